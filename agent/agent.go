@@ -1,11 +1,17 @@
 package agent
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/emitter"
 	"github.com/cloudinsight/cloudinsight-agent/common/log"
 	"github.com/cloudinsight/cloudinsight-agent/common/metric"
 	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
@@ -13,17 +19,34 @@ import (
 
 // Agent runs agent and collects data based on the given config
 type Agent struct {
+	conf *config.Config
+	// pipelines are the main pipeline followed by the ones of the
+	// [[pipeline]] sections, see config.SplitPipelines.
+	pipelines []*pipeline
+
+	mu sync.Mutex
+	// checks holds the status of the Plugins, reported by Checks.
+	checks map[*plugin.RunningPlugin]*CheckStatus
+}
+
+// pipeline runs the Plugins of its configuration, their metrics are flushed
+// by its Collector.
+type pipeline struct {
 	conf      *config.Config
 	collector *Collector
 }
 
 // NewAgent returns an Agent struct based off the given Config
 func NewAgent(conf *config.Config) *Agent {
-	collector := NewCollector(conf)
-
 	a := &Agent{
-		conf:      conf,
-		collector: collector,
+		conf:   conf,
+		checks: make(map[*plugin.RunningPlugin]*CheckStatus, len(conf.Plugins)),
+	}
+	for _, pc := range conf.SplitPipelines() {
+		a.pipelines = append(a.pipelines, &pipeline{conf: pc, collector: NewCollector(pc)})
+		for _, p := range pc.Plugins {
+			a.checks[p] = &CheckStatus{Name: p.Name, Instances: len(p.Config.Instances), Pipeline: pc.PipelineName()}
+		}
 	}
 
 	return a
@@ -42,19 +65,36 @@ func panicRecover(plugin *plugin.RunningPlugin) {
 // reporting interval.
 func (a *Agent) collect(
 	shutdown chan struct{},
+	conf *config.Config,
 	plugin *plugin.RunningPlugin,
 	interval time.Duration,
 	metricC chan metric.Metric,
+	eventC chan metric.Event,
+	serviceCheckC chan metric.ServiceCheck,
 ) error {
 	defer panicRecover(plugin)
 
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	agg := NewAggregator(metricC, a.conf)
+	filter, err := conf.NewFilter(plugin.Config.Filter)
+	if err != nil {
+		return err
+	}
+	agg := &countingAggregator{
+		Aggregator: NewAggregator(metricC, eventC, serviceCheckC, conf, filter),
+	}
+
+	loggers, closeLogs, err := instanceLoggers(plugin)
+	if err != nil {
+		return err
+	}
+	defer closeLogs()
 
 	for {
-		collectWithTimeout(shutdown, plugin, agg, interval)
+		start := time.Now()
+		err := collectWithTimeout(shutdown, plugin, agg, interval, loggers)
+		a.record(plugin, start, err, agg)
 
 		select {
 		case <-shutdown:
@@ -65,6 +105,44 @@ func (a *Agent) collect(
 	}
 }
 
+// instanceLoggers returns the loggers of the instances of the Plugin, nil for
+// the ones without log_level and log_file options, and a function closing
+// their files.
+func instanceLoggers(plugin *plugin.RunningPlugin) ([]log.Logger, func(), error) {
+	loggers := make([]log.Logger, len(plugin.Config.Instances))
+	var files []*os.File
+	closeLogs := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	for i, instance := range plugin.Config.Instances {
+		level, file := instance.LogOptions()
+		if level == "" && file == "" {
+			continue
+		}
+
+		var out io.Writer
+		if file != "" {
+			f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				closeLogs()
+				return nil, nil, fmt.Errorf("plugin [%s]: %s", plugin.Name, err)
+			}
+			files = append(files, f)
+			out = f
+		}
+		l, err := log.New(level, out)
+		if err != nil {
+			closeLogs()
+			return nil, nil, fmt.Errorf("plugin [%s]: %s", plugin.Name, err)
+		}
+		loggers[i] = l.With("check", plugin.Name)
+	}
+	return loggers, closeLogs, nil
+}
+
 // collectWithTimeout collects from the given Plugin, with the given timeout.
 //   when the given timeout is reached, and logs an error message
 //   but continues waiting for it to return. This is to avoid leaving behind
@@ -75,15 +153,27 @@ func collectWithTimeout(
 	plugin *plugin.RunningPlugin,
 	agg metric.Aggregator,
 	timeout time.Duration,
-) {
+	loggers []log.Logger,
+) error {
 	ticker := time.NewTicker(timeout)
 	defer ticker.Stop()
-	done := make(chan error)
+	// Buffered, the check may return after the timeout or the shutdown.
+	done := make(chan error, 1)
 	go func() {
-		for _, instance := range plugin.Config.Instances {
-			done <- plugin.Plugin.Check(agg, instance)
+		var errs []string
+		for i, instance := range plugin.Config.Instances {
+			instanceAgg := metric.WithTags(metric.WithLogger(agg, loggers[i]), instance.Tags()...)
+			err := plugin.Plugin.Check(instanceAgg, instance)
+			if err != nil {
+				errs = append(errs, err.Error())
+			}
 			agg.Flush()
 		}
+		if len(errs) > 0 {
+			done <- errors.New(strings.Join(errs, "; "))
+			return
+		}
+		done <- nil
 	}()
 
 	for {
@@ -92,14 +182,14 @@ func collectWithTimeout(
 			if err != nil {
 				log.Infof("ERROR in plugin [%s]: %s", plugin.Name, err)
 			}
-			return
+			return err
 		case <-ticker.C:
 			log.Infof("ERROR: plugin [%s] took longer to collect than "+
 				"collection interval (%s)",
 				plugin.Name, timeout)
 			continue
 		case <-shutdown:
-			return
+			return nil
 		}
 	}
 }
@@ -112,31 +202,161 @@ func (a *Agent) Test() error {
 
 // Run runs the agent daemon, collecting every Interval
 func (a *Agent) Run(shutdown chan struct{}) error {
+	var (
+		wg        sync.WaitGroup
+		closeOnce sync.Once
+	)
+	stop := func() {
+		closeOnce.Do(func() { close(shutdown) })
+	}
+
+	wg.Add(len(a.pipelines))
+	for _, p := range a.pipelines {
+		go func(p *pipeline) {
+			defer wg.Done()
+			a.runPipeline(shutdown, stop, p)
+		}(p)
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// runPipeline runs the Collector and the Plugins of p, stop is called when
+// the Collector fails.
+func (a *Agent) runPipeline(shutdown chan struct{}, stop func(), p *pipeline) {
 	var wg sync.WaitGroup
-	interval := 30 * time.Second
+	interval := p.conf.GetCollectInterval()
 
 	// channel shared between all Plugin threads for collecting metrics
 	metricC := make(chan metric.Metric, 10000)
+	eventC := make(chan metric.Event, emitter.DefaultEventBufferLimit)
+	serviceCheckC := make(chan metric.ServiceCheck, emitter.DefaultServiceCheckBufferLimit)
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := a.collector.Run(shutdown, metricC, interval); err != nil {
+		if err := p.collector.Run(shutdown, metricC, eventC, serviceCheckC, interval); err != nil {
 			log.Infof("Collector routine failed, exiting: %s", err.Error())
-			close(shutdown)
+			stop()
 		}
 	}()
 
-	wg.Add(len(a.conf.Plugins))
-	for _, p := range a.conf.Plugins {
+	wg.Add(len(p.conf.Plugins))
+	for _, rp := range p.conf.Plugins {
 		go func(rp *plugin.RunningPlugin, interval time.Duration) {
 			defer wg.Done()
-			if err := a.collect(shutdown, rp, interval, metricC); err != nil {
+			if err := a.collect(shutdown, p.conf, rp, interval, metricC, eventC, serviceCheckC); err != nil {
 				log.Info(err.Error())
 			}
-		}(p, interval)
+		}(rp, interval)
 	}
 
 	wg.Wait()
+}
+
+// RunOnce runs every Plugin once, within timeout, and flushes what they
+// collected. It returns the errors of the Plugins and of the flushes.
+func (a *Agent) RunOnce(timeout time.Duration) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []string
+	)
+	wg.Add(len(a.pipelines))
+	for _, p := range a.pipelines {
+		go func(p *pipeline) {
+			defer wg.Done()
+			pipelineErrs := a.runPipelineOnce(p, timeout)
+			mu.Lock()
+			errs = append(errs, pipelineErrs...)
+			mu.Unlock()
+		}(p)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
 	return nil
 }
+
+// runPipelineOnce runs the Plugins of p once and flushes what they
+// collected, it returns the errors of the Plugins and of the flush.
+func (a *Agent) runPipelineOnce(p *pipeline, timeout time.Duration) []string {
+	metricC := make(chan metric.Metric, 10000)
+	eventC := make(chan metric.Event, emitter.DefaultEventBufferLimit)
+	serviceCheckC := make(chan metric.ServiceCheck, emitter.DefaultServiceCheckBufferLimit)
+
+	collected := make(chan struct{})
+	flushed := make(chan error, 1)
+	go func() {
+		flushed <- p.collector.RunOnce(collected, metricC, eventC, serviceCheckC)
+	}()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []string
+	)
+	wg.Add(len(p.conf.Plugins))
+	for _, rp := range p.conf.Plugins {
+		go func(rp *plugin.RunningPlugin) {
+			defer wg.Done()
+			if err := a.collectOnce(p.conf, rp, timeout, metricC, eventC, serviceCheckC); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("plugin [%s]: %s", rp.Name, err))
+				mu.Unlock()
+			}
+		}(rp)
+	}
+	wg.Wait()
+	close(collected)
+
+	if err := <-flushed; err != nil {
+		if name := p.conf.PipelineName(); name != "" {
+			errs = append(errs, fmt.Sprintf("flush of pipeline %s: %s", name, err))
+		} else {
+			errs = append(errs, fmt.Sprintf("flush: %s", err))
+		}
+	}
+	return errs
+}
+
+// collectOnce runs the Plugin once, it fails when the Plugin doesn't return
+// within timeout.
+func (a *Agent) collectOnce(
+	conf *config.Config,
+	plugin *plugin.RunningPlugin,
+	timeout time.Duration,
+	metricC chan metric.Metric,
+	eventC chan metric.Event,
+	serviceCheckC chan metric.ServiceCheck,
+) error {
+	filter, err := conf.NewFilter(plugin.Config.Filter)
+	if err != nil {
+		return err
+	}
+	agg := &countingAggregator{
+		Aggregator: NewAggregator(metricC, eventC, serviceCheckC, conf, filter),
+	}
+	loggers, closeLogs, err := instanceLoggers(plugin)
+	if err != nil {
+		return err
+	}
+	defer closeLogs()
+
+	expired := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(expired) })
+	defer timer.Stop()
+
+	start := time.Now()
+	err = collectWithTimeout(expired, plugin, agg, timeout, loggers)
+	select {
+	case <-expired:
+		err = fmt.Errorf("took longer than %s", timeout)
+	default:
+	}
+	a.record(plugin, start, err, agg)
+	return err
+}
@@ -8,9 +8,28 @@ import (
 // NewAggregator XXX
 func NewAggregator(
 	metrics chan metric.Metric,
+	events chan metric.Event,
+	serviceChecks chan metric.ServiceCheck,
 	conf *config.Config,
+	filter *metric.Filter,
 ) metric.Aggregator {
-	return metric.NewAggregator(metrics, 1, conf.GetHostname(), formatter, nil, nil, 0)
+	agg := metric.NewAggregator(
+		metrics,
+		events,
+		serviceChecks,
+		1,
+		conf.GetHostname(),
+		formatter,
+		conf.GlobalConfig.HistogramAggregates,
+		conf.GlobalConfig.HistogramPercentiles,
+		conf.HistogramPrefixes(),
+		conf.GlobalConfig.ContextLimit,
+		filter,
+		conf.Transformer(),
+		int64(conf.GlobalConfig.LateSampleWindow),
+		0,
+	)
+	return metric.WithTags(agg, conf.GlobalConfig.Tags...)
 }
 
 // Format metrics coming from the MetricsAggregator. Will look like:
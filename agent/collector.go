@@ -1,14 +1,15 @@
 package agent
 
 import (
-	"strings"
 	"time"
 
 	"github.com/cloudinsight/cloudinsight-agent/common/api"
+	"github.com/cloudinsight/cloudinsight-agent/common/cloud"
 	"github.com/cloudinsight/cloudinsight-agent/common/config"
 	"github.com/cloudinsight/cloudinsight-agent/common/emitter"
 	"github.com/cloudinsight/cloudinsight-agent/common/gohai"
 	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
 )
 
 const metadataUpdateInterval = 4 * time.Hour
@@ -20,12 +21,16 @@ type Collector struct {
 	api   *api.API
 	conf  *config.Config
 	start time.Time
+	cloud *cloud.Metadata
 }
 
 // NewCollector creates a new instance of Collector.
 func NewCollector(conf *config.Config) *Collector {
 	emitter := emitter.NewEmitter("Collector")
 	api := api.NewAPI(conf.GetForwarderAddrWithScheme(), conf.GlobalConfig.LicenseKey, 10*time.Second)
+	api.CompressionLevel = conf.GlobalConfig.CompressionLevel
+	api.MaxPayloadSize = conf.GlobalConfig.MaxPayloadSize
+	api.Format = conf.GlobalConfig.PayloadFormat
 
 	c := &Collector{
 		Emitter: emitter,
@@ -34,6 +39,14 @@ func NewCollector(conf *config.Config) *Collector {
 		start:   time.Now(),
 	}
 	c.Emitter.Parent = c
+	c.Emitter.FlushJitter = conf.GetFlushJitter()
+
+	outputs, err := conf.NewOutputs()
+	if err != nil {
+		log.Errorf("Failed to create the outputs: %s", err)
+	}
+	c.Emitter.Outputs = outputs
+	c.Emitter.DisablePost = !conf.GlobalConfig.CloudinsightOutput
 
 	return c
 }
@@ -48,15 +61,18 @@ func (c *Collector) Post(metrics []interface{}) error {
 		log.Debug("We should send metadata.")
 
 		payload.Gohai = gohai.GetMetadata()
-		if c.conf.GlobalConfig.Tags != "" {
-			hostTags := strings.Split(c.conf.GlobalConfig.Tags, ",")
-			for i, tag := range hostTags {
-				hostTags[i] = strings.TrimSpace(tag)
-			}
-
-			payload.HostTags = map[string]interface{}{
-				"system": hostTags,
-			}
+		payload.HostTags = make(map[string]interface{})
+		if len(c.conf.GlobalConfig.Tags) > 0 {
+			payload.HostTags["system"] = []string(c.conf.GlobalConfig.Tags)
+		}
+
+		if c.conf.GlobalConfig.CloudMetadata {
+			// Refreshed along with the metadata, the instance type may change.
+			c.cloud = cloud.Detect(cloud.DefaultTimeout)
+		}
+		if c.cloud != nil {
+			payload.Gohai["cloud"] = c.cloud
+			payload.HostTags[c.cloud.Provider] = c.cloud.Tags()
 		}
 	}
 
@@ -73,7 +89,13 @@ func (c *Collector) Post(metrics []interface{}) error {
 		"host":       c.conf.GetHostname(),
 	}
 
-	err := c.api.SubmitMetrics(payload)
+	var err error
+	if payload.Gohai != nil {
+		// The Forwarder posts the host metadata first when it's late.
+		err = c.api.SubmitMetadata(payload)
+	} else {
+		err = c.api.SubmitMetrics(payload)
+	}
 	elapsed := time.Since(start)
 	if err == nil {
 		log.Debugf("Post batch of %d metrics in %s",
@@ -82,6 +104,27 @@ func (c *Collector) Post(metrics []interface{}) error {
 	return err
 }
 
+// PostEvents sends the events to Forwarder API.
+func (c *Collector) PostEvents(events []metric.Event) error {
+	payload := NewPayload(c.conf)
+	payload.Events = metric.FormatEvents(events)
+
+	err := c.api.SubmitMetrics(payload)
+	if err == nil {
+		log.Debugf("Post %d events", len(events))
+	}
+	return err
+}
+
+// PostServiceChecks sends the service checks to Forwarder API.
+func (c *Collector) PostServiceChecks(serviceChecks []metric.ServiceCheck) error {
+	err := c.api.SubmitServiceChecks(serviceChecks)
+	if err == nil {
+		log.Debugf("Post %d service checks", len(serviceChecks))
+	}
+	return err
+}
+
 // We send metadata every 4 hours, which contains Gohai, HostTags and so on.
 func (c *Collector) shouldSendMetadata() bool {
 	if c.IsFirstRun() {
@@ -0,0 +1,142 @@
+package agent
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/watchdog"
+)
+
+// CheckStatus is the state of a check, reported by the control API.
+type CheckStatus struct {
+	Name      string `json:"name"`
+	Instances int    `json:"instances"`
+	// Pipeline is the [[pipeline]] running the check, empty for the main
+	// one.
+	Pipeline string `json:"pipeline,omitempty"`
+	// Runs and Errors count the runs of the check, and the failed ones.
+	Runs   int64 `json:"runs"`
+	Errors int64 `json:"errors"`
+	// LastRun is the start of the last run, nil until the check ran.
+	LastRun *time.Time `json:"last_run,omitempty"`
+	// LastDuration is the duration of the last run, in seconds.
+	LastDuration float64 `json:"last_duration"`
+	LastError    string  `json:"last_error,omitempty"`
+	// LastMetrics, LastEvents and LastServiceChecks count what the last
+	// run submitted.
+	LastMetrics       int64 `json:"last_metrics"`
+	LastEvents        int64 `json:"last_events"`
+	LastServiceChecks int64 `json:"last_service_checks"`
+}
+
+// record updates the status of the Plugin with a run started at start,
+// counted by counter.
+func (a *Agent) record(p *plugin.RunningPlugin, start time.Time, err error, counter *countingAggregator) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	status, ok := a.checks[p]
+	if !ok {
+		return
+	}
+	status.Runs++
+	status.LastRun = &start
+	status.LastDuration = time.Since(start).Seconds()
+	status.LastError = ""
+	status.LastMetrics, status.LastEvents, status.LastServiceChecks = counter.reset()
+	if err != nil {
+		status.Errors++
+		status.LastError = err.Error()
+	}
+}
+
+// Checks returns the status of the checks, in the order of the
+// configuration.
+func (a *Agent) Checks() []CheckStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	checks := make([]CheckStatus, 0, len(a.conf.Plugins))
+	for _, p := range a.conf.Plugins {
+		if status, ok := a.checks[p]; ok {
+			checks = append(checks, *status)
+		}
+	}
+	return checks
+}
+
+// LastFlush returns the time of the last successful flush of the Collector
+// of the main pipeline, the zero time until the first one.
+func (a *Agent) LastFlush() time.Time {
+	return a.pipelines[0].collector.LastFlush()
+}
+
+// countingAggregator counts the samples, events and service checks added by
+// a check, a check may still add them after its run timed out.
+type countingAggregator struct {
+	metric.Aggregator
+
+	metrics       int64
+	events        int64
+	serviceChecks int64
+}
+
+func (c *countingAggregator) AddMetrics(
+	metricType string,
+	prefix string,
+	fields map[string]interface{},
+	tags []string,
+	deviceName string,
+	ts ...int64,
+) {
+	atomic.AddInt64(&c.metrics, int64(len(fields)))
+	c.Aggregator.AddMetrics(metricType, prefix, fields, tags, deviceName, ts...)
+}
+
+func (c *countingAggregator) SubmitPackets(packet string, extraTags ...string) {
+	for _, line := range strings.Split(packet, "\n") {
+		if line != "" {
+			atomic.AddInt64(&c.metrics, 1)
+		}
+	}
+	c.Aggregator.SubmitPackets(packet, extraTags...)
+}
+
+func (c *countingAggregator) Add(metricType string, m metric.Metric) {
+	atomic.AddInt64(&c.metrics, 1)
+	c.Aggregator.Add(metricType, m)
+}
+
+func (c *countingAggregator) AddEvent(e metric.Event) {
+	atomic.AddInt64(&c.events, 1)
+	c.Aggregator.AddEvent(e)
+}
+
+func (c *countingAggregator) AddServiceCheck(sc metric.ServiceCheck) {
+	atomic.AddInt64(&c.serviceChecks, 1)
+	c.Aggregator.AddServiceCheck(sc)
+}
+
+// reset returns the counts and sets them to 0.
+func (c *countingAggregator) reset() (metrics, events, serviceChecks int64) {
+	return atomic.SwapInt64(&c.metrics, 0), atomic.SwapInt64(&c.events, 0), atomic.SwapInt64(&c.serviceChecks, 0)
+}
+
+// Heartbeat returns the heartbeat of the loop of the Collector flushing the
+// metrics of the checks of the main pipeline.
+func (a *Agent) Heartbeat() *watchdog.Heartbeat {
+	return a.pipelines[0].collector.Heartbeat()
+}
+
+// PipelineHeartbeats returns the heartbeats of the Collectors of the
+// [[pipeline]] sections, by name.
+func (a *Agent) PipelineHeartbeats() map[string]*watchdog.Heartbeat {
+	heartbeats := make(map[string]*watchdog.Heartbeat, len(a.pipelines)-1)
+	for _, p := range a.pipelines[1:] {
+		heartbeats[p.conf.PipelineName()] = p.collector.Heartbeat()
+	}
+	return heartbeats
+}
@@ -0,0 +1,175 @@
+package aerospike
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// DefaultTimeout is the default timeout in seconds of the info requests.
+const DefaultTimeout = 10
+
+// NewAerospike XXX
+func NewAerospike(conf plugin.InitConfig) plugin.Plugin {
+	return &Aerospike{}
+}
+
+// Aerospike collects the node and namespace statistics and the latencies of
+// an Aerospike node with the info protocol.
+type Aerospike struct{}
+
+type instanceConfig struct {
+	Host    string `yaml:"host"`
+	Port    int    `yaml:"port"`
+	Timeout int    `yaml:"timeout"`
+	// Namespaces restricts the namespaces collected, all of them by default.
+	Namespaces []string `yaml:"namespaces"`
+	Tags       []string `yaml:"tags"`
+}
+
+// counterSuffixes are the suffixes of the transaction counters of the
+// namespaces, e.g. client_read_success, which are submitted as rates.
+var counterSuffixes = []string{
+	"_success",
+	"_error",
+	"_timeout",
+	"_not_found",
+	"_filtered_out",
+}
+
+// Check XXX
+func (a *Aerospike) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.Host == "" {
+		conf.Host = "localhost"
+	}
+	if conf.Port <= 0 {
+		conf.Port = 3000
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = DefaultTimeout
+	}
+	addr := net.JoinHostPort(conf.Host, strconv.Itoa(conf.Port))
+	timeout := time.Duration(conf.Timeout) * time.Second
+
+	values, err := info(addr, timeout, "statistics", "namespaces", "latencies:")
+	if err != nil {
+		return fmt.Errorf("could not get the statistics of %s: %s", addr, err)
+	}
+
+	for key, value := range parseStats(values["statistics"]) {
+		agg.Add("gauge", metric.NewMetric("aerospike."+key, value, conf.Tags))
+	}
+
+	namespaces := strings.Split(values["namespaces"], ";")
+	if len(conf.Namespaces) > 0 {
+		namespaces = conf.Namespaces
+	}
+	var commands []string
+	for _, ns := range namespaces {
+		if ns != "" {
+			commands = append(commands, "namespace/"+ns)
+		}
+	}
+	if len(commands) > 0 {
+		nsValues, err := info(addr, timeout, commands...)
+		if err != nil {
+			return fmt.Errorf("could not get the namespace statistics of %s: %s", addr, err)
+		}
+		for _, command := range commands {
+			ns := strings.TrimPrefix(command, "namespace/")
+			tags := append(append([]string{}, conf.Tags...), "namespace:"+ns)
+			for key, value := range parseStats(nsValues[command]) {
+				agg.Add(namespaceMetricType(key), metric.NewMetric("aerospike.namespace."+key, value, tags))
+			}
+		}
+	}
+
+	submitLatencies(agg, values["latencies:"], conf.Tags)
+
+	return nil
+}
+
+// parseStats parses the "key=value;key=value" lists of the statistics, the
+// booleans are reported as 0 or 1 and the strings are skipped.
+func parseStats(s string) map[string]float64 {
+	stats := make(map[string]float64)
+	for _, pair := range strings.Split(s, ";") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[1] {
+		case "true":
+			stats[parts[0]] = 1
+		case "false":
+			stats[parts[0]] = 0
+		default:
+			if value, err := strconv.ParseFloat(parts[1], 64); err == nil {
+				stats[parts[0]] = value
+			}
+		}
+	}
+	return stats
+}
+
+func namespaceMetricType(key string) string {
+	for _, suffix := range counterSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return "rate"
+		}
+	}
+	return "gauge"
+}
+
+// submitLatencies parses the latency histograms of latencies: (Aerospike
+// 5.1+), which look like:
+//
+//	{test}-read:msec,1.9,4.21,1.05,0.00;{test}-write:msec,0.0,0.00,0.00,0.00;batch-index:
+//
+// the first value is the throughput in ops/sec and the next ones are the %
+// of the operations above 1, 8, 64... ms.
+func submitLatencies(agg metric.Aggregator, s string, tags []string) {
+	for _, histogram := range strings.Split(s, ";") {
+		parts := strings.SplitN(histogram, ":", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "{") {
+			continue
+		}
+		end := strings.Index(parts[0], "}-")
+		if end < 0 {
+			continue
+		}
+		ns, op := parts[0][1:end], parts[0][end+2:]
+		values := strings.Split(parts[1], ",")
+		if len(values) < 2 || values[0] != "msec" {
+			continue
+		}
+
+		nsTags := append(append([]string{}, tags...), "namespace:"+ns)
+		prefix := "aerospike.namespace.latency." + op
+		for i, v := range values[1:] {
+			value, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			name := prefix + "_ops_sec"
+			if i > 0 {
+				name = fmt.Sprintf("%s_over_%dms", prefix, 1<<uint(3*(i-1)))
+			}
+			agg.Add("gauge", metric.NewMetric(name, value, nsTags))
+		}
+	}
+}
+
+func init() {
+	collector.Add("aerospike", NewAerospike)
+}
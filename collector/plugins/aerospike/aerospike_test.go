@@ -0,0 +1,98 @@
+package aerospike
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var responses = map[string]string{
+	"statistics":     "cluster_size=3;cluster_integrity=true;client_connections=12;cluster_key=8E2D93D1C5A1",
+	"namespaces":     "test;bar",
+	"latencies:":     "batch-index:;{test}-read:msec,1.9,4.21,1.05,0.00;{test}-write:msec,0.5,0.00,0.00,0.00",
+	"namespace/test": "objects=1200;memory_used_bytes=524288;device_used_bytes=1048576;client_read_success=9001;stop_writes=false",
+	"namespace/bar":  "objects=3",
+}
+
+// serve answers the info requests of the connections accepted by l.
+func serve(t *testing.T, l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		var h [8]byte
+		if _, err = io.ReadFull(conn, h[:]); err != nil {
+			conn.Close()
+			continue
+		}
+		body := make([]byte, binary.BigEndian.Uint64(h[:])&(1<<48-1))
+		io.ReadFull(conn, body)
+
+		var response string
+		for _, command := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+			response += command + "\t" + responses[command] + "\n"
+		}
+		conn.Write(append(header(len(response)), response...))
+		conn.Close()
+	}
+}
+
+func TestCheck(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go serve(t, l)
+
+	addr := l.Addr().(*net.TCPAddr)
+	a := NewAerospike(nil)
+	agg := &metric.MockAggregator{}
+	require.NoError(t, a.Check(agg, plugin.Instance{
+		"host": "127.0.0.1",
+		"port": addr.Port,
+	}))
+
+	for _, c := range []struct {
+		name       string
+		tags       []string
+		metricType string
+		value      float64
+	}{
+		{"aerospike.cluster_size", nil, "gauge", 3},
+		{"aerospike.cluster_integrity", nil, "gauge", 1},
+		{"aerospike.namespace.objects", []string{"namespace:test"}, "gauge", 1200},
+		{"aerospike.namespace.objects", []string{"namespace:bar"}, "gauge", 3},
+		{"aerospike.namespace.memory_used_bytes", []string{"namespace:test"}, "gauge", 524288},
+		{"aerospike.namespace.client_read_success", []string{"namespace:test"}, "rate", 9001},
+		{"aerospike.namespace.stop_writes", []string{"namespace:test"}, "gauge", 0},
+		{"aerospike.namespace.latency.read_ops_sec", []string{"namespace:test"}, "gauge", 1.9},
+		{"aerospike.namespace.latency.read_over_1ms", []string{"namespace:test"}, "gauge", 4.21},
+		{"aerospike.namespace.latency.read_over_8ms", []string{"namespace:test"}, "gauge", 1.05},
+		{"aerospike.namespace.latency.write_over_64ms", []string{"namespace:test"}, "gauge", 0},
+	} {
+		m, ok := agg.Get(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			value, _ := agg.Value(c.name, c.tags...)
+			assert.Equal(t, c.value, value, c.name)
+			assert.Equal(t, c.metricType, m.Type, c.name)
+		}
+	}
+	assert.NotContains(t, agg.Names(), "aerospike.cluster_key")
+}
+
+func TestCheckUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	a := NewAerospike(nil)
+	assert.Error(t, a.Check(&metric.MockAggregator{}, plugin.Instance{"port": port}))
+}
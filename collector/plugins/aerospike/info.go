@@ -0,0 +1,70 @@
+package aerospike
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	protoVersion = 2
+	protoInfo    = 1
+	// maxInfoSize protects us from reading garbage when something else than
+	// Aerospike listens on the port.
+	maxInfoSize = 16 << 20
+)
+
+// info sends the info commands (e.g. statistics or namespace/test) to the
+// node and returns their values keyed by command. Every message starts with
+// a 8 bytes header made of the protocol version, the message type and the
+// size of the body on 6 bytes, a request body lists the commands separated
+// by newlines and the response one lists "command\tvalue" lines.
+func info(addr string, timeout time.Duration, commands ...string) (map[string]string, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	body := strings.Join(commands, "\n") + "\n"
+	if _, err = conn.Write(append(header(len(body)), body...)); err != nil {
+		return nil, err
+	}
+
+	var h [8]byte
+	if _, err = io.ReadFull(conn, h[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint64(h[:]) & (1<<48 - 1)
+	if h[0] != protoVersion || h[1] != protoInfo || size > maxInfoSize {
+		return nil, fmt.Errorf("unexpected info response header %x", h)
+	}
+	response := make([]byte, size)
+	if _, err = io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for _, line := range strings.Split(string(response), "\n") {
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) == 2 {
+			values[parts[0]] = parts[1]
+		}
+	}
+	return values, nil
+}
+
+func header(size int) []byte {
+	h := make([]byte, 8)
+	binary.BigEndian.PutUint64(h, uint64(size))
+	h[0], h[1] = protoVersion, protoInfo
+	return h
+}
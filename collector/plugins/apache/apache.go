@@ -0,0 +1,146 @@
+package apache
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewApache XXX
+func NewApache(conf plugin.InitConfig) plugin.Plugin {
+	return &Apache{}
+}
+
+// Apache collects the metrics exposed by mod_status in the machine readable
+// format (server-status?auto).
+type Apache struct{}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	URL  string   `yaml:"apache_status_url"`
+	Tags []string `yaml:"tags"`
+}
+
+var gauges = map[string]string{
+	"IdleWorkers":         "apache.performance.idle_workers",
+	"BusyWorkers":         "apache.performance.busy_workers",
+	"CPULoad":             "apache.performance.cpu_load",
+	"Uptime":              "apache.performance.uptime",
+	"Total kBytes":        "apache.net.bytes",
+	"Total Accesses":      "apache.net.hits",
+	"ConnsTotal":          "apache.conns_total",
+	"ConnsAsyncWriting":   "apache.conns_async_writing",
+	"ConnsAsyncKeepAlive": "apache.conns_async_keep_alive",
+	"ConnsAsyncClosing":   "apache.conns_async_closing",
+}
+
+var rates = map[string]string{
+	"Total kBytes":   "apache.net.bytes_per_s",
+	"Total Accesses": "apache.net.request_per_s",
+}
+
+// scoreboardStates maps the scoreboard keys to the worker states.
+var scoreboardStates = map[rune]string{
+	'_': "waiting_for_connection",
+	'S': "starting_up",
+	'R': "reading_request",
+	'W': "sending_reply",
+	'K': "keepalive",
+	'D': "dns_lookup",
+	'C': "closing_connection",
+	'L': "logging",
+	'G': "gracefully_finishing",
+	'I': "idle_cleanup",
+	'.': "open_slot",
+}
+
+// Check XXX
+func (a *Apache) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.URL == "" {
+		return fmt.Errorf("a configured apache_status_url is required")
+	}
+	if !strings.Contains(conf.URL, "?auto") {
+		// Make sure we get the machine readable format.
+		if strings.Contains(conf.URL, "?") {
+			conf.URL += "&auto"
+		} else {
+			conf.URL += "?auto"
+		}
+	}
+
+	resp, err := conf.Get(conf.URL)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	status := make(map[string]string)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		status[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	return submit(agg, status, conf.Tags)
+}
+
+func submit(agg metric.Aggregator, status map[string]string, tags []string) error {
+	if _, ok := status["BusyWorkers"]; !ok {
+		return fmt.Errorf("no metrics were fetched for this instance, make sure mod_status is enabled and apache_status_url is correct")
+	}
+
+	for key, raw := range status {
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			continue
+		}
+		// Report bytes rather than kilobytes.
+		if key == "Total kBytes" {
+			value *= 1024
+		}
+
+		if name, ok := gauges[key]; ok {
+			agg.Add("gauge", metric.NewMetric(name, value, tags))
+		}
+		if name, ok := rates[key]; ok {
+			agg.Add("rate", metric.NewMetric(name, value, tags))
+		}
+	}
+
+	if scoreboard, ok := status["Scoreboard"]; ok {
+		counts := make(map[string]interface{})
+		for _, state := range scoreboardStates {
+			counts[state] = 0
+		}
+		for _, key := range scoreboard {
+			if state, ok := scoreboardStates[key]; ok {
+				counts[state] = counts[state].(int) + 1
+			}
+		}
+		agg.AddMetrics("gauge", "apache.scoreboard", counts, tags, "")
+	}
+
+	return nil
+}
+
+func init() {
+	collector.Add("apache", NewApache)
+}
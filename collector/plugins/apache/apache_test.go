@@ -0,0 +1,95 @@
+package apache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const preforkStatus = `Total Accesses: 1500
+Total kBytes: 2
+CPULoad: .0123
+Uptime: 3600
+ReqPerSec: .416
+BytesPerSec: .568
+BytesPerReq: 1.36
+BusyWorkers: 2
+IdleWorkers: 8
+Scoreboard: _W_K_____.C....
+`
+
+const eventStatus = `localhost
+ServerVersion: Apache/2.4.18 (Ubuntu)
+ServerMPM: event
+Total Accesses: 10
+Total kBytes: 8
+Uptime: 60
+BusyWorkers: 1
+IdleWorkers: 49
+ConnsTotal: 3
+ConnsAsyncWriting: 0
+ConnsAsyncKeepAlive: 2
+ConnsAsyncClosing: 1
+Scoreboard: _W_
+`
+
+func TestCheck(t *testing.T) {
+	for _, c := range []struct {
+		status string
+		want   map[string]float64
+	}{
+		{preforkStatus, map[string]float64{
+			"apache.performance.busy_workers":          2,
+			"apache.performance.idle_workers":          8,
+			"apache.performance.cpu_load":              0.0123,
+			"apache.net.bytes":                         2048,
+			"apache.net.request_per_s":                 1500,
+			"apache.scoreboard.waiting_for_connection": 7,
+			"apache.scoreboard.sending_reply":          1,
+			"apache.scoreboard.keepalive":              1,
+			"apache.scoreboard.closing_connection":     1,
+			"apache.scoreboard.open_slot":              5,
+			"apache.scoreboard.logging":                0,
+		}},
+		{eventStatus, map[string]float64{
+			"apache.performance.busy_workers": 1,
+			"apache.conns_total":              3,
+			"apache.conns_async_keep_alive":   2,
+			"apache.conns_async_closing":      1,
+			"apache.net.bytes_per_s":          8192,
+			"apache.scoreboard.sending_reply": 1,
+		}},
+	} {
+		status := c.status
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "auto", r.URL.RawQuery)
+			fmt.Fprint(w, status)
+		}))
+
+		agg := &metric.MockAggregator{}
+		err := NewApache(nil).Check(agg, plugin.Instance{
+			"apache_status_url": server.URL + "/server-status",
+			"tags":              []interface{}{"env:test"},
+		})
+		require.NoError(t, err)
+
+		for name, want := range c.want {
+			value, ok := agg.Value(name, "env:test")
+			if assert.True(t, ok, "metric %s not found", name) {
+				assert.Equal(t, want, value, name)
+			}
+		}
+		server.Close()
+	}
+}
+
+func TestWithoutExtendedStatus(t *testing.T) {
+	err := submit(&metric.MockAggregator{}, map[string]string{"Total Accesses": "1"}, nil)
+	assert.Error(t, err)
+}
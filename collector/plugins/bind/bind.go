@@ -0,0 +1,239 @@
+package bind
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewBind XXX
+func NewBind(conf plugin.InitConfig) plugin.Plugin {
+	return &Bind{
+		cache: make(map[string][2]float64),
+	}
+}
+
+// Bind collects the server and resolver statistics of BIND 9 from its
+// statistics channel, in its JSON or XML (v3) flavour.
+type Bind struct {
+	sync.Mutex
+
+	// cache keeps the last cache hits and misses of every view, the hit
+	// ratio is computed over the check interval.
+	cache map[string][2]float64
+}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	// URL is the JSON (e.g. http://localhost:8053/json/v1) or the XML
+	// (e.g. http://localhost:8053/xml/v3) statistics of the server.
+	URL  string   `yaml:"url"`
+	Tags []string `yaml:"tags"`
+}
+
+// statistics gathers the counters of both flavours, keyed by the counter
+// type, e.g. rcode or nsstat.
+type statistics struct {
+	server map[string]map[string]float64
+	// views are keyed by view name, then by counter type (resstats or
+	// cachestats).
+	views map[string]map[string]map[string]float64
+}
+
+// serverCounters names the server counter types of the JSON and XML
+// documents.
+var serverCounters = map[string]string{
+	"opcodes": "opcode",
+	"rcodes":  "rcode",
+	"qtypes":  "qtype",
+	"nsstats": "nsstat",
+	"opcode":  "opcode",
+	"rcode":   "rcode",
+	"qtype":   "qtype",
+	"nsstat":  "nsstat",
+}
+
+// Check XXX
+func (b *Bind) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.URL == "" {
+		conf.URL = "http://localhost:8053/json/v1"
+	}
+	conf.URL = strings.TrimSuffix(conf.URL, "/")
+
+	body, err := fetch(&conf, conf.URL+"/server")
+	if err != nil {
+		return err
+	}
+
+	var stats *statistics
+	if strings.Contains(conf.URL, "/xml") {
+		stats, err = parseXML(body)
+	} else {
+		stats, err = parseJSON(body)
+	}
+	if err != nil {
+		return fmt.Errorf("could not parse the statistics of %s: %s", conf.URL, err)
+	}
+
+	for counterType, counters := range stats.server {
+		for name, value := range counters {
+			metricName := fmt.Sprintf("bind.%s.%s", counterType, strings.ToLower(name))
+			agg.Add("rate", metric.NewMetric(metricName, value, conf.Tags))
+		}
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	for view, counters := range stats.views {
+		tags := append(append([]string{}, conf.Tags...), "view:"+view)
+		for name, value := range counters["resstats"] {
+			agg.Add("rate", metric.NewMetric("bind.resolver."+strings.ToLower(name), value, tags))
+		}
+		for name, value := range counters["cachestats"] {
+			agg.Add("rate", metric.NewMetric("bind.cache."+strings.ToLower(name), value, tags))
+		}
+		b.submitHitRatio(agg, conf.URL+view, counters["cachestats"], tags)
+	}
+
+	return nil
+}
+
+func (b *Bind) submitHitRatio(agg metric.Aggregator, key string, cachestats map[string]float64, tags []string) {
+	hits, ok := cachestats["QueryHits"]
+	if !ok {
+		return
+	}
+	misses := cachestats["QueryMisses"]
+
+	prev, ok := b.cache[key]
+	b.cache[key] = [2]float64{hits, misses}
+	if !ok {
+		return
+	}
+	dh, dm := hits-prev[0], misses-prev[1]
+	if dh < 0 || dm < 0 || dh+dm == 0 {
+		return
+	}
+	agg.Add("gauge", metric.NewMetric("bind.cache.hit_ratio", dh/(dh+dm)*100, tags))
+}
+
+func fetch(conf *instanceConfig, url string) ([]byte, error) {
+	resp, err := conf.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func parseJSON(body []byte) (*statistics, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+
+	stats := &statistics{
+		server: make(map[string]map[string]float64),
+		views:  make(map[string]map[string]map[string]float64),
+	}
+	for key, raw := range doc {
+		counterType, ok := serverCounters[key]
+		if !ok {
+			continue
+		}
+		var counters map[string]float64
+		if err := json.Unmarshal(raw, &counters); err != nil {
+			return nil, fmt.Errorf("unexpected %s: %s", key, err)
+		}
+		stats.server[counterType] = counters
+	}
+
+	var views map[string]struct {
+		Resolver struct {
+			Stats      map[string]float64 `json:"stats"`
+			CacheStats map[string]float64 `json:"cachestats"`
+		} `json:"resolver"`
+	}
+	if raw, ok := doc["views"]; ok {
+		if err := json.Unmarshal(raw, &views); err != nil {
+			return nil, fmt.Errorf("unexpected views: %s", err)
+		}
+	}
+	for name, view := range views {
+		stats.views[name] = map[string]map[string]float64{
+			"resstats":   view.Resolver.Stats,
+			"cachestats": view.Resolver.CacheStats,
+		}
+	}
+
+	return stats, nil
+}
+
+type xmlCounters struct {
+	Type     string `xml:"type,attr"`
+	Counters []struct {
+		Name  string  `xml:"name,attr"`
+		Value float64 `xml:",chardata"`
+	} `xml:"counter"`
+}
+
+type xmlStatistics struct {
+	Server struct {
+		Counters []xmlCounters `xml:"counters"`
+	} `xml:"server"`
+	Views []struct {
+		Name     string        `xml:"name,attr"`
+		Counters []xmlCounters `xml:"counters"`
+	} `xml:"views>view"`
+}
+
+func parseXML(body []byte) (*statistics, error) {
+	var doc xmlStatistics
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+
+	stats := &statistics{
+		server: make(map[string]map[string]float64),
+		views:  make(map[string]map[string]map[string]float64),
+	}
+	for _, c := range doc.Server.Counters {
+		if counterType, ok := serverCounters[c.Type]; ok {
+			stats.server[counterType] = c.toMap()
+		}
+	}
+	for _, view := range doc.Views {
+		stats.views[view.Name] = make(map[string]map[string]float64)
+		for _, c := range view.Counters {
+			stats.views[view.Name][c.Type] = c.toMap()
+		}
+	}
+
+	return stats, nil
+}
+
+func (c *xmlCounters) toMap() map[string]float64 {
+	m := make(map[string]float64)
+	for _, counter := range c.Counters {
+		m[counter.Name] = counter.Value
+	}
+	return m
+}
+
+func init() {
+	collector.Add("bind", NewBind)
+}
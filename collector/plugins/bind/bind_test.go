@@ -0,0 +1,70 @@
+package bind
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	hits := "300"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var file string
+		switch r.URL.Path {
+		case "/json/v1/server":
+			file = "testdata/server.json"
+		case "/xml/v3/server":
+			file = "testdata/server.xml"
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		content, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		w.Write([]byte(strings.Replace(string(content), "300", hits, 1)))
+	}))
+	defer server.Close()
+
+	for _, path := range []string{"/json/v1", "/xml/v3"} {
+		hits = "300"
+		b := NewBind(nil)
+		instance := plugin.Instance{"url": server.URL + path}
+		agg := &metric.MockAggregator{}
+		require.NoError(t, b.Check(agg, instance), path)
+
+		for _, c := range []struct {
+			name  string
+			tags  []string
+			value float64
+		}{
+			{"bind.opcode.query", nil, 1000},
+			{"bind.rcode.servfail", nil, 12},
+			{"bind.nsstat.qrysuccess", nil, 880},
+			{"bind.resolver.retry", []string{"view:_default"}, 3},
+			{"bind.cache.queryhits", []string{"view:_default"}, 300},
+		} {
+			m, ok := agg.Get(c.name, c.tags...)
+			if assert.True(t, ok, "%s: metric %s not found", path, c.name) {
+				value, _ := agg.Value(c.name, c.tags...)
+				assert.Equal(t, c.value, value, c.name)
+				assert.Equal(t, "rate", m.Type)
+			}
+		}
+		assert.NotContains(t, agg.Names(), "bind.cache.hit_ratio")
+
+		// 60 hits and no miss since the last check.
+		hits = "360"
+		agg = &metric.MockAggregator{}
+		require.NoError(t, b.Check(agg, instance), path)
+		value, ok := agg.Value("bind.cache.hit_ratio", "view:_default")
+		assert.True(t, ok, path)
+		assert.Equal(t, float64(100), value, path)
+	}
+}
@@ -0,0 +1,187 @@
+package cassandranodetool
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// MiB is the unit of the heap reported by nodetool info.
+const MiB = 1024 * 1024
+
+// runCommand is replaced in tests.
+var runCommand = util.RunCommand
+
+// NewCassandraNodetool XXX
+func NewCassandraNodetool(conf plugin.InitConfig) plugin.Plugin {
+	return &CassandraNodetool{}
+}
+
+// CassandraNodetool collects the pending compactions, the client request
+// latencies, the heap and the status of the ring of a Cassandra node with
+// nodetool, for the environments where the JMX metrics of the cassandra
+// check aren't reachable through Jolokia.
+type CassandraNodetool struct{}
+
+type instanceConfig struct {
+	Nodetool string `yaml:"nodetool"`
+	// Host, Port, Username and Password are passed to nodetool, which
+	// talks to the JMX port of the node.
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	Timeout  int      `yaml:"timeout"`
+	Tags     []string `yaml:"tags"`
+}
+
+var (
+	pendingRe = regexp.MustCompile(`(?m)^pending tasks:\s*(\d+)`)
+	heapRe    = regexp.MustCompile(`(?m)^Heap Memory \(MB\)\s*:\s*([\d.]+)\s*/\s*([\d.]+)`)
+	// nodeRe matches the nodes of nodetool status, e.g.
+	// UN  10.0.0.1  1.2 GiB  256  ?  6a1e6a4e-...  rack1
+	nodeRe = regexp.MustCompile(`^([UD])([NLJM])\s+(\S+)\s`)
+)
+
+// Check XXX
+func (c *CassandraNodetool) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.Nodetool == "" {
+		conf.Nodetool = "nodetool"
+	}
+	if conf.Host == "" {
+		conf.Host = "localhost"
+	}
+	tags := append(append([]string{}, conf.Tags...), "cassandra_host:"+conf.Host)
+
+	out, err := conf.nodetool("compactionstats")
+	if err != nil {
+		return err
+	}
+	m := pendingRe.FindSubmatch(out)
+	if m == nil {
+		return fmt.Errorf("unexpected nodetool compactionstats output: %q", out)
+	}
+	pending, _ := strconv.ParseFloat(string(m[1]), 64)
+	agg.Add("gauge", metric.NewMetric("cassandra.nodetool.compactions.pending", pending, tags))
+
+	if out, err = conf.nodetool("info"); err != nil {
+		return err
+	}
+	if m = heapRe.FindSubmatch(out); m != nil {
+		used, _ := strconv.ParseFloat(string(m[1]), 64)
+		max, _ := strconv.ParseFloat(string(m[2]), 64)
+		agg.Add("gauge", metric.NewMetric("cassandra.nodetool.heap.used", used*MiB, tags))
+		agg.Add("gauge", metric.NewMetric("cassandra.nodetool.heap.max", max*MiB, tags))
+	}
+
+	if out, err = conf.nodetool("proxyhistograms"); err != nil {
+		return err
+	}
+	if err = submitLatencies(agg, out, tags); err != nil {
+		return err
+	}
+
+	if out, err = conf.nodetool("status"); err != nil {
+		return err
+	}
+	submitStatus(agg, out, tags)
+	return nil
+}
+
+func (conf *instanceConfig) nodetool(command string) ([]byte, error) {
+	args := []string{"-h", conf.Host}
+	if conf.Port > 0 {
+		args = append(args, "-p", strconv.Itoa(conf.Port))
+	}
+	if conf.Username != "" {
+		args = append(args, "-u", conf.Username, "-pw", conf.Password)
+	}
+	return runCommand(time.Duration(conf.Timeout)*time.Second, conf.Nodetool, append(args, command)...)
+}
+
+// submitLatencies parses nodetool proxyhistograms, the latencies of the
+// client requests in microseconds by percentile:
+//
+//	Percentile       Read Latency      Write Latency      Range Latency ...
+//	                     (micros)           (micros)           (micros)
+//	50%                    454.83             219.34            1131.75
+func submitLatencies(agg metric.Aggregator, out []byte, tags []string) error {
+	var columns []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "Percentile") {
+			for _, c := range strings.Split(line, "Latency") {
+				c = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(c), "Percentile"))
+				if c != "" {
+					columns = append(columns, strings.ToLower(strings.Replace(c, " ", "_", -1)))
+				}
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if columns == nil || len(fields) != len(columns)+1 || !strings.HasSuffix(fields[0], "%") {
+			continue
+		}
+
+		percentile := "p" + strings.Replace(strings.TrimSuffix(fields[0], "%"), ".", "", -1)
+		for i, column := range columns {
+			v, err := strconv.ParseFloat(fields[i+1], 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s latency %q", column, fields[i+1])
+			}
+			name := "cassandra.nodetool.latency." + column + "." + percentile
+			agg.Add("gauge", metric.NewMetric(name, v/1e6, tags))
+		}
+	}
+	if columns == nil {
+		return fmt.Errorf("unexpected nodetool proxyhistograms output: %q", out)
+	}
+	return scanner.Err()
+}
+
+// submitStatus counts the nodes of the ring by datacenter and status.
+func submitStatus(agg metric.Aggregator, out []byte, tags []string) {
+	type key struct{ datacenter, status string }
+	counts := make(map[key]int)
+	datacenter := ""
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "Datacenter:") {
+			datacenter = strings.TrimSpace(strings.TrimPrefix(line, "Datacenter:"))
+			// Both are reported, even without nodes.
+			counts[key{datacenter, "up"}], counts[key{datacenter, "down"}] = 0, 0
+			continue
+		}
+		if m := nodeRe.FindStringSubmatch(line); m != nil {
+			status := "up"
+			if m[1] == "D" {
+				status = "down"
+			}
+			counts[key{datacenter, status}]++
+		}
+	}
+
+	for k, count := range counts {
+		statusTags := append(append([]string{}, tags...), "datacenter:"+k.datacenter, "status:"+k.status)
+		agg.Add("gauge", metric.NewMetric("cassandra.nodetool.status.nodes", count, statusTags))
+	}
+}
+
+func init() {
+	collector.Add("cassandra_nodetool", NewCassandraNodetool)
+}
@@ -0,0 +1,63 @@
+package cassandranodetool
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	var commands []string
+	runCommand = func(timeout time.Duration, name string, args ...string) ([]byte, error) {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return ioutil.ReadFile("testdata/" + args[len(args)-1])
+	}
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewCassandraNodetool(nil).Check(agg, plugin.Instance{
+		"host":     "10.0.0.1",
+		"port":     7199,
+		"username": "monitor",
+		"password": "secret",
+	}))
+	assert.Equal(t, "nodetool -h 10.0.0.1 -p 7199 -u monitor -pw secret compactionstats", commands[0])
+	assert.Len(t, commands, 4)
+
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"cassandra.nodetool.compactions.pending", nil, 4},
+		{"cassandra.nodetool.heap.used", nil, 1024.5 * MiB},
+		{"cassandra.nodetool.heap.max", nil, 4016 * MiB},
+		{"cassandra.nodetool.latency.read.p50", nil, 0.00045483},
+		{"cassandra.nodetool.latency.write.p99", nil, 0.00094313},
+		{"cassandra.nodetool.latency.range.p95", nil, 0.0023468},
+		{"cassandra.nodetool.latency.cas_read.p99", nil, 0},
+		{"cassandra.nodetool.latency.view_write.p75", nil, 0},
+		{"cassandra.nodetool.status.nodes", []string{"datacenter:dc1", "status:up"}, 2},
+		{"cassandra.nodetool.status.nodes", []string{"datacenter:dc1", "status:down"}, 1},
+		{"cassandra.nodetool.status.nodes", []string{"datacenter:dc2", "status:up"}, 1},
+		{"cassandra.nodetool.status.nodes", []string{"datacenter:dc2", "status:down"}, 0},
+	} {
+		value, ok := agg.Value(c.name, append(c.tags, "cassandra_host:10.0.0.1")...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			assert.InDelta(t, c.value, value, 1e-9, c.name)
+		}
+	}
+	assert.NotContains(t, agg.Names(), "cassandra.nodetool.latency.read.pmax")
+}
+
+func TestCheckUnreachable(t *testing.T) {
+	runCommand = func(timeout time.Duration, name string, args ...string) ([]byte, error) {
+		return []byte("nodetool: Failed to connect to '127.0.0.1:7199' - ConnectException: 'Connection refused'.\n"), nil
+	}
+	assert.Error(t, NewCassandraNodetool(nil).Check(&metric.MockAggregator{}, plugin.Instance{}))
+}
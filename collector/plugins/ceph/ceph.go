@@ -0,0 +1,240 @@
+package ceph
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// runCommand is replaced in tests.
+var runCommand = util.RunCommand
+
+// NewCeph XXX
+func NewCeph(conf plugin.InitConfig) plugin.Plugin {
+	return &Ceph{}
+}
+
+// Ceph collects the health, placement groups, OSDs, pools and OSD latencies
+// of a Ceph cluster with the ceph command line tool.
+type Ceph struct{}
+
+type instanceConfig struct {
+	CephCmd string `yaml:"ceph_cmd"`
+	// Cluster and User are passed as --cluster and --id.
+	Cluster string   `yaml:"ceph_cluster"`
+	User    string   `yaml:"ceph_user"`
+	UseSudo bool     `yaml:"use_sudo"`
+	Timeout int      `yaml:"timeout"`
+	Tags    []string `yaml:"tags"`
+}
+
+// healthStatus maps the health of the cluster to a number.
+var healthStatus = map[string]int{
+	"HEALTH_OK":   0,
+	"HEALTH_WARN": 1,
+	"HEALTH_ERR":  2,
+}
+
+type osdMap struct {
+	NumOSDs   *float64 `json:"num_osds"`
+	NumUpOSDs float64  `json:"num_up_osds"`
+	NumInOSDs float64  `json:"num_in_osds"`
+	Full      bool     `json:"full"`
+	NearFull  bool     `json:"nearfull"`
+}
+
+type status struct {
+	FSID   string `json:"fsid"`
+	Health struct {
+		Status        string `json:"status"`
+		OverallStatus string `json:"overall_status"`
+	} `json:"health"`
+	QuorumNames []string `json:"quorum_names"`
+	MonMap      struct {
+		Mons []interface{} `json:"mons"`
+	} `json:"monmap"`
+	// Before Luminous the OSD map is nested in another osdmap object.
+	OSDMap struct {
+		osdMap
+		OSDMap *osdMap `json:"osdmap"`
+	} `json:"osdmap"`
+	PGMap struct {
+		PGsByState []struct {
+			StateName string  `json:"state_name"`
+			Count     float64 `json:"count"`
+		} `json:"pgs_by_state"`
+		NumPGs        float64 `json:"num_pgs"`
+		BytesUsed     float64 `json:"bytes_used"`
+		BytesAvail    float64 `json:"bytes_avail"`
+		BytesTotal    float64 `json:"bytes_total"`
+		ReadBytesSec  float64 `json:"read_bytes_sec"`
+		WriteBytesSec float64 `json:"write_bytes_sec"`
+		ReadOpPerSec  float64 `json:"read_op_per_sec"`
+		WriteOpPerSec float64 `json:"write_op_per_sec"`
+	} `json:"pgmap"`
+}
+
+type df struct {
+	Pools []struct {
+		Name  string             `json:"name"`
+		Stats map[string]float64 `json:"stats"`
+	} `json:"pools"`
+}
+
+type osdPerfInfos []struct {
+	ID        int `json:"id"`
+	PerfStats struct {
+		CommitLatencyMs float64 `json:"commit_latency_ms"`
+		ApplyLatencyMs  float64 `json:"apply_latency_ms"`
+	} `json:"perf_stats"`
+}
+
+type osdPerf struct {
+	OSDPerfInfos osdPerfInfos `json:"osd_perf_infos"`
+	// Nautilus and later nest them in osdstats.
+	OSDStats struct {
+		OSDPerfInfos osdPerfInfos `json:"osd_perf_infos"`
+	} `json:"osdstats"`
+}
+
+// poolStats are the pool stats of ceph df we report.
+var poolStats = map[string]string{
+	"bytes_used":   "ceph.pool.bytes_used",
+	"stored":       "ceph.pool.stored",
+	"max_avail":    "ceph.pool.max_avail",
+	"objects":      "ceph.pool.objects",
+	"percent_used": "ceph.pool.percent_used",
+}
+
+// Check XXX
+func (c *Ceph) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.CephCmd == "" {
+		conf.CephCmd = "ceph"
+	}
+
+	var s status
+	if err := conf.run(&s, "status"); err != nil {
+		return err
+	}
+	tags := append(append([]string{}, conf.Tags...), "ceph_fsid:"+s.FSID)
+	submitStatus(agg, &s, tags)
+
+	var d df
+	if err := conf.run(&d, "df", "detail"); err != nil {
+		return err
+	}
+	for _, pool := range d.Pools {
+		poolTags := append(append([]string{}, tags...), "ceph_pool:"+pool.Name)
+		for key, name := range poolStats {
+			if value, ok := pool.Stats[key]; ok {
+				agg.Add("gauge", metric.NewMetric(name, value, poolTags))
+			}
+		}
+	}
+
+	var perf osdPerf
+	if err := conf.run(&perf, "osd", "perf"); err != nil {
+		return err
+	}
+	infos := perf.OSDPerfInfos
+	if len(infos) == 0 {
+		infos = perf.OSDStats.OSDPerfInfos
+	}
+	for _, info := range infos {
+		osdTags := append(append([]string{}, tags...), "ceph_osd:osd."+strconv.Itoa(info.ID))
+		agg.AddMetrics("gauge", "ceph", map[string]interface{}{
+			"commit_latency_ms": info.PerfStats.CommitLatencyMs,
+			"apply_latency_ms":  info.PerfStats.ApplyLatencyMs,
+		}, osdTags, "")
+	}
+
+	return nil
+}
+
+func submitStatus(agg metric.Aggregator, s *status, tags []string) {
+	health := s.Health.Status
+	if health == "" {
+		health = s.Health.OverallStatus
+	}
+	if value, ok := healthStatus[health]; ok {
+		agg.Add("gauge", metric.NewMetric("ceph.overall_status", value, tags))
+	}
+
+	osds := &s.OSDMap.osdMap
+	if s.OSDMap.OSDMap != nil {
+		osds = s.OSDMap.OSDMap
+	}
+	fields := map[string]interface{}{
+		"num_mons":         len(s.MonMap.Mons),
+		"num_in_quorum":    len(s.QuorumNames),
+		"num_up_osds":      osds.NumUpOSDs,
+		"num_in_osds":      osds.NumInOSDs,
+		"num_pgs":          s.PGMap.NumPGs,
+		"read_bytes_sec":   s.PGMap.ReadBytesSec,
+		"write_bytes_sec":  s.PGMap.WriteBytesSec,
+		"read_op_per_sec":  s.PGMap.ReadOpPerSec,
+		"write_op_per_sec": s.PGMap.WriteOpPerSec,
+		"osd.full":         boolToInt(osds.Full),
+		"osd.nearfull":     boolToInt(osds.NearFull),
+	}
+	if osds.NumOSDs != nil {
+		fields["num_osds"] = *osds.NumOSDs
+	}
+	if s.PGMap.BytesTotal > 0 {
+		fields["aggregate_pct_used"] = s.PGMap.BytesUsed / s.PGMap.BytesTotal * 100
+	}
+	agg.AddMetrics("gauge", "ceph", fields, tags, "")
+
+	// e.g. ceph.pgstate.active_clean
+	for _, pg := range s.PGMap.PGsByState {
+		name := "ceph.pgstate." + strings.Replace(pg.StateName, "+", "_", -1)
+		agg.Add("gauge", metric.NewMetric(name, pg.Count, tags))
+	}
+}
+
+func (conf *instanceConfig) run(v interface{}, args ...string) error {
+	command := strings.Join(args, " ")
+	if conf.Cluster != "" {
+		args = append(args, "--cluster", conf.Cluster)
+	}
+	if conf.User != "" {
+		args = append(args, "--id", conf.User)
+	}
+	args = append(args, "--format", "json")
+
+	name := conf.CephCmd
+	if conf.UseSudo {
+		name, args = "sudo", append([]string{"-n", conf.CephCmd}, args...)
+	}
+
+	out, err := runCommand(time.Duration(conf.Timeout)*time.Second, name, args...)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(out, v); err != nil {
+		return fmt.Errorf("could not decode the output of ceph %s: %s", command, err)
+	}
+	return nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func init() {
+	collector.Add("ceph", NewCeph)
+}
@@ -0,0 +1,73 @@
+package ceph
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	runCommand = func(timeout time.Duration, name string, args ...string) ([]byte, error) {
+		assert.Equal(t, "ceph", name)
+		command := strings.Join(args, " ")
+		assert.True(t, strings.HasSuffix(command, " --id monitoring --format json"), command)
+
+		file := map[string]string{
+			"status":   "testdata/status.json",
+			"df":       "testdata/df.json",
+			"osd perf": "testdata/osd_perf.json",
+		}[strings.TrimSuffix(strings.Split(command, " --")[0], " detail")]
+		if file == "" {
+			return nil, fmt.Errorf("unexpected command %s", command)
+		}
+		return ioutil.ReadFile(file)
+	}
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewCeph(nil).Check(agg, plugin.Instance{"ceph_user": "monitoring"}))
+
+	fsid := "ceph_fsid:d6e4b1a2-5c7e-4b1d-9a5e-3f2b1c0d9e8f"
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"ceph.overall_status", []string{fsid}, 1},
+		{"ceph.num_mons", nil, 3},
+		{"ceph.num_in_quorum", nil, 3},
+		{"ceph.num_osds", nil, 3},
+		{"ceph.num_up_osds", nil, 2},
+		{"ceph.num_in_osds", nil, 3},
+		{"ceph.osd.nearfull", nil, 1},
+		{"ceph.num_pgs", nil, 64},
+		{"ceph.pgstate.active_clean", nil, 60},
+		{"ceph.pgstate.active_undersized_degraded", nil, 4},
+		{"ceph.aggregate_pct_used", nil, 25},
+		{"ceph.read_bytes_sec", nil, 1024},
+		{"ceph.write_op_per_sec", nil, 12},
+		{"ceph.pool.bytes_used", []string{"ceph_pool:rbd"}, 200},
+		{"ceph.pool.objects", []string{"ceph_pool:rbd"}, 42},
+		{"ceph.commit_latency_ms", []string{"ceph_osd:osd.1"}, 10},
+		{"ceph.apply_latency_ms", []string{"ceph_osd:osd.0"}, 4},
+	} {
+		value, ok := agg.Value(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			assert.Equal(t, c.value, value, c.name)
+		}
+	}
+}
+
+func TestCheckError(t *testing.T) {
+	runCommand = func(timeout time.Duration, name string, args ...string) ([]byte, error) {
+		return []byte("not json"), nil
+	}
+	err := NewCeph(nil).Check(&metric.MockAggregator{}, plugin.Instance{})
+	assert.EqualError(t, err, "could not decode the output of ceph status: invalid character 'o' in literal null (expecting 'u')")
+}
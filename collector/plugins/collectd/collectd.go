@@ -0,0 +1,286 @@
+package collectd
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+const (
+	// DefaultListen is the default address of the receiver, the default
+	// port of the collectd network plugin.
+	DefaultListen = ":25826"
+
+	// MaxPendingMetrics is the number of metrics kept between two checks,
+	// once reached the received metrics are dropped.
+	MaxPendingMetrics = 100000
+
+	// UDPMaxPacketSize is the largest packet we can receive.
+	UDPMaxPacketSize = 64 * 1024
+)
+
+// NewCollectd XXX
+func NewCollectd(conf plugin.InitConfig) plugin.Plugin {
+	return &Collectd{}
+}
+
+// Collectd receives the metrics sent by the network plugin of collectd, so
+// existing collectd setups can forward their metrics to the agent. The
+// receiver is started by the first check, the next ones submit the metrics
+// received in between.
+type Collectd struct{}
+
+type instanceConfig struct {
+	Listen string `yaml:"listen"`
+	// SecurityLevel is none, sign or encrypt, the packets which are not
+	// signed or encrypted as required are dropped.
+	SecurityLevel string `yaml:"security_level"`
+	// AuthFile lists the users allowed to sign or encrypt the packets, in
+	// the format of collectd, i.e. "user: password" lines.
+	AuthFile string `yaml:"auth_file"`
+	// TypesDB are the types.db files naming the values of the types not
+	// known by default.
+	TypesDB []string `yaml:"typesdb"`
+	Tags    []string `yaml:"tags"`
+}
+
+var (
+	serversLock sync.Mutex
+	// servers are keyed by their listen address, they outlive the plugins
+	// so a reload of the configuration doesn't have to rebind them.
+	servers = make(map[string]*server)
+)
+
+// Check XXX
+func (c *Collectd) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.Listen == "" {
+		conf.Listen = DefaultListen
+	}
+	switch conf.SecurityLevel {
+	case "":
+		conf.SecurityLevel = securityNone
+	case securityNone, securitySign, securityEncrypt:
+	default:
+		return fmt.Errorf("invalid security_level %q, expecting none, sign or encrypt", conf.SecurityLevel)
+	}
+
+	passwords := make(map[string]string)
+	if conf.AuthFile != "" {
+		var err error
+		if passwords, err = loadAuthFile(conf.AuthFile); err != nil {
+			return fmt.Errorf("could not load the auth_file: %s", err)
+		}
+	}
+	if conf.SecurityLevel != securityNone && len(passwords) == 0 {
+		return fmt.Errorf("an auth_file is required by the security_level %s", conf.SecurityLevel)
+	}
+
+	types := make(typesDB)
+	for name, ds := range defaultTypes {
+		types[name] = ds
+	}
+	for _, file := range conf.TypesDB {
+		if err := loadTypesDB(file, types); err != nil {
+			return fmt.Errorf("could not load the typesdb: %s", err)
+		}
+	}
+
+	s, err := getServer(conf.Listen)
+	if err != nil {
+		return err
+	}
+	s.configure(newDecoder(conf.SecurityLevel, passwords), types)
+
+	for _, m := range s.drain() {
+		m.Tags = append(m.Tags, conf.Tags...)
+		agg.Add("gauge", m)
+	}
+	return nil
+}
+
+func getServer(addr string) (*server, error) {
+	serversLock.Lock()
+	defer serversLock.Unlock()
+
+	if s, ok := servers[addr]; ok {
+		return s, nil
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	log.Infoln("Collectd receiver listening on:", conn.LocalAddr())
+
+	s := &server{
+		conn: conn,
+		last: make(map[string][2]float64),
+	}
+	servers[addr] = s
+	go s.serve()
+	return s, nil
+}
+
+type server struct {
+	sync.Mutex
+
+	conn    *net.UDPConn
+	decoder *decoder
+	types   typesDB
+	// last keeps the last time and value of the counters, their rates are
+	// computed from the times of the value lists.
+	last    map[string][2]float64
+	metrics []metric.Metric
+	drops   int
+}
+
+func (s *server) configure(d *decoder, types typesDB) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.decoder = d
+	s.types = types
+}
+
+func (s *server) drain() []metric.Metric {
+	s.Lock()
+	defer s.Unlock()
+
+	metrics := s.metrics
+	s.metrics = nil
+	return metrics
+}
+
+func (s *server) serve() {
+	buf := make([]byte, UDPMaxPacketSize)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			log.Infoln("Collectd receiver failed to read UDP msg:", err)
+			return
+		}
+
+		s.Lock()
+		if s.decoder == nil {
+			// Not configured by the check yet.
+			s.Unlock()
+			continue
+		}
+		lists, err := s.decoder.decode(buf[:n])
+		if err != nil {
+			log.Debugf("Dropping collectd packet from %s: %s", addr, err)
+		}
+		for _, list := range lists {
+			s.add(list)
+		}
+		s.Unlock()
+	}
+}
+
+// add turns the value list into metrics named after its identifier, e.g.
+// collectd.interface.if_octets.rx, the plugin instance becomes a tag.
+func (s *server) add(list valueList) {
+	names := s.types[list.Type]
+	var tags []string
+	if list.PluginInstance != "" {
+		tags = append(tags, "instance:"+list.PluginInstance)
+	}
+
+	parts := []string{"collectd", list.Plugin}
+	if list.Type != list.Plugin {
+		parts = append(parts, list.Type)
+	}
+	if list.TypeInstance != "" {
+		parts = append(parts, list.TypeInstance)
+	}
+	prefix := strings.Replace(strings.Join(parts, "."), " ", "_", -1)
+	key := strings.Join([]string{list.Host, list.Plugin, list.PluginInstance, list.Type, list.TypeInstance}, "/")
+
+	for i, value := range list.Values {
+		name := prefix
+		if len(list.Values) > 1 {
+			ds := fmt.Sprint(i)
+			if i < len(names) {
+				ds = names[i]
+			}
+			name += "." + ds
+		}
+
+		switch list.DSTypes[i] {
+		case dsCounter, dsDerive:
+			dsKey := fmt.Sprintf("%s/%d", key, i)
+			prev, ok := s.last[dsKey]
+			s.last[dsKey] = [2]float64{list.Time, value}
+			if !ok || list.Time <= prev[0] || value < prev[1] {
+				// First value, or the counter has been reset or wrapped.
+				continue
+			}
+			value = (value - prev[1]) / (list.Time - prev[0])
+		case dsAbsolute:
+			// Absolute values are reset when read, i.e. every interval.
+			if list.Interval <= 0 {
+				continue
+			}
+			value /= list.Interval
+		}
+
+		if len(s.metrics) >= MaxPendingMetrics {
+			s.drops++
+			if s.drops == 1 || s.drops%MaxPendingMetrics == 0 {
+				log.Infof("ERROR: collectd metric queue full. "+
+					"We have dropped %d metrics so far.", s.drops)
+			}
+			continue
+		}
+		m := metric.NewMetric(name, value, append([]string{}, tags...))
+		m.Hostname = list.Host
+		m.Timestamp = int64(list.Time)
+		s.metrics = append(s.metrics, m)
+	}
+}
+
+// loadAuthFile parses the "user: password" lines of the auth file.
+func loadAuthFile(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	passwords := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid line %q in %s", line, file)
+		}
+		passwords[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return passwords, scanner.Err()
+}
+
+func init() {
+	collector.Add("collectd", NewCollectd)
+}
@@ -0,0 +1,113 @@
+package collectd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func send(t *testing.T, addr net.Addr, p []byte) {
+	conn, err := net.Dial("udp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(p)
+	require.NoError(t, err)
+}
+
+func waitMetrics(s *server, n int) {
+	for i := 0; i < 100; i++ {
+		s.Lock()
+		count := len(s.metrics)
+		s.Unlock()
+		if count >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	c := NewCollectd(nil)
+	instance := plugin.Instance{
+		"listen": "127.0.0.1:0",
+		"tags":   []interface{}{"source:collectd"},
+	}
+	require.NoError(t, c.Check(&metric.MockAggregator{}, instance))
+	s := servers["127.0.0.1:0"]
+	addr := s.conn.LocalAddr()
+
+	// The rates of the derive values are computed from the second packet.
+	send(t, addr, samplePacket)
+	waitMetrics(s, 3)
+	send(t, addr, packet(
+		stringPart(partHost, "web01"),
+		numberPart(partTime, 1488453248),
+		stringPart(partPlugin, "interface"),
+		stringPart(partPluginInstance, "eth0"),
+		stringPart(partType, "if_octets"),
+		valuesPart([]byte{dsDerive, dsDerive}, []float64{1500, 3000}),
+	))
+	waitMetrics(s, 5)
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, c.Check(agg, instance))
+	for _, e := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"collectd.load.shortterm", nil, 0.5},
+		{"collectd.load.longterm", nil, 0.125},
+		{"collectd.interface.if_octets.rx", []string{"instance:eth0"}, 50},
+		{"collectd.interface.if_octets.tx", []string{"instance:eth0"}, 100},
+	} {
+		m, ok := agg.Get(e.name, append(e.tags, "source:collectd")...)
+		if assert.True(t, ok, "metric %s not found", e.name) {
+			value, _ := agg.Value(e.name)
+			assert.Equal(t, e.value, value, e.name)
+			assert.Equal(t, "web01", m.Hostname)
+			assert.Equal(t, "gauge", m.Type)
+		}
+	}
+	assert.Len(t, agg.Metrics, 5)
+
+	// The metrics are only submitted once.
+	agg = &metric.MockAggregator{}
+	require.NoError(t, c.Check(agg, instance))
+	assert.Empty(t, agg.Metrics)
+}
+
+func TestCheckConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collectd")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	authFile := filepath.Join(dir, "passwd")
+	require.NoError(t, ioutil.WriteFile(authFile, []byte("# users\nalice: secret\n"), 0600))
+
+	passwords, err := loadAuthFile(authFile)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"alice": "secret"}, passwords)
+
+	typesFile := filepath.Join(dir, "types.db")
+	require.NoError(t, ioutil.WriteFile(typesFile, []byte("my_type  in:DERIVE:0:U, out:DERIVE:0:U\n"), 0644))
+	types := make(typesDB)
+	require.NoError(t, loadTypesDB(typesFile, types))
+	assert.Equal(t, []string{"in", "out"}, types["my_type"])
+
+	c := NewCollectd(nil)
+	for _, instance := range []plugin.Instance{
+		{"security_level": "paranoid"},
+		{"security_level": "sign"},
+		{"auth_file": filepath.Join(dir, "missing")},
+	} {
+		assert.Error(t, c.Check(&metric.MockAggregator{}, instance), "%v", instance)
+	}
+}
@@ -0,0 +1,277 @@
+package collectd
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// The part types of the collectd binary network protocol, see
+// https://collectd.org/wiki/index.php/Binary_protocol
+const (
+	partHost           = 0x0000
+	partTime           = 0x0001
+	partPlugin         = 0x0002
+	partPluginInstance = 0x0003
+	partType           = 0x0004
+	partTypeInstance   = 0x0005
+	partValues         = 0x0006
+	partInterval       = 0x0007
+	partTimeHR         = 0x0008
+	partIntervalHR     = 0x0009
+	partSignature      = 0x0200
+	partEncryption     = 0x0210
+)
+
+// The data source types of the values.
+const (
+	dsCounter  = 0
+	dsGauge    = 1
+	dsDerive   = 2
+	dsAbsolute = 3
+)
+
+// The security levels of the receiver, like the SecurityLevel option of the
+// network plugin of collectd.
+const (
+	securityNone    = "none"
+	securitySign    = "sign"
+	securityEncrypt = "encrypt"
+)
+
+// valueList is a set of values sharing the same identifier, e.g. the rx and
+// tx values of the if_octets type. Time and Interval are in seconds.
+type valueList struct {
+	Host           string
+	Plugin         string
+	PluginInstance string
+	Type           string
+	TypeInstance   string
+	Time           float64
+	Interval       float64
+	DSTypes        []byte
+	Values         []float64
+}
+
+// decoder decodes the packets of the collectd network plugin, the fields of
+// the value lists are carried over from one part to the next.
+type decoder struct {
+	level string
+	// passwords are the credentials of the users allowed to sign or encrypt
+	// the packets.
+	passwords map[string]string
+
+	state valueList
+	lists []valueList
+}
+
+func newDecoder(level string, passwords map[string]string) *decoder {
+	return &decoder{
+		level:     level,
+		passwords: passwords,
+	}
+}
+
+// decode returns the value lists of a packet, the value lists which are not
+// signed or encrypted as the security level requires are dropped.
+func (d *decoder) decode(packet []byte) ([]valueList, error) {
+	d.state = valueList{}
+	d.lists = nil
+	err := d.decodeParts(packet, "")
+	return d.lists, err
+}
+
+// decodeParts decodes the parts of b, security is the protection of b
+// (signed or encrypted) by an enclosing part.
+func (d *decoder) decodeParts(b []byte, security string) error {
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return fmt.Errorf("truncated part header")
+		}
+		kind := binary.BigEndian.Uint16(b[0:2])
+		length := int(binary.BigEndian.Uint16(b[2:4]))
+		if length < 4 || length > len(b) {
+			return fmt.Errorf("invalid length %d of part 0x%04x", length, kind)
+		}
+		body := b[4:length]
+
+		var err error
+		switch kind {
+		case partSignature:
+			// The signature covers the rest of the packet.
+			rest := b[length:]
+			if security, err = d.verify(body, rest, security); err != nil {
+				return err
+			}
+			return d.decodeParts(rest, security)
+		case partEncryption:
+			var payload []byte
+			if payload, err = d.decrypt(body); err != nil {
+				return err
+			}
+			if err = d.decodeParts(payload, securityEncrypt); err != nil {
+				return err
+			}
+		case partHost:
+			d.state.Host, err = decodeString(body)
+		case partPlugin:
+			d.state.Plugin, err = decodeString(body)
+		case partPluginInstance:
+			d.state.PluginInstance, err = decodeString(body)
+		case partType:
+			d.state.Type, err = decodeString(body)
+		case partTypeInstance:
+			d.state.TypeInstance, err = decodeString(body)
+		case partTime, partInterval, partTimeHR, partIntervalHR:
+			if len(body) != 8 {
+				return fmt.Errorf("invalid length %d of part 0x%04x", length, kind)
+			}
+			value := float64(binary.BigEndian.Uint64(body))
+			if kind == partTimeHR || kind == partIntervalHR {
+				// The high resolution times are in 2^-30 seconds.
+				value /= 1 << 30
+			}
+			if kind == partTime || kind == partTimeHR {
+				d.state.Time = value
+			} else {
+				d.state.Interval = value
+			}
+		case partValues:
+			if !d.allowed(security) {
+				break
+			}
+			var list valueList
+			if list, err = d.decodeValues(body); err == nil {
+				d.lists = append(d.lists, list)
+			}
+		}
+		// The notifications and the unknown parts are skipped.
+		if err != nil {
+			return err
+		}
+
+		b = b[length:]
+	}
+	return nil
+}
+
+func (d *decoder) allowed(security string) bool {
+	switch d.level {
+	case securityEncrypt:
+		return security == securityEncrypt
+	case securitySign:
+		return security == securitySign || security == securityEncrypt
+	default:
+		return true
+	}
+}
+
+func (d *decoder) decodeValues(body []byte) (valueList, error) {
+	if len(body) < 2 {
+		return valueList{}, fmt.Errorf("truncated values part")
+	}
+	n := int(binary.BigEndian.Uint16(body[0:2]))
+	if len(body) != 2+n*9 {
+		return valueList{}, fmt.Errorf("invalid length of the values part for %d values", n)
+	}
+
+	list := d.state
+	list.DSTypes = make([]byte, n)
+	list.Values = make([]float64, n)
+	copy(list.DSTypes, body[2:2+n])
+	values := body[2+n:]
+	for i, ds := range list.DSTypes {
+		raw := values[i*8 : (i+1)*8]
+		switch ds {
+		case dsGauge:
+			// Gauges are the only values in little endian.
+			list.Values[i] = math.Float64frombits(binary.LittleEndian.Uint64(raw))
+		case dsDerive:
+			list.Values[i] = float64(int64(binary.BigEndian.Uint64(raw)))
+		case dsCounter, dsAbsolute:
+			list.Values[i] = float64(binary.BigEndian.Uint64(raw))
+		default:
+			return valueList{}, fmt.Errorf("unknown data source type %d", ds)
+		}
+	}
+	return list, nil
+}
+
+// verify checks the HMAC-SHA-256 signature of the signed part, made of the
+// 32 bytes signature and of the user name, over the user name and the rest
+// of the packet. It returns the security of the rest of the packet.
+func (d *decoder) verify(body, rest []byte, security string) (string, error) {
+	if len(body) < sha256.Size {
+		return "", fmt.Errorf("truncated signature part")
+	}
+	signature, user := body[:sha256.Size], body[sha256.Size:]
+
+	password, ok := d.passwords[string(user)]
+	if !ok {
+		if d.level == securityNone {
+			// Like collectd, signed packets from unknown users are accepted
+			// when no security is required.
+			return security, nil
+		}
+		return "", fmt.Errorf("signed packet from unknown user %q", user)
+	}
+
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write(user)
+	mac.Write(rest)
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return "", fmt.Errorf("invalid signature of the packet from user %q", user)
+	}
+	if security == securityEncrypt {
+		return security, nil
+	}
+	return securitySign, nil
+}
+
+// decrypt decrypts the encrypted part, made of the length of the user name,
+// the user name, a 16 bytes IV and the payload encrypted with AES-256 in OFB
+// mode, the key being the SHA-256 of the password. The payload starts with
+// the SHA-1 of the rest of it.
+func (d *decoder) decrypt(body []byte) ([]byte, error) {
+	if len(body) < 2 {
+		return nil, fmt.Errorf("truncated encryption part")
+	}
+	n := int(binary.BigEndian.Uint16(body[0:2]))
+	if len(body) < 2+n+aes.BlockSize+sha1.Size {
+		return nil, fmt.Errorf("truncated encryption part")
+	}
+	user := string(body[2 : 2+n])
+	iv := body[2+n : 2+n+aes.BlockSize]
+	encrypted := body[2+n+aes.BlockSize:]
+
+	password, ok := d.passwords[user]
+	if !ok {
+		return nil, fmt.Errorf("encrypted packet from unknown user %q", user)
+	}
+	key := sha256.Sum256([]byte(password))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := make([]byte, len(encrypted))
+	cipher.NewOFB(block, iv).XORKeyStream(decrypted, encrypted)
+	sum := sha1.Sum(decrypted[sha1.Size:])
+	if !bytes.Equal(sum[:], decrypted[:sha1.Size]) {
+		return nil, fmt.Errorf("could not decrypt the packet from user %q, wrong password?", user)
+	}
+	return decrypted[sha1.Size:], nil
+}
+
+func decodeString(b []byte) (string, error) {
+	if len(b) == 0 || b[len(b)-1] != 0 {
+		return "", fmt.Errorf("string part not null terminated")
+	}
+	return string(b[:len(b)-1]), nil
+}
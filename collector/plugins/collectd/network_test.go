@@ -0,0 +1,163 @@
+package collectd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The helpers below encode packets like the network plugin of collectd.
+
+func part(kind uint16, body []byte) []byte {
+	b := make([]byte, 4, 4+len(body))
+	binary.BigEndian.PutUint16(b[0:2], kind)
+	binary.BigEndian.PutUint16(b[2:4], uint16(4+len(body)))
+	return append(b, body...)
+}
+
+func stringPart(kind uint16, s string) []byte {
+	return part(kind, append([]byte(s), 0))
+}
+
+func numberPart(kind uint16, n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return part(kind, b)
+}
+
+func valuesPart(dsTypes []byte, values []float64) []byte {
+	b := make([]byte, 2, 2+len(values)*9)
+	binary.BigEndian.PutUint16(b, uint16(len(values)))
+	b = append(b, dsTypes...)
+	for i, v := range values {
+		raw := make([]byte, 8)
+		if dsTypes[i] == dsGauge {
+			binary.LittleEndian.PutUint64(raw, math.Float64bits(v))
+		} else {
+			binary.BigEndian.PutUint64(raw, uint64(v))
+		}
+		b = append(b, raw...)
+	}
+	return part(partValues, b)
+}
+
+func packet(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func sign(user, password string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write([]byte(user))
+	mac.Write(payload)
+	return append(part(partSignature, append(mac.Sum(nil), user...)), payload...)
+}
+
+func encrypt(user, password string, payload []byte) []byte {
+	key := sha256.Sum256([]byte(password))
+	block, _ := aes.NewCipher(key[:])
+	iv := make([]byte, aes.BlockSize)
+	for i := range iv {
+		iv[i] = byte(i)
+	}
+	sum := sha1.Sum(payload)
+	plain := append(sum[:], payload...)
+	encrypted := make([]byte, len(plain))
+	cipher.NewOFB(block, iv).XORKeyStream(encrypted, plain)
+
+	body := make([]byte, 2)
+	binary.BigEndian.PutUint16(body, uint16(len(user)))
+	body = append(body, user...)
+	body = append(body, iv...)
+	return part(partEncryption, append(body, encrypted...))
+}
+
+var samplePacket = packet(
+	stringPart(partHost, "web01"),
+	numberPart(partTimeHR, 1488453238<<30),
+	numberPart(partIntervalHR, 10<<30),
+	stringPart(partPlugin, "load"),
+	stringPart(partType, "load"),
+	valuesPart([]byte{dsGauge, dsGauge, dsGauge}, []float64{0.5, 0.25, 0.125}),
+	stringPart(partPlugin, "interface"),
+	stringPart(partPluginInstance, "eth0"),
+	stringPart(partType, "if_octets"),
+	valuesPart([]byte{dsDerive, dsDerive}, []float64{1000, 2000}),
+)
+
+func TestDecode(t *testing.T) {
+	lists, err := newDecoder(securityNone, nil).decode(samplePacket)
+	require.NoError(t, err)
+	require.Len(t, lists, 2)
+
+	assert.Equal(t, valueList{
+		Host:     "web01",
+		Plugin:   "load",
+		Type:     "load",
+		Time:     1488453238,
+		Interval: 10,
+		DSTypes:  []byte{dsGauge, dsGauge, dsGauge},
+		Values:   []float64{0.5, 0.25, 0.125},
+	}, lists[0])
+	assert.Equal(t, "interface", lists[1].Plugin)
+	assert.Equal(t, "eth0", lists[1].PluginInstance)
+	assert.Equal(t, "web01", lists[1].Host)
+	assert.Equal(t, []float64{1000, 2000}, lists[1].Values)
+}
+
+func TestDecodeSecurity(t *testing.T) {
+	passwords := map[string]string{"alice": "secret"}
+	signed := sign("alice", "secret", samplePacket)
+	encrypted := encrypt("alice", "secret", samplePacket)
+
+	for _, c := range []struct {
+		level  string
+		packet []byte
+		lists  int
+		err    bool
+	}{
+		{securityNone, samplePacket, 2, false},
+		{securityNone, signed, 2, false},
+		{securityNone, encrypted, 2, false},
+		{securitySign, samplePacket, 0, false},
+		{securitySign, signed, 2, false},
+		{securitySign, encrypted, 2, false},
+		{securitySign, sign("alice", "wrong", samplePacket), 0, true},
+		{securitySign, sign("bob", "secret", samplePacket), 0, true},
+		{securityEncrypt, signed, 0, false},
+		{securityEncrypt, encrypted, 2, false},
+		{securityEncrypt, encrypt("alice", "wrong", samplePacket), 0, true},
+	} {
+		lists, err := newDecoder(c.level, passwords).decode(c.packet)
+		if c.err {
+			assert.Error(t, err, c.level)
+		} else {
+			assert.NoError(t, err, c.level)
+		}
+		assert.Len(t, lists, c.lists, c.level)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, p := range [][]byte{
+		{0x00, 0x00},
+		{0x00, 0x02, 0x00, 0x10, 'a'},
+		part(partHost, []byte("web01")),
+		part(partTime, []byte{1, 2}),
+		part(partValues, []byte{0x00, 0x02, dsGauge}),
+	} {
+		_, err := newDecoder(securityNone, nil).decode(p)
+		assert.Error(t, err, "%x", p)
+	}
+}
@@ -0,0 +1,72 @@
+package collectd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// typesDB maps the collectd types to the names of their data sources, the
+// types with a single data source don't need to be known.
+type typesDB map[string][]string
+
+// defaultTypes are the multi valued types of the types.db shipped with
+// collectd used by the common plugins.
+var defaultTypes = typesDB{
+	"load":              {"shortterm", "midterm", "longterm"},
+	"if_octets":         {"rx", "tx"},
+	"if_packets":        {"rx", "tx"},
+	"if_errors":         {"rx", "tx"},
+	"if_dropped":        {"rx", "tx"},
+	"disk_octets":       {"read", "write"},
+	"disk_ops":          {"read", "write"},
+	"disk_time":         {"read", "write"},
+	"disk_merged":       {"read", "write"},
+	"disk_io_time":      {"io_time", "weighted_io_time"},
+	"io_octets":         {"rx", "tx"},
+	"io_packets":        {"rx", "tx"},
+	"ps_count":          {"processes", "threads"},
+	"ps_cputime":        {"user", "syst"},
+	"ps_disk_octets":    {"read", "write"},
+	"ps_disk_ops":       {"read", "write"},
+	"ps_pagefaults":     {"minflt", "majflt"},
+	"node_octets":       {"rx", "tx"},
+	"mysql_octets":      {"rx", "tx"},
+	"memcached_octets":  {"rx", "tx"},
+	"voltage_threshold": {"value", "threshold"},
+	"vmpage_faults":     {"minflt", "majflt"},
+	"vmpage_io":         {"in", "out"},
+}
+
+// loadTypesDB parses types.db files, whose lines look like:
+//
+//	if_octets  rx:DERIVE:0:U, tx:DERIVE:0:U
+func loadTypesDB(file string, types typesDB) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return fmt.Errorf("invalid line %q in %s", line, file)
+		}
+
+		var names []string
+		for _, ds := range strings.Split(strings.Join(fields[1:], ""), ",") {
+			names = append(names, strings.SplitN(ds, ":", 2)[0])
+		}
+		types[fields[0]] = names
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,412 @@
+package consul
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// DefaultMaxServices is the default number of services we collect catalog
+// metrics for, every service costs a request to the agent.
+const DefaultMaxServices = 50
+
+// NewConsul XXX
+func NewConsul(conf plugin.InitConfig) plugin.Plugin {
+	return &Consul{}
+}
+
+// Consul collects the catalog, health checks, raft and serf state from the
+// HTTP API of a Consul agent.
+type Consul struct{}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	URL      string `yaml:"url"`
+	ACLToken string `yaml:"acl_token"`
+	// CatalogChecks collects the node counts of every service in the catalog.
+	CatalogChecks   bool     `yaml:"catalog_checks"`
+	ServicesInclude []string `yaml:"services_include"`
+	ServicesExclude []string `yaml:"services_exclude"`
+	MaxServices     int      `yaml:"max_services"`
+	// NetworkLatencyChecks computes the latencies between the nodes from the
+	// network coordinates.
+	NetworkLatencyChecks bool     `yaml:"network_latency_checks"`
+	Tags                 []string `yaml:"tags"`
+}
+
+type agentSelf struct {
+	Config struct {
+		Datacenter string `json:"Datacenter"`
+		NodeName   string `json:"NodeName"`
+		Server     bool   `json:"Server"`
+	} `json:"Config"`
+	Stats struct {
+		Consul map[string]string `json:"consul"`
+	} `json:"Stats"`
+}
+
+type member struct {
+	Name   string `json:"Name"`
+	Status int    `json:"Status"`
+}
+
+// memberStatus is the serf member status, as reported by /v1/agent/members.
+var memberStatus = map[int]string{
+	0: "none",
+	1: "alive",
+	2: "leaving",
+	3: "left",
+	4: "failed",
+}
+
+type healthCheck struct {
+	Node        string `json:"Node"`
+	CheckID     string `json:"CheckID"`
+	Name        string `json:"Name"`
+	Status      string `json:"Status"`
+	ServiceID   string `json:"ServiceID"`
+	ServiceName string `json:"ServiceName"`
+	Output      string `json:"Output"`
+}
+
+// checkStatus maps the health check states to the service check statuses,
+// from the best to the worst.
+var checkStatus = map[string]int{
+	"passing":  metric.ServiceCheckOK,
+	"warning":  metric.ServiceCheckWarning,
+	"critical": metric.ServiceCheckCritical,
+}
+
+type serviceHealth struct {
+	Node struct {
+		Node string `json:"Node"`
+	} `json:"Node"`
+	Checks []healthCheck `json:"Checks"`
+}
+
+type coordinate struct {
+	Node  string `json:"Node"`
+	Coord struct {
+		Vec        []float64 `json:"Vec"`
+		Error      float64   `json:"Error"`
+		Adjustment float64   `json:"Adjustment"`
+		Height     float64   `json:"Height"`
+	} `json:"Coord"`
+}
+
+// Check XXX
+func (c *Consul) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	conf, err := parseConfig(instance)
+	if err != nil {
+		return err
+	}
+
+	var self agentSelf
+	if err = conf.GetJSON(conf.URL+"/v1/agent/self", &self); err != nil {
+		return err
+	}
+	tags := append(append([]string{}, conf.Tags...), "consul_datacenter:"+self.Config.Datacenter)
+
+	if err = c.collectRaft(agg, conf, &self, tags); err != nil {
+		return err
+	}
+
+	if err = c.collectMembers(agg, conf, tags); err != nil {
+		return err
+	}
+
+	if err = c.collectChecks(agg, conf, tags); err != nil {
+		return err
+	}
+
+	if conf.CatalogChecks {
+		if err = c.collectCatalog(agg, conf, tags); err != nil {
+			return err
+		}
+	}
+
+	if conf.NetworkLatencyChecks {
+		if err = c.collectLatency(agg, conf, self.Config.NodeName, tags); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parseConfig(instance plugin.Instance) (*instanceConfig, error) {
+	conf := &instanceConfig{}
+	if err := instance.Unmarshal(conf); err != nil {
+		return nil, err
+	}
+
+	if conf.URL == "" {
+		conf.URL = "http://localhost:8500"
+	}
+	conf.URL = strings.TrimSuffix(conf.URL, "/")
+	if conf.ACLToken != "" {
+		if conf.Headers == nil {
+			conf.Headers = make(map[string]string)
+		}
+		conf.Headers["X-Consul-Token"] = conf.ACLToken
+	}
+	if conf.MaxServices <= 0 {
+		conf.MaxServices = DefaultMaxServices
+	}
+
+	return conf, nil
+}
+
+func (c *Consul) collectRaft(agg metric.Aggregator, conf *instanceConfig, self *agentSelf, tags []string) error {
+	var peers []string
+	if err := conf.GetJSON(conf.URL+"/v1/status/peers", &peers); err != nil {
+		return err
+	}
+	agg.Add("gauge", metric.NewMetric("consul.peers", len(peers), tags))
+
+	var leader string
+	if err := conf.GetJSON(conf.URL+"/v1/status/leader", &leader); err != nil {
+		return err
+	}
+	hasLeader := 0
+	if leader != "" {
+		hasLeader = 1
+	}
+	agg.Add("gauge", metric.NewMetric("consul.has_leader", hasLeader, tags))
+
+	// Only the servers take part in the raft election.
+	if self.Config.Server {
+		isLeader := 0
+		if self.Stats.Consul["leader"] == "true" {
+			isLeader = 1
+		}
+		agg.Add("gauge", metric.NewMetric("consul.is_leader", isLeader, tags))
+	}
+
+	return nil
+}
+
+func (c *Consul) collectMembers(agg metric.Aggregator, conf *instanceConfig, tags []string) error {
+	var members []member
+	if err := conf.GetJSON(conf.URL+"/v1/agent/members", &members); err != nil {
+		return err
+	}
+
+	counts := make(map[string]interface{})
+	for _, status := range memberStatus {
+		counts[status] = 0
+	}
+	for _, m := range members {
+		if status, ok := memberStatus[m.Status]; ok {
+			counts[status] = counts[status].(int) + 1
+		}
+	}
+	agg.AddMetrics("gauge", "consul.serf.members", counts, tags, "")
+
+	return nil
+}
+
+func (c *Consul) collectChecks(agg metric.Aggregator, conf *instanceConfig, tags []string) error {
+	var checks []healthCheck
+	if err := conf.GetJSON(conf.URL+"/v1/health/state/any", &checks); err != nil {
+		return err
+	}
+
+	for _, check := range checks {
+		checkTags := append(append([]string{}, tags...),
+			"consul_node:"+check.Node,
+			"consul_check_id:"+check.CheckID,
+		)
+		if check.ServiceName != "" {
+			checkTags = append(checkTags,
+				"consul_service:"+check.ServiceName,
+				"consul_service_id:"+check.ServiceID,
+			)
+		}
+
+		status, ok := checkStatus[check.Status]
+		if !ok {
+			status = metric.ServiceCheckUnknown
+		}
+		sc := metric.NewServiceCheck("consul.check", status, checkTags)
+		sc.Message = strings.TrimSpace(check.Output)
+		agg.AddServiceCheck(sc)
+		if ok {
+			agg.Add("gauge", metric.NewMetric("consul.check.status", status, checkTags))
+		}
+	}
+
+	return nil
+}
+
+func (c *Consul) collectCatalog(agg metric.Aggregator, conf *instanceConfig, tags []string) error {
+	include, err := compilePatterns(conf.ServicesInclude)
+	if err != nil {
+		return err
+	}
+	exclude, err := compilePatterns(conf.ServicesExclude)
+	if err != nil {
+		return err
+	}
+
+	var nodes []interface{}
+	if err = conf.GetJSON(conf.URL+"/v1/catalog/nodes", &nodes); err != nil {
+		return err
+	}
+	agg.Add("gauge", metric.NewMetric("consul.catalog.total_nodes", len(nodes), tags))
+
+	var catalog map[string][]string
+	if err = conf.GetJSON(conf.URL+"/v1/catalog/services", &catalog); err != nil {
+		return err
+	}
+	agg.Add("gauge", metric.NewMetric("consul.catalog.services_count", len(catalog), tags))
+
+	var services []string
+	for name := range catalog {
+		if matchAny(exclude, name) || (len(include) > 0 && !matchAny(include, name)) {
+			continue
+		}
+		services = append(services, name)
+	}
+	sort.Strings(services)
+	if len(services) > conf.MaxServices {
+		metric.Logger(agg).Warnf("Consul service whitelist exceeds the limit of %d, only the first %d services are collected.",
+			conf.MaxServices, conf.MaxServices)
+		services = services[:conf.MaxServices]
+	}
+
+	for _, name := range services {
+		var health []serviceHealth
+		if err = conf.GetJSON(conf.URL+"/v1/health/service/"+url.PathEscape(name), &health); err != nil {
+			return err
+		}
+
+		// A node is as healthy as its worst check.
+		counts := map[string]interface{}{
+			"nodes_up":       len(health),
+			"nodes_passing":  0,
+			"nodes_warning":  0,
+			"nodes_critical": 0,
+		}
+		for _, node := range health {
+			worst := "passing"
+			for _, check := range node.Checks {
+				if checkStatus[check.Status] > checkStatus[worst] {
+					worst = check.Status
+				}
+			}
+			key := "nodes_" + worst
+			counts[key] = counts[key].(int) + 1
+		}
+
+		serviceTags := append(append([]string{}, tags...), "consul_service_id:"+name)
+		for _, tag := range catalog[name] {
+			serviceTags = append(serviceTags, "consul_"+name+"_service_tag:"+tag)
+		}
+		agg.AddMetrics("gauge", "consul.catalog", counts, serviceTags, "")
+	}
+
+	return nil
+}
+
+func (c *Consul) collectLatency(agg metric.Aggregator, conf *instanceConfig, node string, tags []string) error {
+	var coords []coordinate
+	if err := conf.GetJSON(conf.URL+"/v1/coordinate/nodes", &coords); err != nil {
+		return err
+	}
+
+	var local *coordinate
+	for i := range coords {
+		if coords[i].Node == node {
+			local = &coords[i]
+			break
+		}
+	}
+	if local == nil {
+		return fmt.Errorf("no network coordinate found for consul node %s", node)
+	}
+
+	var latencies []float64
+	for i := range coords {
+		if coords[i].Node == node {
+			continue
+		}
+		latencies = append(latencies, distance(local, &coords[i])*1000)
+	}
+	if len(latencies) == 0 {
+		return nil
+	}
+	sort.Float64s(latencies)
+
+	agg.AddMetrics("gauge", "consul.net.node.latency", map[string]interface{}{
+		"min":    latencies[0],
+		"max":    latencies[len(latencies)-1],
+		"median": percentile(latencies, 0.5),
+		"p25":    percentile(latencies, 0.25),
+		"p75":    percentile(latencies, 0.75),
+		"p90":    percentile(latencies, 0.90),
+		"p95":    percentile(latencies, 0.95),
+		"p99":    percentile(latencies, 0.99),
+	}, append(append([]string{}, tags...), "consul_node:"+node), "")
+
+	return nil
+}
+
+// distance estimates the round trip time in seconds between two nodes, see
+// https://www.consul.io/docs/internals/coordinates.html
+func distance(a, b *coordinate) float64 {
+	var sum float64
+	for i := 0; i < len(a.Coord.Vec) && i < len(b.Coord.Vec); i++ {
+		d := a.Coord.Vec[i] - b.Coord.Vec[i]
+		sum += d * d
+	}
+	rtt := math.Sqrt(sum) + a.Coord.Height + b.Coord.Height
+
+	adjusted := rtt + a.Coord.Adjustment + b.Coord.Adjustment
+	if adjusted > 0 {
+		return adjusted
+	}
+	return rtt
+}
+
+// percentile returns the nearest-rank percentile of the sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var result []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid service pattern %q: %s", pattern, err)
+		}
+		result = append(result, re)
+	}
+	return result, nil
+}
+
+func matchAny(patterns []*regexp.Regexp, name string) bool {
+	for _, re := range patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	collector.Add("consul", NewConsul)
+}
@@ -0,0 +1,116 @@
+package consul
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var responses = map[string]string{
+	"/v1/agent/self": `{"Config": {"Datacenter": "dc1", "NodeName": "node-1", "Server": true},
+		"Stats": {"consul": {"leader": "true"}}}`,
+	"/v1/status/peers":  `["10.0.0.1:8300", "10.0.0.2:8300", "10.0.0.3:8300"]`,
+	"/v1/status/leader": `"10.0.0.1:8300"`,
+	"/v1/agent/members": `[{"Name": "node-1", "Status": 1}, {"Name": "node-2", "Status": 1},
+		{"Name": "node-3", "Status": 4}]`,
+	"/v1/health/state/any": `[
+		{"Node": "node-1", "CheckID": "serfHealth", "Status": "passing"},
+		{"Node": "node-2", "CheckID": "service:web", "Status": "critical", "ServiceID": "web", "ServiceName": "web", "Output": "HTTP GET http://localhost/: 503\n"},
+		{"Node": "node-2", "CheckID": "_node_maintenance", "Status": "maintenance"}
+	]`,
+	"/v1/catalog/nodes":    `[{"Node": "node-1"}, {"Node": "node-2"}, {"Node": "node-3"}]`,
+	"/v1/catalog/services": `{"consul": [], "web": ["v1"], "db": []}`,
+	"/v1/health/service/web": `[
+		{"Node": {"Node": "node-1"}, "Checks": [{"Status": "passing"}]},
+		{"Node": {"Node": "node-2"}, "Checks": [{"Status": "passing"}, {"Status": "critical"}]}
+	]`,
+	"/v1/health/service/consul": `[{"Node": {"Node": "node-1"}, "Checks": [{"Status": "passing"}]}]`,
+	"/v1/coordinate/nodes": `[
+		{"Node": "node-1", "Coord": {"Vec": [0, 0], "Height": 0.001}},
+		{"Node": "node-2", "Coord": {"Vec": [0.003, 0.004], "Height": 0.001}},
+		{"Node": "node-3", "Coord": {"Vec": [0, 0.008], "Height": 0}}
+	]`,
+}
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("X-Consul-Token"))
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, body)
+	}))
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	err := NewConsul(nil).Check(agg, plugin.Instance{
+		"url":                    server.URL,
+		"acl_token":              "secret",
+		"catalog_checks":         true,
+		"services_exclude":       []interface{}{"^db$"},
+		"network_latency_checks": true,
+	})
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"consul.peers", []string{"consul_datacenter:dc1"}, 3},
+		{"consul.has_leader", nil, 1},
+		{"consul.is_leader", nil, 1},
+		{"consul.serf.members.alive", nil, 2},
+		{"consul.serf.members.failed", nil, 1},
+		{"consul.check.status", []string{"consul_check_id:serfHealth"}, 0},
+		{"consul.check.status", []string{"consul_check_id:service:web", "consul_service:web"}, 2},
+		{"consul.catalog.total_nodes", nil, 3},
+		{"consul.catalog.services_count", nil, 3},
+		{"consul.catalog.nodes_up", []string{"consul_service_id:web", "consul_web_service_tag:v1"}, 2},
+		{"consul.catalog.nodes_passing", []string{"consul_service_id:web"}, 1},
+		{"consul.catalog.nodes_critical", []string{"consul_service_id:web"}, 1},
+		{"consul.net.node.latency.min", []string{"consul_node:node-1"}, 7},
+		{"consul.net.node.latency.max", nil, 9},
+	} {
+		value, ok := agg.Value(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			assert.InDelta(t, c.value, value, 1e-9, c.name)
+		}
+	}
+
+	_, ok := agg.Get("consul.catalog.nodes_up", "consul_service_id:db")
+	assert.False(t, ok, "excluded services must be skipped")
+
+	for _, c := range []struct {
+		checkID string
+		status  int
+		message string
+	}{
+		{"serfHealth", metric.ServiceCheckOK, ""},
+		{"service:web", metric.ServiceCheckCritical, "HTTP GET http://localhost/: 503"},
+		{"_node_maintenance", metric.ServiceCheckUnknown, ""},
+	} {
+		sc, ok := agg.GetServiceCheck("consul.check", "consul_check_id:"+c.checkID)
+		if assert.True(t, ok, "service check of %s not found", c.checkID) {
+			assert.Equal(t, c.status, sc.Status, c.checkID)
+			assert.Equal(t, c.message, sc.Message, c.checkID)
+		}
+	}
+	_, ok = agg.Get("consul.check.status", "consul_check_id:_node_maintenance")
+	assert.False(t, ok, "unknown states have no gauge")
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{1, 2, 3, 4}
+	assert.Equal(t, float64(2), percentile(values, 0.5))
+	assert.Equal(t, float64(4), percentile(values, 0.99))
+	assert.Equal(t, float64(1), percentile(values, 0))
+}
@@ -0,0 +1,160 @@
+package couchbase
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewCouchbase XXX
+func NewCouchbase(conf plugin.InitConfig) plugin.Plugin {
+	return &Couchbase{}
+}
+
+// Couchbase collects the cluster storage, node and bucket stats from the REST
+// API of a Couchbase server.
+type Couchbase struct{}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	Server string   `yaml:"server"`
+	Tags   []string `yaml:"tags"`
+}
+
+type pool struct {
+	Nodes []struct {
+		Hostname          string             `json:"hostname"`
+		Status            string             `json:"status"`
+		ClusterMembership string             `json:"clusterMembership"`
+		InterestingStats  map[string]float64 `json:"interestingStats"`
+		MemoryTotal       float64            `json:"memoryTotal"`
+		MemoryFree        float64            `json:"memoryFree"`
+	} `json:"nodes"`
+	StorageTotals struct {
+		RAM map[string]float64 `json:"ram"`
+		HDD map[string]float64 `json:"hdd"`
+	} `json:"storageTotals"`
+}
+
+type bucket struct {
+	Name       string             `json:"name"`
+	BasicStats map[string]float64 `json:"basicStats"`
+}
+
+type bucketStats struct {
+	Op struct {
+		Samples map[string][]float64 `json:"samples"`
+	} `json:"op"`
+}
+
+// Check XXX
+func (c *Couchbase) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.Server == "" {
+		conf.Server = "http://localhost:8091"
+	}
+	conf.Server = strings.TrimSuffix(conf.Server, "/")
+
+	if err := c.collectPool(agg, &conf); err != nil {
+		return err
+	}
+
+	return c.collectBuckets(agg, &conf)
+}
+
+func (c *Couchbase) collectPool(agg metric.Aggregator, conf *instanceConfig) error {
+	var p pool
+	if err := conf.GetJSON(conf.Server+"/pools/default", &p); err != nil {
+		return err
+	}
+
+	for name, value := range p.StorageTotals.RAM {
+		agg.Add("gauge", metric.NewMetric("couchbase.ram."+toSnakeCase(name), value, conf.Tags))
+	}
+	for name, value := range p.StorageTotals.HDD {
+		agg.Add("gauge", metric.NewMetric("couchbase.hdd."+toSnakeCase(name), value, conf.Tags))
+	}
+
+	for _, node := range p.Nodes {
+		tags := append(append([]string{}, conf.Tags...), "node:"+node.Hostname)
+		fields := map[string]interface{}{
+			"memory_total": node.MemoryTotal,
+			"memory_free":  node.MemoryFree,
+		}
+		for name, value := range node.InterestingStats {
+			fields[name] = value
+		}
+		healthy := 0
+		if node.Status == "healthy" && node.ClusterMembership == "active" {
+			healthy = 1
+		}
+		fields["healthy"] = healthy
+		agg.AddMetrics("gauge", "couchbase.by_node", fields, tags, "")
+	}
+
+	return nil
+}
+
+func (c *Couchbase) collectBuckets(agg metric.Aggregator, conf *instanceConfig) error {
+	var buckets []bucket
+	if err := conf.GetJSON(conf.Server+"/pools/default/buckets", &buckets); err != nil {
+		return err
+	}
+
+	for _, b := range buckets {
+		tags := append(append([]string{}, conf.Tags...), "bucket:"+b.Name)
+
+		fields := make(map[string]interface{})
+		for name, value := range b.BasicStats {
+			fields[toSnakeCase(name)] = value
+		}
+		agg.AddMetrics("gauge", "couchbase.by_bucket", fields, tags, "")
+
+		var stats bucketStats
+		u := fmt.Sprintf("%s/pools/default/buckets/%s/stats", conf.Server, url.PathEscape(b.Name))
+		if err := conf.GetJSON(u, &stats); err != nil {
+			return err
+		}
+
+		// The stats are sampled every second, the last sample is the
+		// current value.
+		fields = make(map[string]interface{})
+		for name, samples := range stats.Op.Samples {
+			if name == "timestamp" || len(samples) == 0 {
+				continue
+			}
+			fields[name] = samples[len(samples)-1]
+		}
+		agg.AddMetrics("gauge", "couchbase.by_bucket", fields, tags, "")
+	}
+
+	return nil
+}
+
+// toSnakeCase converts the camel cased names of the REST API, e.g.
+// quotaPercentUsed becomes quota_percent_used.
+func toSnakeCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func init() {
+	collector.Add("couchbase", NewCouchbase)
+}
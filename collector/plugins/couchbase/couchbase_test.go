@@ -0,0 +1,82 @@
+package couchbase
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToSnakeCase(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want string
+	}{
+		{"quotaPercentUsed", "quota_percent_used"},
+		{"used", "used"},
+		{"opsPerSec", "ops_per_sec"},
+	} {
+		assert.Equal(t, c.want, toSnakeCase(c.in))
+	}
+}
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		assert.Equal(t, "Administrator", user)
+		assert.Equal(t, "password", pass)
+
+		switch r.URL.Path {
+		case "/pools/default":
+			fmt.Fprintln(w, `{
+				"storageTotals": {"ram": {"total": 8000, "quotaUsed": 2000}, "hdd": {"free": 50000}},
+				"nodes": [{"hostname": "10.0.0.1:8091", "status": "healthy", "clusterMembership": "active",
+					"memoryTotal": 8000, "memoryFree": 3000, "interestingStats": {"cmd_get": 12, "mem_used": 900}}]
+			}`)
+		case "/pools/default/buckets":
+			fmt.Fprintln(w, `[{"name": "default", "basicStats": {"opsPerSec": 42, "quotaPercentUsed": 12.5}}]`)
+		case "/pools/default/buckets/default/stats":
+			fmt.Fprintln(w, `{"op": {"samples": {"timestamp": [1, 2],
+				"disk_write_queue": [3, 4], "ep_cache_miss_rate": [0.5, 0.25]}}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	err := NewCouchbase(nil).Check(agg, plugin.Instance{
+		"server":   server.URL,
+		"username": "Administrator",
+		"password": "password",
+	})
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"couchbase.ram.total", nil, 8000},
+		{"couchbase.ram.quota_used", nil, 2000},
+		{"couchbase.hdd.free", nil, 50000},
+		{"couchbase.by_node.cmd_get", []string{"node:10.0.0.1:8091"}, 12},
+		{"couchbase.by_node.memory_free", nil, 3000},
+		{"couchbase.by_node.healthy", nil, 1},
+		{"couchbase.by_bucket.ops_per_sec", []string{"bucket:default"}, 42},
+		{"couchbase.by_bucket.quota_percent_used", nil, 12.5},
+		{"couchbase.by_bucket.disk_write_queue", nil, 4},
+		{"couchbase.by_bucket.ep_cache_miss_rate", nil, 0.25},
+	} {
+		value, ok := agg.Value(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s not found", c.name) {
+			assert.Equal(t, c.value, value, c.name)
+		}
+	}
+	assert.NotContains(t, agg.Names(), "couchbase.by_bucket.timestamp")
+}
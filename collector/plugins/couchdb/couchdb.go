@@ -0,0 +1,176 @@
+package couchdb
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// DefaultMaxDatabases is the default number of databases we collect metrics
+// for.
+const DefaultMaxDatabases = 50
+
+// NewCouchDB XXX
+func NewCouchDB(conf plugin.InitConfig) plugin.Plugin {
+	return &CouchDB{}
+}
+
+// CouchDB collects the server statistics and the size of the databases of
+// CouchDB 1.x, or of the local node of CouchDB 2.x.
+type CouchDB struct{}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	Server string `yaml:"server"`
+	// Databases are the databases to report, the first MaxDatabases ones by
+	// default.
+	Databases    []string `yaml:"databases"`
+	MaxDatabases int      `yaml:"max_dbs"`
+	Tags         []string `yaml:"tags"`
+}
+
+type database struct {
+	DocCount    float64 `json:"doc_count"`
+	DocDelCount float64 `json:"doc_del_count"`
+	// DiskSize is the size of CouchDB 1.x, Sizes the ones of 2.x.
+	DiskSize *float64 `json:"disk_size"`
+	Sizes    struct {
+		File     *float64 `json:"file"`
+		Active   *float64 `json:"active"`
+		External *float64 `json:"external"`
+	} `json:"sizes"`
+}
+
+// Check XXX
+func (c *CouchDB) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.Server == "" {
+		conf.Server = "http://localhost:5984"
+	}
+	conf.Server = strings.TrimSuffix(conf.Server, "/")
+	if conf.MaxDatabases <= 0 {
+		conf.MaxDatabases = DefaultMaxDatabases
+	}
+	tags := append(append([]string{}, conf.Tags...), "instance:"+conf.Server)
+
+	var info struct {
+		Version string `json:"version"`
+	}
+	if err := conf.GetJSON(conf.Server+"/", &info); err != nil {
+		return err
+	}
+
+	var stats map[string]interface{}
+	if strings.HasPrefix(info.Version, "1.") {
+		if err := conf.GetJSON(conf.Server+"/_stats", &stats); err != nil {
+			return err
+		}
+		submitStatsV1(agg, stats, tags)
+	} else {
+		if err := conf.GetJSON(conf.Server+"/_node/_local/_stats", &stats); err != nil {
+			return err
+		}
+		submitStats(agg, "couchdb", stats, tags)
+	}
+
+	databases := conf.Databases
+	if len(databases) == 0 {
+		if err := conf.GetJSON(conf.Server+"/_all_dbs", &databases); err != nil {
+			return err
+		}
+		if len(databases) > conf.MaxDatabases {
+			metric.Logger(agg).Warnf("Too many databases, only the first %d are collected, "+
+				"choose the databases you are interested in in couchdb.yaml", conf.MaxDatabases)
+			databases = databases[:conf.MaxDatabases]
+		}
+	}
+	for _, name := range databases {
+		var db database
+		if err := conf.GetJSON(conf.Server+"/"+url.PathEscape(name), &db); err != nil {
+			metric.Logger(agg).Warnf("Could not get the stats of database %s: %s", name, err)
+			continue
+		}
+		dbTags := append(append([]string{}, tags...), "db:"+name)
+		agg.Add("gauge", metric.NewMetric("couchdb.by_db.doc_count", db.DocCount, dbTags))
+		agg.Add("gauge", metric.NewMetric("couchdb.by_db.doc_del_count", db.DocDelCount, dbTags))
+		for name, value := range map[string]*float64{
+			"couchdb.by_db.disk_size":     db.DiskSize,
+			"couchdb.by_db.file_size":     db.Sizes.File,
+			"couchdb.by_db.active_size":   db.Sizes.Active,
+			"couchdb.by_db.external_size": db.Sizes.External,
+		} {
+			if value != nil {
+				agg.Add("gauge", metric.NewMetric(name, *value, dbTags))
+			}
+		}
+	}
+	return nil
+}
+
+// submitStatsV1 reports the current values of the statistics of CouchDB
+// 1.x, grouped by module, e.g. {"httpd": {"requests": {"current": 42}}}.
+func submitStatsV1(agg metric.Aggregator, stats map[string]interface{}, tags []string) {
+	for group, g := range stats {
+		values, ok := g.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for name, s := range values {
+			stat, ok := s.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if current, ok := stat["current"].(float64); ok {
+				agg.Add("gauge", metric.NewMetric("couchdb."+group+"."+name, current, tags))
+			}
+		}
+	}
+}
+
+// submitStats walks the nested statistics of CouchDB 2.x, whose leaves hold
+// a type and a value. The counters are reported as rates, the histograms by
+// their mean and max.
+func submitStats(agg metric.Aggregator, prefix string, stats map[string]interface{}, tags []string) {
+	for key, s := range stats {
+		stat, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name := prefix + "." + key
+		statType, isLeaf := stat["type"].(string)
+		if !isLeaf {
+			submitStats(agg, name, stat, tags)
+			continue
+		}
+
+		switch statType {
+		case "counter":
+			if v, ok := stat["value"].(float64); ok {
+				agg.Add("rate", metric.NewMetric(name, v, tags))
+			}
+		case "gauge":
+			if v, ok := stat["value"].(float64); ok {
+				agg.Add("gauge", metric.NewMetric(name, v, tags))
+			}
+		case "histogram":
+			h, _ := stat["value"].(map[string]interface{})
+			if v, ok := h["arithmetic_mean"].(float64); ok {
+				agg.Add("gauge", metric.NewMetric(name+".mean", v, tags))
+			}
+			if v, ok := h["max"].(float64); ok {
+				agg.Add("gauge", metric.NewMetric(name+".max", v, tags))
+			}
+		}
+	}
+}
+
+func init() {
+	collector.Add("couchdb", NewCouchDB)
+}
@@ -0,0 +1,103 @@
+package couchdb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckV2(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintln(w, `{"couchdb": "Welcome", "version": "2.3.1"}`)
+		case "/_node/_local/_stats":
+			fmt.Fprintln(w, `{"couchdb": {
+				"open_databases": {"value": 7, "type": "counter", "desc": "number of open databases"},
+				"open_os_files": {"value": 21, "type": "counter"},
+				"request_time": {"value": {"min": 0.5, "max": 230, "arithmetic_mean": 12.5}, "type": "histogram"},
+				"httpd_request_methods": {"GET": {"value": 4211, "type": "counter"}}
+			}, "mem3": {"shard_cache": {"eviction": {"value": 0, "type": "counter"}}}}`)
+		case "/_all_dbs":
+			fmt.Fprintln(w, `["_users", "orders"]`)
+		case "/_users":
+			fmt.Fprintln(w, `{"db_name": "_users", "doc_count": 3, "doc_del_count": 0, "sizes": {"file": 65536}}`)
+		case "/orders":
+			fmt.Fprintln(w, `{"db_name": "orders", "doc_count": 1200, "doc_del_count": 12,
+				"sizes": {"file": 4194304, "active": 3145728, "external": 2097152}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewCouchDB(nil).Check(agg, plugin.Instance{"server": server.URL + "/"}))
+	instance := "instance:" + server.URL
+	for _, c := range []struct {
+		name       string
+		tags       []string
+		value      float64
+		metricType string
+	}{
+		{"couchdb.couchdb.open_databases", nil, 7, "rate"},
+		{"couchdb.couchdb.request_time.mean", nil, 12.5, "gauge"},
+		{"couchdb.couchdb.request_time.max", nil, 230, "gauge"},
+		{"couchdb.couchdb.httpd_request_methods.GET", nil, 4211, "rate"},
+		{"couchdb.mem3.shard_cache.eviction", nil, 0, "rate"},
+		{"couchdb.by_db.doc_count", []string{"db:orders"}, 1200, "gauge"},
+		{"couchdb.by_db.file_size", []string{"db:orders"}, 4194304, "gauge"},
+		{"couchdb.by_db.active_size", []string{"db:orders"}, 3145728, "gauge"},
+		{"couchdb.by_db.doc_count", []string{"db:_users"}, 3, "gauge"},
+	} {
+		m, ok := agg.Get(c.name, append(c.tags, instance)...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			assert.Equal(t, c.value, m.Value, c.name)
+			assert.Equal(t, c.metricType, m.Type, c.name)
+		}
+	}
+	assert.NotContains(t, agg.Names(), "couchdb.by_db.disk_size")
+}
+
+func TestCheckV1(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintln(w, `{"couchdb": "Welcome", "version": "1.6.1"}`)
+		case "/_stats":
+			fmt.Fprintln(w, `{"couchdb": {"open_databases": {"current": 3, "sum": 3, "mean": 0.01}},
+				"httpd_status_codes": {"500": {"current": null}, "200": {"current": 312}}}`)
+		case "/orders":
+			fmt.Fprintln(w, `{"db_name": "orders", "doc_count": 10, "doc_del_count": 1, "disk_size": 81920}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewCouchDB(nil).Check(agg, plugin.Instance{
+		"server":    server.URL,
+		"databases": []interface{}{"orders"},
+	}))
+	for _, c := range []struct {
+		name  string
+		value float64
+	}{
+		{"couchdb.couchdb.open_databases", 3},
+		{"couchdb.httpd_status_codes.200", 312},
+		{"couchdb.by_db.disk_size", 81920},
+	} {
+		value, ok := agg.Value(c.name)
+		if assert.True(t, ok, "metric %s not found", c.name) {
+			assert.Equal(t, c.value, value, c.name)
+		}
+	}
+	assert.NotContains(t, agg.Names(), "couchdb.httpd_status_codes.500")
+}
@@ -0,0 +1,124 @@
+package directory
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewDirectory XXX
+func NewDirectory(conf plugin.InitConfig) plugin.Plugin {
+	return &Directory{}
+}
+
+// Directory reports the number, size and age of the files in a directory.
+type Directory struct{}
+
+type instanceConfig struct {
+	Directory string `yaml:"directory"`
+	// Name is reported as the name tag, defaults to the directory.
+	Name string `yaml:"name"`
+	// Pattern is a shell pattern matched against the file names, e.g. *.log
+	Pattern   string `yaml:"pattern"`
+	Recursive bool   `yaml:"recursive"`
+	// FileMetrics reports the size and age of every file, beware of the
+	// number of metrics for large directories.
+	FileMetrics bool     `yaml:"file_metrics"`
+	Tags        []string `yaml:"tags"`
+}
+
+// Check XXX
+func (d *Directory) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.Directory == "" {
+		return fmt.Errorf("a configured directory is required")
+	}
+	if conf.Pattern == "" {
+		conf.Pattern = "*"
+	}
+	if _, err := filepath.Match(conf.Pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %s", conf.Pattern, err)
+	}
+	if conf.Name == "" {
+		conf.Name = conf.Directory
+	}
+
+	info, err := os.Stat(conf.Directory)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", conf.Directory)
+	}
+
+	tags := append(append([]string{}, conf.Tags...), "name:"+conf.Name)
+	now := time.Now()
+
+	var count, size float64
+	var oldest, newest time.Time
+	err = filepath.Walk(conf.Directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// The file may have been removed by the consumer of the
+			// directory while we walk it.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			if path != conf.Directory && !conf.Recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ok, _ := filepath.Match(conf.Pattern, info.Name()); !ok {
+			return nil
+		}
+
+		count++
+		size += float64(info.Size())
+		modified := info.ModTime()
+		if oldest.IsZero() || modified.Before(oldest) {
+			oldest = modified
+		}
+		if newest.IsZero() || modified.After(newest) {
+			newest = modified
+		}
+
+		if conf.FileMetrics {
+			fileTags := append(append([]string{}, tags...), "filename:"+path)
+			agg.AddMetrics("gauge", "system.disk.directory.file", map[string]interface{}{
+				"bytes":            info.Size(),
+				"modified_sec_ago": now.Sub(modified).Seconds(),
+			}, fileTags, "")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]interface{}{
+		"files": count,
+		"bytes": size,
+	}
+	if count > 0 {
+		fields["oldest_file_age"] = now.Sub(oldest).Seconds()
+		fields["newest_file_age"] = now.Sub(newest).Seconds()
+	}
+	agg.AddMetrics("gauge", "system.disk.directory", fields, tags, "")
+
+	return nil
+}
+
+func init() {
+	collector.Add("directory", NewDirectory)
+}
@@ -0,0 +1,94 @@
+package directory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "directory")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	for _, f := range []struct {
+		name string
+		size int
+		age  time.Duration
+	}{
+		{"a.msg", 10, time.Hour},
+		{"b.msg", 20, time.Minute},
+		{"c.tmp", 40, 0},
+		{"sub/d.msg", 80, 2 * time.Hour},
+	} {
+		path := filepath.Join(dir, f.name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, make([]byte, f.size), 0644))
+		require.NoError(t, os.Chtimes(path, now.Add(-f.age), now.Add(-f.age)))
+	}
+
+	for _, c := range []struct {
+		name     string
+		instance plugin.Instance
+		files    float64
+		bytes    float64
+		oldest   time.Duration
+		newest   time.Duration
+	}{
+		{"all", plugin.Instance{}, 3, 70, time.Hour, 0},
+		{"pattern", plugin.Instance{"pattern": "*.msg"}, 2, 30, time.Hour, time.Minute},
+		{"recursive", plugin.Instance{"pattern": "*.msg", "recursive": true}, 3, 110, 2 * time.Hour, time.Minute},
+	} {
+		c.instance["directory"] = dir
+		c.instance["name"] = "spool"
+		agg := &metric.MockAggregator{}
+		require.NoError(t, NewDirectory(nil).Check(agg, c.instance), c.name)
+
+		value, _ := agg.Value("system.disk.directory.files", "name:spool")
+		assert.Equal(t, c.files, value, c.name)
+		value, _ = agg.Value("system.disk.directory.bytes")
+		assert.Equal(t, c.bytes, value, c.name)
+		value, _ = agg.Value("system.disk.directory.oldest_file_age")
+		assert.InDelta(t, c.oldest.Seconds(), value, 5, c.name)
+		value, _ = agg.Value("system.disk.directory.newest_file_age")
+		assert.InDelta(t, c.newest.Seconds(), value, 5, c.name)
+		assert.NotContains(t, agg.Names(), "system.disk.directory.file.bytes", c.name)
+	}
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewDirectory(nil).Check(agg, plugin.Instance{"directory": dir, "file_metrics": true}))
+	value, ok := agg.Value("system.disk.directory.file.bytes", "filename:"+filepath.Join(dir, "b.msg"))
+	assert.True(t, ok)
+	assert.Equal(t, float64(20), value)
+}
+
+func TestEmptyDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "directory")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewDirectory(nil).Check(agg, plugin.Instance{"directory": dir}))
+	value, ok := agg.Value("system.disk.directory.files", "name:"+dir)
+	assert.True(t, ok)
+	assert.Equal(t, float64(0), value)
+	assert.NotContains(t, agg.Names(), "system.disk.directory.oldest_file_age")
+}
+
+func TestBadConfig(t *testing.T) {
+	for _, instance := range []plugin.Instance{
+		{},
+		{"directory": "/does/not/exist"},
+		{"directory": os.TempDir(), "pattern": "["},
+	} {
+		assert.Error(t, NewDirectory(nil).Check(&metric.MockAggregator{}, instance))
+	}
+}
@@ -0,0 +1,272 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// NewElasticsearch XXX
+func NewElasticsearch(conf plugin.InitConfig) plugin.Plugin {
+	return &Elasticsearch{}
+}
+
+// Elasticsearch collects the cluster health, node stats and optionally the
+// index stats of an Elasticsearch cluster.
+type Elasticsearch struct{}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	URL string `yaml:"url"`
+	// ClusterStats collects the stats of all the nodes in the cluster
+	// instead of the local node only.
+	ClusterStats bool     `yaml:"cluster_stats"`
+	IndexStats   bool     `yaml:"index_stats"`
+	Tags         []string `yaml:"tags"`
+}
+
+type metricDef struct {
+	metricType string
+	path       string
+	// scale is the divisor applied to the value, e.g. 1000 converts ms to s.
+	scale float64
+}
+
+var healthMetrics = map[string]metricDef{
+	"elasticsearch.number_of_nodes":                  {"gauge", "number_of_nodes", 1},
+	"elasticsearch.number_of_data_nodes":             {"gauge", "number_of_data_nodes", 1},
+	"elasticsearch.active_primary_shards":            {"gauge", "active_primary_shards", 1},
+	"elasticsearch.active_shards":                    {"gauge", "active_shards", 1},
+	"elasticsearch.relocating_shards":                {"gauge", "relocating_shards", 1},
+	"elasticsearch.initializing_shards":              {"gauge", "initializing_shards", 1},
+	"elasticsearch.unassigned_shards":                {"gauge", "unassigned_shards", 1},
+	"elasticsearch.delayed_unassigned_shards":        {"gauge", "delayed_unassigned_shards", 1},
+	"elasticsearch.pending_tasks_total":              {"gauge", "number_of_pending_tasks", 1},
+	"elasticsearch.number_of_in_flight_fetch":        {"gauge", "number_of_in_flight_fetch", 1},
+	"elasticsearch.active_shards_percent_as_numbers": {"gauge", "active_shards_percent_as_number", 1},
+}
+
+var nodeMetrics = map[string]metricDef{
+	"elasticsearch.docs.count":                  {"gauge", "indices.docs.count", 1},
+	"elasticsearch.docs.deleted":                {"gauge", "indices.docs.deleted", 1},
+	"elasticsearch.store.size":                  {"gauge", "indices.store.size_in_bytes", 1},
+	"elasticsearch.indexing.index.total":        {"rate", "indices.indexing.index_total", 1},
+	"elasticsearch.indexing.index.time":         {"rate", "indices.indexing.index_time_in_millis", 1000},
+	"elasticsearch.indexing.index.current":      {"gauge", "indices.indexing.index_current", 1},
+	"elasticsearch.indexing.delete.total":       {"rate", "indices.indexing.delete_total", 1},
+	"elasticsearch.get.total":                   {"rate", "indices.get.total", 1},
+	"elasticsearch.get.time":                    {"rate", "indices.get.time_in_millis", 1000},
+	"elasticsearch.search.query.total":          {"rate", "indices.search.query_total", 1},
+	"elasticsearch.search.query.time":           {"rate", "indices.search.query_time_in_millis", 1000},
+	"elasticsearch.search.query.current":        {"gauge", "indices.search.query_current", 1},
+	"elasticsearch.search.fetch.total":          {"rate", "indices.search.fetch_total", 1},
+	"elasticsearch.search.fetch.time":           {"rate", "indices.search.fetch_time_in_millis", 1000},
+	"elasticsearch.search.fetch.current":        {"gauge", "indices.search.fetch_current", 1},
+	"elasticsearch.merges.current":              {"gauge", "indices.merges.current", 1},
+	"elasticsearch.merges.total":                {"rate", "indices.merges.total", 1},
+	"elasticsearch.refresh.total":               {"rate", "indices.refresh.total", 1},
+	"elasticsearch.flush.total":                 {"rate", "indices.flush.total", 1},
+	"elasticsearch.segments.count":              {"gauge", "indices.segments.count", 1},
+	"elasticsearch.segments.memory_in_bytes":    {"gauge", "indices.segments.memory_in_bytes", 1},
+	"elasticsearch.fielddata.size":              {"gauge", "indices.fielddata.memory_size_in_bytes", 1},
+	"elasticsearch.fielddata.evictions":         {"rate", "indices.fielddata.evictions", 1},
+	"elasticsearch.http.current_open":           {"gauge", "http.current_open", 1},
+	"elasticsearch.http.total_opened":           {"rate", "http.total_opened", 1},
+	"elasticsearch.transport.server_open":       {"gauge", "transport.server_open", 1},
+	"elasticsearch.transport.rx_count":          {"rate", "transport.rx_count", 1},
+	"elasticsearch.transport.tx_count":          {"rate", "transport.tx_count", 1},
+	"elasticsearch.process.open_fd":             {"gauge", "process.open_file_descriptors", 1},
+	"jvm.mem.heap_used":                         {"gauge", "jvm.mem.heap_used_in_bytes", 1},
+	"jvm.mem.heap_committed":                    {"gauge", "jvm.mem.heap_committed_in_bytes", 1},
+	"jvm.mem.heap_max":                          {"gauge", "jvm.mem.heap_max_in_bytes", 1},
+	"jvm.mem.heap_in_use":                       {"gauge", "jvm.mem.heap_used_percent", 100},
+	"jvm.mem.non_heap_used":                     {"gauge", "jvm.mem.non_heap_used_in_bytes", 1},
+	"jvm.mem.non_heap_committed":                {"gauge", "jvm.mem.non_heap_committed_in_bytes", 1},
+	"jvm.threads.count":                         {"gauge", "jvm.threads.count", 1},
+	"jvm.gc.collectors.young.count":             {"rate", "jvm.gc.collectors.young.collection_count", 1},
+	"jvm.gc.collectors.young.collection_time":   {"rate", "jvm.gc.collectors.young.collection_time_in_millis", 1000},
+	"jvm.gc.collectors.old.count":               {"rate", "jvm.gc.collectors.old.collection_count", 1},
+	"jvm.gc.collectors.old.collection_time":     {"rate", "jvm.gc.collectors.old.collection_time_in_millis", 1000},
+	"elasticsearch.breakers.fielddata.tripped":  {"rate", "breakers.fielddata.tripped", 1},
+	"elasticsearch.breakers.request.tripped":    {"rate", "breakers.request.tripped", 1},
+	"elasticsearch.breakers.parent.tripped":     {"rate", "breakers.parent.tripped", 1},
+	"elasticsearch.fs.total.available_in_bytes": {"gauge", "fs.total.available_in_bytes", 1},
+	"elasticsearch.fs.total.total_in_bytes":     {"gauge", "fs.total.total_in_bytes", 1},
+}
+
+var threadPoolMetrics = map[string]string{
+	"threads":   "gauge",
+	"queue":     "gauge",
+	"active":    "gauge",
+	"rejected":  "rate",
+	"completed": "rate",
+}
+
+// clusterStatus maps the cluster health color to a number.
+var clusterStatus = map[string]int{
+	"green":  0,
+	"yellow": 1,
+	"red":    2,
+}
+
+type clusterHealth map[string]interface{}
+
+type nodesStats struct {
+	ClusterName string                            `json:"cluster_name"`
+	Nodes       map[string]map[string]interface{} `json:"nodes"`
+}
+
+type indexStats map[string]string
+
+// Check XXX
+func (e *Elasticsearch) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.URL == "" {
+		return fmt.Errorf("a configured url is required")
+	}
+	conf.URL = strings.TrimSuffix(conf.URL, "/")
+
+	tags, err := e.collectHealth(agg, &conf)
+	if err != nil {
+		return err
+	}
+
+	if err = e.collectNodes(agg, &conf, tags); err != nil {
+		return err
+	}
+
+	if conf.IndexStats {
+		if err = e.collectIndices(agg, &conf, tags); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// collectHealth collects the cluster health and returns the instance tags
+// along with the cluster_name tag.
+func (e *Elasticsearch) collectHealth(agg metric.Aggregator, conf *instanceConfig) ([]string, error) {
+	var health clusterHealth
+	if err := conf.GetJSON(conf.URL+"/_cluster/health", &health); err != nil {
+		return nil, err
+	}
+
+	tags := conf.Tags
+	if name, ok := health["cluster_name"].(string); ok {
+		tags = append(copyTags(tags), "cluster_name:"+name)
+	}
+
+	submit(agg, healthMetrics, health, tags)
+
+	if status, ok := health["status"].(string); ok {
+		if value, ok := clusterStatus[status]; ok {
+			agg.Add("gauge", metric.NewMetric("elasticsearch.cluster_status", value, tags))
+		}
+	}
+
+	return tags, nil
+}
+
+func (e *Elasticsearch) collectNodes(agg metric.Aggregator, conf *instanceConfig, tags []string) error {
+	url := conf.URL + "/_nodes/_local/stats"
+	if conf.ClusterStats {
+		url = conf.URL + "/_nodes/stats"
+	}
+
+	var stats nodesStats
+	if err := conf.GetJSON(url, &stats); err != nil {
+		return err
+	}
+
+	for id, node := range stats.Nodes {
+		name, ok := node["name"].(string)
+		if !ok {
+			name = id
+		}
+		nodeTags := append(copyTags(tags), "node_name:"+name)
+
+		submit(agg, nodeMetrics, node, nodeTags)
+
+		pools, _ := node["thread_pool"].(map[string]interface{})
+		for pool, data := range pools {
+			poolStats, ok := data.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for stat, metricType := range threadPoolMetrics {
+				if value, ok := poolStats[stat]; ok {
+					name := fmt.Sprintf("elasticsearch.thread_pool.%s.%s", pool, stat)
+					agg.Add(metricType, metric.NewMetric(name, value, nodeTags))
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (e *Elasticsearch) collectIndices(agg metric.Aggregator, conf *instanceConfig, tags []string) error {
+	var indices []indexStats
+	if err := conf.GetJSON(conf.URL+"/_cat/indices?format=json&bytes=b", &indices); err != nil {
+		return err
+	}
+
+	for _, index := range indices {
+		indexTags := append(copyTags(tags), "index_name:"+index["index"])
+		if health := index["health"]; health != "" {
+			indexTags = append(indexTags, "health:"+health)
+		}
+
+		fields := make(map[string]interface{})
+		for name, key := range map[string]string{
+			"docs.count":     "docs.count",
+			"docs.deleted":   "docs.deleted",
+			"store.size":     "store.size",
+			"pri.store.size": "pri.store.size",
+			"primary_shards": "pri",
+			"replica_shards": "rep",
+		} {
+			// Closed indices report no stats.
+			value, err := strconv.ParseFloat(index[key], 64)
+			if err != nil {
+				continue
+			}
+			fields[name] = value
+		}
+		agg.AddMetrics("gauge", "elasticsearch.index", fields, indexTags, "")
+	}
+
+	return nil
+}
+
+func submit(agg metric.Aggregator, defs map[string]metricDef, data map[string]interface{}, tags []string) {
+	for name, def := range defs {
+		raw, ok := util.GetValue(data, def.path)
+		if !ok {
+			continue
+		}
+		value, ok := raw.(float64)
+		if !ok {
+			continue
+		}
+		agg.Add(def.metricType, metric.NewMetric(name, value/def.scale, tags))
+	}
+}
+
+func copyTags(tags []string) []string {
+	return append([]string{}, tags...)
+}
+
+func init() {
+	collector.Add("elasticsearch", NewElasticsearch)
+}
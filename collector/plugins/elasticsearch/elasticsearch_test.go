@@ -0,0 +1,113 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const healthResponse = `{
+  "cluster_name": "test",
+  "status": "yellow",
+  "number_of_nodes": 1,
+  "number_of_data_nodes": 1,
+  "active_primary_shards": 5,
+  "active_shards": 5,
+  "relocating_shards": 0,
+  "initializing_shards": 0,
+  "unassigned_shards": 5
+}`
+
+const nodesResponse = `{
+  "cluster_name": "test",
+  "nodes": {
+    "SDFsfSDFsdfFSDSDfSFDSDF": {
+      "name": "node-1",
+      "indices": {
+        "docs": {"count": 100, "deleted": 2},
+        "store": {"size_in_bytes": 2048},
+        "search": {"query_total": 10, "query_time_in_millis": 2000}
+      },
+      "jvm": {
+        "mem": {"heap_used_in_bytes": 512, "heap_used_percent": 50, "heap_max_in_bytes": 1024}
+      },
+      "thread_pool": {
+        "search": {"threads": 4, "queue": 0, "active": 1, "rejected": 3, "completed": 42}
+      }
+    }
+  }
+}`
+
+const indicesResponse = `[
+  {"health": "yellow", "status": "open", "index": "twitter", "pri": "5", "rep": "1",
+   "docs.count": "1200", "docs.deleted": "0", "store.size": "4096", "pri.store.size": "4096"},
+  {"health": "red", "status": "close", "index": "closed"}
+]`
+
+func newServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_cluster/health":
+			fmt.Fprintln(w, healthResponse)
+		case "/_nodes/_local/stats", "/_nodes/stats":
+			fmt.Fprintln(w, nodesResponse)
+		case "/_cat/indices":
+			assert.Equal(t, "json", r.URL.Query().Get("format"))
+			fmt.Fprintln(w, indicesResponse)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestCheck(t *testing.T) {
+	server := newServer(t)
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	es := NewElasticsearch(nil)
+	err := es.Check(agg, plugin.Instance{
+		"url":         server.URL,
+		"index_stats": true,
+		"tags":        []interface{}{"env:test"},
+	})
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"elasticsearch.cluster_status", []string{"env:test", "cluster_name:test"}, 1},
+		{"elasticsearch.unassigned_shards", []string{"cluster_name:test"}, 5},
+		{"elasticsearch.docs.count", []string{"node_name:node-1"}, 100},
+		{"elasticsearch.search.query.time", []string{"node_name:node-1"}, 2},
+		{"jvm.mem.heap_in_use", []string{"node_name:node-1"}, 0.5},
+		{"elasticsearch.thread_pool.search.rejected", []string{"node_name:node-1"}, 3},
+		{"elasticsearch.index.docs.count", []string{"index_name:twitter", "health:yellow"}, 1200},
+		{"elasticsearch.index.replica_shards", []string{"index_name:twitter"}, 1},
+	} {
+		value, ok := agg.Value(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s not found", c.name) {
+			assert.Equal(t, c.value, value, c.name)
+		}
+	}
+
+	m, _ := agg.Get("elasticsearch.thread_pool.search.rejected")
+	assert.Equal(t, "rate", m.Type)
+
+	_, ok := agg.Get("elasticsearch.index.docs.count", "index_name:closed")
+	assert.False(t, ok)
+}
+
+func TestCheckWithoutURL(t *testing.T) {
+	es := NewElasticsearch(nil)
+	err := es.Check(&metric.MockAggregator{}, plugin.Instance{})
+	assert.Error(t, err)
+}
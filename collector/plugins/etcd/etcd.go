@@ -0,0 +1,200 @@
+package etcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/prometheus"
+)
+
+// NewEtcd XXX
+func NewEtcd(conf plugin.InitConfig) plugin.Plugin {
+	return &Etcd{
+		histograms: make(map[string]map[string]*prometheus.Histogram),
+	}
+}
+
+// Etcd collects the health and the Prometheus metrics of an etcd v3 member.
+type Etcd struct {
+	sync.Mutex
+
+	// histograms keeps the last scraped latency histograms by URL, we report
+	// the latencies of the observations made between two checks. Only the
+	// histograms of the last scrape are kept, so that the label sets which
+	// are gone don't pile up.
+	histograms map[string]map[string]*prometheus.Histogram
+}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	URL  string   `yaml:"url"`
+	Tags []string `yaml:"tags"`
+}
+
+type metricDef struct {
+	name       string
+	metricType string
+}
+
+var metricDefs = map[string]metricDef{
+	"etcd_server_has_leader":                              {"etcd.server.has_leader", "gauge"},
+	"etcd_server_is_leader":                               {"etcd.server.is_leader", "gauge"},
+	"etcd_server_leader_changes_seen_total":               {"etcd.server.leader_changes_seen", "rate"},
+	"etcd_server_proposals_committed_total":               {"etcd.server.proposals_committed", "rate"},
+	"etcd_server_proposals_applied_total":                 {"etcd.server.proposals_applied", "rate"},
+	"etcd_server_proposals_pending":                       {"etcd.server.proposals_pending", "gauge"},
+	"etcd_server_proposals_failed_total":                  {"etcd.server.proposals_failed", "rate"},
+	"etcd_server_slow_apply_total":                        {"etcd.server.slow_apply", "rate"},
+	"etcd_mvcc_db_total_size_in_bytes":                    {"etcd.mvcc.db_total_size", "gauge"},
+	"etcd_debugging_mvcc_db_total_size_in_bytes":          {"etcd.mvcc.db_total_size", "gauge"},
+	"etcd_mvcc_db_total_size_in_use_in_bytes":             {"etcd.mvcc.db_total_size_in_use", "gauge"},
+	"etcd_debugging_mvcc_keys_total":                      {"etcd.mvcc.keys", "gauge"},
+	"etcd_network_client_grpc_received_bytes_total":       {"etcd.network.client_grpc_received_bytes", "rate"},
+	"etcd_network_client_grpc_sent_bytes_total":           {"etcd.network.client_grpc_sent_bytes", "rate"},
+	"etcd_network_peer_received_bytes_total":              {"etcd.network.peer_received_bytes", "rate"},
+	"etcd_network_peer_sent_bytes_total":                  {"etcd.network.peer_sent_bytes", "rate"},
+	"grpc_server_started_total":                           {"etcd.grpc.server.started", "rate"},
+	"grpc_server_handled_total":                           {"etcd.grpc.server.handled", "rate"},
+	"process_open_fds":                                    {"etcd.process.open_fds", "gauge"},
+	"process_max_fds":                                     {"etcd.process.max_fds", "gauge"},
+	"process_resident_memory_bytes":                       {"etcd.process.resident_memory", "gauge"},
+	"etcd_disk_wal_fsync_duration_seconds":                {"etcd.disk.wal_fsync_duration", "histogram"},
+	"etcd_disk_backend_commit_duration_seconds":           {"etcd.disk.backend_commit_duration", "histogram"},
+	"etcd_network_peer_round_trip_time_seconds":           {"etcd.network.peer_round_trip_time", "histogram"},
+	"grpc_server_handling_seconds":                        {"etcd.grpc.server.handling", "histogram"},
+	"etcd_server_apply_duration_seconds":                  {"etcd.server.apply_duration", "histogram"},
+	"etcd_mvcc_db_compaction_total_duration_milliseconds": {"etcd.mvcc.db_compaction_total_duration", "histogram"},
+}
+
+// Check XXX
+func (e *Etcd) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.URL == "" {
+		conf.URL = "http://localhost:2379"
+	}
+	conf.URL = strings.TrimSuffix(conf.URL, "/")
+	tags := append(append([]string{}, conf.Tags...), "url:"+conf.URL)
+
+	healthy, err := e.checkHealth(&conf)
+	agg.Add("gauge", metric.NewMetric("etcd.health", healthy, tags))
+	if err != nil {
+		return err
+	}
+
+	resp, err := conf.Get(conf.URL + "/metrics")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	families, err := prometheus.Parse(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not parse the metrics of %s: %s", conf.URL, err)
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	prev := e.histograms[conf.URL]
+	seen := make(map[string]*prometheus.Histogram)
+	for _, f := range families {
+		def, ok := metricDefs[f.Name]
+		if !ok {
+			continue
+		}
+
+		if def.metricType == "histogram" {
+			for _, h := range f.Histograms() {
+				submitHistogram(agg, prev, seen, def.name, h, tags)
+			}
+			continue
+		}
+
+		for _, s := range f.Samples {
+			agg.Add(def.metricType, metric.NewMetric(def.name, s.Value, labelsToTags(s.Labels, tags)))
+		}
+	}
+	e.histograms[conf.URL] = seen
+
+	return nil
+}
+
+func (e *Etcd) checkHealth(conf *instanceConfig) (int, error) {
+	resp, err := conf.Get(conf.URL + "/health")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	var health struct {
+		Health interface{} `json:"health"`
+	}
+	if err = json.Unmarshal(body, &health); err != nil {
+		return 0, fmt.Errorf("could not decode the health of %s: %s", conf.URL, err)
+	}
+
+	// etcd reports "true" as a string, newer versions may use a boolean.
+	if fmt.Sprintf("%v", health.Health) != "true" {
+		return 0, fmt.Errorf("etcd %s is unhealthy: %s", conf.URL, strings.TrimSpace(string(body)))
+	}
+	return 1, nil
+}
+
+// submitHistogram reports the observation rate, and the average and 99th
+// percentile of the observations made since the previous check, h is looked
+// up in the histograms of the previous scrape and recorded in seen.
+func submitHistogram(agg metric.Aggregator, previous, seen map[string]*prometheus.Histogram, name string, h *prometheus.Histogram, tags []string) {
+	metricTags := labelsToTags(h.Labels, tags)
+	agg.Add("rate", metric.NewMetric(name+".count", h.Count, metricTags))
+
+	key := name + prometheus.LabelsKey(h.Labels)
+	prev, ok := previous[key]
+	seen[key] = h
+	if !ok || h.Count < prev.Count {
+		// First run, or the counters have been reset by a restart.
+		return
+	}
+
+	d := h.Delta(prev)
+	if d.Count <= 0 {
+		return
+	}
+	agg.Add("gauge", metric.NewMetric(name+".avg", d.Sum/d.Count, metricTags))
+	agg.Add("gauge", metric.NewMetric(name+".99percentile", d.Quantile(0.99), metricTags))
+}
+
+func labelsToTags(labels map[string]string, tags []string) []string {
+	result := append([]string{}, tags...)
+	var names []string
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result = append(result, name+":"+labels[name])
+	}
+	return result
+}
+
+func init() {
+	collector.Add("etcd", NewEtcd)
+}
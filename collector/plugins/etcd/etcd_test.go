@@ -0,0 +1,129 @@
+package etcd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const metricsTemplate = `# TYPE etcd_server_has_leader gauge
+etcd_server_has_leader 1
+# TYPE etcd_server_leader_changes_seen_total counter
+etcd_server_leader_changes_seen_total 2
+# TYPE etcd_mvcc_db_total_size_in_bytes gauge
+etcd_mvcc_db_total_size_in_bytes 24576
+# TYPE grpc_server_handled_total counter
+grpc_server_handled_total{grpc_code="OK",grpc_method="Range",grpc_service="etcdserverpb.KV",grpc_type="unary"} 7
+# TYPE etcd_disk_wal_fsync_duration_seconds histogram
+etcd_disk_wal_fsync_duration_seconds_bucket{le="0.001"} %d
+etcd_disk_wal_fsync_duration_seconds_bucket{le="0.01"} %d
+etcd_disk_wal_fsync_duration_seconds_bucket{le="+Inf"} %d
+etcd_disk_wal_fsync_duration_seconds_sum %f
+etcd_disk_wal_fsync_duration_seconds_count %d
+# TYPE unrelated_metric gauge
+unrelated_metric 1
+`
+
+func TestCheck(t *testing.T) {
+	scrapes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			fmt.Fprint(w, `{"health": "true"}`)
+		case "/metrics":
+			if scrapes == 0 {
+				fmt.Fprintf(w, metricsTemplate, 10, 10, 10, 0.005, 10)
+			} else {
+				fmt.Fprintf(w, metricsTemplate, 10, 110, 110, 0.505, 110)
+			}
+			scrapes++
+		}
+	}))
+	defer server.Close()
+
+	e := NewEtcd(nil)
+	instance := plugin.Instance{"url": server.URL}
+	agg := &metric.MockAggregator{}
+	require.NoError(t, e.Check(agg, instance))
+
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"etcd.health", []string{"url:" + server.URL}, 1},
+		{"etcd.server.has_leader", nil, 1},
+		{"etcd.server.leader_changes_seen", nil, 2},
+		{"etcd.mvcc.db_total_size", nil, 24576},
+		{"etcd.grpc.server.handled", []string{"grpc_code:OK", "grpc_method:Range"}, 7},
+		{"etcd.disk.wal_fsync_duration.count", nil, 10},
+	} {
+		value, ok := agg.Value(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s not found", c.name) {
+			assert.Equal(t, c.value, value, c.name)
+		}
+	}
+	_, ok := agg.Get("etcd.disk.wal_fsync_duration.avg")
+	assert.False(t, ok, "no latency can be computed on the first run")
+	assert.NotContains(t, agg.Names(), "unrelated_metric")
+
+	// 100 observations in (0.001, 0.01] summing to 0.5s.
+	agg = &metric.MockAggregator{}
+	require.NoError(t, e.Check(agg, instance))
+	value, ok := agg.Value("etcd.disk.wal_fsync_duration.avg")
+	assert.True(t, ok)
+	assert.InDelta(t, 0.005, value, 1e-9)
+	value, ok = agg.Value("etcd.disk.wal_fsync_duration.99percentile")
+	assert.True(t, ok)
+	assert.InDelta(t, 0.00991, value, 1e-9)
+}
+
+func TestHistogramEviction(t *testing.T) {
+	members := []string{"a", "b"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			fmt.Fprint(w, `{"health": "true"}`)
+		case "/metrics":
+			fmt.Fprintln(w, "# TYPE etcd_network_peer_round_trip_time_seconds histogram")
+			for _, member := range members {
+				fmt.Fprintf(w, "etcd_network_peer_round_trip_time_seconds_bucket{To=%q,le=\"+Inf\"} 1\n", member)
+				fmt.Fprintf(w, "etcd_network_peer_round_trip_time_seconds_sum{To=%q} 0.1\n", member)
+				fmt.Fprintf(w, "etcd_network_peer_round_trip_time_seconds_count{To=%q} 1\n", member)
+			}
+		}
+	}))
+	defer server.Close()
+
+	e := NewEtcd(nil).(*Etcd)
+	instance := plugin.Instance{"url": server.URL}
+	require.NoError(t, e.Check(&metric.MockAggregator{}, instance))
+	assert.Len(t, e.histograms[server.URL], 2)
+
+	// The histograms of the members which are gone are dropped.
+	members = []string{"c"}
+	require.NoError(t, e.Check(&metric.MockAggregator{}, instance))
+	assert.Len(t, e.histograms[server.URL], 1)
+	for key := range e.histograms[server.URL] {
+		assert.Contains(t, key, `To="c"`)
+	}
+}
+
+func TestUnhealthy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"health": "false"}`)
+	}))
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	err := NewEtcd(nil).Check(agg, plugin.Instance{"url": server.URL})
+	assert.Error(t, err)
+	value, _ := agg.Value("etcd.health")
+	assert.Equal(t, float64(0), value)
+}
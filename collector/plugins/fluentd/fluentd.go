@@ -0,0 +1,94 @@
+package fluentd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewFluentd XXX
+func NewFluentd(conf plugin.InitConfig) plugin.Plugin {
+	return &Fluentd{}
+}
+
+// Fluentd reports the buffers and the retries of the Fluentd output plugins
+// from the monitor_agent input plugin.
+type Fluentd struct{}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	// MonitorAgentURL is the URL of plugins.json, e.g.
+	// http://localhost:24220/api/plugins.json
+	MonitorAgentURL string `yaml:"monitor_agent_url"`
+	// PluginIDs are the @id of the plugins to report, all the plugins with
+	// a buffer by default.
+	PluginIDs []string `yaml:"plugin_ids"`
+	Tags      []string `yaml:"tags"`
+}
+
+type plugins struct {
+	Plugins []struct {
+		PluginID              string   `json:"plugin_id"`
+		Type                  string   `json:"type"`
+		BufferQueueLength     *float64 `json:"buffer_queue_length"`
+		BufferTotalQueuedSize *float64 `json:"buffer_total_queued_size"`
+		RetryCount            *float64 `json:"retry_count"`
+	} `json:"plugins"`
+}
+
+// Check XXX
+func (f *Fluentd) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.MonitorAgentURL == "" {
+		return fmt.Errorf("a configured monitor_agent_url is required")
+	}
+
+	var resp plugins
+	if err := conf.GetJSON(conf.MonitorAgentURL, &resp); err != nil {
+		return err
+	}
+
+	for _, p := range resp.Plugins {
+		// Only the plugins with a buffer report the metrics.
+		if p.BufferQueueLength == nil || !conf.wants(p.PluginID) {
+			continue
+		}
+		tags := append(append([]string{}, conf.Tags...),
+			"plugin_id:"+p.PluginID,
+			"type:"+p.Type,
+		)
+		for name, value := range map[string]*float64{
+			"fluentd.buffer_queue_length":      p.BufferQueueLength,
+			"fluentd.buffer_total_queued_size": p.BufferTotalQueuedSize,
+			"fluentd.retry_count":              p.RetryCount,
+		} {
+			if value != nil {
+				agg.Add("gauge", metric.NewMetric(name, *value, tags))
+			}
+		}
+	}
+	return nil
+}
+
+func (conf *instanceConfig) wants(id string) bool {
+	if len(conf.PluginIDs) == 0 {
+		return true
+	}
+	for _, i := range conf.PluginIDs {
+		if strings.EqualFold(i, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	collector.Add("fluentd", NewFluentd)
+}
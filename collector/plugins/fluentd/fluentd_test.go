@@ -0,0 +1,62 @@
+package fluentd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/plugins.json", r.URL.Path)
+		fmt.Fprintln(w, `{"plugins": [
+			{"plugin_id": "in_forward", "plugin_category": "input", "type": "forward", "output_plugin": false,
+			 "retry_count": null},
+			{"plugin_id": "out_es", "plugin_category": "output", "type": "elasticsearch", "output_plugin": true,
+			 "buffer_queue_length": 4, "buffer_total_queued_size": 1048576, "retry_count": 7},
+			{"plugin_id": "out_s3", "plugin_category": "output", "type": "s3", "output_plugin": true,
+			 "buffer_queue_length": 0, "buffer_total_queued_size": 0, "retry_count": 0}
+		]}`)
+	}))
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	url := server.URL + "/api/plugins.json"
+	require.NoError(t, NewFluentd(nil).Check(agg, plugin.Instance{"monitor_agent_url": url}))
+	for _, c := range []struct {
+		name  string
+		value float64
+	}{
+		{"fluentd.buffer_queue_length", 4},
+		{"fluentd.buffer_total_queued_size", 1048576},
+		{"fluentd.retry_count", 7},
+	} {
+		value, ok := agg.Value(c.name, "plugin_id:out_es", "type:elasticsearch")
+		if assert.True(t, ok, "metric %s not found", c.name) {
+			assert.Equal(t, c.value, value, c.name)
+		}
+	}
+	_, ok := agg.Get("fluentd.buffer_queue_length", "plugin_id:out_s3")
+	assert.True(t, ok)
+	_, ok = agg.Get("fluentd.retry_count", "plugin_id:in_forward")
+	assert.False(t, ok)
+
+	agg = &metric.MockAggregator{}
+	require.NoError(t, NewFluentd(nil).Check(agg, plugin.Instance{
+		"monitor_agent_url": url,
+		"plugin_ids":        []interface{}{"out_s3"},
+	}))
+	_, ok = agg.Get("fluentd.retry_count", "plugin_id:out_es")
+	assert.False(t, ok)
+	assert.Len(t, agg.Metrics, 3)
+}
+
+func TestCheckInvalidConfig(t *testing.T) {
+	assert.Error(t, NewFluentd(nil).Check(&metric.MockAggregator{}, plugin.Instance{}))
+}
@@ -0,0 +1,180 @@
+package gluster
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// runCommand is replaced in tests.
+var runCommand = util.RunCommand
+
+// NewGluster XXX
+func NewGluster(conf plugin.InitConfig) plugin.Plugin {
+	return &Gluster{}
+}
+
+// Gluster collects the status and the usage of the bricks of the GlusterFS
+// volumes and, when profiling is started on them, the latency of their file
+// operations with the gluster command line tool.
+type Gluster struct{}
+
+type instanceConfig struct {
+	Gluster string `yaml:"gluster"`
+	UseSudo bool   `yaml:"use_sudo"`
+	// Profile collects the latencies, profiling must be started on the
+	// volumes with gluster volume profile <volume> start.
+	Profile bool     `yaml:"profile"`
+	Timeout int      `yaml:"timeout"`
+	Tags    []string `yaml:"tags"`
+}
+
+type cliOutput struct {
+	OpRet    int    `xml:"opRet"`
+	OpErrstr string `xml:"opErrstr"`
+}
+
+type volumeStatus struct {
+	cliOutput
+	Volumes []struct {
+		Name  string `xml:"volName"`
+		Nodes []struct {
+			Hostname    string  `xml:"hostname"`
+			Path        string  `xml:"path"`
+			Status      int     `xml:"status"`
+			SizeTotal   float64 `xml:"sizeTotal"`
+			SizeFree    float64 `xml:"sizeFree"`
+			InodesTotal float64 `xml:"inodesTotal"`
+			InodesFree  float64 `xml:"inodesFree"`
+		} `xml:"node"`
+	} `xml:"volStatus>volumes>volume"`
+}
+
+type volumeProfile struct {
+	cliOutput
+	Bricks []struct {
+		Name string `xml:"brickName"`
+		Fops []struct {
+			Name string  `xml:"name"`
+			Hits float64 `xml:"hits"`
+			// The latencies are in microseconds.
+			AvgLatency float64 `xml:"avgLatency"`
+			MaxLatency float64 `xml:"maxLatency"`
+		} `xml:"cumulativeStats>fopStats>fop"`
+	} `xml:"volProfile>brick"`
+}
+
+// Check XXX
+func (g *Gluster) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.Gluster == "" {
+		conf.Gluster = "gluster"
+	}
+
+	var status volumeStatus
+	if err := conf.run(&status, "volume", "status", "all", "detail"); err != nil {
+		return err
+	}
+
+	for _, v := range status.Volumes {
+		tags := append(append([]string{}, conf.Tags...), "volume:"+v.Name)
+		online := 0
+		for _, n := range v.Nodes {
+			brickTags := append(append([]string{}, tags...), "brick:"+n.Hostname+":"+n.Path)
+			agg.Add("gauge", metric.NewMetric("gluster.brick.online", n.Status, brickTags))
+			online += n.Status
+			if n.Status != 1 {
+				// The usage of offline bricks is unknown.
+				continue
+			}
+			agg.Add("gauge", metric.NewMetric("gluster.brick.size.total", n.SizeTotal, brickTags))
+			agg.Add("gauge", metric.NewMetric("gluster.brick.size.free", n.SizeFree, brickTags))
+			agg.Add("gauge", metric.NewMetric("gluster.brick.size.used", n.SizeTotal-n.SizeFree, brickTags))
+			agg.Add("gauge", metric.NewMetric("gluster.brick.inodes.total", n.InodesTotal, brickTags))
+			agg.Add("gauge", metric.NewMetric("gluster.brick.inodes.free", n.InodesFree, brickTags))
+			agg.Add("gauge", metric.NewMetric("gluster.brick.inodes.used", n.InodesTotal-n.InodesFree, brickTags))
+			if n.InodesTotal > 0 {
+				agg.Add("gauge", metric.NewMetric("gluster.brick.inodes.in_use",
+					(n.InodesTotal-n.InodesFree)/n.InodesTotal*100, brickTags))
+			}
+		}
+		agg.Add("gauge", metric.NewMetric("gluster.volume.bricks.total", len(v.Nodes), tags))
+		agg.Add("gauge", metric.NewMetric("gluster.volume.bricks.online", online, tags))
+
+		if conf.Profile {
+			if err := conf.submitProfile(agg, v.Name, tags); err != nil {
+				metric.Logger(agg).Warnf("Could not get the profile of volume %s: %s", v.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// submitProfile reports the latencies of the file operations of the volume,
+// averaged over its bricks.
+func (conf *instanceConfig) submitProfile(agg metric.Aggregator, volume string, tags []string) error {
+	var profile volumeProfile
+	if err := conf.run(&profile, "volume", "profile", volume, "info", "cumulative"); err != nil {
+		return err
+	}
+
+	hits := make(map[string]float64)
+	latencies := make(map[string]float64)
+	maxLatencies := make(map[string]float64)
+	for _, b := range profile.Bricks {
+		for _, f := range b.Fops {
+			hits[f.Name] += f.Hits
+			latencies[f.Name] += f.Hits * f.AvgLatency
+			if f.MaxLatency > maxLatencies[f.Name] {
+				maxLatencies[f.Name] = f.MaxLatency
+			}
+		}
+	}
+
+	for fop, h := range hits {
+		fopTags := append(append([]string{}, tags...), "fop:"+fop)
+		agg.Add("rate", metric.NewMetric("gluster.volume.fop.hits", h, fopTags))
+		if h > 0 {
+			agg.Add("gauge", metric.NewMetric("gluster.volume.fop.latency.avg", latencies[fop]/h/1e6, fopTags))
+		}
+		agg.Add("gauge", metric.NewMetric("gluster.volume.fop.latency.max", maxLatencies[fop]/1e6, fopTags))
+	}
+	return nil
+}
+
+// run runs the gluster command with --xml and decodes its output into v,
+// which embeds cliOutput.
+func (conf *instanceConfig) run(v interface{ result() *cliOutput }, args ...string) error {
+	args = append(args, "--xml")
+	name := conf.Gluster
+	if conf.UseSudo {
+		name, args = "sudo", append([]string{conf.Gluster}, args...)
+	}
+	out, err := runCommand(time.Duration(conf.Timeout)*time.Second, name, args...)
+	if err != nil {
+		return err
+	}
+	if err = xml.Unmarshal(out, v); err != nil {
+		return fmt.Errorf("could not decode the gluster output: %s", err)
+	}
+	if r := v.result(); r.OpRet != 0 {
+		return fmt.Errorf("gluster failed: %s", r.OpErrstr)
+	}
+	return nil
+}
+
+func (c *cliOutput) result() *cliOutput {
+	return c
+}
+
+func init() {
+	collector.Add("gluster", NewGluster)
+}
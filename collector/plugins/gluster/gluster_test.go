@@ -0,0 +1,75 @@
+package gluster
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	status, err := ioutil.ReadFile("testdata/status.xml")
+	require.NoError(t, err)
+	profile, err := ioutil.ReadFile("testdata/profile.xml")
+	require.NoError(t, err)
+
+	var commands []string
+	runCommand = func(timeout time.Duration, name string, args ...string) ([]byte, error) {
+		command := name + " " + strings.Join(args, " ")
+		commands = append(commands, command)
+		if strings.Contains(command, "profile") {
+			return profile, nil
+		}
+		return status, nil
+	}
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewGluster(nil).Check(agg, plugin.Instance{
+		"use_sudo": true,
+		"profile":  true,
+	}))
+	assert.Equal(t, []string{
+		"sudo gluster volume status all detail --xml",
+		"sudo gluster volume profile gv0 info cumulative --xml",
+	}, commands)
+
+	brick1 := []string{"volume:gv0", "brick:gfs01:/data/brick1/gv0"}
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"gluster.brick.online", brick1, 1},
+		{"gluster.brick.size.used", brick1, 10732175360},
+		{"gluster.brick.inodes.used", brick1, 30720},
+		{"gluster.brick.inodes.in_use", brick1, 0.05859375},
+		{"gluster.brick.online", []string{"brick:gfs02:/data/brick1/gv0"}, 0},
+		{"gluster.volume.bricks.total", []string{"volume:gv0"}, 2},
+		{"gluster.volume.bricks.online", []string{"volume:gv0"}, 1},
+		{"gluster.volume.fop.hits", []string{"volume:gv0", "fop:WRITE"}, 400},
+		{"gluster.volume.fop.latency.avg", []string{"volume:gv0", "fop:WRITE"}, 0.0003},
+		{"gluster.volume.fop.latency.max", []string{"volume:gv0", "fop:WRITE"}, 0.012},
+		{"gluster.volume.fop.latency.avg", []string{"volume:gv0", "fop:LOOKUP"}, 0.00004},
+	} {
+		value, ok := agg.Value(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			assert.InDelta(t, c.value, value, 1e-9, c.name)
+		}
+	}
+	_, ok := agg.Get("gluster.brick.size.total", "brick:gfs02:/data/brick1/gv0")
+	assert.False(t, ok)
+}
+
+func TestCheckFailed(t *testing.T) {
+	runCommand = func(timeout time.Duration, name string, args ...string) ([]byte, error) {
+		return []byte(`<?xml version="1.0"?><cliOutput><opRet>-1</opRet><opErrno>30800</opErrno>
+			<opErrstr>Another transaction is in progress. Please try again after some time.</opErrstr></cliOutput>`), nil
+	}
+	err := NewGluster(nil).Check(&metric.MockAggregator{}, plugin.Instance{})
+	assert.EqualError(t, err, "gluster failed: Another transaction is in progress. Please try again after some time.")
+}
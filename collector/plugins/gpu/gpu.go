@@ -0,0 +1,174 @@
+package gpu
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// MiB is the unit of the memory reported by nvidia-smi.
+const MiB = 1024 * 1024
+
+// runCommand is replaced in tests.
+var runCommand = util.RunCommand
+
+// NewGPU XXX
+func NewGPU(conf plugin.InitConfig) plugin.Plugin {
+	return &GPU{}
+}
+
+// GPU collects the utilization, memory, temperature and power of the NVIDIA
+// GPUs as reported by nvidia-smi.
+type GPU struct{}
+
+type instanceConfig struct {
+	NvidiaSmi string `yaml:"nvidia_smi"`
+	// ProcessMetrics reports the GPU memory used by every compute process.
+	ProcessMetrics *bool    `yaml:"process_metrics"`
+	Timeout        int      `yaml:"timeout"`
+	Tags           []string `yaml:"tags"`
+}
+
+type field struct {
+	query string
+	name  string
+	scale float64
+}
+
+// gpuFields are queried with nvidia-smi --query-gpu, index and uuid come
+// first to tag the others.
+var gpuFields = []field{
+	{"index", "", 0},
+	{"uuid", "", 0},
+	{"name", "", 0},
+	{"utilization.gpu", "gpu.utilization", 1},
+	{"utilization.memory", "gpu.memory.utilization", 1},
+	{"memory.used", "gpu.memory.used", MiB},
+	{"memory.free", "gpu.memory.free", MiB},
+	{"memory.total", "gpu.memory.total", MiB},
+	{"temperature.gpu", "gpu.temperature", 1},
+	{"power.draw", "gpu.power.draw", 1},
+	{"power.limit", "gpu.power.limit", 1},
+	{"fan.speed", "gpu.fan_speed", 1},
+	{"clocks.sm", "gpu.clock.sm", 1},
+	{"clocks.mem", "gpu.clock.mem", 1},
+}
+
+var processFields = []string{"gpu_uuid", "pid", "process_name", "used_memory"}
+
+// Check XXX
+func (g *GPU) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.NvidiaSmi == "" {
+		conf.NvidiaSmi = "nvidia-smi"
+	}
+	timeout := time.Duration(conf.Timeout) * time.Second
+
+	var queries []string
+	for _, f := range gpuFields {
+		queries = append(queries, f.query)
+	}
+	rows, err := query(conf.NvidiaSmi, timeout, "--query-gpu="+strings.Join(queries, ","))
+	if err != nil {
+		return err
+	}
+
+	// The processes report the uuid of their GPU.
+	gpuTags := make(map[string][]string)
+	for _, row := range rows {
+		if len(row) != len(gpuFields) {
+			return fmt.Errorf("unexpected output of nvidia-smi: %q", strings.Join(row, ", "))
+		}
+		tags := append(append([]string{}, conf.Tags...),
+			"gpu_index:"+row[0],
+			"gpu_uuid:"+row[1],
+			"gpu_name:"+row[2],
+		)
+		gpuTags[row[1]] = tags
+
+		for i, f := range gpuFields {
+			if f.name == "" {
+				continue
+			}
+			if value, ok := parseValue(row[i]); ok {
+				agg.Add("gauge", metric.NewMetric(f.name, value*f.scale, tags))
+			}
+		}
+	}
+
+	if conf.ProcessMetrics != nil && !*conf.ProcessMetrics {
+		return nil
+	}
+
+	rows, err = query(conf.NvidiaSmi, timeout, "--query-compute-apps="+strings.Join(processFields, ","))
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if len(row) != len(processFields) {
+			continue
+		}
+		tags, ok := gpuTags[row[0]]
+		if !ok {
+			continue
+		}
+		value, ok := parseValue(row[3])
+		if !ok {
+			continue
+		}
+		processTags := append(append([]string{}, tags...),
+			"pid:"+row[1],
+			"process_name:"+filepath.Base(row[2]),
+		)
+		agg.Add("gauge", metric.NewMetric("gpu.process.memory.used", value*MiB, processTags))
+	}
+
+	return nil
+}
+
+func query(nvidiaSmi string, timeout time.Duration, q string) ([][]string, error) {
+	out, err := runCommand(timeout, nvidiaSmi, q, "--format=csv,noheader,nounits")
+	if err != nil {
+		return nil, err
+	}
+
+	r := csv.NewReader(bytes.NewReader(out))
+	r.TrimLeadingSpace = true
+	r.FieldsPerRecord = -1
+
+	var rows [][]string
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the output of nvidia-smi: %s", err)
+		}
+		rows = append(rows, row)
+	}
+}
+
+// parseValue parses the numbers of nvidia-smi, which reports [N/A] or
+// [Not Supported] for what the GPU can't measure.
+func parseValue(s string) (float64, bool) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return value, err == nil
+}
+
+func init() {
+	collector.Add("gpu", NewGPU)
+}
@@ -0,0 +1,60 @@
+package gpu
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const gpuOutput = `0, GPU-5a1d, Tesla V100-SXM2-16GB, 87, 40, 8000, 8160, 16160, 65, 250.12, 300.00, [N/A], 1530, 877
+1, GPU-9f2c, Tesla V100-SXM2-16GB, 0, 0, 0, 16160, 16160, 34, 41.50, 300.00, [Not Supported], 135, 877
+`
+
+const processOutput = `GPU-5a1d, 4242, /usr/bin/python3, 7990
+`
+
+func TestCheck(t *testing.T) {
+	runCommand = func(timeout time.Duration, name string, args ...string) ([]byte, error) {
+		assert.Equal(t, "/usr/bin/nvidia-smi", name)
+		assert.Equal(t, "--format=csv,noheader,nounits", args[1])
+		if strings.HasPrefix(args[0], "--query-gpu=index,uuid,") {
+			return []byte(gpuOutput), nil
+		}
+		return []byte(processOutput), nil
+	}
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewGPU(nil).Check(agg, plugin.Instance{"nvidia_smi": "/usr/bin/nvidia-smi"}))
+
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"gpu.utilization", []string{"gpu_index:0", "gpu_uuid:GPU-5a1d", "gpu_name:Tesla V100-SXM2-16GB"}, 87},
+		{"gpu.memory.used", []string{"gpu_index:0"}, 8000 * MiB},
+		{"gpu.memory.total", []string{"gpu_index:1"}, 16160 * MiB},
+		{"gpu.temperature", []string{"gpu_index:1"}, 34},
+		{"gpu.power.draw", []string{"gpu_index:0"}, 250.12},
+		{"gpu.process.memory.used", []string{"gpu_index:0", "pid:4242", "process_name:python3"}, 7990 * MiB},
+	} {
+		value, ok := agg.Value(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			assert.Equal(t, c.value, value, c.name)
+		}
+	}
+	assert.NotContains(t, agg.Names(), "gpu.fan_speed")
+}
+
+func TestCheckError(t *testing.T) {
+	runCommand = func(timeout time.Duration, name string, args ...string) ([]byte, error) {
+		return nil, fmt.Errorf("nvidia-smi failed: exit status 9: NVIDIA-SMI has failed")
+	}
+	assert.Error(t, NewGPU(nil).Check(&metric.MockAggregator{}, plugin.Instance{}))
+}
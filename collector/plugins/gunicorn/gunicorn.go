@@ -0,0 +1,129 @@
+package gunicorn
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// Statuses of the master process.
+const (
+	StatusOK       = 0
+	StatusCritical = 2
+)
+
+// NewGunicorn XXX
+func NewGunicorn(conf plugin.InitConfig) plugin.Plugin {
+	return &Gunicorn{
+		ps:       &systemPS{},
+		cpuTimes: make(map[int32]float64),
+	}
+}
+
+// Gunicorn counts the working and idle workers of a Gunicorn application. It
+// relies on the process titles set by Gunicorn when setproctitle is
+// installed, e.g. "gunicorn: worker [app]". A worker is working when it used
+// CPU since the previous check.
+type Gunicorn struct {
+	sync.Mutex
+
+	ps PS
+	// cpuTimes keeps the CPU time of the workers at the previous check.
+	cpuTimes map[int32]float64
+}
+
+type instanceConfig struct {
+	// ProcName is the name of the application, set with the --name option
+	// of Gunicorn.
+	ProcName string   `yaml:"proc_name"`
+	Tags     []string `yaml:"tags"`
+}
+
+// Check XXX
+func (g *Gunicorn) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.ProcName == "" {
+		return fmt.Errorf("a configured proc_name is required")
+	}
+	tags := append(append([]string{}, conf.Tags...), "app:"+conf.ProcName)
+
+	pids, err := g.ps.Pids()
+	if err != nil {
+		return err
+	}
+
+	master := "gunicorn: master [" + conf.ProcName + "]"
+	worker := "gunicorn: worker [" + conf.ProcName + "]"
+	var masters int
+	var workers []int32
+	for _, pid := range pids {
+		// Processes vanish while we're listing them.
+		cmdline, err := g.ps.Cmdline(pid)
+		if err != nil {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(cmdline, master):
+			masters++
+		case strings.HasPrefix(cmdline, worker):
+			workers = append(workers, pid)
+		}
+	}
+
+	status := StatusOK
+	if masters == 0 {
+		status = StatusCritical
+	}
+	agg.Add("gauge", metric.NewMetric("gunicorn.status", status, tags))
+	if masters == 0 {
+		return fmt.Errorf("no gunicorn master process found for %s", conf.ProcName)
+	}
+
+	working, idle := g.countWorkers(workers)
+	agg.Add("gauge", metric.NewMetric("gunicorn.workers", working, append(append([]string{}, tags...), "state:working")))
+	agg.Add("gauge", metric.NewMetric("gunicorn.workers", idle, append(append([]string{}, tags...), "state:idle")))
+	return nil
+}
+
+// countWorkers compares the CPU times of the workers with the previous
+// check, the new workers are counted as idle.
+func (g *Gunicorn) countWorkers(pids []int32) (working, idle int) {
+	g.Lock()
+	defer g.Unlock()
+
+	for _, pid := range pids {
+		cpu, err := g.ps.CPUTime(pid)
+		if err != nil {
+			continue
+		}
+		if last, ok := g.cpuTimes[pid]; ok && cpu > last {
+			working++
+		} else {
+			idle++
+		}
+		g.cpuTimes[pid] = cpu
+	}
+
+	// Forget the workers which exited.
+	current := make(map[int32]bool, len(pids))
+	for _, pid := range pids {
+		current[pid] = true
+	}
+	for pid := range g.cpuTimes {
+		if !current[pid] {
+			delete(g.cpuTimes, pid)
+		}
+	}
+	return working, idle
+}
+
+func init() {
+	collector.Add("gunicorn", NewGunicorn)
+}
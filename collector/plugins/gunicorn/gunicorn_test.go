@@ -0,0 +1,91 @@
+package gunicorn
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProcess struct {
+	cmdline string
+	cpu     float64
+}
+
+type fakePS map[int32]*fakeProcess
+
+func (f fakePS) Pids() ([]int32, error) {
+	var pids []int32
+	for pid := range f {
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+func (f fakePS) Cmdline(pid int32) (string, error) {
+	p, ok := f[pid]
+	if !ok {
+		return "", fmt.Errorf("process %d not found", pid)
+	}
+	return p.cmdline, nil
+}
+
+func (f fakePS) CPUTime(pid int32) (float64, error) {
+	p, ok := f[pid]
+	if !ok {
+		return 0, fmt.Errorf("process %d not found", pid)
+	}
+	return p.cpu, nil
+}
+
+func TestCheck(t *testing.T) {
+	ps := fakePS{
+		100: {"gunicorn: master [app]", 12},
+		101: {"gunicorn: worker [app]", 3},
+		102: {"gunicorn: worker [app]", 5},
+		103: {"gunicorn: worker [app]", 1},
+		200: {"gunicorn: worker [other]", 8},
+		300: {"/usr/bin/python3 manage.py", 20},
+	}
+	g := &Gunicorn{ps: ps, cpuTimes: make(map[int32]float64)}
+	instance := plugin.Instance{"proc_name": "app", "tags": []interface{}{"env:prod"}}
+
+	check := func() (float64, float64) {
+		agg := &metric.MockAggregator{}
+		require.NoError(t, g.Check(agg, instance))
+		status, _ := agg.Value("gunicorn.status", "app:app", "env:prod")
+		assert.Equal(t, float64(StatusOK), status)
+		working, ok := agg.Value("gunicorn.workers", "app:app", "env:prod", "state:working")
+		assert.True(t, ok)
+		idle, ok := agg.Value("gunicorn.workers", "app:app", "env:prod", "state:idle")
+		assert.True(t, ok)
+		return working, idle
+	}
+
+	// All the workers are idle until their CPU time is known.
+	working, idle := check()
+	assert.Equal(t, float64(0), working)
+	assert.Equal(t, float64(3), idle)
+
+	ps[101].cpu = 4
+	ps[102].cpu = 5.5
+	delete(ps, 103)
+	ps[104] = &fakeProcess{"gunicorn: worker [app]", 0.2}
+	working, idle = check()
+	assert.Equal(t, float64(2), working)
+	assert.Equal(t, float64(1), idle)
+	assert.Len(t, g.cpuTimes, 3)
+}
+
+func TestCheckNoMaster(t *testing.T) {
+	g := &Gunicorn{ps: fakePS{101: {"gunicorn: worker [app]", 3}}, cpuTimes: make(map[int32]float64)}
+	agg := &metric.MockAggregator{}
+	assert.Error(t, g.Check(agg, plugin.Instance{"proc_name": "app"}))
+	status, _ := agg.Value("gunicorn.status", "app:app")
+	assert.Equal(t, float64(StatusCritical), status)
+
+	assert.Error(t, g.Check(agg, plugin.Instance{}))
+}
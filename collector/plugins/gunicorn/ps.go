@@ -0,0 +1,39 @@
+package gunicorn
+
+import (
+	"github.com/shirou/gopsutil/process"
+)
+
+// PS XXX
+type PS interface {
+	Pids() ([]int32, error)
+	Cmdline(pid int32) (string, error)
+	// CPUTime is the user and system time in seconds.
+	CPUTime(pid int32) (float64, error)
+}
+
+type systemPS struct{}
+
+func (s *systemPS) Pids() ([]int32, error) {
+	return process.Pids()
+}
+
+func (s *systemPS) Cmdline(pid int32) (string, error) {
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return "", err
+	}
+	return p.Cmdline()
+}
+
+func (s *systemPS) CPUTime(pid int32) (float64, error) {
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return 0, err
+	}
+	times, err := p.Times()
+	if err != nil {
+		return 0, err
+	}
+	return times.User + times.System, nil
+}
@@ -0,0 +1,234 @@
+package httpcheck
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// DefaultStatusCode matches the status codes considered as up by default.
+const DefaultStatusCode = `(1|2|3)\d\d`
+
+// The default numbers of days before the expiration of the certificate of
+// the endpoint under which http.ssl_cert is WARNING and CRITICAL.
+const (
+	DefaultDaysWarning  = 14
+	DefaultDaysCritical = 7
+)
+
+// maxContentSize bounds the part of the response body read to match
+// content_match.
+const maxContentSize = 1 << 20
+
+// NewHTTPCheck XXX
+func NewHTTPCheck(conf plugin.InitConfig) plugin.Plugin {
+	return &HTTPCheck{}
+}
+
+// HTTPCheck probes HTTP endpoints and reports their availability and
+// response time.
+type HTTPCheck struct{}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	Name   string `yaml:"name"`
+	URL    string `yaml:"url"`
+	Method string `yaml:"method"`
+	Data   string `yaml:"data"`
+	// StatusCode is a regular expression the status code must match.
+	StatusCode string `yaml:"http_response_status_code"`
+	// ContentMatch is a regular expression the response body must match,
+	// or must not match with ReverseContentMatch.
+	ContentMatch        string `yaml:"content_match"`
+	ReverseContentMatch bool   `yaml:"reverse_content_match"`
+	AllowRedirects      *bool  `yaml:"allow_redirects"`
+	// CheckCertificateExpiration reports the expiration of the certificate
+	// of the https URLs, it's enabled by default.
+	CheckCertificateExpiration *bool    `yaml:"check_certificate_expiration"`
+	DaysWarning                int      `yaml:"days_warning"`
+	DaysCritical               int      `yaml:"days_critical"`
+	Tags                       []string `yaml:"tags"`
+}
+
+// Check XXX
+func (h *HTTPCheck) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	conf, err := parseConfig(instance)
+	if err != nil {
+		return err
+	}
+
+	statusCode, err := regexp.Compile("^" + conf.StatusCode + "$")
+	if err != nil {
+		return fmt.Errorf("invalid http_response_status_code %q: %s", conf.StatusCode, err)
+	}
+	var contentMatch *regexp.Regexp
+	if conf.ContentMatch != "" {
+		if contentMatch, err = regexp.Compile(conf.ContentMatch); err != nil {
+			return fmt.Errorf("invalid content_match %q: %s", conf.ContentMatch, err)
+		}
+	}
+
+	tags := append(append([]string{}, conf.Tags...), "url:"+conf.URL, "instance:"+conf.Name)
+
+	resp, elapsed, err := probe(conf)
+	if err != nil {
+		metric.Logger(agg).Infof("%s is down: %s", conf.URL, err)
+		agg.Add("gauge", metric.NewMetric("network.http.can_connect", 0, tags))
+		addServiceCheck(agg, "http.can_connect", metric.ServiceCheckCritical, tags, err.Error())
+		return nil
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	agg.Add("gauge", metric.NewMetric("network.http.response_time", elapsed.Seconds(), tags))
+
+	code := strconv.Itoa(resp.StatusCode)
+	codeTags := append(append([]string{}, tags...), "status_code:"+code, "status_code_class:"+code[:1]+"xx")
+	agg.Add("gauge", metric.NewMetric("network.http.status_code", resp.StatusCode, codeTags))
+
+	var reason string
+	if !statusCode.MatchString(code) {
+		reason = fmt.Sprintf("Incorrect HTTP return code %s, expected %s", code, conf.StatusCode)
+	}
+
+	if contentMatch != nil {
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxContentSize))
+		if err != nil {
+			return fmt.Errorf("could not read the response of %s: %s", conf.URL, err)
+		}
+		matched := contentMatch.Match(body) != conf.ReverseContentMatch
+		agg.Add("gauge", metric.NewMetric("network.http.content_match", boolToInt(matched), tags))
+		if !matched && reason == "" {
+			if conf.ReverseContentMatch {
+				reason = fmt.Sprintf("Content %q found in the response", conf.ContentMatch)
+			} else {
+				reason = fmt.Sprintf("Content %q not found in the response", conf.ContentMatch)
+			}
+		}
+	}
+
+	if reason != "" {
+		metric.Logger(agg).Infof("%s is down: %s", conf.URL, reason)
+		agg.Add("gauge", metric.NewMetric("network.http.can_connect", 0, tags))
+		addServiceCheck(agg, "http.can_connect", metric.ServiceCheckCritical, tags, reason)
+	} else {
+		agg.Add("gauge", metric.NewMetric("network.http.can_connect", 1, tags))
+		addServiceCheck(agg, "http.can_connect", metric.ServiceCheckOK, tags, "")
+	}
+
+	if (conf.CheckCertificateExpiration == nil || *conf.CheckCertificateExpiration) &&
+		resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		notAfter := resp.TLS.PeerCertificates[0].NotAfter
+		now := time.Now()
+		daysLeft := notAfter.Sub(now).Hours() / 24
+		agg.Add("gauge", metric.NewMetric("network.http.ssl.days_left", daysLeft, tags))
+		status, message := certificateStatus(notAfter, now, conf.DaysWarning, conf.DaysCritical)
+		addServiceCheck(agg, "http.ssl_cert", status, tags, message)
+	}
+	return nil
+}
+
+// certificateStatus rates a certificate expiring at notAfter: CRITICAL when
+// it expires in less than daysCritical days, WARNING in less than
+// daysWarning days, OK otherwise.
+func certificateStatus(notAfter, now time.Time, daysWarning, daysCritical int) (int, string) {
+	left := notAfter.Sub(now)
+	if left <= 0 {
+		return metric.ServiceCheckCritical, fmt.Sprintf("Certificate expired on %s", notAfter.UTC().Format(time.RFC3339))
+	}
+
+	days := int(left.Hours() / 24)
+	message := fmt.Sprintf("Certificate expires in %d days, on %s", days, notAfter.UTC().Format(time.RFC3339))
+	switch {
+	case left < time.Duration(daysCritical)*24*time.Hour:
+		return metric.ServiceCheckCritical, message
+	case left < time.Duration(daysWarning)*24*time.Hour:
+		return metric.ServiceCheckWarning, message
+	}
+	return metric.ServiceCheckOK, message
+}
+
+func addServiceCheck(agg metric.Aggregator, name string, status int, tags []string, message string) {
+	sc := metric.NewServiceCheck(name, status, tags)
+	sc.Message = message
+	agg.AddServiceCheck(sc)
+}
+
+func parseConfig(instance plugin.Instance) (*instanceConfig, error) {
+	conf := &instanceConfig{}
+	if err := instance.Unmarshal(conf); err != nil {
+		return nil, err
+	}
+
+	if conf.URL == "" {
+		return nil, fmt.Errorf("a configured url is required")
+	}
+	if conf.Name == "" {
+		conf.Name = conf.URL
+	}
+	if conf.Method == "" {
+		conf.Method = "GET"
+	}
+	conf.Method = strings.ToUpper(conf.Method)
+	if conf.StatusCode == "" {
+		conf.StatusCode = DefaultStatusCode
+	}
+	if conf.DaysWarning <= 0 {
+		conf.DaysWarning = DefaultDaysWarning
+	}
+	if conf.DaysCritical <= 0 {
+		conf.DaysCritical = DefaultDaysCritical
+	}
+
+	return conf, nil
+}
+
+func probe(conf *instanceConfig) (*http.Response, time.Duration, error) {
+	client, err := conf.NewClient()
+	if err != nil {
+		return nil, 0, err
+	}
+	if conf.AllowRedirects != nil && !*conf.AllowRedirects {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	var body io.Reader
+	if conf.Data != "" {
+		body = strings.NewReader(conf.Data)
+	}
+	req, err := conf.NewRequest(conf.Method, conf.URL, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	return resp, time.Since(start), err
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func init() {
+	collector.Add("http_check", NewHTTPCheck)
+}
@@ -0,0 +1,155 @@
+package httpcheck
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			fmt.Fprintln(w, "status: all good")
+		case "/post":
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.Method != "POST" || string(body) != "ping" || r.Header.Get("X-Probe") != "1" {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		case "/redirect":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		default:
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	for _, c := range []struct {
+		name         string
+		instance     plugin.Instance
+		up           float64
+		status       float64
+		contentMatch float64
+		message      string
+	}{
+		{"ok", plugin.Instance{"url": server.URL + "/ok"}, 1, 200, -1, ""},
+		{"error", plugin.Instance{"url": server.URL + "/error"}, 0, 500, -1,
+			"Incorrect HTTP return code 500, expected (1|2|3)\\d\\d"},
+		{"expected error", plugin.Instance{"url": server.URL + "/error", "http_response_status_code": "5\\d\\d"}, 1, 500, -1, ""},
+		{"content match", plugin.Instance{"url": server.URL + "/ok", "content_match": "all good"}, 1, 200, 1, ""},
+		{"content mismatch", plugin.Instance{"url": server.URL + "/ok", "content_match": "^down"}, 0, 200, 0,
+			`Content "^down" not found in the response`},
+		{"reverse content match", plugin.Instance{"url": server.URL + "/ok", "content_match": "good",
+			"reverse_content_match": true}, 0, 200, 0, `Content "good" found in the response`},
+		{"post", plugin.Instance{"url": server.URL + "/post", "method": "post", "data": "ping",
+			"headers": map[string]string{"X-Probe": "1"}}, 1, 200, -1, ""},
+		{"redirect", plugin.Instance{"url": server.URL + "/redirect"}, 1, 200, -1, ""},
+		{"no redirect", plugin.Instance{"url": server.URL + "/redirect", "allow_redirects": false,
+			"http_response_status_code": "2\\d\\d"}, 0, 302, -1, "Incorrect HTTP return code 302, expected 2\\d\\d"},
+	} {
+		agg := &metric.MockAggregator{}
+		require.NoError(t, NewHTTPCheck(nil).Check(agg, c.instance), c.name)
+
+		value, _ := agg.Value("network.http.can_connect", "url:"+c.instance["url"].(string))
+		assert.Equal(t, c.up, value, c.name)
+		value, _ = agg.Value("network.http.status_code")
+		assert.Equal(t, c.status, value, c.name)
+		_, ok := agg.Value("network.http.response_time")
+		assert.True(t, ok, c.name)
+
+		value, ok = agg.Value("network.http.content_match")
+		if c.contentMatch < 0 {
+			assert.False(t, ok, c.name)
+		} else {
+			assert.Equal(t, c.contentMatch, value, c.name)
+		}
+
+		sc, ok := agg.GetServiceCheck("http.can_connect", "url:"+c.instance["url"].(string))
+		if assert.True(t, ok, c.name) {
+			if c.up == 1 {
+				assert.Equal(t, metric.ServiceCheckOK, sc.Status, c.name)
+			} else {
+				assert.Equal(t, metric.ServiceCheckCritical, sc.Status, c.name)
+			}
+			assert.Equal(t, c.message, sc.Message, c.name)
+		}
+		_, ok = agg.GetServiceCheck("http.ssl_cert")
+		assert.False(t, ok, c.name)
+	}
+}
+
+func TestCheckTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewHTTPCheck(nil).Check(agg, plugin.Instance{"url": server.URL, "disable_ssl_validation": true}))
+	sc, ok := agg.GetServiceCheck("http.ssl_cert")
+	if assert.True(t, ok) {
+		assert.Equal(t, metric.ServiceCheckOK, sc.Status)
+		assert.Contains(t, sc.Message, "Certificate expires in")
+	}
+	_, ok = agg.Value("network.http.ssl.days_left")
+	assert.True(t, ok)
+
+	agg = &metric.MockAggregator{}
+	require.NoError(t, NewHTTPCheck(nil).Check(agg, plugin.Instance{"url": server.URL, "disable_ssl_validation": true,
+		"check_certificate_expiration": false}))
+	_, ok = agg.GetServiceCheck("http.ssl_cert")
+	assert.False(t, ok)
+}
+
+func TestCertificateStatus(t *testing.T) {
+	now := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	for _, c := range []struct {
+		notAfter time.Time
+		status   int
+		message  string
+	}{
+		{now.Add(30 * day), metric.ServiceCheckOK, "Certificate expires in 30 days, on 2017-03-31T00:00:00Z"},
+		{now.Add(10 * day), metric.ServiceCheckWarning, "Certificate expires in 10 days, on 2017-03-11T00:00:00Z"},
+		{now.Add(2 * day), metric.ServiceCheckCritical, "Certificate expires in 2 days, on 2017-03-03T00:00:00Z"},
+		{now.Add(-day), metric.ServiceCheckCritical, "Certificate expired on 2017-02-28T00:00:00Z"},
+	} {
+		status, message := certificateStatus(c.notAfter, now, DefaultDaysWarning, DefaultDaysCritical)
+		assert.Equal(t, c.status, status, c.message)
+		assert.Equal(t, c.message, message)
+	}
+}
+
+func TestCheckDown(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewHTTPCheck(nil).Check(agg, plugin.Instance{"url": url, "name": "api"}))
+	value, ok := agg.Value("network.http.can_connect", "instance:api")
+	assert.True(t, ok)
+	assert.Equal(t, float64(0), value)
+	assert.NotContains(t, agg.Names(), "network.http.response_time")
+	sc, ok := agg.GetServiceCheck("http.can_connect", "instance:api")
+	if assert.True(t, ok) {
+		assert.Equal(t, metric.ServiceCheckCritical, sc.Status)
+		assert.Contains(t, sc.Message, "connection refused")
+	}
+}
+
+func TestBadConfig(t *testing.T) {
+	for _, instance := range []plugin.Instance{
+		{},
+		{"url": "http://localhost", "content_match": "("},
+		{"url": "http://localhost", "http_response_status_code": "("},
+	} {
+		assert.Error(t, NewHTTPCheck(nil).Check(&metric.MockAggregator{}, instance))
+	}
+}
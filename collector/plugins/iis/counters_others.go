@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package iis
+
+import "fmt"
+
+type wmiCounters struct{}
+
+func (c *wmiCounters) Sites() ([]site, error) {
+	return nil, fmt.Errorf("the iis check is only supported on Windows")
+}
+
+func (c *wmiCounters) Queues() ([]queue, error) {
+	return nil, fmt.Errorf("the iis check is only supported on Windows")
+}
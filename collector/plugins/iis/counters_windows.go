@@ -0,0 +1,70 @@
+package iis
+
+import "github.com/StackExchange/wmi"
+
+// The names of the types and of their fields are the ones of the WMI
+// classes and properties, they're used to build the queries.
+
+// Win32_PerfFormattedData_W3SVC_WebService XXX
+type Win32_PerfFormattedData_W3SVC_WebService struct {
+	Name                      string
+	CurrentConnections        uint32
+	TotalMethodRequestsPersec uint32
+	GetRequestsPersec         uint32
+	PostRequestsPersec        uint32
+	BytesSentPersec           uint64
+	BytesReceivedPersec       uint64
+	NotFoundErrorsPersec      uint32
+	LockedErrorsPersec        uint32
+	ServiceUptime             uint64
+}
+
+// Win32_PerfFormattedData_Counters_HTTPServiceRequestQueues XXX
+type Win32_PerfFormattedData_Counters_HTTPServiceRequestQueues struct {
+	Name             string
+	CurrentQueueSize uint32
+	RejectionRate    uint64
+}
+
+type wmiCounters struct{}
+
+func (c *wmiCounters) Sites() ([]site, error) {
+	var dst []Win32_PerfFormattedData_W3SVC_WebService
+	if err := wmi.Query(wmi.CreateQuery(&dst, ""), &dst); err != nil {
+		return nil, err
+	}
+
+	sites := make([]site, 0, len(dst))
+	for _, s := range dst {
+		sites = append(sites, site{
+			Name:               s.Name,
+			CurrentConnections: float64(s.CurrentConnections),
+			RequestsRate:       float64(s.TotalMethodRequestsPersec),
+			GetRequestsRate:    float64(s.GetRequestsPersec),
+			PostRequestsRate:   float64(s.PostRequestsPersec),
+			BytesSentRate:      float64(s.BytesSentPersec),
+			BytesReceivedRate:  float64(s.BytesReceivedPersec),
+			NotFoundErrorsRate: float64(s.NotFoundErrorsPersec),
+			LockedErrorsRate:   float64(s.LockedErrorsPersec),
+			Uptime:             float64(s.ServiceUptime),
+		})
+	}
+	return sites, nil
+}
+
+func (c *wmiCounters) Queues() ([]queue, error) {
+	var dst []Win32_PerfFormattedData_Counters_HTTPServiceRequestQueues
+	if err := wmi.Query(wmi.CreateQuery(&dst, ""), &dst); err != nil {
+		return nil, err
+	}
+
+	queues := make([]queue, 0, len(dst))
+	for _, q := range dst {
+		queues = append(queues, queue{
+			Name:          q.Name,
+			Size:          float64(q.CurrentQueueSize),
+			RejectionRate: float64(q.RejectionRate),
+		})
+	}
+	return queues, nil
+}
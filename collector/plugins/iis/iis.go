@@ -0,0 +1,119 @@
+package iis
+
+import (
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewIIS XXX
+func NewIIS(conf plugin.InitConfig) plugin.Plugin {
+	return &IIS{
+		counters: &wmiCounters{},
+	}
+}
+
+// IIS reports the activity of the IIS web sites and of their request queues
+// from the performance counters.
+type IIS struct {
+	counters Counters
+}
+
+// site holds the Web Service performance counters of a web site, the rates
+// are per second.
+type site struct {
+	Name               string
+	CurrentConnections float64
+	RequestsRate       float64
+	GetRequestsRate    float64
+	PostRequestsRate   float64
+	BytesSentRate      float64
+	BytesReceivedRate  float64
+	NotFoundErrorsRate float64
+	LockedErrorsRate   float64
+	Uptime             float64
+}
+
+// queue holds the HTTP Service Request Queues performance counters of an
+// application pool.
+type queue struct {
+	Name string
+	Size float64
+	// RejectionRate is the rate of the requests rejected by http.sys with a
+	// 503 Service Unavailable, e.g. because the queue is full or the
+	// application pool is stopped.
+	RejectionRate float64
+}
+
+// Counters XXX
+type Counters interface {
+	Sites() ([]site, error)
+	Queues() ([]queue, error)
+}
+
+type instanceConfig struct {
+	// Sites are the names of the web sites to report, all of them by default.
+	Sites []string `yaml:"sites"`
+	Tags  []string `yaml:"tags"`
+}
+
+// Check XXX
+func (i *IIS) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+
+	sites, err := i.counters.Sites()
+	if err != nil {
+		return err
+	}
+	for _, s := range sites {
+		// _Total sums up all the sites.
+		if s.Name == "_Total" || !conf.wants(s.Name) {
+			continue
+		}
+		tags := append(append([]string{}, conf.Tags...), "site:"+s.Name)
+		agg.Add("gauge", metric.NewMetric("iis.net.num_connections", s.CurrentConnections, tags))
+		agg.Add("gauge", metric.NewMetric("iis.requests.total", s.RequestsRate, tags))
+		agg.Add("gauge", metric.NewMetric("iis.requests.get", s.GetRequestsRate, tags))
+		agg.Add("gauge", metric.NewMetric("iis.requests.post", s.PostRequestsRate, tags))
+		agg.Add("gauge", metric.NewMetric("iis.net.bytes_sent", s.BytesSentRate, tags))
+		agg.Add("gauge", metric.NewMetric("iis.net.bytes_rcvd", s.BytesReceivedRate, tags))
+		agg.Add("gauge", metric.NewMetric("iis.errors.not_found", s.NotFoundErrorsRate, tags))
+		agg.Add("gauge", metric.NewMetric("iis.errors.locked", s.LockedErrorsRate, tags))
+		agg.Add("gauge", metric.NewMetric("iis.uptime", s.Uptime, tags))
+	}
+
+	queues, err := i.counters.Queues()
+	if err != nil {
+		return err
+	}
+	for _, q := range queues {
+		if q.Name == "_Total" {
+			continue
+		}
+		tags := append(append([]string{}, conf.Tags...), "app_pool:"+q.Name)
+		agg.Add("gauge", metric.NewMetric("iis.queue.size", q.Size, tags))
+		agg.Add("gauge", metric.NewMetric("iis.errors.service_unavailable", q.RejectionRate, tags))
+	}
+	return nil
+}
+
+func (conf *instanceConfig) wants(name string) bool {
+	if len(conf.Sites) == 0 {
+		return true
+	}
+	for _, s := range conf.Sites {
+		if strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	collector.Add("iis", NewIIS)
+}
@@ -0,0 +1,72 @@
+package iis
+
+import (
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCounters struct {
+	sites  []site
+	queues []queue
+}
+
+func (f *fakeCounters) Sites() ([]site, error) {
+	return f.sites, nil
+}
+
+func (f *fakeCounters) Queues() ([]queue, error) {
+	return f.queues, nil
+}
+
+func TestCheck(t *testing.T) {
+	i := &IIS{
+		counters: &fakeCounters{
+			sites: []site{
+				{Name: "_Total", CurrentConnections: 15},
+				{Name: "Default Web Site", CurrentConnections: 12, RequestsRate: 250, GetRequestsRate: 200, PostRequestsRate: 50, Uptime: 86400},
+				{Name: "api", CurrentConnections: 3, RequestsRate: 40, NotFoundErrorsRate: 2},
+			},
+			queues: []queue{
+				{Name: "_Total", Size: 7},
+				{Name: "DefaultAppPool", Size: 7, RejectionRate: 1.5},
+			},
+		},
+	}
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, i.Check(agg, plugin.Instance{"tags": []interface{}{"env:prod"}}))
+	for _, c := range []struct {
+		name  string
+		tag   string
+		value float64
+	}{
+		{"iis.net.num_connections", "site:Default Web Site", 12},
+		{"iis.requests.total", "site:Default Web Site", 250},
+		{"iis.requests.post", "site:Default Web Site", 50},
+		{"iis.uptime", "site:Default Web Site", 86400},
+		{"iis.net.num_connections", "site:api", 3},
+		{"iis.errors.not_found", "site:api", 2},
+		{"iis.queue.size", "app_pool:DefaultAppPool", 7},
+		{"iis.errors.service_unavailable", "app_pool:DefaultAppPool", 1.5},
+	} {
+		value, ok := agg.Value(c.name, c.tag, "env:prod")
+		if assert.True(t, ok, "metric %s %s not found", c.name, c.tag) {
+			assert.Equal(t, c.value, value, c.name)
+		}
+	}
+	_, ok := agg.Get("iis.net.num_connections", "site:_Total")
+	assert.False(t, ok)
+	_, ok = agg.Get("iis.queue.size", "app_pool:_Total")
+	assert.False(t, ok)
+
+	agg = &metric.MockAggregator{}
+	require.NoError(t, i.Check(agg, plugin.Instance{"sites": []interface{}{"API"}}))
+	_, ok = agg.Get("iis.net.num_connections", "site:api")
+	assert.True(t, ok)
+	_, ok = agg.Get("iis.net.num_connections", "site:Default Web Site")
+	assert.False(t, ok)
+}
@@ -0,0 +1,253 @@
+package influxdblistener
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+const (
+	// DefaultListen is the default address of the listener.
+	DefaultListen = ":8186"
+
+	// DefaultMaxBodySize is the default limit in bytes of the size of the
+	// writes.
+	DefaultMaxBodySize = 32 * 1024 * 1024
+
+	// MaxPendingMetrics is the number of metrics kept between two checks,
+	// once reached the writes are rejected.
+	MaxPendingMetrics = 100000
+)
+
+// NewInfluxDBListener XXX
+func NewInfluxDBListener(conf plugin.InitConfig) plugin.Plugin {
+	return &InfluxDBListener{}
+}
+
+// InfluxDBListener accepts the writes of the InfluxDB HTTP API, so Telegraf
+// or the InfluxDB client libraries can push their metrics to the agent. The
+// listener is started by the first check, the next ones submit the metrics
+// written in between.
+type InfluxDBListener struct{}
+
+type instanceConfig struct {
+	Listen      string   `yaml:"listen"`
+	MaxBodySize int64    `yaml:"max_body_size"`
+	Tags        []string `yaml:"tags"`
+}
+
+var (
+	serversLock sync.Mutex
+	// servers are keyed by their listen address, they outlive the plugins
+	// so a reload of the configuration doesn't have to rebind them.
+	servers = make(map[string]*server)
+)
+
+// Check XXX
+func (l *InfluxDBListener) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.Listen == "" {
+		conf.Listen = DefaultListen
+	}
+	if conf.MaxBodySize <= 0 {
+		conf.MaxBodySize = DefaultMaxBodySize
+	}
+
+	s, err := getServer(conf.Listen)
+	if err != nil {
+		return err
+	}
+	s.configure(conf.MaxBodySize)
+
+	for _, m := range s.drain() {
+		m.Tags = append(m.Tags, conf.Tags...)
+		agg.Add("gauge", m)
+	}
+	return nil
+}
+
+func getServer(addr string) (*server, error) {
+	serversLock.Lock()
+	defer serversLock.Unlock()
+
+	if s, ok := servers[addr]; ok {
+		return s, nil
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	log.Infoln("InfluxDB listener listening on:", listener.Addr())
+
+	s := &server{
+		listener:    listener,
+		maxBodySize: DefaultMaxBodySize,
+	}
+	servers[addr] = s
+	go func() {
+		if err := http.Serve(listener, s); err != nil {
+			log.Infoln("InfluxDB listener stopped:", err)
+		}
+	}()
+	return s, nil
+}
+
+type server struct {
+	sync.Mutex
+
+	listener    net.Listener
+	maxBodySize int64
+	metrics     []metric.Metric
+}
+
+func (s *server) configure(maxBodySize int64) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.maxBodySize = maxBodySize
+}
+
+func (s *server) drain() []metric.Metric {
+	s.Lock()
+	defer s.Unlock()
+
+	metrics := s.metrics
+	s.metrics = nil
+	return metrics
+}
+
+// ServeHTTP implements the /write and /ping endpoints of InfluxDB.
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/ping":
+		w.WriteHeader(http.StatusNoContent)
+	case "/write":
+		if r.Method != "POST" {
+			writeError(w, http.StatusMethodNotAllowed, "write requires a POST")
+			return
+		}
+		s.write(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *server) write(w http.ResponseWriter, r *http.Request) {
+	precision, ok := precisions[r.URL.Query().Get("precision")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid precision")
+		return
+	}
+
+	s.Lock()
+	maxBodySize := s.maxBodySize
+	s.Unlock()
+	if r.ContentLength > maxBodySize {
+		writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+		return
+	}
+
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxBodySize)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		defer func() {
+			_ = gz.Close()
+		}()
+		body = io.LimitReader(gz, maxBodySize)
+	}
+
+	// Like InfluxDB, the valid lines are kept when some of them are not.
+	var metrics []metric.Metric
+	var parseErr error
+	now := time.Now()
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p, err := parseLine(line, precision, now)
+		if err != nil {
+			if parseErr == nil {
+				parseErr = err
+			}
+			continue
+		}
+		metrics = append(metrics, toMetrics(p)...)
+	}
+	if err := scanner.Err(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.Lock()
+	full := len(s.metrics)+len(metrics) > MaxPendingMetrics
+	if !full {
+		s.metrics = append(s.metrics, metrics...)
+	}
+	s.Unlock()
+	if full {
+		writeError(w, http.StatusServiceUnavailable, "too many pending metrics")
+		return
+	}
+
+	if parseErr != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("partial write: %s", parseErr))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// toMetrics turns every field of the point into a measurement.field metric,
+// the host tag becomes the host of the metrics.
+func toMetrics(p *point) []metric.Metric {
+	var tags []string
+	for key, value := range p.Tags {
+		if key != "host" {
+			tags = append(tags, key+":"+value)
+		}
+	}
+	sort.Strings(tags)
+
+	metrics := make([]metric.Metric, 0, len(p.Fields))
+	for field, value := range p.Fields {
+		m := metric.NewMetric(p.Measurement+"."+field, value, append([]string{}, tags...))
+		m.Hostname = p.Tags["host"]
+		m.Timestamp = p.Time.Unix()
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Influxdb-Error", message)
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func init() {
+	collector.Add("influxdb_listener", NewInfluxDBListener)
+}
@@ -0,0 +1,84 @@
+package influxdblistener
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	l := NewInfluxDBListener(nil)
+	instance := plugin.Instance{
+		"listen": "127.0.0.1:0",
+		"tags":   []interface{}{"source:influxdb"},
+	}
+	require.NoError(t, l.Check(&metric.MockAggregator{}, instance))
+	url := "http://" + servers["127.0.0.1:0"].listener.Addr().String()
+
+	resp, err := http.Get(url + "/ping")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, err = http.Post(url+"/write?db=telegraf&precision=s", "text/plain", strings.NewReader(
+		"cpu,host=web01,cpu=cpu0 usage_user=12.5 1488453238\n"+
+			"mem,host=web01 used=1024i,used_percent=50 1488453238\n"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte("load shortterm=0.5\nbroken line\n"))
+	gz.Close()
+	req, _ := http.NewRequest("POST", url+"/write", &compressed)
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("X-Influxdb-Error"), "partial write")
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, l.Check(agg, instance))
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		host  string
+		value float64
+	}{
+		{"cpu.usage_user", []string{"cpu:cpu0", "source:influxdb"}, "web01", 12.5},
+		{"mem.used", nil, "web01", 1024},
+		{"mem.used_percent", nil, "web01", 50},
+		{"load.shortterm", []string{"source:influxdb"}, "", 0.5},
+	} {
+		m, ok := agg.Get(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s not found", c.name) {
+			value, _ := agg.Value(c.name)
+			assert.Equal(t, c.value, value, c.name)
+			assert.Equal(t, c.host, m.Hostname, c.name)
+			assert.Equal(t, "gauge", m.Type, c.name)
+			assert.NotContains(t, m.Tags, "host:web01")
+		}
+	}
+	m, _ := agg.Get("cpu.usage_user")
+	assert.Equal(t, int64(1488453238), m.Timestamp)
+	assert.Len(t, agg.Metrics, 4)
+
+	resp, err = http.Get(url + "/write")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	resp, err = http.Post(url+"/write?precision=year", "text/plain", strings.NewReader("load value=1"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
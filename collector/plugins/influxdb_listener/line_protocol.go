@@ -0,0 +1,147 @@
+package influxdblistener
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// point is a line of the InfluxDB line protocol, only its numeric and
+// boolean fields are kept.
+type point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]float64
+	Time        time.Time
+}
+
+// precisions are the units of the timestamps, given by the precision
+// parameter of the writes.
+var precisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"n":  time.Nanosecond,
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// parseLine parses a line of the line protocol, which looks like:
+//
+//	cpu,host=web01,cpu=cpu0 usage_user=12.5,usage_idle=80i 1488453238000000000
+//
+// the commas, spaces and equal signs of the names are escaped with a
+// backslash, the timestamp is optional and defaults to now.
+func parseLine(line string, precision time.Duration, now time.Time) (*point, error) {
+	sections := splitUnescaped(line, ' ', true)
+	if len(sections) < 2 || len(sections) > 3 {
+		return nil, fmt.Errorf("invalid line %q", line)
+	}
+
+	keys := splitUnescaped(sections[0], ',', false)
+	p := &point{
+		Measurement: unescape(keys[0]),
+		Tags:        make(map[string]string),
+		Fields:      make(map[string]float64),
+		Time:        now,
+	}
+	if p.Measurement == "" {
+		return nil, fmt.Errorf("missing measurement in %q", line)
+	}
+	for _, tag := range keys[1:] {
+		kv := splitUnescaped(tag, '=', false)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		p.Tags[unescape(kv[0])] = unescape(kv[1])
+	}
+
+	for _, field := range splitUnescaped(sections[1], ',', true) {
+		kv := splitUnescaped(field, '=', true)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid field %q", field)
+		}
+		value, ok, err := parseFieldValue(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of field %q: %s", kv[0], err)
+		}
+		if ok {
+			p.Fields[unescape(kv[0])] = value
+		}
+	}
+
+	if len(sections) == 3 {
+		ts, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", sections[2])
+		}
+		p.Time = time.Unix(0, ts*int64(precision))
+	}
+	return p, nil
+}
+
+// parseFieldValue returns the value of a numeric or boolean field, the
+// strings are valid but are not reported.
+func parseFieldValue(s string) (float64, bool, error) {
+	if strings.HasPrefix(s, `"`) {
+		if len(s) < 2 || !strings.HasSuffix(s, `"`) {
+			return 0, false, fmt.Errorf("unterminated string %s", s)
+		}
+		return 0, false, nil
+	}
+
+	switch s {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+
+	var value float64
+	var err error
+	switch s[len(s)-1] {
+	case 'i':
+		var i int64
+		i, err = strconv.ParseInt(s[:len(s)-1], 10, 64)
+		value = float64(i)
+	case 'u':
+		var u uint64
+		u, err = strconv.ParseUint(s[:len(s)-1], 10, 64)
+		value = float64(u)
+	default:
+		value, err = strconv.ParseFloat(s, 64)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return value, true, nil
+}
+
+// splitUnescaped splits s around the separators which are not escaped with
+// a backslash, nor within a quoted string when quotes is set.
+func splitUnescaped(s string, sep byte, quotes bool) []string {
+	var parts []string
+	start := 0
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"' && quotes:
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+var unescaper = strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=", `\"`, `"`, `\\`, `\`)
+
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
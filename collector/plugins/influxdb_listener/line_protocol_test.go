@@ -0,0 +1,69 @@
+package influxdblistener
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	now := time.Unix(1488453238, 0)
+	for _, c := range []struct {
+		line      string
+		precision time.Duration
+		expected  *point
+	}{
+		{
+			"cpu,host=web01,cpu=cpu0 usage_user=12.5,usage_idle=80i 1488453248000000000",
+			time.Nanosecond,
+			&point{
+				Measurement: "cpu",
+				Tags:        map[string]string{"host": "web01", "cpu": "cpu0"},
+				Fields:      map[string]float64{"usage_user": 12.5, "usage_idle": 80},
+				Time:        time.Unix(1488453248, 0),
+			},
+		},
+		{
+			`disk\ io,path=/var\,log,mode\=x=rw reads=3u,ok=true,msg="a, b=c" 1488453250`,
+			time.Second,
+			&point{
+				Measurement: "disk io",
+				Tags:        map[string]string{"path": "/var,log", "mode=x": "rw"},
+				Fields:      map[string]float64{"reads": 3, "ok": 1},
+				Time:        time.Unix(1488453250, 0),
+			},
+		},
+		{
+			"mem free=-1.5e3",
+			time.Nanosecond,
+			&point{
+				Measurement: "mem",
+				Tags:        map[string]string{},
+				Fields:      map[string]float64{"free": -1500},
+				Time:        now,
+			},
+		},
+	} {
+		p, err := parseLine(c.line, c.precision, now)
+		require.NoError(t, err, c.line)
+		assert.Equal(t, c.expected, p, c.line)
+	}
+}
+
+func TestParseLineErrors(t *testing.T) {
+	for _, line := range []string{
+		"cpu",
+		",host=a value=1",
+		"cpu,host value=1",
+		"cpu value=",
+		"cpu value=abc",
+		`cpu value="abc`,
+		"cpu value=1 abc",
+		"cpu value=1 1 1",
+	} {
+		_, err := parseLine(line, time.Nanosecond, time.Now())
+		assert.Error(t, err, line)
+	}
+}
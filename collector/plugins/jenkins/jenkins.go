@@ -0,0 +1,178 @@
+package jenkins
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewJenkins XXX
+func NewJenkins(conf plugin.InitConfig) plugin.Plugin {
+	return &Jenkins{
+		lastBuilds: make(map[string]int),
+	}
+}
+
+// Jenkins reports the build queue, the executors and the builds of the jobs
+// from the Jenkins REST API.
+type Jenkins struct {
+	sync.Mutex
+
+	// lastBuilds keeps the number of the last completed build reported for
+	// every job, by URL.
+	lastBuilds map[string]int
+}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	URL string `yaml:"jenkins_url"`
+	// Jobs are the names of the jobs to report the builds of, all of them
+	// by default.
+	Jobs []string `yaml:"jobs"`
+	Tags []string `yaml:"tags"`
+}
+
+type queue struct {
+	Items []struct {
+		Blocked   bool `json:"blocked"`
+		Buildable bool `json:"buildable"`
+		Stuck     bool `json:"stuck"`
+	} `json:"items"`
+}
+
+type computers struct {
+	BusyExecutors  int `json:"busyExecutors"`
+	TotalExecutors int `json:"totalExecutors"`
+	Computer       []struct {
+		DisplayName  string `json:"displayName"`
+		Offline      bool   `json:"offline"`
+		NumExecutors int    `json:"numExecutors"`
+	} `json:"computer"`
+}
+
+type jobs struct {
+	Jobs []struct {
+		Name   string `json:"name"`
+		URL    string `json:"url"`
+		Builds []struct {
+			Number   int    `json:"number"`
+			Building bool   `json:"building"`
+			Result   string `json:"result"`
+			// Duration is in milliseconds.
+			Duration float64 `json:"duration"`
+		} `json:"builds"`
+	} `json:"jobs"`
+}
+
+// Check XXX
+func (j *Jenkins) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.URL == "" {
+		return fmt.Errorf("a configured jenkins_url is required")
+	}
+	if !strings.HasSuffix(conf.URL, "/") {
+		conf.URL += "/"
+	}
+	tags := append(append([]string{}, conf.Tags...), "jenkins_url:"+conf.URL)
+
+	var q queue
+	if err := conf.GetJSON(conf.URL+"queue/api/json?tree="+url.QueryEscape("items[blocked,buildable,stuck]"), &q); err != nil {
+		return err
+	}
+	var blocked, buildable, stuck int
+	for _, item := range q.Items {
+		if item.Blocked {
+			blocked++
+		}
+		if item.Buildable {
+			buildable++
+		}
+		if item.Stuck {
+			stuck++
+		}
+	}
+	agg.Add("gauge", metric.NewMetric("jenkins.queue.size", len(q.Items), tags))
+	agg.Add("gauge", metric.NewMetric("jenkins.queue.blocked", blocked, tags))
+	agg.Add("gauge", metric.NewMetric("jenkins.queue.buildable", buildable, tags))
+	agg.Add("gauge", metric.NewMetric("jenkins.queue.stuck", stuck, tags))
+
+	var c computers
+	tree := "busyExecutors,totalExecutors,computer[displayName,offline,numExecutors]"
+	if err := conf.GetJSON(conf.URL+"computer/api/json?tree="+url.QueryEscape(tree), &c); err != nil {
+		return err
+	}
+	agg.Add("gauge", metric.NewMetric("jenkins.executors.busy", c.BusyExecutors, tags))
+	agg.Add("gauge", metric.NewMetric("jenkins.executors.total", c.TotalExecutors, tags))
+	if c.TotalExecutors > 0 {
+		agg.Add("gauge", metric.NewMetric("jenkins.executors.utilization",
+			float64(c.BusyExecutors)/float64(c.TotalExecutors)*100, tags))
+	}
+	offline := 0
+	for _, node := range c.Computer {
+		if node.Offline {
+			offline++
+		}
+	}
+	agg.Add("gauge", metric.NewMetric("jenkins.nodes.total", len(c.Computer), tags))
+	agg.Add("gauge", metric.NewMetric("jenkins.nodes.offline", offline, tags))
+
+	var js jobs
+	// The last builds are enough as long as fewer complete between checks.
+	tree = "jobs[name,url,builds[number,building,result,duration]{0,20}]"
+	if err := conf.GetJSON(conf.URL+"api/json?tree="+url.QueryEscape(tree), &js); err != nil {
+		return err
+	}
+	j.Lock()
+	defer j.Unlock()
+	for _, job := range js.Jobs {
+		if !conf.wants(job.Name) {
+			continue
+		}
+		jobTags := append(append([]string{}, tags...), "job:"+job.Name)
+
+		last, seen := j.lastBuilds[job.URL]
+		newest := last
+		for _, b := range job.Builds {
+			if b.Building {
+				continue
+			}
+			if b.Number > newest {
+				newest = b.Number
+			}
+			// The builds completed before the first check are skipped.
+			if !seen || b.Number <= last {
+				continue
+			}
+			buildTags := append(append([]string{}, jobTags...), "result:"+strings.ToLower(b.Result))
+			agg.Add("gauge", metric.NewMetric("jenkins.job.duration", b.Duration/1000, buildTags))
+			agg.Add("count", metric.NewMetric("jenkins.job.builds", 1, buildTags))
+		}
+		j.lastBuilds[job.URL] = newest
+	}
+	return nil
+}
+
+func (conf *instanceConfig) wants(job string) bool {
+	if len(conf.Jobs) == 0 {
+		return true
+	}
+	for _, j := range conf.Jobs {
+		if j == job {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	collector.Add("jenkins", NewJenkins)
+}
@@ -0,0 +1,83 @@
+package jenkins
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	builds := `{"number": 41, "building": false, "result": "SUCCESS", "duration": 95000}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/queue/api/json":
+			fmt.Fprintln(w, `{"items": [{"blocked": true}, {"buildable": true, "stuck": true}, {"buildable": true}]}`)
+		case "/computer/api/json":
+			fmt.Fprintln(w, `{"busyExecutors": 3, "totalExecutors": 4, "computer": [
+				{"displayName": "master", "offline": false, "numExecutors": 2},
+				{"displayName": "agent-1", "offline": true, "numExecutors": 2}]}`)
+		case "/api/json":
+			fmt.Fprintf(w, `{"jobs": [
+				{"name": "deploy", "url": "http://jenkins/job/deploy/", "builds": [%s]},
+				{"name": "nightly", "url": "http://jenkins/job/nightly/", "builds": []}]}`, builds)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	j := NewJenkins(nil)
+	instance := plugin.Instance{"jenkins_url": server.URL}
+	agg := &metric.MockAggregator{}
+	require.NoError(t, j.Check(agg, instance))
+
+	tag := "jenkins_url:" + server.URL + "/"
+	for _, c := range []struct {
+		name  string
+		value float64
+	}{
+		{"jenkins.queue.size", 3},
+		{"jenkins.queue.blocked", 1},
+		{"jenkins.queue.buildable", 2},
+		{"jenkins.queue.stuck", 1},
+		{"jenkins.executors.busy", 3},
+		{"jenkins.executors.utilization", 75},
+		{"jenkins.nodes.total", 2},
+		{"jenkins.nodes.offline", 1},
+	} {
+		value, ok := agg.Value(c.name, tag)
+		if assert.True(t, ok, "metric %s not found", c.name) {
+			assert.Equal(t, c.value, value, c.name)
+		}
+	}
+	// The builds completed before the first check aren't reported.
+	assert.NotContains(t, agg.Names(), "jenkins.job.duration")
+
+	builds = `{"number": 44, "building": true},
+		{"number": 43, "building": false, "result": "FAILURE", "duration": 12500},
+		{"number": 42, "building": false, "result": "SUCCESS", "duration": 90000},
+		{"number": 41, "building": false, "result": "SUCCESS", "duration": 95000}`
+	agg = &metric.MockAggregator{}
+	require.NoError(t, j.Check(agg, instance))
+	value, ok := agg.Value("jenkins.job.duration", "job:deploy", "result:failure", tag)
+	assert.True(t, ok)
+	assert.Equal(t, 12.5, value)
+	value, ok = agg.Value("jenkins.job.duration", "job:deploy", "result:success")
+	assert.True(t, ok)
+	assert.Equal(t, float64(90), value)
+	assert.Len(t, agg.Metrics, 9+2*2)
+
+	agg = &metric.MockAggregator{}
+	require.NoError(t, j.Check(agg, instance))
+	assert.NotContains(t, agg.Names(), "jenkins.job.builds")
+}
+
+func TestCheckInvalidConfig(t *testing.T) {
+	assert.Error(t, NewJenkins(nil).Check(&metric.MockAggregator{}, plugin.Instance{}))
+}
@@ -0,0 +1,208 @@
+package jmx
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// NewJMX XXX
+func NewJMX(conf plugin.InitConfig) plugin.Plugin {
+	return &JMX{}
+}
+
+// newTemplate returns the constructor of a check collecting the beans of the
+// given template on top of the ones configured in the instances.
+func newTemplate(name string) collector.Checker {
+	return func(conf plugin.InitConfig) plugin.Plugin {
+		return &JMX{template: templates[name]}
+	}
+}
+
+// JMX collects the attributes of MBeans through a Jolokia agent running in
+// the JVM.
+type JMX struct {
+	template []BeanConfig
+}
+
+// BeanConfig maps the attributes of the MBeans matching MBean to metrics.
+type BeanConfig struct {
+	// MBean is an object name or a pattern, e.g. java.lang:type=GarbageCollector,*
+	MBean string `yaml:"mbean"`
+	// Attributes is keyed by attribute name, a dotted path reaches into
+	// composite attributes, e.g. HeapMemoryUsage.used.
+	Attributes map[string]AttributeConfig `yaml:"attributes"`
+}
+
+// AttributeConfig XXX
+type AttributeConfig struct {
+	// Alias is the metric name, $attribute, $domain and the keys of the bean
+	// (e.g. $name) are replaced by their values.
+	Alias      string `yaml:"alias"`
+	MetricType string `yaml:"metric_type"`
+}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	URL string `yaml:"url"`
+	// CollectDefaultMetrics collects the memory, thread and GC metrics of
+	// the JVM.
+	CollectDefaultMetrics *bool        `yaml:"collect_default_metrics"`
+	Conf                  []BeanConfig `yaml:"conf"`
+	Tags                  []string     `yaml:"tags"`
+}
+
+var placeholder = regexp.MustCompile(`\$(\w+)`)
+
+// Check XXX
+func (j *JMX) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.URL == "" {
+		conf.URL = "http://localhost:8778/jolokia"
+	}
+	conf.URL = strings.TrimSuffix(conf.URL, "/") + "/"
+
+	var beans []BeanConfig
+	if conf.CollectDefaultMetrics == nil || *conf.CollectDefaultMetrics {
+		beans = append(beans, jvmBeans...)
+	}
+	beans = append(beans, j.template...)
+	beans = append(beans, conf.Conf...)
+	if len(beans) == 0 {
+		return fmt.Errorf("no bean configured")
+	}
+
+	requests := make([]readRequest, len(beans))
+	for i, bean := range beans {
+		requests[i] = newReadRequest(bean)
+	}
+
+	var responses []readResponse
+	if err := conf.PostJSON(conf.URL, requests, &responses); err != nil {
+		return err
+	}
+	if len(responses) != len(requests) {
+		return fmt.Errorf("jolokia returned %d responses for %d requests", len(responses), len(requests))
+	}
+
+	failed := 0
+	for i, resp := range responses {
+		if resp.Status != 200 {
+			failed++
+			metric.Logger(agg).Debugf("Failed to read %s: %s", beans[i].MBean, resp.Error)
+			continue
+		}
+		for name, attributes := range resp.beans(beans[i].MBean) {
+			submitBean(agg, beans[i], name, attributes, conf.Tags)
+		}
+	}
+	if failed == len(responses) {
+		return fmt.Errorf("could not read any bean from %s: %s", conf.URL, responses[0].Error)
+	}
+
+	return nil
+}
+
+func submitBean(agg metric.Aggregator, bean BeanConfig, name string, attributes map[string]interface{}, instanceTags []string) {
+	domain, props := parseObjectName(name)
+
+	var keys []string
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tags := append([]string{}, instanceTags...)
+	for _, k := range keys {
+		// The type names the metric, tagging with it would add nothing.
+		if k != "type" {
+			tags = append(tags, k+":"+props[k])
+		}
+	}
+
+	for path, attr := range bean.Attributes {
+		raw, ok := util.GetValue(attributes, path)
+		if !ok {
+			continue
+		}
+		value, ok := toFloat(raw)
+		if !ok {
+			continue
+		}
+
+		metricType := attr.MetricType
+		if metricType == "" {
+			metricType = "gauge"
+		}
+		agg.Add(metricType, metric.NewMetric(metricName(attr.Alias, domain, path, props), value, tags))
+	}
+}
+
+func metricName(alias, domain, attribute string, props map[string]string) string {
+	if alias == "" {
+		return "jmx." + strings.ToLower(attribute)
+	}
+
+	return placeholder.ReplaceAllStringFunc(alias, func(s string) string {
+		key := s[1:]
+		switch key {
+		case "attribute":
+			return attribute
+		case "domain":
+			return domain
+		}
+		if v, ok := props[key]; ok {
+			return v
+		}
+		return s
+	})
+}
+
+// parseObjectName splits an MBean object name, e.g.
+// java.lang:type=GarbageCollector,name=G1 Young Generation
+func parseObjectName(name string) (string, map[string]string) {
+	props := make(map[string]string)
+	i := strings.Index(name, ":")
+	if i < 0 {
+		return name, props
+	}
+
+	for _, prop := range strings.Split(name[i+1:], ",") {
+		kv := strings.SplitN(prop, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		props[kv[0]] = strings.Trim(kv[1], `"`)
+	}
+	return name[:i], props
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case float64:
+		return value, true
+	case bool:
+		if value {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func init() {
+	collector.Add("jmx", NewJMX)
+	for name := range templates {
+		collector.Add(name, newTemplate(name))
+	}
+}
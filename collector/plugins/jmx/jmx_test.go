@@ -0,0 +1,101 @@
+package jmx
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseObjectName(t *testing.T) {
+	domain, props := parseObjectName(`java.lang:type=GarbageCollector,name="G1 Young Generation"`)
+	assert.Equal(t, "java.lang", domain)
+	assert.Equal(t, map[string]string{"type": "GarbageCollector", "name": "G1 Young Generation"}, props)
+}
+
+func TestMetricName(t *testing.T) {
+	props := map[string]string{"request": "Produce"}
+	for _, c := range []struct {
+		alias string
+		want  string
+	}{
+		{"", "jmx.heapmemoryusage.used"},
+		{"kafka.request.$request.time", "kafka.request.Produce.time"},
+		{"$domain.$attribute", "kafka.network.HeapMemoryUsage.used"},
+		{"kafka.$unknown", "kafka.$unknown"},
+	} {
+		assert.Equal(t, c.want, metricName(c.alias, "kafka.network", "HeapMemoryUsage.used", props))
+	}
+}
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requests []readRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&requests))
+		require.Len(t, requests, 2)
+		assert.Equal(t, "Catalina:type=ThreadPool,*", requests[0].MBean)
+		assert.Equal(t, "java.lang:type=Memory", requests[1].MBean)
+		assert.Equal(t, []string{"HeapMemoryUsage"}, requests[1].Attribute)
+
+		fmt.Fprintln(w, `[
+			{"status": 200, "value": {
+				"Catalina:name=\"http-nio-8080\",type=ThreadPool": {"currentThreadsBusy": 3, "maxThreads": 200}
+			}},
+			{"status": 200, "value": {"HeapMemoryUsage": {"used": 1024, "max": 4096}}}
+		]`)
+	}))
+	defer server.Close()
+
+	c := &JMX{template: templates["tomcat"][:1]}
+	agg := &metric.MockAggregator{}
+	err := c.Check(agg, plugin.Instance{
+		"url":                     server.URL,
+		"collect_default_metrics": false,
+		"conf": []interface{}{
+			map[interface{}]interface{}{
+				"mbean": "java.lang:type=Memory",
+				"attributes": map[interface{}]interface{}{
+					"HeapMemoryUsage.used": map[interface{}]interface{}{"alias": "jvm.heap_memory"},
+				},
+			},
+		},
+		"tags": []interface{}{"env:test"},
+	})
+	require.NoError(t, err)
+
+	value, ok := agg.Value("tomcat.threads.busy", "name:http-nio-8080", "env:test")
+	assert.True(t, ok)
+	assert.Equal(t, float64(3), value)
+	value, ok = agg.Value("tomcat.threads.max")
+	assert.True(t, ok)
+	assert.Equal(t, float64(200), value)
+	value, ok = agg.Value("jvm.heap_memory", "env:test")
+	assert.True(t, ok)
+	assert.Equal(t, float64(1024), value)
+
+	m, _ := agg.Get("tomcat.threads.busy")
+	assert.NotContains(t, m.Tags, "type:ThreadPool")
+}
+
+func TestCheckErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `[{"status": 404, "error": "javax.management.InstanceNotFoundException"}]`)
+	}))
+	defer server.Close()
+
+	c := &JMX{}
+	err := c.Check(&metric.MockAggregator{}, plugin.Instance{
+		"url": server.URL,
+		"conf": []interface{}{
+			map[interface{}]interface{}{"mbean": "foo:type=Bar", "attributes": map[interface{}]interface{}{"Value": nil}},
+		},
+		"collect_default_metrics": false,
+	})
+	assert.Error(t, err)
+}
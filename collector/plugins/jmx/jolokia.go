@@ -0,0 +1,66 @@
+package jmx
+
+import "strings"
+
+// readRequest is a read request of the Jolokia protocol, see
+// https://jolokia.org/reference/html/protocol.html#read
+type readRequest struct {
+	Type      string          `json:"type"`
+	MBean     string          `json:"mbean"`
+	Attribute []string        `json:"attribute,omitempty"`
+	Config    map[string]bool `json:"config,omitempty"`
+}
+
+type readResponse struct {
+	Status int         `json:"status"`
+	Error  string      `json:"error"`
+	Value  interface{} `json:"value"`
+}
+
+func newReadRequest(bean BeanConfig) readRequest {
+	req := readRequest{
+		Type:  "read",
+		MBean: bean.MBean,
+		// Don't fail the whole request when one of the matching beans
+		// lacks an attribute.
+		Config: map[string]bool{"ignoreErrors": true},
+	}
+
+	found := make(map[string]bool)
+	for path := range bean.Attributes {
+		// Composite attributes are read as a whole.
+		attr := strings.SplitN(path, ".", 2)[0]
+		if !found[attr] {
+			found[attr] = true
+			req.Attribute = append(req.Attribute, attr)
+		}
+	}
+	return req
+}
+
+// beans returns the attributes keyed by object name, the values of a pattern
+// read are keyed by the names of the matching beans while a plain read
+// returns the attributes of the requested bean.
+func (r *readResponse) beans(mbean string) map[string]map[string]interface{} {
+	result := make(map[string]map[string]interface{})
+	value, ok := r.Value.(map[string]interface{})
+	if !ok {
+		return result
+	}
+
+	if !isPattern(mbean) {
+		result[mbean] = value
+		return result
+	}
+
+	for name, attributes := range value {
+		if attrs, ok := attributes.(map[string]interface{}); ok {
+			result[name] = attrs
+		}
+	}
+	return result
+}
+
+func isPattern(mbean string) bool {
+	return strings.ContainsAny(mbean, "*?")
+}
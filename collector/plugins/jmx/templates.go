@@ -0,0 +1,191 @@
+package jmx
+
+// jvmBeans are collected from every JVM unless collect_default_metrics is
+// disabled.
+var jvmBeans = []BeanConfig{
+	{
+		MBean: "java.lang:type=Memory",
+		Attributes: map[string]AttributeConfig{
+			"HeapMemoryUsage.used":         {Alias: "jvm.heap_memory"},
+			"HeapMemoryUsage.committed":    {Alias: "jvm.heap_memory_committed"},
+			"HeapMemoryUsage.max":          {Alias: "jvm.heap_memory_max"},
+			"NonHeapMemoryUsage.used":      {Alias: "jvm.non_heap_memory"},
+			"NonHeapMemoryUsage.committed": {Alias: "jvm.non_heap_memory_committed"},
+		},
+	},
+	{
+		MBean: "java.lang:type=Threading",
+		Attributes: map[string]AttributeConfig{
+			"ThreadCount": {Alias: "jvm.thread_count"},
+		},
+	},
+	{
+		MBean: "java.lang:type=GarbageCollector,*",
+		Attributes: map[string]AttributeConfig{
+			"CollectionCount": {Alias: "jvm.gc.collection_count", MetricType: "rate"},
+			"CollectionTime":  {Alias: "jvm.gc.collection_time", MetricType: "rate"},
+		},
+	},
+	{
+		MBean: "java.lang:type=OperatingSystem",
+		Attributes: map[string]AttributeConfig{
+			"OpenFileDescriptorCount": {Alias: "jvm.os.open_file_descriptors"},
+		},
+	},
+}
+
+// templates are the ready-made bean configurations of the well known JVM
+// applications, each one is registered as a check of its own.
+var templates = map[string][]BeanConfig{
+	"cassandra": {
+		{
+			MBean: "org.apache.cassandra.metrics:type=ClientRequest,name=Latency,*",
+			Attributes: map[string]AttributeConfig{
+				"Count":          {Alias: "cassandra.latency.count", MetricType: "rate"},
+				"OneMinuteRate":  {Alias: "cassandra.latency.one_minute_rate"},
+				"75thPercentile": {Alias: "cassandra.latency.75th_percentile"},
+				"95thPercentile": {Alias: "cassandra.latency.95th_percentile"},
+				"99thPercentile": {Alias: "cassandra.latency.99th_percentile"},
+			},
+		},
+		{
+			MBean: "org.apache.cassandra.metrics:type=ClientRequest,name=Timeouts,*",
+			Attributes: map[string]AttributeConfig{
+				"Count": {Alias: "cassandra.timeouts.count", MetricType: "rate"},
+			},
+		},
+		{
+			MBean: "org.apache.cassandra.metrics:type=ClientRequest,name=Unavailables,*",
+			Attributes: map[string]AttributeConfig{
+				"Count": {Alias: "cassandra.unavailables.count", MetricType: "rate"},
+			},
+		},
+		{
+			MBean: "org.apache.cassandra.metrics:type=Storage,name=Load",
+			Attributes: map[string]AttributeConfig{
+				"Count": {Alias: "cassandra.load.count"},
+			},
+		},
+		{
+			MBean: "org.apache.cassandra.metrics:type=Compaction,name=PendingTasks",
+			Attributes: map[string]AttributeConfig{
+				"Value": {Alias: "cassandra.pending_compactions"},
+			},
+		},
+		{
+			MBean: "org.apache.cassandra.metrics:type=Compaction,name=CompletedTasks",
+			Attributes: map[string]AttributeConfig{
+				"Value": {Alias: "cassandra.compaction.completed_tasks", MetricType: "rate"},
+			},
+		},
+		{
+			MBean: "org.apache.cassandra.metrics:type=ThreadPools,name=PendingTasks,*",
+			Attributes: map[string]AttributeConfig{
+				"Value": {Alias: "cassandra.pending_tasks"},
+			},
+		},
+		{
+			MBean: "org.apache.cassandra.metrics:type=DroppedMessage,name=Dropped,*",
+			Attributes: map[string]AttributeConfig{
+				"Count": {Alias: "cassandra.dropped.count", MetricType: "rate"},
+			},
+		},
+		{
+			MBean: "org.apache.cassandra.metrics:type=Table,name=LiveSSTableCount,*",
+			Attributes: map[string]AttributeConfig{
+				"Value": {Alias: "cassandra.live_ss_table_count"},
+			},
+		},
+	},
+	"tomcat": {
+		{
+			MBean: "Catalina:type=ThreadPool,*",
+			Attributes: map[string]AttributeConfig{
+				"maxThreads":         {Alias: "tomcat.threads.max"},
+				"currentThreadCount": {Alias: "tomcat.threads.count"},
+				"currentThreadsBusy": {Alias: "tomcat.threads.busy"},
+			},
+		},
+		{
+			MBean: "Catalina:type=GlobalRequestProcessor,*",
+			Attributes: map[string]AttributeConfig{
+				"requestCount":   {Alias: "tomcat.request_count", MetricType: "rate"},
+				"errorCount":     {Alias: "tomcat.error_count", MetricType: "rate"},
+				"processingTime": {Alias: "tomcat.processing_time", MetricType: "rate"},
+				"bytesSent":      {Alias: "tomcat.bytes_sent", MetricType: "rate"},
+				"bytesReceived":  {Alias: "tomcat.bytes_rcvd", MetricType: "rate"},
+				"maxTime":        {Alias: "tomcat.max_time"},
+			},
+		},
+		{
+			MBean: "Catalina:type=Manager,*",
+			Attributes: map[string]AttributeConfig{
+				"activeSessions":   {Alias: "tomcat.sessions.active"},
+				"rejectedSessions": {Alias: "tomcat.sessions.rejected", MetricType: "rate"},
+			},
+		},
+	},
+	"kafka": {
+		{
+			MBean: "kafka.server:type=BrokerTopicMetrics,name=MessagesInPerSec",
+			Attributes: map[string]AttributeConfig{
+				"Count": {Alias: "kafka.messages_in.rate", MetricType: "rate"},
+			},
+		},
+		{
+			MBean: "kafka.server:type=BrokerTopicMetrics,name=BytesInPerSec",
+			Attributes: map[string]AttributeConfig{
+				"Count": {Alias: "kafka.net.bytes_in.rate", MetricType: "rate"},
+			},
+		},
+		{
+			MBean: "kafka.server:type=BrokerTopicMetrics,name=BytesOutPerSec",
+			Attributes: map[string]AttributeConfig{
+				"Count": {Alias: "kafka.net.bytes_out.rate", MetricType: "rate"},
+			},
+		},
+		{
+			MBean: "kafka.server:type=BrokerTopicMetrics,name=FailedProduceRequestsPerSec",
+			Attributes: map[string]AttributeConfig{
+				"Count": {Alias: "kafka.request.produce.failed.rate", MetricType: "rate"},
+			},
+		},
+		{
+			MBean: "kafka.server:type=ReplicaManager,name=UnderReplicatedPartitions",
+			Attributes: map[string]AttributeConfig{
+				"Value": {Alias: "kafka.replication.under_replicated_partitions"},
+			},
+		},
+		{
+			MBean: "kafka.server:type=ReplicaManager,name=PartitionCount",
+			Attributes: map[string]AttributeConfig{
+				"Value": {Alias: "kafka.replication.partition_count"},
+			},
+		},
+		{
+			MBean: "kafka.server:type=ReplicaManager,name=IsrShrinksPerSec",
+			Attributes: map[string]AttributeConfig{
+				"Count": {Alias: "kafka.replication.isr_shrinks.rate", MetricType: "rate"},
+			},
+		},
+		{
+			MBean: "kafka.controller:type=KafkaController,name=ActiveControllerCount",
+			Attributes: map[string]AttributeConfig{
+				"Value": {Alias: "kafka.replication.active_controller_count"},
+			},
+		},
+		{
+			MBean: "kafka.controller:type=KafkaController,name=OfflinePartitionsCount",
+			Attributes: map[string]AttributeConfig{
+				"Value": {Alias: "kafka.replication.offline_partitions_count"},
+			},
+		},
+		{
+			MBean: "kafka.network:type=RequestMetrics,name=TotalTimeMs,*",
+			Attributes: map[string]AttributeConfig{
+				"Mean":           {Alias: "kafka.request.$request.time.avg"},
+				"99thPercentile": {Alias: "kafka.request.$request.time.99percentile"},
+			},
+		},
+	},
+}
@@ -0,0 +1,271 @@
+package kubernetes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewKubernetes XXX
+func NewKubernetes(conf plugin.InitConfig) plugin.Plugin {
+	return &Kubernetes{}
+}
+
+// Kubernetes collects the node, pod and container resource usage from the
+// summary API of the local kubelet, the machine capacity from its embedded
+// cAdvisor and the resource requests/limits of the running pods.
+type Kubernetes struct{}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	KubeletURL string `yaml:"kubelet_url"`
+	// BearerTokenPath is the token sent to the kubelet and the API server,
+	// usually the token of the service account the agent runs with.
+	BearerTokenPath string `yaml:"bearer_token_path"`
+	// APIServerURL and NodeName are used to fetch the allocatable resources
+	// of the node, which the kubelet doesn't expose.
+	APIServerURL string   `yaml:"api_server_url"`
+	NodeName     string   `yaml:"node_name"`
+	Tags         []string `yaml:"tags"`
+}
+
+// Check XXX
+func (k *Kubernetes) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.KubeletURL == "" {
+		conf.KubeletURL = "http://localhost:10255"
+	}
+	conf.KubeletURL = strings.TrimSuffix(conf.KubeletURL, "/")
+
+	if conf.BearerTokenPath != "" {
+		token, err := ioutil.ReadFile(conf.BearerTokenPath)
+		if err != nil {
+			return fmt.Errorf("could not read bearer_token_path: %s", err)
+		}
+		if conf.Headers == nil {
+			conf.Headers = make(map[string]string)
+		}
+		conf.Headers["Authorization"] = "Bearer " + strings.TrimSpace(string(token))
+	}
+
+	healthy := k.checkHealth(&conf, metric.Logger(agg))
+	agg.Add("gauge", metric.NewMetric("kubernetes.kubelet.health", healthy, conf.Tags))
+	if healthy == 0 {
+		return fmt.Errorf("kubelet %s is not healthy", conf.KubeletURL)
+	}
+
+	var s summary
+	if err := conf.GetJSON(conf.KubeletURL+"/stats/summary", &s); err != nil {
+		return err
+	}
+	if conf.NodeName == "" {
+		conf.NodeName = s.Node.NodeName
+	}
+	submitSummary(agg, &s, conf.Tags)
+
+	var pods podList
+	if err := conf.GetJSON(conf.KubeletURL+"/pods", &pods); err != nil {
+		return err
+	}
+	submitPods(agg, &pods, conf.Tags)
+
+	k.collectCapacity(agg, &conf)
+
+	return nil
+}
+
+func (k *Kubernetes) checkHealth(conf *instanceConfig, logger log.Logger) int {
+	resp, err := conf.Get(conf.KubeletURL + "/healthz")
+	if err != nil {
+		logger.Warnf("kubelet health check failed: %s", err)
+		return 0
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if strings.TrimSpace(string(body)) != "ok" {
+		return 0
+	}
+	return 1
+}
+
+// collectCapacity reports the machine capacity from cAdvisor, and the
+// allocatable resources from the API server when it's configured.
+func (k *Kubernetes) collectCapacity(agg metric.Aggregator, conf *instanceConfig) {
+	var spec machineSpec
+	if err := conf.GetJSON(conf.KubeletURL+"/spec/", &spec); err != nil {
+		metric.Logger(agg).Warnf("Failed to get the machine spec from cAdvisor: %s", err)
+	} else {
+		agg.Add("gauge", metric.NewMetric("kubernetes.cpu.capacity", spec.NumCores, conf.Tags))
+		agg.Add("gauge", metric.NewMetric("kubernetes.memory.capacity", spec.MemoryCapacity, conf.Tags))
+	}
+
+	if conf.APIServerURL == "" || conf.NodeName == "" {
+		return
+	}
+
+	var n node
+	url := fmt.Sprintf("%s/api/v1/nodes/%s", strings.TrimSuffix(conf.APIServerURL, "/"), conf.NodeName)
+	if err := conf.GetJSON(url, &n); err != nil {
+		metric.Logger(agg).Warnf("Failed to get the node from the API server: %s", err)
+		return
+	}
+
+	for kind, resources := range map[string]map[string]string{
+		"capacity":    n.Status.Capacity,
+		"allocatable": n.Status.Allocatable,
+	} {
+		for resource, quantity := range resources {
+			value, err := parseQuantity(quantity)
+			if err != nil {
+				metric.Logger(agg).Debugf("Skip resource %s: %s", resource, err)
+				continue
+			}
+			name := fmt.Sprintf("kubernetes.%s.%s", resourceName(resource), kind)
+			agg.Add("gauge", metric.NewMetric(name, value, conf.Tags))
+		}
+	}
+}
+
+func submitSummary(agg metric.Aggregator, s *summary, tags []string) {
+	submitCPU(agg, s.Node.CPU, tags)
+	submitMemory(agg, s.Node.Memory, tags)
+	submitNetwork(agg, s.Node.Network, tags)
+	if s.Node.Fs != nil {
+		agg.AddMetrics("gauge", "kubernetes.filesystem", map[string]interface{}{
+			"usage":    s.Node.Fs.UsedBytes,
+			"capacity": s.Node.Fs.CapacityBytes,
+		}, tags, "")
+	}
+
+	for _, pod := range s.Pods {
+		podTags := append(append([]string{}, tags...),
+			"kube_namespace:"+pod.PodRef.Namespace,
+			"pod_name:"+pod.PodRef.Name,
+		)
+		submitNetwork(agg, pod.Network, podTags)
+		if pod.EphemeralStorage != nil {
+			agg.Add("gauge", metric.NewMetric("kubernetes.ephemeral_storage.usage",
+				pod.EphemeralStorage.UsedBytes, podTags))
+		}
+
+		for _, c := range pod.Containers {
+			containerTags := append(append([]string{}, podTags...), "kube_container_name:"+c.Name)
+			submitCPU(agg, c.CPU, containerTags)
+			submitMemory(agg, c.Memory, containerTags)
+			if c.Rootfs != nil {
+				agg.AddMetrics("gauge", "kubernetes.filesystem", map[string]interface{}{
+					"usage":    c.Rootfs.UsedBytes,
+					"capacity": c.Rootfs.CapacityBytes,
+				}, containerTags, "")
+			}
+			if c.Logs != nil {
+				agg.Add("gauge", metric.NewMetric("kubernetes.logs.usage", c.Logs.UsedBytes, containerTags))
+			}
+		}
+	}
+}
+
+func submitCPU(agg metric.Aggregator, cpu *cpuStats, tags []string) {
+	if cpu == nil {
+		return
+	}
+	// Report cores rather than nanocores.
+	agg.Add("gauge", metric.NewMetric("kubernetes.cpu.usage.total", cpu.UsageNanoCores/1e9, tags))
+}
+
+func submitMemory(agg metric.Aggregator, mem *memoryStats, tags []string) {
+	if mem == nil {
+		return
+	}
+	fields := make(map[string]interface{})
+	for name, value := range map[string]*float64{
+		"usage":       mem.UsageBytes,
+		"working_set": mem.WorkingSetBytes,
+		"rss":         mem.RSSBytes,
+		"page_faults": mem.PageFaults,
+	} {
+		if value != nil {
+			fields[name] = *value
+		}
+	}
+	agg.AddMetrics("gauge", "kubernetes.memory", fields, tags, "")
+}
+
+func submitNetwork(agg metric.Aggregator, net *networkStats, tags []string) {
+	if net == nil {
+		return
+	}
+	agg.AddMetrics("rate", "kubernetes.network", map[string]interface{}{
+		"rx_bytes":  net.RxBytes,
+		"tx_bytes":  net.TxBytes,
+		"rx_errors": net.RxErrors,
+		"tx_errors": net.TxErrors,
+	}, tags, "")
+}
+
+func submitPods(agg metric.Aggregator, pods *podList, tags []string) {
+	running := make(map[string]int)
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != "Running" {
+			continue
+		}
+		running[pod.Metadata.Namespace]++
+
+		podTags := append(append([]string{}, tags...),
+			"kube_namespace:"+pod.Metadata.Namespace,
+			"pod_name:"+pod.Metadata.Name,
+		)
+
+		for _, c := range pod.Spec.Containers {
+			containerTags := append(append([]string{}, podTags...), "kube_container_name:"+c.Name)
+			for kind, resources := range map[string]map[string]string{
+				"requests": c.Resources.Requests,
+				"limits":   c.Resources.Limits,
+			} {
+				for resource, quantity := range resources {
+					value, err := parseQuantity(quantity)
+					if err != nil {
+						continue
+					}
+					name := fmt.Sprintf("kubernetes.%s.%s", resourceName(resource), kind)
+					agg.Add("gauge", metric.NewMetric(name, value, containerTags))
+				}
+			}
+		}
+
+		for _, status := range pod.Status.ContainerStatuses {
+			containerTags := append(append([]string{}, podTags...), "kube_container_name:"+status.Name)
+			agg.Add("gauge", metric.NewMetric("kubernetes.containers.restarts", status.RestartCount, containerTags))
+			if status.State.Running != nil {
+				agg.Add("gauge", metric.NewMetric("kubernetes.containers.running", 1, containerTags))
+			}
+		}
+	}
+
+	for namespace, count := range running {
+		nsTags := append(append([]string{}, tags...), "kube_namespace:"+namespace)
+		agg.Add("gauge", metric.NewMetric("kubernetes.pods.running", count, nsTags))
+	}
+}
+
+// resourceName maps the resource names of kubernetes to the metric names,
+// e.g. ephemeral-storage to ephemeral_storage.
+func resourceName(resource string) string {
+	return strings.Replace(resource, "-", "_", -1)
+}
+
+func init() {
+	collector.Add("kubernetes", NewKubernetes)
+}
@@ -0,0 +1,126 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const summaryResponse = `{
+  "node": {
+    "nodeName": "node-1",
+    "cpu": {"usageNanoCores": 500000000},
+    "memory": {"usageBytes": 2048, "workingSetBytes": 1024},
+    "fs": {"usedBytes": 10, "capacityBytes": 100}
+  },
+  "pods": [{
+    "podRef": {"name": "web-1", "namespace": "default"},
+    "network": {"rxBytes": 300, "txBytes": 400},
+    "containers": [{"name": "nginx", "cpu": {"usageNanoCores": 250000000}, "memory": {"rssBytes": 512}}]
+  }]
+}`
+
+const podsResponse = `{
+  "items": [{
+    "metadata": {"name": "web-1", "namespace": "default"},
+    "spec": {"containers": [{"name": "nginx", "resources": {"requests": {"cpu": "100m", "memory": "64Mi"}}}]},
+    "status": {"phase": "Running", "containerStatuses": [{"name": "nginx", "restartCount": 2, "state": {"running": {}}}]}
+  }, {
+    "metadata": {"name": "job-1", "namespace": "default"},
+    "status": {"phase": "Succeeded"}
+  }]
+}`
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			fmt.Fprint(w, "ok")
+		case "/stats/summary":
+			fmt.Fprint(w, summaryResponse)
+		case "/pods":
+			fmt.Fprint(w, podsResponse)
+		case "/spec/":
+			fmt.Fprint(w, `{"num_cores": 4, "memory_capacity": 8192}`)
+		case "/api/v1/nodes/node-1":
+			fmt.Fprint(w, `{"status": {"allocatable": {"cpu": "3800m", "memory": "7Gi", "pods": "110"}}}`)
+		}
+	}))
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	err := NewKubernetes(nil).Check(agg, plugin.Instance{
+		"kubelet_url":    server.URL,
+		"api_server_url": server.URL,
+	})
+	require.NoError(t, err)
+
+	pod := []string{"kube_namespace:default", "pod_name:web-1"}
+	container := append(pod, "kube_container_name:nginx")
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"kubernetes.kubelet.health", nil, 1},
+		{"kubernetes.cpu.usage.total", container, 0.25},
+		{"kubernetes.memory.working_set", nil, 1024},
+		{"kubernetes.memory.rss", container, 512},
+		{"kubernetes.network.rx_bytes", pod, 300},
+		{"kubernetes.filesystem.capacity", nil, 100},
+		{"kubernetes.cpu.requests", container, 0.1},
+		{"kubernetes.memory.requests", container, 64 * 1024 * 1024},
+		{"kubernetes.containers.restarts", container, 2},
+		{"kubernetes.pods.running", []string{"kube_namespace:default"}, 1},
+		{"kubernetes.cpu.capacity", nil, 4},
+		{"kubernetes.cpu.allocatable", nil, 3.8},
+		{"kubernetes.pods.allocatable", nil, 110},
+	} {
+		value, ok := agg.Value(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s not found", c.name) {
+			assert.InDelta(t, c.value, value, 1e-9, c.name)
+		}
+	}
+}
+
+func TestUnhealthyKubelet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	err := NewKubernetes(nil).Check(agg, plugin.Instance{"kubelet_url": server.URL})
+	assert.Error(t, err)
+
+	value, ok := agg.Value("kubernetes.kubelet.health")
+	assert.True(t, ok)
+	assert.Equal(t, float64(0), value)
+}
+
+func TestParseQuantity(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want float64
+	}{
+		{"2", 2},
+		{"100m", 0.1},
+		{"1Ki", 1024},
+		{"128Mi", 128 * 1024 * 1024},
+		{"1G", 1e9},
+		{"1e3", 1000},
+	} {
+		out, err := parseQuantity(c.in)
+		assert.NoError(t, err)
+		assert.InDelta(t, c.want, out, 1e-9, c.in)
+	}
+
+	_, err := parseQuantity("abc")
+	assert.Error(t, err)
+}
@@ -0,0 +1,143 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// summary is the response of the kubelet summary API (/stats/summary).
+type summary struct {
+	Node struct {
+		NodeName string        `json:"nodeName"`
+		CPU      *cpuStats     `json:"cpu"`
+		Memory   *memoryStats  `json:"memory"`
+		Network  *networkStats `json:"network"`
+		Fs       *fsStats      `json:"fs"`
+	} `json:"node"`
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"podRef"`
+		Containers []struct {
+			Name   string       `json:"name"`
+			CPU    *cpuStats    `json:"cpu"`
+			Memory *memoryStats `json:"memory"`
+			Rootfs *fsStats     `json:"rootfs"`
+			Logs   *fsStats     `json:"logs"`
+		} `json:"containers"`
+		Network          *networkStats `json:"network"`
+		EphemeralStorage *fsStats      `json:"ephemeral-storage"`
+	} `json:"pods"`
+}
+
+type cpuStats struct {
+	UsageNanoCores float64 `json:"usageNanoCores"`
+}
+
+// The memory stats are pointers as the kubelet omits the unknown ones.
+type memoryStats struct {
+	UsageBytes      *float64 `json:"usageBytes"`
+	WorkingSetBytes *float64 `json:"workingSetBytes"`
+	RSSBytes        *float64 `json:"rssBytes"`
+	PageFaults      *float64 `json:"pageFaults"`
+}
+
+type networkStats struct {
+	RxBytes  float64 `json:"rxBytes"`
+	RxErrors float64 `json:"rxErrors"`
+	TxBytes  float64 `json:"txBytes"`
+	TxErrors float64 `json:"txErrors"`
+}
+
+type fsStats struct {
+	UsedBytes     float64 `json:"usedBytes"`
+	CapacityBytes float64 `json:"capacityBytes"`
+}
+
+// machineSpec is the response of the cAdvisor machine spec (/spec/).
+type machineSpec struct {
+	NumCores       float64 `json:"num_cores"`
+	MemoryCapacity float64 `json:"memory_capacity"`
+}
+
+// podList is the response of the kubelet /pods endpoint.
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Spec struct {
+			Containers []struct {
+				Name      string `json:"name"`
+				Resources struct {
+					Requests map[string]string `json:"requests"`
+					Limits   map[string]string `json:"limits"`
+				} `json:"resources"`
+			} `json:"containers"`
+		} `json:"spec"`
+		Status struct {
+			Phase             string `json:"phase"`
+			ContainerStatuses []struct {
+				Name         string `json:"name"`
+				RestartCount int    `json:"restartCount"`
+				State        struct {
+					Running *struct{} `json:"running"`
+				} `json:"state"`
+			} `json:"containerStatuses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// node is the node object returned by the API server.
+type node struct {
+	Status struct {
+		Capacity    map[string]string `json:"capacity"`
+		Allocatable map[string]string `json:"allocatable"`
+	} `json:"status"`
+}
+
+var quantitySuffixes = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"Ki", 1 << 10},
+	{"Mi", 1 << 20},
+	{"Gi", 1 << 30},
+	{"Ti", 1 << 40},
+	{"Pi", 1 << 50},
+	{"Ei", 1 << 60},
+	{"n", 1e-9},
+	{"u", 1e-6},
+	{"m", 1e-3},
+	{"k", 1e3},
+	{"M", 1e6},
+	{"G", 1e9},
+	{"T", 1e12},
+	{"P", 1e15},
+	{"E", 1e18},
+}
+
+// parseQuantity parses a resource quantity of kubernetes, such as 100m (CPU)
+// or 128Mi (memory).
+func parseQuantity(quantity string) (float64, error) {
+	quantity = strings.TrimSpace(quantity)
+	for _, s := range quantitySuffixes {
+		if strings.HasSuffix(quantity, s.suffix) {
+			value, err := strconv.ParseFloat(strings.TrimSuffix(quantity, s.suffix), 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid quantity %q", quantity)
+			}
+			return value * s.multiplier, nil
+		}
+	}
+
+	// Plain numbers, optionally with an exponent like 1e3.
+	value, err := strconv.ParseFloat(quantity, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quantity %q", quantity)
+	}
+	return value, nil
+}
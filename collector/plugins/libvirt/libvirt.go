@@ -0,0 +1,265 @@
+package libvirt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// runCommand is replaced in tests.
+var runCommand = util.RunCommand
+
+// NewLibvirt XXX
+func NewLibvirt(conf plugin.InitConfig) plugin.Plugin {
+	return &Libvirt{
+		uuids: make(map[string]string),
+	}
+}
+
+// Libvirt collects the CPU, memory, block and network statistics of the
+// domains of a hypervisor, e.g. KVM, as reported by virsh domstats.
+type Libvirt struct {
+	sync.Mutex
+
+	// uuids caches the UUIDs of the domains by URI and name, they never
+	// change for the life of a domain.
+	uuids map[string]string
+}
+
+type instanceConfig struct {
+	Virsh string `yaml:"virsh"`
+	// URI is the connection URI of libvirt, e.g. qemu:///system
+	URI     string   `yaml:"uri"`
+	UseSudo bool     `yaml:"use_sudo"`
+	Timeout int      `yaml:"timeout"`
+	Tags    []string `yaml:"tags"`
+}
+
+// domain holds the statistics of a domain, the numbered ones (e.g.
+// net.0.rx.bytes) are grouped by their prefix.
+type domain struct {
+	name   string
+	stats  map[string]string
+	vcpus  map[string]map[string]string
+	nets   map[string]map[string]string
+	blocks map[string]map[string]string
+}
+
+// The counters of the devices, in ns for the times. The others are gauges.
+var (
+	cpuCounters = map[string]bool{
+		"cpu.time":   true,
+		"cpu.user":   true,
+		"cpu.system": true,
+	}
+	netCounters = map[string]bool{
+		"rx.bytes": true,
+		"rx.pkts":  true,
+		"rx.errs":  true,
+		"rx.drop":  true,
+		"tx.bytes": true,
+		"tx.pkts":  true,
+		"tx.errs":  true,
+		"tx.drop":  true,
+	}
+	blockCounters = map[string]bool{
+		"rd.reqs":  true,
+		"rd.bytes": true,
+		"rd.times": true,
+		"wr.reqs":  true,
+		"wr.bytes": true,
+		"wr.times": true,
+		"fl.reqs":  true,
+		"fl.times": true,
+	}
+	blockGauges = map[string]bool{
+		"allocation": true,
+		"capacity":   true,
+		"physical":   true,
+	}
+)
+
+// Check XXX
+func (l *Libvirt) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.Virsh == "" {
+		conf.Virsh = "virsh"
+	}
+
+	out, err := conf.virsh("domstats")
+	if err != nil {
+		return err
+	}
+	domains, err := parseDomStats(out)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range domains {
+		tags := append(append([]string{}, conf.Tags...), "domain:"+d.name)
+		if uuid := l.uuid(&conf, d.name, metric.Logger(agg)); uuid != "" {
+			tags = append(tags, "domain_uuid:"+uuid)
+		}
+		submit(agg, d, tags)
+	}
+	return nil
+}
+
+func (conf *instanceConfig) virsh(args ...string) ([]byte, error) {
+	if conf.URI != "" {
+		args = append([]string{"--connect", conf.URI}, args...)
+	}
+	name := conf.Virsh
+	if conf.UseSudo {
+		name, args = "sudo", append([]string{conf.Virsh}, args...)
+	}
+	return runCommand(time.Duration(conf.Timeout)*time.Second, name, args...)
+}
+
+// uuid returns the UUID of the domain, or "" if virsh failed to tell it.
+func (l *Libvirt) uuid(conf *instanceConfig, name string, logger log.Logger) string {
+	l.Lock()
+	defer l.Unlock()
+
+	key := conf.URI + "/" + name
+	if uuid, ok := l.uuids[key]; ok {
+		return uuid
+	}
+	out, err := conf.virsh("domuuid", name)
+	if err != nil {
+		logger.Warnf("Could not get the UUID of domain %s: %s", name, err)
+		return ""
+	}
+	uuid := strings.TrimSpace(string(out))
+	l.uuids[key] = uuid
+	return uuid
+}
+
+// parseDomStats parses the output of virsh domstats, which looks like:
+//
+//	Domain: 'web01'
+//	  state.state=1
+//	  cpu.time=2716942011567
+//	  net.0.rx.bytes=92837465
+func parseDomStats(out []byte) ([]*domain, error) {
+	var domains []*domain
+	var d *domain
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "Domain:") {
+			d = &domain{
+				name:   strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "Domain:")), "'"),
+				stats:  make(map[string]string),
+				vcpus:  make(map[string]map[string]string),
+				nets:   make(map[string]map[string]string),
+				blocks: make(map[string]map[string]string),
+			}
+			domains = append(domains, d)
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if d == nil || len(kv) != 2 {
+			return nil, fmt.Errorf("unexpected virsh domstats output: %q", line)
+		}
+		d.set(kv[0], kv[1])
+	}
+	return domains, scanner.Err()
+}
+
+func (d *domain) set(key, value string) {
+	parts := strings.SplitN(key, ".", 3)
+	if len(parts) == 3 {
+		var devices map[string]map[string]string
+		switch parts[0] {
+		case "vcpu":
+			devices = d.vcpus
+		case "net":
+			devices = d.nets
+		case "block":
+			devices = d.blocks
+		}
+		if _, err := strconv.Atoi(parts[1]); err == nil && devices != nil {
+			if devices[parts[1]] == nil {
+				devices[parts[1]] = make(map[string]string)
+			}
+			devices[parts[1]][parts[2]] = value
+			return
+		}
+	}
+	d.stats[key] = value
+}
+
+func submit(agg metric.Aggregator, d *domain, tags []string) {
+	for key, value := range d.stats {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		switch {
+		case cpuCounters[key]:
+			// The CPU times are in ns, the rate is the number of CPUs used.
+			agg.Add("rate", metric.NewMetric("libvirt."+key, v/1e9, tags))
+		case key == "state.state" || key == "vcpu.current" || key == "vcpu.maximum":
+			agg.Add("gauge", metric.NewMetric("libvirt."+key, v, tags))
+		case strings.HasPrefix(key, "balloon."):
+			// The memory sizes are in KiB.
+			agg.Add("gauge", metric.NewMetric("libvirt.memory."+strings.TrimPrefix(key, "balloon."), v*1024, tags))
+		}
+	}
+
+	for id, stats := range d.vcpus {
+		vcpuTags := append(append([]string{}, tags...), "vcpu:"+id)
+		if v, err := strconv.ParseFloat(stats["time"], 64); err == nil {
+			agg.Add("rate", metric.NewMetric("libvirt.vcpu.time", v/1e9, vcpuTags))
+		}
+	}
+
+	for _, stats := range d.nets {
+		netTags := append(append([]string{}, tags...), "interface:"+stats["name"])
+		submitDevice(agg, "libvirt.net.", stats, netCounters, nil, netTags)
+	}
+
+	for _, stats := range d.blocks {
+		blockTags := append(append([]string{}, tags...), "device:"+stats["name"])
+		submitDevice(agg, "libvirt.block.", stats, blockCounters, blockGauges, blockTags)
+	}
+}
+
+func submitDevice(agg metric.Aggregator, prefix string, stats map[string]string, counters, gauges map[string]bool, tags []string) {
+	for key, value := range stats {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		switch {
+		case counters[key]:
+			if strings.HasSuffix(key, ".times") {
+				v /= 1e9
+			}
+			agg.Add("rate", metric.NewMetric(prefix+key, v, tags))
+		case gauges[key]:
+			agg.Add("gauge", metric.NewMetric(prefix+key, v, tags))
+		}
+	}
+}
+
+func init() {
+	collector.Add("libvirt", NewLibvirt)
+}
@@ -0,0 +1,87 @@
+package libvirt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	output, err := ioutil.ReadFile("testdata/domstats")
+	require.NoError(t, err)
+
+	var commands []string
+	runCommand = func(timeout time.Duration, name string, args ...string) ([]byte, error) {
+		command := name + " " + strings.Join(args, " ")
+		commands = append(commands, command)
+		switch {
+		case strings.HasSuffix(command, "domstats"):
+			return output, nil
+		case strings.HasSuffix(command, "domuuid web01"):
+			return []byte("b6a5e1f2-3c4d-4e5f-8a9b-0c1d2e3f4a5b\n\n"), nil
+		}
+		return nil, fmt.Errorf("virsh failed: exit status 1: error: failed to get domain")
+	}
+
+	l := NewLibvirt(nil)
+	agg := &metric.MockAggregator{}
+	instance := plugin.Instance{
+		"uri":  "qemu:///system",
+		"tags": []interface{}{"env:prod"},
+	}
+	require.NoError(t, l.Check(agg, instance))
+	assert.Equal(t, "virsh --connect qemu:///system domstats", commands[0])
+
+	web := []string{"env:prod", "domain:web01", "domain_uuid:b6a5e1f2-3c4d-4e5f-8a9b-0c1d2e3f4a5b"}
+	for _, c := range []struct {
+		name       string
+		tags       []string
+		value      float64
+		metricType string
+	}{
+		{"libvirt.state.state", web, 1, "gauge"},
+		{"libvirt.cpu.time", web, 2716.942011567, "rate"},
+		{"libvirt.vcpu.current", web, 2, "gauge"},
+		{"libvirt.vcpu.time", append([]string{"vcpu:1"}, web...), 1258.27, "rate"},
+		{"libvirt.memory.current", web, 2147483648, "gauge"},
+		{"libvirt.memory.rss", web, 1638584320, "gauge"},
+		{"libvirt.net.rx.bytes", append([]string{"interface:vnet0"}, web...), 92837465, "rate"},
+		{"libvirt.net.rx.drop", append([]string{"interface:vnet0"}, web...), 3, "rate"},
+		{"libvirt.block.wr.bytes", append([]string{"device:vda"}, web...), 107380736, "rate"},
+		{"libvirt.block.rd.times", append([]string{"device:vda"}, web...), 5.189567705, "rate"},
+		{"libvirt.block.capacity", append([]string{"device:vda"}, web...), 21474836480, "gauge"},
+		{"libvirt.block.rd.reqs", append([]string{"device:hdc"}, web...), 0, "rate"},
+		{"libvirt.state.state", []string{"domain:db01"}, 5, "gauge"},
+		{"libvirt.memory.maximum", []string{"domain:db01"}, 8589934592, "gauge"},
+	} {
+		m, ok := agg.Get(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			assert.InDelta(t, c.value, m.Value, 1e-6, c.name)
+			assert.Equal(t, c.metricType, m.Type, c.name)
+		}
+	}
+	assert.NotContains(t, agg.Names(), "libvirt.state.reason")
+	assert.NotContains(t, agg.Names(), "libvirt.block.path")
+	_, ok := agg.Get("libvirt.state.state", "domain:db01", "domain_uuid:")
+	assert.False(t, ok)
+
+	// The UUIDs are cached.
+	commands = nil
+	require.NoError(t, l.Check(&metric.MockAggregator{}, instance))
+	assert.Equal(t, []string{
+		"virsh --connect qemu:///system domstats",
+		"virsh --connect qemu:///system domuuid db01",
+	}, commands)
+}
+
+func TestParseDomStatsInvalid(t *testing.T) {
+	_, err := parseDomStats([]byte("error: failed to connect to the hypervisor\n"))
+	assert.Error(t, err)
+}
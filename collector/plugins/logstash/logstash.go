@@ -0,0 +1,117 @@
+package logstash
+
+import (
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// NewLogstash XXX
+func NewLogstash(conf plugin.InitConfig) plugin.Plugin {
+	return &Logstash{}
+}
+
+// Logstash reports the event throughput of the Logstash pipelines, their
+// queues and the JVM from the node stats API.
+type Logstash struct{}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	URL  string   `yaml:"url"`
+	Tags []string `yaml:"tags"`
+}
+
+type def struct {
+	metricType string
+	name       string
+	// scale converts the millisecond durations to seconds.
+	scale float64
+}
+
+var nodeMetrics = map[string]def{
+	"events.in":                                         {"rate", "logstash.events.in", 1},
+	"events.filtered":                                   {"rate", "logstash.events.filtered", 1},
+	"events.out":                                        {"rate", "logstash.events.out", 1},
+	"events.duration_in_millis":                         {"rate", "logstash.events.duration", 0.001},
+	"events.queue_push_duration_in_millis":              {"rate", "logstash.events.queue_push_duration", 0.001},
+	"jvm.threads.count":                                 {"gauge", "logstash.jvm.threads.count", 1},
+	"jvm.mem.heap_used_percent":                         {"gauge", "logstash.jvm.mem.heap_used_percent", 1},
+	"jvm.mem.heap_used_in_bytes":                        {"gauge", "logstash.jvm.mem.heap_used", 1},
+	"jvm.mem.heap_max_in_bytes":                         {"gauge", "logstash.jvm.mem.heap_max", 1},
+	"jvm.gc.collectors.old.collection_count":            {"rate", "logstash.jvm.gc.old.count", 1},
+	"jvm.gc.collectors.old.collection_time_in_millis":   {"rate", "logstash.jvm.gc.old.time", 0.001},
+	"jvm.gc.collectors.young.collection_count":          {"rate", "logstash.jvm.gc.young.count", 1},
+	"jvm.gc.collectors.young.collection_time_in_millis": {"rate", "logstash.jvm.gc.young.time", 0.001},
+	"process.cpu.percent":                               {"gauge", "logstash.process.cpu.percent", 1},
+	"process.open_file_descriptors":                     {"gauge", "logstash.process.open_file_descriptors", 1},
+}
+
+var pipelineMetrics = map[string]def{
+	"events.in":                             {"rate", "logstash.pipeline.events.in", 1},
+	"events.filtered":                       {"rate", "logstash.pipeline.events.filtered", 1},
+	"events.out":                            {"rate", "logstash.pipeline.events.out", 1},
+	"events.duration_in_millis":             {"rate", "logstash.pipeline.events.duration", 0.001},
+	"events.queue_push_duration_in_millis":  {"rate", "logstash.pipeline.events.queue_push_duration", 0.001},
+	"reloads.successes":                     {"rate", "logstash.pipeline.reloads.successes", 1},
+	"reloads.failures":                      {"rate", "logstash.pipeline.reloads.failures", 1},
+	"queue.events":                          {"gauge", "logstash.pipeline.queue.events", 1},
+	"queue.events_count":                    {"gauge", "logstash.pipeline.queue.events", 1},
+	"queue.queue_size_in_bytes":             {"gauge", "logstash.pipeline.queue.size", 1},
+	"queue.max_queue_size_in_bytes":         {"gauge", "logstash.pipeline.queue.max_size", 1},
+	"dead_letter_queue.queue_size_in_bytes": {"gauge", "logstash.pipeline.dead_letter_queue.size", 1},
+}
+
+// Check XXX
+func (l *Logstash) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.URL == "" {
+		conf.URL = "http://localhost:9600"
+	}
+
+	var stats map[string]interface{}
+	if err := conf.GetJSON(strings.TrimSuffix(conf.URL, "/")+"/_node/stats", &stats); err != nil {
+		return err
+	}
+
+	tags := conf.Tags
+	if name, ok := stats["name"].(string); ok {
+		tags = append(append([]string{}, tags...), "node_name:"+name)
+	}
+	submit(agg, nodeMetrics, stats, tags)
+
+	// Logstash 5 has a single pipeline.
+	pipelines, _ := stats["pipelines"].(map[string]interface{})
+	if pipeline, ok := stats["pipeline"].(map[string]interface{}); ok && pipelines == nil {
+		pipelines = map[string]interface{}{"main": pipeline}
+	}
+	for name, p := range pipelines {
+		pipeline, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		pipelineTags := append(append([]string{}, tags...), "pipeline_name:"+name)
+		submit(agg, pipelineMetrics, pipeline, pipelineTags)
+	}
+	return nil
+}
+
+func submit(agg metric.Aggregator, defs map[string]def, data map[string]interface{}, tags []string) {
+	for path, d := range defs {
+		if value, ok := util.GetValue(data, path); ok {
+			if v, ok := value.(float64); ok {
+				agg.Add(d.metricType, metric.NewMetric(d.name, v*d.scale, tags))
+			}
+		}
+	}
+}
+
+func init() {
+	collector.Add("logstash", NewLogstash)
+}
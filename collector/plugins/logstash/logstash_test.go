@@ -0,0 +1,77 @@
+package logstash
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_node/stats", r.URL.Path)
+		fmt.Fprintln(w, `{
+			"name": "ls01",
+			"jvm": {"mem": {"heap_used_percent": 31, "heap_used_in_bytes": 330000000},
+			        "gc": {"collectors": {"old": {"collection_time_in_millis": 1500, "collection_count": 3}}}},
+			"process": {"cpu": {"percent": 12}},
+			"events": {"in": 10000, "filtered": 9990, "out": 9980, "duration_in_millis": 52000},
+			"pipelines": {
+				"main": {
+					"events": {"in": 8000, "filtered": 8000, "out": 7990, "queue_push_duration_in_millis": 300},
+					"reloads": {"successes": 2, "failures": 1},
+					"queue": {"type": "persisted", "events_count": 42, "queue_size_in_bytes": 4096}
+				},
+				"beats": {"events": {"in": 2000, "out": 1990}, "queue": {"type": "memory"}}
+			}
+		}`)
+	}))
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewLogstash(nil).Check(agg, plugin.Instance{"url": server.URL + "/"}))
+	for _, c := range []struct {
+		name       string
+		tags       []string
+		value      float64
+		metricType string
+	}{
+		{"logstash.events.in", nil, 10000, "rate"},
+		{"logstash.events.duration", nil, 52, "rate"},
+		{"logstash.jvm.mem.heap_used_percent", nil, 31, "gauge"},
+		{"logstash.jvm.gc.old.time", nil, 1.5, "rate"},
+		{"logstash.process.cpu.percent", nil, 12, "gauge"},
+		{"logstash.pipeline.events.out", []string{"pipeline_name:main"}, 7990, "rate"},
+		{"logstash.pipeline.events.queue_push_duration", []string{"pipeline_name:main"}, 0.3, "rate"},
+		{"logstash.pipeline.reloads.failures", []string{"pipeline_name:main"}, 1, "rate"},
+		{"logstash.pipeline.queue.events", []string{"pipeline_name:main"}, 42, "gauge"},
+		{"logstash.pipeline.queue.size", []string{"pipeline_name:main"}, 4096, "gauge"},
+		{"logstash.pipeline.events.in", []string{"pipeline_name:beats"}, 2000, "rate"},
+	} {
+		m, ok := agg.Get(c.name, append(c.tags, "node_name:ls01")...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			assert.InDelta(t, c.value, m.Value, 1e-9, c.name)
+			assert.Equal(t, c.metricType, m.Type, c.name)
+		}
+	}
+	_, ok := agg.Get("logstash.pipeline.queue.events", "pipeline_name:beats")
+	assert.False(t, ok)
+}
+
+func TestCheckSinglePipeline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"name": "ls01", "pipeline": {"events": {"in": 500}}}`)
+	}))
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewLogstash(nil).Check(agg, plugin.Instance{"url": server.URL}))
+	value, ok := agg.Value("logstash.pipeline.events.in", "pipeline_name:main")
+	assert.True(t, ok)
+	assert.Equal(t, float64(500), value)
+}
@@ -0,0 +1,237 @@
+package memcached
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+const (
+	defaultPort    = 11211
+	defaultTimeout = 5 * time.Second
+)
+
+// NewMemcached XXX
+func NewMemcached(conf plugin.InitConfig) plugin.Plugin {
+	return &Memcached{}
+}
+
+// Memcached collects the output of the stats command, and optionally of the
+// stats slabs and stats items commands.
+type Memcached struct{}
+
+type instanceConfig struct {
+	URL    string `yaml:"url"`
+	Port   int    `yaml:"port"`
+	Socket string `yaml:"socket"`
+	// Slabs and Items enable the per-slab metrics.
+	Slabs bool     `yaml:"slabs"`
+	Items bool     `yaml:"items"`
+	Tags  []string `yaml:"tags"`
+}
+
+var gauges = map[string]string{
+	"uptime":                "memcache.uptime",
+	"curr_items":            "memcache.curr_items",
+	"bytes":                 "memcache.bytes",
+	"curr_connections":      "memcache.curr_connections",
+	"connection_structures": "memcache.connection_structures",
+	"threads":               "memcache.threads",
+	"limit_maxbytes":        "memcache.limit_maxbytes",
+	"pointer_size":          "memcache.pointer_size",
+}
+
+var rates = map[string]string{
+	"rusage_user":         "memcache.rusage_user_rate",
+	"rusage_system":       "memcache.rusage_system_rate",
+	"total_items":         "memcache.total_items",
+	"total_connections":   "memcache.total_connections",
+	"cmd_get":             "memcache.cmd_get_rate",
+	"cmd_set":             "memcache.cmd_set_rate",
+	"cmd_flush":           "memcache.cmd_flush_rate",
+	"get_hits":            "memcache.get_hits_rate",
+	"get_misses":          "memcache.get_misses_rate",
+	"delete_misses":       "memcache.delete_misses_rate",
+	"delete_hits":         "memcache.delete_hits_rate",
+	"evictions":           "memcache.evictions_rate",
+	"bytes_read":          "memcache.bytes_read_rate",
+	"bytes_written":       "memcache.bytes_written_rate",
+	"listen_disabled_num": "memcache.listen_disabled_num_rate",
+}
+
+// Per-slab counters submitted as rates, other slab stats are gauges.
+var slabRates = map[string]bool{
+	"get_hits":          true,
+	"cmd_set":           true,
+	"delete_hits":       true,
+	"incr_hits":         true,
+	"decr_hits":         true,
+	"cas_hits":          true,
+	"cas_badval":        true,
+	"touch_hits":        true,
+	"evicted":           true,
+	"evicted_time":      false,
+	"outofmemory":       true,
+	"reclaimed":         true,
+	"expired_unfetched": true,
+	"evicted_unfetched": true,
+}
+
+// Check XXX
+func (m *Memcached) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+
+	network, address, tags := "tcp", conf.Socket, conf.Tags
+	if conf.Socket != "" {
+		network = "unix"
+		tags = append(append([]string{}, tags...), "socket:"+conf.Socket)
+	} else {
+		if conf.URL == "" {
+			return fmt.Errorf("either url or socket should be set")
+		}
+		if conf.Port == 0 {
+			conf.Port = defaultPort
+		}
+		address = net.JoinHostPort(conf.URL, strconv.Itoa(conf.Port))
+		tags = append(append([]string{}, tags...),
+			"url:"+conf.URL,
+			"port:"+strconv.Itoa(conf.Port),
+		)
+	}
+
+	conn, err := net.DialTimeout(network, address, defaultTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to connect to memcached %s: %s", address, err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetDeadline(time.Now().Add(defaultTimeout))
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	stats, err := sendCommand(rw, "stats")
+	if err != nil {
+		return err
+	}
+	submitStats(agg, stats, tags)
+
+	if conf.Slabs {
+		slabs, err := sendCommand(rw, "stats slabs")
+		if err != nil {
+			return err
+		}
+		submitSlabs(agg, "memcache.slabs", slabs, tags)
+	}
+
+	if conf.Items {
+		items, err := sendCommand(rw, "stats items")
+		if err != nil {
+			return err
+		}
+		submitSlabs(agg, "memcache.items", items, tags)
+	}
+
+	return nil
+}
+
+// sendCommand sends a stats command and reads the "STAT <name> <value>"
+// lines of the response, until END.
+func sendCommand(rw *bufio.ReadWriter, command string) (map[string]string, error) {
+	if _, err := rw.WriteString(command + "\r\n"); err != nil {
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]string)
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("error reading the response of %s: %s", command, err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "END" {
+			return stats, nil
+		}
+		if strings.HasSuffix(line, "ERROR") {
+			return nil, fmt.Errorf("memcached returned %s for %s", line, command)
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "STAT" {
+			stats[fields[1]] = fields[2]
+		}
+	}
+}
+
+func submitStats(agg metric.Aggregator, stats map[string]string, tags []string) {
+	values := make(map[string]float64)
+	for key, raw := range stats {
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			continue
+		}
+		values[key] = value
+
+		if name, ok := gauges[key]; ok {
+			agg.Add("gauge", metric.NewMetric(name, value, tags))
+		}
+		if name, ok := rates[key]; ok {
+			agg.Add("rate", metric.NewMetric(name, value, tags))
+		}
+	}
+
+	if values["cmd_get"] > 0 {
+		agg.Add("gauge", metric.NewMetric("memcache.get_hit_percent",
+			100*values["get_hits"]/values["cmd_get"], tags))
+	}
+	if values["limit_maxbytes"] > 0 {
+		agg.Add("gauge", metric.NewMetric("memcache.fill_percent",
+			100*values["bytes"]/values["limit_maxbytes"], tags))
+	}
+	if values["curr_items"] > 0 {
+		agg.Add("gauge", metric.NewMetric("memcache.avg_item_size",
+			values["bytes"]/values["curr_items"], tags))
+	}
+}
+
+// submitSlabs submits the output of stats slabs ("1:chunk_size 96") and
+// stats items ("items:1:number 5") tagged by slab, the global stats of
+// stats slabs ("active_slabs 1") are submitted untagged.
+func submitSlabs(agg metric.Aggregator, prefix string, stats map[string]string, tags []string) {
+	for key, raw := range stats {
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			continue
+		}
+
+		parts := strings.Split(strings.TrimPrefix(key, "items:"), ":")
+		metricTags := tags
+		name := parts[0]
+		if len(parts) == 2 {
+			metricTags = append(append([]string{}, tags...), "slab:"+parts[0])
+			name = parts[1]
+		}
+
+		metricType := "gauge"
+		if slabRates[name] {
+			metricType = "rate"
+		}
+		agg.Add(metricType, metric.NewMetric(prefix+"."+name, value, metricTags))
+	}
+}
+
+func init() {
+	collector.Add("memcached", NewMemcached)
+}
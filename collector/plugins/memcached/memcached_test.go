@@ -0,0 +1,101 @@
+package memcached
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var responses = map[string]string{
+	"stats": "STAT pid 1\r\nSTAT uptime 100\r\nSTAT version 1.4.25\r\nSTAT cmd_get 10\r\n" +
+		"STAT get_hits 8\r\nSTAT bytes 512\r\nSTAT curr_items 4\r\nSTAT limit_maxbytes 1024\r\n" +
+		"STAT evictions 3\r\nEND\r\n",
+	"stats slabs": "STAT 1:chunk_size 96\r\nSTAT 1:get_hits 7\r\nSTAT active_slabs 1\r\nEND\r\n",
+	"stats items": "STAT items:1:number 4\r\nSTAT items:1:evicted 2\r\nEND\r\n",
+}
+
+func fakeMemcached(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			resp, ok := responses[strings.TrimSpace(line)]
+			if !ok {
+				resp = "ERROR\r\n"
+			}
+			fmt.Fprint(conn, resp)
+		}
+	}()
+	return l
+}
+
+func TestCheck(t *testing.T) {
+	l := fakeMemcached(t)
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	agg := &metric.MockAggregator{}
+	err := NewMemcached(nil).Check(agg, plugin.Instance{
+		"url":   host,
+		"port":  portNum,
+		"slabs": true,
+		"items": true,
+	})
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"memcache.uptime", []string{"url:" + host, "port:" + port}, 100},
+		{"memcache.get_hit_percent", nil, 80},
+		{"memcache.fill_percent", nil, 50},
+		{"memcache.avg_item_size", nil, 128},
+		{"memcache.evictions_rate", nil, 3},
+		{"memcache.slabs.chunk_size", []string{"slab:1"}, 96},
+		{"memcache.slabs.active_slabs", nil, 1},
+		{"memcache.items.number", []string{"slab:1"}, 4},
+	} {
+		value, ok := agg.Value(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s not found", c.name) {
+			assert.Equal(t, c.value, value, c.name)
+		}
+	}
+
+	for name, metricType := range map[string]string{
+		"memcache.evictions_rate":   "rate",
+		"memcache.slabs.get_hits":   "rate",
+		"memcache.items.evicted":    "rate",
+		"memcache.slabs.chunk_size": "gauge",
+	} {
+		m, _ := agg.Get(name)
+		assert.Equal(t, metricType, m.Type, name)
+	}
+}
+
+func TestCheckWithoutAddress(t *testing.T) {
+	err := NewMemcached(nil).Check(&metric.MockAggregator{}, plugin.Instance{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,203 @@
+package mssql
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// PageSize is the unit of the sizes of the database files.
+const PageSize = 8 * 1024
+
+// The types of sys.dm_os_performance_counters, the others are the last
+// observed values.
+const (
+	// counterBulk is cumulative, the /sec counters.
+	counterBulk = 272696576
+	// counterFraction is divided by the "<name> base" counter.
+	counterFraction = 537003264
+)
+
+// runCommand is replaced in tests.
+var runCommand = util.RunCommandWithEnv
+
+// NewMSSQL XXX
+func NewMSSQL(conf plugin.InitConfig) plugin.Plugin {
+	return &MSSQL{}
+}
+
+// MSSQL collects the performance counters and the database file sizes of a
+// Microsoft SQL Server with sqlcmd.
+type MSSQL struct{}
+
+type instanceConfig struct {
+	Sqlcmd string `yaml:"sqlcmd"`
+	// Host is the server, e.g. localhost,1433 or localhost\SQLEXPRESS.
+	Host string `yaml:"host"`
+	// Username and Password use SQL Server authentication, Windows
+	// authentication is used without them.
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	Timeout  int      `yaml:"timeout"`
+	Tags     []string `yaml:"tags"`
+}
+
+type counter struct {
+	object   string
+	name     string
+	instance string
+	metric   string
+}
+
+// counters are matched by the suffix of the object, which is prefixed by
+// SQLServer or MSSQL$<instance> for named instances.
+var counters = []counter{
+	{"Buffer Manager", "Buffer cache hit ratio", "", "mssql.buffer.cache_hit_ratio"},
+	{"Buffer Manager", "Page life expectancy", "", "mssql.buffer.page_life_expectancy"},
+	{"SQL Statistics", "Batch Requests/sec", "", "mssql.stats.batch_requests"},
+	{"SQL Statistics", "SQL Compilations/sec", "", "mssql.stats.sql_compilations"},
+	{"SQL Statistics", "SQL Re-Compilations/sec", "", "mssql.stats.sql_recompilations"},
+	{"General Statistics", "User Connections", "", "mssql.stats.connections"},
+	{"Locks", "Lock Waits/sec", "_Total", "mssql.stats.lock_waits"},
+	{"Locks", "Number of Deadlocks/sec", "_Total", "mssql.stats.deadlocks"},
+}
+
+const countersQuery = `SET NOCOUNT ON;
+SELECT RTRIM(object_name), RTRIM(counter_name), RTRIM(instance_name), cntr_value, cntr_type
+FROM sys.dm_os_performance_counters
+WHERE object_name LIKE '%Buffer Manager%' OR object_name LIKE '%SQL Statistics%'
+OR object_name LIKE '%General Statistics%' OR (object_name LIKE '%Locks%' AND instance_name = '_Total')`
+
+const filesQuery = `SET NOCOUNT ON;
+SELECT DB_NAME(database_id), name, type_desc, size FROM sys.master_files`
+
+// Check XXX
+func (m *MSSQL) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.Sqlcmd == "" {
+		conf.Sqlcmd = "sqlcmd"
+	}
+	if conf.Host == "" {
+		conf.Host = "localhost"
+	}
+	tags := append(append([]string{}, conf.Tags...), "mssql_host:"+conf.Host)
+
+	rows, err := conf.query(countersQuery)
+	if err != nil {
+		return err
+	}
+	if err = submitCounters(agg, rows, tags); err != nil {
+		return err
+	}
+
+	rows, err = conf.query(filesQuery)
+	if err != nil {
+		return err
+	}
+	return submitFiles(agg, rows, tags)
+}
+
+// query runs the query with sqlcmd and returns the columns of the rows.
+func (conf *instanceConfig) query(query string) ([][]string, error) {
+	args := []string{"-S", conf.Host, "-h", "-1", "-W", "-s", "|", "-b", "-Q", query}
+	var env []string
+	if conf.Username != "" {
+		// The password is passed in the environment, the arguments show up
+		// in the process list.
+		args = append(args, "-U", conf.Username)
+		env = []string{"SQLCMDPASSWORD=" + conf.Password}
+	} else {
+		args = append(args, "-E")
+	}
+	out, err := runCommand(time.Duration(conf.Timeout)*time.Second, env, conf.Sqlcmd, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			rows = append(rows, strings.Split(line, "|"))
+		}
+	}
+	return rows, scanner.Err()
+}
+
+func submitCounters(agg metric.Aggregator, rows [][]string, tags []string) error {
+	type key struct{ object, name, instance string }
+	values := make(map[key]float64)
+	types := make(map[key]int)
+	for _, row := range rows {
+		if len(row) != 5 {
+			return fmt.Errorf("unexpected sqlcmd output: %q", strings.Join(row, "|"))
+		}
+		value, err := strconv.ParseFloat(row[3], 64)
+		if err != nil {
+			return fmt.Errorf("invalid value of counter %s: %s", row[1], err)
+		}
+		// The object is prefixed by SQLServer: or MSSQL$<instance>:
+		object := row[0]
+		if i := strings.Index(object, ":"); i >= 0 {
+			object = object[i+1:]
+		}
+		k := key{object, row[1], row[2]}
+		values[k] = value
+		types[k], _ = strconv.Atoi(row[4])
+	}
+
+	for _, c := range counters {
+		k := key{c.object, c.name, c.instance}
+		value, ok := values[k]
+		if !ok {
+			continue
+		}
+		switch types[k] {
+		case counterBulk:
+			agg.Add("rate", metric.NewMetric(c.metric, value, tags))
+		case counterFraction:
+			base := values[key{c.object, c.name + " base", c.instance}]
+			if base > 0 {
+				agg.Add("gauge", metric.NewMetric(c.metric, value/base*100, tags))
+			}
+		default:
+			agg.Add("gauge", metric.NewMetric(c.metric, value, tags))
+		}
+	}
+	return nil
+}
+
+func submitFiles(agg metric.Aggregator, rows [][]string, tags []string) error {
+	for _, row := range rows {
+		if len(row) != 4 {
+			return fmt.Errorf("unexpected sqlcmd output: %q", strings.Join(row, "|"))
+		}
+		pages, err := strconv.ParseFloat(row[3], 64)
+		if err != nil {
+			return fmt.Errorf("invalid size of file %s: %s", row[1], err)
+		}
+		fileTags := append(append([]string{}, tags...),
+			"database:"+row[0],
+			"file:"+row[1],
+			"file_type:"+strings.ToLower(row[2]),
+		)
+		agg.Add("gauge", metric.NewMetric("mssql.database.file.size", pages*PageSize, fileTags))
+	}
+	return nil
+}
+
+func init() {
+	collector.Add("mssql", NewMSSQL)
+}
@@ -0,0 +1,88 @@
+package mssql
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	counters, err := ioutil.ReadFile("testdata/counters")
+	require.NoError(t, err)
+	files, err := ioutil.ReadFile("testdata/files")
+	require.NoError(t, err)
+
+	var commands [][]string
+	var envs [][]string
+	runCommand = func(timeout time.Duration, env []string, name string, args ...string) ([]byte, error) {
+		commands = append(commands, append([]string{name}, args...))
+		envs = append(envs, env)
+		if strings.Contains(strings.Join(args, " "), "sys.master_files") {
+			return files, nil
+		}
+		return counters, nil
+	}
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewMSSQL(nil).Check(agg, plugin.Instance{
+		"host":     `db01\SQLEXPRESS`,
+		"username": "datadog",
+		"password": "secret",
+	}))
+	require.Len(t, commands, 2)
+	assert.Equal(t, []string{"sqlcmd", "-S", `db01\SQLEXPRESS`}, commands[0][:3])
+	assert.Equal(t, []string{"-U", "datadog"}, commands[0][len(commands[0])-2:])
+	assert.NotContains(t, commands[0], "secret")
+	assert.Equal(t, []string{"SQLCMDPASSWORD=secret"}, envs[0])
+
+	host := `mssql_host:db01\SQLEXPRESS`
+	for _, c := range []struct {
+		name       string
+		tags       []string
+		value      float64
+		metricType string
+	}{
+		{"mssql.buffer.cache_hit_ratio", nil, 99.5, "gauge"},
+		{"mssql.buffer.page_life_expectancy", nil, 41237, "gauge"},
+		{"mssql.stats.batch_requests", nil, 1893742, "rate"},
+		{"mssql.stats.sql_recompilations", nil, 127, "rate"},
+		{"mssql.stats.connections", nil, 42, "gauge"},
+		{"mssql.stats.lock_waits", nil, 318, "rate"},
+		{"mssql.stats.deadlocks", nil, 2, "rate"},
+		{"mssql.database.file.size", []string{"database:shop", "file:shop", "file_type:rows"}, 10485760000, "gauge"},
+		{"mssql.database.file.size", []string{"database:master", "file:mastlog", "file_type:log"}, 2097152, "gauge"},
+	} {
+		m, ok := agg.Get(c.name, append(c.tags, host)...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			assert.InDelta(t, c.value, m.Value, 1e-6, c.name)
+			assert.Equal(t, c.metricType, m.Type, c.name)
+		}
+	}
+}
+
+func TestCheckWindowsAuth(t *testing.T) {
+	var commands [][]string
+	runCommand = func(timeout time.Duration, env []string, name string, args ...string) ([]byte, error) {
+		assert.Nil(t, env)
+		commands = append(commands, append([]string{name}, args...))
+		return nil, nil
+	}
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewMSSQL(nil).Check(agg, plugin.Instance{}))
+	require.Len(t, commands, 2)
+	assert.Equal(t, []string{"sqlcmd", "-S", "localhost"}, commands[0][:3])
+	assert.Equal(t, "-E", commands[0][len(commands[0])-1])
+	assert.Empty(t, agg.Metrics)
+}
+
+func TestSubmitCountersInvalid(t *testing.T) {
+	err := submitCounters(&metric.MockAggregator{}, [][]string{{"Msg 18456, Level 14, State 1"}}, nil)
+	assert.Error(t, err)
+}
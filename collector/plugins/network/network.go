@@ -0,0 +1,253 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewNetwork XXX
+func NewNetwork(conf plugin.InitConfig) plugin.Plugin {
+	return &Network{}
+}
+
+// Network collects the TCP connection states, the protocol counters and the
+// conntrack usage from /proc, it only works on Linux.
+type Network struct{}
+
+type instanceConfig struct {
+	// ProcfsPath is where the procfs of the host is mounted, e.g. /host/proc
+	// when the agent runs in a container.
+	ProcfsPath string   `yaml:"procfs_path"`
+	Conntrack  *bool    `yaml:"collect_conntrack_metrics"`
+	Tags       []string `yaml:"tags"`
+}
+
+// tcpStates are the connection states of /proc/net/tcp, see
+// include/net/tcp_states.h
+var tcpStates = map[string]string{
+	"01": "established",
+	"02": "syn_sent",
+	"03": "syn_recv",
+	"04": "fin_wait1",
+	"05": "fin_wait2",
+	"06": "time_wait",
+	"07": "close",
+	"08": "close_wait",
+	"09": "last_ack",
+	"0A": "listen",
+	"0B": "closing",
+}
+
+// counters maps the counters of /proc/net/snmp and /proc/net/netstat to
+// metric names, they're submitted as rates.
+var counters = map[string]string{
+	"Ip.InDiscards":           "system.net.ip.in_discards",
+	"Ip.InHdrErrors":          "system.net.ip.in_header_errors",
+	"Ip.OutDiscards":          "system.net.ip.out_discards",
+	"Tcp.ActiveOpens":         "system.net.tcp.active_opens",
+	"Tcp.PassiveOpens":        "system.net.tcp.passive_opens",
+	"Tcp.AttemptFails":        "system.net.tcp.attempt_fails",
+	"Tcp.EstabResets":         "system.net.tcp.established_resets",
+	"Tcp.InSegs":              "system.net.tcp.in_segs",
+	"Tcp.OutSegs":             "system.net.tcp.out_segs",
+	"Tcp.RetransSegs":         "system.net.tcp.retrans_segs",
+	"Tcp.InErrs":              "system.net.tcp.in_errors",
+	"Tcp.OutRsts":             "system.net.tcp.out_resets",
+	"Udp.InDatagrams":         "system.net.udp.in_datagrams",
+	"Udp.OutDatagrams":        "system.net.udp.out_datagrams",
+	"Udp.NoPorts":             "system.net.udp.no_ports",
+	"Udp.InErrors":            "system.net.udp.in_errors",
+	"Udp.RcvbufErrors":        "system.net.udp.rcv_buf_errors",
+	"Udp.SndbufErrors":        "system.net.udp.snd_buf_errors",
+	"TcpExt.ListenOverflows":  "system.net.tcp.listen_overflows",
+	"TcpExt.ListenDrops":      "system.net.tcp.listen_drops",
+	"TcpExt.TCPBacklogDrop":   "system.net.tcp.backlog_drops",
+	"TcpExt.TCPTimeouts":      "system.net.tcp.timeouts",
+	"TcpExt.TCPAbortOnMemory": "system.net.tcp.abort_on_memory",
+	"TcpExt.SyncookiesSent":   "system.net.tcp.syncookies_sent",
+	"TcpExt.PruneCalled":      "system.net.tcp.prune_called",
+	"TcpExt.TCPRcvQDrop":      "system.net.tcp.rcv_queue_drops",
+	"TcpExt.TCPReqQFullDrop":  "system.net.tcp.req_queue_full_drops",
+}
+
+// Check XXX
+func (n *Network) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.ProcfsPath == "" {
+		conf.ProcfsPath = "/proc"
+	}
+
+	if err := n.collectStates(agg, &conf); err != nil {
+		return err
+	}
+
+	for _, file := range []string{"net/snmp", "net/netstat"} {
+		values, err := parseCounters(filepath.Join(conf.ProcfsPath, file))
+		if err != nil {
+			return err
+		}
+		for key, name := range counters {
+			if value, ok := values[key]; ok {
+				agg.Add("rate", metric.NewMetric(name, value, conf.Tags))
+			}
+		}
+	}
+
+	if err := n.collectSoftnet(agg, &conf); err != nil {
+		return err
+	}
+
+	if conf.Conntrack == nil || *conf.Conntrack {
+		n.collectConntrack(agg, &conf)
+	}
+
+	return nil
+}
+
+// collectStates counts the IPv4 and IPv6 TCP connections by state.
+func (n *Network) collectStates(agg metric.Aggregator, conf *instanceConfig) error {
+	counts := make(map[string]interface{})
+	for _, state := range tcpStates {
+		counts[state] = 0
+	}
+
+	for _, file := range []string{"net/tcp", "net/tcp6"} {
+		f, err := os.Open(filepath.Join(conf.ProcfsPath, file))
+		if err != nil {
+			// IPv6 may be disabled.
+			if file == "net/tcp6" && os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+
+		scanner := bufio.NewScanner(f)
+		// sl local_address rem_address st ...
+		scanner.Scan()
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 4 {
+				continue
+			}
+			if state, ok := tcpStates[fields[3]]; ok {
+				counts[state] = counts[state].(int) + 1
+			}
+		}
+		err = scanner.Err()
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	agg.AddMetrics("gauge", "system.net.tcp.connections", counts, conf.Tags, "")
+	return nil
+}
+
+// parseCounters parses the pairs of header and value lines of
+// /proc/net/snmp and /proc/net/netstat, e.g.
+//
+//	Tcp: RtoAlgorithm RtoMin RtoMax MaxConn ActiveOpens ...
+//	Tcp: 1 200 120000 -1 132 ...
+//
+// into Tcp.RtoAlgorithm, Tcp.RtoMin...
+func parseCounters(path string) (map[string]float64, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]float64)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	for i := 0; i+1 < len(lines); i += 2 {
+		names, data := strings.Fields(lines[i]), strings.Fields(lines[i+1])
+		if len(names) != len(data) || len(names) == 0 || names[0] != data[0] {
+			return nil, fmt.Errorf("unexpected format of %s at line %d", path, i+1)
+		}
+
+		proto := strings.TrimSuffix(names[0], ":")
+		for j := 1; j < len(names); j++ {
+			if value, err := strconv.ParseFloat(data[j], 64); err == nil {
+				values[proto+"."+names[j]] = value
+			}
+		}
+	}
+	return values, nil
+}
+
+// collectSoftnet sums the packets dropped and the times the budget ran out
+// on every CPU, from the second and third columns of /proc/net/softnet_stat.
+func (n *Network) collectSoftnet(agg metric.Aggregator, conf *instanceConfig) error {
+	content, err := ioutil.ReadFile(filepath.Join(conf.ProcfsPath, "net/softnet_stat"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var dropped, squeezed uint64
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		// The columns are hexadecimal.
+		d, _ := strconv.ParseUint(fields[1], 16, 64)
+		s, _ := strconv.ParseUint(fields[2], 16, 64)
+		dropped += d
+		squeezed += s
+	}
+
+	agg.AddMetrics("rate", "system.net.softnet", map[string]interface{}{
+		"dropped":        dropped,
+		"times_squeezed": squeezed,
+	}, conf.Tags, "")
+	return nil
+}
+
+func (n *Network) collectConntrack(agg metric.Aggregator, conf *instanceConfig) {
+	dir := filepath.Join(conf.ProcfsPath, "sys/net/netfilter")
+	count, err := readInt(filepath.Join(dir, "nf_conntrack_count"))
+	if err != nil {
+		// The conntrack module is not loaded.
+		return
+	}
+	max, err := readInt(filepath.Join(dir, "nf_conntrack_max"))
+	if err != nil {
+		return
+	}
+
+	fields := map[string]interface{}{
+		"count": count,
+		"max":   max,
+	}
+	if max > 0 {
+		fields["usage"] = count / max * 100
+	}
+	agg.AddMetrics("gauge", "system.net.conntrack", fields, conf.Tags, "")
+}
+
+func readInt(path string) (float64, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(content)), 64)
+}
+
+func init() {
+	collector.Add("network", NewNetwork)
+}
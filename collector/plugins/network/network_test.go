@@ -0,0 +1,68 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	agg := &metric.MockAggregator{}
+	err := NewNetwork(nil).Check(agg, plugin.Instance{"procfs_path": "testdata/proc"})
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		name       string
+		value      float64
+		metricType string
+	}{
+		{"system.net.tcp.connections.established", 2, "gauge"},
+		{"system.net.tcp.connections.listen", 2, "gauge"},
+		{"system.net.tcp.connections.time_wait", 1, "gauge"},
+		{"system.net.tcp.connections.close_wait", 0, "gauge"},
+		{"system.net.ip.in_header_errors", 1, "rate"},
+		{"system.net.tcp.retrans_segs", 17, "rate"},
+		{"system.net.tcp.out_resets", 5, "rate"},
+		{"system.net.udp.in_errors", 3, "rate"},
+		{"system.net.udp.rcv_buf_errors", 1, "rate"},
+		{"system.net.tcp.listen_overflows", 4, "rate"},
+		{"system.net.tcp.listen_drops", 5, "rate"},
+		{"system.net.tcp.backlog_drops", 6, "rate"},
+		{"system.net.softnet.dropped", 5, "rate"},
+		{"system.net.softnet.times_squeezed", 17, "rate"},
+		{"system.net.conntrack.count", 512, "gauge"},
+		{"system.net.conntrack.max", 2048, "gauge"},
+		{"system.net.conntrack.usage", 25, "gauge"},
+	} {
+		m, ok := agg.Get(c.name)
+		if !assert.True(t, ok, "metric %s not found", c.name) {
+			continue
+		}
+		value, _ := agg.Value(c.name)
+		assert.Equal(t, c.value, value, c.name)
+		assert.Equal(t, c.metricType, m.Type, c.name)
+	}
+}
+
+func TestParseCounters(t *testing.T) {
+	values, err := parseCounters("testdata/proc/net/snmp")
+	require.NoError(t, err)
+	assert.Equal(t, float64(-1), values["Tcp.MaxConn"])
+	assert.Equal(t, float64(3456), values["Ip.InReceives"])
+
+	_, err = parseCounters("testdata/proc/net/tcp")
+	assert.Error(t, err)
+}
+
+func TestNoConntrack(t *testing.T) {
+	agg := &metric.MockAggregator{}
+	err := NewNetwork(nil).Check(agg, plugin.Instance{
+		"procfs_path":               "testdata/proc",
+		"collect_conntrack_metrics": false,
+	})
+	require.NoError(t, err)
+	assert.NotContains(t, agg.Names(), "system.net.conntrack.count")
+}
@@ -0,0 +1,267 @@
+package nfs
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewNFS XXX
+func NewNFS(conf plugin.InitConfig) plugin.Plugin {
+	return &NFS{
+		ops: make(map[string]opStats),
+	}
+}
+
+// NFS collects the per mount statistics of the NFS client, the ones nfsiostat
+// reports, and the statistics of the NFS server.
+type NFS struct {
+	sync.Mutex
+
+	// ops keeps the last per-op statistics of every mount, the RTT and
+	// execution times are averaged over the check interval.
+	ops map[string]opStats
+}
+
+type instanceConfig struct {
+	MountStats string `yaml:"mountstats_path"`
+	NFSd       string `yaml:"nfsd_path"`
+	// Mounts are the mount points to report, all NFS mounts by default.
+	Mounts []string `yaml:"mounts"`
+	Tags   []string `yaml:"tags"`
+}
+
+// opStats are the per-op statistics of /proc/self/mountstats.
+type opStats struct {
+	ops       float64
+	trans     float64
+	timeouts  float64
+	bytesSent float64
+	bytesRecv float64
+	queue     float64
+	rtt       float64
+	execute   float64
+}
+
+// clientOps are the operations of the mounts we report.
+var clientOps = map[string]bool{
+	"read":    true,
+	"write":   true,
+	"getattr": true,
+	"lookup":  true,
+	"access":  true,
+}
+
+// nfs3Procs are the NFSv3 procedures in the order of the proc3 line of
+// /proc/net/rpc/nfsd.
+var nfs3Procs = []string{
+	"null", "getattr", "setattr", "lookup", "access", "readlink", "read",
+	"write", "create", "mkdir", "symlink", "mknod", "remove", "rmdir",
+	"rename", "link", "readdir", "readdirplus", "fsstat", "fsinfo",
+	"pathconf", "commit",
+}
+
+// Check XXX
+func (n *NFS) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.MountStats == "" {
+		conf.MountStats = "/proc/self/mountstats"
+	}
+	if conf.NFSd == "" {
+		conf.NFSd = "/proc/net/rpc/nfsd"
+	}
+
+	if err := n.collectClient(agg, &conf); err != nil {
+		return err
+	}
+
+	return n.collectServer(agg, &conf)
+}
+
+// collectClient parses /proc/self/mountstats, e.g.
+//
+//	device nfs.example.com:/export mounted on /mnt/data with fstype nfs4 statvers=1.1
+//		...
+//		per-op statistics
+//		        READ: 120 121 0 20160 1228800 15 250 300
+func (n *NFS) collectClient(agg metric.Aggregator, conf *instanceConfig) error {
+	f, err := os.Open(conf.MountStats)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	n.Lock()
+	defer n.Unlock()
+
+	var mount string
+	var tags []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "device" {
+			mount, tags = "", nil
+			// device <export> mounted on <mount> with fstype <type> ...
+			if len(fields) >= 8 && strings.HasPrefix(fields[7], "nfs") && conf.match(fields[4]) {
+				mount = fields[4]
+				tags = append(append([]string{}, conf.Tags...), "nfs_mount:"+mount, "nfs_export:"+fields[1])
+			}
+			continue
+		}
+		if mount == "" {
+			continue
+		}
+
+		switch {
+		case fields[0] == "bytes:" && len(fields) >= 7:
+			// normal read, normal write, direct read, direct write, server
+			// read, server write...
+			agg.AddMetrics("rate", "system.nfs", map[string]interface{}{
+				"read_bytes":  parseFloat(fields[5]),
+				"write_bytes": parseFloat(fields[6]),
+			}, tags, "")
+		case fields[0] == "xprt:" && len(fields) >= 2:
+			// xprt: tcp <port> <bind count> <connect count> <connect time>
+			// <idle time> <sends> <recvs> <bad xids> <req_u> <backlog_u>...
+			if fields[1] == "tcp" && len(fields) >= 12 {
+				agg.Add("rate", metric.NewMetric("system.nfs.sends", parseFloat(fields[7]), tags))
+			}
+		case strings.HasSuffix(fields[0], ":") && len(fields) == 9:
+			op := strings.ToLower(strings.TrimSuffix(fields[0], ":"))
+			if !clientOps[op] {
+				continue
+			}
+			n.submitOp(agg, mount, op, parseOp(fields[1:]), tags)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (n *NFS) submitOp(agg metric.Aggregator, mount, op string, s opStats, tags []string) {
+	prefix := "system.nfs." + op
+	agg.AddMetrics("rate", prefix, map[string]interface{}{
+		"ops":      s.ops,
+		"retrans":  s.trans - s.ops,
+		"timeouts": s.timeouts,
+	}, tags, "")
+
+	key := mount + ":" + op
+	prev, ok := n.ops[key]
+	n.ops[key] = s
+	if !ok || s.ops <= prev.ops {
+		return
+	}
+
+	// The times are cumulated milliseconds.
+	ops := s.ops - prev.ops
+	agg.AddMetrics("gauge", prefix, map[string]interface{}{
+		"rtt":       (s.rtt - prev.rtt) / ops,
+		"exe":       (s.execute - prev.execute) / ops,
+		"queue":     (s.queue - prev.queue) / ops,
+		"kb_per_op": (s.bytesSent + s.bytesRecv - prev.bytesSent - prev.bytesRecv) / ops / 1024,
+	}, tags, "")
+}
+
+// collectServer parses /proc/net/rpc/nfsd, which only exists when the NFS
+// server is running.
+func (n *NFS) collectServer(agg metric.Aggregator, conf *instanceConfig) error {
+	f, err := os.Open(conf.NFSd)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		values := fields[1:]
+
+		switch fields[0] {
+		case "rc":
+			// hits misses nocache
+			submit(agg, "rate", "nfsd.reply_cache", []string{"hits", "misses", "nocache"}, values, conf.Tags)
+		case "io":
+			submit(agg, "rate", "nfsd.io", []string{"read_bytes", "write_bytes"}, values, conf.Tags)
+		case "th":
+			submit(agg, "gauge", "nfsd", []string{"threads"}, values, conf.Tags)
+		case "net":
+			submit(agg, "rate", "nfsd.net", []string{"packets", "udp", "tcp", "tcp_connections"}, values, conf.Tags)
+		case "rpc":
+			submit(agg, "rate", "nfsd.rpc", []string{"calls", "badcalls", "badfmt", "badauth", "badclnt"}, values, conf.Tags)
+		case "proc3":
+			// The first value is the number of procedures.
+			submit(agg, "rate", "nfsd.proc3", nfs3Procs, values[1:], conf.Tags)
+		case "proc4ops":
+			var total float64
+			for _, v := range values[1:] {
+				total += parseFloat(v)
+			}
+			agg.Add("rate", metric.NewMetric("nfsd.proc4ops.total", total, conf.Tags))
+		}
+	}
+
+	return scanner.Err()
+}
+
+func submit(agg metric.Aggregator, metricType, prefix string, names, values []string, tags []string) {
+	fields := make(map[string]interface{})
+	for i, name := range names {
+		if i < len(values) {
+			fields[name] = parseFloat(values[i])
+		}
+	}
+	agg.AddMetrics(metricType, prefix, fields, tags, "")
+}
+
+func parseOp(fields []string) opStats {
+	v := make([]float64, 8)
+	for i := range v {
+		v[i] = parseFloat(fields[i])
+	}
+	return opStats{v[0], v[1], v[2], v[3], v[4], v[5], v[6], v[7]}
+}
+
+func parseFloat(s string) float64 {
+	value, _ := strconv.ParseFloat(s, 64)
+	return value
+}
+
+func (conf *instanceConfig) match(mount string) bool {
+	if len(conf.Mounts) == 0 {
+		return true
+	}
+	for _, m := range conf.Mounts {
+		if m == mount {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	collector.Add("nfs", NewNFS)
+}
@@ -0,0 +1,79 @@
+package nfs
+
+import (
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	n := NewNFS(nil).(*NFS)
+	instance := plugin.Instance{
+		"mountstats_path": "testdata/mountstats",
+		"nfsd_path":       "testdata/nfsd",
+		"mounts":          []interface{}{"/mnt/data"},
+	}
+	agg := &metric.MockAggregator{}
+	require.NoError(t, n.Check(agg, instance))
+
+	mount := "nfs_mount:/mnt/data"
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"system.nfs.read_bytes", []string{mount, "nfs_export:nfs.example.com:/export/data"}, 1228800},
+		{"system.nfs.write_bytes", []string{mount}, 409600},
+		{"system.nfs.sends", []string{mount}, 500},
+		{"system.nfs.read.ops", []string{mount}, 120},
+		{"system.nfs.read.retrans", []string{mount}, 2},
+		{"system.nfs.read.timeouts", []string{mount}, 1},
+		{"system.nfs.write.ops", []string{mount}, 50},
+		{"system.nfs.getattr.ops", []string{mount}, 300},
+		{"nfsd.reply_cache.hits", nil, 10},
+		{"nfsd.io.write_bytes", nil, 8192},
+		{"nfsd.threads", nil, 8},
+		{"nfsd.net.tcp_connections", nil, 12},
+		{"nfsd.rpc.badcalls", nil, 1},
+		{"nfsd.proc3.getattr", nil, 100},
+		{"nfsd.proc3.read", nil, 400},
+		{"nfsd.proc3.commit", nil, 9},
+		{"nfsd.proc4ops.total", nil, 60},
+	} {
+		value, ok := agg.Value(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			assert.Equal(t, c.value, value, c.name)
+		}
+	}
+	_, ok := agg.Get("system.nfs.read.ops", "nfs_mount:/home")
+	assert.False(t, ok, "/home is not in mounts")
+	assert.NotContains(t, agg.Names(), "system.nfs.read.rtt")
+
+	n.ops["/mnt/data:read"] = opStats{ops: 20, rtt: 40, execute: 50, bytesRecv: 1024 * 10}
+	agg = &metric.MockAggregator{}
+	require.NoError(t, n.Check(agg, instance))
+	for name, want := range map[string]float64{
+		"system.nfs.read.rtt": 2,
+		"system.nfs.read.exe": 2.5,
+	} {
+		value, ok := agg.Value(name, mount)
+		if assert.True(t, ok, "metric %s not found", name) {
+			assert.Equal(t, want, value, name)
+		}
+	}
+}
+
+func TestNoServer(t *testing.T) {
+	agg := &metric.MockAggregator{}
+	err := NewNFS(nil).Check(agg, plugin.Instance{
+		"mountstats_path": "testdata/mountstats",
+		"nfsd_path":       "testdata/missing",
+	})
+	require.NoError(t, err)
+	assert.NotContains(t, agg.Names(), "nfsd.threads")
+	_, ok := agg.Get("system.nfs.read.ops", "nfs_mount:/home")
+	assert.True(t, ok)
+}
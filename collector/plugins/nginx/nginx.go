@@ -0,0 +1,233 @@
+package nginx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewNginx XXX
+func NewNginx(conf plugin.InitConfig) plugin.Plugin {
+	return &Nginx{}
+}
+
+// Nginx collects the connection and request metrics from the stub_status
+// module, or from the JSON status of nginx plus.
+type Nginx struct{}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	URL string `yaml:"nginx_status_url"`
+	// PlusAPI means URL points to the nginx plus API (e.g. http://localhost/api/6)
+	// rather than to stub_status or the legacy JSON status page.
+	PlusAPI bool     `yaml:"use_plus_api"`
+	Tags    []string `yaml:"tags"`
+}
+
+// The endpoints of nginx plus API we collect, their responses make up the
+// same document as the legacy status page.
+var plusEndpoints = []string{
+	"connections",
+	"http/requests",
+	"http/server_zones",
+	"http/upstreams",
+}
+
+// plusCounters are the monotonic counters of nginx plus, submitted as rates.
+var plusCounters = map[string]bool{
+	"accepted":  true,
+	"dropped":   true,
+	"total":     true,
+	"requests":  true,
+	"discarded": true,
+	"received":  true,
+	"sent":      true,
+	"fails":     true,
+	"unavail":   true,
+	"1xx":       true,
+	"2xx":       true,
+	"3xx":       true,
+	"4xx":       true,
+	"5xx":       true,
+}
+
+// Check XXX
+func (n *Nginx) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.URL == "" {
+		return fmt.Errorf("a configured nginx_status_url is required")
+	}
+
+	if conf.PlusAPI {
+		return n.collectPlusAPI(agg, &conf)
+	}
+
+	body, err := n.fetch(&conf, conf.URL)
+	if err != nil {
+		return err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		var status map[string]interface{}
+		if err = json.Unmarshal(body, &status); err != nil {
+			return fmt.Errorf("could not decode nginx plus status: %s", err)
+		}
+		submitPlus(agg, status, conf.Tags)
+		return nil
+	}
+
+	return parseStubStatus(agg, body, conf.Tags)
+}
+
+func (n *Nginx) fetch(conf *instanceConfig, url string) ([]byte, error) {
+	resp, err := conf.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (n *Nginx) collectPlusAPI(agg metric.Aggregator, conf *instanceConfig) error {
+	base := strings.TrimSuffix(conf.URL, "/")
+	status := make(map[string]interface{})
+	for _, endpoint := range plusEndpoints {
+		var data interface{}
+		if err := conf.GetJSON(base+"/"+endpoint, &data); err != nil {
+			return err
+		}
+		key := endpoint[strings.LastIndex(endpoint, "/")+1:]
+		status[key] = data
+	}
+
+	submitPlus(agg, status, conf.Tags)
+	return nil
+}
+
+// parseStubStatus parses the output of stub_status, which looks like:
+//
+//	Active connections: 291
+//	server accepts handled requests
+//	 16630948 16630948 31070465
+//	Reading: 6 Writing: 179 Waiting: 106
+func parseStubStatus(agg metric.Aggregator, body []byte, tags []string) error {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	var lines []string
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) < 4 {
+		return fmt.Errorf("unexpected stub_status output: %q", body)
+	}
+
+	fields := strings.Fields(lines[0])
+	active, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+	if err != nil {
+		return fmt.Errorf("could not parse active connections: %s", err)
+	}
+
+	counters := strings.Fields(lines[2])
+	if len(counters) != 3 {
+		return fmt.Errorf("unexpected stub_status output: %q", lines[2])
+	}
+	var values [3]float64
+	for i, c := range counters {
+		if values[i], err = strconv.ParseFloat(c, 64); err != nil {
+			return fmt.Errorf("could not parse %q: %s", lines[2], err)
+		}
+	}
+	accepts, handled, requests := values[0], values[1], values[2]
+
+	// Reading: 6 Writing: 179 Waiting: 106
+	states := strings.Fields(lines[3])
+	gauges := map[string]interface{}{
+		"connections": active,
+	}
+	for i := 0; i+1 < len(states); i += 2 {
+		name := strings.ToLower(strings.TrimSuffix(states[i], ":"))
+		if value, err := strconv.ParseFloat(states[i+1], 64); err == nil {
+			gauges[name] = value
+		}
+	}
+	agg.AddMetrics("gauge", "nginx.net", gauges, tags, "")
+
+	agg.AddMetrics("rate", "nginx.net", map[string]interface{}{
+		"conn_opened_per_s":  accepts,
+		"conn_dropped_per_s": accepts - handled,
+		"request_per_s":      requests,
+	}, tags, "")
+
+	return nil
+}
+
+// submitPlus flattens the nginx plus status document into metrics, the server
+// zones and upstream peers are turned into tags.
+func submitPlus(agg metric.Aggregator, status map[string]interface{}, tags []string) {
+	for key, value := range status {
+		switch key {
+		case "server_zones":
+			zones, _ := value.(map[string]interface{})
+			for zone, stats := range zones {
+				zoneTags := append(append([]string{}, tags...), "server_zone:"+zone)
+				flatten(agg, "nginx.plus.server_zone", stats, zoneTags)
+			}
+		case "upstreams":
+			upstreams, _ := value.(map[string]interface{})
+			for name, data := range upstreams {
+				upstreamTags := append(append([]string{}, tags...), "upstream:"+name)
+				upstream, _ := data.(map[string]interface{})
+				for k, v := range upstream {
+					if k != "peers" {
+						flatten(agg, "nginx.plus.upstream."+k, v, upstreamTags)
+						continue
+					}
+					peers, _ := v.([]interface{})
+					for _, p := range peers {
+						peer, _ := p.(map[string]interface{})
+						server, _ := peer["server"].(string)
+						peerTags := append(append([]string{}, upstreamTags...), "server:"+server)
+						flatten(agg, "nginx.plus.upstream.peers", peer, peerTags)
+					}
+				}
+			}
+		default:
+			flatten(agg, "nginx.plus."+key, value, tags)
+		}
+	}
+}
+
+func flatten(agg metric.Aggregator, name string, value interface{}, tags []string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			flatten(agg, name+"."+key, child, tags)
+		}
+	case float64:
+		metricType := "gauge"
+		if plusCounters[name[strings.LastIndex(name, ".")+1:]] {
+			metricType = "rate"
+		}
+		agg.Add(metricType, metric.NewMetric(name, v, tags))
+	}
+}
+
+func init() {
+	collector.Add("nginx", NewNginx)
+}
@@ -0,0 +1,119 @@
+package nginx
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const stubStatus = `Active connections: 291 
+server accepts handled requests
+ 16630948 16630940 31070465 
+Reading: 6 Writing: 179 Waiting: 106 
+`
+
+const plusStatus = `{
+  "connections": {"accepted": 100, "dropped": 1, "active": 10, "idle": 3},
+  "server_zones": {"example.com": {"processing": 2, "requests": 50, "responses": {"5xx": 4}}},
+  "upstreams": {"backend": {"peers": [{"server": "10.0.0.1:80", "active": 1, "fails": 7}]}}
+}`
+
+func TestStubStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("X-Auth"))
+		fmt.Fprint(w, stubStatus)
+	}))
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	err := NewNginx(nil).Check(agg, plugin.Instance{
+		"nginx_status_url": server.URL,
+		"headers":          map[interface{}]interface{}{"X-Auth": "secret"},
+		"tags":             []interface{}{"env:test"},
+	})
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		name  string
+		value float64
+	}{
+		{"nginx.net.connections", 291},
+		{"nginx.net.reading", 6},
+		{"nginx.net.writing", 179},
+		{"nginx.net.waiting", 106},
+		{"nginx.net.conn_opened_per_s", 16630948},
+		{"nginx.net.conn_dropped_per_s", 8},
+		{"nginx.net.request_per_s", 31070465},
+	} {
+		value, ok := agg.Value(c.name, "env:test")
+		if assert.True(t, ok, "metric %s not found", c.name) {
+			assert.Equal(t, c.value, value, c.name)
+		}
+	}
+}
+
+func TestBadStubStatus(t *testing.T) {
+	err := parseStubStatus(&metric.MockAggregator{}, []byte("Active connections: 1\n"), nil)
+	assert.Error(t, err)
+}
+
+func TestPlusStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, plusStatus)
+	}))
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	err := NewNginx(nil).Check(agg, plugin.Instance{"nginx_status_url": server.URL})
+	require.NoError(t, err)
+
+	m, ok := agg.Get("nginx.plus.connections.accepted")
+	require.True(t, ok)
+	assert.Equal(t, "rate", m.Type)
+
+	m, ok = agg.Get("nginx.plus.connections.active")
+	require.True(t, ok)
+	assert.Equal(t, "gauge", m.Type)
+
+	value, ok := agg.Value("nginx.plus.server_zone.responses.5xx", "server_zone:example.com")
+	assert.True(t, ok)
+	assert.Equal(t, float64(4), value)
+
+	value, ok = agg.Value("nginx.plus.upstream.peers.fails", "upstream:backend", "server:10.0.0.1:80")
+	assert.True(t, ok)
+	assert.Equal(t, float64(7), value)
+}
+
+func TestPlusAPI(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/6/connections":
+			fmt.Fprint(w, `{"accepted": 100, "active": 10}`)
+		case "/api/6/http/requests":
+			fmt.Fprint(w, `{"total": 500, "current": 2}`)
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	err := NewNginx(nil).Check(agg, plugin.Instance{
+		"nginx_status_url": server.URL + "/api/6",
+		"use_plus_api":     true,
+	})
+	require.NoError(t, err)
+
+	value, ok := agg.Value("nginx.plus.requests.total")
+	assert.True(t, ok)
+	assert.Equal(t, float64(500), value)
+	value, ok = agg.Value("nginx.plus.connections.active")
+	assert.True(t, ok)
+	assert.Equal(t, float64(10), value)
+}
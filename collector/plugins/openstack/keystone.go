@@ -0,0 +1,111 @@
+package openstack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// token is a Keystone v3 token and the endpoints of its catalog.
+type token struct {
+	id      string
+	expires time.Time
+	// endpoints are the URLs of the services by type, e.g. compute.
+	endpoints map[string]string
+}
+
+type authRequest struct {
+	Auth struct {
+		Identity struct {
+			Methods  []string `json:"methods"`
+			Password struct {
+				User struct {
+					Name     string `json:"name"`
+					Password string `json:"password"`
+					Domain   struct {
+						Name string `json:"name"`
+					} `json:"domain"`
+				} `json:"user"`
+			} `json:"password"`
+		} `json:"identity"`
+		Scope struct {
+			Project struct {
+				Name   string `json:"name"`
+				Domain struct {
+					Name string `json:"name"`
+				} `json:"domain"`
+			} `json:"project"`
+		} `json:"scope"`
+	} `json:"auth"`
+}
+
+type authResponse struct {
+	Token struct {
+		ExpiresAt time.Time `json:"expires_at"`
+		Catalog   []struct {
+			Type      string `json:"type"`
+			Endpoints []struct {
+				Interface string `json:"interface"`
+				Region    string `json:"region"`
+				URL       string `json:"url"`
+			} `json:"endpoints"`
+		} `json:"catalog"`
+	} `json:"token"`
+}
+
+// authenticate gets a project scoped token with the password method.
+func (conf *instanceConfig) authenticate(user, password string) (*token, error) {
+	var body authRequest
+	body.Auth.Identity.Methods = []string{"password"}
+	u := &body.Auth.Identity.Password.User
+	u.Name, u.Password, u.Domain.Name = user, password, conf.UserDomain
+	project := &body.Auth.Scope.Project
+	project.Name, project.Domain.Name = conf.Project, conf.ProjectDomain
+
+	content, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	url := strings.TrimSuffix(conf.KeystoneURL, "/") + "/auth/tokens"
+	req, err := conf.NewRequest("POST", url, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := conf.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("keystone authentication failed: %s", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var auth authResponse
+	if err = json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+		return nil, fmt.Errorf("could not decode the response of %s: %s", url, err)
+	}
+
+	t := &token{
+		id:        resp.Header.Get("X-Subject-Token"),
+		expires:   auth.Token.ExpiresAt,
+		endpoints: make(map[string]string),
+	}
+	for _, service := range auth.Token.Catalog {
+		for _, e := range service.Endpoints {
+			if e.Interface == conf.Interface && (conf.Region == "" || e.Region == conf.Region) {
+				t.endpoints[service.Type] = strings.TrimSuffix(e.URL, "/")
+				break
+			}
+		}
+	}
+	return t, nil
+}
+
+// valid tells whether the token can still be used, it's renewed a bit before
+// it expires.
+func (t *token) valid() bool {
+	return t != nil && time.Now().Add(time.Minute).Before(t.expires)
+}
@@ -0,0 +1,206 @@
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewOpenStack XXX
+func NewOpenStack(conf plugin.InitConfig) plugin.Plugin {
+	return &OpenStack{
+		tokens: make(map[string]*token),
+	}
+}
+
+// OpenStack collects the stats of the nova hypervisors, the health of the
+// neutron agents and the response time of the APIs of an OpenStack cloud.
+type OpenStack struct {
+	sync.Mutex
+
+	// tokens caches the Keystone tokens by keystone URL, user and project.
+	tokens map[string]*token
+}
+
+type instanceConfig struct {
+	// The username and password of HTTPConfig are the credentials of the
+	// Keystone user, they're not sent as basic auth.
+	plugin.HTTPConfig `yaml:",inline"`
+
+	// KeystoneURL is the URL of the Identity API v3, e.g.
+	// http://controller:5000/v3
+	KeystoneURL   string `yaml:"keystone_url"`
+	UserDomain    string `yaml:"user_domain"`
+	Project       string `yaml:"project"`
+	ProjectDomain string `yaml:"project_domain"`
+	// Region and Interface select the endpoints of the catalog.
+	Region    string   `yaml:"region"`
+	Interface string   `yaml:"interface"`
+	Tags      []string `yaml:"tags"`
+}
+
+type hypervisors struct {
+	Hypervisors []struct {
+		Hostname        string  `json:"hypervisor_hostname"`
+		Type            string  `json:"hypervisor_type"`
+		State           string  `json:"state"`
+		Status          string  `json:"status"`
+		VCPUs           float64 `json:"vcpus"`
+		VCPUsUsed       float64 `json:"vcpus_used"`
+		MemoryMB        float64 `json:"memory_mb"`
+		MemoryMBUsed    float64 `json:"memory_mb_used"`
+		LocalGB         float64 `json:"local_gb"`
+		LocalGBUsed     float64 `json:"local_gb_used"`
+		RunningVMs      float64 `json:"running_vms"`
+		CurrentWorkload float64 `json:"current_workload"`
+	} `json:"hypervisors"`
+}
+
+type agents struct {
+	Agents []struct {
+		Type         string `json:"agent_type"`
+		Binary       string `json:"binary"`
+		Host         string `json:"host"`
+		Alive        bool   `json:"alive"`
+		AdminStateUp bool   `json:"admin_state_up"`
+	} `json:"agents"`
+}
+
+// Check XXX
+func (o *OpenStack) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.KeystoneURL == "" || conf.Username == "" || conf.Project == "" {
+		return fmt.Errorf("a configured keystone_url, username and project are required")
+	}
+	if conf.UserDomain == "" {
+		conf.UserDomain = "Default"
+	}
+	if conf.ProjectDomain == "" {
+		conf.ProjectDomain = "Default"
+	}
+	if conf.Interface == "" {
+		conf.Interface = "public"
+	}
+
+	user, password := conf.Username, conf.Password
+	conf.Username, conf.Password = "", ""
+
+	t, err := o.token(&conf, user, password, metric.Logger(agg))
+	if err != nil {
+		return err
+	}
+	tags := append(append([]string{}, conf.Tags...), "project:"+conf.Project)
+
+	var h hypervisors
+	if err = o.get(agg, &conf, t, "compute", "/os-hypervisors/detail", &h, tags); err != nil {
+		return err
+	}
+	for _, hv := range h.Hypervisors {
+		hvTags := append(append([]string{}, tags...),
+			"hypervisor:"+hv.Hostname,
+			"hypervisor_type:"+hv.Type,
+		)
+		up := 0
+		if hv.State == "up" && hv.Status == "enabled" {
+			up = 1
+		}
+		agg.Add("gauge", metric.NewMetric("openstack.nova.hypervisor.up", up, hvTags))
+		agg.Add("gauge", metric.NewMetric("openstack.nova.vcpus", hv.VCPUs, hvTags))
+		agg.Add("gauge", metric.NewMetric("openstack.nova.vcpus_used", hv.VCPUsUsed, hvTags))
+		agg.Add("gauge", metric.NewMetric("openstack.nova.memory", hv.MemoryMB*1024*1024, hvTags))
+		agg.Add("gauge", metric.NewMetric("openstack.nova.memory_used", hv.MemoryMBUsed*1024*1024, hvTags))
+		agg.Add("gauge", metric.NewMetric("openstack.nova.local_disk", hv.LocalGB*1024*1024*1024, hvTags))
+		agg.Add("gauge", metric.NewMetric("openstack.nova.local_disk_used", hv.LocalGBUsed*1024*1024*1024, hvTags))
+		agg.Add("gauge", metric.NewMetric("openstack.nova.running_vms", hv.RunningVMs, hvTags))
+		agg.Add("gauge", metric.NewMetric("openstack.nova.current_workload", hv.CurrentWorkload, hvTags))
+	}
+
+	var a agents
+	if err = o.get(agg, &conf, t, "network", "/v2.0/agents", &a, tags); err != nil {
+		return err
+	}
+	for _, agent := range a.Agents {
+		agentTags := append(append([]string{}, tags...),
+			"agent_type:"+agent.Type,
+			"agent_binary:"+agent.Binary,
+			"agent_host:"+agent.Host,
+		)
+		alive, adminUp := 0, 0
+		if agent.Alive {
+			alive = 1
+		}
+		if agent.AdminStateUp {
+			adminUp = 1
+		}
+		agg.Add("gauge", metric.NewMetric("openstack.neutron.agent.alive", alive, agentTags))
+		agg.Add("gauge", metric.NewMetric("openstack.neutron.agent.admin_state_up", adminUp, agentTags))
+	}
+	return nil
+}
+
+// token returns the cached token, or authenticates again when it's about to
+// expire.
+func (o *OpenStack) token(conf *instanceConfig, user, password string, logger log.Logger) (*token, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	key := conf.KeystoneURL + "/" + conf.UserDomain + "/" + user + "/" + conf.ProjectDomain + "/" + conf.Project
+	if t := o.tokens[key]; t.valid() {
+		return t, nil
+	}
+
+	start := time.Now()
+	t, err := conf.authenticate(user, password)
+	if err != nil {
+		delete(o.tokens, key)
+		return nil, err
+	}
+	logger.Debugf("Got a keystone token for %s in %s", user, time.Since(start))
+	o.tokens[key] = t
+	return t, nil
+}
+
+// get fetches the path of the service and decodes its JSON response into v,
+// the response time of the API is reported.
+func (o *OpenStack) get(agg metric.Aggregator, conf *instanceConfig, t *token, service, path string, v interface{}, tags []string) error {
+	endpoint, ok := t.endpoints[service]
+	if !ok {
+		return fmt.Errorf("no %s %s endpoint found in the catalog", conf.Interface, service)
+	}
+	req, err := conf.NewRequest("GET", endpoint+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Auth-Token", t.id)
+
+	serviceTags := append(append([]string{}, tags...), "service:"+service)
+	start := time.Now()
+	resp, err := conf.Do(req)
+	if err != nil {
+		agg.Add("gauge", metric.NewMetric("openstack.api.up", 0, serviceTags))
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		agg.Add("gauge", metric.NewMetric("openstack.api.up", 0, serviceTags))
+		return fmt.Errorf("could not decode the response of %s: %s", req.URL, err)
+	}
+	agg.Add("gauge", metric.NewMetric("openstack.api.up", 1, serviceTags))
+	agg.Add("gauge", metric.NewMetric("openstack.api.response_time", time.Since(start).Seconds(), serviceTags))
+	return nil
+}
+
+func init() {
+	collector.Add("openstack", NewOpenStack)
+}
@@ -0,0 +1,110 @@
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newServer(t *testing.T, auths *int) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, basic := r.BasicAuth()
+		assert.False(t, basic)
+		if r.URL.Path != "/v3/auth/tokens" && r.Header.Get("X-Auth-Token") != "gAAAAABtoken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v3/auth/tokens":
+			*auths++
+			var req authRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal(t, "admin", req.Auth.Identity.Password.User.Name)
+			assert.Equal(t, "secret", req.Auth.Identity.Password.User.Password)
+			assert.Equal(t, "Default", req.Auth.Identity.Password.User.Domain.Name)
+			assert.Equal(t, "admin", req.Auth.Scope.Project.Name)
+
+			w.Header().Set("X-Subject-Token", "gAAAAABtoken")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"token": {"expires_at": "%s", "catalog": [
+				{"type": "compute", "endpoints": [
+					{"interface": "internal", "region": "RegionOne", "url": "http://internal/compute"},
+					{"interface": "public", "region": "RegionOne", "url": "%s/compute/v2.1/"}]},
+				{"type": "network", "endpoints": [
+					{"interface": "public", "region": "RegionOne", "url": "%s/network"}]}
+			]}}`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339), server.URL, server.URL)
+		case "/compute/v2.1/os-hypervisors/detail":
+			fmt.Fprintln(w, `{"hypervisors": [
+				{"hypervisor_hostname": "compute01", "hypervisor_type": "QEMU", "state": "up", "status": "enabled",
+				 "vcpus": 32, "vcpus_used": 20, "memory_mb": 131072, "memory_mb_used": 65536,
+				 "local_gb": 1000, "local_gb_used": 250, "running_vms": 12, "current_workload": 1},
+				{"hypervisor_hostname": "compute02", "hypervisor_type": "QEMU", "state": "down", "status": "enabled"}]}`)
+		case "/network/v2.0/agents":
+			fmt.Fprintln(w, `{"agents": [
+				{"agent_type": "L3 agent", "binary": "neutron-l3-agent", "host": "network01", "alive": true, "admin_state_up": true},
+				{"agent_type": "DHCP agent", "binary": "neutron-dhcp-agent", "host": "network01", "alive": false, "admin_state_up": true}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return server
+}
+
+func TestCheck(t *testing.T) {
+	auths := 0
+	server := newServer(t, &auths)
+	defer server.Close()
+
+	o := NewOpenStack(nil)
+	instance := plugin.Instance{
+		"keystone_url": server.URL + "/v3",
+		"username":     "admin",
+		"password":     "secret",
+		"project":      "admin",
+	}
+	agg := &metric.MockAggregator{}
+	require.NoError(t, o.Check(agg, instance))
+
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"openstack.nova.hypervisor.up", []string{"hypervisor:compute01"}, 1},
+		{"openstack.nova.vcpus_used", []string{"hypervisor:compute01"}, 20},
+		{"openstack.nova.memory_used", []string{"hypervisor:compute01"}, 68719476736},
+		{"openstack.nova.running_vms", []string{"hypervisor:compute01"}, 12},
+		{"openstack.nova.hypervisor.up", []string{"hypervisor:compute02"}, 0},
+		{"openstack.neutron.agent.alive", []string{"agent_binary:neutron-l3-agent", "agent_host:network01"}, 1},
+		{"openstack.neutron.agent.alive", []string{"agent_type:DHCP agent"}, 0},
+		{"openstack.api.up", []string{"service:compute"}, 1},
+		{"openstack.api.up", []string{"service:network"}, 1},
+	} {
+		value, ok := agg.Value(c.name, append(c.tags, "project:admin")...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			assert.Equal(t, c.value, value, c.name)
+		}
+	}
+	_, ok := agg.Get("openstack.api.response_time", "service:compute")
+	assert.True(t, ok)
+
+	// The token is reused.
+	require.NoError(t, o.Check(&metric.MockAggregator{}, instance))
+	assert.Equal(t, 1, auths)
+}
+
+func TestCheckInvalidConfig(t *testing.T) {
+	assert.Error(t, NewOpenStack(nil).Check(&metric.MockAggregator{}, plugin.Instance{
+		"keystone_url": "http://controller:5000/v3",
+	}))
+}
@@ -0,0 +1,159 @@
+package oracle
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// runCommand is replaced in tests.
+var runCommand = util.RunCommandWithInput
+
+// NewOracle XXX
+func NewOracle(conf plugin.InitConfig) plugin.Plugin {
+	return &Oracle{}
+}
+
+// Oracle collects the system metrics, the sessions and the tablespace usage
+// of an Oracle Database with sqlplus.
+type Oracle struct{}
+
+type instanceConfig struct {
+	Sqlplus string `yaml:"sqlplus"`
+	// Server is the connect identifier, e.g. localhost:1521/ORCLPDB1 or a
+	// tnsnames.ora alias.
+	Server   string   `yaml:"server"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	Timeout  int      `yaml:"timeout"`
+	Tags     []string `yaml:"tags"`
+}
+
+// sysmetrics maps the names of v$sysmetric to the metrics.
+var sysmetrics = map[string]string{
+	"Buffer Cache Hit Ratio":   "oracle.buffer_cachehit_ratio",
+	"Library Cache Hit Ratio":  "oracle.library_cachehit_ratio",
+	"Average Active Sessions":  "oracle.active_sessions",
+	"Session Count":            "oracle.session_count",
+	"Session Limit %":          "oracle.session_limit_usage",
+	"User Transaction Per Sec": "oracle.user_transactions",
+	"Executions Per Sec":       "oracle.executions",
+	"Logons Per Sec":           "oracle.logons",
+	"Physical Reads Per Sec":   "oracle.physical_reads",
+	"Physical Writes Per Sec":  "oracle.physical_writes",
+	"Database CPU Time Ratio":  "oracle.cpu_time_ratio",
+	"Database Wait Time Ratio": "oracle.wait_time_ratio",
+	"Shared Pool Free %":       "oracle.shared_pool_free",
+	"Host CPU Utilization (%)": "oracle.host_cpu_utilization",
+	"Rows Per Sort":            "oracle.rows_per_sort",
+	"Disk Sort Per Sec":        "oracle.disk_sorts",
+	"Memory Sorts Ratio":       "oracle.memory_sorts_ratio",
+	"Long Table Scans Per Sec": "oracle.long_table_scans",
+	"Enqueue Timeouts Per Sec": "oracle.enqueue_timeouts",
+	"Temp Space Used":          "oracle.temp_space_used",
+	"Total PGA Allocated":      "oracle.pga_allocated",
+}
+
+// The rows of the queries are prefixed by their kind and separated by |, the
+// sysmetric rows are from the 60 seconds interval group.
+const script = `SET HEADING OFF FEEDBACK OFF PAGESIZE 0 LINESIZE 32767 TRIMSPOOL ON
+WHENEVER SQLERROR EXIT FAILURE
+SELECT 'sysmetric|' || metric_name || '|' || TO_CHAR(value, 'TM9', 'NLS_NUMERIC_CHARACTERS=''.,''')
+FROM v$sysmetric WHERE group_id = 2;
+SELECT 'session|' || LOWER(status) || '|' || COUNT(*)
+FROM v$session WHERE type = 'USER' GROUP BY status;
+SELECT 'tablespace|' || m.tablespace_name || '|' || m.used_space * t.block_size || '|' ||
+m.tablespace_size * t.block_size || '|' || TO_CHAR(m.used_percent, 'TM9', 'NLS_NUMERIC_CHARACTERS=''.,''')
+FROM dba_tablespace_usage_metrics m JOIN dba_tablespaces t ON m.tablespace_name = t.tablespace_name;
+EXIT
+`
+
+// Check XXX
+func (o *Oracle) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.Server == "" || conf.Username == "" {
+		return fmt.Errorf("a configured server and username are required")
+	}
+	if conf.Sqlplus == "" {
+		conf.Sqlplus = "sqlplus"
+	}
+
+	// The credentials are sent on the standard input rather than as
+	// arguments, which are visible to all users.
+	input := fmt.Sprintf("CONNECT %s/\"%s\"@%s\n%s", conf.Username, conf.Password, conf.Server, script)
+	out, err := runCommand(time.Duration(conf.Timeout)*time.Second, []byte(input),
+		conf.Sqlplus, "-S", "-L", "/nolog")
+	if err != nil {
+		return err
+	}
+
+	tags := append(append([]string{}, conf.Tags...), "oracle_server:"+conf.Server)
+	return parse(agg, out, tags)
+}
+
+func parse(agg metric.Aggregator, out []byte, tags []string) error {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		// The rows are kind|name|values...
+		fields := strings.Split(line, "|")
+		if len(fields) < 3 {
+			// e.g. ORA-01017: invalid username/password; logon denied
+			return fmt.Errorf("unexpected sqlplus output: %q", line)
+		}
+		values, err := parseFloats(fields[2:])
+		if err != nil {
+			// Some metrics have no value yet after the startup.
+			metric.Logger(agg).Debugf("Invalid value in sqlplus output %q: %s", line, err)
+			continue
+		}
+
+		switch {
+		case fields[0] == "sysmetric" && len(values) == 1:
+			if name, ok := sysmetrics[fields[1]]; ok {
+				agg.Add("gauge", metric.NewMetric(name, values[0], tags))
+			}
+		case fields[0] == "session" && len(values) == 1:
+			sessionTags := append(append([]string{}, tags...), "status:"+fields[1])
+			agg.Add("gauge", metric.NewMetric("oracle.sessions", values[0], sessionTags))
+		case fields[0] == "tablespace" && len(values) == 3:
+			tsTags := append(append([]string{}, tags...), "tablespace:"+fields[1])
+			agg.Add("gauge", metric.NewMetric("oracle.tablespace.used", values[0], tsTags))
+			agg.Add("gauge", metric.NewMetric("oracle.tablespace.size", values[1], tsTags))
+			agg.Add("gauge", metric.NewMetric("oracle.tablespace.in_use", values[2], tsTags))
+		default:
+			return fmt.Errorf("unexpected sqlplus output: %q", line)
+		}
+	}
+	return scanner.Err()
+}
+
+func parseFloats(fields []string) ([]float64, error) {
+	values := make([]float64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func init() {
+	collector.Add("oracle", NewOracle)
+}
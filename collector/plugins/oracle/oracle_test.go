@@ -0,0 +1,67 @@
+package oracle
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	output, err := ioutil.ReadFile("testdata/sqlplus")
+	require.NoError(t, err)
+
+	var command, input string
+	runCommand = func(timeout time.Duration, in []byte, name string, args ...string) ([]byte, error) {
+		command = name + " " + strings.Join(args, " ")
+		input = string(in)
+		return output, nil
+	}
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewOracle(nil).Check(agg, plugin.Instance{
+		"server":   "localhost:1521/ORCLPDB1",
+		"username": "cloudinsight",
+		"password": "secret",
+	}))
+	assert.Equal(t, "sqlplus -S -L /nolog", command)
+	assert.True(t, strings.HasPrefix(input, "CONNECT cloudinsight/\"secret\"@localhost:1521/ORCLPDB1\n"), input)
+
+	server := "oracle_server:localhost:1521/ORCLPDB1"
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"oracle.buffer_cachehit_ratio", nil, 99.87},
+		{"oracle.active_sessions", nil, 1.42},
+		{"oracle.session_count", nil, 57},
+		{"oracle.user_transactions", nil, 12.5},
+		{"oracle.sessions", []string{"status:active"}, 6},
+		{"oracle.sessions", []string{"status:inactive"}, 38},
+		{"oracle.tablespace.used", []string{"tablespace:SYSTEM"}, 912261120},
+		{"oracle.tablespace.size", []string{"tablespace:SYSTEM"}, 34359721984},
+		{"oracle.tablespace.in_use", []string{"tablespace:USERS"}, 0.02},
+	} {
+		value, ok := agg.Value(c.name, append(c.tags, server)...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			assert.InDelta(t, c.value, value, 1e-6, c.name)
+		}
+	}
+	assert.NotContains(t, agg.Names(), "oracle.pga_allocated")
+}
+
+func TestCheckInvalid(t *testing.T) {
+	runCommand = func(timeout time.Duration, in []byte, name string, args ...string) ([]byte, error) {
+		return []byte("ERROR:\nORA-01017: invalid username/password; logon denied\n"), nil
+	}
+
+	o := NewOracle(nil)
+	assert.Error(t, o.Check(&metric.MockAggregator{}, plugin.Instance{"server": "db"}))
+	assert.Error(t, o.Check(&metric.MockAggregator{}, plugin.Instance{"server": "db", "username": "u"}))
+}
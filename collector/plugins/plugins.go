@@ -2,5 +2,52 @@ package plugins
 
 import (
 	// registry all plugins
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/aerospike"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/apache"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/bind"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/cassandra_nodetool"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/ceph"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/collectd"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/consul"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/couchbase"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/couchdb"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/directory"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/elasticsearch"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/etcd"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/fluentd"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/gluster"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/gpu"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/gunicorn"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/http_check"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/iis"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/influxdb_listener"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/jenkins"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/jmx"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/kubernetes"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/libvirt"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/logstash"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/memcached"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/mssql"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/network"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/nfs"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/nginx"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/openstack"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/oracle"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/postfix"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/powerdns"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/process"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/prometheus"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/rabbitmq"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/riak"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/sensors"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/smart"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/solr"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/squid"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/supervisord"
 	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/system"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/tcp_check"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/uwsgi"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/varnish"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/vsphere"
+	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins/windows_service"
 )
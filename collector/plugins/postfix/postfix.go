@@ -0,0 +1,146 @@
+package postfix
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// DefaultQueues are the queues reported by default.
+var DefaultQueues = []string{"incoming", "active", "deferred", "hold"}
+
+// runCommand is replaced in tests.
+var runCommand = util.RunCommand
+
+// NewPostfix XXX
+func NewPostfix(conf plugin.InitConfig) plugin.Plugin {
+	return &Postfix{}
+}
+
+// Postfix reports the number of messages in the queues of Postfix.
+type Postfix struct{}
+
+type instanceConfig struct {
+	// QueueDirectory is scanned unless UsePostqueue is set, the agent user
+	// must be allowed to read it.
+	QueueDirectory string `yaml:"queue_directory"`
+	// UsePostqueue lists the queues with postqueue -j (Postfix 3.1+),
+	// which doesn't require any privilege.
+	UsePostqueue    bool     `yaml:"use_postqueue"`
+	Postqueue       string   `yaml:"postqueue"`
+	ConfigDirectory string   `yaml:"config_directory"`
+	Queues          []string `yaml:"queues"`
+	Timeout         int      `yaml:"timeout"`
+	Tags            []string `yaml:"tags"`
+}
+
+type queuedMessage struct {
+	QueueName   string  `json:"queue_name"`
+	MessageSize float64 `json:"message_size"`
+}
+
+// Check XXX
+func (p *Postfix) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.QueueDirectory == "" {
+		conf.QueueDirectory = "/var/spool/postfix"
+	}
+	if conf.Postqueue == "" {
+		conf.Postqueue = "postqueue"
+	}
+	if len(conf.Queues) == 0 {
+		conf.Queues = DefaultQueues
+	}
+
+	var counts, sizes map[string]float64
+	var err error
+	if conf.UsePostqueue {
+		counts, sizes, err = readPostqueue(&conf)
+	} else {
+		counts, sizes, err = scanQueues(&conf)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, queue := range conf.Queues {
+		tags := append(append([]string{}, conf.Tags...), "queue:"+queue)
+		agg.Add("gauge", metric.NewMetric("postfix.queue.size", counts[queue], tags))
+		agg.Add("gauge", metric.NewMetric("postfix.queue.bytes", sizes[queue], tags))
+	}
+	return nil
+}
+
+// scanQueues counts the files of the queue directories, the messages are
+// hashed into subdirectories.
+func scanQueues(conf *instanceConfig) (map[string]float64, map[string]float64, error) {
+	counts := make(map[string]float64)
+	sizes := make(map[string]float64)
+	for _, queue := range conf.Queues {
+		dir := filepath.Join(conf.QueueDirectory, queue)
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				// Messages move between the queues while we walk them.
+				if os.IsNotExist(err) && path != dir {
+					return nil
+				}
+				return err
+			}
+			if !info.IsDir() {
+				counts[queue]++
+				sizes[queue] += float64(info.Size())
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not scan the %s queue: %s", queue, err)
+		}
+	}
+	return counts, sizes, nil
+}
+
+// readPostqueue parses the output of postqueue -j, one JSON object per
+// queued message.
+func readPostqueue(conf *instanceConfig) (map[string]float64, map[string]float64, error) {
+	args := []string{"-j"}
+	if conf.ConfigDirectory != "" {
+		args = append(args, "-c", conf.ConfigDirectory)
+	}
+	out, err := runCommand(time.Duration(conf.Timeout)*time.Second, conf.Postqueue, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	counts := make(map[string]float64)
+	sizes := make(map[string]float64)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var msg queuedMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return nil, nil, fmt.Errorf("could not decode the output of postqueue: %s", err)
+		}
+		counts[msg.QueueName]++
+		sizes[msg.QueueName] += msg.MessageSize
+	}
+	return counts, sizes, scanner.Err()
+}
+
+func init() {
+	collector.Add("postfix", NewPostfix)
+}
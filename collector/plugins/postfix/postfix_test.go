@@ -0,0 +1,75 @@
+package postfix
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanQueues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "postfix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, f := range []string{"incoming/A1B2C3", "active/D4E5F6", "deferred/1/1A2B3C", "deferred/7/7D8E9F", "hold/.keep"} {
+		path := filepath.Join(dir, f)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte("message"), 0600))
+	}
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewPostfix(nil).Check(agg, plugin.Instance{"queue_directory": dir}))
+	for queue, want := range map[string]float64{"incoming": 1, "active": 1, "deferred": 2, "hold": 1} {
+		value, ok := agg.Value("postfix.queue.size", "queue:"+queue)
+		assert.True(t, ok, queue)
+		assert.Equal(t, want, value, queue)
+	}
+	value, _ := agg.Value("postfix.queue.bytes", "queue:deferred")
+	assert.Equal(t, float64(14), value)
+
+	err = NewPostfix(nil).Check(agg, plugin.Instance{"queue_directory": dir, "queues": []interface{}{"missing"}})
+	assert.Error(t, err)
+}
+
+func TestPostqueue(t *testing.T) {
+	var command string
+	runCommand = func(timeout time.Duration, name string, args ...string) ([]byte, error) {
+		command = name + " " + strings.Join(args, " ")
+		return []byte(`{"queue_name": "deferred", "queue_id": "1A2B3C", "message_size": 1024}
+{"queue_name": "deferred", "queue_id": "7D8E9F", "message_size": 2048}
+{"queue_name": "active", "queue_id": "D4E5F6", "message_size": 512}
+`), nil
+	}
+
+	agg := &metric.MockAggregator{}
+	err := NewPostfix(nil).Check(agg, plugin.Instance{
+		"use_postqueue":    true,
+		"config_directory": "/etc/postfix-out",
+		"tags":             []interface{}{"instance:out"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "postqueue -j -c /etc/postfix-out", command)
+
+	for _, c := range []struct {
+		name  string
+		queue string
+		value float64
+	}{
+		{"postfix.queue.size", "deferred", 2},
+		{"postfix.queue.bytes", "deferred", 3072},
+		{"postfix.queue.size", "active", 1},
+		{"postfix.queue.size", "hold", 0},
+	} {
+		value, ok := agg.Value(c.name, "queue:"+c.queue, "instance:out")
+		assert.True(t, ok)
+		assert.Equal(t, c.value, value, c.name+" "+c.queue)
+	}
+}
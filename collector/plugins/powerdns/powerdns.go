@@ -0,0 +1,158 @@
+package powerdns
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewPowerDNS XXX
+func NewPowerDNS(conf plugin.InitConfig) plugin.Plugin {
+	return &PowerDNS{
+		cache: make(map[string]float64),
+	}
+}
+
+// PowerDNS collects the statistics of a PowerDNS Authoritative Server or
+// Recursor from its built-in webserver API.
+type PowerDNS struct {
+	sync.Mutex
+
+	// cache keeps the last value of the cache hits and misses counters,
+	// keyed by url and counter name.
+	cache map[string]float64
+}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	URL      string   `yaml:"url"`
+	APIKey   string   `yaml:"api_key"`
+	ServerID string   `yaml:"server_id"`
+	Tags     []string `yaml:"tags"`
+}
+
+type statistic struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// gauges are the statistics which are not monotonic counters, everything
+// else is submitted as a rate.
+var gauges = map[string]bool{
+	"cache-entries":        true,
+	"concurrent-queries":   true,
+	"fd-usage":             true,
+	"key-cache-size":       true,
+	"latency":              true,
+	"meta-cache-size":      true,
+	"negcache-entries":     true,
+	"packetcache-entries":  true,
+	"packetcache-size":     true,
+	"qa-latency":           true,
+	"qsize-q":              true,
+	"query-cache-size":     true,
+	"real-memory-usage":    true,
+	"record-cache-size":    true,
+	"security-status":      true,
+	"signature-cache-size": true,
+	"throttle-entries":     true,
+	"uptime":               true,
+}
+
+// hitRatios are the caches whose hit ratio is computed over the check
+// interval from their hits and misses counters, the Authoritative Server and
+// the Recursor name the packet cache counters differently.
+var hitRatios = []struct {
+	name, hits, misses string
+}{
+	{"powerdns.cache.hit_ratio", "cache-hits", "cache-misses"},
+	{"powerdns.packetcache.hit_ratio", "packetcache-hit", "packetcache-miss"},
+	{"powerdns.packetcache.hit_ratio", "packetcache-hits", "packetcache-misses"},
+	{"powerdns.query_cache.hit_ratio", "query-cache-hit", "query-cache-miss"},
+}
+
+// Check XXX
+func (p *PowerDNS) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.URL == "" {
+		conf.URL = "http://localhost:8081"
+	}
+	if conf.ServerID == "" {
+		conf.ServerID = "localhost"
+	}
+	if conf.APIKey == "" {
+		return fmt.Errorf("a configured api_key is required")
+	}
+	if conf.Headers == nil {
+		conf.Headers = make(map[string]string)
+	}
+	conf.Headers["X-API-Key"] = conf.APIKey
+
+	url := fmt.Sprintf("%s/api/v1/servers/%s/statistics", strings.TrimSuffix(conf.URL, "/"), conf.ServerID)
+	var stats []statistic
+	if err := conf.GetJSON(url, &stats); err != nil {
+		return err
+	}
+
+	counters := make(map[string]float64)
+	for _, stat := range stats {
+		// Ring and map statistics (e.g. the top queried domains) are skipped.
+		if stat.Type != "" && stat.Type != "StatisticItem" {
+			continue
+		}
+		s, ok := stat.Value.(string)
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			continue
+		}
+		counters[stat.Name] = value
+
+		metricType := "rate"
+		if gauges[stat.Name] {
+			metricType = "gauge"
+		}
+		name := "powerdns." + strings.Replace(stat.Name, "-", "_", -1)
+		agg.Add(metricType, metric.NewMetric(name, value, conf.Tags))
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	for _, ratio := range hitRatios {
+		hits, ok := counters[ratio.hits]
+		if !ok {
+			continue
+		}
+		misses := counters[ratio.misses]
+		prevHits, ok := p.cache[url+ratio.hits]
+		prevMisses := p.cache[url+ratio.misses]
+		p.cache[url+ratio.hits], p.cache[url+ratio.misses] = hits, misses
+		if !ok {
+			continue
+		}
+
+		dh, dm := hits-prevHits, misses-prevMisses
+		if dh < 0 || dm < 0 || dh+dm == 0 {
+			continue
+		}
+		agg.Add("gauge", metric.NewMetric(ratio.name, dh/(dh+dm)*100, conf.Tags))
+	}
+
+	return nil
+}
+
+func init() {
+	collector.Add("powerdns", NewPowerDNS)
+}
@@ -0,0 +1,74 @@
+package powerdns
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const statisticsResponse = `[
+  {"name": "cache-hits", "type": "StatisticItem", "value": "%d"},
+  {"name": "cache-misses", "type": "StatisticItem", "value": "100"},
+  {"name": "servfail-answers", "type": "StatisticItem", "value": "7"},
+  {"name": "concurrent-queries", "type": "StatisticItem", "value": "3"},
+  {"name": "response-by-qtype", "type": "MapStatisticItem", "value": [{"name": "A", "value": "12"}]}
+]`
+
+func TestCheck(t *testing.T) {
+	hits := 300
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/servers/localhost/statistics" || r.Header.Get("X-API-Key") != "secret" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, statisticsResponse, hits)
+	}))
+	defer server.Close()
+
+	p := NewPowerDNS(nil)
+	instance := plugin.Instance{
+		"url":     server.URL,
+		"api_key": "secret",
+		"tags":    []interface{}{"role:recursor"},
+	}
+	agg := &metric.MockAggregator{}
+	require.NoError(t, p.Check(agg, instance))
+
+	for _, c := range []struct {
+		name       string
+		metricType string
+		value      float64
+	}{
+		{"powerdns.cache_hits", "rate", 300},
+		{"powerdns.servfail_answers", "rate", 7},
+		{"powerdns.concurrent_queries", "gauge", 3},
+	} {
+		m, ok := agg.Get(c.name, "role:recursor")
+		if assert.True(t, ok, "metric %s not found", c.name) {
+			value, _ := agg.Value(c.name)
+			assert.Equal(t, c.value, value, c.name)
+			assert.Equal(t, c.metricType, m.Type, c.name)
+		}
+	}
+	assert.NotContains(t, agg.Names(), "powerdns.response_by_qtype")
+	assert.NotContains(t, agg.Names(), "powerdns.cache.hit_ratio")
+
+	// 100 hits and no miss since the last check.
+	hits = 400
+	agg = &metric.MockAggregator{}
+	require.NoError(t, p.Check(agg, instance))
+	value, ok := agg.Value("powerdns.cache.hit_ratio")
+	assert.True(t, ok)
+	assert.Equal(t, float64(100), value)
+}
+
+func TestCheckWithoutAPIKey(t *testing.T) {
+	p := NewPowerDNS(nil)
+	assert.Error(t, p.Check(&metric.MockAggregator{}, plugin.Instance{}))
+}
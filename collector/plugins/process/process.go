@@ -0,0 +1,266 @@
+package process
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewProcess XXX
+func NewProcess(conf plugin.InitConfig) plugin.Plugin {
+	return &Process{
+		ps:      &systemPS{},
+		samples: make(map[string]map[int32]cpuSample),
+	}
+}
+
+// Process finds processes by name, command line or pidfile and reports their
+// number and resource usage.
+type Process struct {
+	sync.Mutex
+
+	ps PS
+	// samples keeps the last CPU time of every process of every instance,
+	// the CPU usage is computed over the check interval.
+	samples map[string]map[int32]cpuSample
+}
+
+type cpuSample struct {
+	cpu  float64
+	time time.Time
+}
+
+type instanceConfig struct {
+	Name string `yaml:"name"`
+	// SearchString matches the process names, or is searched in the
+	// command lines when ExactMatch is false.
+	SearchString []string `yaml:"search_string"`
+	ExactMatch   *bool    `yaml:"exact_match"`
+	// Pattern is a regular expression matched against the command lines.
+	Pattern string `yaml:"pattern"`
+	PidFile string `yaml:"pid_file"`
+	// Thresholds are the [min, max] bounds of the process count.
+	Thresholds struct {
+		Warning  []int `yaml:"warning"`
+		Critical []int `yaml:"critical"`
+	} `yaml:"thresholds"`
+	Tags []string `yaml:"tags"`
+}
+
+// Check XXX
+func (p *Process) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	conf, err := parseConfig(instance)
+	if err != nil {
+		return err
+	}
+
+	pids, err := p.findPids(conf, metric.Logger(agg))
+	if err != nil {
+		return err
+	}
+
+	tags := append(append([]string{}, conf.Tags...), "process_name:"+conf.Name)
+	agg.Add("gauge", metric.NewMetric("system.processes.number", len(pids), tags))
+
+	status := conf.status(len(pids))
+	message := conf.message(len(pids))
+	if status != metric.ServiceCheckOK {
+		metric.Logger(agg).Warnf("Process %s: %s", conf.Name, message)
+	}
+	agg.Add("gauge", metric.NewMetric("system.processes.status", status, tags))
+	sc := metric.NewServiceCheck("process.up", status, tags)
+	sc.Message = message
+	agg.AddServiceCheck(sc)
+
+	p.submitStats(agg, conf.Name, pids, tags)
+	return nil
+}
+
+func parseConfig(instance plugin.Instance) (*instanceConfig, error) {
+	conf := &instanceConfig{}
+	if err := instance.Unmarshal(conf); err != nil {
+		return nil, err
+	}
+
+	if conf.Name == "" {
+		return nil, fmt.Errorf("a configured name is required")
+	}
+	if len(conf.SearchString) == 0 && conf.Pattern == "" && conf.PidFile == "" {
+		return nil, fmt.Errorf("one of search_string, pattern or pid_file is required")
+	}
+	for _, t := range [][]int{conf.Thresholds.Warning, conf.Thresholds.Critical} {
+		if len(t) != 0 && len(t) != 2 {
+			return nil, fmt.Errorf("the thresholds must be [min, max] pairs")
+		}
+	}
+
+	return conf, nil
+}
+
+// status rates the process count against the thresholds as a service check
+// status.
+func (conf *instanceConfig) status(count int) int {
+	outside := func(bounds []int) bool {
+		return len(bounds) == 2 && (count < bounds[0] || count > bounds[1])
+	}
+
+	switch {
+	case outside(conf.Thresholds.Critical):
+		return metric.ServiceCheckCritical
+	case outside(conf.Thresholds.Warning):
+		return metric.ServiceCheckWarning
+	case len(conf.Thresholds.Critical) == 0 && len(conf.Thresholds.Warning) == 0 && count == 0:
+		// Without thresholds at least one process is expected.
+		return metric.ServiceCheckCritical
+	}
+	return metric.ServiceCheckOK
+}
+
+// message describes the process count and the thresholds it's rated
+// against.
+func (conf *instanceConfig) message(count int) string {
+	message := fmt.Sprintf("%d processes found", count)
+	if count == 1 {
+		message = "1 process found"
+	}
+
+	if len(conf.Thresholds.Critical) == 0 && len(conf.Thresholds.Warning) == 0 {
+		return message + ", at least 1 expected"
+	}
+	if t := conf.Thresholds.Warning; len(t) == 2 {
+		message += fmt.Sprintf(", warning threshold [%d, %d]", t[0], t[1])
+	}
+	if t := conf.Thresholds.Critical; len(t) == 2 {
+		message += fmt.Sprintf(", critical threshold [%d, %d]", t[0], t[1])
+	}
+	return message
+}
+
+func (p *Process) findPids(conf *instanceConfig, logger log.Logger) ([]int32, error) {
+	if conf.PidFile != "" {
+		content, err := ioutil.ReadFile(conf.PidFile)
+		if err != nil {
+			logger.Debugf("Could not read pid_file %s: %s", conf.PidFile, err)
+			return nil, nil
+		}
+		pid, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid_file %s: %s", conf.PidFile, err)
+		}
+		// The pidfile may outlive the process.
+		if _, err = p.ps.Name(int32(pid)); err != nil {
+			return nil, nil
+		}
+		return []int32{int32(pid)}, nil
+	}
+
+	var pattern *regexp.Regexp
+	if conf.Pattern != "" {
+		var err error
+		if pattern, err = regexp.Compile(conf.Pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", conf.Pattern, err)
+		}
+	}
+	exact := conf.ExactMatch == nil || *conf.ExactMatch
+
+	all, err := p.ps.Pids()
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int32
+	for _, pid := range all {
+		if pattern == nil && exact {
+			// Reading the name is cheaper than the command line.
+			name, err := p.ps.Name(pid)
+			if err == nil && contains(conf.SearchString, name) {
+				pids = append(pids, pid)
+			}
+			continue
+		}
+
+		// Processes vanish while we're listing them.
+		cmdline, err := p.ps.Cmdline(pid)
+		if err != nil || cmdline == "" {
+			continue
+		}
+		if pattern != nil && pattern.MatchString(cmdline) {
+			pids = append(pids, pid)
+			continue
+		}
+		for _, s := range conf.SearchString {
+			if strings.Contains(cmdline, s) {
+				pids = append(pids, pid)
+				break
+			}
+		}
+	}
+	return pids, nil
+}
+
+func (p *Process) submitStats(agg metric.Aggregator, name string, pids []int32, tags []string) {
+	p.Lock()
+	defer p.Unlock()
+
+	prev := p.samples[name]
+	samples := make(map[int32]cpuSample)
+	now := time.Now()
+
+	var rss, vms, fds, threads, cpuPct float64
+	cpuKnown := false
+	for _, pid := range pids {
+		s, err := p.ps.Stats(pid)
+		if err != nil {
+			continue
+		}
+		rss += float64(s.rss)
+		vms += float64(s.vms)
+		fds += float64(s.fds)
+		threads += float64(s.threads)
+
+		samples[pid] = cpuSample{s.cpu, now}
+		if last, ok := prev[pid]; ok {
+			if elapsed := now.Sub(last.time).Seconds(); elapsed > 0 && s.cpu >= last.cpu {
+				cpuPct += (s.cpu - last.cpu) / elapsed * 100
+				cpuKnown = true
+			}
+		}
+	}
+	p.samples[name] = samples
+
+	if len(samples) == 0 {
+		return
+	}
+	fields := map[string]interface{}{
+		"mem.rss":               rss,
+		"mem.vms":               vms,
+		"open_file_descriptors": fds,
+		"threads":               threads,
+	}
+	if cpuKnown {
+		fields["cpu.pct"] = cpuPct
+	}
+	agg.AddMetrics("gauge", "system.processes", fields, tags, "")
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	collector.Add("process", NewProcess)
+}
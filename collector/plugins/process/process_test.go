@@ -0,0 +1,152 @@
+package process
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProcess struct {
+	name    string
+	cmdline string
+	stats   stats
+}
+
+type fakePS map[int32]*fakeProcess
+
+func (f fakePS) Pids() ([]int32, error) {
+	var pids []int32
+	for pid := range f {
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+func (f fakePS) get(pid int32) (*fakeProcess, error) {
+	p, ok := f[pid]
+	if !ok {
+		return nil, fmt.Errorf("process %d not found", pid)
+	}
+	return p, nil
+}
+
+func (f fakePS) Name(pid int32) (string, error) {
+	p, err := f.get(pid)
+	if err != nil {
+		return "", err
+	}
+	return p.name, nil
+}
+
+func (f fakePS) Cmdline(pid int32) (string, error) {
+	p, err := f.get(pid)
+	if err != nil {
+		return "", err
+	}
+	return p.cmdline, nil
+}
+
+func (f fakePS) Stats(pid int32) (*stats, error) {
+	p, err := f.get(pid)
+	if err != nil {
+		return nil, err
+	}
+	s := p.stats
+	return &s, nil
+}
+
+var processes = fakePS{
+	1:   {"systemd", "/sbin/init", stats{cpu: 10, rss: 100}},
+	100: {"nginx", "nginx: master process /usr/sbin/nginx", stats{cpu: 1, rss: 1000, vms: 4000, fds: 10, threads: 1}},
+	101: {"nginx", "nginx: worker process", stats{cpu: 2, rss: 2000, vms: 4000, fds: 20, threads: 1}},
+	200: {"java", "java -jar /opt/app/app.jar", stats{cpu: 3, rss: 3000, threads: 40}},
+}
+
+func TestCheck(t *testing.T) {
+	pidFile := filepath.Join(os.TempDir(), "process_check_test.pid")
+	require.NoError(t, ioutil.WriteFile(pidFile, []byte("200\n"), 0644))
+	defer os.Remove(pidFile)
+
+	for _, c := range []struct {
+		name     string
+		instance plugin.Instance
+		number   float64
+		status   float64
+		rss      float64
+		message  string
+	}{
+		{"name", plugin.Instance{"search_string": []interface{}{"nginx"}}, 2, metric.ServiceCheckOK, 3000, "2 processes found, at least 1 expected"},
+		{"substring", plugin.Instance{"search_string": []interface{}{"app.jar"}, "exact_match": false}, 1, metric.ServiceCheckOK, 3000,
+			"1 process found, at least 1 expected"},
+		{"pattern", plugin.Instance{"pattern": "worker|init"}, 2, metric.ServiceCheckOK, 2100, "2 processes found, at least 1 expected"},
+		{"pid_file", plugin.Instance{"pid_file": pidFile}, 1, metric.ServiceCheckOK, 3000, "1 process found, at least 1 expected"},
+		{"missing pid_file", plugin.Instance{"pid_file": pidFile + ".missing"}, 0, metric.ServiceCheckCritical, -1,
+			"0 processes found, at least 1 expected"},
+		{"not found", plugin.Instance{"search_string": []interface{}{"redis"}}, 0, metric.ServiceCheckCritical, -1, "0 processes found, at least 1 expected"},
+		{"warning", plugin.Instance{"search_string": []interface{}{"nginx"},
+			"thresholds": map[string]interface{}{"warning": []int{3, 10}, "critical": []int{1, 10}}}, 2, metric.ServiceCheckWarning, 3000,
+			"2 processes found, warning threshold [3, 10], critical threshold [1, 10]"},
+		{"critical", plugin.Instance{"search_string": []interface{}{"nginx"},
+			"thresholds": map[string]interface{}{"critical": []int{0, 1}}}, 2, metric.ServiceCheckCritical, 3000,
+			"2 processes found, critical threshold [0, 1]"},
+	} {
+		c.instance["name"] = "test"
+		p := &Process{ps: processes, samples: make(map[string]map[int32]cpuSample)}
+		agg := &metric.MockAggregator{}
+		require.NoError(t, p.Check(agg, c.instance), c.name)
+
+		value, ok := agg.Value("system.processes.number", "process_name:test")
+		assert.True(t, ok, c.name)
+		assert.Equal(t, c.number, value, c.name)
+		value, _ = agg.Value("system.processes.status")
+		assert.Equal(t, c.status, value, c.name)
+		sc, ok := agg.GetServiceCheck("process.up", "process_name:test")
+		if assert.True(t, ok, c.name) {
+			assert.Equal(t, int(c.status), sc.Status, c.name)
+			assert.Equal(t, c.message, sc.Message, c.name)
+		}
+
+		value, ok = agg.Value("system.processes.mem.rss")
+		if c.rss < 0 {
+			assert.False(t, ok, c.name)
+		} else {
+			assert.Equal(t, c.rss, value, c.name)
+		}
+	}
+}
+
+func TestCPU(t *testing.T) {
+	ps := fakePS{1: {"nginx", "nginx", stats{cpu: 1}}}
+	p := &Process{ps: ps, samples: make(map[string]map[int32]cpuSample)}
+	instance := plugin.Instance{"name": "nginx", "search_string": []interface{}{"nginx"}}
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, p.Check(agg, instance))
+	assert.NotContains(t, agg.Names(), "system.processes.cpu.pct")
+
+	ps[1].stats.cpu = 1000
+	agg = &metric.MockAggregator{}
+	require.NoError(t, p.Check(agg, instance))
+	value, ok := agg.Value("system.processes.cpu.pct")
+	assert.True(t, ok)
+	assert.True(t, value > 0)
+}
+
+func TestBadConfig(t *testing.T) {
+	for _, instance := range []plugin.Instance{
+		{"search_string": []interface{}{"nginx"}},
+		{"name": "nginx"},
+		{"name": "nginx", "pattern": "("},
+		{"name": "nginx", "pattern": "nginx", "thresholds": map[string]interface{}{"critical": []int{1}}},
+	} {
+		p := &Process{ps: processes, samples: make(map[string]map[int32]cpuSample)}
+		assert.Error(t, p.Check(&metric.MockAggregator{}, instance), "%v", instance)
+	}
+}
@@ -0,0 +1,72 @@
+package process
+
+import (
+	"github.com/shirou/gopsutil/process"
+)
+
+// stats are the resources used by a process.
+type stats struct {
+	// cpu is the user and system time in seconds.
+	cpu     float64
+	rss     uint64
+	vms     uint64
+	fds     int32
+	threads int32
+}
+
+// PS XXX
+type PS interface {
+	Pids() ([]int32, error)
+	Name(pid int32) (string, error)
+	Cmdline(pid int32) (string, error)
+	Stats(pid int32) (*stats, error)
+}
+
+type systemPS struct{}
+
+func (s *systemPS) Pids() ([]int32, error) {
+	return process.Pids()
+}
+
+func (s *systemPS) Name(pid int32) (string, error) {
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return "", err
+	}
+	return p.Name()
+}
+
+func (s *systemPS) Cmdline(pid int32) (string, error) {
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return "", err
+	}
+	return p.Cmdline()
+}
+
+func (s *systemPS) Stats(pid int32) (*stats, error) {
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &stats{}
+	times, err := p.Times()
+	if err != nil {
+		return nil, err
+	}
+	result.cpu = times.User + times.System
+
+	mem, err := p.MemoryInfo()
+	if err != nil {
+		return nil, err
+	}
+	result.rss, result.vms = mem.RSS, mem.VMS
+
+	// Reading the descriptors of the processes of other users requires
+	// privileges, report what we can.
+	result.fds, _ = p.NumFDs()
+	result.threads, _ = p.NumThreads()
+
+	return result, nil
+}
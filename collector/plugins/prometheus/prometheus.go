@@ -0,0 +1,205 @@
+package prometheus
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	prom "github.com/cloudinsight/cloudinsight-agent/common/prometheus"
+)
+
+// NewPrometheus XXX
+func NewPrometheus(conf plugin.InitConfig) plugin.Plugin {
+	return &Prometheus{
+		histograms: make(map[string]map[string]*prom.Histogram),
+	}
+}
+
+// Prometheus scrapes any endpoint exposing metrics in the Prometheus text
+// format, e.g. the exporters or the /metrics of the cloud native services.
+type Prometheus struct {
+	sync.Mutex
+
+	// histograms keeps the last scraped histograms by URL, their percentiles
+	// are computed from the observations made between two checks. Only the
+	// histograms of the last scrape are kept.
+	histograms map[string]map[string]*prom.Histogram
+}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	URL string `yaml:"prometheus_url"`
+	// Namespace prefixes the names of the metrics, e.g. namespace.metric.
+	Namespace string `yaml:"namespace"`
+	// Metrics and ExcludeMetrics are glob patterns matched against the
+	// Prometheus metric names, all the metrics are collected by default.
+	Metrics        []string `yaml:"metrics"`
+	ExcludeMetrics []string `yaml:"exclude_metrics"`
+	// LabelsMapper renames the labels turned into tags, ExcludeLabels drops
+	// them.
+	LabelsMapper  map[string]string `yaml:"labels_mapper"`
+	ExcludeLabels []string          `yaml:"exclude_labels"`
+	Tags          []string          `yaml:"tags"`
+}
+
+// quantiles are the percentiles reported for the histograms.
+var quantiles = map[string]float64{
+	"median":       0.5,
+	"95percentile": 0.95,
+	"99percentile": 0.99,
+}
+
+// Check XXX
+func (p *Prometheus) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.URL == "" || conf.Namespace == "" {
+		return fmt.Errorf("a configured prometheus_url and namespace are required")
+	}
+	for _, pattern := range append(conf.Metrics, conf.ExcludeMetrics...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid metric pattern %q: %s", pattern, err)
+		}
+	}
+
+	resp, err := conf.Get(conf.URL)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	families, err := prom.Parse(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not parse the metrics of %s: %s", conf.URL, err)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	prev := p.histograms[conf.URL]
+	seen := make(map[string]*prom.Histogram)
+	for _, f := range families {
+		if !conf.match(f.Name) {
+			continue
+		}
+		name := conf.Namespace + "." + strings.Replace(f.Name, ":", "_", -1)
+
+		switch f.Type {
+		case "counter":
+			for _, s := range f.Samples {
+				agg.Add("rate", metric.NewMetric(name, s.Value, conf.labelsToTags(s.Labels)))
+			}
+		case "histogram":
+			for _, h := range f.Histograms() {
+				submitHistogram(agg, &conf, prev, seen, name, h)
+			}
+		case "summary":
+			for _, s := range f.Samples {
+				tags := conf.labelsToTags(s.Labels)
+				switch s.Name {
+				case f.Name + "_count":
+					agg.Add("rate", metric.NewMetric(name+".count", s.Value, tags))
+				case f.Name + "_sum":
+					agg.Add("rate", metric.NewMetric(name+".sum", s.Value, tags))
+				default:
+					// The quantiles are already computed by the client
+					// library, the quantile label becomes a tag.
+					agg.Add("gauge", metric.NewMetric(name+".quantile", s.Value, tags))
+				}
+			}
+		default:
+			for _, s := range f.Samples {
+				agg.Add("gauge", metric.NewMetric(name, s.Value, conf.labelsToTags(s.Labels)))
+			}
+		}
+	}
+	p.histograms[conf.URL] = seen
+
+	return nil
+}
+
+// submitHistogram reports the observation count and sum as rates, and the
+// average and percentiles of the observations made since the previous check,
+// h is looked up in the histograms of the previous scrape and recorded in
+// seen.
+func submitHistogram(agg metric.Aggregator, conf *instanceConfig, previous, seen map[string]*prom.Histogram, name string, h *prom.Histogram) {
+	tags := conf.labelsToTags(h.Labels)
+	agg.Add("rate", metric.NewMetric(name+".count", h.Count, tags))
+	agg.Add("rate", metric.NewMetric(name+".sum", h.Sum, tags))
+
+	key := name + prom.LabelsKey(h.Labels)
+	prev, ok := previous[key]
+	seen[key] = h
+	if !ok || h.Count < prev.Count {
+		// First run, or the counters have been reset by a restart.
+		return
+	}
+
+	d := h.Delta(prev)
+	if d.Count <= 0 {
+		return
+	}
+	agg.Add("gauge", metric.NewMetric(name+".avg", d.Sum/d.Count, tags))
+	for suffix, q := range quantiles {
+		agg.Add("gauge", metric.NewMetric(name+"."+suffix, d.Quantile(q), tags))
+	}
+}
+
+func (c *instanceConfig) match(name string) bool {
+	for _, pattern := range c.ExcludeMetrics {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+	}
+
+	if len(c.Metrics) == 0 {
+		return true
+	}
+	for _, pattern := range c.Metrics {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *instanceConfig) labelsToTags(labels map[string]string) []string {
+	tags := append([]string{}, c.Tags...)
+	var names []string
+	for name, value := range labels {
+		if value != "" && !contains(c.ExcludeLabels, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tag := name
+		if mapped, ok := c.LabelsMapper[name]; ok {
+			tag = mapped
+		}
+		tags = append(tags, tag+":"+labels[name])
+	}
+	return tags
+}
+
+func contains(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	collector.Add("prometheus", NewPrometheus)
+}
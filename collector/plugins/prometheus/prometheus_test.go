@@ -0,0 +1,112 @@
+package prometheus
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/metrics")
+	require.NoError(t, err)
+	fast, total := 100, 160
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := strings.Replace(string(content), "160", fmt.Sprint(total), -1)
+		fmt.Fprintf(w, body, fast)
+	}))
+	defer server.Close()
+
+	p := NewPrometheus(nil)
+	instance := plugin.Instance{
+		"prometheus_url":  server.URL + "/metrics",
+		"namespace":       "app",
+		"exclude_metrics": []interface{}{"go_*"},
+		"labels_mapper":   map[interface{}]interface{}{"code": "status_code"},
+		"exclude_labels":  []interface{}{"method"},
+		"tags":            []interface{}{"env:test"},
+	}
+	agg := &metric.MockAggregator{}
+	require.NoError(t, p.Check(agg, instance))
+
+	for _, c := range []struct {
+		name       string
+		tags       []string
+		metricType string
+		value      float64
+	}{
+		{"app.http_requests_total", []string{"env:test", "status_code:200"}, "rate", 1027},
+		{"app.http_requests_total", []string{"status_code:400"}, "rate", 3},
+		{"app.queue_length", []string{"queue:default"}, "gauge", 12},
+		{"app.build_info", []string{"version:1.2.3"}, "gauge", 1},
+		{"app.request_duration_seconds.count", nil, "rate", 160},
+		{"app.request_duration_seconds.sum", nil, "rate", 40},
+		{"app.rpc_duration_seconds.quantile", []string{"quantile:0.99"}, "gauge", 0.3},
+		{"app.rpc_duration_seconds.count", nil, "rate", 120},
+	} {
+		m, ok := agg.Get(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			value, _ := agg.Value(c.name, c.tags...)
+			assert.Equal(t, c.value, value, c.name)
+			assert.Equal(t, c.metricType, m.Type, c.name)
+		}
+	}
+	names := agg.Names()
+	assert.NotContains(t, names, "app.go_goroutines")
+	assert.NotContains(t, names, "app.request_duration_seconds.avg")
+	m, _ := agg.Get("app.queue_length")
+	assert.Equal(t, []string{"env:test", "queue:default"}, m.Tags)
+	m, _ = agg.Get("app.http_requests_total")
+	assert.NotContains(t, m.Tags, "method:post")
+
+	// 40 observations since the last check, all faster than 100ms.
+	fast, total = 140, 200
+	agg = &metric.MockAggregator{}
+	require.NoError(t, p.Check(agg, instance))
+	value, ok := agg.Value("app.request_duration_seconds.99percentile")
+	assert.True(t, ok)
+	assert.InDelta(t, 0.099, value, 0.001)
+}
+
+func TestHistogramEviction(t *testing.T) {
+	paths := []string{"/a", "/b"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "# TYPE http_request_duration_seconds histogram")
+		for _, path := range paths {
+			fmt.Fprintf(w, "http_request_duration_seconds_bucket{path=%q,le=\"+Inf\"} 1\n", path)
+			fmt.Fprintf(w, "http_request_duration_seconds_sum{path=%q} 0.1\n", path)
+			fmt.Fprintf(w, "http_request_duration_seconds_count{path=%q} 1\n", path)
+		}
+	}))
+	defer server.Close()
+
+	p := NewPrometheus(nil).(*Prometheus)
+	instance := plugin.Instance{"prometheus_url": server.URL, "namespace": "app"}
+	require.NoError(t, p.Check(&metric.MockAggregator{}, instance))
+	assert.Len(t, p.histograms[server.URL], 2)
+
+	// The histograms of the label sets which are gone are dropped.
+	paths = []string{"/c"}
+	require.NoError(t, p.Check(&metric.MockAggregator{}, instance))
+	assert.Len(t, p.histograms[server.URL], 1)
+	for key := range p.histograms[server.URL] {
+		assert.Contains(t, key, `path="/c"`)
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	p := NewPrometheus(nil)
+	assert.Error(t, p.Check(&metric.MockAggregator{}, plugin.Instance{"namespace": "app"}))
+	assert.Error(t, p.Check(&metric.MockAggregator{}, plugin.Instance{
+		"prometheus_url": "http://localhost:9090/metrics",
+		"namespace":      "app",
+		"metrics":        []interface{}{"["},
+	}))
+}
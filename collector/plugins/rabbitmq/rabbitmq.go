@@ -0,0 +1,251 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// DefaultMaxQueues is the default number of queues we collect metrics for,
+// it protects the backend from users with thousands of queues.
+const DefaultMaxQueues = 200
+
+// NewRabbitMQ XXX
+func NewRabbitMQ(conf plugin.InitConfig) plugin.Plugin {
+	return &RabbitMQ{}
+}
+
+// RabbitMQ collects the overview, node and queue metrics from the RabbitMQ
+// management API.
+type RabbitMQ struct{}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	URL string `yaml:"rabbitmq_api_url"`
+	// Queues and ExcludeQueues are regular expressions matched against the
+	// queue names.
+	Queues        []string `yaml:"queues"`
+	ExcludeQueues []string `yaml:"exclude_queues"`
+	MaxQueues     int      `yaml:"max_queues"`
+	Tags          []string `yaml:"tags"`
+}
+
+var queueMetrics = map[string]string{
+	"messages":                               "rabbitmq.queue.messages",
+	"messages_ready":                         "rabbitmq.queue.messages_ready",
+	"messages_unacknowledged":                "rabbitmq.queue.messages_unacknowledged",
+	"consumers":                              "rabbitmq.queue.consumers",
+	"memory":                                 "rabbitmq.queue.memory",
+	"message_stats.publish_details.rate":     "rabbitmq.queue.messages.publish.rate",
+	"message_stats.deliver_get_details.rate": "rabbitmq.queue.messages.deliver_get.rate",
+	"message_stats.ack_details.rate":         "rabbitmq.queue.messages.ack.rate",
+	"message_stats.redeliver_details.rate":   "rabbitmq.queue.messages.redeliver.rate",
+}
+
+var nodeMetrics = map[string]string{
+	"mem_used":      "rabbitmq.node.mem_used",
+	"mem_limit":     "rabbitmq.node.mem_limit",
+	"fd_used":       "rabbitmq.node.fd_used",
+	"fd_total":      "rabbitmq.node.fd_total",
+	"sockets_used":  "rabbitmq.node.sockets_used",
+	"sockets_total": "rabbitmq.node.sockets_total",
+	"disk_free":     "rabbitmq.node.disk_free",
+	"proc_used":     "rabbitmq.node.proc_used",
+}
+
+var overviewMetrics = map[string]string{
+	"object_totals.connections":                    "rabbitmq.connections",
+	"object_totals.channels":                       "rabbitmq.channels",
+	"object_totals.queues":                         "rabbitmq.queues",
+	"object_totals.consumers":                      "rabbitmq.consumers",
+	"object_totals.exchanges":                      "rabbitmq.exchanges",
+	"queue_totals.messages":                        "rabbitmq.overview.messages",
+	"queue_totals.messages_ready":                  "rabbitmq.overview.messages_ready",
+	"queue_totals.messages_unacknowledged":         "rabbitmq.overview.messages_unacknowledged",
+	"message_stats.publish_details.rate":           "rabbitmq.overview.messages.publish.rate",
+	"message_stats.deliver_get_details.rate":       "rabbitmq.overview.messages.deliver_get.rate",
+	"message_stats.ack_details.rate":               "rabbitmq.overview.messages.ack.rate",
+	"message_stats.confirm_details.rate":           "rabbitmq.overview.messages.confirm.rate",
+	"message_stats.return_unroutable_details.rate": "rabbitmq.overview.messages.return_unroutable.rate",
+}
+
+// Check XXX
+func (r *RabbitMQ) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	conf, err := parseConfig(instance)
+	if err != nil {
+		return err
+	}
+
+	filter, err := newQueueFilter(conf.Queues, conf.ExcludeQueues)
+	if err != nil {
+		return err
+	}
+
+	if err = r.collectOverview(agg, conf); err != nil {
+		return err
+	}
+
+	if err = r.collectNodes(agg, conf); err != nil {
+		return err
+	}
+
+	return r.collectQueues(agg, conf, filter)
+}
+
+func parseConfig(instance plugin.Instance) (*instanceConfig, error) {
+	conf := &instanceConfig{}
+	if err := instance.Unmarshal(conf); err != nil {
+		return nil, err
+	}
+
+	if conf.URL == "" {
+		conf.URL = "http://localhost:15672/api/"
+	}
+	if !strings.HasSuffix(conf.URL, "/") {
+		conf.URL += "/"
+	}
+	if conf.Username == "" && conf.Password == "" {
+		conf.Username = "guest"
+		conf.Password = "guest"
+	}
+	if conf.MaxQueues <= 0 {
+		conf.MaxQueues = DefaultMaxQueues
+	}
+
+	return conf, nil
+}
+
+func (r *RabbitMQ) collectOverview(agg metric.Aggregator, conf *instanceConfig) error {
+	var overview map[string]interface{}
+	if err := conf.GetJSON(conf.URL+"overview", &overview); err != nil {
+		return err
+	}
+
+	tags := conf.Tags
+	if name, ok := overview["cluster_name"].(string); ok {
+		tags = append(append([]string{}, tags...), "rabbitmq_cluster:"+name)
+	}
+	submit(agg, overviewMetrics, overview, tags)
+
+	return nil
+}
+
+func (r *RabbitMQ) collectNodes(agg metric.Aggregator, conf *instanceConfig) error {
+	var nodes []map[string]interface{}
+	if err := conf.GetJSON(conf.URL+"nodes", &nodes); err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		name, _ := node["name"].(string)
+		tags := append(append([]string{}, conf.Tags...), "rabbitmq_node:"+name)
+		submit(agg, nodeMetrics, node, tags)
+
+		running := 0
+		if ok, _ := node["running"].(bool); ok {
+			running = 1
+		}
+		agg.Add("gauge", metric.NewMetric("rabbitmq.node.running", running, tags))
+	}
+
+	return nil
+}
+
+func (r *RabbitMQ) collectQueues(agg metric.Aggregator, conf *instanceConfig, filter *queueFilter) error {
+	var queues []map[string]interface{}
+	if err := conf.GetJSON(conf.URL+"queues", &queues); err != nil {
+		return err
+	}
+
+	count := 0
+	for _, queue := range queues {
+		name, _ := queue["name"].(string)
+		if !filter.match(name) {
+			continue
+		}
+
+		count++
+		if count > conf.MaxQueues {
+			metric.Logger(agg).Warnf("Too many queues to fetch. You must choose the queues you are interested in "+
+				"by editing the rabbitmq.yaml configuration file or get in touch with support, "+
+				"only the first %d queues are collected.", conf.MaxQueues)
+			break
+		}
+
+		vhost, _ := queue["vhost"].(string)
+		tags := append(append([]string{}, conf.Tags...),
+			"rabbitmq_queue:"+name,
+			"rabbitmq_vhost:"+vhost,
+		)
+		if node, ok := queue["node"].(string); ok {
+			tags = append(tags, "rabbitmq_node:"+node)
+		}
+		submit(agg, queueMetrics, queue, tags)
+	}
+
+	return nil
+}
+
+func submit(agg metric.Aggregator, defs map[string]string, data map[string]interface{}, tags []string) {
+	for path, name := range defs {
+		if value, ok := util.GetValue(data, path); ok {
+			if v, ok := value.(float64); ok {
+				agg.Add("gauge", metric.NewMetric(name, v, tags))
+			}
+		}
+	}
+}
+
+// queueFilter keeps the queues matching one of the include patterns (or all
+// of them when there is none) and not matching any of the exclude patterns.
+type queueFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func newQueueFilter(include, exclude []string) (*queueFilter, error) {
+	f := &queueFilter{}
+	for _, pattern := range include {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid queue pattern %q: %s", pattern, err)
+		}
+		f.include = append(f.include, re)
+	}
+	for _, pattern := range exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude_queues pattern %q: %s", pattern, err)
+		}
+		f.exclude = append(f.exclude, re)
+	}
+	return f, nil
+}
+
+func (f *queueFilter) match(name string) bool {
+	for _, re := range f.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	collector.Add("rabbitmq", NewRabbitMQ)
+}
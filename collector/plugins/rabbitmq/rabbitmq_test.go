@@ -0,0 +1,88 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueFilter(t *testing.T) {
+	f, err := newQueueFilter([]string{"^orders\\."}, []string{"\\.tmp$"})
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		in   string
+		want bool
+	}{
+		{"orders.created", true},
+		{"orders.created.tmp", false},
+		{"users", false},
+	} {
+		out := f.match(c.in)
+		if out != c.want {
+			t.Errorf("match(%s) == %t, want %t", c.in, out, c.want)
+		}
+	}
+
+	_, err = newQueueFilter([]string{"("}, nil)
+	assert.Error(t, err)
+}
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		assert.Equal(t, "guest", user)
+		assert.Equal(t, "guest", pass)
+
+		switch r.URL.Path {
+		case "/api/overview":
+			fmt.Fprintln(w, `{"cluster_name": "rabbit@test", "object_totals": {"connections": 3, "channels": 6},
+				"message_stats": {"publish_details": {"rate": 1.5}}}`)
+		case "/api/nodes":
+			fmt.Fprintln(w, `[{"name": "rabbit@test", "running": true, "fd_used": 30, "mem_used": 1024}]`)
+		case "/api/queues":
+			fmt.Fprintln(w, `[
+				{"name": "orders", "vhost": "/", "messages": 12, "consumers": 2,
+				 "message_stats": {"ack_details": {"rate": 0.5}}},
+				{"name": "orders.tmp", "vhost": "/", "messages": 99}
+			]`)
+		}
+	}))
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	r := NewRabbitMQ(nil)
+	err := r.Check(agg, plugin.Instance{
+		"rabbitmq_api_url": server.URL + "/api",
+		"exclude_queues":   []interface{}{"tmp$"},
+	})
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"rabbitmq.connections", []string{"rabbitmq_cluster:rabbit@test"}, 3},
+		{"rabbitmq.channels", nil, 6},
+		{"rabbitmq.overview.messages.publish.rate", nil, 1.5},
+		{"rabbitmq.node.running", []string{"rabbitmq_node:rabbit@test"}, 1},
+		{"rabbitmq.node.fd_used", nil, 30},
+		{"rabbitmq.queue.messages", []string{"rabbitmq_queue:orders", "rabbitmq_vhost:/"}, 12},
+		{"rabbitmq.queue.messages.ack.rate", nil, 0.5},
+	} {
+		value, ok := agg.Value(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s not found", c.name) {
+			assert.Equal(t, c.value, value, c.name)
+		}
+	}
+
+	_, ok := agg.Get("rabbitmq.queue.messages", "rabbitmq_queue:orders.tmp")
+	assert.False(t, ok)
+}
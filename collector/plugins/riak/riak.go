@@ -0,0 +1,145 @@
+package riak
+
+import (
+	"fmt"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewRiak XXX
+func NewRiak(conf plugin.InitConfig) plugin.Plugin {
+	return &Riak{}
+}
+
+// Riak collects the throughput, latency and memory stats of a Riak KV node
+// from its /stats endpoint.
+type Riak struct{}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	URL  string   `yaml:"url"`
+	Tags []string `yaml:"tags"`
+}
+
+// gauges are the stats computed over the last minute by Riak, and the
+// current state of the node.
+var gauges = []string{
+	"node_gets",
+	"node_puts",
+	"vnode_gets",
+	"vnode_puts",
+	"vnode_index_reads",
+	"vnode_index_writes",
+	"read_repairs",
+	"node_get_fsm_active",
+	"node_put_fsm_active",
+	"node_get_fsm_rejected",
+	"node_put_fsm_rejected",
+	"pbc_active",
+	"pbc_connects",
+	"search_query_throughput_one",
+	"search_index_throughput_one",
+	"memory_total",
+	"memory_processes",
+	"memory_processes_used",
+	"memory_atom_used",
+	"memory_binary",
+	"memory_code",
+	"memory_ets",
+	"sys_process_count",
+	"cpu_avg1",
+	"cpu_avg5",
+	"cpu_avg15",
+}
+
+// counters are the totals since the node started, submitted as rates.
+var counters = []string{
+	"node_gets_total",
+	"node_puts_total",
+	"vnode_gets_total",
+	"vnode_puts_total",
+	"read_repairs_total",
+	"coord_redirs_total",
+	"pbc_connects_total",
+	"precommit_fail",
+	"postcommit_fail",
+}
+
+// histograms are the latency (in microseconds), object size and siblings
+// distributions of the last minute, reported by their mean, median, 95th,
+// 99th and 100th percentiles.
+var histograms = []string{
+	"node_get_fsm_time",
+	"node_put_fsm_time",
+	"node_get_fsm_objsize",
+	"node_get_fsm_siblings",
+	"search_query_latency",
+	"search_index_latency",
+}
+
+var histogramStats = []string{"mean", "median", "95", "99", "100"}
+
+// Check XXX
+func (r *Riak) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.URL == "" {
+		conf.URL = "http://localhost:8098/stats"
+	}
+
+	var stats map[string]interface{}
+	if err := conf.GetJSON(conf.URL, &stats); err != nil {
+		return err
+	}
+
+	tags := conf.Tags
+	if node, ok := stats["nodename"].(string); ok {
+		tags = append(append([]string{}, tags...), "riak_node:"+node)
+	}
+
+	submit := func(metricType, key string) {
+		if value, ok := stats[key].(float64); ok {
+			agg.Add(metricType, metric.NewMetric("riak."+key, value, tags))
+		}
+	}
+	for _, key := range gauges {
+		submit("gauge", key)
+	}
+	for _, key := range counters {
+		submit("rate", key)
+	}
+	for _, key := range histograms {
+		for _, stat := range histogramStats {
+			submit("gauge", fmt.Sprintf("%s_%s", key, stat))
+		}
+	}
+
+	// The disks are listed with their size in KB and their usage in %.
+	if disks, ok := stats["disk"].([]interface{}); ok {
+		for _, d := range disks {
+			info, ok := d.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			id, _ := info["id"].(string)
+			diskTags := append(append([]string{}, tags...), "device:"+id)
+			if size, ok := info["size"].(float64); ok {
+				agg.Add("gauge", metric.NewMetric("riak.disk.size", size*1024, diskTags))
+			}
+			if used, ok := info["used"].(float64); ok {
+				agg.Add("gauge", metric.NewMetric("riak.disk.in_use", used, diskTags))
+			}
+		}
+	}
+
+	return nil
+}
+
+func init() {
+	collector.Add("riak", NewRiak)
+}
@@ -0,0 +1,65 @@
+package riak
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const statsResponse = `{
+  "nodename": "riak@127.0.0.1",
+  "node_gets": 120,
+  "node_gets_total": 51234,
+  "node_puts": 40,
+  "node_get_fsm_time_mean": 1523,
+  "node_get_fsm_time_99": 8012,
+  "node_put_fsm_time_100": 15000,
+  "memory_total": 104857600,
+  "ring_members": ["riak@127.0.0.1"],
+  "disk": [{"id": "/", "size": 1024, "used": 42}]
+}`
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, statsResponse)
+	}))
+	defer server.Close()
+
+	r := NewRiak(nil)
+	agg := &metric.MockAggregator{}
+	require.NoError(t, r.Check(agg, plugin.Instance{"url": server.URL + "/stats"}))
+
+	for _, c := range []struct {
+		name       string
+		tags       []string
+		metricType string
+		value      float64
+	}{
+		{"riak.node_gets", nil, "gauge", 120},
+		{"riak.node_gets_total", nil, "rate", 51234},
+		{"riak.node_get_fsm_time_mean", nil, "gauge", 1523},
+		{"riak.node_get_fsm_time_99", nil, "gauge", 8012},
+		{"riak.node_put_fsm_time_100", nil, "gauge", 15000},
+		{"riak.memory_total", nil, "gauge", 104857600},
+		{"riak.disk.size", []string{"device:/"}, "gauge", 1024 * 1024},
+		{"riak.disk.in_use", []string{"device:/"}, "gauge", 42},
+	} {
+		m, ok := agg.Get(c.name, append(c.tags, "riak_node:riak@127.0.0.1")...)
+		if assert.True(t, ok, "metric %s not found", c.name) {
+			value, _ := agg.Value(c.name, c.tags...)
+			assert.Equal(t, c.value, value, c.name)
+			assert.Equal(t, c.metricType, m.Type, c.name)
+		}
+	}
+	assert.Len(t, agg.Metrics, 9)
+}
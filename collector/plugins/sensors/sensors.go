@@ -0,0 +1,211 @@
+package sensors
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// runCommand is replaced in tests.
+var runCommand = util.RunCommand
+
+// NewSensors XXX
+func NewSensors(conf plugin.InitConfig) plugin.Plugin {
+	return &Sensors{}
+}
+
+// Sensors reads the hardware monitoring chips exposed by the kernel, the same
+// ones lm-sensors reads, and optionally the IPMI sensors of the BMC.
+type Sensors struct{}
+
+type instanceConfig struct {
+	HwmonPath string `yaml:"hwmon_path"`
+	// IPMI reads the sensors of the BMC with ipmitool.
+	IPMI     bool     `yaml:"ipmi"`
+	IPMITool string   `yaml:"ipmitool"`
+	UseSudo  bool     `yaml:"use_sudo"`
+	Timeout  int      `yaml:"timeout"`
+	Tags     []string `yaml:"tags"`
+}
+
+type hwmonInput struct {
+	name  string
+	scale float64
+}
+
+// hwmonInputs maps the prefixes of the input files of the hwmon sysfs
+// interface to metric names, see Documentation/hwmon/sysfs-interface
+var hwmonInputs = map[string]hwmonInput{
+	"temp":  {"sensors.temperature", 1000},
+	"fan":   {"sensors.fan_speed", 1},
+	"in":    {"sensors.voltage", 1000},
+	"power": {"sensors.power", 1000000},
+	"curr":  {"sensors.current", 1000},
+}
+
+var inputFile = regexp.MustCompile(`^([a-z]+)(\d+)_input$`)
+
+// ipmiUnits maps the units of ipmitool sdr to metric names.
+var ipmiUnits = map[string]string{
+	"degrees C": "ipmi.temperature",
+	"RPM":       "ipmi.fan_speed",
+	"Volts":     "ipmi.voltage",
+	"Watts":     "ipmi.power",
+	"Amps":      "ipmi.current",
+	"percent":   "ipmi.percent",
+}
+
+// ipmiStatus maps the status column of ipmitool sdr to the service check
+// statuses, ns (no reading) is skipped.
+var ipmiStatus = map[string]int{
+	"ok": metric.ServiceCheckOK,
+	"nc": metric.ServiceCheckWarning,
+	"cr": metric.ServiceCheckCritical,
+	"nr": metric.ServiceCheckCritical,
+}
+
+// Check XXX
+func (s *Sensors) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.HwmonPath == "" {
+		conf.HwmonPath = "/sys/class/hwmon"
+	}
+	if conf.IPMITool == "" {
+		conf.IPMITool = "ipmitool"
+	}
+
+	if err := s.collectHwmon(agg, &conf); err != nil {
+		return err
+	}
+
+	if conf.IPMI {
+		return s.collectIPMI(agg, &conf)
+	}
+	return nil
+}
+
+func (s *Sensors) collectHwmon(agg metric.Aggregator, conf *instanceConfig) error {
+	chips, err := filepath.Glob(filepath.Join(conf.HwmonPath, "hwmon*"))
+	if err != nil {
+		return err
+	}
+
+	for _, chip := range chips {
+		// Older kernels expose the attributes in the device directory.
+		dir := chip
+		if _, err := os.Stat(filepath.Join(chip, "name")); os.IsNotExist(err) {
+			dir = filepath.Join(chip, "device")
+		}
+		name := readString(filepath.Join(dir, "name"))
+		if name == "" {
+			name = filepath.Base(chip)
+		}
+
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			metric.Logger(agg).Debugf("Failed to read %s: %s", dir, err)
+			continue
+		}
+		for _, f := range files {
+			m := inputFile.FindStringSubmatch(f.Name())
+			if m == nil {
+				continue
+			}
+			input, ok := hwmonInputs[m[1]]
+			if !ok {
+				continue
+			}
+			raw, err := strconv.ParseFloat(readString(filepath.Join(dir, f.Name())), 64)
+			if err != nil {
+				continue
+			}
+
+			label := readString(filepath.Join(dir, m[1]+m[2]+"_label"))
+			if label == "" {
+				label = m[1] + m[2]
+			}
+			tags := append(append([]string{}, conf.Tags...), "chip:"+name, "sensor:"+label)
+			agg.Add("gauge", metric.NewMetric(input.name, raw/input.scale, tags))
+		}
+	}
+
+	return nil
+}
+
+// collectIPMI parses the output of ipmitool sdr list, e.g.
+//
+//	CPU1 Temp        | 45 degrees C      | ok
+//	FAN1             | 4200 RPM          | ok
+//	PS1 Status       | 0x01              | ok
+func (s *Sensors) collectIPMI(agg metric.Aggregator, conf *instanceConfig) error {
+	name, args := conf.IPMITool, []string{"sdr", "list"}
+	if conf.UseSudo {
+		name, args = "sudo", append([]string{"-n", conf.IPMITool}, args...)
+	}
+	out, err := runCommand(time.Duration(conf.Timeout)*time.Second, name, args...)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "|")
+		if len(fields) != 3 {
+			continue
+		}
+		sensor := strings.TrimSpace(fields[0])
+		reading := strings.TrimSpace(fields[1])
+		code := strings.TrimSpace(fields[2])
+		status, ok := ipmiStatus[code]
+		if !ok {
+			continue
+		}
+
+		tags := append(append([]string{}, conf.Tags...), "sensor:"+sensor)
+		agg.Add("gauge", metric.NewMetric("ipmi.sensor.status", status, tags))
+		sc := metric.NewServiceCheck("ipmi.sensor", status, tags)
+		sc.Message = reading + " (" + code + ")"
+		agg.AddServiceCheck(sc)
+
+		parts := strings.SplitN(reading, " ", 2)
+		if len(parts) != 2 {
+			// Discrete sensors, e.g. the PSU status, only have a status.
+			continue
+		}
+		metricName, ok := ipmiUnits[parts[1]]
+		if !ok {
+			continue
+		}
+		if value, err := strconv.ParseFloat(parts[0], 64); err == nil {
+			agg.Add("gauge", metric.NewMetric(metricName, value, tags))
+		}
+	}
+
+	return scanner.Err()
+}
+
+func readString(path string) string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+func init() {
+	collector.Add("sensors", NewSensors)
+}
@@ -0,0 +1,86 @@
+package sensors
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	output, err := ioutil.ReadFile("testdata/ipmitool.txt")
+	require.NoError(t, err)
+
+	var command string
+	runCommand = func(timeout time.Duration, name string, args ...string) ([]byte, error) {
+		command = name + " " + strings.Join(args, " ")
+		return output, nil
+	}
+
+	agg := &metric.MockAggregator{}
+	err = NewSensors(nil).Check(agg, plugin.Instance{
+		"hwmon_path": "testdata/hwmon",
+		"ipmi":       true,
+		"use_sudo":   true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "sudo -n ipmitool sdr list", command)
+
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"sensors.temperature", []string{"chip:coretemp", "sensor:Package id 0"}, 45},
+		{"sensors.temperature", []string{"chip:coretemp", "sensor:temp2"}, 52.5},
+		{"sensors.fan_speed", []string{"chip:nct6775", "sensor:fan1"}, 1250},
+		{"sensors.voltage", []string{"chip:nct6775", "sensor:Vcore"}, 1.104},
+		{"ipmi.temperature", []string{"sensor:CPU2 Temp"}, 91},
+		{"ipmi.fan_speed", []string{"sensor:FAN1"}, 4200},
+		{"ipmi.voltage", []string{"sensor:12V"}, 12.1},
+		{"ipmi.power", []string{"sensor:Pwr Consumption"}, 224},
+		{"ipmi.sensor.status", []string{"sensor:CPU1 Temp"}, metric.ServiceCheckOK},
+		{"ipmi.sensor.status", []string{"sensor:FAN2"}, metric.ServiceCheckWarning},
+		{"ipmi.sensor.status", []string{"sensor:PS2 Status"}, metric.ServiceCheckCritical},
+	} {
+		value, ok := agg.Value(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			assert.Equal(t, c.value, value, c.name)
+		}
+	}
+
+	for _, c := range []struct {
+		sensor  string
+		status  int
+		message string
+	}{
+		{"CPU1 Temp", metric.ServiceCheckOK, "45 degrees C (ok)"},
+		{"CPU2 Temp", metric.ServiceCheckCritical, "91 degrees C (cr)"},
+		{"FAN2", metric.ServiceCheckWarning, "0 RPM (nc)"},
+		{"PS2 Status", metric.ServiceCheckCritical, "0x0b (cr)"},
+	} {
+		sc, ok := agg.GetServiceCheck("ipmi.sensor", "sensor:"+c.sensor)
+		if assert.True(t, ok, "service check of %s not found", c.sensor) {
+			assert.Equal(t, c.status, sc.Status, c.sensor)
+			assert.Equal(t, c.message, sc.Message, c.sensor)
+		}
+	}
+
+	_, ok := agg.Get("ipmi.sensor.status", "sensor:HDD Status")
+	assert.False(t, ok)
+	_, ok = agg.GetServiceCheck("ipmi.sensor", "sensor:HDD Status")
+	assert.False(t, ok)
+	_, ok = agg.Get("sensors.temperature", "sensor:temp2_max")
+	assert.False(t, ok)
+}
+
+func TestNoHwmon(t *testing.T) {
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewSensors(nil).Check(agg, plugin.Instance{"hwmon_path": "testdata/missing"}))
+	assert.Empty(t, agg.Metrics)
+}
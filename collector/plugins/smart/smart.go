@@ -0,0 +1,242 @@
+package smart
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// runCommand is replaced in tests.
+var runCommand = util.RunCommand
+
+// NewSmart XXX
+func NewSmart(conf plugin.InitConfig) plugin.Plugin {
+	return &Smart{}
+}
+
+// Smart reports the S.M.A.R.T. health and attributes of the disks as read
+// by smartctl.
+type Smart struct{}
+
+type instanceConfig struct {
+	SmartCtl string `yaml:"smartctl"`
+	// Devices defaults to the devices found by smartctl --scan, a device
+	// may be followed by its type like in the scan, e.g.
+	// "/dev/bus/0 -d megaraid,1".
+	Devices []string `yaml:"devices"`
+	UseSudo bool     `yaml:"use_sudo"`
+	Timeout int      `yaml:"timeout"`
+	Tags    []string `yaml:"tags"`
+}
+
+// attributes maps the ATA attribute IDs to metric names, the raw values are
+// reported.
+var attributes = map[string]string{
+	"5":   "smart.reallocated_sectors",
+	"9":   "smart.power_on_hours",
+	"10":  "smart.spin_retry_count",
+	"187": "smart.reported_uncorrectable",
+	"188": "smart.command_timeout",
+	"190": "smart.temperature",
+	"194": "smart.temperature",
+	"196": "smart.reallocated_events",
+	"197": "smart.pending_sectors",
+	"198": "smart.offline_uncorrectable",
+	"199": "smart.udma_crc_errors",
+}
+
+// infoLines maps the lines of the SCSI and NVMe health information to
+// metric names.
+var infoLines = map[string]string{
+	"Current Drive Temperature":       "smart.temperature",
+	"Temperature":                     "smart.temperature",
+	"Elements in grown defect list":   "smart.reallocated_sectors",
+	"Media and Data Integrity Errors": "smart.media_errors",
+	"Percentage Used":                 "smart.percentage_used",
+	"Power On Hours":                  "smart.power_on_hours",
+	"Available Spare":                 "smart.available_spare",
+}
+
+// Check XXX
+func (s *Smart) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.SmartCtl == "" {
+		conf.SmartCtl = "smartctl"
+	}
+
+	var devices []device
+	for _, line := range conf.Devices {
+		if d, ok := parseDevice(line); ok {
+			devices = append(devices, d)
+		}
+	}
+	if len(conf.Devices) == 0 {
+		out, err := conf.run("--scan")
+		if err != nil {
+			return err
+		}
+		devices = parseScan(out)
+	}
+
+	for _, d := range devices {
+		// smartctl sets the bits of its exit status for failing disks too,
+		// only an empty output is an error.
+		out, err := conf.run(append([]string{"-H", "-A"}, d.args()...)...)
+		if len(out) == 0 {
+			metric.Logger(agg).Errorf("Failed to read the S.M.A.R.T. data of %s: %s", d, err)
+			continue
+		}
+
+		tags := append(append([]string{}, conf.Tags...), "device:"+filepath.Base(d.path))
+		if d.typ != "" {
+			tags = append(tags, "device_type:"+d.typ)
+		}
+		for name, value := range parseOutput(out) {
+			agg.Add("gauge", metric.NewMetric(name, value, tags))
+		}
+	}
+
+	return nil
+}
+
+// device is a disk as given to smartctl, the disks behind a RAID controller
+// share its path and differ by their type, e.g. megaraid,0 and megaraid,1.
+type device struct {
+	path string
+	typ  string
+}
+
+// args returns the arguments of smartctl selecting the device.
+func (d device) args() []string {
+	if d.typ == "" {
+		return []string{d.path}
+	}
+	return []string{"-d", d.typ, d.path}
+}
+
+func (d device) String() string {
+	return strings.Join(d.args(), " ")
+}
+
+// parseDevice parses a device and its optional type, e.g.
+//
+//	/dev/bus/0 -d megaraid,1 # /dev/bus/0 [megaraid_disk_01], SCSI device
+func parseDevice(line string) (device, bool) {
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/dev/") {
+		return device{}, false
+	}
+	d := device{path: fields[0]}
+	for i := 1; i < len(fields)-1; i++ {
+		if fields[i] == "-d" {
+			d.typ = fields[i+1]
+		}
+	}
+	return d, true
+}
+
+func (conf *instanceConfig) run(args ...string) ([]byte, error) {
+	name := conf.SmartCtl
+	if conf.UseSudo {
+		name, args = "sudo", append([]string{"-n", conf.SmartCtl}, args...)
+	}
+	return runCommand(time.Duration(conf.Timeout)*time.Second, name, args...)
+}
+
+// parseScan parses the output of smartctl --scan, e.g.
+//
+//	/dev/sda -d scsi # /dev/sda, SCSI device
+//	/dev/bus/0 -d megaraid,0 # /dev/bus/0 [megaraid_disk_00], SCSI device
+func parseScan(out []byte) []device {
+	var devices []device
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if d, ok := parseDevice(scanner.Text()); ok {
+			devices = append(devices, d)
+		}
+	}
+	return devices
+}
+
+// parseOutput extracts the health and the attributes from the output of
+// smartctl -H -A.
+func parseOutput(out []byte) map[string]float64 {
+	values := make(map[string]float64)
+	inTable := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "SMART overall-health self-assessment test result:"),
+			strings.HasPrefix(line, "SMART Health Status:"):
+			status := strings.TrimSpace(line[strings.Index(line, ":")+1:])
+			values["smart.health"] = 0
+			if status == "PASSED" || status == "OK" {
+				values["smart.health"] = 1
+			}
+			continue
+		case strings.HasPrefix(line, "ID#"):
+			inTable = true
+			continue
+		case line == "":
+			inTable = false
+			continue
+		}
+
+		if inTable {
+			// ID# ATTRIBUTE_NAME FLAG VALUE WORST THRESH TYPE UPDATED WHEN_FAILED RAW_VALUE
+			fields := strings.Fields(line)
+			if len(fields) < 10 {
+				continue
+			}
+			name, ok := attributes[fields[0]]
+			if !ok {
+				continue
+			}
+			if value, err := strconv.ParseFloat(fields[9], 64); err == nil {
+				values[name] = value
+			}
+			continue
+		}
+
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		name, ok := infoLines[line[:i]]
+		if !ok {
+			continue
+		}
+		// e.g. "35 C", "38 Celsius", "3%" or "7,843"
+		fields := strings.Fields(line[i+1:])
+		if len(fields) == 0 {
+			continue
+		}
+		raw := strings.Replace(strings.TrimSuffix(fields[0], "%"), ",", "", -1)
+		if value, err := strconv.ParseFloat(raw, 64); err == nil {
+			values[name] = value
+		}
+	}
+
+	return values
+}
+
+func init() {
+	collector.Add("smart", NewSmart)
+}
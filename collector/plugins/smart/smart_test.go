@@ -0,0 +1,114 @@
+package smart
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOutput(t *testing.T) {
+	for _, c := range []struct {
+		file string
+		want map[string]float64
+	}{
+		{"testdata/ata.txt", map[string]float64{
+			"smart.health":                1,
+			"smart.reallocated_sectors":   8,
+			"smart.power_on_hours":        19934,
+			"smart.temperature":           35,
+			"smart.pending_sectors":       2,
+			"smart.offline_uncorrectable": 0,
+		}},
+		{"testdata/nvme.txt", map[string]float64{
+			"smart.health":          0,
+			"smart.temperature":     38,
+			"smart.available_spare": 100,
+			"smart.percentage_used": 3,
+			"smart.power_on_hours":  7843,
+			"smart.media_errors":    0,
+		}},
+	} {
+		out, err := ioutil.ReadFile(c.file)
+		require.NoError(t, err)
+		assert.Equal(t, c.want, parseOutput(out), c.file)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ata, err := ioutil.ReadFile("testdata/ata.txt")
+	require.NoError(t, err)
+
+	var commands []string
+	runCommand = func(timeout time.Duration, name string, args ...string) ([]byte, error) {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		switch args[len(args)-1] {
+		case "--scan":
+			return []byte("/dev/sda -d scsi # /dev/sda, SCSI device\n/dev/sdb -d scsi # /dev/sdb, SCSI device\n" +
+				"/dev/bus/0 -d megaraid,0 # /dev/bus/0 [megaraid_disk_00], SCSI device\n" +
+				"/dev/bus/0 -d megaraid,1 # /dev/bus/0 [megaraid_disk_01], SCSI device\n"), nil
+		case "/dev/sda", "/dev/bus/0":
+			// Bit 3 of the exit status: the disk is failing.
+			return ata, fmt.Errorf("smartctl failed: exit status 8")
+		}
+		return nil, fmt.Errorf("smartctl failed: exit status 2")
+	}
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewSmart(nil).Check(agg, plugin.Instance{"use_sudo": true}))
+	assert.Equal(t, []string{
+		"sudo -n smartctl --scan",
+		"sudo -n smartctl -H -A -d scsi /dev/sda",
+		"sudo -n smartctl -H -A -d scsi /dev/sdb",
+		"sudo -n smartctl -H -A -d megaraid,0 /dev/bus/0",
+		"sudo -n smartctl -H -A -d megaraid,1 /dev/bus/0",
+	}, commands)
+
+	value, ok := agg.Value("smart.reallocated_sectors", "device:sda", "device_type:scsi")
+	assert.True(t, ok)
+	assert.Equal(t, float64(8), value)
+	_, ok = agg.Get("smart.health", "device:sdb")
+	assert.False(t, ok)
+	// The disks behind the controller are told apart by their type.
+	for _, typ := range []string{"megaraid,0", "megaraid,1"} {
+		_, ok = agg.Get("smart.health", "device:0", "device_type:"+typ)
+		assert.True(t, ok, typ)
+	}
+
+	// The configured devices may have a type too.
+	commands = nil
+	agg = &metric.MockAggregator{}
+	require.NoError(t, NewSmart(nil).Check(agg, plugin.Instance{
+		"devices": []interface{}{"/dev/sda", "/dev/bus/0 -d megaraid,1"},
+	}))
+	assert.Equal(t, []string{
+		"smartctl -H -A /dev/sda",
+		"smartctl -H -A -d megaraid,1 /dev/bus/0",
+	}, commands)
+	_, ok = agg.Get("smart.health", "device:0", "device_type:megaraid,1")
+	assert.True(t, ok)
+}
+
+func TestParseDevice(t *testing.T) {
+	for _, c := range []struct {
+		line string
+		want device
+		ok   bool
+	}{
+		{"/dev/sda", device{path: "/dev/sda"}, true},
+		{"/dev/sda -d sat # /dev/sda [SAT], ATA device", device{path: "/dev/sda", typ: "sat"}, true},
+		{"/dev/bus/0 -d megaraid,1", device{path: "/dev/bus/0", typ: "megaraid,1"}, true},
+		{"# comment", device{}, false},
+		{"", device{}, false},
+	} {
+		d, ok := parseDevice(c.line)
+		assert.Equal(t, c.ok, ok, c.line)
+		assert.Equal(t, c.want, d, c.line)
+	}
+}
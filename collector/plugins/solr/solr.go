@@ -0,0 +1,240 @@
+package solr
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewSolr XXX
+func NewSolr(conf plugin.InitConfig) plugin.Plugin {
+	return &Solr{}
+}
+
+// Solr collects the request handler, cache, index and replication metrics of
+// every core of a Solr node from its metrics API (Solr 7+).
+type Solr struct{}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	URL string `yaml:"url"`
+	// Handlers are the request handlers whose metrics are collected, e.g.
+	// /select or /update.
+	Handlers []string `yaml:"handlers"`
+	Tags     []string `yaml:"tags"`
+}
+
+var defaultHandlers = []string{"/select", "/query", "/get", "/update"}
+
+// metricPrefixes restricts the response of the metrics API to what we use.
+var metricPrefixes = []string{
+	"CORE.coreName",
+	"QUERY.",
+	"UPDATE.",
+	"CACHE.searcher.",
+	"INDEX.sizeInBytes",
+	"SEARCHER.searcher.",
+}
+
+var searcherMetrics = map[string]string{
+	"SEARCHER.searcher.numDocs":     "solr.searcher.num_docs",
+	"SEARCHER.searcher.maxDoc":      "solr.searcher.max_docs",
+	"SEARCHER.searcher.deletedDocs": "solr.searcher.deleted_docs",
+	"INDEX.sizeInBytes":             "solr.index.size",
+}
+
+var cacheMetrics = map[string]string{
+	"lookups":   "rate",
+	"hits":      "rate",
+	"inserts":   "rate",
+	"evictions": "rate",
+	"hitratio":  "gauge",
+	"size":      "gauge",
+}
+
+// timerMetrics are the percentiles of the request times we keep, in ms.
+var timerMetrics = map[string]string{
+	"mean_ms":   "solr.request_handler.request_time.mean",
+	"median_ms": "solr.request_handler.request_time.median",
+	"p95_ms":    "solr.request_handler.request_time.95percentile",
+	"p99_ms":    "solr.request_handler.request_time.99percentile",
+}
+
+type metricsResponse struct {
+	Metrics map[string]map[string]interface{} `json:"metrics"`
+}
+
+// replicationDetails is the response of the replication handler, Solr 8.7
+// renamed the master and slave sections to leader and follower.
+type replicationDetails struct {
+	Details struct {
+		IndexVersion float64              `json:"indexVersion"`
+		Generation   float64              `json:"generation"`
+		Follower     *replicationFollower `json:"follower"`
+		Slave        *replicationFollower `json:"slave"`
+	} `json:"details"`
+}
+
+type replicationFollower struct {
+	LeaderDetails *replicationIndex `json:"leaderDetails"`
+	MasterDetails *replicationIndex `json:"masterDetails"`
+}
+
+type replicationIndex struct {
+	IndexVersion float64 `json:"indexVersion"`
+	Generation   float64 `json:"generation"`
+}
+
+// Check XXX
+func (s *Solr) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.URL == "" {
+		conf.URL = "http://localhost:8983/solr"
+	}
+	conf.URL = strings.TrimSuffix(conf.URL, "/")
+	if len(conf.Handlers) == 0 {
+		conf.Handlers = defaultHandlers
+	}
+
+	query := url.Values{}
+	query.Set("group", "core")
+	query.Set("prefix", strings.Join(metricPrefixes, ","))
+	query.Set("wt", "json")
+	var resp metricsResponse
+	if err := conf.GetJSON(conf.URL+"/admin/metrics?"+query.Encode(), &resp); err != nil {
+		return err
+	}
+
+	for registry, metrics := range resp.Metrics {
+		tags, cloud := coreTags(registry, conf.Tags)
+		s.submitCore(agg, &conf, metrics, tags)
+
+		// The replicas of SolrCloud which pull the index from their shard
+		// leader report how far behind it they are.
+		if name, ok := metrics["CORE.coreName"].(string); ok && cloud {
+			if err := s.collectReplication(agg, &conf, name, tags); err != nil {
+				metric.Logger(agg).Warnf("Could not collect the replication lag of %s: %s", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// coreTags turns the registry name of a core into tags, it's
+// solr.core.<collection>.<shard>.<replica> in SolrCloud mode and
+// solr.core.<core> otherwise.
+func coreTags(registry string, tags []string) ([]string, bool) {
+	tags = append([]string{}, tags...)
+	parts := strings.SplitN(strings.TrimPrefix(registry, "solr.core."), ".", 3)
+	if len(parts) != 3 {
+		return append(tags, "core:"+parts[0]), false
+	}
+	return append(tags,
+		"core:"+strings.Join(parts, "_"),
+		"collection:"+parts[0],
+		"shard:"+parts[1],
+		"replica:"+parts[2],
+	), true
+}
+
+func (s *Solr) submitCore(agg metric.Aggregator, conf *instanceConfig, metrics map[string]interface{}, tags []string) {
+	for key, name := range searcherMetrics {
+		if value, ok := metrics[key].(float64); ok {
+			agg.Add("gauge", metric.NewMetric(name, value, tags))
+		}
+	}
+
+	for key, value := range metrics {
+		if !strings.HasPrefix(key, "CACHE.searcher.") {
+			continue
+		}
+		stats, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		cacheTags := append(append([]string{}, tags...), "cache:"+strings.TrimPrefix(key, "CACHE.searcher."))
+		for stat, metricType := range cacheMetrics {
+			if v, ok := stats[stat].(float64); ok {
+				agg.Add(metricType, metric.NewMetric("solr.cache."+stat, v, cacheTags))
+			}
+		}
+	}
+
+	for _, category := range []string{"QUERY", "UPDATE"} {
+		for _, handler := range conf.Handlers {
+			prefix := category + "." + handler + "."
+			if _, ok := metrics[prefix+"requests"]; !ok {
+				continue
+			}
+			handlerTags := append(append([]string{}, tags...), "handler:"+handler, "category:"+category)
+			for _, counter := range []string{"requests", "errors", "timeouts"} {
+				if v, ok := count(metrics[prefix+counter]); ok {
+					agg.Add("rate", metric.NewMetric("solr.request_handler."+counter, v, handlerTags))
+				}
+			}
+			times, _ := metrics[prefix+"requestTimes"].(map[string]interface{})
+			for key, name := range timerMetrics {
+				if v, ok := times[key].(float64); ok {
+					agg.Add("gauge", metric.NewMetric(name, v, handlerTags))
+				}
+			}
+		}
+	}
+}
+
+// count returns the value of a counter, or the count of a meter or a timer.
+func count(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case map[string]interface{}:
+		c, ok := v["count"].(float64)
+		return c, ok
+	}
+	return 0, false
+}
+
+func (s *Solr) collectReplication(agg metric.Aggregator, conf *instanceConfig, core string, tags []string) error {
+	var resp replicationDetails
+	endpoint := fmt.Sprintf("%s/%s/replication?command=details&wt=json", conf.URL, url.PathEscape(core))
+	if err := conf.GetJSON(endpoint, &resp); err != nil {
+		return err
+	}
+
+	follower := resp.Details.Follower
+	if follower == nil {
+		follower = resp.Details.Slave
+	}
+	if follower == nil {
+		return nil
+	}
+	leader := follower.LeaderDetails
+	if leader == nil {
+		leader = follower.MasterDetails
+	}
+	if leader == nil {
+		return nil
+	}
+
+	// The index version is the commit timestamp in ms.
+	lag := (leader.IndexVersion - resp.Details.IndexVersion) / 1000
+	if lag < 0 {
+		lag = 0
+	}
+	agg.Add("gauge", metric.NewMetric("solr.replication.lag", lag, tags))
+	agg.Add("gauge", metric.NewMetric("solr.replication.generation_lag", leader.Generation-resp.Details.Generation, tags))
+	return nil
+}
+
+func init() {
+	collector.Add("solr", NewSolr)
+}
@@ -0,0 +1,68 @@
+package solr
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var file string
+		switch r.URL.Path {
+		case "/solr/admin/metrics":
+			assert.Equal(t, "core", r.URL.Query().Get("group"))
+			file = "testdata/metrics.json"
+		case "/solr/products_shard1_replica_t2/replication":
+			file = "testdata/replication.json"
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		content, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	s := NewSolr(nil)
+	agg := &metric.MockAggregator{}
+	require.NoError(t, s.Check(agg, plugin.Instance{"url": server.URL + "/solr"}))
+
+	cloud := []string{"core:products_shard1_replica_t2", "collection:products", "shard:shard1", "replica:replica_t2"}
+	handler := append([]string{"handler:/select", "category:QUERY"}, cloud...)
+	cache := append([]string{"cache:queryResultCache"}, cloud...)
+	for _, c := range []struct {
+		name       string
+		tags       []string
+		metricType string
+		value      float64
+	}{
+		{"solr.index.size", cloud, "gauge", 524288},
+		{"solr.searcher.num_docs", cloud, "gauge", 1200},
+		{"solr.searcher.deleted_docs", cloud, "gauge", 50},
+		{"solr.cache.hitratio", cache, "gauge", 0.75},
+		{"solr.cache.lookups", cache, "rate", 100},
+		{"solr.request_handler.requests", handler, "rate", 420},
+		{"solr.request_handler.errors", handler, "rate", 2},
+		{"solr.request_handler.request_time.99percentile", handler, "gauge", 30},
+		{"solr.replication.lag", cloud, "gauge", 30},
+		{"solr.replication.generation_lag", cloud, "gauge", 2},
+		{"solr.searcher.num_docs", []string{"core:techproducts"}, "gauge", 32},
+	} {
+		m, ok := agg.Get(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			value, _ := agg.Value(c.name, c.tags...)
+			assert.Equal(t, c.value, value, c.name)
+			assert.Equal(t, c.metricType, m.Type, c.name)
+		}
+	}
+	_, ok := agg.Get("solr.replication.lag", "core:techproducts")
+	assert.False(t, ok)
+}
@@ -0,0 +1,161 @@
+package squid
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewSquid XXX
+func NewSquid(conf plugin.InitConfig) plugin.Plugin {
+	return &Squid{}
+}
+
+// Squid collects the counters and the general runtime information of Squid
+// from its cache manager interface.
+type Squid struct{}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	// URL is where Squid listens, the cache manager pages are requested
+	// under /squid-internal-mgr/.
+	URL  string   `yaml:"url"`
+	Name string   `yaml:"name"`
+	Tags []string `yaml:"tags"`
+}
+
+// infoMetrics maps the lines of the info page to metric names, the ratios
+// and the median service times are taken over the last 5 minutes.
+var infoMetrics = map[string]string{
+	"Hits as % of all requests":            "squid.cache.request_hit_ratio",
+	"Hits as % of bytes sent":              "squid.cache.byte_hit_ratio",
+	"Memory hits as % of hit requests":     "squid.cache.memory_hit_ratio",
+	"Disk hits as % of hit requests":       "squid.cache.disk_hit_ratio",
+	"HTTP Requests (All)":                  "squid.service_time.http_requests",
+	"Cache Misses":                         "squid.service_time.cache_misses",
+	"Cache Hits":                           "squid.service_time.cache_hits",
+	"DNS Lookups":                          "squid.service_time.dns_lookups",
+	"Number of clients accessing cache":    "squid.clients",
+	"Maximum number of file descriptors":   "squid.fd.max",
+	"Number of file desc currently in use": "squid.fd.used",
+	"Available number of file descriptors": "squid.fd.available",
+	"Reserved number of file descriptors":  "squid.fd.reserved",
+}
+
+var numberPattern = regexp.MustCompile(`[0-9]+(\.[0-9]+)?`)
+
+// Check XXX
+func (s *Squid) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.URL == "" {
+		conf.URL = "http://localhost:3128"
+	}
+	conf.URL = strings.TrimSuffix(conf.URL, "/")
+	if conf.Name == "" {
+		conf.Name = "squid"
+	}
+	tags := append(append([]string{}, conf.Tags...), "name:"+conf.Name)
+
+	counters, err := s.fetch(&conf, "counters")
+	if err != nil {
+		return err
+	}
+	for key, value := range parseCounters(counters) {
+		agg.Add("rate", metric.NewMetric("squid.cachemgr."+key, value, tags))
+	}
+
+	info, err := s.fetch(&conf, "info")
+	if err != nil {
+		return err
+	}
+	for key, value := range parseInfo(info) {
+		agg.Add("gauge", metric.NewMetric(infoMetrics[key], value, tags))
+	}
+
+	return nil
+}
+
+func (s *Squid) fetch(conf *instanceConfig, page string) ([]byte, error) {
+	resp, err := conf.Get(conf.URL + "/squid-internal-mgr/" + page)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// parseCounters parses the counters page, which looks like:
+//
+//	sample_time = 1488453238.123456 (Thu, 02 Mar 2017 11:13:58 GMT)
+//	client_http.requests = 4902
+//	client_http.hits = 1290
+func parseCounters(body []byte) map[string]float64 {
+	counters := make(map[string]float64)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		if key == "sample_time" {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			continue
+		}
+		counters[key] = value
+	}
+	return counters
+}
+
+// parseInfo picks the lines of the info page listed in infoMetrics, they
+// look like:
+//
+//	Hits as % of all requests:	5min: 26.3%, 60min: 24.1%
+//	DNS Lookups:           0.04237  0.04237
+//	Maximum number of file descriptors:   1024
+//
+// and the first number of the line is kept.
+func parseInfo(body []byte) map[string]float64 {
+	info := make(map[string]float64)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key := line[:i]
+		if _, ok := infoMetrics[key]; !ok {
+			continue
+		}
+		rest := strings.TrimPrefix(strings.TrimSpace(line[i+1:]), "5min:")
+		number := numberPattern.FindString(rest)
+		if number == "" {
+			continue
+		}
+		if value, err := strconv.ParseFloat(number, 64); err == nil {
+			info[key] = value
+		}
+	}
+	return info
+}
+
+func init() {
+	collector.Add("squid", NewSquid)
+}
@@ -0,0 +1,68 @@
+package squid
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/squid-internal-mgr/") {
+			http.NotFound(w, r)
+			return
+		}
+		content, err := ioutil.ReadFile("testdata/" + strings.TrimPrefix(r.URL.Path, "/squid-internal-mgr/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	s := NewSquid(nil)
+	agg := &metric.MockAggregator{}
+	require.NoError(t, s.Check(agg, plugin.Instance{"url": server.URL}))
+
+	for _, c := range []struct {
+		name       string
+		metricType string
+		value      float64
+	}{
+		{"squid.cachemgr.client_http.requests", "rate", 4902},
+		{"squid.cachemgr.client_http.hits", "rate", 1290},
+		{"squid.cachemgr.cpu_time", "rate", 12.345678},
+		{"squid.cache.request_hit_ratio", "gauge", 26.3},
+		{"squid.cache.byte_hit_ratio", "gauge", 8.5},
+		{"squid.service_time.dns_lookups", "gauge", 0.04237},
+		{"squid.service_time.cache_hits", "gauge", 0},
+		{"squid.clients", "gauge", 12},
+		{"squid.fd.max", "gauge", 1024},
+		{"squid.fd.used", "gauge", 15},
+		{"squid.fd.available", "gauge", 1009},
+	} {
+		m, ok := agg.Get(c.name, "name:squid")
+		if assert.True(t, ok, "metric %s not found", c.name) {
+			value, _ := agg.Value(c.name)
+			assert.Equal(t, c.value, value, c.name)
+			assert.Equal(t, c.metricType, m.Type, c.name)
+		}
+	}
+	assert.NotContains(t, agg.Names(), "squid.cachemgr.sample_time")
+}
+
+func TestCheckUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	s := NewSquid(nil)
+	assert.Error(t, s.Check(&metric.MockAggregator{}, plugin.Instance{"url": server.URL}))
+}
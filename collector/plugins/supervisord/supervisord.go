@@ -0,0 +1,211 @@
+package supervisord
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// states maps the states of the processes to the service check statuses,
+// only RUNNING is OK and the ones in transition are WARNING. The other
+// statenames are UNKNOWN.
+var states = map[string]int{
+	"STOPPED":  metric.ServiceCheckCritical,
+	"STARTING": metric.ServiceCheckWarning,
+	"RUNNING":  metric.ServiceCheckOK,
+	"BACKOFF":  metric.ServiceCheckCritical,
+	"STOPPING": metric.ServiceCheckWarning,
+	"EXITED":   metric.ServiceCheckCritical,
+	"FATAL":    metric.ServiceCheckCritical,
+	"UNKNOWN":  metric.ServiceCheckCritical,
+}
+
+// NewSupervisord XXX
+func NewSupervisord(conf plugin.InitConfig) plugin.Plugin {
+	return &Supervisord{}
+}
+
+// Supervisord reports the state and the uptime of the processes managed by
+// supervisord from its XML-RPC interface.
+type Supervisord struct{}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	// Name is reported as the supervisord_server tag.
+	Name string `yaml:"name"`
+	// URL is the XML-RPC endpoint of the inet_http_server, Socket is the
+	// path of the unix_http_server.
+	URL    string `yaml:"url"`
+	Socket string `yaml:"socket"`
+	// ProcNames and ProcRegex select the processes, all of them by default.
+	ProcNames []string `yaml:"proc_names"`
+	ProcRegex []string `yaml:"proc_regex"`
+	Tags      []string `yaml:"tags"`
+}
+
+// Check XXX
+func (s *Supervisord) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.URL == "" && conf.Socket == "" {
+		conf.URL = "http://localhost:9001/RPC2"
+	}
+	if conf.Name == "" {
+		conf.Name = "supervisord"
+	}
+	var regexes []*regexp.Regexp
+	for _, r := range conf.ProcRegex {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return fmt.Errorf("invalid proc_regex %q: %s", r, err)
+		}
+		regexes = append(regexes, re)
+	}
+
+	processes, err := conf.getAllProcessInfo()
+	if err != nil {
+		return err
+	}
+
+	tags := append(append([]string{}, conf.Tags...), "supervisord_server:"+conf.Name)
+	// Every known state is counted, even without process, and so are the
+	// unexpected ones.
+	counts := make(map[string]int, len(states))
+	for state := range states {
+		counts[strings.ToLower(state)] = 0
+	}
+	for _, p := range processes {
+		// The processes of a group are named group:name.
+		name := p["name"]
+		if p["group"] != "" && p["group"] != name {
+			name = p["group"] + ":" + name
+		}
+		if !conf.wants(name, regexes) {
+			continue
+		}
+
+		state := p["statename"]
+		status, ok := states[state]
+		if !ok {
+			status = metric.ServiceCheckUnknown
+		}
+		counts[strings.ToLower(state)]++
+
+		procTags := append(append([]string{}, tags...), "supervisord_process:"+name)
+		agg.Add("gauge", metric.NewMetric("supervisord.process.status", status, procTags))
+		sc := metric.NewServiceCheck("supervisord.process.status", status, procTags)
+		sc.Message = state
+		if p["description"] != "" {
+			sc.Message += ": " + p["description"]
+		}
+		agg.AddServiceCheck(sc)
+
+		uptime := 0
+		if state == "RUNNING" {
+			start, _ := strconv.Atoi(p["start"])
+			now, _ := strconv.Atoi(p["now"])
+			if start > 0 && now > start {
+				uptime = now - start
+			}
+		}
+		agg.Add("gauge", metric.NewMetric("supervisord.process.uptime", uptime, procTags))
+	}
+
+	for state, count := range counts {
+		stateTags := append(append([]string{}, tags...), "status:"+state)
+		agg.Add("gauge", metric.NewMetric("supervisord.process.count", count, stateTags))
+	}
+	return nil
+}
+
+func (conf *instanceConfig) wants(name string, regexes []*regexp.Regexp) bool {
+	if len(conf.ProcNames) == 0 && len(regexes) == 0 {
+		return true
+	}
+	for _, n := range conf.ProcNames {
+		if n == name {
+			return true
+		}
+	}
+	for _, re := range regexes {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (conf *instanceConfig) getAllProcessInfo() ([]map[string]string, error) {
+	url := conf.URL
+	if conf.Socket != "" {
+		// The host is ignored, the requests are sent to the socket.
+		url = "http://localhost/RPC2"
+	}
+	req, err := conf.NewRequest("POST", url, bytes.NewReader(newCall("supervisor.getAllProcessInfo")))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/xml")
+
+	var resp *http.Response
+	if conf.Socket != "" {
+		resp, err = conf.doUnix(req)
+	} else {
+		resp, err = conf.Do(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseResponse(content)
+}
+
+func (conf *instanceConfig) doUnix(req *http.Request) (*http.Response, error) {
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = plugin.DefaultHTTPTimeout
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.Dial("unix", conf.Socket)
+			},
+			DisableKeepAlives: true,
+		},
+		Timeout: time.Duration(timeout) * time.Second,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%s returned HTTP status %d", conf.Socket, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func init() {
+	collector.Add("supervisord", NewSupervisord)
+}
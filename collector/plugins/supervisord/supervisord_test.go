@@ -0,0 +1,129 @@
+package supervisord
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newHandler(t *testing.T) http.Handler {
+	response, err := ioutil.ReadFile("testdata/getAllProcessInfo.xml")
+	require.NoError(t, err)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Contains(t, string(body), "<methodName>supervisor.getAllProcessInfo</methodName>")
+		user, pass, _ := r.BasicAuth()
+		assert.Equal(t, "user", user)
+		assert.Equal(t, "123", pass)
+		_, _ = w.Write(response)
+	})
+}
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(newHandler(t))
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewSupervisord(nil).Check(agg, plugin.Instance{
+		"name":     "server0",
+		"url":      server.URL + "/RPC2",
+		"username": "user",
+		"password": "123",
+	}))
+
+	for _, c := range []struct {
+		name    string
+		process string
+		value   float64
+	}{
+		{"supervisord.process.status", "web", metric.ServiceCheckOK},
+		{"supervisord.process.uptime", "web", 3600},
+		{"supervisord.process.status", "worker:worker_00", metric.ServiceCheckOK},
+		{"supervisord.process.uptime", "worker:worker_00", 1800},
+		{"supervisord.process.status", "worker:worker_01", metric.ServiceCheckCritical},
+		{"supervisord.process.uptime", "worker:worker_01", 0},
+		{"supervisord.process.status", "cron", metric.ServiceCheckCritical},
+		{"supervisord.process.uptime", "cron", 0},
+		{"supervisord.process.status", "queue", metric.ServiceCheckWarning},
+		{"supervisord.process.status", "legacy", metric.ServiceCheckUnknown},
+	} {
+		value, ok := agg.Value(c.name, "supervisord_server:server0", "supervisord_process:"+c.process)
+		if assert.True(t, ok, "metric %s of %s not found", c.name, c.process) {
+			assert.Equal(t, c.value, value, c.name+" "+c.process)
+		}
+	}
+	for state, count := range map[string]float64{"running": 2, "fatal": 1, "stopped": 1, "starting": 1, "backoff": 0,
+		"suspended": 1} {
+		value, ok := agg.Value("supervisord.process.count", "status:"+state)
+		if assert.True(t, ok, state) {
+			assert.Equal(t, count, value, state)
+		}
+	}
+
+	for _, c := range []struct {
+		process string
+		status  int
+		message string
+	}{
+		{"web", metric.ServiceCheckOK, "RUNNING: pid 1234, uptime 1:00:00"},
+		{"worker:worker_01", metric.ServiceCheckCritical, "FATAL: Exited too quickly (process log may have details)"},
+		{"cron", metric.ServiceCheckCritical, "STOPPED: Not started"},
+		{"queue", metric.ServiceCheckWarning, "STARTING"},
+		{"legacy", metric.ServiceCheckUnknown, "SUSPENDED: pid 0"},
+	} {
+		sc, ok := agg.GetServiceCheck("supervisord.process.status", "supervisord_server:server0",
+			"supervisord_process:"+c.process)
+		if assert.True(t, ok, "service check of %s not found", c.process) {
+			assert.Equal(t, c.status, sc.Status, c.process)
+			assert.Equal(t, c.message, sc.Message, c.process)
+		}
+	}
+}
+
+func TestCheckSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "supervisord")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	socket := filepath.Join(dir, "supervisor.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := &httptest.Server{Listener: listener, Config: &http.Server{Handler: newHandler(t)}}
+	server.Start()
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewSupervisord(nil).Check(agg, plugin.Instance{
+		"socket":     socket,
+		"username":   "user",
+		"password":   "123",
+		"proc_names": []interface{}{"web"},
+		"proc_regex": []interface{}{"^worker:.*_01$"},
+	}))
+	_, ok := agg.Get("supervisord.process.status", "supervisord_server:supervisord", "supervisord_process:web")
+	assert.True(t, ok)
+	_, ok = agg.Get("supervisord.process.status", "supervisord_process:worker:worker_01")
+	assert.True(t, ok)
+	_, ok = agg.Get("supervisord.process.status", "supervisord_process:cron")
+	assert.False(t, ok)
+}
+
+func TestParseResponseFault(t *testing.T) {
+	_, err := parseResponse([]byte(`<?xml version="1.0"?><methodResponse><fault><value><struct>
+		<member><name>faultCode</name><value><int>1</int></value></member>
+		<member><name>faultString</name><value><string>UNKNOWN_METHOD</string></value></member>
+		</struct></value></fault></methodResponse>`))
+	assert.EqualError(t, err, "XML-RPC fault 1: UNKNOWN_METHOD")
+}
@@ -0,0 +1,74 @@
+package supervisord
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// The subset of XML-RPC used by supervisord: a call without parameters
+// whose response is an array of structs of ints and strings.
+
+type xmlValue struct {
+	Int     *string     `xml:"int"`
+	I4      *string     `xml:"i4"`
+	Boolean *string     `xml:"boolean"`
+	String  *string     `xml:"string"`
+	Array   []xmlValue  `xml:"array>data>value"`
+	Members []xmlMember `xml:"struct>member"`
+	// Text is the content of a value without type, which is a string.
+	Text string `xml:",chardata"`
+}
+
+type xmlMember struct {
+	Name  string   `xml:"name"`
+	Value xmlValue `xml:"value"`
+}
+
+type xmlResponse struct {
+	Params []xmlValue `xml:"params>param>value"`
+	Fault  *xmlValue  `xml:"fault>value"`
+}
+
+func newCall(method string) []byte {
+	return []byte(xml.Header + "<methodCall><methodName>" + method + "</methodName><params/></methodCall>")
+}
+
+// parseResponse decodes a response holding an array of structs, the members
+// are returned as strings.
+func parseResponse(content []byte) ([]map[string]string, error) {
+	var resp xmlResponse
+	if err := xml.Unmarshal(content, &resp); err != nil {
+		return nil, fmt.Errorf("invalid XML-RPC response: %s", err)
+	}
+	if resp.Fault != nil {
+		fault := resp.Fault.toMap()
+		return nil, fmt.Errorf("XML-RPC fault %s: %s", fault["faultCode"], fault["faultString"])
+	}
+	if len(resp.Params) != 1 {
+		return nil, fmt.Errorf("invalid XML-RPC response: %d params", len(resp.Params))
+	}
+
+	var result []map[string]string
+	for _, v := range resp.Params[0].Array {
+		result = append(result, v.toMap())
+	}
+	return result, nil
+}
+
+func (v *xmlValue) toMap() map[string]string {
+	m := make(map[string]string, len(v.Members))
+	for _, member := range v.Members {
+		m[member.Name] = member.Value.text()
+	}
+	return m
+}
+
+func (v *xmlValue) text() string {
+	for _, s := range []*string{v.Int, v.I4, v.Boolean, v.String} {
+		if s != nil {
+			return *s
+		}
+	}
+	return strings.TrimSpace(v.Text)
+}
@@ -0,0 +1,132 @@
+package tcpcheck
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// DefaultTimeout is the default timeout in seconds of the connection and of
+// the handshake.
+const DefaultTimeout = 10
+
+// NewTCPCheck XXX
+func NewTCPCheck(conf plugin.InitConfig) plugin.Plugin {
+	return &TCPCheck{}
+}
+
+// TCPCheck reports whether a TCP port accepts connections and the time it
+// takes to connect.
+type TCPCheck struct{}
+
+type instanceConfig struct {
+	Name    string `yaml:"name"`
+	Host    string `yaml:"host"`
+	Port    int    `yaml:"port"`
+	Timeout int    `yaml:"timeout"`
+	// Send is written once connected, Expect is a regular expression the
+	// first line of the reply must match.
+	Send   string   `yaml:"send"`
+	Expect string   `yaml:"expect"`
+	Tags   []string `yaml:"tags"`
+}
+
+// Check XXX
+func (c *TCPCheck) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.Host == "" || conf.Port <= 0 {
+		return fmt.Errorf("a configured host and port are required")
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = DefaultTimeout
+	}
+
+	var expect *regexp.Regexp
+	if conf.Expect != "" {
+		var err error
+		if expect, err = regexp.Compile(conf.Expect); err != nil {
+			return fmt.Errorf("invalid expect pattern %q: %s", conf.Expect, err)
+		}
+	}
+
+	addr := net.JoinHostPort(conf.Host, strconv.Itoa(conf.Port))
+	if conf.Name == "" {
+		conf.Name = addr
+	}
+	tags := append(append([]string{}, conf.Tags...),
+		"target_host:"+conf.Host,
+		"port:"+strconv.Itoa(conf.Port),
+		"instance:"+conf.Name,
+	)
+
+	timeout := time.Duration(conf.Timeout) * time.Second
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		metric.Logger(agg).Infof("%s is down: %s", addr, err)
+		agg.Add("gauge", metric.NewMetric("network.tcp.can_connect", 0, tags))
+		addServiceCheck(agg, metric.ServiceCheckCritical, tags, err.Error())
+		return nil
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	agg.Add("gauge", metric.NewMetric("network.tcp.response_time", time.Since(start).Seconds(), tags))
+
+	if conf.Send != "" || expect != nil {
+		if err = handshake(conn, timeout, conf.Send, expect); err != nil {
+			metric.Logger(agg).Infof("%s is down: %s", addr, err)
+			agg.Add("gauge", metric.NewMetric("network.tcp.can_connect", 0, tags))
+			addServiceCheck(agg, metric.ServiceCheckCritical, tags, err.Error())
+			return nil
+		}
+	}
+	agg.Add("gauge", metric.NewMetric("network.tcp.can_connect", 1, tags))
+	addServiceCheck(agg, metric.ServiceCheckOK, tags, "")
+
+	return nil
+}
+
+func addServiceCheck(agg metric.Aggregator, status int, tags []string, message string) {
+	sc := metric.NewServiceCheck("tcp.can_connect", status, tags)
+	sc.Message = message
+	agg.AddServiceCheck(sc)
+}
+
+func handshake(conn net.Conn, timeout time.Duration, send string, expect *regexp.Regexp) error {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	if send != "" {
+		if _, err := conn.Write([]byte(send)); err != nil {
+			return fmt.Errorf("could not send the payload: %s", err)
+		}
+	}
+	if expect == nil {
+		return nil
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("could not read the reply: %s", err)
+	}
+	if !expect.MatchString(line) {
+		return fmt.Errorf("unexpected reply %q", line)
+	}
+	return nil
+}
+
+func init() {
+	collector.Add("tcp_check", NewTCPCheck)
+}
@@ -0,0 +1,103 @@
+package tcpcheck
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listen starts a server replying PONG to PING and ERR to anything else.
+func listen(t *testing.T) (net.Listener, int) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				if line == "PING\r\n" {
+					_, _ = conn.Write([]byte("+PONG\r\n"))
+				} else {
+					_, _ = conn.Write([]byte("-ERR\r\n"))
+				}
+			}()
+		}
+	}()
+
+	return l, l.Addr().(*net.TCPAddr).Port
+}
+
+func TestCheck(t *testing.T) {
+	l, port := listen(t)
+	defer l.Close()
+
+	for _, c := range []struct {
+		name     string
+		instance plugin.Instance
+		up       float64
+	}{
+		{"connect", plugin.Instance{}, 1},
+		{"handshake", plugin.Instance{"send": "PING\r\n", "expect": "^\\+PONG"}, 1},
+		{"bad handshake", plugin.Instance{"send": "QUIT\r\n", "expect": "^\\+PONG"}, 0},
+	} {
+		c.instance["host"] = "127.0.0.1"
+		c.instance["port"] = port
+		agg := &metric.MockAggregator{}
+		require.NoError(t, NewTCPCheck(nil).Check(agg, c.instance), c.name)
+
+		value, ok := agg.Value("network.tcp.can_connect", "port:"+strconv.Itoa(port), "target_host:127.0.0.1")
+		assert.True(t, ok, c.name)
+		assert.Equal(t, c.up, value, c.name)
+		_, ok = agg.Value("network.tcp.response_time")
+		assert.True(t, ok, c.name)
+
+		sc, ok := agg.GetServiceCheck("tcp.can_connect", "port:"+strconv.Itoa(port))
+		if assert.True(t, ok, c.name) {
+			if c.up == 1 {
+				assert.Equal(t, metric.ServiceCheckOK, sc.Status, c.name)
+				assert.Empty(t, sc.Message, c.name)
+			} else {
+				assert.Equal(t, metric.ServiceCheckCritical, sc.Status, c.name)
+				assert.NotEmpty(t, sc.Message, c.name)
+			}
+		}
+	}
+}
+
+func TestCheckDown(t *testing.T) {
+	l, port := listen(t)
+	l.Close()
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewTCPCheck(nil).Check(agg, plugin.Instance{"host": "127.0.0.1", "port": port, "name": "db"}))
+	value, ok := agg.Value("network.tcp.can_connect", "instance:db")
+	assert.True(t, ok)
+	assert.Equal(t, float64(0), value)
+	assert.NotContains(t, agg.Names(), "network.tcp.response_time")
+	sc, ok := agg.GetServiceCheck("tcp.can_connect", "instance:db")
+	if assert.True(t, ok) {
+		assert.Equal(t, metric.ServiceCheckCritical, sc.Status)
+		assert.Contains(t, sc.Message, "connection refused")
+	}
+}
+
+func TestBadConfig(t *testing.T) {
+	for _, instance := range []plugin.Instance{
+		{},
+		{"host": "localhost"},
+		{"host": "localhost", "port": 80, "expect": "("},
+	} {
+		assert.Error(t, NewTCPCheck(nil).Check(&metric.MockAggregator{}, instance))
+	}
+}
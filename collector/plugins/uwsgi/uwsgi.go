@@ -0,0 +1,143 @@
+package uwsgi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// NewUWSGI XXX
+func NewUWSGI(conf plugin.InitConfig) plugin.Plugin {
+	return &UWSGI{}
+}
+
+// UWSGI reports the workers of a uWSGI server from its stats server, enabled
+// with the --stats option.
+type UWSGI struct{}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	// Stats is the address of the stats server: host:port, the path of a
+	// unix socket, or a http:// URL with --stats-http.
+	Stats string   `yaml:"stats"`
+	Tags  []string `yaml:"tags"`
+}
+
+type stats struct {
+	ListenQueue       float64 `json:"listen_queue"`
+	ListenQueueErrors float64 `json:"listen_queue_errors"`
+	Load              float64 `json:"load"`
+	Workers           []struct {
+		ID            int     `json:"id"`
+		Status        string  `json:"status"`
+		Requests      float64 `json:"requests"`
+		Exceptions    float64 `json:"exceptions"`
+		HarakiriCount float64 `json:"harakiri_count"`
+		RSS           float64 `json:"rss"`
+		VSZ           float64 `json:"vsz"`
+		// AvgRT is the average response time in microseconds.
+		AvgRT float64 `json:"avg_rt"`
+		TX    float64 `json:"tx"`
+	} `json:"workers"`
+}
+
+// workerStates are always reported, the workers are "sig" or "pause" too,
+// e.g. while handling a signal.
+var workerStates = []string{"idle", "busy", "cheap"}
+
+// Check XXX
+func (u *UWSGI) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.Stats == "" {
+		return fmt.Errorf("a configured stats address is required")
+	}
+
+	s, err := conf.fetch()
+	if err != nil {
+		return err
+	}
+
+	tags := append(append([]string{}, conf.Tags...), "uwsgi_stats:"+conf.Stats)
+	agg.Add("gauge", metric.NewMetric("uwsgi.listen_queue", s.ListenQueue, tags))
+	agg.Add("rate", metric.NewMetric("uwsgi.listen_queue_errors", s.ListenQueueErrors, tags))
+	agg.Add("gauge", metric.NewMetric("uwsgi.load", s.Load, tags))
+
+	states := make(map[string]int)
+	var requests, exceptions, harakiri float64
+	for _, w := range s.Workers {
+		states[w.Status]++
+		requests += w.Requests
+		exceptions += w.Exceptions
+		harakiri += w.HarakiriCount
+
+		workerTags := append(append([]string{}, tags...), "worker:"+strconv.Itoa(w.ID))
+		agg.Add("rate", metric.NewMetric("uwsgi.worker.requests", w.Requests, workerTags))
+		agg.Add("rate", metric.NewMetric("uwsgi.worker.exceptions", w.Exceptions, workerTags))
+		agg.Add("rate", metric.NewMetric("uwsgi.worker.harakiri", w.HarakiriCount, workerTags))
+		agg.Add("rate", metric.NewMetric("uwsgi.worker.tx", w.TX, workerTags))
+		agg.Add("gauge", metric.NewMetric("uwsgi.worker.rss", w.RSS, workerTags))
+		agg.Add("gauge", metric.NewMetric("uwsgi.worker.vsz", w.VSZ, workerTags))
+		agg.Add("gauge", metric.NewMetric("uwsgi.worker.avg_rt", w.AvgRT/1e6, workerTags))
+	}
+	agg.Add("rate", metric.NewMetric("uwsgi.requests", requests, tags))
+	agg.Add("rate", metric.NewMetric("uwsgi.exceptions", exceptions, tags))
+	agg.Add("rate", metric.NewMetric("uwsgi.harakiri", harakiri, tags))
+	agg.Add("gauge", metric.NewMetric("uwsgi.harakiri_count", harakiri, tags))
+
+	for _, state := range workerStates {
+		if _, ok := states[state]; !ok {
+			states[state] = 0
+		}
+	}
+	for state, count := range states {
+		stateTags := append(append([]string{}, tags...), "state:"+state)
+		agg.Add("gauge", metric.NewMetric("uwsgi.workers", count, stateTags))
+	}
+	return nil
+}
+
+// fetch reads the stats, the stats server writes them and closes the
+// connection.
+func (conf *instanceConfig) fetch() (*stats, error) {
+	s := &stats{}
+	if strings.HasPrefix(conf.Stats, "http://") || strings.HasPrefix(conf.Stats, "https://") {
+		return s, conf.GetJSON(conf.Stats, s)
+	}
+
+	network := "tcp"
+	if strings.Contains(conf.Stats, "/") {
+		network = "unix"
+	}
+	timeout := time.Duration(conf.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = plugin.DefaultHTTPTimeout * time.Second
+	}
+	conn, err := net.DialTimeout(network, conf.Stats, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the uwsgi stats server %s: %s", conf.Stats, err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if err = json.NewDecoder(conn).Decode(s); err != nil {
+		return nil, fmt.Errorf("could not decode the stats of %s: %s", conf.Stats, err)
+	}
+	return s, nil
+}
+
+func init() {
+	collector.Add("uwsgi", NewUWSGI)
+}
@@ -0,0 +1,94 @@
+package uwsgi
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const statsJSON = `{
+	"version": "2.0.18", "listen_queue": 3, "listen_queue_errors": 1, "load": 2,
+	"workers": [
+		{"id": 1, "pid": 101, "status": "busy", "requests": 1500, "exceptions": 2,
+		 "harakiri_count": 1, "rss": 52428800, "vsz": 209715200, "avg_rt": 25000, "tx": 409600},
+		{"id": 2, "pid": 102, "status": "idle", "requests": 1200, "exceptions": 0,
+		 "harakiri_count": 2, "rss": 50331648, "vsz": 209715200, "avg_rt": 15000, "tx": 307200},
+		{"id": 3, "pid": 0, "status": "cheap", "requests": 0}
+	]
+}`
+
+func checkStats(t *testing.T, agg *metric.MockAggregator, address string) {
+	tag := "uwsgi_stats:" + address
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"uwsgi.listen_queue", nil, 3},
+		{"uwsgi.listen_queue_errors", nil, 1},
+		{"uwsgi.requests", nil, 2700},
+		{"uwsgi.harakiri_count", nil, 3},
+		{"uwsgi.workers", []string{"state:busy"}, 1},
+		{"uwsgi.workers", []string{"state:idle"}, 1},
+		{"uwsgi.workers", []string{"state:cheap"}, 1},
+		{"uwsgi.worker.requests", []string{"worker:1"}, 1500},
+		{"uwsgi.worker.harakiri", []string{"worker:2"}, 2},
+		{"uwsgi.worker.avg_rt", []string{"worker:1"}, 0.025},
+	} {
+		value, ok := agg.Value(c.name, append(c.tags, tag)...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			assert.Equal(t, c.value, value, c.name)
+		}
+	}
+}
+
+func TestCheckSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uwsgi")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	socket := filepath.Join(dir, "stats.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte(statsJSON))
+		_ = conn.Close()
+	}()
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewUWSGI(nil).Check(agg, plugin.Instance{"stats": socket}))
+	checkStats(t, agg, socket)
+}
+
+func TestCheckHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(statsJSON))
+	}))
+	defer server.Close()
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewUWSGI(nil).Check(agg, plugin.Instance{"stats": server.URL}))
+	checkStats(t, agg, server.URL)
+}
+
+func TestCheckInvalidConfig(t *testing.T) {
+	assert.Error(t, NewUWSGI(nil).Check(&metric.MockAggregator{}, plugin.Instance{}))
+}
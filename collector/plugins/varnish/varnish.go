@@ -0,0 +1,211 @@
+package varnish
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// runCommand is replaced in tests.
+var runCommand = util.RunCommand
+
+// NewVarnish XXX
+func NewVarnish(conf plugin.InitConfig) plugin.Plugin {
+	return &Varnish{
+		hits: make(map[string][2]float64),
+	}
+}
+
+// Varnish collects the counters reported by varnishstat.
+type Varnish struct {
+	sync.Mutex
+
+	// hits keeps the last cache_hit and cache_miss counters of every
+	// instance, the hit ratio is computed over the check interval.
+	hits map[string][2]float64
+}
+
+type instanceConfig struct {
+	VarnishStat string `yaml:"varnishstat"`
+	// Name is the varnishd instance name, passed to varnishstat -n.
+	Name string `yaml:"name"`
+	// Metrics and ExcludeMetrics are shell patterns matched against the
+	// counter names, e.g. MAIN.* or VBE.*.happy
+	Metrics        []string `yaml:"metrics"`
+	ExcludeMetrics []string `yaml:"exclude_metrics"`
+	UseSudo        bool     `yaml:"use_sudo"`
+	Timeout        int      `yaml:"timeout"`
+	Tags           []string `yaml:"tags"`
+}
+
+type counter struct {
+	Flag  string  `json:"flag"`
+	Value float64 `json:"value"`
+}
+
+// Check XXX
+func (v *Varnish) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.VarnishStat == "" {
+		conf.VarnishStat = "varnishstat"
+	}
+	for _, pattern := range append(conf.Metrics, conf.ExcludeMetrics...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid metric pattern %q: %s", pattern, err)
+		}
+	}
+
+	counters, err := readCounters(&conf)
+	if err != nil {
+		return err
+	}
+
+	tags := conf.Tags
+	if conf.Name != "" {
+		tags = append(append([]string{}, tags...), "varnish_name:"+conf.Name)
+	}
+
+	for name, c := range counters {
+		if !conf.match(name) {
+			continue
+		}
+		metricType := "gauge"
+		switch c.Flag {
+		case "c", "a":
+			metricType = "rate"
+		case "b":
+			// Bitmaps, e.g. VBE.*.happy, make no sense as numbers.
+			continue
+		}
+		metricName, metricTags := normalize(name, tags)
+		agg.Add(metricType, metric.NewMetric(metricName, c.Value, metricTags))
+	}
+
+	v.submitHitRatio(agg, &conf, counters, tags)
+	return nil
+}
+
+func readCounters(conf *instanceConfig) (map[string]counter, error) {
+	name, args := conf.VarnishStat, []string{"-1", "-j"}
+	if conf.Name != "" {
+		args = append(args, "-n", conf.Name)
+	}
+	if conf.UseSudo {
+		name, args = "sudo", append([]string{conf.VarnishStat}, args...)
+	}
+
+	out, err := runCommand(time.Duration(conf.Timeout)*time.Second, name, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats map[string]json.RawMessage
+	if err = json.Unmarshal(out, &stats); err != nil {
+		return nil, fmt.Errorf("could not decode the output of varnishstat: %s", err)
+	}
+	// Varnish 6.5 and later nest the counters in a counters object.
+	if raw, ok := stats["counters"]; ok {
+		stats = nil
+		if err = json.Unmarshal(raw, &stats); err != nil {
+			return nil, fmt.Errorf("could not decode the output of varnishstat: %s", err)
+		}
+	}
+
+	counters := make(map[string]counter)
+	for name, raw := range stats {
+		var c counter
+		// timestamp, version... are not counters.
+		if json.Unmarshal(raw, &c) != nil || c.Flag == "" {
+			continue
+		}
+		counters[name] = c
+	}
+	return counters, nil
+}
+
+func (conf *instanceConfig) match(name string) bool {
+	for _, pattern := range conf.ExcludeMetrics {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+	}
+
+	if len(conf.Metrics) == 0 {
+		return true
+	}
+	for _, pattern := range conf.Metrics {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize turns the varnishstat counter names into metric names, e.g.
+//
+//	MAIN.cache_hit             varnish.cache_hit
+//	VBE.boot.default.req       varnish.backend.req, backend:default
+//	SMA.s0.g_bytes             varnish.sma.g_bytes, varnish_storage:s0
+func normalize(name string, tags []string) (string, []string) {
+	parts := strings.Split(name, ".")
+	if len(parts) < 2 {
+		return "varnish." + name, tags
+	}
+
+	section, counter := parts[0], parts[len(parts)-1]
+	switch {
+	case section == "MAIN":
+		return "varnish." + strings.Join(parts[1:], "."), tags
+	case section == "VBE" && len(parts) >= 3:
+		// The backends are prefixed with the VCL name in Varnish 4.1 and
+		// later, e.g. VBE.boot.default.req
+		backend := parts[len(parts)-2]
+		return "varnish.backend." + counter, append(append([]string{}, tags...), "backend:"+backend)
+	case len(parts) == 3:
+		id := parts[1]
+		return "varnish." + strings.ToLower(section) + "." + counter,
+			append(append([]string{}, tags...), "varnish_"+strings.ToLower(section)+":"+id)
+	}
+	return "varnish." + strings.ToLower(name), tags
+}
+
+func (v *Varnish) submitHitRatio(agg metric.Aggregator, conf *instanceConfig, counters map[string]counter, tags []string) {
+	hit, ok := counters["MAIN.cache_hit"]
+	if !ok {
+		return
+	}
+	miss, ok := counters["MAIN.cache_miss"]
+	if !ok {
+		return
+	}
+
+	v.Lock()
+	defer v.Unlock()
+	prev, ok := v.hits[conf.Name]
+	v.hits[conf.Name] = [2]float64{hit.Value, miss.Value}
+	if !ok {
+		return
+	}
+
+	hits, misses := hit.Value-prev[0], miss.Value-prev[1]
+	// Nothing was requested or varnishd has been restarted.
+	if hits < 0 || misses < 0 || hits+misses == 0 {
+		return
+	}
+	agg.Add("gauge", metric.NewMetric("varnish.cache_hit_ratio", hits/(hits+misses)*100, tags))
+}
+
+func init() {
+	collector.Add("varnish", NewVarnish)
+}
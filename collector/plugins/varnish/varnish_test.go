@@ -0,0 +1,87 @@
+package varnish
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	output, err := ioutil.ReadFile("testdata/varnishstat.json")
+	require.NoError(t, err)
+
+	var command string
+	runCommand = func(timeout time.Duration, name string, args ...string) ([]byte, error) {
+		command = name + " " + strings.Join(args, " ")
+		return output, nil
+	}
+
+	v := NewVarnish(nil)
+	agg := &metric.MockAggregator{}
+	instance := plugin.Instance{
+		"name":            "cache1",
+		"exclude_metrics": []interface{}{"MAIN.uptime"},
+	}
+	require.NoError(t, v.Check(agg, instance))
+	assert.Equal(t, "varnishstat -1 -j -n cache1", command)
+
+	for _, c := range []struct {
+		name       string
+		tags       []string
+		value      float64
+		metricType string
+	}{
+		{"varnish.cache_hit", []string{"varnish_name:cache1"}, 900, "rate"},
+		{"varnish.backend_fail", nil, 2, "rate"},
+		{"varnish.threads", nil, 200, "gauge"},
+		{"varnish.n_expired", nil, 17, "rate"},
+		{"varnish.sma.g_bytes", []string{"varnish_sma:s0"}, 4096, "gauge"},
+		{"varnish.backend.req", []string{"backend:default"}, 120, "rate"},
+	} {
+		m, ok := agg.Get(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s not found", c.name) {
+			assert.EqualValues(t, c.value, m.Value, c.name)
+			assert.Equal(t, c.metricType, m.Type, c.name)
+		}
+	}
+	assert.NotContains(t, agg.Names(), "varnish.uptime")
+	assert.NotContains(t, agg.Names(), "varnish.backend.happy")
+	assert.NotContains(t, agg.Names(), "varnish.cache_hit_ratio")
+
+	// 90 more hits and 10 more misses.
+	output = []byte(strings.NewReplacer(`"value": 900`, `"value": 990`, `"value": 100}`, `"value": 110}`).
+		Replace(string(output)))
+	agg = &metric.MockAggregator{}
+	require.NoError(t, v.Check(agg, instance))
+	value, ok := agg.Value("varnish.cache_hit_ratio")
+	assert.True(t, ok)
+	assert.InDelta(t, 90, value, 1e-9)
+}
+
+func TestCheckNested(t *testing.T) {
+	runCommand = func(timeout time.Duration, name string, args ...string) ([]byte, error) {
+		return []byte(`{"version": 1, "timestamp": "2021-01-01T00:00:00",
+			"counters": {"MAIN.client_req": {"flag": "c", "value": 5}}}`), nil
+	}
+
+	agg := &metric.MockAggregator{}
+	require.NoError(t, NewVarnish(nil).Check(agg, plugin.Instance{"metrics": []interface{}{"MAIN.*"}}))
+	value, ok := agg.Value("varnish.client_req")
+	assert.True(t, ok)
+	assert.Equal(t, float64(5), value)
+}
+
+func TestCheckErrors(t *testing.T) {
+	runCommand = func(timeout time.Duration, name string, args ...string) ([]byte, error) {
+		return nil, fmt.Errorf("varnishstat failed: exit status 1")
+	}
+	assert.Error(t, NewVarnish(nil).Check(&metric.MockAggregator{}, plugin.Instance{}))
+	assert.Error(t, NewVarnish(nil).Check(&metric.MockAggregator{}, plugin.Instance{"metrics": []interface{}{"["}}))
+}
@@ -0,0 +1,283 @@
+package vsphere
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// client is a minimal client of the vSphere Web Services API (SOAP), it
+// implements the few methods of the vim25 namespace the check needs.
+type client struct {
+	url  string
+	http *http.Client
+	sc   serviceContent
+}
+
+type moRef struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// element returns the reference as the XML element tag.
+func (r moRef) element(tag string) string {
+	return fmt.Sprintf(`<%s type="%s">%s</%s>`, tag, escape(r.Type), escape(r.Value), tag)
+}
+
+type serviceContent struct {
+	RootFolder        moRef `xml:"rootFolder"`
+	PropertyCollector moRef `xml:"propertyCollector"`
+	ViewManager       moRef `xml:"viewManager"`
+	SessionManager    moRef `xml:"sessionManager"`
+	PerfManager       moRef `xml:"perfManager"`
+}
+
+type objectContent struct {
+	Obj     moRef `xml:"obj"`
+	PropSet []struct {
+		Name string        `xml:"name"`
+		Val  propertyValue `xml:"val"`
+	} `xml:"propSet"`
+}
+
+// propertyValue is the value of a property, the managed object references
+// carry their type in the type attribute next to xsi:type.
+type propertyValue struct {
+	Attrs []xml.Attr `xml:",any,attr"`
+	Value string     `xml:",chardata"`
+	Inner []byte     `xml:",innerxml"`
+}
+
+// ref returns the managed object reference held by the value, if any.
+func (v propertyValue) ref() (moRef, bool) {
+	for _, a := range v.Attrs {
+		if a.Name.Local == "type" && a.Name.Space == "" {
+			return moRef{Type: a.Value, Value: v.Value}, true
+		}
+	}
+	return moRef{}, false
+}
+
+type retrieveResult struct {
+	Token   string          `xml:"token"`
+	Objects []objectContent `xml:"objects"`
+}
+
+type perfCounterInfo struct {
+	Key       int32  `xml:"key"`
+	Name      string `xml:"nameInfo>key"`
+	Group     string `xml:"groupInfo>key"`
+	Unit      string `xml:"unitInfo>key"`
+	Rollup    string `xml:"rollupType"`
+	StatsType string `xml:"statsType"`
+}
+
+type perfEntityMetric struct {
+	Entity moRef `xml:"entity"`
+	Values []struct {
+		CounterID int32   `xml:"id>counterId"`
+		Instance  string  `xml:"id>instance"`
+		Value     []int64 `xml:"value"`
+	} `xml:"value"`
+}
+
+func newClient(host string, conf *plugin.HTTPConfig) (*client, error) {
+	httpClient, err := conf.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	// The session is kept in the vmware_soap_session cookie.
+	if httpClient.Jar, err = cookiejar.New(nil); err != nil {
+		return nil, err
+	}
+	url := host
+	if !strings.Contains(url, "://") {
+		url = "https://" + url
+	}
+	return &client{
+		url:  strings.TrimSuffix(url, "/") + "/sdk",
+		http: httpClient,
+	}, nil
+}
+
+// call sends the SOAP request made of body and decodes the response element
+// into v, SOAP faults are returned as errors.
+func (c *client) call(body string, v interface{}) error {
+	envelope := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" ` +
+		`xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">` +
+		`<soapenv:Body>` + body + `</soapenv:Body></soapenv:Envelope>`
+
+	req, err := http.NewRequest("POST", c.url, strings.NewReader(envelope))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", "urn:vim25/6.0")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var response struct {
+		Body struct {
+			Fault *struct {
+				String string `xml:"faultstring"`
+			} `xml:"Fault"`
+			Inner []byte `xml:",innerxml"`
+		} `xml:"Body"`
+	}
+	if err = xml.Unmarshal(content, &response); err != nil {
+		return fmt.Errorf("%s returned HTTP status %d and an invalid response: %s", c.url, resp.StatusCode, err)
+	}
+	if response.Body.Fault != nil {
+		return fmt.Errorf("%s returned a fault: %s", c.url, response.Body.Fault.String)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned HTTP status %d", c.url, resp.StatusCode)
+	}
+	if v == nil {
+		return nil
+	}
+	return xml.Unmarshal(bytes.TrimSpace(response.Body.Inner), v)
+}
+
+func (c *client) login(username, password string) error {
+	var resp struct {
+		Returnval serviceContent `xml:"returnval"`
+	}
+	err := c.call(`<RetrieveServiceContent xmlns="urn:vim25"><_this type="ServiceInstance">ServiceInstance</_this></RetrieveServiceContent>`, &resp)
+	if err != nil {
+		return err
+	}
+	c.sc = resp.Returnval
+
+	return c.call(fmt.Sprintf(`<Login xmlns="urn:vim25">%s<userName>%s</userName><password>%s</password></Login>`,
+		c.sc.SessionManager.element("_this"), escape(username), escape(password)), nil)
+}
+
+func (c *client) logout() error {
+	return c.call(fmt.Sprintf(`<Logout xmlns="urn:vim25">%s</Logout>`, c.sc.SessionManager.element("_this")), nil)
+}
+
+// retrieveInventory returns the managed objects of the inventory of the
+// types of properties, along with the properties listed for their type.
+func (c *client) retrieveInventory(properties map[string][]string) ([]objectContent, error) {
+	var types []string
+	for t := range properties {
+		types = append(types, "<type>"+t+"</type>")
+	}
+	var view struct {
+		Returnval moRef `xml:"returnval"`
+	}
+	err := c.call(fmt.Sprintf(`<CreateContainerView xmlns="urn:vim25">%s%s%s<recursive>true</recursive></CreateContainerView>`,
+		c.sc.ViewManager.element("_this"), c.sc.RootFolder.element("container"), strings.Join(types, "")), &view)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = c.call(fmt.Sprintf(`<DestroyView xmlns="urn:vim25">%s</DestroyView>`, view.Returnval.element("_this")), nil)
+	}()
+
+	var propSet string
+	for t, paths := range properties {
+		propSet += "<propSet><type>" + t + "</type>"
+		for _, p := range paths {
+			propSet += "<pathSet>" + p + "</pathSet>"
+		}
+		propSet += "</propSet>"
+	}
+	objectSet := fmt.Sprintf(`<objectSet>%s<skip>true</skip>`+
+		`<selectSet xsi:type="TraversalSpec"><name>view</name><type>ContainerView</type><path>view</path><skip>false</skip></selectSet></objectSet>`,
+		view.Returnval.element("obj"))
+	return c.retrieveProperties(propSet + objectSet)
+}
+
+// retrieveProperties calls RetrievePropertiesEx with the given spec, and
+// follows the continuation tokens of the large result sets.
+func (c *client) retrieveProperties(spec string) ([]objectContent, error) {
+	var resp struct {
+		Returnval retrieveResult `xml:"returnval"`
+	}
+	err := c.call(fmt.Sprintf(`<RetrievePropertiesEx xmlns="urn:vim25">%s<specSet>%s</specSet><options></options></RetrievePropertiesEx>`,
+		c.sc.PropertyCollector.element("_this"), spec), &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := resp.Returnval.Objects
+	for token := resp.Returnval.Token; token != ""; token = resp.Returnval.Token {
+		resp.Returnval = retrieveResult{}
+		err = c.call(fmt.Sprintf(`<ContinueRetrievePropertiesEx xmlns="urn:vim25">%s<token>%s</token></ContinueRetrievePropertiesEx>`,
+			c.sc.PropertyCollector.element("_this"), escape(token)), &resp)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, resp.Returnval.Objects...)
+	}
+	return objects, nil
+}
+
+func (c *client) perfCounters() ([]perfCounterInfo, error) {
+	objects, err := c.retrieveProperties(fmt.Sprintf(
+		`<propSet><type>PerformanceManager</type><pathSet>perfCounter</pathSet></propSet><objectSet>%s</objectSet>`,
+		c.sc.PerfManager.element("obj")))
+	if err != nil {
+		return nil, err
+	}
+
+	var counters []perfCounterInfo
+	for _, obj := range objects {
+		for _, prop := range obj.PropSet {
+			var infos struct {
+				Counters []perfCounterInfo `xml:"PerfCounterInfo"`
+			}
+			if err = xml.Unmarshal(append(append([]byte("<val>"), prop.Val.Inner...), "</val>"...), &infos); err != nil {
+				return nil, err
+			}
+			counters = append(counters, infos.Counters...)
+		}
+	}
+	return counters, nil
+}
+
+// queryPerf queries the last real-time (20s) sample of the counters of the
+// entities, the instance is "" for the aggregated value of the entity and
+// "*" for all its instances, e.g. its network interfaces.
+func (c *client) queryPerf(entities []moRef, counters map[int32]string) ([]perfEntityMetric, error) {
+	var metricIDs string
+	for id, instance := range counters {
+		metricIDs += fmt.Sprintf("<metricId><counterId>%d</counterId><instance>%s</instance></metricId>", id, escape(instance))
+	}
+	var specs string
+	for _, entity := range entities {
+		specs += fmt.Sprintf("<querySpec>%s<maxSample>1</maxSample>%s<intervalId>20</intervalId></querySpec>",
+			entity.element("entity"), metricIDs)
+	}
+
+	var resp struct {
+		Returnval []perfEntityMetric `xml:"returnval"`
+	}
+	err := c.call(fmt.Sprintf(`<QueryPerf xmlns="urn:vim25">%s%s</QueryPerf>`, c.sc.PerfManager.element("_this"), specs), &resp)
+	return resp.Returnval, err
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
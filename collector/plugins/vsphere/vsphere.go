@@ -0,0 +1,297 @@
+package vsphere
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// BatchSize is the number of entities whose counters are queried at once.
+const BatchSize = 50
+
+// NewVSphere XXX
+func NewVSphere(conf plugin.InitConfig) plugin.Plugin {
+	return &VSphere{}
+}
+
+// VSphere collects the real-time performance counters of the ESXi hosts and
+// of the running virtual machines managed by a vCenter server or an ESXi
+// host.
+type VSphere struct{}
+
+type instanceConfig struct {
+	plugin.HTTPConfig `yaml:",inline"`
+
+	Host string `yaml:"host"`
+	// The include and exclude options are regular expressions matched
+	// against the names of the clusters of the hosts and VMs, and of the
+	// resource pools of the VMs.
+	IncludeClusters      []string `yaml:"include_clusters"`
+	ExcludeClusters      []string `yaml:"exclude_clusters"`
+	IncludeResourcePools []string `yaml:"include_resource_pools"`
+	ExcludeResourcePools []string `yaml:"exclude_resource_pools"`
+	Tags                 []string `yaml:"tags"`
+}
+
+// counterDefs are the counters we collect, named group.name.rollup, with
+// the instance queried: "" for the value of the whole entity and "*" for
+// its instances too, e.g. its datastores or network interfaces.
+var counterDefs = map[string]string{
+	"cpu.usage.average":                   "",
+	"cpu.usagemhz.average":                "",
+	"cpu.ready.summation":                 "",
+	"mem.usage.average":                   "",
+	"mem.active.average":                  "",
+	"mem.consumed.average":                "",
+	"mem.vmmemctl.average":                "",
+	"mem.swapped.average":                 "",
+	"disk.usage.average":                  "",
+	"datastore.totalReadLatency.average":  "*",
+	"datastore.totalWriteLatency.average": "*",
+	"net.received.average":                "*",
+	"net.transmitted.average":             "*",
+	"net.usage.average":                   "",
+}
+
+// entityProperties are the properties of the inventory we need to tag and
+// filter the entities.
+var entityProperties = map[string][]string{
+	"VirtualMachine":         {"name", "runtime.host", "runtime.powerState", "resourcePool"},
+	"HostSystem":             {"name", "parent", "runtime.powerState"},
+	"ClusterComputeResource": {"name"},
+	"ResourcePool":           {"name"},
+}
+
+type entity struct {
+	ref  moRef
+	tags []string
+}
+
+// filter keeps the names matching one of the include patterns (or all of
+// them when there is none) and not matching any of the exclude patterns.
+type filter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// Check XXX
+func (v *VSphere) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if conf.Host == "" || conf.Username == "" {
+		return fmt.Errorf("a configured host and username are required")
+	}
+	clusters, err := newFilter(conf.IncludeClusters, conf.ExcludeClusters)
+	if err != nil {
+		return err
+	}
+	pools, err := newFilter(conf.IncludeResourcePools, conf.ExcludeResourcePools)
+	if err != nil {
+		return err
+	}
+
+	c, err := newClient(conf.Host, &conf.HTTPConfig)
+	if err != nil {
+		return err
+	}
+	if err = c.login(conf.Username, conf.Password); err != nil {
+		return fmt.Errorf("could not log in to %s: %s", conf.Host, err)
+	}
+	defer func() {
+		if err := c.logout(); err != nil {
+			metric.Logger(agg).Warnf("Could not log out of %s: %s", conf.Host, err)
+		}
+	}()
+
+	infos, err := c.perfCounters()
+	if err != nil {
+		return fmt.Errorf("could not list the performance counters: %s", err)
+	}
+	counters := make(map[int32]perfCounterInfo)
+	query := make(map[int32]string)
+	for _, info := range infos {
+		name := fmt.Sprintf("%s.%s.%s", info.Group, info.Name, info.Rollup)
+		if instance, ok := counterDefs[name]; ok {
+			counters[info.Key] = info
+			query[info.Key] = instance
+		}
+	}
+
+	objects, err := c.retrieveInventory(entityProperties)
+	if err != nil {
+		return fmt.Errorf("could not retrieve the inventory: %s", err)
+	}
+	entities := selectEntities(objects, clusters, pools, conf.Tags)
+
+	for start := 0; start < len(entities); start += BatchSize {
+		end := start + BatchSize
+		if end > len(entities) {
+			end = len(entities)
+		}
+		batch := entities[start:end]
+		refs := make([]moRef, len(batch))
+		tags := make(map[moRef][]string)
+		for i, e := range batch {
+			refs[i] = e.ref
+			tags[e.ref] = e.tags
+		}
+
+		results, err := c.queryPerf(refs, query)
+		if err != nil {
+			return fmt.Errorf("could not query the performance counters: %s", err)
+		}
+		for _, result := range results {
+			submit(agg, result, counters, tags[result.Entity])
+		}
+	}
+
+	return nil
+}
+
+// selectEntities returns the powered on hosts and VMs passing the filters,
+// with their tags.
+func selectEntities(objects []objectContent, clusters, pools *filter, tags []string) []entity {
+	names := make(map[moRef]string)
+	props := make(map[moRef]map[string]string)
+	refs := make(map[moRef]map[string]moRef)
+	for _, obj := range objects {
+		props[obj.Obj] = make(map[string]string)
+		refs[obj.Obj] = make(map[string]moRef)
+		for _, p := range obj.PropSet {
+			props[obj.Obj][p.Name] = p.Val.Value
+			if ref, ok := p.Val.ref(); ok {
+				refs[obj.Obj][p.Name] = ref
+			}
+		}
+		names[obj.Obj] = props[obj.Obj]["name"]
+	}
+
+	var entities []entity
+	hostTags := make(map[moRef][]string)
+	hostClusters := make(map[moRef]string)
+	for _, obj := range objects {
+		if obj.Obj.Type != "HostSystem" || props[obj.Obj]["runtime.powerState"] != "poweredOn" {
+			continue
+		}
+		// Standalone hosts belong to a ComputeResource, not to a cluster.
+		cluster := ""
+		if parent := refs[obj.Obj]["parent"]; parent.Type == "ClusterComputeResource" {
+			cluster = names[parent]
+		}
+		if !clusters.match(cluster) {
+			continue
+		}
+
+		t := []string{"vsphere_host:" + names[obj.Obj]}
+		if cluster != "" {
+			t = append(t, "vsphere_cluster:"+cluster)
+		}
+		hostTags[obj.Obj] = t
+		hostClusters[obj.Obj] = cluster
+		entities = append(entities, entity{
+			ref:  obj.Obj,
+			tags: append(append(append([]string{}, tags...), t...), "vsphere_type:host"),
+		})
+	}
+
+	for _, obj := range objects {
+		if obj.Obj.Type != "VirtualMachine" || props[obj.Obj]["runtime.powerState"] != "poweredOn" {
+			continue
+		}
+		host := refs[obj.Obj]["runtime.host"]
+		t, ok := hostTags[host]
+		if !ok {
+			// The host is filtered out or not connected.
+			continue
+		}
+		pool := names[refs[obj.Obj]["resourcePool"]]
+		if !pools.match(pool) {
+			continue
+		}
+
+		vmTags := append(append(append([]string{}, tags...), t...), "vsphere_type:vm", "vm:"+names[obj.Obj])
+		if pool != "" {
+			vmTags = append(vmTags, "vsphere_resource_pool:"+pool)
+		}
+		entities = append(entities, entity{ref: obj.Obj, tags: vmTags})
+	}
+
+	return entities
+}
+
+// submit reports the last sample of the counters of an entity, e.g.
+// vsphere.cpu.ready.summation, the percentages are reported in %.
+func submit(agg metric.Aggregator, result perfEntityMetric, counters map[int32]perfCounterInfo, tags []string) {
+	for _, series := range result.Values {
+		info, ok := counters[series.CounterID]
+		if !ok || len(series.Value) == 0 {
+			continue
+		}
+		value := float64(series.Value[len(series.Value)-1])
+		if value < 0 {
+			// -1 means no value was collected for the interval.
+			continue
+		}
+		if info.Unit == "percent" {
+			value /= 100
+		}
+
+		metricTags := tags
+		if series.Instance != "" {
+			metricTags = append(append([]string{}, tags...), "instance:"+series.Instance)
+		}
+		name := fmt.Sprintf("vsphere.%s.%s.%s", info.Group, info.Name, info.Rollup)
+		agg.Add("gauge", metric.NewMetric(name, value, metricTags))
+
+		// The ready time is the sum in ms over the 20s of the interval.
+		if name == "vsphere.cpu.ready.summation" && series.Instance == "" {
+			agg.Add("gauge", metric.NewMetric("vsphere.cpu.ready.pct", value/20000*100, metricTags))
+		}
+	}
+}
+
+func newFilter(include, exclude []string) (*filter, error) {
+	f := &filter{}
+	for _, pattern := range include {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %s", pattern, err)
+		}
+		f.include = append(f.include, re)
+	}
+	for _, pattern := range exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %s", pattern, err)
+		}
+		f.exclude = append(f.exclude, re)
+	}
+	return f, nil
+}
+
+func (f *filter) match(name string) bool {
+	for _, re := range f.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	collector.Add("vsphere", NewVSphere)
+}
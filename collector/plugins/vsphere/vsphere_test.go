@@ -0,0 +1,148 @@
+package vsphere
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fault = `<soapenv:Fault><faultcode>ServerFaultCode</faultcode>` +
+	`<faultstring>Cannot complete login due to an incorrect user name or password.</faultstring></soapenv:Fault>`
+
+// vCenter fakes the SOAP API of vCenter with the responses of testdata.
+type vCenter struct {
+	t        *testing.T
+	loggedIn bool
+	queried  string
+}
+
+func (v *vCenter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(v.t, err)
+	request := string(body)
+
+	var response string
+	switch {
+	case strings.Contains(request, "<RetrieveServiceContent "):
+		response = v.file("service_content.xml")
+	case strings.Contains(request, "<Login "):
+		if !strings.Contains(request, "<password>s3cr&amp;t</password>") {
+			w.WriteHeader(http.StatusInternalServerError)
+			response = fault
+			break
+		}
+		http.SetCookie(w, &http.Cookie{Name: "vmware_soap_session", Value: "42"})
+		response = `<LoginResponse xmlns="urn:vim25"><returnval><key>42</key></returnval></LoginResponse>`
+	case strings.Contains(request, "<Logout "):
+		v.loggedIn = false
+		response = `<LogoutResponse xmlns="urn:vim25"></LogoutResponse>`
+	default:
+		if cookie, err := r.Cookie("vmware_soap_session"); err != nil || cookie.Value != "42" {
+			w.WriteHeader(http.StatusInternalServerError)
+			response = `<soapenv:Fault><faultstring>The session is not authenticated.</faultstring></soapenv:Fault>`
+			break
+		}
+		v.loggedIn = true
+		switch {
+		case strings.Contains(request, "<CreateContainerView "):
+			response = `<CreateContainerViewResponse xmlns="urn:vim25"><returnval type="ContainerView">session[52]view</returnval></CreateContainerViewResponse>`
+		case strings.Contains(request, "<DestroyView "):
+			response = `<DestroyViewResponse xmlns="urn:vim25"></DestroyViewResponse>`
+		case strings.Contains(request, "<RetrievePropertiesEx ") && strings.Contains(request, "perfCounter"):
+			response = v.file("perf_counters.xml")
+		case strings.Contains(request, "<RetrievePropertiesEx "):
+			response = v.file("inventory.xml")
+		case strings.Contains(request, "<ContinueRetrievePropertiesEx "):
+			response = v.file("inventory_continued.xml")
+		case strings.Contains(request, "<QueryPerf "):
+			v.queried = request
+			response = v.file("query_perf.xml")
+		}
+	}
+
+	w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" ` +
+		`xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` +
+		`<soapenv:Body>` + response + `</soapenv:Body></soapenv:Envelope>`))
+}
+
+func (v *vCenter) file(name string) string {
+	content, err := ioutil.ReadFile("testdata/" + name)
+	require.NoError(v.t, err)
+	return string(content)
+}
+
+func TestCheck(t *testing.T) {
+	vc := &vCenter{t: t}
+	server := httptest.NewTLSServer(vc)
+	defer server.Close()
+
+	vs := NewVSphere(nil)
+	instance := plugin.Instance{
+		"host":                   server.URL,
+		"username":               "monitoring",
+		"password":               "s3cr&t",
+		"disable_ssl_validation": true,
+		"exclude_clusters":       []interface{}{"^staging$"},
+	}
+	agg := &metric.MockAggregator{}
+	require.NoError(t, vs.Check(agg, instance))
+	assert.False(t, vc.loggedIn)
+
+	// The counters and entities are filtered.
+	assert.Contains(t, vc.queried, `<entity type="HostSystem">host-10</entity>`)
+	assert.Contains(t, vc.queried, `<entity type="VirtualMachine">vm-20</entity>`)
+	assert.NotContains(t, vc.queried, "host-11")
+	assert.NotContains(t, vc.queried, "vm-21")
+	assert.NotContains(t, vc.queried, "vm-22")
+	assert.Contains(t, vc.queried, "<metricId><counterId>143</counterId><instance>*</instance></metricId>")
+	assert.NotContains(t, vc.queried, "<counterId>999</counterId>")
+
+	host := []string{"vsphere_type:host", "vsphere_host:esx01.example.com", "vsphere_cluster:production"}
+	vm := []string{"vsphere_type:vm", "vm:web01", "vsphere_host:esx01.example.com", "vsphere_cluster:production", "vsphere_resource_pool:web"}
+	for _, c := range []struct {
+		name  string
+		tags  []string
+		value float64
+	}{
+		{"vsphere.cpu.usage.average", host, 25.12},
+		{"vsphere.net.received.average", append([]string{"instance:vmnic0"}, host...), 1200},
+		{"vsphere.cpu.ready.summation", vm, 400},
+		{"vsphere.cpu.ready.pct", vm, 2},
+		{"vsphere.mem.vmmemctl.average", vm, 1024},
+	} {
+		m, ok := agg.Get(c.name, c.tags...)
+		if assert.True(t, ok, "metric %s %v not found", c.name, c.tags) {
+			value, _ := agg.Value(c.name, c.tags...)
+			assert.Equal(t, c.value, value, c.name)
+			assert.Equal(t, "gauge", m.Type)
+		}
+	}
+	value, _ := agg.Value("vsphere.net.received.average", host...)
+	assert.Equal(t, float64(1500), value)
+	_, ok := agg.Get("vsphere.cpu.usage.average", "vm:web01")
+	assert.False(t, ok)
+	assert.Len(t, agg.Metrics, 6)
+}
+
+func TestCheckLoginFailure(t *testing.T) {
+	server := httptest.NewTLSServer(&vCenter{t: t})
+	defer server.Close()
+
+	vs := NewVSphere(nil)
+	err := vs.Check(&metric.MockAggregator{}, plugin.Instance{
+		"host":                   server.URL,
+		"username":               "monitoring",
+		"password":               "wrong",
+		"disable_ssl_validation": true,
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "incorrect user name or password")
+}
@@ -0,0 +1,18 @@
+package windowsservice
+
+// The states of the services, as reported by the Win32_Service WMI class.
+const (
+	StateRunning = "Running"
+	StateStopped = "Stopped"
+)
+
+type service struct {
+	Name  string
+	State string
+}
+
+// Manager XXX
+type Manager interface {
+	Services() ([]service, error)
+	Start(name string) error
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package windowsservice
+
+import "fmt"
+
+type systemManager struct{}
+
+func (m *systemManager) Services() ([]service, error) {
+	return nil, fmt.Errorf("the windows_service check is only supported on Windows")
+}
+
+func (m *systemManager) Start(name string) error {
+	return fmt.Errorf("the windows_service check is only supported on Windows")
+}
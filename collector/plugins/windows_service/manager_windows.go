@@ -0,0 +1,33 @@
+package windowsservice
+
+import (
+	"time"
+
+	"github.com/StackExchange/wmi"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+type win32Service struct {
+	Name  string
+	State string
+}
+
+type systemManager struct{}
+
+func (m *systemManager) Services() ([]service, error) {
+	var dst []win32Service
+	if err := wmi.Query("SELECT Name, State FROM Win32_Service", &dst); err != nil {
+		return nil, err
+	}
+
+	services := make([]service, 0, len(dst))
+	for _, s := range dst {
+		services = append(services, service{Name: s.Name, State: s.State})
+	}
+	return services, nil
+}
+
+func (m *systemManager) Start(name string) error {
+	_, err := util.RunCommand(30*time.Second, "sc.exe", "start", name)
+	return err
+}
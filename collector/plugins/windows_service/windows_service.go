@@ -0,0 +1,194 @@
+package windowsservice
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+)
+
+// The defaults of the crash loop detection: a service restarted
+// DefaultMaxRestarts times within DefaultRestartWindow seconds isn't
+// restarted anymore.
+const (
+	DefaultMaxRestarts   = 3
+	DefaultRestartWindow = 600
+)
+
+// NewWindowsService XXX
+func NewWindowsService(conf plugin.InitConfig) plugin.Plugin {
+	return &WindowsService{
+		manager:  &systemManager{},
+		restarts: make(map[string][]time.Time),
+	}
+}
+
+// WindowsService reports the state of the Windows services and optionally
+// restarts the stopped ones.
+type WindowsService struct {
+	sync.Mutex
+
+	manager Manager
+	// restarts keeps the times the services were restarted by the check,
+	// they're used to detect crash loops.
+	restarts map[string][]time.Time
+}
+
+type instanceConfig struct {
+	// Services are the names of the services, or wildcard patterns such as
+	// "sql*", matched case-insensitively.
+	Services []string `yaml:"services"`
+	// Restart starts the monitored services found stopped.
+	Restart       bool     `yaml:"restart"`
+	MaxRestarts   int      `yaml:"max_restarts"`
+	RestartWindow int      `yaml:"restart_window"`
+	Tags          []string `yaml:"tags"`
+}
+
+// Check XXX
+func (w *WindowsService) Check(agg metric.Aggregator, instance plugin.Instance) error {
+	var conf instanceConfig
+	if err := instance.Unmarshal(&conf); err != nil {
+		return err
+	}
+	if len(conf.Services) == 0 {
+		return fmt.Errorf("at least one service is required")
+	}
+	for _, pattern := range conf.Services {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid service pattern %q: %s", pattern, err)
+		}
+	}
+	if conf.MaxRestarts <= 0 {
+		conf.MaxRestarts = DefaultMaxRestarts
+	}
+	if conf.RestartWindow <= 0 {
+		conf.RestartWindow = DefaultRestartWindow
+	}
+
+	services, err := w.manager.Services()
+	if err != nil {
+		return err
+	}
+
+	matched := make(map[string]bool)
+	for _, s := range services {
+		pattern, ok := match(conf.Services, s.Name)
+		if !ok {
+			continue
+		}
+		matched[pattern] = true
+
+		tags := append(append([]string{}, conf.Tags...), "service:"+strings.ToLower(s.Name))
+		up, status := 0, metric.ServiceCheckWarning
+		message := fmt.Sprintf("Service %s is %s", s.Name, strings.ToLower(s.State))
+		crashLoop := false
+		switch s.State {
+		case StateRunning:
+			up, status = 1, metric.ServiceCheckOK
+		case StateStopped:
+			status = metric.ServiceCheckCritical
+			if conf.Restart {
+				var outcome string
+				crashLoop, outcome = w.restart(agg, &conf, s.Name, tags)
+				message += ", " + outcome
+			}
+		}
+		agg.Add("gauge", metric.NewMetric("windows_service.up", up, tags))
+		agg.Add("gauge", metric.NewMetric("windows_service.status", status, tags))
+		addServiceCheck(agg, "windows_service.state", status, tags, message)
+
+		if conf.Restart {
+			if crashLoop {
+				addServiceCheck(agg, "windows_service.crash_loop", metric.ServiceCheckCritical, tags, message)
+			} else {
+				addServiceCheck(agg, "windows_service.crash_loop", metric.ServiceCheckOK, tags, "")
+			}
+		}
+	}
+
+	// The services named without wildcards are expected to exist.
+	for _, pattern := range conf.Services {
+		if matched[pattern] || strings.ContainsAny(pattern, "*?[") {
+			continue
+		}
+		metric.Logger(agg).Warnf("Windows service %s not found", pattern)
+		tags := append(append([]string{}, conf.Tags...), "service:"+strings.ToLower(pattern))
+		agg.Add("gauge", metric.NewMetric("windows_service.up", 0, tags))
+		agg.Add("gauge", metric.NewMetric("windows_service.status", metric.ServiceCheckCritical, tags))
+		addServiceCheck(agg, "windows_service.state", metric.ServiceCheckCritical, tags,
+			fmt.Sprintf("Service %s not found", pattern))
+	}
+	return nil
+}
+
+func addServiceCheck(agg metric.Aggregator, name string, status int, tags []string, message string) {
+	sc := metric.NewServiceCheck(name, status, tags)
+	sc.Message = message
+	agg.AddServiceCheck(sc)
+}
+
+// match returns the first pattern matching the service name.
+func match(patterns []string, name string) (string, bool) {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(strings.ToLower(pattern), name); ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// restart starts the stopped service unless it was already restarted
+// MaxRestarts times within the RestartWindow, it's then considered to be in
+// a crash loop and left alone until the window passes. It returns whether the
+// service is crash looping and what was done.
+func (w *WindowsService) restart(agg metric.Aggregator, conf *instanceConfig, name string, tags []string) (bool, string) {
+	w.Lock()
+	defer w.Unlock()
+
+	now := time.Now()
+	window := time.Duration(conf.RestartWindow) * time.Second
+	var recent []time.Time
+	for _, t := range w.restarts[name] {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+
+	crashLoop := len(recent) >= conf.MaxRestarts
+	var outcome string
+	if crashLoop {
+		outcome = fmt.Sprintf("restarted %d times in %s, it's crash looping and won't be restarted", len(recent), window)
+		metric.Logger(agg).Errorf("Windows service %s was %s", name, outcome)
+	} else {
+		metric.Logger(agg).Warnf("Windows service %s is stopped, restarting it", name)
+		outcome = "restarted it"
+		if err := w.manager.Start(name); err != nil {
+			metric.Logger(agg).Errorf("Could not restart Windows service %s: %s", name, err)
+			outcome = fmt.Sprintf("could not restart it: %s", err)
+		}
+		recent = append(recent, now)
+	}
+	w.restarts[name] = recent
+
+	agg.Add("gauge", metric.NewMetric("windows_service.restarts", len(recent), tags))
+	agg.Add("gauge", metric.NewMetric("windows_service.crash_loop", boolToInt(crashLoop), tags))
+	return crashLoop, outcome
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func init() {
+	collector.Add("windows_service", NewWindowsService)
+}
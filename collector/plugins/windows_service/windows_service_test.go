@@ -0,0 +1,127 @@
+package windowsservice
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeManager struct {
+	services []service
+	started  []string
+}
+
+func (f *fakeManager) Services() ([]service, error) {
+	return f.services, nil
+}
+
+func (f *fakeManager) Start(name string) error {
+	f.started = append(f.started, name)
+	return fmt.Errorf("the service did not respond to the start request")
+}
+
+func TestCheck(t *testing.T) {
+	manager := &fakeManager{
+		services: []service{
+			{"wuauserv", StateRunning},
+			{"MSSQLSERVER", StateStopped},
+			{"MSSQL$EXPRESS", "Start Pending"},
+			{"Spooler", StateStopped},
+		},
+	}
+	w := &WindowsService{manager: manager, restarts: make(map[string][]time.Time)}
+	agg := &metric.MockAggregator{}
+	instance := plugin.Instance{
+		"services": []interface{}{"wuauserv", "mssql*", "w3svc"},
+		"tags":     []interface{}{"env:prod"},
+	}
+	require.NoError(t, w.Check(agg, instance))
+
+	for _, c := range []struct {
+		service string
+		up      float64
+		status  float64
+		message string
+	}{
+		{"wuauserv", 1, metric.ServiceCheckOK, "Service wuauserv is running"},
+		{"mssqlserver", 0, metric.ServiceCheckCritical, "Service MSSQLSERVER is stopped"},
+		{"mssql$express", 0, metric.ServiceCheckWarning, "Service MSSQL$EXPRESS is start pending"},
+		{"w3svc", 0, metric.ServiceCheckCritical, "Service w3svc not found"},
+	} {
+		value, ok := agg.Value("windows_service.up", "env:prod", "service:"+c.service)
+		if assert.True(t, ok, c.service) {
+			assert.Equal(t, c.up, value, c.service)
+		}
+		value, ok = agg.Value("windows_service.status", "env:prod", "service:"+c.service)
+		if assert.True(t, ok, c.service) {
+			assert.Equal(t, c.status, value, c.service)
+		}
+		sc, ok := agg.GetServiceCheck("windows_service.state", "env:prod", "service:"+c.service)
+		if assert.True(t, ok, c.service) {
+			assert.Equal(t, int(c.status), sc.Status, c.service)
+			assert.Equal(t, c.message, sc.Message, c.service)
+		}
+	}
+	_, ok := agg.Get("windows_service.up", "service:spooler")
+	assert.False(t, ok)
+	assert.Empty(t, manager.started)
+	assert.NotContains(t, agg.Names(), "windows_service.restarts")
+	_, ok = agg.GetServiceCheck("windows_service.crash_loop")
+	assert.False(t, ok)
+}
+
+func TestCheckRestart(t *testing.T) {
+	manager := &fakeManager{services: []service{{"Spooler", StateStopped}}}
+	w := &WindowsService{manager: manager, restarts: make(map[string][]time.Time)}
+	instance := plugin.Instance{
+		"services":     []interface{}{"spooler"},
+		"restart":      true,
+		"max_restarts": 2,
+	}
+
+	for i := 1; i <= 2; i++ {
+		agg := &metric.MockAggregator{}
+		require.NoError(t, w.Check(agg, instance))
+		value, _ := agg.Value("windows_service.restarts", "service:spooler")
+		assert.Equal(t, float64(i), value)
+		value, _ = agg.Value("windows_service.crash_loop", "service:spooler")
+		assert.Equal(t, float64(0), value)
+		sc, _ := agg.GetServiceCheck("windows_service.state", "service:spooler")
+		assert.Equal(t, "Service Spooler is stopped, could not restart it: the service did not respond to the start request",
+			sc.Message)
+		sc, _ = agg.GetServiceCheck("windows_service.crash_loop", "service:spooler")
+		assert.Equal(t, metric.ServiceCheckOK, sc.Status)
+	}
+	assert.Equal(t, []string{"Spooler", "Spooler"}, manager.started)
+
+	// The service is crash looping, it isn't restarted anymore.
+	agg := &metric.MockAggregator{}
+	require.NoError(t, w.Check(agg, instance))
+	value, _ := agg.Value("windows_service.crash_loop", "service:spooler")
+	assert.Equal(t, float64(1), value)
+	assert.Len(t, manager.started, 2)
+	sc, ok := agg.GetServiceCheck("windows_service.crash_loop", "service:spooler")
+	if assert.True(t, ok) {
+		assert.Equal(t, metric.ServiceCheckCritical, sc.Status)
+		assert.Equal(t, "Service Spooler is stopped, restarted 2 times in 10m0s, it's crash looping and won't be restarted",
+			sc.Message)
+	}
+
+	// Until the restarts are out of the window.
+	w.restarts["Spooler"] = []time.Time{time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)}
+	require.NoError(t, w.Check(&metric.MockAggregator{}, instance))
+	assert.Len(t, manager.started, 3)
+}
+
+func TestCheckInvalidConfig(t *testing.T) {
+	w := &WindowsService{manager: &fakeManager{}, restarts: make(map[string][]time.Time)}
+	assert.Error(t, w.Check(&metric.MockAggregator{}, plugin.Instance{}))
+	assert.Error(t, w.Check(&metric.MockAggregator{}, plugin.Instance{
+		"services": []interface{}{"sql["},
+	}))
+}
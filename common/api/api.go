@@ -3,7 +3,9 @@ package api
 import (
 	"bytes"
 	"compress/zlib"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,63 +15,191 @@ import (
 
 	"github.com/cloudinsight/cloudinsight-agent/common/config"
 	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/msgpack"
+	"github.com/cloudinsight/cloudinsight-agent/common/proxy"
 )
 
+// DefaultMaxPayloadSize is the maximum size of a compressed payload accepted
+// by Cloudinsight.
+const DefaultMaxPayloadSize = 2 * 1024 * 1024
+
+// PriorityHeader is set to HighPriority on the payloads the Forwarder should
+// post before the others when it's retrying a backlog.
+const (
+	PriorityHeader = "X-Cloudinsight-Priority"
+	HighPriority   = "high"
+)
+
+// The formats of the payloads, JSON is understood by every intake while
+// MessagePack is smaller and cheaper to encode.
+const (
+	FormatJSON    = "json"
+	FormatMsgpack = "msgpack"
+
+	// ContentTypeJSON is the media type of the JSON payloads.
+	ContentTypeJSON = "application/json"
+)
+
+// ErrPayloadTooLarge is returned when a compressed payload exceeds the
+// maximum payload size, the caller should split it.
+var ErrPayloadTooLarge = errors.New("payload exceeds the maximum payload size")
+
 // API XXX
 type API struct {
 	ciURL      string
 	licenseKey string
 	client     *http.Client
+
+	// CompressionLevel is the zlib compression level of the payloads, from 1
+	// (fastest) to 9 (smallest), 0 uses the default level.
+	CompressionLevel int
+	// MaxPayloadSize is the maximum size of a compressed payload in bytes, 0
+	// uses DefaultMaxPayloadSize.
+	MaxPayloadSize int
+	// Format is the serialization of the submitted payloads, FormatJSON or
+	// FormatMsgpack. The default is FormatJSON.
+	Format string
 }
 
 // NewAPI XXX
 func NewAPI(ciURL string, licenseKey string, timeout time.Duration) *API {
 	ciURL = strings.TrimSuffix(ciURL, "/")
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy.FromConfig
 	api := &API{
 		ciURL:      ciURL,
 		licenseKey: licenseKey,
 		client: &http.Client{
-			Timeout: timeout,
+			Transport: transport,
+			Timeout:   timeout,
 		},
 	}
 	return api
 }
 
+// SetTLSConfig sets the TLS options of the requests, e.g. to trust a custom
+// CA or to present a client certificate.
+func (api *API) SetTLSConfig(tlsConfig *tls.Config) {
+	api.client.Transport.(*http.Transport).TLSClientConfig = tlsConfig
+}
+
 // SubmitMetrics submits metrics the collector collected.
 func (api *API) SubmitMetrics(data interface{}) error {
-	dataBytes, err := json.Marshal(data)
+	return api.submit("metrics", data, false)
+}
+
+// SubmitMetadata submits a metrics payload carrying the host metadata, it has
+// a high priority.
+func (api *API) SubmitMetadata(data interface{}) error {
+	return api.submit("metrics", data, true)
+}
+
+// SubmitHostMetadata submits the host metadata payload, it has its own
+// endpoint and a high priority.
+func (api *API) SubmitHostMetadata(data interface{}) error {
+	return api.submit("metadata", data, true)
+}
+
+// SubmitProcesses submits the process inventory payload, it has its own
+// endpoint.
+func (api *API) SubmitProcesses(data interface{}) error {
+	return api.submit("processes", data, false)
+}
+
+// SubmitConnections submits the network connections payload, it has its own
+// endpoint.
+func (api *API) SubmitConnections(data interface{}) error {
+	return api.submit("connections", data, false)
+}
+
+// SubmitServiceChecks submits the service checks, they have their own endpoint.
+func (api *API) SubmitServiceChecks(data interface{}) error {
+	return api.submit("service_checks", data, false)
+}
+
+func (api *API) submit(msgType string, data interface{}, highPriority bool) error {
+	dataBytes, contentType, err := api.marshal(data)
 	if err != nil {
 		return fmt.Errorf("unable to marshal data, %s", err.Error())
 	}
-	// log.Debugf("Submitting metrics: %s", string(dataBytes))
-	compressed := api.compress(dataBytes)
+	compressed, err := api.compress(dataBytes)
+	if err != nil {
+		return err
+	}
 
-	return api.Post(api.GetURL("metrics"), &compressed)
+	req, err := http.NewRequest("POST", api.GetURL(msgType), compressed)
+	if err != nil {
+		return fmt.Errorf("unable to create http.Request, %s", err.Error())
+	}
+	req.Header.Set("Content-Type", contentType)
+	if highPriority {
+		req.Header.Set(PriorityHeader, HighPriority)
+	}
+	return api.send(req)
+}
+
+// marshal serializes data in the format of the API, it returns the content
+// type of the payload.
+func (api *API) marshal(data interface{}) ([]byte, string, error) {
+	if api.Format == FormatMsgpack {
+		b, err := msgpack.Marshal(data)
+		return b, msgpack.ContentType, err
+	}
+	b, err := json.Marshal(data)
+	return b, ContentTypeJSON, err
 }
 
 // Post sends the metrics to Cloudinsight.
 func (api *API) Post(path string, body io.Reader) error {
+	return api.PostContent(path, ContentTypeJSON, body)
+}
+
+// PostContent sends a payload serialized in contentType to Cloudinsight.
+func (api *API) PostContent(path, contentType string, body io.Reader) error {
 	req, err := http.NewRequest("POST", path, body)
 	if err != nil {
 		return fmt.Errorf("unable to create http.Request, %s", err.Error())
 	}
+	req.Header.Set("Content-Type", contentType)
+	return api.send(req)
+}
 
+func (api *API) send(req *http.Request) error {
 	resp, err := api.do(req)
 	defer closeResp(resp)
 	if err != nil {
 		return fmt.Errorf("error POSTing data, %s", err.Error())
 	}
 
+	if resp.StatusCode == http.StatusRequestEntityTooLarge {
+		return ErrPayloadTooLarge
+	}
 	if resp.StatusCode < 200 || resp.StatusCode > 209 {
-		return fmt.Errorf("received bad status code, %d", resp.StatusCode)
+		return &StatusError{Code: resp.StatusCode}
 	}
 
 	return nil
 }
 
+// StatusError is returned by Post when Cloudinsight answers with an
+// unexpected status code.
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("received bad status code, %d", e.Code)
+}
+
+// Permanent returns true when sending the same request again would fail
+// again, that is for the client errors except timeouts and throttling.
+func (e *StatusError) Permanent() bool {
+	return e.Code >= 400 && e.Code < 500 &&
+		e.Code != http.StatusRequestTimeout && e.Code != http.StatusTooManyRequests
+}
+
 func (api *API) do(req *http.Request) (resp *http.Response, err error) {
 	req.Header.Add("User-Agent", fmt.Sprintf("Cloudinsight Agent/%s", config.VERSION))
-	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Content-Encoding", "deflate")
 	req.Header.Add("Accept", "text/html, */*")
 
@@ -80,14 +210,31 @@ func (api *API) do(req *http.Request) (resp *http.Response, err error) {
 	return resp, nil
 }
 
-func (api *API) compress(b []byte) bytes.Buffer {
-	var buf bytes.Buffer
-	comp := zlib.NewWriter(&buf)
+// compress deflates b with zlib, the only encoding sent to the intake, and
+// returns ErrPayloadTooLarge when the compressed payload exceeds the maximum
+// payload size.
+func (api *API) compress(b []byte) (*bytes.Buffer, error) {
+	level := api.CompressionLevel
+	if level == 0 {
+		level = zlib.DefaultCompression
+	}
 
+	var buf bytes.Buffer
+	comp, err := zlib.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compress data, %s", err.Error())
+	}
 	_, _ = comp.Write(b)
 	_ = comp.Close()
 
-	return buf
+	maxSize := api.MaxPayloadSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxPayloadSize
+	}
+	if buf.Len() > maxSize {
+		return nil, ErrPayloadTooLarge
+	}
+	return &buf, nil
 }
 
 func closeResp(resp *http.Response) {
@@ -99,7 +246,8 @@ func closeResp(resp *http.Response) {
 	}
 }
 
-// GetURL gets URL according to msgType(metrics, service_checks or series).
+// GetURL gets URL according to msgType(metrics, service_checks, metadata,
+// processes, connections or series).
 func (api *API) GetURL(msgType string) string {
 	q := url.Values{
 		"license_key": []string{api.licenseKey},
@@ -110,6 +258,12 @@ func (api *API) GetURL(msgType string) string {
 		return fmt.Sprintf("%s/infrastructure/metrics?%s", api.ciURL, q.Encode())
 	case "service_checks":
 		return fmt.Sprintf("%s/infrastructure/service_checks?%s", api.ciURL, q.Encode())
+	case "metadata":
+		return fmt.Sprintf("%s/infrastructure/metadata?%s", api.ciURL, q.Encode())
+	case "processes":
+		return fmt.Sprintf("%s/infrastructure/processes?%s", api.ciURL, q.Encode())
+	case "connections":
+		return fmt.Sprintf("%s/infrastructure/connections?%s", api.ciURL, q.Encode())
 	case "series":
 		return fmt.Sprintf("%s/infrastructure/series?%s", api.ciURL, q.Encode())
 	default:
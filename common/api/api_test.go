@@ -1,10 +1,14 @@
 package api
 
 import (
+	"compress/zlib"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/cloudinsight/cloudinsight-agent/common/msgpack"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -15,14 +19,11 @@ func TestNewAPI(t *testing.T) {
 		5*time.Second,
 	)
 
-	expectedAPI := &API{
-		ciURL:      "http://example.com",
-		licenseKey: "dummy-key",
-		client: &http.Client{
-			Timeout: 5 * time.Second,
-		},
-	}
-	assert.Equal(t, expectedAPI, api)
+	assert.Equal(t, "http://example.com", api.ciURL)
+	assert.Equal(t, "dummy-key", api.licenseKey)
+	assert.Equal(t, 5*time.Second, api.client.Timeout)
+	// The proxy of the agent is used.
+	assert.NotNil(t, api.client.Transport.(*http.Transport).Proxy)
 }
 
 func TestGetURL(t *testing.T) {
@@ -46,3 +47,84 @@ func TestGetURL(t *testing.T) {
 		}
 	}
 }
+
+func TestStatusError(t *testing.T) {
+	for code, permanent := range map[int]bool{
+		400: true,
+		403: true,
+		408: false,
+		429: false,
+		500: false,
+		503: false,
+	} {
+		err := &StatusError{Code: code}
+		assert.Equal(t, permanent, err.Permanent(), "status %d", code)
+	}
+	assert.Equal(t, "received bad status code, 404", (&StatusError{Code: 404}).Error())
+}
+
+func TestSubmitMetricsTooLarge(t *testing.T) {
+	var received int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+		if r.URL.Path == "/infrastructure/service_checks" {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}
+	}))
+	defer server.Close()
+
+	api := NewAPI(server.URL, "dummy-key", 5*time.Second)
+	api.CompressionLevel = 9
+	assert.NoError(t, api.SubmitMetrics([]int{1, 2, 3}))
+
+	// Checked before sending.
+	api.MaxPayloadSize = 8
+	assert.Equal(t, ErrPayloadTooLarge, api.SubmitMetrics([]int{1, 2, 3}))
+	assert.Equal(t, 1, received)
+
+	// Rejected by the server.
+	api.MaxPayloadSize = 0
+	assert.Equal(t, ErrPayloadTooLarge, api.SubmitServiceChecks([]int{1, 2, 3}))
+	assert.Equal(t, 2, received)
+}
+
+func TestSubmitMetadata(t *testing.T) {
+	var priorities []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/infrastructure/metrics", r.URL.Path)
+		assert.Equal(t, "deflate", r.Header.Get("Content-Encoding"))
+		priorities = append(priorities, r.Header.Get(PriorityHeader))
+	}))
+	defer server.Close()
+
+	api := NewAPI(server.URL, "dummy-key", 5*time.Second)
+	assert.NoError(t, api.SubmitMetrics([]int{1}))
+	assert.NoError(t, api.SubmitMetadata([]int{1}))
+	assert.Equal(t, []string{"", HighPriority}, priorities)
+}
+
+func TestSubmitFormat(t *testing.T) {
+	var contentType string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		zr, err := zlib.NewReader(r.Body)
+		assert.NoError(t, err)
+		body, err = ioutil.ReadAll(zr)
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	api := NewAPI(server.URL, "dummy-key", 5*time.Second)
+	data := map[string]interface{}{"os": "linux"}
+	assert.NoError(t, api.SubmitMetrics(data))
+	assert.Equal(t, ContentTypeJSON, contentType)
+	assert.Equal(t, `{"os":"linux"}`, string(body))
+
+	api.Format = FormatMsgpack
+	assert.NoError(t, api.SubmitMetrics(data))
+	assert.Equal(t, msgpack.ContentType, contentType)
+	decoded, err := msgpack.Decode(body)
+	assert.NoError(t, err)
+	assert.Equal(t, data, decoded)
+}
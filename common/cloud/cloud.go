@@ -0,0 +1,147 @@
+// Package cloud detects the cloud provider the agent is running on from the
+// instance metadata endpoints, and turns the instance metadata into host tags.
+package cloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+)
+
+// DefaultTimeout bounds every request sent to the metadata endpoints, they
+// are link-local so they answer quickly when they exist.
+const DefaultTimeout = 1 * time.Second
+
+// Metadata describes the cloud instance running the agent.
+type Metadata struct {
+	Provider         string `json:"provider"`
+	InstanceID       string `json:"instance_id"`
+	InstanceType     string `json:"instance_type"`
+	Region           string `json:"region"`
+	AvailabilityZone string `json:"availability_zone"`
+}
+
+// Tags returns the metadata as host tags.
+func (m *Metadata) Tags() []string {
+	tags := []string{"cloud_provider:" + m.Provider}
+	for _, tag := range [][2]string{
+		{"instance-id", m.InstanceID},
+		{"instance-type", m.InstanceType},
+		{"region", m.Region},
+		{"availability-zone", m.AvailabilityZone},
+	} {
+		if tag[1] != "" {
+			tags = append(tags, tag[0]+":"+tag[1])
+		}
+	}
+	return tags
+}
+
+type provider struct {
+	name   string
+	detect func(client *http.Client) (*Metadata, error)
+}
+
+var providers = []provider{
+	{"aws", detectEC2},
+	{"gcp", detectGCE},
+	{"azure", detectAzure},
+	{"aliyun", detectAliyun},
+}
+
+// Detect queries the metadata endpoints of all the providers concurrently,
+// it returns nil when the agent doesn't run on any of them.
+func Detect(timeout time.Duration) *Metadata {
+	client := newClient(timeout)
+	results := make(chan *Metadata, len(providers))
+	for _, p := range providers {
+		go func(p provider) {
+			m, err := p.detect(client)
+			if err != nil {
+				log.Debugf("Not running on %s: %s", p.name, err)
+				results <- nil
+				return
+			}
+			m.Provider = p.name
+			results <- m
+		}(p)
+	}
+
+	var found *Metadata
+	for range providers {
+		if m := <-results; m != nil && found == nil {
+			found = m
+		}
+	}
+	return found
+}
+
+// EC2InstanceID returns the id of the EC2 instance running the agent.
+func EC2InstanceID(timeout time.Duration) (string, error) {
+	m, err := detectEC2(newClient(timeout))
+	if err != nil {
+		return "", err
+	}
+	return m.InstanceID, nil
+}
+
+func newClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		// Never go through a proxy for the link-local endpoints.
+		Transport: &http.Transport{Proxy: nil},
+	}
+}
+
+// request sends a request with the given headers and returns the body.
+func request(client *http.Client, method, url string, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned HTTP status %d", url, resp.StatusCode)
+	}
+	// The documents are small, don't trust whatever answers on the address.
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+}
+
+func getJSON(client *http.Client, url string, headers map[string]string, v interface{}) error {
+	body, err := request(client, "GET", url, headers)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+func getText(client *http.Client, url string) (string, error) {
+	body, err := request(client, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// lastSegment returns what follows the last slash, GCE returns resources as
+// "projects/123/zones/us-central1-a".
+func lastSegment(s string) string {
+	return s[strings.LastIndex(s, "/")+1:]
+}
@@ -0,0 +1,135 @@
+package cloud
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newServer(handler http.HandlerFunc) (*httptest.Server, *http.Client) {
+	ts := httptest.NewServer(handler)
+	return ts, &http.Client{Timeout: time.Second}
+}
+
+func TestDetectEC2(t *testing.T) {
+	ts, client := newServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/token":
+			assert.Equal(t, "PUT", r.Method)
+			fmt.Fprint(w, "secret")
+		case "/dynamic/instance-identity/document":
+			assert.Equal(t, "secret", r.Header.Get("X-aws-ec2-metadata-token"))
+			fmt.Fprint(w, `{"instanceId":"i-0123","instanceType":"m5.large","region":"us-east-1","availabilityZone":"us-east-1a"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer ts.Close()
+	ec2URL = ts.URL
+
+	m, err := detectEC2(client)
+	assert.NoError(t, err)
+	assert.Equal(t, &Metadata{
+		InstanceID:       "i-0123",
+		InstanceType:     "m5.large",
+		Region:           "us-east-1",
+		AvailabilityZone: "us-east-1a",
+	}, m)
+}
+
+func TestDetectGCE(t *testing.T) {
+	ts, client := newServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"id":4520031799277581759,"machineType":"projects/123/machineTypes/n1-standard-1","zone":"projects/123/zones/us-central1-a"}`)
+	})
+	defer ts.Close()
+	gceURL = ts.URL
+
+	m, err := detectGCE(client)
+	assert.NoError(t, err)
+	assert.Equal(t, &Metadata{
+		InstanceID:       "4520031799277581759",
+		InstanceType:     "n1-standard-1",
+		Region:           "us-central1",
+		AvailabilityZone: "us-central1-a",
+	}, m)
+}
+
+func TestDetectAzure(t *testing.T) {
+	ts, client := newServer(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.Header.Get("Metadata"))
+		fmt.Fprint(w, `{"vmId":"02aab8a4-74ef","vmSize":"Standard_D2s_v3","location":"westeurope","zone":"1"}`)
+	})
+	defer ts.Close()
+	azureURL = ts.URL
+
+	m, err := detectAzure(client)
+	assert.NoError(t, err)
+	assert.Equal(t, &Metadata{
+		InstanceID:       "02aab8a4-74ef",
+		InstanceType:     "Standard_D2s_v3",
+		Region:           "westeurope",
+		AvailabilityZone: "1",
+	}, m)
+}
+
+func TestDetectAliyun(t *testing.T) {
+	items := map[string]string{
+		"/instance-id":            "i-bp67acfmxazb4p",
+		"/instance/instance-type": "ecs.g6.large",
+		"/region-id":              "cn-hangzhou",
+		"/zone-id":                "cn-hangzhou-i",
+	}
+	ts, client := newServer(func(w http.ResponseWriter, r *http.Request) {
+		item, ok := items[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, item)
+	})
+	defer ts.Close()
+	aliyunURL = ts.URL
+
+	m, err := detectAliyun(client)
+	assert.NoError(t, err)
+	assert.Equal(t, &Metadata{
+		InstanceID:       "i-bp67acfmxazb4p",
+		InstanceType:     "ecs.g6.large",
+		Region:           "cn-hangzhou",
+		AvailabilityZone: "cn-hangzhou-i",
+	}, m)
+}
+
+func TestDetect(t *testing.T) {
+	ts, _ := newServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/instance/compute" {
+			fmt.Fprint(w, `{"vmId":"02aab8a4-74ef","vmSize":"Standard_D2s_v3","location":"westeurope"}`)
+			return
+		}
+		http.NotFound(w, r)
+	})
+	defer ts.Close()
+	ec2URL, gceURL, azureURL, aliyunURL = ts.URL, ts.URL, ts.URL, ts.URL
+
+	m := Detect(time.Second)
+	if assert.NotNil(t, m) {
+		assert.Equal(t, "azure", m.Provider)
+		assert.Equal(t, []string{
+			"cloud_provider:azure",
+			"instance-id:02aab8a4-74ef",
+			"instance-type:Standard_D2s_v3",
+			"region:westeurope",
+		}, m.Tags())
+	}
+
+	ts.Close()
+	assert.Nil(t, Detect(time.Second))
+}
@@ -0,0 +1,123 @@
+package cloud
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// The metadata endpoints, variables so that tests can point them to a local
+// server.
+var (
+	ec2URL    = "http://169.254.169.254/latest"
+	gceURL    = "http://metadata.google.internal/computeMetadata/v1"
+	azureURL  = "http://169.254.169.254/metadata"
+	aliyunURL = "http://100.100.100.200/latest/meta-data"
+)
+
+// detectEC2 reads the instance identity document, with an IMDSv2 session
+// token when the instance provides one.
+func detectEC2(client *http.Client) (*Metadata, error) {
+	headers := map[string]string{}
+	token, err := request(client, "PUT", ec2URL+"/api/token", map[string]string{
+		"X-aws-ec2-metadata-token-ttl-seconds": "60",
+	})
+	if err == nil {
+		headers["X-aws-ec2-metadata-token"] = string(token)
+	}
+
+	var doc struct {
+		InstanceID       string `json:"instanceId"`
+		InstanceType     string `json:"instanceType"`
+		Region           string `json:"region"`
+		AvailabilityZone string `json:"availabilityZone"`
+	}
+	if err := getJSON(client, ec2URL+"/dynamic/instance-identity/document", headers, &doc); err != nil {
+		return nil, err
+	}
+	if doc.InstanceID == "" {
+		return nil, fmt.Errorf("no instanceId in the identity document")
+	}
+
+	return &Metadata{
+		InstanceID:       doc.InstanceID,
+		InstanceType:     doc.InstanceType,
+		Region:           doc.Region,
+		AvailabilityZone: doc.AvailabilityZone,
+	}, nil
+}
+
+func detectGCE(client *http.Client) (*Metadata, error) {
+	var instance struct {
+		ID          uint64 `json:"id"`
+		MachineType string `json:"machineType"`
+		Zone        string `json:"zone"`
+	}
+	err := getJSON(client, gceURL+"/instance/?recursive=true", map[string]string{
+		"Metadata-Flavor": "Google",
+	}, &instance)
+	if err != nil {
+		return nil, err
+	}
+	if instance.ID == 0 {
+		return nil, fmt.Errorf("no id in the instance metadata")
+	}
+
+	zone := lastSegment(instance.Zone)
+	region := zone
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		region = zone[:i]
+	}
+
+	return &Metadata{
+		InstanceID:       fmt.Sprint(instance.ID),
+		InstanceType:     lastSegment(instance.MachineType),
+		Region:           region,
+		AvailabilityZone: zone,
+	}, nil
+}
+
+func detectAzure(client *http.Client) (*Metadata, error) {
+	var compute struct {
+		VMID     string `json:"vmId"`
+		VMSize   string `json:"vmSize"`
+		Location string `json:"location"`
+		Zone     string `json:"zone"`
+	}
+	err := getJSON(client, azureURL+"/instance/compute?api-version=2021-02-01", map[string]string{
+		"Metadata": "true",
+	}, &compute)
+	if err != nil {
+		return nil, err
+	}
+	if compute.VMID == "" {
+		return nil, fmt.Errorf("no vmId in the compute metadata")
+	}
+
+	return &Metadata{
+		InstanceID:       compute.VMID,
+		InstanceType:     compute.VMSize,
+		Region:           compute.Location,
+		AvailabilityZone: compute.Zone,
+	}, nil
+}
+
+// detectAliyun reads the plain text metadata items of Alibaba Cloud ECS.
+func detectAliyun(client *http.Client) (*Metadata, error) {
+	id, err := getText(client, aliyunURL+"/instance-id")
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Metadata{InstanceID: id}
+	for path, value := range map[string]*string{
+		"/instance/instance-type": &m.InstanceType,
+		"/region-id":              &m.Region,
+		"/zone-id":                &m.AvailabilityZone,
+	} {
+		if *value, err = getText(client, aliyunURL+path); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
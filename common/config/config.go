@@ -1,21 +1,40 @@
 package config
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/cloudinsight/cloudinsight-agent/collector"
+	"github.com/cloudinsight/cloudinsight-agent/common/kv"
+	"github.com/cloudinsight/cloudinsight-agent/common/limits"
 	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
 	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/prometheus"
+	"github.com/cloudinsight/cloudinsight-agent/common/proxy"
+	"github.com/cloudinsight/cloudinsight-agent/common/secrets"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+	"github.com/cloudinsight/cloudinsight-agent/output"
 )
 
 // VERSION sets the agent version here.
 const VERSION = "0.0.1"
 
+// DefaultControlTokenFile is the default token file of the control API,
+// it's created by the agent.
+const DefaultControlTokenFile = "/etc/cloudinsight-agent/control.token"
+
+// DefaultSecretKeyFile is the default key file of the ENC[...] values, it's
+// created by `cloudinsight-agent secret encrypt`.
+const DefaultSecretKeyFile = "/etc/cloudinsight-agent/secret.key"
+
 var (
 	// DefaultConfig is the default top-level configuration.
 	DefaultConfig = Config{
@@ -28,6 +47,28 @@ var (
 		BindHost:   "127.0.0.1",
 		ListenPort: 10010,
 		StatsdPort: 8251,
+
+		CloudMetadata:      true,
+		CloudinsightOutput: true,
+
+		CollectInterval:     30,
+		StatsdFlushInterval: 10,
+		FlushJitter:         5,
+
+		ForwarderQueueMaxSize: 100,
+		ForwarderQueueMaxAge:  24,
+		ForwarderBackoffBase:  2,
+		ForwarderBackoffMax:   300,
+
+		RemoteConfigInterval: 60,
+
+		HostMetadata:         true,
+		HostMetadataInterval: 1800,
+
+		ProcessInventoryInterval: 60,
+		ProcessInventoryTop:      10,
+
+		NetworkConnectionsInterval: 60,
 	}
 )
 
@@ -41,29 +82,343 @@ func NewConfig(confPath string) (*Config, error) {
 		return nil, fmt.Errorf("Failed to load the config file: %s", err)
 	}
 
-	if c.GlobalConfig.LicenseKey == "" {
-		return nil, fmt.Errorf("LicenseKey must be specified in the config file.")
+	if c.GlobalConfig.CloudinsightOutput {
+		if c.GlobalConfig.LicenseKey == "" {
+			return nil, fmt.Errorf("LicenseKey must be specified in the config file.")
+		}
+	} else if len(c.Outputs) == 0 && c.GlobalConfig.PrometheusPort == 0 {
+		return nil, fmt.Errorf("cloudinsight_output is disabled but no output is configured")
+	}
+
+	if err = c.validateHistograms(); err != nil {
+		return nil, err
+	}
+
+	if _, err = c.NewFilter(); err != nil {
+		return nil, err
+	}
+
+	if err = c.validatePipelines(); err != nil {
+		return nil, err
+	}
+
+	if _, err = metric.NewTransformer(c.Transforms); err != nil {
+		return nil, err
+	}
+
+	switch c.GlobalConfig.ForwarderRetryPolicy {
+	case "", "oldest_first", "newest_first":
+	default:
+		return nil, fmt.Errorf("forwarder_retry_policy must be oldest_first or newest_first, got %s", c.GlobalConfig.ForwarderRetryPolicy)
+	}
+
+	switch c.GlobalConfig.PayloadFormat {
+	case "", "json", "msgpack":
+	default:
+		return nil, fmt.Errorf("payload_format must be json or msgpack, got %s", c.GlobalConfig.PayloadFormat)
+	}
+
+	for name, port := range map[string]int{
+		"listen_port":     c.GlobalConfig.ListenPort,
+		"statsd_port":     c.GlobalConfig.StatsdPort,
+		"statsd_tcp_port": c.GlobalConfig.StatsdTCPPort,
+		"prometheus_port": c.GlobalConfig.PrometheusPort,
+		"control_port":    c.GlobalConfig.ControlPort,
+	} {
+		if port < 0 || port > 65535 {
+			return nil, fmt.Errorf("%s must be between 0 and 65535, got %d", name, port)
+		}
+	}
+
+	for name, value := range map[string]int{
+		"max_procs":    c.GlobalConfig.MaxProcs,
+		"gc_percent":   c.GlobalConfig.GCPercent,
+		"memory_limit": c.GlobalConfig.MemoryLimit,
+	} {
+		if value < 0 {
+			return nil, fmt.Errorf("%s must not be negative, got %d", name, value)
+		}
+	}
+
+	if c.GlobalConfig.RunAsGroup != "" && c.GlobalConfig.RunAsUser == "" {
+		return nil, fmt.Errorf("run_as_group must be specified with run_as_user")
+	}
+
+	if level := c.GlobalConfig.CompressionLevel; level < 0 || level > 9 {
+		return nil, fmt.Errorf("compression_level must be between 1 and 9, got %d", level)
+	}
+
+	if _, err = proxy.New(c.ProxySettings()); err != nil {
+		return nil, err
+	}
+
+	if _, err = c.ForwarderTLSConfig(); err != nil {
+		return nil, err
+	}
+
+	if c.GlobalConfig.RemoteConfigURL != "" && c.GlobalConfig.RemoteConfigPath == "" {
+		return nil, fmt.Errorf("remote_config_path must be specified with remote_config_url")
+	}
+
+	outputs, err := c.NewOutputs()
+	if err != nil {
+		return nil, err
 	}
+	closeOutputs(outputs)
 
 	return c, nil
 }
 
 // Config represents cloudinsight-agent's configuration file.
 type Config struct {
+	// Include lists the files, or glob patterns, layered on top of the main
+	// file. The paths are relative to the main file.
+	Include []string `toml:"include"`
 	GlobalConfig  GlobalConfig  `toml:"global"`
 	LoggingConfig LoggingConfig `toml:"logging"`
-	Plugins       []*plugin.RunningPlugin
+	// StatsdMappings rewrite the names of the metrics received by Statsd.
+	StatsdMappings []StatsdMapping `toml:"statsd_mapping"`
+	// Histograms override the global histogram settings by metric prefix.
+	Histograms []HistogramConfig `toml:"histogram"`
+	// Filter selects the metrics and tags submitted by every check and
+	// Statsd, the filter of a check is applied on top of it.
+	Filter metric.FilterConfig `toml:"filter"`
+	// Transforms rename, scale or change the type of the metrics.
+	Transforms []metric.TransformRule `toml:"transform"`
+	// Outputs holds the [[output.<name>]] sections, decoded by the outputs.
+	Outputs map[string][]toml.Primitive `toml:"output"`
+	// Pipelines run their checks apart from the others, see SplitPipelines.
+	Pipelines []PipelineConfig `toml:"pipeline"`
+	Plugins    []*plugin.RunningPlugin
+
+	// pipeline is the name of the pipelines returned by SplitPipelines.
+	pipeline string
+
+	// hostname caches GetHostname, guarded by hostnameMu.
+	hostname         string
+	hostnameResolved bool
 }
 
 // GlobalConfig XXX
 type GlobalConfig struct {
-	CiURL      string `toml:"ci_url"`
-	LicenseKey string `toml:"license_key"`
-	Hostname   string `toml:"hostname"`
-	Tags       string `toml:"tags"`
-	BindHost   string `toml:"bind_host"`
-	ListenPort int    `toml:"listen_port"`
-	StatsdPort int    `toml:"statsd_port"`
+	CiURL      string  `toml:"ci_url"`
+	LicenseKey string  `toml:"license_key"`
+	Hostname   string  `toml:"hostname"`
+	// HostnameCommand, run with HostnameCommandArguments, prints the
+	// hostname when the hostname option isn't set. HostnameEC2InstanceID
+	// then uses the id of the EC2 instance, and HostnameFQDN the FQDN of the
+	// host, see ResolveHostname.
+	HostnameCommand          string   `toml:"hostname_command"`
+	HostnameCommandArguments []string `toml:"hostname_command_arguments"`
+	HostnameEC2InstanceID    bool     `toml:"hostname_ec2_instance_id"`
+	HostnameFQDN             bool     `toml:"hostname_fqdn"`
+	Tags       TagList `toml:"tags"`
+	BindHost   string  `toml:"bind_host"`
+	ListenPort int     `toml:"listen_port"`
+	StatsdPort int     `toml:"statsd_port"`
+	// StatsdSocket is the path of an optional unix domain socket Statsd
+	// listens to, in addition to the UDP port.
+	StatsdSocket string `toml:"statsd_socket"`
+	// StatsdOriginDetection tags the metrics received on StatsdSocket with
+	// the container of the sender.
+	StatsdOriginDetection bool `toml:"statsd_origin_detection"`
+	// StatsdWorkers is the number of goroutines decoding the statsd packets,
+	// it defaults to the number of CPUs.
+	StatsdWorkers int `toml:"statsd_workers"`
+	// StatsdAggregatorShards is the number of aggregators the statsd metrics
+	// are spread across by name, each one runs in its own goroutine.
+	StatsdAggregatorShards int `toml:"statsd_aggregator_shards"`
+	// StatsdReadBuffer sets the size of the kernel receive buffer (SO_RCVBUF)
+	// of the statsd sockets, the system default is used when it's 0.
+	StatsdReadBuffer int `toml:"statsd_so_rcvbuf"`
+	// StatsdTCPPort enables a TCP listener for statsd lines separated by
+	// newlines, it's disabled when 0.
+	StatsdTCPPort int `toml:"statsd_tcp_port"`
+	// StatsdTLSCert and StatsdTLSKey switch the TCP listener to TLS.
+	StatsdTLSCert string `toml:"statsd_tls_cert"`
+	StatsdTLSKey  string `toml:"statsd_tls_key"`
+	// PrometheusPort enables an endpoint exposing the latest values of the
+	// metrics to Prometheus on /metrics, it's disabled when 0.
+	PrometheusPort int `toml:"prometheus_port"`
+	// PrometheusBindHost is the address of that endpoint, BindHost is used
+	// when it's empty.
+	PrometheusBindHost string `toml:"prometheus_bind_host"`
+	// HistogramAggregates and HistogramPercentiles select what the histograms
+	// emit, metric.DefaultHistogramAggregates and
+	// metric.DefaultHistogramPercentiles are used when they are empty.
+	HistogramAggregates  []string  `toml:"histogram_aggregates"`
+	HistogramPercentiles []float64 `toml:"histogram_percentiles"`
+	// CloudMetadata queries the metadata endpoints of EC2, GCE, Azure and
+	// Aliyun for the instance id, type, region and zone host tags.
+	CloudMetadata bool `toml:"cloud_metadata"`
+	// HostMetadata posts the facts of the host, its OS, CPUs, memory,
+	// addresses and tags, every HostMetadataInterval seconds.
+	HostMetadata         bool `toml:"host_metadata"`
+	HostMetadataInterval int  `toml:"host_metadata_interval"`
+	// ProcessInventory posts the ProcessInventoryTop processes using the
+	// most CPU and the ones using the most memory every
+	// ProcessInventoryInterval seconds. The values of the arguments named
+	// after a word of ProcessScrubWords, or of the default ones like
+	// password, are scrubbed from their command lines.
+	ProcessInventory         bool     `toml:"process_inventory"`
+	ProcessInventoryInterval int      `toml:"process_inventory_interval"`
+	ProcessInventoryTop      int      `toml:"process_inventory_top"`
+	ProcessScrubWords        []string `toml:"process_scrub_words"`
+	// NetworkConnections posts the number of TCP and UDP connections
+	// between the addresses, by process, every NetworkConnectionsInterval
+	// seconds.
+	NetworkConnections         bool `toml:"network_connections"`
+	NetworkConnectionsInterval int  `toml:"network_connections_interval"`
+	// CloudinsightOutput posts the metrics, events and service checks to
+	// Cloudinsight, it may be turned off to only write to the outputs.
+	CloudinsightOutput bool `toml:"cloudinsight_output"`
+	// CollectInterval is the number of seconds between the runs of the checks
+	// and the submissions of their metrics.
+	CollectInterval int `toml:"collect_interval"`
+	// StatsdFlushInterval is the number of seconds between the flushes and
+	// the submissions of the Statsd metrics.
+	StatsdFlushInterval int `toml:"statsd_flush_interval"`
+	// FlushJitter delays the first submission by up to that many seconds, so
+	// that the agents started together don't submit at the same time.
+	FlushJitter int `toml:"flush_jitter"`
+	// LateSampleWindow is the number of seconds a sample submitted with the
+	// timestamp of a previous interval is accepted, it's aggregated with the
+	// samples of that interval. 0 stamps them with the flush time.
+	LateSampleWindow int `toml:"late_sample_window"`
+	// ContextLimit caps the number of unique metric contexts (name, tags,
+	// host and device) each aggregator holds, 0 means unlimited.
+	ContextLimit int `toml:"context_limit"`
+	// ForwarderQueuePath is the directory where the Forwarder keeps the
+	// payloads it couldn't post until they're retried, they're kept in
+	// memory when it's empty.
+	ForwarderQueuePath string `toml:"forwarder_queue_path"`
+	// SpoolPath is the directory where the Forwarder writes the payloads
+	// instead of posting them, for the hosts without a network access to
+	// Cloudinsight. They're posted later by the import command.
+	SpoolPath string `toml:"spool_path"`
+	// SpoolMaxSize is the size of the spool in megabytes, the oldest
+	// payloads are dropped above it. 0 means unlimited.
+	SpoolMaxSize int `toml:"spool_max_size"`
+	// ForwarderQueueMaxSize is the size of the queue in megabytes, the
+	// oldest payloads are dropped above it.
+	ForwarderQueueMaxSize int `toml:"forwarder_queue_max_size"`
+	// ForwarderQueueMaxAge is the number of hours a payload is kept.
+	ForwarderQueueMaxAge int `toml:"forwarder_queue_max_age"`
+	// ForwarderBackoffBase and ForwarderBackoffMax are the number of seconds
+	// an endpoint isn't retried after its first failure, the delay doubles
+	// at every consecutive failure up to ForwarderBackoffMax.
+	ForwarderBackoffBase int `toml:"forwarder_backoff_base"`
+	ForwarderBackoffMax  int `toml:"forwarder_backoff_max"`
+	// ForwarderRetryPolicy is the order the queued payloads are retried in,
+	// "oldest_first" (the default) or "newest_first". The payloads carrying
+	// the host metadata go first either way.
+	ForwarderRetryPolicy string `toml:"forwarder_retry_policy"`
+	// CompressionLevel is the zlib level of the payloads, from 1 (fastest)
+	// to 9 (smallest), 0 uses the default level.
+	CompressionLevel int `toml:"compression_level"`
+	// MaxPayloadSize is the maximum size of a compressed payload in bytes,
+	// the larger batches are split. 0 uses the limit of Cloudinsight.
+	MaxPayloadSize int `toml:"max_payload_size"`
+	// SecretBackendCommand is the executable resolving the SECRET[handle]
+	// placeholders of the configuration files, with SecretBackendArguments.
+	// SecretBackendTimeout is the number of seconds it has to answer.
+	SecretBackendCommand   string   `toml:"secret_backend_command"`
+	SecretBackendArguments []string `toml:"secret_backend_arguments"`
+	SecretBackendTimeout   int      `toml:"secret_backend_timeout"`
+	// SecretKeyFile is the key file decrypting the ENC[...] values,
+	// DefaultSecretKeyFile when empty.
+	SecretKeyFile string `toml:"secret_key_file"`
+	// KVConsulURL, KVEtcdURL and KVZookeeperAddress are the key-value
+	// stores of the KV[<store>://<key>] placeholders of the checks, the
+	// local agents of the stores are used by default.
+	KVConsulURL        string `toml:"kv_consul_url"`
+	KVConsulToken      string `toml:"kv_consul_token"`
+	KVEtcdURL          string `toml:"kv_etcd_url"`
+	KVZookeeperAddress string `toml:"kv_zookeeper_address"`
+	// RemoteConfigURL is polled every RemoteConfigInterval seconds for the
+	// configurations of the checks, they're stored in RemoteConfigPath and
+	// loaded along with the ones of conf.d.
+	RemoteConfigURL      string `toml:"remote_config_url"`
+	RemoteConfigPath     string `toml:"remote_config_path"`
+	RemoteConfigInterval int    `toml:"remote_config_interval"`
+	// ControlSocket is the unix socket of the control API, ControlPort a
+	// port of 127.0.0.1 serving it to the clients sending the token of
+	// ControlTokenFile, DefaultControlTokenFile when empty.
+	ControlSocket    string `toml:"control_socket"`
+	ControlPort      int    `toml:"control_port"`
+	ControlTokenFile string `toml:"control_token_file"`
+	// EnableProfiling serves the runtime profiles of net/http/pprof on the
+	// control API.
+	EnableProfiling bool `toml:"enable_profiling"`
+	// RunAsUser and RunAsGroup are the user and the group the agent
+	// switches to once it bound the privileged ports, when it's started as
+	// root. RunAsGroup defaults to the primary group of the user.
+	RunAsUser  string `toml:"run_as_user"`
+	RunAsGroup string `toml:"run_as_group"`
+	// MaxProcs, GCPercent and MemoryLimit bound the resources of the agent
+	// itself: the CPUs running it at the same time, the growth of the heap
+	// triggering a garbage collection and the memory in MB above which the
+	// Statsd packets are dropped. 0 keeps the defaults of the Go runtime, and
+	// doesn't limit the memory.
+	MaxProcs    int `toml:"max_procs"`
+	GCPercent   int `toml:"gc_percent"`
+	MemoryLimit int `toml:"memory_limit"`
+	// UpgradeURL serves the latest release of the agent to the upgrade
+	// subcommand, signed with the ed25519 key whose base64 public key is
+	// UpgradePublicKey.
+	UpgradeURL       string `toml:"upgrade_url"`
+	UpgradePublicKey string `toml:"upgrade_public_key"`
+	// PayloadFormat is the serialization of the payloads, "json" (the
+	// default) or "msgpack". The Forwarder falls back to JSON when the
+	// intake doesn't accept MessagePack.
+	PayloadFormat string `toml:"payload_format"`
+	// HTTPProxy and HTTPSProxy are the proxies of the requests sent by the
+	// Forwarder and the checks, NoProxy lists the hosts reached directly.
+	// The HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are
+	// used when neither proxy is set.
+	HTTPProxy  string   `toml:"http_proxy"`
+	HTTPSProxy string   `toml:"https_proxy"`
+	NoProxy    []string `toml:"no_proxy"`
+	// ForwarderCACerts is a PEM bundle of the CAs trusted to verify the
+	// certificate of Cloudinsight, instead of the system ones.
+	ForwarderCACerts string `toml:"forwarder_ca_certs"`
+	// ForwarderClientCert and ForwarderClientKey are the certificate the
+	// Forwarder presents to an intake requiring mutual TLS.
+	ForwarderClientCert string `toml:"forwarder_client_cert"`
+	ForwarderClientKey  string `toml:"forwarder_client_key"`
+	// ForwarderMinTLSVersion is the lowest TLS version the Forwarder
+	// accepts, "1.0" to "1.3".
+	ForwarderMinTLSVersion string `toml:"forwarder_min_tls_version"`
+}
+
+// StatsdMapping rewrites the statsd metrics whose name matches Match into
+// the metric Name with the given Tags. Name and the tag values can refer to
+// the wildcards of a glob ("api.*.*.count") or the groups of a regex as $1,
+// $2, etc.
+type StatsdMapping struct {
+	Match     string            `toml:"match"`
+	MatchType string            `toml:"match_type"`
+	Name      string            `toml:"name"`
+	Tags      map[string]string `toml:"tags"`
+}
+
+// HistogramConfig sets the aggregates and percentiles of the histograms whose
+// name starts with Prefix.
+type HistogramConfig struct {
+	Prefix      string    `toml:"prefix"`
+	Aggregates  []string  `toml:"aggregates"`
+	Percentiles []float64 `toml:"percentiles"`
+}
+
+// PipelineConfig runs the checks listed in Checks in a pipeline of their
+// own: their metrics are tagged with Tags instead of the global tags and
+// posted with LicenseKey, e.g. to the account of another team sharing the
+// host.
+type PipelineConfig struct {
+	Name       string   `toml:"name"`
+	LicenseKey string   `toml:"license_key"`
+	Tags       TagList  `toml:"tags"`
+	Checks     []string `toml:"checks"`
 }
 
 // LoggingConfig XXX
@@ -93,19 +448,55 @@ func getDefaultConfigPath() (string, error) {
 		" in %s, or %s", etcfile, homefile)
 }
 
+// Path returns confPath, or the default configuration file when it's empty.
+func Path(confPath string) (string, error) {
+	if confPath != "" {
+		return confPath, nil
+	}
+	return getDefaultConfigPath()
+}
+
 // LoadConfig XXX
 func (c *Config) LoadConfig(confPath string) error {
-	var err error
-	if confPath == "" {
-		if confPath, err = getDefaultConfigPath(); err != nil {
-			return err
-		}
+	confPath, err := Path(confPath)
+	if err != nil {
+		return err
 	}
 
-	if _, err = toml.DecodeFile(confPath, c); err != nil {
+	if err = c.decodeFile(confPath); err != nil {
 		return err
 	}
 
+	// The included files override the main file, in order.
+	includes := c.Include
+	for _, pattern := range includes {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(confPath), pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid include %s: %s", confPath, pattern, err)
+		}
+		for _, file := range files {
+			c.Include = nil
+			if err = c.decodeFile(file); err != nil {
+				return err
+			}
+			if len(c.Include) > 0 {
+				return fmt.Errorf("%s: include is only supported in the main config file", file)
+			}
+		}
+	}
+	c.Include = includes
+
+	// The ENC[...] values and the SECRET[handle] placeholders are resolved
+	// in the decoded values, with the settings of the last file setting
+	// them.
+	c.setResolvers()
+	if err = secrets.Resolve(c); err != nil {
+		return fmt.Errorf("%s: %s", confPath, err)
+	}
+
 	patterns := [2]string{"*.yaml", "*.yaml.default"}
 	var files []string
 	root, err := os.Getwd()
@@ -117,6 +508,11 @@ func (c *Config) LoadConfig(confPath string) error {
 		m, _ := filepath.Glob(filepath.Join(root, "collector/conf.d", pattern))
 		files = append(files, m...)
 	}
+	// The configurations fetched from remote_config_url.
+	if dir := c.GlobalConfig.RemoteConfigPath; dir != "" {
+		m, _ := filepath.Glob(filepath.Join(dir, "*.yaml"))
+		files = append(files, m...)
+	}
 
 	for _, file := range files {
 		pluginConfig, err := plugin.LoadConfig(file)
@@ -134,15 +530,121 @@ func (c *Config) LoadConfig(confPath string) error {
 		}
 	}
 
+	// The conf.d/<check>.d directories hold several files of a check.
+	dirs, _ := filepath.Glob(filepath.Join(root, "collector/conf.d", "*.d"))
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		pluginConfig, err := plugin.LoadConfigDir(dir)
+		if err != nil {
+			log.Errorf("Failed to parse Plugin Config %s: %s", dir, err)
+			continue
+		}
+
+		pluginName := strings.TrimSuffix(path.Base(dir), ".d")
+		if err = c.addPlugin(pluginName, pluginConfig); err != nil {
+			log.Errorf("Failed to load Plugin %s: %s", pluginName, err)
+			continue
+		}
+	}
+
+	return nil
+}
+
+// decodeFile decodes a configuration file into c, the options it sets
+// override the ones of the files decoded before, except the arrays of tables
+// like [[histogram]] or [[output.<name>]] which are appended.
+func (c *Config) decodeFile(confPath string) error {
+	content, err := ioutil.ReadFile(confPath)
+	if err != nil {
+		return err
+	}
+	if content, err = util.ExpandEnv(content); err != nil {
+		return fmt.Errorf("%s: %s", confPath, err)
+	}
+
+	mappings, histograms, transforms, outputs, pipelines := c.StatsdMappings, c.Histograms, c.Transforms, c.Outputs, c.Pipelines
+	c.StatsdMappings, c.Histograms, c.Transforms, c.Outputs, c.Pipelines = nil, nil, nil, nil, nil
+	md, err := toml.Decode(string(content), c)
+	if err != nil {
+		if typeErr := checkTypes(md); typeErr != nil {
+			err = typeErr
+		}
+		return fmt.Errorf("%s: %s", confPath, err)
+	}
+	if err = checkUndecoded(md); err != nil {
+		return fmt.Errorf("%s: %s", confPath, err)
+	}
+
+	c.StatsdMappings = append(mappings, c.StatsdMappings...)
+	c.Histograms = append(histograms, c.Histograms...)
+	c.Transforms = append(transforms, c.Transforms...)
+	c.Pipelines = append(pipelines, c.Pipelines...)
+	for name, sections := range c.Outputs {
+		outputs = appendOutputs(outputs, name, sections)
+	}
+	c.Outputs = outputs
 	return nil
 }
 
+func appendOutputs(outputs map[string][]toml.Primitive, name string, sections []toml.Primitive) map[string][]toml.Primitive {
+	if outputs == nil {
+		outputs = make(map[string][]toml.Primitive)
+	}
+	outputs[name] = append(outputs[name], sections...)
+	return outputs
+}
+
+// GetControlTokenFile gets the token file of the control API.
+func (c *Config) GetControlTokenFile() string {
+	if c.GlobalConfig.ControlTokenFile == "" {
+		return DefaultControlTokenFile
+	}
+	return c.GlobalConfig.ControlTokenFile
+}
+
+// GetSecretKeyFile gets the key file decrypting the ENC[...] values.
+func (c *Config) GetSecretKeyFile() string {
+	if c.GlobalConfig.SecretKeyFile == "" {
+		return DefaultSecretKeyFile
+	}
+	return c.GlobalConfig.SecretKeyFile
+}
+
+// setResolvers sets the secret backend, the keyring and the key-value stores
+// the placeholders of the configuration files are resolved with.
+func (c *Config) setResolvers() {
+	g := c.GlobalConfig
+	var backend *secrets.Backend
+	if g.SecretBackendCommand != "" {
+		backend = &secrets.Backend{
+			Command: g.SecretBackendCommand,
+			Args:    g.SecretBackendArguments,
+			Timeout: time.Duration(g.SecretBackendTimeout) * time.Second,
+		}
+	}
+	secrets.Set(backend)
+	secrets.SetKeyring(&secrets.Keyring{Path: c.GetSecretKeyFile()})
+
+	kv.Set(kv.NewResolver(kv.Settings{
+		ConsulURL:        g.KVConsulURL,
+		ConsulToken:      g.KVConsulToken,
+		EtcdURL:          g.KVEtcdURL,
+		ZookeeperAddress: g.KVZookeeperAddress,
+	}))
+}
+
 func (c *Config) addPlugin(name string, pluginConfig *plugin.Config) error {
 	checker, ok := collector.Plugins[name]
 	if !ok {
 		return fmt.Errorf("Undefined plugin: %s", name)
 	}
 
+	if _, err := c.NewFilter(pluginConfig.Filter); err != nil {
+		return err
+	}
+
 	rp := &plugin.RunningPlugin{
 		Name:   name,
 		Plugin: checker(pluginConfig.InitConfig),
@@ -161,6 +663,118 @@ func (c *Config) PluginNames() []string {
 	return name
 }
 
+func (c *Config) validateHistograms() error {
+	global := c.GlobalConfig
+	if err := metric.ValidateHistogram(global.HistogramAggregates, global.HistogramPercentiles); err != nil {
+		return err
+	}
+	for _, h := range c.Histograms {
+		if h.Prefix == "" {
+			return fmt.Errorf("histogram requires a prefix")
+		}
+		if err := metric.ValidateHistogram(h.Aggregates, h.Percentiles); err != nil {
+			return fmt.Errorf("histogram %s: %s", h.Prefix, err)
+		}
+	}
+	return nil
+}
+
+func (c *Config) validatePipelines() error {
+	if len(c.Pipelines) > 0 && !c.GlobalConfig.CloudinsightOutput {
+		return fmt.Errorf("pipeline requires cloudinsight_output")
+	}
+	names := make(map[string]bool, len(c.Pipelines))
+	owners := make(map[string]string)
+	for _, p := range c.Pipelines {
+		if p.Name == "" {
+			return fmt.Errorf("pipeline requires a name")
+		}
+		if names[p.Name] {
+			return fmt.Errorf("pipeline %s is defined twice", p.Name)
+		}
+		names[p.Name] = true
+		if p.LicenseKey == "" {
+			return fmt.Errorf("pipeline %s requires a license_key", p.Name)
+		}
+		if len(p.Checks) == 0 {
+			return fmt.Errorf("pipeline %s requires checks", p.Name)
+		}
+		for _, check := range p.Checks {
+			if _, ok := collector.Plugins[check]; !ok {
+				return fmt.Errorf("pipeline %s: undefined plugin %s", p.Name, check)
+			}
+			if owner, ok := owners[check]; ok {
+				return fmt.Errorf("pipeline %s: check %s already runs in pipeline %s", p.Name, check, owner)
+			}
+			owners[check] = p.Name
+		}
+	}
+	return nil
+}
+
+// SplitPipelines returns the configuration of every pipeline, the main one
+// running the checks of no [[pipeline]] followed by the ones of Pipelines.
+// The configurations of Pipelines are copies of c with their license key,
+// tags and checks, without the outputs which are written by the main one.
+func (c *Config) SplitPipelines() []*Config {
+	main := *c
+	main.Plugins = nil
+	configs := []*Config{&main}
+	// owners maps the checks of Pipelines to the index of their pipeline.
+	owners := make(map[string]int)
+	for _, p := range c.Pipelines {
+		pc := *c
+		pc.pipeline = p.Name
+		pc.GlobalConfig.LicenseKey = p.LicenseKey
+		pc.GlobalConfig.Tags = p.Tags
+		pc.Outputs = nil
+		pc.Plugins = nil
+		for _, check := range p.Checks {
+			owners[check] = len(configs)
+		}
+		configs = append(configs, &pc)
+	}
+	for _, rp := range c.Plugins {
+		i := owners[rp.Name]
+		configs[i].Plugins = append(configs[i].Plugins, rp)
+	}
+	return configs
+}
+
+// PipelineName returns the name of the pipeline of a configuration returned
+// by SplitPipelines, it's empty for the main one.
+func (c *Config) PipelineName() string {
+	return c.pipeline
+}
+
+// HistogramPrefixes returns the per prefix histogram settings for the
+// aggregators.
+func (c *Config) HistogramPrefixes() []metric.HistogramPrefix {
+	var prefixes []metric.HistogramPrefix
+	for _, h := range c.Histograms {
+		prefixes = append(prefixes, metric.HistogramPrefix{
+			Prefix:      h.Prefix,
+			Aggregates:  h.Aggregates,
+			Percentiles: h.Percentiles,
+		})
+	}
+	return prefixes
+}
+
+// NewFilter compiles the global filter followed by the filters of a check.
+func (c *Config) NewFilter(check ...metric.FilterConfig) (*metric.Filter, error) {
+	return metric.NewFilter(append([]metric.FilterConfig{c.Filter}, check...)...)
+}
+
+// Transformer compiles the transform rules, which are validated by NewConfig.
+func (c *Config) Transformer() *metric.Transformer {
+	t, err := metric.NewTransformer(c.Transforms)
+	if err != nil {
+		log.Errorf("Failed to load the transform rules: %s", err)
+	}
+	return t
+}
+
 // GetForwarderAddr gets the address that Forwarder listening to.
 func (c *Config) GetForwarderAddr() string {
 	return fmt.Sprintf("%s:%d", c.GlobalConfig.BindHost, c.GlobalConfig.ListenPort)
@@ -176,19 +790,219 @@ func (c *Config) GetStatsdAddr() string {
 	return fmt.Sprintf("%s:%d", c.GlobalConfig.BindHost, c.GlobalConfig.StatsdPort)
 }
 
-// GetHostname gets the hostname from os itself if not set in the agent configuration.
-func (c *Config) GetHostname() string {
-	hostname := c.GlobalConfig.Hostname
-	if hostname != "" {
-		return hostname
+// GetStatsdTCPAddr gets the address that the TCP listener of Statsd listening to.
+func (c *Config) GetStatsdTCPAddr() string {
+	return fmt.Sprintf("%s:%d", c.GlobalConfig.BindHost, c.GlobalConfig.StatsdTCPPort)
+}
+
+// GetPrometheusAddr gets the address that the Prometheus endpoint listening to.
+func (c *Config) GetPrometheusAddr() string {
+	host := c.GlobalConfig.PrometheusBindHost
+	if host == "" {
+		host = c.GlobalConfig.BindHost
+	}
+	return fmt.Sprintf("%s:%d", host, c.GlobalConfig.PrometheusPort)
+}
+
+// Limits returns the limits of the resources of the agent.
+func (c *Config) Limits() limits.Limits {
+	return limits.Limits{
+		MaxProcs:    c.GlobalConfig.MaxProcs,
+		GCPercent:   c.GlobalConfig.GCPercent,
+		MemoryLimit: int64(c.GlobalConfig.MemoryLimit) << 20,
+	}
+}
+
+// GetHostMetadataInterval gets the interval between two host metadata
+// payloads.
+func (c *Config) GetHostMetadataInterval() time.Duration {
+	return intervalOrDefault(c.GlobalConfig.HostMetadataInterval, DefaultGlobalConfig.HostMetadataInterval)
+}
+
+// GetProcessInventoryInterval gets the interval between two process
+// inventory payloads.
+func (c *Config) GetProcessInventoryInterval() time.Duration {
+	return intervalOrDefault(c.GlobalConfig.ProcessInventoryInterval, DefaultGlobalConfig.ProcessInventoryInterval)
+}
+
+// GetProcessInventoryTop gets the number of processes reported by CPU and by
+// memory usage.
+func (c *Config) GetProcessInventoryTop() int {
+	if c.GlobalConfig.ProcessInventoryTop <= 0 {
+		return DefaultGlobalConfig.ProcessInventoryTop
+	}
+	return c.GlobalConfig.ProcessInventoryTop
+}
+
+// GetNetworkConnectionsInterval gets the interval between two network
+// connections payloads.
+func (c *Config) GetNetworkConnectionsInterval() time.Duration {
+	return intervalOrDefault(c.GlobalConfig.NetworkConnectionsInterval, DefaultGlobalConfig.NetworkConnectionsInterval)
+}
+
+// GetControlAddr gets the address that the control API listening to, with
+// the token.
+func (c *Config) GetControlAddr() string {
+	return fmt.Sprintf("127.0.0.1:%d", c.GlobalConfig.ControlPort)
+}
+
+// GetCollectInterval gets the interval of the checks.
+func (c *Config) GetCollectInterval() time.Duration {
+	return intervalOrDefault(c.GlobalConfig.CollectInterval, DefaultGlobalConfig.CollectInterval)
+}
+
+// GetStatsdFlushInterval gets the flush interval of Statsd.
+func (c *Config) GetStatsdFlushInterval() time.Duration {
+	return intervalOrDefault(c.GlobalConfig.StatsdFlushInterval, DefaultGlobalConfig.StatsdFlushInterval)
+}
+
+// GetFlushJitter gets the maximum delay of the first submission.
+func (c *Config) GetFlushJitter() time.Duration {
+	if c.GlobalConfig.FlushJitter <= 0 {
+		return 0
+	}
+	return time.Duration(c.GlobalConfig.FlushJitter) * time.Second
+}
+
+// GetForwarderQueueMaxSize gets the size of the queue of the Forwarder in bytes.
+func (c *Config) GetForwarderQueueMaxSize() int64 {
+	size := c.GlobalConfig.ForwarderQueueMaxSize
+	if size <= 0 {
+		size = DefaultGlobalConfig.ForwarderQueueMaxSize
+	}
+	return int64(size) << 20
+}
+
+// GetForwarderQueueMaxAge gets how long the Forwarder keeps the queued payloads.
+func (c *Config) GetForwarderQueueMaxAge() time.Duration {
+	age := c.GlobalConfig.ForwarderQueueMaxAge
+	if age <= 0 {
+		age = DefaultGlobalConfig.ForwarderQueueMaxAge
+	}
+	return time.Duration(age) * time.Hour
+}
+
+// GetForwarderBackoffBase gets the delay before the first retry of an endpoint.
+func (c *Config) GetForwarderBackoffBase() time.Duration {
+	return intervalOrDefault(c.GlobalConfig.ForwarderBackoffBase, DefaultGlobalConfig.ForwarderBackoffBase)
+}
+
+// GetForwarderBackoffMax gets the maximum delay between the retries of an endpoint.
+func (c *Config) GetForwarderBackoffMax() time.Duration {
+	return intervalOrDefault(c.GlobalConfig.ForwarderBackoffMax, DefaultGlobalConfig.ForwarderBackoffMax)
+}
+
+func intervalOrDefault(seconds, defaultSeconds int) time.Duration {
+	if seconds <= 0 {
+		seconds = defaultSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// GetRemoteConfigInterval gets the interval between two polls of the remote
+// configuration.
+func (c *Config) GetRemoteConfigInterval() time.Duration {
+	return intervalOrDefault(c.GlobalConfig.RemoteConfigInterval, DefaultGlobalConfig.RemoteConfigInterval)
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ForwarderTLSConfig returns the TLS options of the requests the Forwarder
+// sends to Cloudinsight.
+func (c *Config) ForwarderTLSConfig() (*tls.Config, error) {
+	httpConfig := plugin.HTTPConfig{
+		CACerts:    c.GlobalConfig.ForwarderCACerts,
+		ClientCert: c.GlobalConfig.ForwarderClientCert,
+		ClientKey:  c.GlobalConfig.ForwarderClientKey,
+	}
+	tlsConfig, err := httpConfig.TLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid Forwarder TLS options: %s", err)
+	}
+
+	if version := c.GlobalConfig.ForwarderMinTLSVersion; version != "" {
+		min, ok := tlsVersions[version]
+		if !ok {
+			return nil, fmt.Errorf("invalid forwarder_min_tls_version %s, expected 1.0, 1.1, 1.2 or 1.3", version)
+		}
+		tlsConfig.MinVersion = min
+	}
+	return tlsConfig, nil
+}
+
+// NewOutputs creates the outputs the metrics are written to, in addition to
+// Cloudinsight, including the store of the Prometheus endpoint when enabled.
+func (c *Config) NewOutputs() ([]*output.RunningOutput, error) {
+	var outputs []*output.RunningOutput
+	if c.GlobalConfig.PrometheusPort != 0 {
+		outputs = append(outputs, output.NewRunningOutput("prometheus", prometheus.Default, output.RunningConfig{}))
+	}
+	for name, sections := range c.Outputs {
+		creator, ok := output.Outputs[name]
+		if !ok {
+			closeOutputs(outputs)
+			return nil, fmt.Errorf("unknown output %s", name)
+		}
+
+		for _, section := range sections {
+			section := section
+			o, err := creator(func(v interface{}) error {
+				if err := toml.PrimitiveDecode(section, v); err != nil {
+					return err
+				}
+				return secrets.Resolve(v)
+			})
+			if err != nil {
+				closeOutputs(outputs)
+				return nil, fmt.Errorf("invalid output %s: %s", name, err)
+			}
+
+			var running output.RunningConfig
+			if err = toml.PrimitiveDecode(section, &running); err != nil {
+				_ = o.Close()
+				closeOutputs(outputs)
+				return nil, fmt.Errorf("invalid output %s: %s", name, err)
+			}
+			outputs = append(outputs, output.NewRunningOutput(name, o, running))
+		}
 	}
+	return outputs, nil
+}
 
-	var err error
-	hostname, err = os.Hostname()
+func closeOutputs(outputs []*output.RunningOutput) {
+	for _, o := range outputs {
+		_ = o.Close()
+	}
+}
+
+// ProxySettings returns the proxy options of the agent.
+func (c *Config) ProxySettings() proxy.Settings {
+	return proxy.Settings{
+		HTTP:    c.GlobalConfig.HTTPProxy,
+		HTTPS:   c.GlobalConfig.HTTPSProxy,
+		NoProxy: c.GlobalConfig.NoProxy,
+	}
+}
+
+// InitializeProxy makes the Forwarder and the checks use the configured proxy.
+func (c *Config) InitializeProxy() error {
+	settings := c.ProxySettings()
+	if settings.IsEmpty() {
+		proxy.Set(nil)
+		return nil
+	}
+
+	p, err := proxy.New(settings)
 	if err != nil {
-		log.Error(err)
+		return err
 	}
-	return hostname
+	proxy.Set(p)
+	return nil
 }
 
 //InitializeLogging initializes logging level and output according to the agent configuration.
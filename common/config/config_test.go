@@ -1,11 +1,23 @@
 package config
 
 import (
+	"crypto/tls"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
+	"github.com/BurntSushi/toml"
+	"github.com/cloudinsight/cloudinsight-agent/collector"
 	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/secrets"
+	"github.com/cloudinsight/cloudinsight-agent/output"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -19,15 +31,61 @@ func TestLoadConfig(t *testing.T) {
 			CiURL:      "https://dc-cloud.oneapm.com",
 			LicenseKey: "test",
 			Hostname:   "test",
-			Tags:       "mytag, env:prod, role:database",
+			Tags:       TagList{"mytag", "env:prod", "role:database"},
 			BindHost:   "localhost",
 			ListenPort: 9999,
 			StatsdPort: 8125,
+
+			HistogramPercentiles: []float64{0.95, 0.99},
+			ContextLimit:         10000,
+
+			CloudMetadata:      true,
+			CloudinsightOutput: true,
+
+			CollectInterval:     15,
+			StatsdFlushInterval: 10,
+			LateSampleWindow:    60,
+
+			ForwarderQueueMaxSize: 100,
+			ForwarderQueueMaxAge:  24,
+			ForwarderBackoffBase:  2,
+			ForwarderBackoffMax:   300,
+
+			RemoteConfigInterval: 60,
+
+			HostMetadata:         true,
+			HostMetadataInterval: 1800,
+
+			ProcessInventoryInterval: 60,
+			ProcessInventoryTop:      10,
+
+			NetworkConnectionsInterval: 60,
 		},
 		LoggingConfig: LoggingConfig{
 			LogLevel: "debug",
 			LogFile:  "/tmp/cloudinsight-agent-testing.log",
 		},
+		Histograms: []HistogramConfig{
+			{
+				Prefix:      "http.latency",
+				Aggregates:  []string{"max", "avg"},
+				Percentiles: []float64{0.999},
+			},
+		},
+		StatsdMappings: []StatsdMapping{
+			{
+				Match: "api.*.count",
+				Name:  "api.requests",
+				Tags:  map[string]string{"endpoint": "$1"},
+			},
+		},
+		Filter: metric.FilterConfig{
+			MetricExclude: []string{"debug.*"},
+			TagExclude:    []string{"request_id"},
+		},
+		Transforms: []metric.TransformRule{
+			{Match: "system.mem.*", Rename: "mem.$1", Scale: 0.001},
+		},
 	}
 	assert.Equal(t, expectedConf, conf)
 }
@@ -69,6 +127,15 @@ func TestGetStatsdAddr(t *testing.T) {
 	assert.Equal(t, expectedAddr, conf.GetStatsdAddr())
 }
 
+func TestGetPrometheusAddr(t *testing.T) {
+	conf, _ := NewConfig("testdata/cloudinsight-agent.conf")
+	conf.GlobalConfig.PrometheusPort = 9101
+	assert.Equal(t, "localhost:9101", conf.GetPrometheusAddr())
+
+	conf.GlobalConfig.PrometheusBindHost = "0.0.0.0"
+	assert.Equal(t, "0.0.0.0:9101", conf.GetPrometheusAddr())
+}
+
 func TestInitializeLogging(t *testing.T) {
 	conf, _ := NewConfig("testdata/cloudinsight-agent.conf")
 	_ = conf.InitializeLogging()
@@ -82,3 +149,320 @@ func TestInitializeLogging(t *testing.T) {
 	msg := `level=debug msg="This debug-level line should show up in the output."`
 	assert.Contains(t, string(data), msg)
 }
+
+func TestValidateHistograms(t *testing.T) {
+	conf := &Config{}
+	assert.NoError(t, conf.validateHistograms())
+
+	conf.GlobalConfig.HistogramPercentiles = []float64{95}
+	assert.Error(t, conf.validateHistograms())
+
+	conf.GlobalConfig.HistogramPercentiles = nil
+	conf.Histograms = []HistogramConfig{{Prefix: "http", Aggregates: []string{"p99"}}}
+	assert.Error(t, conf.validateHistograms())
+
+	conf.Histograms = []HistogramConfig{{Aggregates: []string{"max"}}}
+	assert.Error(t, conf.validateHistograms())
+}
+
+func TestNewFilter(t *testing.T) {
+	conf := &Config{}
+	filter, err := conf.NewFilter()
+	assert.NoError(t, err)
+	assert.Nil(t, filter)
+
+	conf.Filter.MetricExclude = []string{"debug.*"}
+	filter, err = conf.NewFilter(metric.FilterConfig{MetricInclude: []string{"nginx.*"}})
+	assert.NoError(t, err)
+	m := metric.NewMetric("nginx.net.reading", 1)
+	assert.True(t, filter.Apply(&m))
+	m = metric.NewMetric("system.load.1", 1)
+	assert.False(t, filter.Apply(&m))
+
+	_, err = conf.NewFilter(metric.FilterConfig{MetricInclude: []string{"/(/"}})
+	assert.Error(t, err)
+}
+
+func TestPipelines(t *testing.T) {
+	collector.Add("pipeline_a", func(plugin.InitConfig) plugin.Plugin { return nil })
+	collector.Add("pipeline_b", func(plugin.InitConfig) plugin.Plugin { return nil })
+
+	conf := &Config{GlobalConfig: DefaultGlobalConfig}
+	conf.GlobalConfig.LicenseKey = "main"
+	conf.GlobalConfig.Tags = TagList{"env:prod"}
+	for content, want := range map[string]string{
+		`[[pipeline]]
+license_key = "k"
+checks = ["pipeline_a"]`: "pipeline requires a name",
+		`[[pipeline]]
+name = "team"
+checks = ["pipeline_a"]`: "pipeline team requires a license_key",
+		`[[pipeline]]
+name = "team"
+license_key = "k"`: "pipeline team requires checks",
+		`[[pipeline]]
+name = "team"
+license_key = "k"
+checks = ["missing"]`: "pipeline team: undefined plugin missing",
+		`[[pipeline]]
+name = "team"
+license_key = "k"
+checks = ["pipeline_a"]
+[[pipeline]]
+name = "other"
+license_key = "k2"
+checks = ["pipeline_a"]`: "pipeline other: check pipeline_a already runs in pipeline team",
+	} {
+		c := *conf
+		_, err := toml.Decode(content, &c)
+		require.NoError(t, err)
+		assert.EqualError(t, c.validatePipelines(), want)
+	}
+
+	conf.Pipelines = []PipelineConfig{{Name: "team", LicenseKey: "team-key", Tags: TagList{"team:db"}, Checks: []string{"pipeline_b"}}}
+	assert.NoError(t, conf.validatePipelines())
+	a := &plugin.RunningPlugin{Name: "pipeline_a"}
+	b := &plugin.RunningPlugin{Name: "pipeline_b"}
+	conf.Plugins = []*plugin.RunningPlugin{a, b}
+
+	configs := conf.SplitPipelines()
+	require.Len(t, configs, 2)
+	assert.Equal(t, "", configs[0].PipelineName())
+	assert.Equal(t, "main", configs[0].GlobalConfig.LicenseKey)
+	assert.Equal(t, TagList{"env:prod"}, configs[0].GlobalConfig.Tags)
+	assert.Equal(t, []*plugin.RunningPlugin{a}, configs[0].Plugins)
+	assert.Equal(t, "team", configs[1].PipelineName())
+	assert.Equal(t, "team-key", configs[1].GlobalConfig.LicenseKey)
+	assert.Equal(t, TagList{"team:db"}, configs[1].GlobalConfig.Tags)
+	assert.Equal(t, []*plugin.RunningPlugin{b}, configs[1].Plugins)
+	// The configuration itself is left alone.
+	assert.Equal(t, []*plugin.RunningPlugin{a, b}, conf.Plugins)
+
+	conf.GlobalConfig.CloudinsightOutput = false
+	assert.EqualError(t, conf.validatePipelines(), "pipeline requires cloudinsight_output")
+}
+
+func TestTagList(t *testing.T) {
+	_ = os.Setenv("CI_TEST_ENV", "prod")
+	defer func() {
+		_ = os.Unsetenv("CI_TEST_ENV")
+	}()
+
+	var tags TagList
+	assert.NoError(t, tags.UnmarshalTOML("mytag, env:$CI_TEST_ENV,"))
+	assert.Equal(t, TagList{"mytag", "env:prod"}, tags)
+
+	assert.NoError(t, tags.UnmarshalTOML([]interface{}{"team:payments", "env:${CI_TEST_ENV}"}))
+	assert.Equal(t, TagList{"team:payments", "env:prod"}, tags)
+
+	assert.Error(t, tags.UnmarshalTOML([]interface{}{1}))
+	assert.Error(t, tags.UnmarshalTOML(1))
+}
+
+func TestIntervals(t *testing.T) {
+	conf := &Config{}
+	assert.Equal(t, 30*time.Second, conf.GetCollectInterval())
+	assert.Equal(t, 10*time.Second, conf.GetStatsdFlushInterval())
+	assert.Equal(t, time.Duration(0), conf.GetFlushJitter())
+	assert.Equal(t, 2*time.Second, conf.GetForwarderBackoffBase())
+	assert.Equal(t, 5*time.Minute, conf.GetForwarderBackoffMax())
+
+	conf.GlobalConfig.CollectInterval = 60
+	conf.GlobalConfig.StatsdFlushInterval = 20
+	conf.GlobalConfig.FlushJitter = 3
+	assert.Equal(t, 60*time.Second, conf.GetCollectInterval())
+	assert.Equal(t, 20*time.Second, conf.GetStatsdFlushInterval())
+	assert.Equal(t, 3*time.Second, conf.GetFlushJitter())
+}
+
+func TestForwarderTLSConfig(t *testing.T) {
+	conf := &Config{}
+	tlsConfig, err := conf.ForwarderTLSConfig()
+	assert.NoError(t, err)
+	assert.Nil(t, tlsConfig.RootCAs)
+
+	conf.GlobalConfig.ForwarderMinTLSVersion = "1.2"
+	tlsConfig, err = conf.ForwarderTLSConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+
+	conf.GlobalConfig.ForwarderMinTLSVersion = "1.4"
+	_, err = conf.ForwarderTLSConfig()
+	assert.Error(t, err)
+
+	conf.GlobalConfig.ForwarderMinTLSVersion = ""
+	conf.GlobalConfig.ForwarderCACerts = "testdata/missing.pem"
+	_, err = conf.ForwarderTLSConfig()
+	assert.Error(t, err)
+}
+
+func TestNewOutputs(t *testing.T) {
+	var decoded []string
+	output.Add("test", func(decode func(v interface{}) error) (output.Output, error) {
+		var conf struct {
+			Name string `toml:"name"`
+		}
+		if err := decode(&conf); err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, conf.Name)
+		return nil, nil
+	})
+	defer delete(output.Outputs, "test")
+
+	conf := &Config{}
+	_, err := toml.Decode(`
+[[output.test]]
+name = "first"
+[[output.test]]
+name = "second"
+`, conf)
+	require.NoError(t, err)
+	outputs, err := conf.NewOutputs()
+	require.NoError(t, err)
+	assert.Len(t, outputs, 2)
+	assert.Equal(t, []string{"first", "second"}, decoded)
+
+	conf = &Config{}
+	_, err = toml.Decode(`
+[[output.missing]]
+name = "first"
+`, conf)
+	require.NoError(t, err)
+	_, err = conf.NewOutputs()
+	assert.EqualError(t, err, "unknown output missing")
+}
+
+func TestDisableCloudinsightOutput(t *testing.T) {
+	f, err := ioutil.TempFile("", "cloudinsight-agent.conf")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`
+[global]
+cloudinsight_output = false
+`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// The license key isn't required, an output is.
+	_, err = NewConfig(f.Name())
+	assert.EqualError(t, err, "cloudinsight_output is disabled but no output is configured")
+}
+
+func TestUnknownOptions(t *testing.T) {
+	for content, want := range map[string]string{
+		"[global]\nlicense_key = \"test\"\nhistname = \"web-1\"\n":            "unknown option global.histname, did you mean hostname?",
+		"[globl]\nlicense_key = \"test\"\n":                                   "unknown option globl, did you mean global?",
+		"[global]\nlicense_key = \"test\"\n[[histogram]]\nprefx = \"http\"\n": "unknown option histogram.prefx, did you mean prefix?",
+		"[global]\nlicense_key = \"test\"\ncompletely_unrelated = 1\n":        "unknown option global.completely_unrelated",
+		"[global]\nlicense_key = \"test\"\nlisten_port = \"10010\"\n":         "option global.listen_port must be an integer, got a string",
+		"[global]\nlicense_key = \"test\"\nlisten_port = 100100\n":            "listen_port must be between 0 and 65535, got 100100",
+	} {
+		f, err := ioutil.TempFile("", "cloudinsight-agent.conf")
+		require.NoError(t, err)
+		_, err = f.WriteString(content)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		_, err = NewConfig(f.Name())
+		os.Remove(f.Name())
+		if assert.Error(t, err, content) {
+			assert.Contains(t, err.Error(), want)
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "roles"), 0700))
+
+	for name, content := range map[string]string{
+		"cloudinsight-agent.conf": `include = ["roles/*.conf", "host.conf", "missing/*.conf"]
+
+[global]
+license_key = "test"
+hostname = "base"
+collect_interval = 20
+
+[[histogram]]
+prefix = "http"
+`,
+		"roles/web.conf": `[global]
+hostname = "web"
+tags = ["role:web"]
+
+[[histogram]]
+prefix = "nginx"
+`,
+		"host.conf": `[global]
+hostname = "web-1"
+`,
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+
+	conf, err := NewConfig(filepath.Join(dir, "cloudinsight-agent.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "web-1", conf.GlobalConfig.Hostname)
+	assert.Equal(t, TagList{"role:web"}, conf.GlobalConfig.Tags)
+	assert.Equal(t, 20, conf.GlobalConfig.CollectInterval)
+	assert.Equal(t, []HistogramConfig{{Prefix: "http"}, {Prefix: "nginx"}}, conf.Histograms)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "host.conf"), []byte(`include = ["other.conf"]`), 0600))
+	_, err = NewConfig(filepath.Join(dir, "cloudinsight-agent.conf"))
+	assert.EqualError(t, err, "Failed to load the config file: "+filepath.Join(dir, "host.conf")+": include is only supported in the main config file")
+}
+
+func TestSecrets(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the secret backend is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer secrets.Set(nil)
+	defer secrets.SetKeyring(nil)
+
+	keyFile := filepath.Join(dir, "secret.key")
+	require.NoError(t, secrets.GenerateKey(keyFile))
+	encrypted, err := (&secrets.Keyring{Path: keyFile}).Encrypt("#p\"r\\x\n[global]")
+	require.NoError(t, err)
+
+	for name, content := range map[string]string{
+		"backend.sh": `#!/bin/sh
+cat <<'EOF'
+{"license": {"value": "s3\\cr\"3t", "error": null}, "host": {"value": "web-1", "error": null},
+ "comment": {"value": "#a\n[global]\nhostname = \"injected\"", "error": null}}
+EOF
+`,
+		"cloudinsight-agent.conf": `include = ["host.conf"]
+
+[global]
+license_key = "SECRET[license]"
+tags = ["SECRET[comment]"]
+secret_backend_command = "` + filepath.Join(dir, "backend.sh") + `"
+secret_key_file = "` + keyFile + `"
+kv_consul_token = "` + encrypted + `"
+
+[[histogram]]
+prefix = "http"
+`,
+		// The included files use the backend of the main file.
+		"host.conf": `[global]
+hostname = "SECRET[host]"
+`,
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0700))
+	}
+
+	conf, err := NewConfig(filepath.Join(dir, "cloudinsight-agent.conf"))
+	require.NoError(t, err)
+	// The values are never parsed as TOML.
+	assert.Equal(t, `s3\cr"3t`, conf.GlobalConfig.LicenseKey)
+	assert.Equal(t, TagList{"#a\n[global]\nhostname = \"injected\""}, conf.GlobalConfig.Tags)
+	assert.Equal(t, "#p\"r\\x\n[global]", conf.GlobalConfig.KVConsulToken)
+	assert.Equal(t, "web-1", conf.GlobalConfig.Hostname)
+	assert.Equal(t, []HistogramConfig{{Prefix: "http"}}, conf.Histograms)
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/cloud"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// The sources of the hostname, in the order they're tried.
+const (
+	HostnameSourceConfig  = "hostname"
+	HostnameSourceCommand = "hostname_command"
+	HostnameSourceEC2     = "hostname_ec2_instance_id"
+	HostnameSourceFQDN    = "hostname_fqdn"
+	HostnameSourceOS      = "os"
+)
+
+// HostnameSources lists the sources of the hostname in the order they're
+// tried.
+var HostnameSources = []string{
+	HostnameSourceConfig,
+	HostnameSourceCommand,
+	HostnameSourceEC2,
+	HostnameSourceFQDN,
+	HostnameSourceOS,
+}
+
+// hostnameCommandTimeout bounds the run of hostname_command.
+const hostnameCommandTimeout = 10 * time.Second
+
+// HostnameResolution is the hostname of the agent and the source it comes
+// from.
+type HostnameResolution struct {
+	Hostname string
+	Source   string
+	// Skipped explains why the configured sources tried before Source didn't
+	// give a hostname, by source.
+	Skipped map[string]string
+}
+
+// ResolveHostname tries the configured sources of the hostname: the hostname
+// option, hostname_command, the EC2 instance id with hostname_ec2_instance_id,
+// the FQDN of the host with hostname_fqdn, and the hostname of the OS.
+func (c *Config) ResolveHostname() (*HostnameResolution, error) {
+	r := &HostnameResolution{Skipped: make(map[string]string)}
+	try := func(source string, enabled bool, resolve func() (string, error)) bool {
+		if !enabled {
+			return false
+		}
+		hostname, err := resolve()
+		if err == nil {
+			err = validateHostname(hostname)
+		}
+		if err != nil {
+			r.Skipped[source] = err.Error()
+			return false
+		}
+		r.Hostname, r.Source = hostname, source
+		return true
+	}
+
+	g := c.GlobalConfig
+	if try(HostnameSourceConfig, g.Hostname != "", func() (string, error) {
+		return g.Hostname, nil
+	}) || try(HostnameSourceCommand, g.HostnameCommand != "", func() (string, error) {
+		out, err := util.RunCommand(hostnameCommandTimeout, g.HostnameCommand, g.HostnameCommandArguments...)
+		return strings.TrimSpace(string(out)), err
+	}) || try(HostnameSourceEC2, g.HostnameEC2InstanceID, func() (string, error) {
+		return cloud.EC2InstanceID(cloud.DefaultTimeout)
+	}) || try(HostnameSourceFQDN, g.HostnameFQDN, fqdn) || try(HostnameSourceOS, true, os.Hostname) {
+		return r, nil
+	}
+	return r, fmt.Errorf("unable to resolve the hostname: %s", r.Skipped[HostnameSourceOS])
+}
+
+// fqdn returns the first name the addresses of the hostname resolve to.
+func fqdn() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	ips, err := net.LookupIP(hostname)
+	if err != nil {
+		return "", err
+	}
+	for _, ip := range ips {
+		names, err := net.LookupAddr(ip.String())
+		if err == nil && len(names) > 0 {
+			return strings.TrimSuffix(names[0], "."), nil
+		}
+	}
+	return "", fmt.Errorf("no name for the addresses of %s", hostname)
+}
+
+func validateHostname(hostname string) error {
+	switch {
+	case hostname == "":
+		return fmt.Errorf("empty hostname")
+	case len(hostname) > 255:
+		return fmt.Errorf("hostname %.20s... is longer than 255 characters", hostname)
+	case strings.ContainsAny(hostname, " \t\r\n"):
+		return fmt.Errorf("hostname %q contains spaces", hostname)
+	}
+	return nil
+}
+
+// hostnameMu guards the hostname cached by GetHostname.
+var hostnameMu sync.Mutex
+
+// GetHostname returns the hostname of the agent, it's resolved once.
+func (c *Config) GetHostname() string {
+	hostnameMu.Lock()
+	defer hostnameMu.Unlock()
+	if c.hostnameResolved {
+		return c.hostname
+	}
+	c.hostnameResolved = true
+
+	r, err := c.ResolveHostname()
+	for _, source := range HostnameSources {
+		if reason, ok := r.Skipped[source]; ok {
+			log.Warnf("Could not get the hostname from %s: %s", source, reason)
+		}
+	}
+	if err != nil {
+		log.Error(err)
+		return ""
+	}
+	c.hostname = r.Hostname
+	log.Infof("Using hostname %s from %s", r.Hostname, r.Source)
+	return c.hostname
+}
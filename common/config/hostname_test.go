@@ -0,0 +1,64 @@
+//go:build !windows
+// +build !windows
+
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveHostname(t *testing.T) {
+	osHostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		global  GlobalConfig
+		want    string
+		source  string
+		skipped map[string]string
+	}{
+		{
+			global: GlobalConfig{Hostname: "web-1", HostnameCommand: "echo"},
+			want:   "web-1",
+			source: HostnameSourceConfig,
+		},
+		{
+			global: GlobalConfig{HostnameCommand: "echo", HostnameCommandArguments: []string{" web-2\n"}},
+			want:   "web-2",
+			source: HostnameSourceCommand,
+		},
+		{
+			global:  GlobalConfig{HostnameCommand: "echo"},
+			want:    osHostname,
+			source:  HostnameSourceOS,
+			skipped: map[string]string{HostnameSourceCommand: "empty hostname"},
+		},
+		{
+			global:  GlobalConfig{HostnameCommand: "echo", HostnameCommandArguments: []string{"web 3"}},
+			want:    osHostname,
+			source:  HostnameSourceOS,
+			skipped: map[string]string{HostnameSourceCommand: `hostname "web 3" contains spaces`},
+		},
+		{
+			global:  GlobalConfig{HostnameCommand: "false"},
+			want:    osHostname,
+			source:  HostnameSourceOS,
+			skipped: map[string]string{HostnameSourceCommand: "false failed: exit status 1"},
+		},
+	} {
+		conf := &Config{GlobalConfig: c.global}
+		r, err := conf.ResolveHostname()
+		require.NoError(t, err)
+		assert.Equal(t, c.want, r.Hostname)
+		assert.Equal(t, c.source, r.Source)
+		if c.skipped == nil {
+			c.skipped = map[string]string{}
+		}
+		assert.Equal(t, c.skipped, r.Skipped)
+		assert.Equal(t, c.want, conf.GetHostname())
+	}
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TagList is a list of tags, given either as a TOML array or as a comma
+// separated string. Environment variables like $ENV or ${ENV} are expanded.
+type TagList []string
+
+// UnmarshalTOML implements toml.Unmarshaler.
+func (t *TagList) UnmarshalTOML(data interface{}) error {
+	var tags []string
+	switch v := data.(type) {
+	case string:
+		tags = strings.Split(v, ",")
+	case []interface{}:
+		for _, tag := range v {
+			s, ok := tag.(string)
+			if !ok {
+				return fmt.Errorf("tags should be strings, got %v", tag)
+			}
+			tags = append(tags, s)
+		}
+	default:
+		return fmt.Errorf("tags should be a string or a list of strings, got %v", data)
+	}
+
+	*t = nil
+	for _, tag := range tags {
+		tag = strings.TrimSpace(os.ExpandEnv(tag))
+		if tag != "" {
+			*t = append(*t, tag)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// checkUndecoded returns an error for the first key of the configuration
+// file which doesn't match any option, with the closest option when it looks
+// like a typo. The options of the [[output.<name>]] sections belong to the
+// outputs, they aren't checked.
+func checkUndecoded(md toml.MetaData) error {
+	// The keys are in the order of the file, an unknown table is reported
+	// before its keys.
+	for _, key := range md.Undecoded() {
+		if key[0] == "output" {
+			continue
+		}
+		name := key[len(key)-1]
+		if suggestion := closest(name, knownKeys(reflect.TypeOf(Config{}), key[:len(key)-1])); suggestion != "" {
+			return fmt.Errorf("unknown option %s, did you mean %s?", key, suggestion)
+		}
+		return fmt.Errorf("unknown option %s", key)
+	}
+	return nil
+}
+
+var (
+	unmarshalerType     = reflect.TypeOf((*toml.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*toml.TextUnmarshaler)(nil)).Elem()
+)
+
+// checkTypes returns an error naming the first option whose value doesn't
+// have the type of the option, the errors of the decoder don't name them.
+func checkTypes(md toml.MetaData) error {
+	for _, key := range md.Keys() {
+		t := reflect.TypeOf(Config{})
+		for _, name := range key {
+			if t = fieldType(t, name); t == nil {
+				break
+			}
+		}
+		got := md.Type(key...)
+		if t == nil || got == "" ||
+			reflect.PtrTo(t).Implements(unmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+			continue
+		}
+
+		var want string
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			want = "Integer"
+		case reflect.Float32, reflect.Float64:
+			if got == "Integer" {
+				continue
+			}
+			want = "Float"
+		case reflect.String:
+			want = "String"
+		case reflect.Bool:
+			want = "Bool"
+		case reflect.Slice:
+			if elemType(t).Kind() == reflect.Struct {
+				continue
+			}
+			want = "Array"
+		default:
+			continue
+		}
+		if got != want {
+			return fmt.Errorf("option %s must be %s, got %s", key, describeType(want), describeType(got))
+		}
+	}
+	return nil
+}
+
+func describeType(t string) string {
+	switch t {
+	case "Integer":
+		return "an integer"
+	case "Bool":
+		return "a boolean"
+	case "Array":
+		return "an array"
+	case "Hash":
+		return "a table"
+	case "ArrayHash":
+		return "an array of tables"
+	}
+	return "a " + strings.ToLower(t)
+}
+
+// knownKeys returns the options of the table at path.
+func knownKeys(t reflect.Type, path []string) []string {
+	for _, name := range path {
+		t = fieldType(t, name)
+		if t == nil {
+			return nil
+		}
+	}
+	t = elemType(t)
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("toml"); tag != "" && tag != "-" {
+			keys = append(keys, strings.Split(tag, ",")[0])
+		}
+	}
+	return keys
+}
+
+func fieldType(t reflect.Type, name string) reflect.Type {
+	t = elemType(t)
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if strings.Split(t.Field(i).Tag.Get("toml"), ",")[0] == name {
+			return t.Field(i).Type
+		}
+	}
+	return nil
+}
+
+// elemType returns the type of the tables of an array of tables.
+func elemType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Slice || t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// closest returns the key the closest to name, if it's close enough to be a
+// typo.
+func closest(name string, keys []string) string {
+	best, bestDistance := "", len(name)/3+2
+	for _, key := range keys {
+		if d := editDistance(name, key); d < bestDistance {
+			best, bestDistance = key, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
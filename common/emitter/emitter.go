@@ -1,12 +1,19 @@
 package emitter
 
 import (
+	"errors"
+	"math/rand"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/cloudinsight/cloudinsight-agent/common/api"
 	"github.com/cloudinsight/cloudinsight-agent/common/log"
 	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/watchdog"
+	"github.com/cloudinsight/cloudinsight-agent/output"
 )
 
 const (
@@ -21,8 +28,28 @@ const (
 
 	// FlushLoggingPeriod XXX
 	FlushLoggingPeriod = 20
+
+	// DefaultEventBufferLimit is the number of events kept while they can't be
+	// posted, the oldest ones are dropped first.
+	DefaultEventBufferLimit = 1000
+
+	// DefaultServiceCheckBufferLimit is the number of service checks kept
+	// while they can't be posted, the oldest ones are dropped first.
+	DefaultServiceCheckBufferLimit = 1000
 )
 
+// EventPoster is implemented by the parents of the emitters which can post
+// events.
+type EventPoster interface {
+	PostEvents(events []metric.Event) error
+}
+
+// ServiceCheckPoster is implemented by the parents of the emitters which can
+// post service checks.
+type ServiceCheckPoster interface {
+	PostServiceChecks(serviceChecks []metric.ServiceCheck) error
+}
+
 // Emitter contains the output configuration
 type Emitter struct {
 	Parent interface{}
@@ -34,6 +61,29 @@ type Emitter struct {
 	failMetrics       *Buffer
 	MetricBufferLimit int
 	MetricBatchSize   int
+	// FlushJitter is the maximum random delay of the first emit, which
+	// spreads the submissions of the agents started at the same time.
+	FlushJitter time.Duration
+	// Outputs receive every batch of metrics too, they buffer the batches
+	// they fail to write. They're closed when the emitter stops.
+	Outputs []*output.RunningOutput
+	// DisablePost turns off the posts to the forwarder, the metrics are
+	// only written to the Outputs, the events and service checks dropped.
+	DisablePost bool
+
+	events      []metric.Event
+	eventDrops  int
+	eventsLimit int
+
+	serviceChecks      []metric.ServiceCheck
+	serviceCheckDrops  int
+	serviceChecksLimit int
+
+	// lastFlush is the UnixNano time of the last successful flush, read
+	// concurrently by LastFlush.
+	lastFlush int64
+	// heartbeat beats every interval, unless an emit is stuck.
+	heartbeat watchdog.Heartbeat
 }
 
 // NewEmitter XXX
@@ -47,33 +97,125 @@ func NewEmitter(name string) *Emitter {
 		failMetrics:       NewBuffer(bufferLimit),
 		MetricBufferLimit: bufferLimit,
 		MetricBatchSize:   batchSize,
+		eventsLimit:       DefaultEventBufferLimit,
+
+		serviceChecksLimit: DefaultServiceCheckBufferLimit,
 	}
 	return c
 }
 
-// Run monitors the metrics channel and emits on the flush interval
-func (e *Emitter) Run(shutdown chan struct{}, metricC chan metric.Metric, interval time.Duration) error {
+// Run monitors the metrics, events and service checks channels and emits on
+// the flush interval
+func (e *Emitter) Run(
+	shutdown chan struct{},
+	metricC chan metric.Metric,
+	eventC chan metric.Event,
+	serviceCheckC chan metric.ServiceCheck,
+	interval time.Duration,
+) error {
 	// Inelegant, but this sleep is to allow the collect threads to run, so that
 	// the emitter will emit after metrics are flushed.
-	time.Sleep(200 * time.Millisecond)
+	delay := 200*time.Millisecond + jitter(e.FlushJitter, interval)
+	select {
+	case <-shutdown:
+		return nil
+	case <-time.After(delay):
+	}
 
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
+	e.heartbeat.Beat()
 
 	for {
 		select {
 		case <-shutdown:
 			log.Infoln("Hang on, emitting any cached metrics before shutdown")
 			e.emit()
+			e.closeOutputs()
 			return nil
 		case <-ticker.C:
 			e.emit()
+			e.heartbeat.Beat()
 		case m := <-metricC:
 			e.addMetric(m)
+		case ev := <-eventC:
+			e.addEvent(ev)
+		case sc := <-serviceCheckC:
+			e.addServiceCheck(sc)
 		}
 	}
 }
 
+// RunOnce adds the metrics, events and service checks of the channels until
+// done is closed, then the ones left in the channels, and flushes them once.
+func (e *Emitter) RunOnce(
+	done chan struct{},
+	metricC chan metric.Metric,
+	eventC chan metric.Event,
+	serviceCheckC chan metric.ServiceCheck,
+) error {
+	defer e.closeOutputs()
+
+	for {
+		select {
+		case m := <-metricC:
+			e.addMetric(m)
+		case ev := <-eventC:
+			e.addEvent(ev)
+		case sc := <-serviceCheckC:
+			e.addServiceCheck(sc)
+		case <-done:
+			for e.receive(metricC, eventC, serviceCheckC) {
+			}
+
+			e.emitCount++
+			var errs []string
+			for _, flush := range []func() error{e.flush, e.flushEvents, e.flushServiceChecks} {
+				if err := flush(); err != nil {
+					errs = append(errs, err.Error())
+				}
+			}
+			if len(errs) > 0 {
+				return errors.New(strings.Join(errs, "; "))
+			}
+			return nil
+		}
+	}
+}
+
+// receive adds a value of the channels, it returns false when they're all
+// empty.
+func (e *Emitter) receive(
+	metricC chan metric.Metric,
+	eventC chan metric.Event,
+	serviceCheckC chan metric.ServiceCheck,
+) bool {
+	select {
+	case m := <-metricC:
+		e.addMetric(m)
+	case ev := <-eventC:
+		e.addEvent(ev)
+	case sc := <-serviceCheckC:
+		e.addServiceCheck(sc)
+	default:
+		return false
+	}
+	return true
+}
+
+// jitter returns a random duration below max, which is capped to interval.
+func jitter(max, interval time.Duration) time.Duration {
+	if max > interval {
+		max = interval
+	}
+	if max <= 0 {
+		return 0
+	}
+	// Seeded here, all the agents would draw the same delay otherwise.
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return time.Duration(r.Int63n(int64(max)))
+}
+
 // emit sends the collected metrics to forwarder API
 func (e *Emitter) emit() {
 	e.emitCount++
@@ -86,20 +228,62 @@ func (e *Emitter) emit() {
 		if err != nil {
 			log.Infof("Error occurred when posting to Forwarder API: %s", err.Error())
 		}
+		if err = e.flushEvents(); err != nil {
+			log.Infof("Error occurred when posting events to Forwarder API: %s", err.Error())
+		}
+		if err = e.flushServiceChecks(); err != nil {
+			log.Infof("Error occurred when posting service checks to Forwarder API: %s", err.Error())
+		}
 	}()
 
 	wg.Wait()
 }
 
+func (e *Emitter) addEvent(ev metric.Event) {
+	if len(e.events) >= e.eventsLimit {
+		e.events = e.events[1:]
+		e.eventDrops++
+		if e.eventDrops == 1 || e.eventDrops%e.eventsLimit == 0 {
+			log.Infof("%s event buffer full. We have dropped %d events so far.", e.name, e.eventDrops)
+		}
+	}
+	e.events = append(e.events, ev)
+}
+
+// flushEvents posts all cached events, they are kept for the next flush if
+// the post fails.
+func (e *Emitter) flushEvents() error {
+	if len(e.events) == 0 {
+		return nil
+	}
+
+	poster, ok := e.Parent.(EventPoster)
+	if !ok || e.DisablePost {
+		log.Debugf("%s can't post events, dropping %d events.", e.name, len(e.events))
+		e.events = nil
+		return nil
+	}
+
+	events := e.events
+	posted, err := e.postSplit("event", len(events), func(from, to int) error {
+		return poster.PostEvents(events[from:to])
+	})
+	e.events = e.events[posted:]
+	if len(e.events) == 0 {
+		e.events = nil
+	}
+	return err
+}
+
 // AddMetric adds a metric to the Collector. It will post metrics to Forwarder
 // when the metrics size has reached the MetricBatchSize.
 func (e *Emitter) addMetric(metric metric.Metric) {
 	e.metrics.Add(metric)
 	if e.metrics.Len() == e.MetricBatchSize {
 		batch := e.metrics.Batch(e.MetricBatchSize)
-		err := e.Post(batch)
-		if err != nil {
-			e.failMetrics.Add(batch...)
+		e.write(batch)
+		if rest, err := e.post(batch); err != nil {
+			e.failMetrics.Add(rest...)
 		}
 	}
 }
@@ -147,36 +331,85 @@ func (e *Emitter) flush() error {
 			// If we've already failed previous Emit, don't bother trying to
 			// post to Forwarder again. We are not exiting the loop just so
 			// that we can rotate the metrics to preserve order.
+			rest := batch
 			if err == nil {
-				err = e.Post(batch)
+				rest, err = e.post(batch)
 			}
 			if err != nil {
-				e.failMetrics.Add(batch...)
+				e.failMetrics.Add(rest...)
 			}
 		}
 	}
 
 	batch := e.metrics.Batch(e.MetricBatchSize)
+	e.write(batch)
 	// if c.failMetrics is empty then err will always be nil at this point.
+	rest := batch
 	if err == nil {
-		err = e.Post(batch)
+		rest, err = e.post(batch)
 	}
 	if err != nil {
-		e.failMetrics.Add(batch...)
+		e.failMetrics.Add(rest...)
 		return err
 	}
+	atomic.StoreInt64(&e.lastFlush, time.Now().UnixNano())
 	return nil
 }
 
+// LastFlush returns the time of the last successful flush, the zero time
+// until the first one.
+func (e *Emitter) LastFlush() time.Time {
+	ns := atomic.LoadInt64(&e.lastFlush)
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// Heartbeat returns the heartbeat of the loop of Run, for the watchdog.
+func (e *Emitter) Heartbeat() *watchdog.Heartbeat {
+	return &e.heartbeat
+}
+
+// write writes a new batch to the outputs, along with the metrics they
+// failed to write before.
+func (e *Emitter) write(metrics []metric.Metric) {
+	for _, o := range e.Outputs {
+		if len(metrics) == 0 && o.Len() == 0 {
+			continue
+		}
+		if err := o.Write(metrics); err != nil {
+			log.Errorf("%s failed to write to an output, %d metrics buffered, %d dropped: %s",
+				e.name, o.Len(), o.Drops(), err)
+		}
+	}
+}
+
+func (e *Emitter) closeOutputs() {
+	for _, o := range e.Outputs {
+		if err := o.Close(); err != nil {
+			log.Errorf("%s failed to close output %s: %s", e.name, o.Name, err)
+		}
+	}
+}
+
 // Post XXX
 func (e *Emitter) Post(metrics []metric.Metric) error {
-	if metrics == nil || len(metrics) == 0 {
-		return nil
+	_, err := e.post(metrics)
+	return err
+}
+
+// post posts the metrics through the Post method of the parent, the batches
+// exceeding the maximum payload size are split. It returns the metrics which
+// haven't been posted when it fails.
+func (e *Emitter) post(metrics []metric.Metric) ([]metric.Metric, error) {
+	if metrics == nil || len(metrics) == 0 || e.DisablePost {
+		return nil, nil
 	}
 
 	formattedMetrics := e.format(metrics)
 	if len(formattedMetrics) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	v := reflect.ValueOf(e.Parent)
@@ -185,14 +418,90 @@ func (e *Emitter) Post(metrics []metric.Metric) error {
 		log.Fatal("Can't find valid post method.")
 	}
 
-	ret := method.Call([]reflect.Value{reflect.ValueOf(formattedMetrics)})
-	val := ret[0].Interface()
-	if val != nil {
-		if err, ok := val.(error); ok {
-			return err
+	posted, err := e.postSplit("metric", len(formattedMetrics), func(from, to int) error {
+		ret := method.Call([]reflect.Value{reflect.ValueOf(formattedMetrics[from:to])})
+		val := ret[0].Interface()
+		if val != nil {
+			if err, ok := val.(error); ok {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return metrics[posted:], err
+	}
+	return nil, nil
+}
+
+// postSplit posts the items [0, n) with post. The batches rejected with
+// api.ErrPayloadTooLarge are posted in halves, and an item too large on its
+// own is dropped. It returns the number of leading items posted or dropped,
+// the other ones should be posted again when it fails.
+func (e *Emitter) postSplit(kind string, n int, post func(from, to int) error) (int, error) {
+	done := 0
+	var split func(from, to int) error
+	split = func(from, to int) error {
+		err := post(from, to)
+		if err == api.ErrPayloadTooLarge {
+			if to-from == 1 {
+				log.Errorf("%s dropping a %s larger than the maximum payload size.", e.name, kind)
+				err = nil
+			} else {
+				mid := from + (to-from)/2
+				if err = split(from, mid); err == nil {
+					err = split(mid, to)
+				}
+				return err
+			}
+		}
+		if err == nil {
+			done = to
 		}
+		return err
 	}
-	return nil
+
+	if n == 0 {
+		return 0, nil
+	}
+	err := split(0, n)
+	return done, err
+}
+
+func (e *Emitter) addServiceCheck(sc metric.ServiceCheck) {
+	if len(e.serviceChecks) >= e.serviceChecksLimit {
+		e.serviceChecks = e.serviceChecks[1:]
+		e.serviceCheckDrops++
+		if e.serviceCheckDrops == 1 || e.serviceCheckDrops%e.serviceChecksLimit == 0 {
+			log.Infof("%s service check buffer full. We have dropped %d service checks so far.", e.name, e.serviceCheckDrops)
+		}
+	}
+	e.serviceChecks = append(e.serviceChecks, sc)
+}
+
+// flushServiceChecks posts all cached service checks, they are kept for the
+// next flush if the post fails.
+func (e *Emitter) flushServiceChecks() error {
+	if len(e.serviceChecks) == 0 {
+		return nil
+	}
+
+	poster, ok := e.Parent.(ServiceCheckPoster)
+	if !ok || e.DisablePost {
+		log.Debugf("%s can't post service checks, dropping %d service checks.", e.name, len(e.serviceChecks))
+		e.serviceChecks = nil
+		return nil
+	}
+
+	serviceChecks := e.serviceChecks
+	posted, err := e.postSplit("service check", len(serviceChecks), func(from, to int) error {
+		return poster.PostServiceChecks(serviceChecks[from:to])
+	})
+	e.serviceChecks = e.serviceChecks[posted:]
+	if len(e.serviceChecks) == 0 {
+		e.serviceChecks = nil
+	}
+	return err
 }
 
 // IsFirstRun XXX
@@ -5,9 +5,12 @@ import (
 	"io/ioutil"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/cloudinsight/cloudinsight-agent/common/api"
 	"github.com/cloudinsight/cloudinsight-agent/common/log"
 	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/output"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -169,14 +172,123 @@ func TestPostFail(t *testing.T) {
 	assert.Len(t, m.Metrics(), 10)
 }
 
+// mockOutput records the batches written to it.
+type mockOutput struct {
+	batches [][]metric.Metric
+	closed  bool
+}
+
+func (o *mockOutput) Write(metrics []metric.Metric) error {
+	o.batches = append(o.batches, metrics)
+	return nil
+}
+
+func (o *mockOutput) Connect() error {
+	return nil
+}
+
+func (o *mockOutput) Close() error {
+	o.closed = true
+	return nil
+}
+
+func TestOutputs(t *testing.T) {
+	o := &mockOutput{}
+	m := &mockEmitter{
+		Emitter:  NewEmitter("Test"),
+		failPost: true,
+	}
+	m.MetricBatchSize = 4
+	m.Emitter.Parent = m
+	m.Outputs = append(m.Outputs, output.NewRunningOutput("mock", o, output.RunningConfig{}))
+
+	for _, metric := range first5 {
+		m.addMetric(metric)
+	}
+	require.Error(t, m.flush())
+	m.failPost = false
+	require.NoError(t, m.flush())
+
+	// The failed posts aren't written again.
+	require.Len(t, o.batches, 2)
+	assert.Equal(t, first5[:4], o.batches[0])
+	assert.Equal(t, first5[4:], o.batches[1])
+	assert.Len(t, m.Metrics(), 5)
+
+	m.closeOutputs()
+	assert.True(t, o.closed)
+}
+
+func TestDisablePost(t *testing.T) {
+	o := &mockOutput{}
+	m := &mockEmitter{
+		Emitter: NewEmitter("Test"),
+	}
+	m.Emitter.Parent = m
+	m.Outputs = append(m.Outputs, output.NewRunningOutput("mock", o, output.RunningConfig{}))
+	m.DisablePost = true
+
+	for _, metric := range first5 {
+		m.addMetric(metric)
+	}
+	m.addEvent(metric.Event{Title: "event"})
+	m.addServiceCheck(metric.ServiceCheck{Name: "check"})
+	m.emit()
+
+	assert.Len(t, m.Metrics(), 0)
+	assert.Len(t, m.Events(), 0)
+	assert.Len(t, m.ServiceChecks(), 0)
+	require.Len(t, o.batches, 1)
+	assert.Equal(t, first5, o.batches[0])
+}
+
+func TestPostSplit(t *testing.T) {
+	m := &mockEmitter{
+		Emitter:  NewEmitter("Test"),
+		maxBatch: 3,
+	}
+	m.Emitter.Parent = m
+
+	for _, metric := range append(append([]metric.Metric{}, first5...), next5...) {
+		m.Emitter.metrics.Add(metric)
+	}
+	require.NoError(t, m.flush())
+	// the batch was split, the order is preserved
+	metrics := m.Metrics()
+	require.Len(t, metrics, 10)
+	for i, formatted := range metrics {
+		assert.Equal(t, fmt.Sprintf("metric%d", i+1), formatted.(metric.Metric).Name)
+	}
+
+	m.addEvent(metric.NewEvent("event1", "text"))
+	m.addEvent(metric.NewEvent("event2", "text"))
+	m.addEvent(metric.NewEvent("event3", "text"))
+	m.addEvent(metric.NewEvent("event4", "text"))
+	require.NoError(t, m.flushEvents())
+	assert.Len(t, m.Events(), 4)
+	assert.Len(t, m.Emitter.events, 0)
+
+	// a metric too large on its own is dropped
+	m.maxBatch = -1
+	m.metrics = nil
+	require.NoError(t, m.Emitter.Post(first5[:1]))
+	assert.Len(t, m.Metrics(), 0)
+}
+
 type mockEmitter struct {
 	*Emitter
 	sync.Mutex
 
 	metrics []interface{}
+	events  []metric.Event
+
+	serviceChecks []metric.ServiceCheck
 
 	// if true, mock a post failure
 	failPost bool
+	// if set, the larger batches are rejected as too large, all of them
+	// when negative
+	maxBatch int
 }
 
 func (m *mockEmitter) Post(metrics []interface{}) error {
@@ -185,6 +297,9 @@ func (m *mockEmitter) Post(metrics []interface{}) error {
 	if m.failPost {
 		return fmt.Errorf("Failed Post!")
 	}
+	if m.maxBatch != 0 && len(metrics) > m.maxBatch {
+		return api.ErrPayloadTooLarge
+	}
 
 	if m.metrics == nil {
 		m.metrics = []interface{}{}
@@ -196,12 +311,102 @@ func (m *mockEmitter) Post(metrics []interface{}) error {
 	return nil
 }
 
+func (m *mockEmitter) PostEvents(events []metric.Event) error {
+	m.Lock()
+	defer m.Unlock()
+	if m.failPost {
+		return fmt.Errorf("Failed Post!")
+	}
+	if m.maxBatch != 0 && len(events) > m.maxBatch {
+		return api.ErrPayloadTooLarge
+	}
+
+	m.events = append(m.events, events...)
+	return nil
+}
+
+func (m *mockEmitter) Events() []metric.Event {
+	m.Lock()
+	defer m.Unlock()
+	return m.events
+}
+
+func (m *mockEmitter) PostServiceChecks(serviceChecks []metric.ServiceCheck) error {
+	m.Lock()
+	defer m.Unlock()
+	if m.failPost {
+		return fmt.Errorf("Failed Post!")
+	}
+
+	m.serviceChecks = append(m.serviceChecks, serviceChecks...)
+	return nil
+}
+
+func (m *mockEmitter) ServiceChecks() []metric.ServiceCheck {
+	m.Lock()
+	defer m.Unlock()
+	return m.serviceChecks
+}
+
 func (m *mockEmitter) Metrics() []interface{} {
 	m.Lock()
 	defer m.Unlock()
 	return m.metrics
 }
 
+func TestFlushEvents(t *testing.T) {
+	m := &mockEmitter{
+		Emitter:  NewEmitter("Test"),
+		failPost: true,
+	}
+	m.eventsLimit = 2
+	m.Emitter.Parent = m
+
+	m.addEvent(metric.NewEvent("event1", "text"))
+	m.addEvent(metric.NewEvent("event2", "text"))
+	m.addEvent(metric.NewEvent("event3", "text"))
+
+	// the events are kept when the post fails
+	require.Error(t, m.flushEvents())
+	assert.Len(t, m.Events(), 0)
+
+	m.failPost = false
+	require.NoError(t, m.flushEvents())
+	events := m.Events()
+	require.Len(t, events, 2)
+	// the oldest event was dropped
+	assert.Equal(t, "event2", events[0].Title)
+	assert.Equal(t, "event3", events[1].Title)
+
+	require.NoError(t, m.flushEvents())
+	assert.Len(t, m.Events(), 2)
+}
+
+func TestFlushServiceChecks(t *testing.T) {
+	m := &mockEmitter{
+		Emitter:  NewEmitter("Test"),
+		failPost: true,
+	}
+	m.serviceChecksLimit = 2
+	m.Emitter.Parent = m
+
+	m.addServiceCheck(metric.NewServiceCheck("check1", metric.ServiceCheckOK))
+	m.addServiceCheck(metric.NewServiceCheck("check2", metric.ServiceCheckOK))
+	m.addServiceCheck(metric.NewServiceCheck("check3", metric.ServiceCheckCritical))
+
+	// the service checks are kept when the post fails
+	require.Error(t, m.flushServiceChecks())
+	assert.Len(t, m.ServiceChecks(), 0)
+
+	m.failPost = false
+	require.NoError(t, m.flushServiceChecks())
+	serviceChecks := m.ServiceChecks()
+	require.Len(t, serviceChecks, 2)
+	// the oldest service check was dropped
+	assert.Equal(t, "check2", serviceChecks[0].Name)
+	assert.Equal(t, "check3", serviceChecks[1].Name)
+}
+
 type perfEmitter struct {
 	*Emitter
 
@@ -219,3 +424,14 @@ func (m *perfEmitter) Post(metrics []interface{}) error {
 func init() {
 	log.SetOutput(ioutil.Discard)
 }
+
+func TestJitter(t *testing.T) {
+	assert.Equal(t, time.Duration(0), jitter(0, 10*time.Second))
+	for i := 0; i < 100; i++ {
+		d := jitter(5*time.Second, 10*time.Second)
+		assert.True(t, d >= 0 && d < 5*time.Second)
+		// Capped to the interval
+		d = jitter(time.Minute, time.Second)
+		assert.True(t, d >= 0 && d < time.Second)
+	}
+}
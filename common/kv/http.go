@@ -0,0 +1,84 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+var errNotFound = errors.New("key not found")
+
+// getConsul reads key with the KV API of Consul.
+func (r *Resolver) getConsul(key string) (string, error) {
+	req, err := http.NewRequest("GET", r.settings.ConsulURL+"/v1/kv/"+strings.TrimPrefix(key, "/")+"?raw", nil)
+	if err != nil {
+		return "", err
+	}
+	if r.settings.ConsulToken != "" {
+		req.Header.Set("X-Consul-Token", r.settings.ConsulToken)
+	}
+
+	body, err := r.do(req)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// getEtcd reads key with the JSON gateway of the v3 API of etcd.
+func (r *Resolver) getEtcd(key string) (string, error) {
+	payload, _ := json.Marshal(map[string]string{
+		"key": base64.StdEncoding.EncodeToString([]byte(key)),
+	})
+	req, err := http.NewRequest("POST", r.settings.EtcdURL+"/v3/kv/range", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	body, err := r.do(req)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		KVs []struct {
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	if err = json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("invalid etcd response: %s", err)
+	}
+	if len(resp.KVs) == 0 {
+		return "", errNotFound
+	}
+	value, err := base64.StdEncoding.DecodeString(resp.KVs[0].Value)
+	if err != nil {
+		return "", fmt.Errorf("invalid etcd response: %s", err)
+	}
+	return string(value), nil
+}
+
+func (r *Resolver) do(req *http.Request) ([]byte, error) {
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("received bad status code, %d", resp.StatusCode)
+	}
+	return body, nil
+}
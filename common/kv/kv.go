@@ -0,0 +1,154 @@
+// Package kv resolves the KV[<store>://<key>] placeholders of the check
+// configurations with the values of a key-value store, so that the hosts and
+// the ports maintained in a service registry flow into the instances. The
+// stores are Consul (consul://), etcd (etcd://) and ZooKeeper (zk://), e.g.
+// KV[consul://services/redis/port].
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/proxy"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// The addresses of the stores when they aren't configured, the agents of
+// the stores usually run on every host.
+const (
+	DefaultConsulURL        = "http://127.0.0.1:8500"
+	DefaultEtcdURL          = "http://127.0.0.1:2379"
+	DefaultZookeeperAddress = "127.0.0.1:2181"
+)
+
+const defaultTimeout = 5 * time.Second
+
+var placeholder = regexp.MustCompile(`KV\[([a-z]+)://([^\]\n]+)\]`)
+
+// Settings are the addresses of the stores, the defaults are used for the
+// empty ones.
+type Settings struct {
+	ConsulURL   string
+	ConsulToken string
+	EtcdURL     string
+	// ZookeeperAddress is the host:port of a ZooKeeper server.
+	ZookeeperAddress string
+	Timeout          time.Duration
+}
+
+// Resolver reads the keys from the stores, the values are cached for the
+// life of the Resolver.
+type Resolver struct {
+	settings Settings
+	client   *http.Client
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+// NewResolver creates a new instance of Resolver.
+func NewResolver(s Settings) *Resolver {
+	if s.ConsulURL == "" {
+		s.ConsulURL = DefaultConsulURL
+	}
+	if s.EtcdURL == "" {
+		s.EtcdURL = DefaultEtcdURL
+	}
+	if s.ZookeeperAddress == "" {
+		s.ZookeeperAddress = DefaultZookeeperAddress
+	}
+	if s.Timeout <= 0 {
+		s.Timeout = defaultTimeout
+	}
+	s.ConsulURL = strings.TrimSuffix(s.ConsulURL, "/")
+	s.EtcdURL = strings.TrimSuffix(s.EtcdURL, "/")
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy.FromConfig
+	return &Resolver{
+		settings: s,
+		client:   &http.Client{Transport: transport, Timeout: s.Timeout},
+		cache:    make(map[string]string),
+	}
+}
+
+// Get returns the value of key in store.
+func (r *Resolver) Get(store, key string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := store + "://" + key
+	if value, ok := r.cache[id]; ok {
+		return value, nil
+	}
+
+	var value string
+	var err error
+	switch store {
+	case "consul":
+		value, err = r.getConsul(key)
+	case "etcd":
+		value, err = r.getEtcd(key)
+	case "zk":
+		value, err = getZookeeper(r.settings.ZookeeperAddress, key, r.settings.Timeout)
+	default:
+		return "", fmt.Errorf("unknown key-value store %s in KV[%s]", store, id)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to read KV[%s]: %s", id, err)
+	}
+	r.cache[id] = value
+	return value, nil
+}
+
+var (
+	mu      sync.RWMutex
+	current = NewResolver(Settings{})
+)
+
+// Set sets the resolver of the placeholders.
+func Set(r *Resolver) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = r
+}
+
+// Resolve replaces the KV[<store>://<key>] placeholders of content with the
+// values of the keys, the comment lines are left alone.
+func Resolve(content []byte) ([]byte, error) {
+	if !bytes.Contains(content, []byte("KV[")) {
+		return content, nil
+	}
+
+	mu.RLock()
+	r := current
+	mu.RUnlock()
+
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		if util.IsCommentLine(line) {
+			buf.Write(line)
+			continue
+		}
+		var err error
+		line = placeholder.ReplaceAllFunc(line, func(m []byte) []byte {
+			if err != nil {
+				return nil
+			}
+			sub := placeholder.FindSubmatch(m)
+			var value string
+			value, err = r.Get(string(sub[1]), strings.TrimSpace(string(sub[2])))
+			return []byte(value)
+		})
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,129 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeZookeeper answers the session and getData requests with nodes.
+func fakeZookeeper(t *testing.T, nodes map[string]string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				if _, err := readPacket(conn); err != nil {
+					return
+				}
+				var resp bytes.Buffer
+				writeInt32(&resp, 0)
+				writeInt32(&resp, 10000)
+				writeInt64(&resp, 42)
+				writeBuffer(&resp, make([]byte, 16))
+				_ = writePacket(conn, resp.Bytes())
+
+				req, err := readPacket(conn)
+				if err != nil {
+					return
+				}
+				n := binary.BigEndian.Uint32(req[8:12])
+				path := string(req[12 : 12+n])
+
+				resp.Reset()
+				writeInt32(&resp, 1)
+				writeInt64(&resp, 7)
+				if value, ok := nodes[path]; ok {
+					writeInt32(&resp, 0)
+					writeBuffer(&resp, []byte(value))
+					resp.Write(make([]byte, 68))
+				} else {
+					writeInt32(&resp, zkErrNoNode)
+				}
+				_ = writePacket(conn, resp.Bytes())
+			}(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestResolve(t *testing.T) {
+	var consulRequests int
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		consulRequests++
+		assert.Equal(t, "secret-token", r.Header.Get("X-Consul-Token"))
+		if r.URL.Path != "/v1/kv/services/redis/host" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("redis-1.local"))
+	}))
+	defer consul.Close()
+
+	etcd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v3/kv/range", r.URL.Path)
+		var req struct {
+			Key string `json:"key"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		key, _ := base64.StdEncoding.DecodeString(req.Key)
+		if string(key) != "/services/redis/port" {
+			_, _ = w.Write([]byte(`{"header":{}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"kvs":[{"value":"` + base64.StdEncoding.EncodeToString([]byte("6380")) + `"}],"count":"1"}`))
+	}))
+	defer etcd.Close()
+
+	zk := fakeZookeeper(t, map[string]string{"/services/redis/db": "2"})
+
+	Set(NewResolver(Settings{
+		ConsulURL:        consul.URL,
+		ConsulToken:      "secret-token",
+		EtcdURL:          etcd.URL,
+		ZookeeperAddress: zk,
+	}))
+	defer Set(NewResolver(Settings{}))
+
+	content := []byte(`# KV[consul://missing]
+instances:
+  - host: KV[consul://services/redis/host]
+    port: KV[etcd:///services/redis/port]
+    db: KV[zk://services/redis/db]
+    tags: ["host:KV[consul://services/redis/host]"]
+`)
+	out, err := Resolve(content)
+	assert.NoError(t, err)
+	assert.Equal(t, `# KV[consul://missing]
+instances:
+  - host: redis-1.local
+    port: 6380
+    db: 2
+    tags: ["host:redis-1.local"]
+`, string(out))
+	assert.Equal(t, 1, consulRequests)
+
+	for in, want := range map[string]string{
+		"host: KV[consul://missing]": "unable to read KV[consul://missing]: key not found",
+		"host: KV[etcd://missing]":   "unable to read KV[etcd://missing]: key not found",
+		"host: KV[zk://missing]":     "unable to read KV[zk://missing]: key not found",
+		"host: KV[redis://key]":      "unknown key-value store redis in KV[redis://key]",
+	} {
+		_, err = Resolve([]byte(in))
+		assert.EqualError(t, err, want, in)
+	}
+}
@@ -0,0 +1,127 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// The parts of the ZooKeeper protocol needed to read a node: a session is
+// opened, the data of the node is read with getData, and the connection is
+// closed, which expires the session.
+const (
+	zkProtocolVersion = 0
+	zkOpGetData       = 4
+	zkErrNoNode       = -101
+	// zkMaxPacket bounds the responses, the nodes hold at most 1MB.
+	zkMaxPacket = 4 << 20
+)
+
+func getZookeeper(addr, path string, timeout time.Duration) (string, error) {
+	if path == "" || path[0] != '/' {
+		path = "/" + path
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	// ConnectRequest: protocolVersion, lastZxidSeen, timeOut, sessionId,
+	// passwd, readOnly.
+	var req bytes.Buffer
+	writeInt32(&req, zkProtocolVersion)
+	writeInt64(&req, 0)
+	writeInt32(&req, int32(timeout/time.Millisecond))
+	writeInt64(&req, 0)
+	writeBuffer(&req, make([]byte, 16))
+	req.WriteByte(0)
+	if err = writePacket(conn, req.Bytes()); err != nil {
+		return "", err
+	}
+	resp, err := readPacket(conn)
+	if err != nil {
+		return "", err
+	}
+	if len(resp) < 16 || binary.BigEndian.Uint64(resp[8:16]) == 0 {
+		return "", fmt.Errorf("zookeeper refused the session")
+	}
+
+	// RequestHeader (xid, type) and GetDataRequest (path, watch).
+	req.Reset()
+	writeInt32(&req, 1)
+	writeInt32(&req, zkOpGetData)
+	writeBuffer(&req, []byte(path))
+	req.WriteByte(0)
+	if err = writePacket(conn, req.Bytes()); err != nil {
+		return "", err
+	}
+	if resp, err = readPacket(conn); err != nil {
+		return "", err
+	}
+
+	// ReplyHeader (xid, zxid, err) and GetDataResponse (data, stat).
+	if len(resp) < 16 {
+		return "", io.ErrUnexpectedEOF
+	}
+	switch code := int32(binary.BigEndian.Uint32(resp[12:16])); code {
+	case 0:
+	case zkErrNoNode:
+		return "", errNotFound
+	default:
+		return "", fmt.Errorf("zookeeper error %d", code)
+	}
+	resp = resp[16:]
+	if len(resp) < 4 {
+		return "", io.ErrUnexpectedEOF
+	}
+	n := int32(binary.BigEndian.Uint32(resp))
+	if n < 0 {
+		return "", nil
+	}
+	if int(n) > len(resp)-4 {
+		return "", io.ErrUnexpectedEOF
+	}
+	return string(resp[4 : 4+n]), nil
+}
+
+func writeInt32(w *bytes.Buffer, v int32) {
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+func writeInt64(w *bytes.Buffer, v int64) {
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+func writeBuffer(w *bytes.Buffer, b []byte) {
+	writeInt32(w, int32(len(b)))
+	w.Write(b)
+}
+
+// writePacket prefixes p with its length.
+func writePacket(w io.Writer, p []byte) error {
+	packet := make([]byte, 4+len(p))
+	binary.BigEndian.PutUint32(packet, uint32(len(p)))
+	copy(packet[4:], p)
+	_, err := w.Write(packet)
+	return err
+}
+
+func readPacket(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n > zkMaxPacket {
+		return nil, fmt.Errorf("zookeeper packet of %d bytes is too large", n)
+	}
+	p := make([]byte, n)
+	_, err := io.ReadFull(r, p)
+	return p, err
+}
@@ -0,0 +1,112 @@
+// Package limits bounds the CPU and the memory used by the agent itself, so
+// that a misconfigured check or a flood of Statsd packets doesn't starve the
+// host it monitors.
+package limits
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+)
+
+// checkInterval is the time between two checks of the memory usage.
+const checkInterval = 5 * time.Second
+
+// The values before Apply, restored when a limit is removed by a reload.
+var (
+	defaultMaxProcs    = runtime.GOMAXPROCS(0)
+	defaultGCPercent   = readGCPercent()
+	defaultMemoryLimit = debug.SetMemoryLimit(-1)
+)
+
+func readGCPercent() int {
+	percent := debug.SetGCPercent(100)
+	debug.SetGCPercent(percent)
+	return percent
+}
+
+// Limits are the limits of the agent, the zero values keep the defaults of
+// the runtime.
+type Limits struct {
+	// MaxProcs is the number of CPUs running the agent at the same time.
+	MaxProcs int
+	// GCPercent is the growth of the heap triggering a garbage collection,
+	// lower values trade CPU for memory.
+	GCPercent int
+	// MemoryLimit is the memory, in bytes, the garbage collector tries to
+	// stay below.
+	MemoryLimit int64
+}
+
+// Apply applies l to the runtime.
+func Apply(l Limits) {
+	if l.MaxProcs > 0 {
+		runtime.GOMAXPROCS(l.MaxProcs)
+	} else {
+		runtime.GOMAXPROCS(defaultMaxProcs)
+	}
+	if l.GCPercent > 0 {
+		debug.SetGCPercent(l.GCPercent)
+	} else {
+		debug.SetGCPercent(defaultGCPercent)
+	}
+	if l.MemoryLimit > 0 {
+		debug.SetMemoryLimit(l.MemoryLimit)
+	} else {
+		debug.SetMemoryLimit(defaultMemoryLimit)
+	}
+}
+
+// Monitor watches the memory used by the agent, the load is shed while it's
+// above the limit.
+type Monitor struct {
+	limit uint64
+	// exceeded is set to 1 while the memory is above the limit.
+	exceeded int32
+}
+
+// NewMonitor creates a new instance of Monitor, limit is in bytes.
+func NewMonitor(limit uint64) *Monitor {
+	return &Monitor{limit: limit}
+}
+
+// Exceeded tells whether the agent uses more memory than the limit, it's
+// only reset once the agent is back below 90% of the limit.
+func (m *Monitor) Exceeded() bool {
+	return atomic.LoadInt32(&m.exceeded) == 1
+}
+
+// Run checks the memory used by the agent until shutdown is closed.
+func (m *Monitor) Run(shutdown chan struct{}) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	var stats runtime.MemStats
+	for {
+		runtime.ReadMemStats(&stats)
+		m.check(stats.Sys - stats.HeapReleased)
+
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Monitor) check(used uint64) {
+	switch {
+	case used > m.limit && !m.Exceeded():
+		atomic.StoreInt32(&m.exceeded, 1)
+		log.Warnf("The agent uses %d MB of memory, above memory_limit %d MB, dropping the Statsd packets",
+			used>>20, m.limit>>20)
+	case used > m.limit:
+		log.Warnf("The agent still uses %d MB of memory, above memory_limit %d MB", used>>20, m.limit>>20)
+	case used < m.limit/10*9 && m.Exceeded():
+		atomic.StoreInt32(&m.exceeded, 0)
+		log.Infof("The agent uses %d MB of memory, below memory_limit %d MB again", used>>20, m.limit>>20)
+	}
+}
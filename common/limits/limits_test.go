@@ -0,0 +1,35 @@
+package limits
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApply(t *testing.T) {
+	defer Apply(Limits{})
+
+	Apply(Limits{MaxProcs: 1, GCPercent: 50, MemoryLimit: 256 << 20})
+	assert.Equal(t, 1, runtime.GOMAXPROCS(0))
+	assert.Equal(t, 50, readGCPercent())
+	assert.Equal(t, int64(256<<20), debug.SetMemoryLimit(-1))
+
+	Apply(Limits{})
+	assert.Equal(t, defaultMaxProcs, runtime.GOMAXPROCS(0))
+	assert.Equal(t, defaultGCPercent, readGCPercent())
+	assert.Equal(t, defaultMemoryLimit, debug.SetMemoryLimit(-1))
+}
+
+func TestMonitor(t *testing.T) {
+	m := NewMonitor(100 << 20)
+	assert.False(t, m.Exceeded())
+
+	m.check(120 << 20)
+	assert.True(t, m.Exceeded())
+	m.check(95 << 20)
+	assert.True(t, m.Exceeded())
+	m.check(80 << 20)
+	assert.False(t, m.Exceeded())
+}
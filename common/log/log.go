@@ -35,11 +35,34 @@ func SetLevel(level string) error {
 	return nil
 }
 
+// GetLevel returns the level of the base logger.
+func GetLevel() string {
+	return origLogger.Level.String()
+}
+
 // SetOutput XXX
 func SetOutput(out io.Writer) {
 	origLogger.Out = out
 }
 
+// AddErrorHook calls f with the messages of the base logger at the error
+// level and above, e.g. to report them to the Windows event log. It's not
+// safe to call while logging.
+func AddErrorHook(f func(msg string)) {
+	origLogger.Hooks.Add(errorHook(f))
+}
+
+type errorHook func(msg string)
+
+func (h errorHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.ErrorLevel, logrus.FatalLevel, logrus.PanicLevel}
+}
+
+func (h errorHook) Fire(entry *logrus.Entry) error {
+	h(entry.Message)
+	return nil
+}
+
 type levelFlag struct{}
 
 // String implements flag.Value.
@@ -241,6 +264,32 @@ func With(key string, value interface{}) Logger {
 	return baseLogger.With(key, value)
 }
 
+// New returns a Logger with its own level, writing to out with the formatter
+// of the base logger. The level of the base logger is used when level is
+// empty and its output when out is nil.
+func New(level string, out io.Writer) (Logger, error) {
+	l := logrus.New()
+	l.Formatter = origLogger.Formatter
+	l.Level = origLogger.Level
+	l.Out = origLogger.Out
+	if level != "" {
+		var err error
+		if l.Level, err = logrus.ParseLevel(level); err != nil {
+			return nil, err
+		}
+	}
+	if out != nil {
+		l.Out = out
+	}
+	return logger{entry: logrus.NewEntry(l)}, nil
+}
+
+// ValidateLevel returns an error if level isn't a valid logging level.
+func ValidateLevel(level string) error {
+	_, err := logrus.ParseLevel(level)
+	return err
+}
+
 // Debug logs a message at level Debug on the standard logger.
 func Debug(args ...interface{}) {
 	baseLogger.sourced().Debug(args...)
@@ -68,3 +68,24 @@ func logging() {
 	Infof("This info-level line should show up in the output.")
 	Debugf("This debug-level line should show up in the output.")
 }
+
+func TestNew(t *testing.T) {
+	var base, check bytes.Buffer
+	SetOutput(&base)
+	assert.NoError(t, SetLevel("info"))
+
+	l, err := New("debug", &check)
+	assert.NoError(t, err)
+	l.Debug("instance debug")
+	Debug("base debug")
+	Info("base info")
+
+	assert.Contains(t, check.String(), `level=debug msg="instance debug" source="log_test.go:`)
+	assert.NotContains(t, check.String(), "base")
+	assert.NotContains(t, base.String(), "instance debug")
+	assert.NotContains(t, base.String(), "base debug")
+	assert.Contains(t, base.String(), "base info")
+
+	_, err = New("verbose", nil)
+	assert.Error(t, err)
+}
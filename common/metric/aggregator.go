@@ -2,6 +2,7 @@ package metric
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -19,8 +20,10 @@ type Aggregator interface {
 		deviceName string,
 		t ...int64)
 
-	SubmitPackets(packet string)
+	SubmitPackets(packet string, extraTags ...string)
 	Add(metricType string, m Metric)
+	AddEvent(e Event)
+	AddServiceCheck(sc ServiceCheck)
 	Flush()
 }
 
@@ -30,16 +33,34 @@ const (
 
 	// DefaultExpirySeconds is default to 5 minutes
 	DefaultExpirySeconds = 5 * 60
+
+	// droppedContextsMetric counts the samples dropped because the
+	// aggregator held contextLimit contexts.
+	droppedContextsMetric = "cloudinsight.agent.contexts_dropped"
+
+	// maxDroppedNames bounds the names tracked for the context limit
+	// warning, so that the guard doesn't grow without limit itself.
+	maxDroppedNames = 1000
+
+	// topDroppedNames is the number of names logged by the warning.
+	topDroppedNames = 5
 )
 
 // NewAggregator creates a new instance of aggregator.
 func NewAggregator(
 	metrics chan Metric,
+	events chan Event,
+	serviceChecks chan ServiceCheck,
 	interval float64,
 	hostname string,
 	formatter Formatter,
 	histogramAggregates []string,
 	histogramPercentiles []float64,
+	histogramPrefixes []HistogramPrefix,
+	contextLimit int,
+	filter *Filter,
+	transformer *Transformer,
+	lateSampleWindow int64,
 	recentPointThreshold int64,
 	expiry ...int64,
 ) Aggregator {
@@ -56,12 +77,19 @@ func NewAggregator(
 
 	return &aggregator{
 		metrics:              metrics,
+		events:               events,
+		serviceChecks:        serviceChecks,
 		context:              make(map[Context]Generator),
 		interval:             interval,
 		hostname:             hostname,
 		formatter:            formatter,
 		histogramAggregates:  histogramAggregates,
 		histogramPercentiles: histogramPercentiles,
+		histogramPrefixes:    histogramPrefixes,
+		contextLimit:         contextLimit,
+		filter:               filter,
+		transformer:          transformer,
+		lateSampleWindow:     lateSampleWindow,
 		recentPointThreshold: recentPointThreshold,
 		expirySeconds:        expirySeconds,
 	}
@@ -69,12 +97,30 @@ func NewAggregator(
 
 type aggregator struct {
 	metrics              chan Metric
+	events               chan Event
+	pendingEvents        []Event
+	serviceChecks        chan ServiceCheck
+	pendingServiceChecks []ServiceCheck
 	context              map[Context]Generator
 	interval             float64
 	hostname             string
 	formatter            Formatter
 	histogramAggregates  []string
 	histogramPercentiles []float64
+	histogramPrefixes    []HistogramPrefix
+	// contextLimit caps the number of contexts, the ones of the buckets of
+	// the late samples included, 0 means unlimited.
+	contextLimit int
+	droppedNames map[string]int64
+	filter       *Filter
+	transformer  *Transformer
+	// lateSampleWindow is the number of seconds the samples with a timestamp
+	// older than the current interval are accepted, they are aggregated in
+	// buckets of their own interval. 0 stamps them at the flush time.
+	lateSampleWindow     int64
+	buckets              map[int64]map[Context]Generator
+	bucketContexts       int
+	discardedLatePoints  int64
 	recentPointThreshold int64
 	discardedOldPoints   int64
 	expirySeconds        int64
@@ -101,7 +147,7 @@ func (agg *aggregator) AddMetrics(
 
 	for name, value := range fields {
 		agg.Add(metricType, Metric{
-			Name:       strings.Join([]string{prefix, name}, "."),
+			Name:       prefix + "." + name,
 			Value:      value,
 			Tags:       tags,
 			DeviceName: deviceName,
@@ -110,27 +156,91 @@ func (agg *aggregator) AddMetrics(
 	}
 }
 
+// SubmitPackets parses the statsd lines of the packet and adds the resulting
+// metrics, extraTags are appended to the tags of every metric.
 func (agg *aggregator) SubmitPackets(
 	packet string,
+	extraTags ...string,
 ) {
+	parsed := ParsePackets(packet, extraTags...)
+	for _, m := range parsed.Metrics {
+		agg.Add(m.Type, m)
+	}
+	for _, e := range parsed.Events {
+		agg.AddEvent(e)
+	}
+	for _, sc := range parsed.ServiceChecks {
+		agg.AddServiceCheck(sc)
+	}
+}
+
+// ParsedPacket holds what was decoded from a statsd packet.
+type ParsedPacket struct {
+	Metrics       []Metric
+	Events        []Event
+	ServiceChecks []ServiceCheck
+	// Invalid is the number of lines which couldn't be parsed.
+	Invalid int
+}
+
+// ParsePackets parses the newline separated statsd lines of a packet, the
+// lines which can't be parsed are logged and skipped. It doesn't touch any
+// aggregator state, so packets can be decoded concurrently.
+func ParsePackets(
+	packet string,
+	extraTags ...string,
+) ParsedPacket {
+	var result ParsedPacket
 	packets := strings.Split(packet, "\n")
 	for _, packet := range packets {
 		packet = strings.TrimSpace(packet)
+		if isServiceCheckPacket(packet) {
+			sc, err := parseServiceCheck(packet)
+			if err != nil {
+				log.Error("Error occurred when parsing service check:", err)
+				result.Invalid++
+				continue
+			}
+			sc.Tags = append(sc.Tags, extraTags...)
+			result.ServiceChecks = append(result.ServiceChecks, sc)
+			continue
+		}
+		if isEventPacket(packet) {
+			e, err := parseEvent(packet)
+			if err != nil {
+				log.Error("Error occurred when parsing event:", err)
+				result.Invalid++
+				continue
+			}
+			e.Tags = append(e.Tags, extraTags...)
+			result.Events = append(result.Events, e)
+			continue
+		}
 		if packet != "" {
 			metrics, err := parsePacket(packet)
 			if err != nil {
 				log.Error("Error occurred when parsing packet:", err)
+				result.Invalid++
 				continue
 			}
 
 			for _, m := range metrics {
-				agg.Add(m.Type, m)
+				if len(extraTags) > 0 {
+					m.Tags = append(m.Tags, extraTags...)
+				}
+				result.Metrics = append(result.Metrics, m)
 			}
 		}
 	}
+	return result
 }
 
 func (agg *aggregator) Add(metricType string, m Metric) {
+	metricType = agg.transformer.Apply(metricType, &m)
+	if !agg.filter.Apply(&m) {
+		return
+	}
+
 	if m.Hostname == "" {
 		m.Hostname = agg.hostname
 	}
@@ -142,11 +252,22 @@ func (agg *aggregator) Add(metricType string, m Metric) {
 		return
 	}
 
+	if agg.isLate(metricType, m.Timestamp, timestamp) {
+		agg.addLate(metricType, m, timestamp)
+		return
+	}
+
 	ctx := m.context()
 	generator, ok := agg.context[ctx]
 	if !ok {
+		if agg.contextsFull() {
+			agg.dropContext(m.Name)
+			return
+		}
+
 		var err error
-		generator, err = NewGenerator(metricType, m, agg.formatter, agg.histogramAggregates, agg.histogramPercentiles)
+		aggregates, percentiles := agg.histogramOptions(m.Name)
+		generator, err = NewGenerator(metricType, m, agg.formatter, aggregates, percentiles)
 		if err != nil {
 			log.Errorf("Error adding metric [%v]: %s", m, err.Error())
 			return
@@ -163,11 +284,217 @@ func (agg *aggregator) Add(metricType string, m Metric) {
 	generator.Sample(value, m.Timestamp)
 }
 
+// isLate returns true when a sample timestamped ts belongs to a previous
+// interval and should be aggregated in its bucket. The rates and the
+// monotonic counts compute deltas between consecutive samples, they are
+// never bucketed.
+func (agg *aggregator) isLate(metricType string, ts, now int64) bool {
+	if agg.lateSampleWindow <= 0 || ts <= 0 {
+		return false
+	}
+	if metricType == "rate" || metricType == "monotonic_count" {
+		return false
+	}
+	return agg.bucketStart(ts) < agg.bucketStart(now)
+}
+
+// bucketStart returns the start of the interval of ts.
+func (agg *aggregator) bucketStart(ts int64) int64 {
+	interval := int64(agg.interval)
+	if interval < 1 {
+		interval = 1
+	}
+	return ts - ts%interval
+}
+
+// addLate samples m in the bucket of its timestamp, or discards it when it's
+// older than the late sample window.
+func (agg *aggregator) addLate(metricType string, m Metric, now int64) {
+	if now-m.Timestamp > agg.lateSampleWindow {
+		log.Debugf("Discarding late %s - ts = %d , current ts = %d ", m.Name, m.Timestamp, now)
+		agg.discardedLatePoints++
+		return
+	}
+
+	if agg.buckets == nil {
+		agg.buckets = make(map[int64]map[Context]Generator)
+	}
+	start := agg.bucketStart(m.Timestamp)
+	bucket, ok := agg.buckets[start]
+	if !ok {
+		bucket = make(map[Context]Generator)
+		agg.buckets[start] = bucket
+	}
+
+	ctx := m.context()
+	generator, ok := bucket[ctx]
+	if !ok {
+		if agg.contextsFull() {
+			agg.dropContext(m.Name)
+			return
+		}
+
+		var err error
+		aggregates, percentiles := agg.histogramOptions(m.Name)
+		generator, err = NewGenerator(metricType, m, agg.formatter, aggregates, percentiles)
+		if err != nil {
+			log.Errorf("Error adding metric [%v]: %s", m, err.Error())
+			return
+		}
+		bucket[ctx] = generator
+		agg.bucketContexts++
+	}
+
+	value, err := m.getCorrectedValue()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	generator.Sample(value, m.Timestamp)
+}
+
+// flushBuckets flushes the late samples stamped with the start of their
+// interval. A bucket is flushed once, the samples arriving later for the same
+// interval end up in a new one.
+func (agg *aggregator) flushBuckets() {
+	for start, bucket := range agg.buckets {
+		for _, generator := range bucket {
+			for _, m := range generator.Flush(start, agg.interval) {
+				agg.metrics <- m
+			}
+		}
+		delete(agg.buckets, start)
+	}
+	agg.bucketContexts = 0
+
+	if agg.discardedLatePoints > 0 {
+		log.Warnf("%d points were discarded as a result of arriving after the late sample window of %ds",
+			agg.discardedLatePoints, agg.lateSampleWindow)
+		agg.discardedLatePoints = 0
+	}
+}
+
+// contextsFull tells whether the aggregator holds contextLimit contexts,
+// counting the ones of the current interval and of the buckets.
+func (agg *aggregator) contextsFull() bool {
+	return agg.contextLimit > 0 && len(agg.context)+agg.bucketContexts >= agg.contextLimit
+}
+
+// dropContext records a sample whose context was refused because of the
+// context limit.
+func (agg *aggregator) dropContext(name string) {
+	if agg.droppedNames == nil {
+		agg.droppedNames = make(map[string]int64)
+	}
+	if _, ok := agg.droppedNames[name]; ok || len(agg.droppedNames) < maxDroppedNames {
+		agg.droppedNames[name]++
+	}
+}
+
+// flushDroppedContexts warns about the samples dropped by the context limit
+// since the last flush, with the metric names dropping the most.
+func (agg *aggregator) flushDroppedContexts(timestamp int64) {
+	if len(agg.droppedNames) == 0 {
+		return
+	}
+
+	var total int64
+	names := make([]string, 0, len(agg.droppedNames))
+	for name, n := range agg.droppedNames {
+		total += n
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if agg.droppedNames[names[i]] == agg.droppedNames[names[j]] {
+			return names[i] < names[j]
+		}
+		return agg.droppedNames[names[i]] > agg.droppedNames[names[j]]
+	})
+	if len(names) > topDroppedNames {
+		names = names[:topDroppedNames]
+	}
+	top := make([]string, len(names))
+	for i, name := range names {
+		top[i] = fmt.Sprintf("%s (%d)", name, agg.droppedNames[name])
+	}
+	log.Warnf("The limit of %d contexts was reached, %d points were dropped, top metrics: %s",
+		agg.contextLimit, total, strings.Join(top, ", "))
+
+	agg.metrics <- Metric{
+		Name:       droppedContextsMetric,
+		Value:      float64(total),
+		Hostname:   agg.hostname,
+		Timestamp:  timestamp,
+		Type:       "count",
+		Samplerate: 1,
+		Formatter:  agg.formatter,
+	}
+	agg.droppedNames = nil
+}
+
+// histogramOptions returns the aggregates and percentiles of the histogram
+// named name, from its longest matching prefix.
+func (agg *aggregator) histogramOptions(name string) ([]string, []float64) {
+	aggregates, percentiles := agg.histogramAggregates, agg.histogramPercentiles
+
+	var match *HistogramPrefix
+	for i, p := range agg.histogramPrefixes {
+		if strings.HasPrefix(name, p.Prefix) && (match == nil || len(p.Prefix) > len(match.Prefix)) {
+			match = &agg.histogramPrefixes[i]
+		}
+	}
+	if match != nil {
+		if len(match.Aggregates) > 0 {
+			aggregates = match.Aggregates
+		}
+		if len(match.Percentiles) > 0 {
+			percentiles = match.Percentiles
+		}
+	}
+	return aggregates, percentiles
+}
+
+// AddEvent keeps the event until the next Flush.
+func (agg *aggregator) AddEvent(e Event) {
+	if e.Hostname == "" {
+		e.Hostname = agg.hostname
+	}
+	if e.Timestamp == 0 {
+		e.Timestamp = time.Now().Unix()
+	}
+	agg.pendingEvents = append(agg.pendingEvents, e)
+}
+
+// AddServiceCheck keeps the service check until the next Flush.
+func (agg *aggregator) AddServiceCheck(sc ServiceCheck) {
+	if sc.Hostname == "" {
+		sc.Hostname = agg.hostname
+	}
+	if sc.Timestamp == 0 {
+		sc.Timestamp = time.Now().Unix()
+	}
+	agg.pendingServiceChecks = append(agg.pendingServiceChecks, sc)
+}
+
 func (agg *aggregator) Flush() {
+	if agg.events != nil {
+		for _, e := range agg.pendingEvents {
+			agg.events <- e
+		}
+	}
+	agg.pendingEvents = nil
+
+	if agg.serviceChecks != nil {
+		for _, sc := range agg.pendingServiceChecks {
+			agg.serviceChecks <- sc
+		}
+	}
+	agg.pendingServiceChecks = nil
+
 	timestamp := time.Now().Unix()
 	for ctx, generator := range agg.context {
 		if generator.IsExpired(timestamp, agg.expirySeconds) {
-			log.Debugf("%v hasn't been submitted in %ds. Expiring.", ctx, agg.expirySeconds)
+			log.Debugf("Context %x hasn't been submitted in %ds. Expiring.", ctx, agg.expirySeconds)
 
 			delete(agg.context, ctx)
 			continue
@@ -179,6 +506,9 @@ func (agg *aggregator) Flush() {
 		}
 	}
 
+	agg.flushBuckets()
+	agg.flushDroppedContexts(timestamp)
+
 	// Log a warning regarding metrics with old timestamps being submitted
 	if agg.discardedOldPoints > 0 {
 		log.Warnf("%d points were discarded as a result of having an old timestamp", agg.discardedOldPoints)
@@ -250,6 +580,8 @@ func parsePacket(
 			m.Value = util.Hash(pipesplit[0])
 		case "ms", "h":
 			m.Type = "histogram"
+		case "d":
+			m.Type = "distribution"
 		default:
 			log.Infof("Error: Statsd Metric type %s unsupported", pipesplit[1])
 			return nil, fmt.Errorf("Error Parsing statsd line")
@@ -277,6 +609,16 @@ func parsePacket(
 	return metrics, nil
 }
 
+// DogStatsD event: _e{<title_length>,<text_length>}:<title>|<text>|d:<timestamp>|#<tags>
+func isEventPacket(packet string) bool {
+	return strings.HasPrefix(packet, "_e{")
+}
+
+// DogStatsD service check: _sc|<name>|<status>|d:<timestamp>|#<tags>|m:<message>
+func isServiceCheckPacket(packet string) bool {
+	return strings.HasPrefix(packet, "_sc|")
+}
+
 func extractMagicTags(tags []string) (string, string, []string) {
 	var hostname, deviceName string
 	var recombinedTags []string
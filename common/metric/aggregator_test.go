@@ -548,6 +548,228 @@ func TestRateErrors(t *testing.T) {
 	assert.Len(t, a.metrics, 0)
 }
 
+func TestMonotonicCount(t *testing.T) {
+	a := aggregator{
+		metrics:  make(chan Metric, 10),
+		context:  make(map[Context]Generator),
+		interval: 1,
+		hostname: "myhost",
+	}
+	defer close(a.metrics)
+
+	// A single sample only sets the reference value
+	a.Add("monotonic_count", NewMetric("my.count", 100))
+	a.Flush()
+	assert.Len(t, a.metrics, 0)
+
+	a.Add("monotonic_count", NewMetric("my.count", 110))
+	a.Add("monotonic_count", NewMetric("my.count", 125))
+	a.Flush()
+	assert.Len(t, a.metrics, 1)
+	testm := <-a.metrics
+	assert.Equal(t, "my.count", testm.Name)
+	assert.Equal(t, "count", testm.Type)
+	assert.Equal(t, float64(25), getValue(testm))
+
+	// The counter is reset, the decrease is skipped and the new value
+	// becomes the reference
+	a.Add("monotonic_count", NewMetric("my.count", 5))
+	a.Flush()
+	assert.Len(t, a.metrics, 0)
+
+	a.Add("monotonic_count", NewMetric("my.count", 12))
+	a.Flush()
+	assert.Len(t, a.metrics, 1)
+	testm = <-a.metrics
+	assert.Equal(t, float64(7), getValue(testm))
+}
+
+func TestContextLimit(t *testing.T) {
+	a := aggregator{
+		metrics:      make(chan Metric, 10),
+		context:      make(map[Context]Generator),
+		interval:     1,
+		hostname:     "myhost",
+		contextLimit: 2,
+	}
+	defer close(a.metrics)
+
+	a.Add("gauge", NewMetric("my.gauge", 1, []string{"id:1"}))
+	a.Add("gauge", NewMetric("my.gauge", 1, []string{"id:2"}))
+	// The known contexts are still sampled
+	a.Add("gauge", NewMetric("my.gauge", 2, []string{"id:1"}))
+	a.Add("gauge", NewMetric("my.gauge", 1, []string{"id:3"}))
+	a.Add("gauge", NewMetric("my.gauge", 1, []string{"id:4"}))
+	a.Add("gauge", NewMetric("other.gauge", 1))
+	assert.Len(t, a.context, 2)
+
+	a.Flush()
+	metrics := make([]Metric, 0, len(a.metrics))
+	for len(a.metrics) > 0 {
+		metrics = append(metrics, <-a.metrics)
+	}
+	sort.Sort(MetricSorter(metrics))
+	assert.Len(t, metrics, 3)
+	assert.Equal(t, "cloudinsight.agent.contexts_dropped", metrics[0].Name)
+	assert.Equal(t, "count", metrics[0].Type)
+	assert.Equal(t, float64(3), getValue(metrics[0]))
+	assert.Equal(t, float64(1), getValue(metrics[1]))
+	assert.Equal(t, float64(2), getValue(metrics[2]))
+
+	// Nothing was dropped since the last flush
+	a.Add("gauge", NewMetric("my.gauge", 1, []string{"id:1"}))
+	a.Flush()
+	assert.Len(t, a.metrics, 1)
+}
+
+func TestLateSamples(t *testing.T) {
+	a := aggregator{
+		metrics:              make(chan Metric, 10),
+		context:              make(map[Context]Generator),
+		interval:             10,
+		hostname:             "myhost",
+		lateSampleWindow:     60,
+		recentPointThreshold: DefaultRecentPointThreshold,
+	}
+	defer close(a.metrics)
+
+	now := time.Now().Unix()
+	current := now - now%10
+	late := current - 20
+
+	a.Add("count", Metric{Name: "my.count", Value: 1, Timestamp: late + 1})
+	a.Add("count", Metric{Name: "my.count", Value: 2, Timestamp: late + 9})
+	a.Add("count", Metric{Name: "my.count", Value: 4, Timestamp: now})
+	// Older than the window
+	a.Add("count", Metric{Name: "my.count", Value: 8, Timestamp: now - 120})
+	// The rates are never bucketed
+	a.Add("rate", Metric{Name: "my.rate", Value: 1, Timestamp: late})
+
+	a.Flush()
+	metrics := make([]Metric, 0, len(a.metrics))
+	for len(a.metrics) > 0 {
+		metrics = append(metrics, <-a.metrics)
+	}
+	sort.Sort(MetricSorter(metrics))
+	assert.Len(t, metrics, 2)
+	assert.Equal(t, float64(3), getValue(metrics[0]))
+	assert.Equal(t, late, metrics[0].Timestamp)
+	assert.Equal(t, float64(4), getValue(metrics[1]))
+	assert.True(t, metrics[1].Timestamp >= now)
+	assert.Len(t, a.buckets, 0)
+
+	// Without a window, the samples are reported in the current interval
+	a.lateSampleWindow = 0
+	a.Add("count", Metric{Name: "other.count", Value: 1, Timestamp: late})
+	a.Flush()
+	assert.Len(t, a.metrics, 1)
+	assert.True(t, (<-a.metrics).Timestamp >= now)
+}
+
+func TestLateSamplesContextLimit(t *testing.T) {
+	a := aggregator{
+		metrics:              make(chan Metric, 10),
+		context:              make(map[Context]Generator),
+		interval:             10,
+		hostname:             "myhost",
+		contextLimit:         3,
+		lateSampleWindow:     60,
+		recentPointThreshold: DefaultRecentPointThreshold,
+	}
+	defer close(a.metrics)
+
+	now := time.Now().Unix()
+	current := now - now%10
+
+	a.Add("gauge", Metric{Name: "my.gauge", Value: 1, Timestamp: now})
+	// The contexts of every bucket count against the limit.
+	a.Add("gauge", Metric{Name: "my.gauge", Value: 1, Timestamp: current - 10})
+	a.Add("gauge", Metric{Name: "my.gauge", Value: 1, Timestamp: current - 20})
+	a.Add("gauge", Metric{Name: "my.gauge", Value: 1, Timestamp: current - 30})
+	a.Add("gauge", Metric{Name: "late.gauge", Value: 1, Timestamp: current - 10})
+	// As they do against the ones of the current interval.
+	a.Add("gauge", Metric{Name: "other.gauge", Value: 1, Timestamp: now})
+	// The known contexts are still sampled.
+	a.Add("gauge", Metric{Name: "my.gauge", Value: 2, Timestamp: current - 10})
+	assert.Equal(t, 2, a.bucketContexts)
+	assert.Len(t, a.context, 1)
+
+	a.Flush()
+	metrics := make([]Metric, 0, len(a.metrics))
+	for len(a.metrics) > 0 {
+		metrics = append(metrics, <-a.metrics)
+	}
+	sort.Sort(MetricSorter(metrics))
+	assert.Len(t, metrics, 4)
+	assert.Equal(t, "cloudinsight.agent.contexts_dropped", metrics[0].Name)
+	assert.Equal(t, float64(3), getValue(metrics[0]))
+	assert.Equal(t, 0, a.bucketContexts)
+}
+
+func TestContext(t *testing.T) {
+	m1 := NewMetric("my.metric", 1, []string{"b:2", "a:1", "a:1"})
+	m2 := NewMetric("my.metric", 1, []string{"a:1", "b:2"})
+	assert.Equal(t, m1.context(), m2.context())
+
+	m2.Hostname = "myhost"
+	assert.NotEqual(t, m1.context(), m2.context())
+
+	m3 := NewMetric("my.metric", 1, []string{"a:1"})
+	m3.DeviceName = "myhost"
+	m4 := NewMetric("my.metric", 1, []string{"a:1"})
+	m4.Hostname = "myhost"
+	assert.NotEqual(t, m3.context(), m4.context())
+
+	// The long lists of tags use a pooled buffer
+	var tags []string
+	for i := 0; i < 40; i++ {
+		tags = append(tags, fmt.Sprintf("tag:%d", i))
+	}
+	m5 := NewMetric("my.metric", 1, tags)
+	reversed := make([]string, len(tags))
+	for i, tag := range tags {
+		reversed[len(tags)-1-i] = tag
+	}
+	m6 := NewMetric("my.metric", 1, reversed)
+	assert.Equal(t, m5.context(), m6.context())
+}
+
+func TestAddDoesNotAllocate(t *testing.T) {
+	a := aggregator{
+		metrics:              make(chan Metric, 10),
+		context:              make(map[Context]Generator),
+		interval:             1,
+		hostname:             "myhost",
+		recentPointThreshold: DefaultRecentPointThreshold,
+	}
+	defer close(a.metrics)
+
+	m := NewMetric("my.metric", 1.5, []string{"env:prod", "role:db"})
+	for _, metricType := range []string{"gauge", "count", "counter"} {
+		a.Add(metricType, m)
+		allocs := testing.AllocsPerRun(100, func() {
+			a.Add(metricType, m)
+		})
+		assert.Equal(t, float64(0), allocs, metricType)
+		m.Name += "." + metricType
+	}
+}
+
+func BenchmarkAdd(b *testing.B) {
+	a := aggregator{
+		metrics:              make(chan Metric, 10),
+		context:              make(map[Context]Generator),
+		interval:             1,
+		hostname:             "myhost",
+		recentPointThreshold: DefaultRecentPointThreshold,
+	}
+	m := NewMetric("my.metric", 1.5, []string{"env:prod", "role:db", "service:api"})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		a.Add("counter", m)
+	}
+}
+
 func TestHistogram(t *testing.T) {
 	a := aggregator{
 		metrics:             make(chan Metric, 10),
@@ -837,3 +1059,158 @@ func TestInvalidPackets(t *testing.T) {
 		}
 	}
 }
+
+func TestDogStatsDPackets(t *testing.T) {
+	a := aggregator{
+		metrics:       make(chan Metric, 10),
+		events:        make(chan Event, 10),
+		serviceChecks: make(chan ServiceCheck, 10),
+		context:       make(map[Context]Generator),
+		interval:      1,
+		hostname:      "myhost",
+	}
+	defer close(a.metrics)
+
+	a.SubmitPackets("_e{5,4}:title|text|#tag1\n" +
+		"my.counter:1|c|#env:prod\n" +
+		"_sc|my.service|0|#tag1|m:ok")
+	assert.Len(t, a.events, 0)
+	assert.Len(t, a.serviceChecks, 0)
+	a.Flush()
+	assert.Len(t, a.metrics, 1)
+	assert.Len(t, a.events, 1)
+	assert.Len(t, a.serviceChecks, 1)
+
+	testm := <-a.metrics
+	assert.Equal(t, "my.counter", testm.Name)
+	assert.Equal(t, []string{"env:prod"}, testm.Tags)
+
+	e := <-a.events
+	assert.Equal(t, "title", e.Title)
+	assert.Equal(t, "myhost", e.Hostname)
+	assert.NotZero(t, e.Timestamp)
+
+	sc := <-a.serviceChecks
+	assert.Equal(t, "my.service", sc.Name)
+	assert.Equal(t, ServiceCheckOK, sc.Status)
+	assert.Equal(t, "myhost", sc.Hostname)
+	assert.Equal(t, "ok", sc.Message)
+	assert.NotZero(t, sc.Timestamp)
+}
+
+func TestExtraTags(t *testing.T) {
+	a := aggregator{
+		metrics:  make(chan Metric, 10),
+		context:  make(map[Context]Generator),
+		interval: 1,
+		hostname: "myhost",
+	}
+	defer close(a.metrics)
+
+	a.SubmitPackets("my.counter:1|c|#env:prod", "container_id:abc")
+	a.SubmitPackets("my.gauge:1|g", "container_id:abc")
+	a.Flush()
+	assert.Len(t, a.metrics, 2)
+
+	metrics := []Metric{<-a.metrics, <-a.metrics}
+	sort.Sort(MetricSorter(metrics))
+	assert.Equal(t, []string{"env:prod", "container_id:abc"}, metrics[0].Tags)
+	assert.Equal(t, []string{"container_id:abc"}, metrics[1].Tags)
+}
+
+func TestParsePackets(t *testing.T) {
+	parsed := ParsePackets("first:1|c\ninvalid\n\nsecond:2|g|#tag1\r\nthird:3|h:4|h\n_e{5,4}:title|text\n_sc|check|0", "extra")
+	assert.Len(t, parsed.Metrics, 4)
+	assert.Len(t, parsed.Events, 1)
+	assert.Len(t, parsed.ServiceChecks, 1)
+	assert.Equal(t, []string{"extra"}, parsed.ServiceChecks[0].Tags)
+	assert.Equal(t, 1, parsed.Invalid)
+	assert.Equal(t, []string{"extra"}, parsed.Events[0].Tags)
+
+	names := []string{}
+	for _, m := range parsed.Metrics {
+		names = append(names, m.Name)
+		assert.Contains(t, m.Tags, "extra")
+	}
+	assert.Equal(t, []string{"first", "second", "third", "third"}, names)
+}
+
+func TestHistogramPrefixes(t *testing.T) {
+	a := aggregator{
+		metrics:              make(chan Metric, 20),
+		context:              make(map[Context]Generator),
+		interval:             1,
+		hostname:             "myhost",
+		histogramAggregates:  []string{"max"},
+		histogramPercentiles: []float64{0.5},
+		histogramPrefixes: []HistogramPrefix{
+			{Prefix: "http.", Percentiles: []float64{0.99, 0.999}},
+			{Prefix: "http.latency", Aggregates: []string{"avg", "count"}},
+		},
+	}
+	defer close(a.metrics)
+
+	for i := 1; i <= 1000; i++ {
+		a.SubmitPackets(fmt.Sprintf("other:%d|h", i))
+		a.SubmitPackets(fmt.Sprintf("http.size:%d|h", i))
+		a.SubmitPackets(fmt.Sprintf("http.latency:%d|h", i))
+	}
+	a.Flush()
+
+	values := make(map[string]float64)
+	for len(a.metrics) > 0 {
+		m := <-a.metrics
+		values[m.Name] = getValue(m)
+	}
+	assert.Equal(t, map[string]float64{
+		"other.max":                 1000,
+		"other.50percentile":        500,
+		"http.size.max":             1000,
+		"http.size.99percentile":    990,
+		"http.size.99_9percentile":  999,
+		"http.latency.avg":          500.5,
+		"http.latency.count":        1000,
+		"http.latency.50percentile": 500,
+	}, values)
+}
+
+func TestDistribution(t *testing.T) {
+	a := aggregator{
+		metrics:  make(chan Metric, 10),
+		context:  make(map[Context]Generator),
+		interval: 1,
+		hostname: "myhost",
+	}
+	defer close(a.metrics)
+
+	for i := 1; i <= 100; i++ {
+		a.SubmitPackets(fmt.Sprintf("my.dist:%d|d|#tag1", i))
+	}
+	a.SubmitPackets("my.sampled.dist:1000|d|@0.5")
+	a.Flush()
+	assert.Len(t, a.metrics, 2)
+
+	metrics := []Metric{<-a.metrics, <-a.metrics}
+	sort.Sort(MetricSorter(metrics))
+
+	m := metrics[0]
+	assert.Equal(t, "my.dist", m.Name)
+	assert.Equal(t, "distribution", m.Type)
+	assert.Equal(t, []string{"tag1"}, m.Tags)
+	sketch, ok := m.Value.(*Sketch)
+	if assert.True(t, ok) {
+		assert.Equal(t, float64(100), sketch.Count())
+		assert.Equal(t, float64(100), sketch.Quantile(1))
+	}
+
+	m = metrics[1]
+	assert.Equal(t, "my.sampled.dist", m.Name)
+	sketch, ok = m.Value.(*Sketch)
+	if assert.True(t, ok) {
+		assert.Equal(t, float64(2), sketch.Count())
+	}
+
+	// Ensure that distributions are reset.
+	a.Flush()
+	assert.Len(t, a.metrics, 0)
+}
@@ -0,0 +1,147 @@
+package metric
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Event priorities.
+const (
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// Event alert types.
+const (
+	AlertInfo    = "info"
+	AlertWarning = "warning"
+	AlertError   = "error"
+	AlertSuccess = "success"
+)
+
+// defaultEventSource groups the events which don't set a SourceTypeName.
+const defaultEventSource = "api"
+
+// Event is something that happened at a point in time, like a deployment or
+// a failover, it's sent along with the metrics.
+type Event struct {
+	Title          string   `json:"msg_title"`
+	Text           string   `json:"msg_text"`
+	Timestamp      int64    `json:"timestamp"`
+	Priority       string   `json:"priority,omitempty"`
+	AlertType      string   `json:"alert_type,omitempty"`
+	Tags           []string `json:"tags,omitempty"`
+	AggregationKey string   `json:"aggregation_key,omitempty"`
+	SourceTypeName string   `json:"source_type_name,omitempty"`
+	Hostname       string   `json:"host,omitempty"`
+}
+
+// NewEvent creates a new instance of Event.
+func NewEvent(title, text string, tags ...[]string) Event {
+	e := Event{
+		Title: title,
+		Text:  text,
+	}
+	if len(tags) > 0 {
+		e.Tags = tags[0]
+	}
+	return e
+}
+
+// FormatEvents groups the events by source type, that's how they are sent in
+// the payload.
+func FormatEvents(events []Event) map[string]interface{} {
+	if len(events) == 0 {
+		return nil
+	}
+
+	grouped := make(map[string][]Event)
+	for _, e := range events {
+		source := e.SourceTypeName
+		if source == "" {
+			source = defaultEventSource
+		}
+		grouped[source] = append(grouped[source], e)
+	}
+
+	formatted := make(map[string]interface{}, len(grouped))
+	for source, events := range grouped {
+		formatted[source] = events
+	}
+	return formatted
+}
+
+// Schema of a DogStatsD event packet:
+// _e{<title_length>,<text_length>}:<title>|<text>|d:<timestamp>|h:<hostname>|p:<priority>|t:<alert_type>|k:<aggregation_key>|s:<source_type_name>|#<tag1>,<tag2>
+// For example:
+// _e{21,36}:An exception occurred|Cannot parse CSV file from 10.0.0.17|t:warning|#err_type:bad_file
+func parseEvent(packet string) (Event, error) {
+	var e Event
+
+	header := strings.SplitN(packet, ":", 2)
+	if len(header) != 2 || !strings.HasPrefix(header[0], "_e{") || !strings.HasSuffix(header[0], "}") {
+		return e, fmt.Errorf("Error parsing event packet %s", packet)
+	}
+
+	lengths := strings.Split(header[0][3:len(header[0])-1], ",")
+	if len(lengths) != 2 {
+		return e, fmt.Errorf("Error parsing event lengths from packet %s", packet)
+	}
+	titleLen, err := strconv.Atoi(lengths[0])
+	if err != nil {
+		return e, fmt.Errorf("Error parsing event title length from packet %s", packet)
+	}
+	textLen, err := strconv.Atoi(lengths[1])
+	if err != nil {
+		return e, fmt.Errorf("Error parsing event text length from packet %s", packet)
+	}
+
+	body := header[1]
+	if titleLen < 0 || textLen < 0 || titleLen+1+textLen > len(body) || body[titleLen] != '|' {
+		return e, fmt.Errorf("Error parsing event title and text from packet %s", packet)
+	}
+	e.Title = unescapeEventText(body[:titleLen])
+	e.Text = unescapeEventText(body[titleLen+1 : titleLen+1+textLen])
+
+	metadata := body[titleLen+1+textLen:]
+	if metadata == "" {
+		return e, nil
+	}
+	if metadata[0] != '|' {
+		return e, fmt.Errorf("Error parsing event metadata from packet %s", packet)
+	}
+
+	for _, segment := range strings.Split(metadata[1:], "|") {
+		switch {
+		case strings.HasPrefix(segment, "d:"):
+			e.Timestamp, err = strconv.ParseInt(segment[2:], 10, 64)
+			if err != nil {
+				return e, fmt.Errorf("Error parsing event timestamp from packet %s", packet)
+			}
+		case strings.HasPrefix(segment, "h:"):
+			e.Hostname = segment[2:]
+		case strings.HasPrefix(segment, "p:"):
+			e.Priority = segment[2:]
+		case strings.HasPrefix(segment, "t:"):
+			e.AlertType = segment[2:]
+		case strings.HasPrefix(segment, "k:"):
+			e.AggregationKey = segment[2:]
+		case strings.HasPrefix(segment, "s:"):
+			e.SourceTypeName = segment[2:]
+		case strings.HasPrefix(segment, "#"):
+			for _, tag := range strings.Split(segment[1:], ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					e.Tags = append(e.Tags, tag)
+				}
+			}
+		}
+	}
+
+	return e, nil
+}
+
+// The newlines of the title and text are escaped as \n.
+func unescapeEventText(s string) string {
+	return strings.Replace(s, "\\n", "\n", -1)
+}
@@ -0,0 +1,63 @@
+package metric
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEvent(t *testing.T) {
+	e, err := parseEvent("_e{21,36}:An exception occurred|Cannot parse CSV file from 10.0.0.17|t:warning|#err_type:bad_file")
+	require.NoError(t, err)
+	assert.Equal(t, Event{
+		Title:     "An exception occurred",
+		Text:      "Cannot parse CSV file from 10.0.0.17",
+		AlertType: AlertWarning,
+		Tags:      []string{"err_type:bad_file"},
+	}, e)
+
+	// The text may contain the separators, and escaped newlines.
+	e, err = parseEvent("_e{5,12}:title|a|b\\nc:d|e|f|d:1474867457|h:myhost|p:low|k:key|s:jenkins|#tag1,tag2")
+	require.NoError(t, err)
+	assert.Equal(t, Event{
+		Title:          "title",
+		Text:           "a|b\nc:d|e|f",
+		Timestamp:      1474867457,
+		Priority:       PriorityLow,
+		Hostname:       "myhost",
+		AggregationKey: "key",
+		SourceTypeName: "jenkins",
+		Tags:           []string{"tag1", "tag2"},
+	}, e)
+}
+
+func TestInvalidEvents(t *testing.T) {
+	invalidEvents := []string{
+		"_e{5,4}",
+		"_e{5}:title|text",
+		"_e{a,4}:title|text",
+		"_e{5,b}:title|text",
+		"_e{10,4}:title|text",
+		"_e{5,4}:titletext",
+		"_e{5,4}:title|textd:1",
+		"_e{5,4}:title|text|d:abc",
+	}
+
+	for _, packet := range invalidEvents {
+		_, err := parseEvent(packet)
+		assert.Error(t, err, packet)
+	}
+}
+
+func TestFormatEvents(t *testing.T) {
+	assert.Nil(t, FormatEvents(nil))
+
+	e1 := NewEvent("title1", "text1")
+	e2 := NewEvent("title2", "text2", []string{"tag"})
+	e2.SourceTypeName = "jenkins"
+	assert.Equal(t, map[string]interface{}{
+		"api":     []Event{e1},
+		"jenkins": []Event{e2},
+	}, FormatEvents([]Event{e1, e2}))
+}
@@ -0,0 +1,138 @@
+package metric
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FilterConfig selects the metrics and tags which are submitted. The patterns
+// are globs where "*" matches any characters, or regexes when enclosed in
+// slashes like "/^nginx\.(net|upstream)\./". Metric patterns match the metric
+// name, tag patterns match the tag name, the part before the first ":".
+type FilterConfig struct {
+	// MetricInclude keeps only the metrics matching one of the patterns.
+	MetricInclude []string `toml:"metric_include" yaml:"metric_include"`
+	// MetricExclude drops the metrics matching one of the patterns.
+	MetricExclude []string `toml:"metric_exclude" yaml:"metric_exclude"`
+	// TagInclude keeps only the tags matching one of the patterns.
+	TagInclude []string `toml:"tag_include" yaml:"tag_include"`
+	// TagExclude strips the tags matching one of the patterns.
+	TagExclude []string `toml:"tag_exclude" yaml:"tag_exclude"`
+}
+
+// IsEmpty returns true when conf doesn't filter anything.
+func (conf FilterConfig) IsEmpty() bool {
+	return len(conf.MetricInclude) == 0 && len(conf.MetricExclude) == 0 &&
+		len(conf.TagInclude) == 0 && len(conf.TagExclude) == 0
+}
+
+type filterRules struct {
+	metricInclude []*regexp.Regexp
+	metricExclude []*regexp.Regexp
+	tagInclude    []*regexp.Regexp
+	tagExclude    []*regexp.Regexp
+}
+
+// Filter applies the rules of one or more FilterConfig, a metric has to pass
+// all of them.
+type Filter struct {
+	rules []filterRules
+}
+
+// NewFilter compiles the configs, typically the global one followed by the
+// one of a check. It returns nil when none of them filters anything.
+func NewFilter(confs ...FilterConfig) (*Filter, error) {
+	f := &Filter{}
+	for _, conf := range confs {
+		if conf.IsEmpty() {
+			continue
+		}
+
+		var rules filterRules
+		var err error
+		if rules.metricInclude, err = compilePatterns(conf.MetricInclude); err != nil {
+			return nil, err
+		}
+		if rules.metricExclude, err = compilePatterns(conf.MetricExclude); err != nil {
+			return nil, err
+		}
+		if rules.tagInclude, err = compilePatterns(conf.TagInclude); err != nil {
+			return nil, err
+		}
+		if rules.tagExclude, err = compilePatterns(conf.TagExclude); err != nil {
+			return nil, err
+		}
+		f.rules = append(f.rules, rules)
+	}
+
+	if len(f.rules) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := compilePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter pattern %s: %s", pattern, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// compilePattern compiles a regex enclosed in slashes, or a glob where every
+// "*" is a group matching any characters.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		return regexp.Compile(pattern[1 : len(pattern)-1])
+	}
+	return regexp.Compile("^" + strings.Replace(regexp.QuoteMeta(pattern), `\*`, "(.*)", -1) + "$")
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns false if m should be dropped, otherwise the filtered tags are
+// removed from m. A nil Filter keeps everything.
+func (f *Filter) Apply(m *Metric) bool {
+	if f == nil {
+		return true
+	}
+
+	for _, rules := range f.rules {
+		if len(rules.metricInclude) > 0 && !matchAny(rules.metricInclude, m.Name) {
+			return false
+		}
+		if matchAny(rules.metricExclude, m.Name) {
+			return false
+		}
+
+		if len(rules.tagInclude) == 0 && len(rules.tagExclude) == 0 {
+			continue
+		}
+		// Don't modify the tags in place, they may be shared between metrics.
+		tags := make([]string, 0, len(m.Tags))
+		for _, tag := range m.Tags {
+			name := strings.SplitN(tag, ":", 2)[0]
+			if len(rules.tagInclude) > 0 && !matchAny(rules.tagInclude, name) {
+				continue
+			}
+			if matchAny(rules.tagExclude, name) {
+				continue
+			}
+			tags = append(tags, tag)
+		}
+		m.Tags = tags
+	}
+	return true
+}
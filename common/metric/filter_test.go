@@ -0,0 +1,75 @@
+package metric
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterMetrics(t *testing.T) {
+	f, err := NewFilter(FilterConfig{
+		MetricInclude: []string{"nginx.*", `/^system\.(cpu|mem)\./`},
+		MetricExclude: []string{"nginx.upstream.*"},
+	})
+	assert.NoError(t, err)
+
+	cases := map[string]bool{
+		"nginx.net.reading":          true,
+		"nginx.upstream.peers.fails": false,
+		"system.cpu.user":            true,
+		"system.memory":              false,
+		"redis.net.clients":          false,
+	}
+	for name, expected := range cases {
+		m := NewMetric(name, 1)
+		assert.Equal(t, expected, f.Apply(&m), name)
+	}
+}
+
+func TestFilterTags(t *testing.T) {
+	f, err := NewFilter(
+		FilterConfig{TagExclude: []string{"request_id"}},
+		FilterConfig{TagInclude: []string{"env", "role*"}},
+	)
+	assert.NoError(t, err)
+
+	tags := []string{"env:prod", "request_id:42", "role:db", "nokey", "host_role:db"}
+	m := NewMetric("my.metric", 1, tags)
+	assert.True(t, f.Apply(&m))
+	assert.Equal(t, []string{"env:prod", "role:db"}, m.Tags)
+	// The original tags are left untouched
+	assert.Len(t, tags, 5)
+}
+
+func TestNilFilter(t *testing.T) {
+	f, err := NewFilter(FilterConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+
+	m := NewMetric("my.metric", 1, []string{"env:prod"})
+	assert.True(t, f.Apply(&m))
+	assert.Equal(t, []string{"env:prod"}, m.Tags)
+}
+
+func TestInvalidFilter(t *testing.T) {
+	_, err := NewFilter(FilterConfig{TagExclude: []string{"/[/"}})
+	assert.Error(t, err)
+}
+
+func TestAggregatorFilter(t *testing.T) {
+	f, _ := NewFilter(FilterConfig{MetricExclude: []string{"debug.*"}})
+	a := aggregator{
+		metrics:  make(chan Metric, 10),
+		context:  make(map[Context]Generator),
+		interval: 1,
+		hostname: "myhost",
+		filter:   f,
+	}
+	defer close(a.metrics)
+
+	a.Add("gauge", NewMetric("debug.gauge", 1))
+	a.Add("gauge", NewMetric("my.gauge", 1))
+	a.Flush()
+	assert.Len(t, a.metrics, 1)
+	assert.Equal(t, "my.gauge", (<-a.metrics).Name)
+}
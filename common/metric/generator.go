@@ -2,9 +2,9 @@ package metric
 
 import (
 	"fmt"
-	"math"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cloudinsight/cloudinsight-agent/common/log"
@@ -17,8 +17,43 @@ var (
 
 	// DefaultHistogramPercentiles XXX
 	DefaultHistogramPercentiles = []float64{0.95}
+
+	// HistogramAggregates are the aggregates a histogram can emit.
+	HistogramAggregates = []string{"min", "max", "median", "avg", "count"}
 )
 
+// HistogramPrefix overrides the aggregates and percentiles emitted by the
+// histograms whose name starts with Prefix, the defaults of the aggregator
+// are used for the ones left empty.
+type HistogramPrefix struct {
+	Prefix      string
+	Aggregates  []string
+	Percentiles []float64
+}
+
+// ValidateHistogram checks the aggregates and percentiles of a histogram
+// configuration.
+func ValidateHistogram(aggregates []string, percentiles []float64) error {
+	for _, aggregate := range aggregates {
+		known := false
+		for _, name := range HistogramAggregates {
+			if aggregate == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown histogram aggregate %q, supported ones are %v", aggregate, HistogramAggregates)
+		}
+	}
+	for _, p := range percentiles {
+		if p <= 0 || p >= 1 {
+			return fmt.Errorf("histogram percentile %v must be between 0 and 1", p)
+		}
+	}
+	return nil
+}
+
 // Generator generates metrics
 type Generator interface {
 	Sample(value float64, timestamp int64)
@@ -26,7 +61,7 @@ type Generator interface {
 	IsExpired(timestamp, expirySeconds int64) bool
 }
 
-// NewGenerator creates a new instance of Generator(gauge, bucketGauge, counter, rate, count, set, histogram).
+// NewGenerator creates a new instance of Generator(gauge, bucketGauge, counter, rate, count, set, histogram, distribution).
 func NewGenerator(metricType string, metric Metric, formatter Formatter, histogramAggregates []string, histogramPercentiles []float64) (Generator, error) {
 	metric.Type = metricType
 	metric.Formatter = formatter
@@ -43,10 +78,10 @@ func NewGenerator(metricType string, metric Metric, formatter Formatter, histogr
 
 	switch metricType {
 	case "gauge":
-		return &gauge{metric}, nil
+		return &gauge{Metric: metric}, nil
 	case "bucketgauge":
 		return &bucketGauge{
-			gauge{metric},
+			gauge{Metric: metric},
 		}, nil
 	case "counter":
 		return &counter{
@@ -60,6 +95,10 @@ func NewGenerator(metricType string, metric Metric, formatter Formatter, histogr
 		return &count{
 			Metric: metric,
 		}, nil
+	case "monotonic_count":
+		return &monotonicCount{
+			Metric: metric,
+		}, nil
 	case "set":
 		return &set{
 			Metric: metric,
@@ -71,36 +110,46 @@ func NewGenerator(metricType string, metric Metric, formatter Formatter, histogr
 			aggregates:  histogramAggregates,
 			percentiles: histogramPercentiles,
 		}, nil
+	case "distribution":
+		return &distribution{
+			Metric: metric,
+		}, nil
 	default:
 		return nil, fmt.Errorf("unsupported metricType: %s", metricType)
 	}
 }
 
+// The generators keep their values in float64 fields rather than in
+// Metric.Value, so that sampling doesn't allocate an interface value.
+
 type gauge struct {
 	Metric
+
+	value    float64
+	hasValue bool
 }
 
 func (g *gauge) Sample(value float64, timestamp int64) {
-	g.Value = value
+	g.value = value
+	g.hasValue = true
 	g.Timestamp = timestamp
 	g.LastSampleTime = time.Now().Unix()
 }
 
 func (g *gauge) Flush(timestamp int64, interval float64) []Metric {
 	defer func() {
-		g.Value = nil
+		g.hasValue = false
 	}()
 
-	if g.Value == nil {
+	if !g.hasValue {
 		return nil
 	}
 
-	if g.Timestamp != 0 {
-		return []Metric{g.Metric}
-	}
-
 	m := g.Metric
-	m.Timestamp = timestamp
+	m.Value = g.value
+	if m.Timestamp == 0 {
+		m.Timestamp = timestamp
+	}
 	return []Metric{m}
 }
 
@@ -110,14 +159,15 @@ type bucketGauge struct {
 
 func (bg *bucketGauge) Flush(timestamp int64, interval float64) []Metric {
 	defer func() {
-		bg.Value = nil
+		bg.hasValue = false
 	}()
 
-	if bg.Value == nil {
+	if !bg.hasValue {
 		return nil
 	}
 
 	m := bg.Metric
+	m.Value = bg.value
 	m.Type = "gauge"
 	m.Timestamp = timestamp
 	return []Metric{m}
@@ -126,20 +176,15 @@ func (bg *bucketGauge) Flush(timestamp int64, interval float64) []Metric {
 type count struct {
 	Metric
 
+	value      float64
 	hasSampled bool
 }
 
 func (c *count) Sample(value float64, timestamp int64) {
 	if c.hasSampled {
-		correctedValue, err := c.getCorrectedValue()
-		if err != nil {
-			log.Error(err)
-			return
-		}
-
-		c.Value = correctedValue + value
+		c.value += value
 	} else {
-		c.Value = value * float64(int(1/c.Samplerate))
+		c.value = value * float64(int(1/c.Samplerate))
 		c.hasSampled = true
 	}
 
@@ -148,39 +193,59 @@ func (c *count) Sample(value float64, timestamp int64) {
 
 func (c *count) Flush(timestamp int64, interval float64) []Metric {
 	defer func() {
-		c.Value = nil
+		c.hasSampled = false
 	}()
 
-	if c.Value == nil {
+	if !c.hasSampled {
 		return nil
 	}
 
 	m := c.Metric
+	m.Value = c.value
 	m.Timestamp = timestamp
 	return []Metric{m}
 }
 
+// monotonicCount turns an ever-increasing counter into the increase seen
+// since the previous flush. A decreasing value means the counter has been
+// reset (e.g. the process restarted), the new value becomes the reference
+// instead of producing a negative delta.
 type monotonicCount struct {
 	Metric
 
-	preCounter float64
-	curCounter float64
-	count      float64
+	hasPrevious bool
+	preCounter  float64
+	count       float64
+	hasDelta    bool
 }
 
 func (mc *monotonicCount) Sample(value float64, timestamp int64) {
-	mc.preCounter = mc.curCounter
-	mc.curCounter = value
-	mc.count += math.Max(0, mc.curCounter-mc.preCounter)
+	if mc.hasPrevious {
+		if value >= mc.preCounter {
+			mc.count += value - mc.preCounter
+			mc.hasDelta = true
+		} else {
+			log.Infof("Metric %s has decreased. Counter may have been Reset.", mc.Name)
+		}
+	}
+
+	mc.preCounter = value
+	mc.hasPrevious = true
 	mc.LastSampleTime = time.Now().Unix()
 }
 
 func (mc *monotonicCount) Flush(timestamp int64, interval float64) []Metric {
 	defer func() {
 		mc.count = 0
+		mc.hasDelta = false
 	}()
 
+	if !mc.hasDelta {
+		return nil
+	}
+
 	m := mc.Metric
+	m.Type = "count"
 	m.Value = mc.count
 	m.Timestamp = timestamp
 	return []Metric{m}
@@ -189,6 +254,7 @@ func (mc *monotonicCount) Flush(timestamp int64, interval float64) []Metric {
 type counter struct {
 	Metric
 
+	value      float64
 	hasSampled bool
 }
 
@@ -199,15 +265,9 @@ func (ct *counter) Sample(value float64, timestamp int64) {
 	}
 
 	if ct.hasSampled {
-		correctedValue, err := ct.getCorrectedValue()
-		if err != nil {
-			log.Error(err)
-			return
-		}
-
-		ct.Value = correctedValue + value*float64(int(1/ct.Samplerate))
+		ct.value += value * float64(int(1/ct.Samplerate))
 	} else {
-		ct.Value = value * float64(int(1/ct.Samplerate))
+		ct.value = value * float64(int(1/ct.Samplerate))
 		ct.hasSampled = true
 	}
 
@@ -216,17 +276,11 @@ func (ct *counter) Sample(value float64, timestamp int64) {
 
 func (ct *counter) Flush(timestamp int64, interval float64) []Metric {
 	defer func() {
-		ct.Value = 0
+		ct.value = 0
 	}()
 
-	correctedValue, err := ct.getCorrectedValue()
-	if err != nil {
-		log.Error(err)
-		return nil
-	}
-
 	m := ct.Metric
-	m.Value = correctedValue / interval
+	m.Value = ct.value / interval
 	m.Timestamp = timestamp
 	return []Metric{m}
 }
@@ -294,9 +348,16 @@ func (h *histogram) Flush(timestamp int64, interval float64) []Metric {
 	}
 
 	for _, p := range h.percentiles {
+		index := util.Cast(p*float64(length) - 1)
+		if index < 0 {
+			index = 0
+		} else if index >= length {
+			index = length - 1
+		}
+
 		m := h.Metric
-		m.Name = fmt.Sprintf("%s.%spercentile", m.Name, strconv.Itoa(int(p*float64(100))))
-		m.Value = h.samples[util.Cast(p*float64(length)-1)]
+		m.Name = fmt.Sprintf("%s.%spercentile", m.Name, percentileName(p))
+		m.Value = h.samples[index]
 		m.Timestamp = timestamp
 		m.Type = "gauge"
 		metrics = append(metrics, m)
@@ -305,6 +366,48 @@ func (h *histogram) Flush(timestamp int64, interval float64) []Metric {
 	return metrics
 }
 
+// distribution aggregates the values in a Sketch, which is sent as is so
+// that the percentiles can be computed accurately across hosts.
+type distribution struct {
+	Metric
+
+	sketch *Sketch
+}
+
+func (d *distribution) Sample(value float64, timestamp int64) {
+	if d.Samplerate == 0 {
+		log.Error("The samplerate can not be zero.")
+		return
+	}
+	if d.sketch == nil {
+		d.sketch = NewSketch(DefaultSketchRelativeAccuracy, DefaultSketchMaxBins)
+	}
+	d.sketch.Add(value, 1/d.Samplerate)
+	d.LastSampleTime = time.Now().Unix()
+}
+
+func (d *distribution) Flush(timestamp int64, interval float64) []Metric {
+	defer func() {
+		d.sketch = nil
+	}()
+
+	if d.sketch == nil {
+		return nil
+	}
+
+	m := d.Metric
+	m.Value = d.sketch
+	m.Timestamp = timestamp
+	return []Metric{m}
+}
+
+// percentileName formats 0.95 as "95" and 0.999 as "99_9", so that it
+// doesn't add a dot to the metric name.
+func percentileName(p float64) string {
+	name := strconv.FormatFloat(util.Round(p*100, 3), 'f', -1, 64)
+	return strings.Replace(name, ".", "_", -1)
+}
+
 type set struct {
 	Metric
 
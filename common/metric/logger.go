@@ -0,0 +1,45 @@
+package metric
+
+import "github.com/cloudinsight/cloudinsight-agent/common/log"
+
+// WithLogger returns an Aggregator carrying l, the checks log with the
+// logger of the Aggregator they're given so that every instance may have its
+// own level and file.
+func WithLogger(agg Aggregator, l log.Logger) Aggregator {
+	if l == nil {
+		return agg
+	}
+	return &loggedAggregator{Aggregator: agg, l: l}
+}
+
+// Logger returns the logger carried by agg, or the base logger.
+func Logger(agg Aggregator) log.Logger {
+	if c, ok := agg.(loggerCarrier); ok {
+		if l := c.logger(); l != nil {
+			return l
+		}
+	}
+	return log.Base()
+}
+
+type loggerCarrier interface {
+	logger() log.Logger
+}
+
+type loggedAggregator struct {
+	Aggregator
+
+	l log.Logger
+}
+
+func (a *loggedAggregator) logger() log.Logger {
+	return a.l
+}
+
+// The tags are often added on top of the logger.
+func (t *taggedAggregator) logger() log.Logger {
+	if c, ok := t.Aggregator.(loggerCarrier); ok {
+		return c.logger()
+	}
+	return nil
+}
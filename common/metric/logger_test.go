@@ -0,0 +1,28 @@
+package metric
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithLogger(t *testing.T) {
+	agg := &MockAggregator{}
+	assert.Equal(t, log.Base(), Logger(agg))
+	assert.Equal(t, Aggregator(agg), WithLogger(agg, nil))
+
+	var out bytes.Buffer
+	l, err := log.New("debug", &out)
+	require.NoError(t, err)
+	logged := WithTags(WithLogger(agg, l), "env:prod")
+	Logger(logged).Debugf("instance debug")
+	assert.Contains(t, out.String(), `msg="instance debug"`)
+	assert.Equal(t, log.Base(), Logger(WithTags(agg, "env:prod")))
+
+	logged.Add("gauge", NewMetric("my.gauge", 1))
+	_, ok := agg.Get("my.gauge", "env:prod")
+	assert.True(t, ok)
+}
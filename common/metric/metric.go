@@ -3,12 +3,12 @@ package metric
 import (
 	"fmt"
 	"math"
-	"sort"
-	"strings"
+	"sync"
 )
 
-// Context XXX
-type Context [4]string
+// Context identifies the metrics aggregated together, it's a hash of their
+// name, tags, hostname and device name.
+type Context uint64
 
 // Formatter XXX
 type Formatter func(metric Metric) interface{}
@@ -44,6 +44,12 @@ func NewMetric(name string, value interface{}, tags ...[]string) Metric {
 	return m
 }
 
+// FloatValue returns the value of m as a float64, it fails for the values
+// which aren't numbers and for NaN.
+func (m *Metric) FloatValue() (float64, error) {
+	return m.getCorrectedValue()
+}
+
 func (m *Metric) getCorrectedValue() (float64, error) {
 	var value float64
 
@@ -73,22 +79,77 @@ func (m *Metric) getCorrectedValue() (float64, error) {
 	return value, nil
 }
 
-func (m *Metric) removeDuplicates(s []string) []string {
-	result := []string{}
-	found := map[string]bool{}
-	for _, val := range s {
-		if _, ok := found[val]; !ok {
-			result = append(result, val)
-			found[val] = true
-		}
+// FNV-1a, computed inline so that hashing a context doesn't allocate.
+const (
+	offset64 = 14695981039346656037
+	prime64  = 1099511628211
+)
+
+func hashString(h uint64, s string) uint64 {
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= prime64
 	}
-	return result
+	return h
+}
+
+func hashUint64(h uint64, v uint64) uint64 {
+	for i := uint(0); i < 64; i += 8 {
+		h ^= (v >> i) & 0xff
+		h *= prime64
+	}
+	return h
+}
+
+// tagHashesPool holds the buffers hashing the metrics with many tags, the
+// usual ones fit in an array on the stack.
+var tagHashesPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]uint64, 0, 64)
+		return &buf
+	},
 }
 
+// context hashes the name, the tags, the hostname and the device name of the
+// metric, without allocating.
 func (m *Metric) context() Context {
-	tags := m.removeDuplicates(m.Tags)
-	sort.Strings(tags)
-	return Context{m.Name, strings.Join(tags, ","), m.Hostname, m.DeviceName}
+	if len(m.Tags) <= 16 {
+		var array [16]uint64
+		return m.hashContext(array[:0])
+	}
+
+	buf := tagHashesPool.Get().(*[]uint64)
+	ctx := m.hashContext((*buf)[:0])
+	tagHashesPool.Put(buf)
+	return ctx
+}
+
+// hashContext hashes the tags one by one into hashes and sorts them, so
+// that neither the order nor the duplicates of the tags change the context.
+func (m *Metric) hashContext(hashes []uint64) Context {
+	for _, tag := range m.Tags {
+		th := hashString(offset64, tag)
+		// Insertion sort, the lists of tags are short.
+		i := len(hashes)
+		hashes = append(hashes, th)
+		for i > 0 && hashes[i-1] > th {
+			hashes[i] = hashes[i-1]
+			i--
+		}
+		hashes[i] = th
+	}
+
+	h := hashString(offset64, m.Name)
+	for i, th := range hashes {
+		if i > 0 && th == hashes[i-1] {
+			continue
+		}
+		h = hashUint64(h, th)
+	}
+	// The separators keep e.g. the hostname from being read as the device.
+	h = hashString(hashUint64(h, 0), m.Hostname)
+	h = hashString(hashUint64(h, 1), m.DeviceName)
+	return Context(h)
 }
 
 // IsExpired XXX
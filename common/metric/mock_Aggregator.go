@@ -0,0 +1,143 @@
+package metric
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MockAggregator records all the metrics added to it, it's used to test plugins.
+type MockAggregator struct {
+	sync.Mutex
+
+	Metrics       []Metric
+	Events        []Event
+	ServiceChecks []ServiceCheck
+}
+
+// AddMetrics XXX
+func (m *MockAggregator) AddMetrics(
+	metricType string,
+	prefix string,
+	fields map[string]interface{},
+	tags []string,
+	deviceName string,
+	t ...int64,
+) {
+	for name, value := range fields {
+		m.Add(metricType, Metric{
+			Name:       strings.Join([]string{prefix, name}, "."),
+			Value:      value,
+			Tags:       tags,
+			DeviceName: deviceName,
+		})
+	}
+}
+
+// SubmitPackets XXX
+func (m *MockAggregator) SubmitPackets(packet string, extraTags ...string) {}
+
+// Add XXX
+func (m *MockAggregator) Add(metricType string, metric Metric) {
+	m.Lock()
+	defer m.Unlock()
+
+	metric.Type = metricType
+	m.Metrics = append(m.Metrics, metric)
+}
+
+// AddEvent XXX
+func (m *MockAggregator) AddEvent(e Event) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.Events = append(m.Events, e)
+}
+
+// AddServiceCheck XXX
+func (m *MockAggregator) AddServiceCheck(sc ServiceCheck) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.ServiceChecks = append(m.ServiceChecks, sc)
+}
+
+// Flush XXX
+func (m *MockAggregator) Flush() {}
+
+// Get returns the last recorded metric with the given name which carries all
+// the given tags.
+func (m *MockAggregator) Get(name string, tags ...string) (Metric, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	for i := len(m.Metrics) - 1; i >= 0; i-- {
+		metric := m.Metrics[i]
+		if metric.Name == name && hasTags(metric.Tags, tags) {
+			return metric, true
+		}
+	}
+	return Metric{}, false
+}
+
+// Value returns the value of the metric found by Get as float64.
+func (m *MockAggregator) Value(name string, tags ...string) (float64, bool) {
+	metric, ok := m.Get(name, tags...)
+	if !ok {
+		return 0, false
+	}
+
+	value, err := metric.getCorrectedValue()
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// GetServiceCheck returns the last recorded service check with the given name
+// which carries all the given tags.
+func (m *MockAggregator) GetServiceCheck(name string, tags ...string) (ServiceCheck, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	for i := len(m.ServiceChecks) - 1; i >= 0; i-- {
+		sc := m.ServiceChecks[i]
+		if sc.Name == name && hasTags(sc.Tags, tags) {
+			return sc, true
+		}
+	}
+	return ServiceCheck{}, false
+}
+
+// Names returns the sorted and deduplicated names of the recorded metrics.
+func (m *MockAggregator) Names() []string {
+	m.Lock()
+	defer m.Unlock()
+
+	found := make(map[string]bool)
+	var names []string
+	for _, metric := range m.Metrics {
+		if !found[metric.Name] {
+			found[metric.Name] = true
+			names = append(names, metric.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func hasTags(tags []string, expected []string) bool {
+	for _, e := range expected {
+		found := false
+		for _, tag := range tags {
+			if tag == e {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
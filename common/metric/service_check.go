@@ -0,0 +1,83 @@
+package metric
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Service check statuses.
+const (
+	ServiceCheckOK       = 0
+	ServiceCheckWarning  = 1
+	ServiceCheckCritical = 2
+	ServiceCheckUnknown  = 3
+)
+
+// ServiceCheck reports whether a service is up, it's posted separately from
+// the metrics.
+type ServiceCheck struct {
+	Name      string   `json:"check"`
+	Status    int      `json:"status"`
+	Timestamp int64    `json:"timestamp"`
+	Hostname  string   `json:"host_name,omitempty"`
+	Message   string   `json:"message,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// NewServiceCheck creates a new instance of ServiceCheck.
+func NewServiceCheck(name string, status int, tags ...[]string) ServiceCheck {
+	sc := ServiceCheck{
+		Name:   name,
+		Status: status,
+	}
+	if len(tags) > 0 {
+		sc.Tags = tags[0]
+	}
+	return sc
+}
+
+// Schema of a DogStatsD service check packet:
+// _sc|<name>|<status>|d:<timestamp>|h:<hostname>|#<tag1>,<tag2>|m:<message>
+// For example:
+// _sc|redis.can_connect|2|#env:dev|m:Redis connection timed out
+func parseServiceCheck(packet string) (ServiceCheck, error) {
+	var sc ServiceCheck
+
+	parts := strings.Split(packet, "|")
+	if len(parts) < 3 || parts[0] != "_sc" || parts[1] == "" {
+		return sc, fmt.Errorf("Error parsing service check packet %s", packet)
+	}
+	sc.Name = parts[1]
+
+	status, err := strconv.Atoi(parts[2])
+	if err != nil || status < ServiceCheckOK || status > ServiceCheckUnknown {
+		return sc, fmt.Errorf("Error parsing service check status from packet %s", packet)
+	}
+	sc.Status = status
+
+	for i := 3; i < len(parts); i++ {
+		segment := parts[i]
+		switch {
+		case strings.HasPrefix(segment, "d:"):
+			sc.Timestamp, err = strconv.ParseInt(segment[2:], 10, 64)
+			if err != nil {
+				return sc, fmt.Errorf("Error parsing service check timestamp from packet %s", packet)
+			}
+		case strings.HasPrefix(segment, "h:"):
+			sc.Hostname = segment[2:]
+		case strings.HasPrefix(segment, "#"):
+			for _, tag := range strings.Split(segment[1:], ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					sc.Tags = append(sc.Tags, tag)
+				}
+			}
+		case strings.HasPrefix(segment, "m:"):
+			// The message is the last field, it may contain pipes.
+			sc.Message = unescapeEventText(strings.Join(append([]string{segment[2:]}, parts[i+1:]...), "|"))
+			return sc, nil
+		}
+	}
+
+	return sc, nil
+}
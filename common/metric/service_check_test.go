@@ -0,0 +1,41 @@
+package metric
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseServiceCheck(t *testing.T) {
+	sc, err := parseServiceCheck("_sc|redis.can_connect|2")
+	require.NoError(t, err)
+	assert.Equal(t, NewServiceCheck("redis.can_connect", ServiceCheckCritical), sc)
+
+	sc, err = parseServiceCheck("_sc|redis.can_connect|1|d:1474867457|h:myhost|#env:dev,role:cache|m:timed out|after 5s\\nretrying")
+	require.NoError(t, err)
+	assert.Equal(t, ServiceCheck{
+		Name:      "redis.can_connect",
+		Status:    ServiceCheckWarning,
+		Timestamp: 1474867457,
+		Hostname:  "myhost",
+		Message:   "timed out|after 5s\nretrying",
+		Tags:      []string{"env:dev", "role:cache"},
+	}, sc)
+}
+
+func TestInvalidServiceChecks(t *testing.T) {
+	invalidServiceChecks := []string{
+		"_sc",
+		"_sc|name",
+		"_sc||0",
+		"_sc|name|ok",
+		"_sc|name|4",
+		"_sc|name|0|d:abc",
+	}
+
+	for _, packet := range invalidServiceChecks {
+		_, err := parseServiceCheck(packet)
+		assert.Error(t, err, packet)
+	}
+}
@@ -0,0 +1,254 @@
+package metric
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+)
+
+const (
+	// DefaultSketchRelativeAccuracy is the relative error of the quantiles
+	// computed from a Sketch.
+	DefaultSketchRelativeAccuracy = 0.01
+
+	// DefaultSketchMaxBins bounds the memory used by a Sketch, the lowest
+	// bins are collapsed when it's reached.
+	DefaultSketchMaxBins = 2048
+
+	// sketchMinValue is the smallest magnitude tracked in the bins, smaller
+	// values are counted as zeros.
+	sketchMinValue = 1e-9
+)
+
+// Sketch is a DDSketch: a quantile sketch with relative error guarantees,
+// which can be merged with the sketches of other hosts, so that the
+// percentiles of a distribution can be computed server-side.
+// See https://arxiv.org/abs/1908.10693
+type Sketch struct {
+	alpha    float64
+	gamma    float64
+	logGamma float64
+	maxBins  int
+	positive map[int]float64
+	negative map[int]float64
+	zeros    float64
+	count    float64
+	sum      float64
+	min      float64
+	max      float64
+}
+
+// NewSketch creates a Sketch with the given relative accuracy.
+func NewSketch(alpha float64, maxBins int) *Sketch {
+	gamma := (1 + alpha) / (1 - alpha)
+	return &Sketch{
+		alpha:    alpha,
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		maxBins:  maxBins,
+		positive: make(map[int]float64),
+		negative: make(map[int]float64),
+		min:      math.Inf(1),
+		max:      math.Inf(-1),
+	}
+}
+
+// Add adds a value with the given weight, which is 1/samplerate for the
+// sampled values.
+func (s *Sketch) Add(value float64, weight float64) {
+	switch {
+	case value > sketchMinValue:
+		s.positive[s.index(value)] += weight
+		s.collapse(s.positive)
+	case value < -sketchMinValue:
+		s.negative[s.index(-value)] += weight
+		s.collapse(s.negative)
+	default:
+		s.zeros += weight
+	}
+
+	s.count += weight
+	s.sum += value * weight
+	s.min = math.Min(s.min, value)
+	s.max = math.Max(s.max, value)
+}
+
+// Merge adds the values of other to the sketch, both must have been created
+// with the same accuracy.
+func (s *Sketch) Merge(other *Sketch) {
+	for k, n := range other.positive {
+		s.positive[k] += n
+	}
+	s.collapse(s.positive)
+	for k, n := range other.negative {
+		s.negative[k] += n
+	}
+	s.collapse(s.negative)
+
+	s.zeros += other.zeros
+	s.count += other.count
+	s.sum += other.sum
+	s.min = math.Min(s.min, other.min)
+	s.max = math.Max(s.max, other.max)
+}
+
+// Count returns the total weight of the values added.
+func (s *Sketch) Count() float64 {
+	return s.count
+}
+
+// Quantile returns the approximate q-quantile of the values, q is between 0
+// and 1.
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+	if q == 0 {
+		return s.min
+	}
+	if q == 1 {
+		return s.max
+	}
+
+	rank := q * (s.count - 1)
+
+	// The negative values come first, from the largest magnitude.
+	var seen float64
+	negKeys := sortedKeys(s.negative)
+	for i := len(negKeys) - 1; i >= 0; i-- {
+		seen += s.negative[negKeys[i]]
+		if seen > rank {
+			return s.clamp(-s.value(negKeys[i]))
+		}
+	}
+
+	seen += s.zeros
+	if seen > rank {
+		return 0
+	}
+
+	for _, k := range sortedKeys(s.positive) {
+		seen += s.positive[k]
+		if seen > rank {
+			return s.clamp(s.value(k))
+		}
+	}
+	return s.max
+}
+
+// index returns the bin of a positive value: gamma^(i-1) < value <= gamma^i.
+func (s *Sketch) index(value float64) int {
+	return int(math.Ceil(math.Log(value) / s.logGamma))
+}
+
+// value returns the value representing a bin, within alpha of all the values
+// of the bin.
+func (s *Sketch) value(index int) float64 {
+	return 2 * math.Pow(s.gamma, float64(index)) / (1 + s.gamma)
+}
+
+func (s *Sketch) clamp(value float64) float64 {
+	return math.Max(s.min, math.Min(s.max, value))
+}
+
+// collapse merges the lowest bins when there are too many of them, which
+// only degrades the accuracy of the lowest quantiles.
+func (s *Sketch) collapse(bins map[int]float64) {
+	if s.maxBins <= 0 || len(bins) <= s.maxBins {
+		return
+	}
+
+	keys := sortedKeys(bins)
+	excess := len(keys) - s.maxBins
+	target := keys[excess]
+	for _, k := range keys[:excess] {
+		bins[target] += bins[k]
+		delete(bins, k)
+	}
+}
+
+func sortedKeys(bins map[int]float64) []int {
+	keys := make([]int, 0, len(bins))
+	for k := range bins {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+type sketchBins struct {
+	Keys   []int     `json:"k"`
+	Counts []float64 `json:"n"`
+}
+
+func newSketchBins(bins map[int]float64) sketchBins {
+	b := sketchBins{
+		Keys: sortedKeys(bins),
+	}
+	b.Counts = make([]float64, len(b.Keys))
+	for i, k := range b.Keys {
+		b.Counts[i] = bins[k]
+	}
+	return b
+}
+
+func (b sketchBins) toMap() map[int]float64 {
+	bins := make(map[int]float64, len(b.Keys))
+	for i, k := range b.Keys {
+		if i < len(b.Counts) {
+			bins[k] = b.Counts[i]
+		}
+	}
+	return bins
+}
+
+type sketchJSON struct {
+	Alpha    float64    `json:"alpha"`
+	Count    float64    `json:"cnt"`
+	Sum      float64    `json:"sum"`
+	Min      float64    `json:"min"`
+	Max      float64    `json:"max"`
+	Zeros    float64    `json:"zeros"`
+	Positive sketchBins `json:"pos"`
+	Negative sketchBins `json:"neg"`
+}
+
+// MarshalJSON serializes the sketch for the payload, the bins are sent as
+// sorted keys and counts.
+func (s *Sketch) MarshalJSON() ([]byte, error) {
+	encoded := sketchJSON{
+		Alpha:    s.alpha,
+		Count:    s.count,
+		Sum:      s.sum,
+		Zeros:    s.zeros,
+		Positive: newSketchBins(s.positive),
+		Negative: newSketchBins(s.negative),
+	}
+	// min and max are infinite until a value is added, which JSON can't
+	// represent.
+	if s.count > 0 {
+		encoded.Min = s.min
+		encoded.Max = s.max
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON XXX
+func (s *Sketch) UnmarshalJSON(data []byte) error {
+	var decoded sketchJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*s = *NewSketch(decoded.Alpha, DefaultSketchMaxBins)
+	s.count = decoded.Count
+	s.sum = decoded.Sum
+	if s.count > 0 {
+		s.min = decoded.Min
+		s.max = decoded.Max
+	}
+	s.zeros = decoded.Zeros
+	s.positive = decoded.Positive.toMap()
+	s.negative = decoded.Negative.toMap()
+	return nil
+}
@@ -0,0 +1,105 @@
+package metric
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exactQuantile(values []float64, q float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+func assertRelativeError(t *testing.T, expected, actual, alpha float64) {
+	assert.True(t, math.Abs(actual-expected) <= alpha*math.Abs(expected)+1e-9,
+		"expected %v, got %v", expected, actual)
+}
+
+func TestSketchQuantiles(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	s := NewSketch(DefaultSketchRelativeAccuracy, DefaultSketchMaxBins)
+	var values []float64
+	for i := 0; i < 10000; i++ {
+		v := math.Exp(r.NormFloat64())
+		if i%10 == 0 {
+			v = -v
+		}
+		values = append(values, v)
+		s.Add(v, 1)
+	}
+
+	assert.Equal(t, float64(10000), s.Count())
+	for _, q := range []float64{0.01, 0.05, 0.25, 0.5, 0.75, 0.95, 0.99, 0.999} {
+		assertRelativeError(t, exactQuantile(values, q), s.Quantile(q), DefaultSketchRelativeAccuracy)
+	}
+	assert.Equal(t, exactQuantile(values, 0), s.Quantile(0))
+	assert.Equal(t, exactQuantile(values, 1), s.Quantile(1))
+	assert.True(t, math.IsNaN(NewSketch(0.01, 0).Quantile(0.5)))
+}
+
+func TestSketchZerosAndWeights(t *testing.T) {
+	s := NewSketch(DefaultSketchRelativeAccuracy, DefaultSketchMaxBins)
+	s.Add(0, 1)
+	s.Add(10, 3)
+
+	assert.Equal(t, float64(4), s.Count())
+	assert.Equal(t, float64(0), s.Quantile(0.1))
+	assertRelativeError(t, 10, s.Quantile(0.5), DefaultSketchRelativeAccuracy)
+}
+
+func TestSketchMerge(t *testing.T) {
+	all := NewSketch(DefaultSketchRelativeAccuracy, DefaultSketchMaxBins)
+	a := NewSketch(DefaultSketchRelativeAccuracy, DefaultSketchMaxBins)
+	b := NewSketch(DefaultSketchRelativeAccuracy, DefaultSketchMaxBins)
+	for i := 1; i <= 1000; i++ {
+		all.Add(float64(i), 1)
+		if i%2 == 0 {
+			a.Add(float64(i), 1)
+		} else {
+			b.Add(float64(i), 1)
+		}
+	}
+
+	a.Merge(b)
+	assert.Equal(t, all.Count(), a.Count())
+	for _, q := range []float64{0.1, 0.5, 0.9, 0.99} {
+		assert.Equal(t, all.Quantile(q), a.Quantile(q))
+	}
+}
+
+func TestSketchCollapse(t *testing.T) {
+	s := NewSketch(DefaultSketchRelativeAccuracy, 10)
+	for i := 0; i < 100; i++ {
+		s.Add(math.Pow(2, float64(i)), 1)
+	}
+
+	assert.Len(t, s.positive, 10)
+	assert.Equal(t, float64(100), s.Count())
+	// The highest quantiles are still accurate.
+	assertRelativeError(t, math.Pow(2, 99), s.Quantile(1), DefaultSketchRelativeAccuracy)
+	assertRelativeError(t, math.Pow(2, 98), s.Quantile(0.99), DefaultSketchRelativeAccuracy)
+}
+
+func TestSketchJSON(t *testing.T) {
+	s := NewSketch(DefaultSketchRelativeAccuracy, DefaultSketchMaxBins)
+	for _, v := range []float64{-5, 0, 1, 2, 2, 1000} {
+		s.Add(v, 1)
+	}
+
+	data, err := json.Marshal(s)
+	require.NoError(t, err)
+
+	decoded := &Sketch{}
+	require.NoError(t, json.Unmarshal(data, decoded))
+	assert.Equal(t, s, decoded)
+
+	_, err = json.Marshal(NewSketch(DefaultSketchRelativeAccuracy, DefaultSketchMaxBins))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,68 @@
+package metric
+
+// WithTags returns an Aggregator appending tags to every metric, event and
+// service check added to agg. The tags already carried by them aren't
+// repeated.
+func WithTags(agg Aggregator, tags ...string) Aggregator {
+	if len(tags) == 0 {
+		return agg
+	}
+	return &taggedAggregator{Aggregator: agg, tags: tags}
+}
+
+type taggedAggregator struct {
+	Aggregator
+
+	tags []string
+}
+
+func (t *taggedAggregator) AddMetrics(
+	metricType string,
+	prefix string,
+	fields map[string]interface{},
+	tags []string,
+	deviceName string,
+	ts ...int64,
+) {
+	t.Aggregator.AddMetrics(metricType, prefix, fields, AppendTags(tags, t.tags...), deviceName, ts...)
+}
+
+func (t *taggedAggregator) SubmitPackets(packet string, extraTags ...string) {
+	t.Aggregator.SubmitPackets(packet, AppendTags(extraTags, t.tags...)...)
+}
+
+func (t *taggedAggregator) Add(metricType string, m Metric) {
+	m.Tags = AppendTags(m.Tags, t.tags...)
+	t.Aggregator.Add(metricType, m)
+}
+
+func (t *taggedAggregator) AddEvent(e Event) {
+	e.Tags = AppendTags(e.Tags, t.tags...)
+	t.Aggregator.AddEvent(e)
+}
+
+func (t *taggedAggregator) AddServiceCheck(sc ServiceCheck) {
+	sc.Tags = AppendTags(sc.Tags, t.tags...)
+	t.Aggregator.AddServiceCheck(sc)
+}
+
+// AppendTags returns a new slice holding tags followed by the extra tags
+// which aren't in tags yet, tags itself is never modified.
+func AppendTags(tags []string, extra ...string) []string {
+	result := make([]string, 0, len(tags)+len(extra))
+	result = append(result, tags...)
+
+	for _, tag := range extra {
+		found := false
+		for _, existing := range result {
+			if existing == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
@@ -0,0 +1,46 @@
+package metric
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendTags(t *testing.T) {
+	tags := []string{"env:prod", "role:db"}
+	result := AppendTags(tags, "role:db", "team:payments")
+	assert.Equal(t, []string{"env:prod", "role:db", "team:payments"}, result)
+	assert.Len(t, tags, 2)
+	assert.Equal(t, []string{"env:prod"}, AppendTags(nil, "env:prod"))
+}
+
+func TestWithTags(t *testing.T) {
+	a := &aggregator{
+		metrics:       make(chan Metric, 10),
+		events:        make(chan Event, 10),
+		serviceChecks: make(chan ServiceCheck, 10),
+		context:       make(map[Context]Generator),
+		interval:      1,
+		hostname:      "myhost",
+	}
+	agg := WithTags(a, "env:prod")
+	assert.Equal(t, Aggregator(a), WithTags(a))
+
+	agg.Add("gauge", NewMetric("my.gauge", 1, []string{"env:prod", "role:db"}))
+	agg.AddMetrics("gauge", "my", map[string]interface{}{"metric": 1}, nil, "")
+	agg.SubmitPackets("my.counter:1|c")
+	agg.AddEvent(NewEvent("title", "text"))
+	agg.AddServiceCheck(NewServiceCheck("my.check", ServiceCheckOK))
+	agg.Flush()
+
+	assert.Len(t, a.metrics, 3)
+	for len(a.metrics) > 0 {
+		m := <-a.metrics
+		assert.Contains(t, m.Tags, "env:prod")
+		if m.Name == "my.gauge" {
+			assert.Equal(t, []string{"env:prod", "role:db"}, m.Tags)
+		}
+	}
+	assert.Equal(t, []string{"env:prod"}, (<-a.events).Tags)
+	assert.Equal(t, []string{"env:prod"}, (<-a.serviceChecks).Tags)
+}
@@ -0,0 +1,94 @@
+package metric
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// transformTypes are the metric types a TransformRule can convert to.
+var transformTypes = map[string]bool{
+	"gauge":           true,
+	"rate":            true,
+	"count":           true,
+	"monotonic_count": true,
+	"histogram":       true,
+	"distribution":    true,
+}
+
+// TransformRule rewrites the metrics whose name matches Match, a glob where
+// "*" matches any characters or a regex enclosed in slashes, like the
+// FilterConfig patterns.
+type TransformRule struct {
+	Match string `toml:"match"`
+	// Rename is the new name, it can refer to the wildcards or the groups of
+	// Match as $1, $2, etc., or ${1} when followed by a letter, digit or "_".
+	Rename string `toml:"rename"`
+	// Scale multiplies the values, e.g. 0.000001 turns bytes into MB.
+	Scale float64 `toml:"scale"`
+	// Type submits the metric with another type, e.g. monotonic_count turns
+	// the counters collected as rates into the count of each flush.
+	Type string `toml:"type"`
+}
+
+type transformRule struct {
+	TransformRule
+	re *regexp.Regexp
+}
+
+// Transformer applies the first TransformRule matching a metric.
+type Transformer struct {
+	rules []transformRule
+}
+
+// NewTransformer compiles the rules, it returns nil when there is none.
+func NewTransformer(rules []TransformRule) (*Transformer, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	t := &Transformer{}
+	for _, rule := range rules {
+		if rule.Match == "" {
+			return nil, fmt.Errorf("transform requires a match")
+		}
+		if rule.Type != "" && !transformTypes[rule.Type] {
+			return nil, fmt.Errorf("transform %s: unsupported type %s", rule.Match, rule.Type)
+		}
+		re, err := compilePattern(rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transform pattern %s: %s", rule.Match, err)
+		}
+		t.rules = append(t.rules, transformRule{TransformRule: rule, re: re})
+	}
+	return t, nil
+}
+
+// Apply rewrites m according to the first matching rule and returns the type
+// m should be submitted as. A nil Transformer leaves m untouched.
+func (t *Transformer) Apply(metricType string, m *Metric) string {
+	if t == nil {
+		return metricType
+	}
+
+	for _, rule := range t.rules {
+		match := rule.re.FindStringSubmatchIndex(m.Name)
+		if match == nil {
+			continue
+		}
+
+		if rule.Rename != "" {
+			m.Name = string(rule.re.ExpandString(nil, rule.Rename, m.Name, match))
+		}
+		// The values of the sets are hashes.
+		if rule.Scale != 0 && metricType != "set" {
+			if value, err := m.getCorrectedValue(); err == nil {
+				m.Value = value * rule.Scale
+			}
+		}
+		if rule.Type != "" {
+			metricType = rule.Type
+		}
+		break
+	}
+	return metricType
+}
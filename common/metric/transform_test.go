@@ -0,0 +1,67 @@
+package metric
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransformer(t *testing.T) {
+	tr, err := NewTransformer([]TransformRule{
+		{Match: "system.mem.*", Rename: "mem.${1}_mb", Scale: 0.000001},
+		{Match: `/^nginx\.(\w+)\.requests$/`, Rename: "nginx.$1.hits", Type: "monotonic_count"},
+		{Match: "nginx.*", Scale: 2},
+	})
+	assert.NoError(t, err)
+
+	m := NewMetric("system.mem.used", 2000000)
+	assert.Equal(t, "gauge", tr.Apply("gauge", &m))
+	assert.Equal(t, "mem.used_mb", m.Name)
+	assert.Equal(t, float64(2), m.Value)
+
+	// Only the first matching rule applies
+	m = NewMetric("nginx.net.requests", 10)
+	assert.Equal(t, "monotonic_count", tr.Apply("rate", &m))
+	assert.Equal(t, "nginx.net.hits", m.Name)
+	assert.Equal(t, 10, m.Value)
+
+	m = NewMetric("redis.net.clients", 10)
+	assert.Equal(t, "gauge", tr.Apply("gauge", &m))
+	assert.Equal(t, "redis.net.clients", m.Name)
+
+	var nilTransformer *Transformer
+	assert.Equal(t, "gauge", nilTransformer.Apply("gauge", &m))
+}
+
+func TestInvalidTransformer(t *testing.T) {
+	_, err := NewTransformer([]TransformRule{{Rename: "foo"}})
+	assert.Error(t, err)
+	_, err = NewTransformer([]TransformRule{{Match: "foo", Type: "set"}})
+	assert.Error(t, err)
+	_, err = NewTransformer([]TransformRule{{Match: "/(/"}})
+	assert.Error(t, err)
+
+	tr, err := NewTransformer(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, tr)
+}
+
+func TestAggregatorTransformer(t *testing.T) {
+	tr, _ := NewTransformer([]TransformRule{{Match: "my.counter", Type: "monotonic_count"}})
+	a := aggregator{
+		metrics:     make(chan Metric, 10),
+		context:     make(map[Context]Generator),
+		interval:    1,
+		hostname:    "myhost",
+		transformer: tr,
+	}
+	defer close(a.metrics)
+
+	a.Add("rate", NewMetric("my.counter", 10))
+	a.Add("rate", NewMetric("my.counter", 15))
+	a.Flush()
+	assert.Len(t, a.metrics, 1)
+	m := <-a.metrics
+	assert.Equal(t, "count", m.Type)
+	assert.Equal(t, float64(5), getValue(m))
+}
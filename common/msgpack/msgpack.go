@@ -0,0 +1,392 @@
+// Package msgpack serializes the payloads in MessagePack, a binary
+// equivalent of JSON which is smaller and cheaper to encode. The values are
+// encoded like encoding/json would: the structs are maps named after their
+// json tags, and the types implementing json.Marshaler are encoded from
+// their JSON.
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the MessagePack payloads.
+const ContentType = "application/msgpack"
+
+var errTruncated = errors.New("msgpack: unexpected end of data")
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	numberType        = reflect.TypeOf(json.Number(""))
+)
+
+// Marshal returns the MessagePack encoding of v.
+func Marshal(v interface{}) ([]byte, error) {
+	var e encoder
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+type encoder struct {
+	buf     bytes.Buffer
+	scratch [9]byte
+}
+
+func (e *encoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf.WriteByte(0xc0)
+		return nil
+	}
+	if v.Type() == numberType {
+		return e.encodeNumber(json.Number(v.String()))
+	}
+	if v.Type().Implements(jsonMarshalerType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			e.buf.WriteByte(0xc0)
+			return nil
+		}
+		return e.encodeJSON(v.Interface().(json.Marshaler))
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() && v.Addr().Type().Implements(jsonMarshalerType) {
+		return e.encodeJSON(v.Addr().Interface().(json.Marshaler))
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.buf.WriteByte(0xc0)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf.WriteByte(0xc3)
+		} else {
+			e.buf.WriteByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.writeUint(v.Uint())
+	case reflect.Float32:
+		e.buf.WriteByte(0xca)
+		binary.BigEndian.PutUint32(e.scratch[:4], math.Float32bits(float32(v.Float())))
+		e.buf.Write(e.scratch[:4])
+	case reflect.Float64:
+		e.writeFloat(v.Float())
+	case reflect.String:
+		e.writeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.buf.WriteByte(0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.writeBinary(v.Bytes())
+			return nil
+		}
+		fallthrough
+	case reflect.Array:
+		e.writeHeader(v.Len(), 0x90, 0xdc, 0xdd)
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// encodeJSON encodes the values with a custom JSON encoding, decoded back
+// to the generic types first.
+func (e *encoder) encodeJSON(m json.Marshaler) error {
+	b, err := m.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err = d.Decode(&v); err != nil {
+		return err
+	}
+	return e.encode(reflect.ValueOf(v))
+}
+
+// encodeNumber encodes the numbers decoded by encodeJSON as integers when
+// they have no fraction.
+func (e *encoder) encodeNumber(n json.Number) error {
+	if i, err := n.Int64(); err == nil {
+		e.writeInt(i)
+		return nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return fmt.Errorf("msgpack: invalid number %q", string(n))
+	}
+	e.writeFloat(f)
+	return nil
+}
+
+func (e *encoder) encodeMap(v reflect.Value) error {
+	if v.IsNil() {
+		e.buf.WriteByte(0xc0)
+		return nil
+	}
+	keys := v.MapKeys()
+	// Sorted like encoding/json, so that a payload is always encoded the
+	// same way.
+	if v.Type().Key().Kind() == reflect.String {
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+	}
+	e.writeHeader(len(keys), 0x80, 0xde, 0xdf)
+	for _, key := range keys {
+		if err := e.encode(key); err != nil {
+			return err
+		}
+		if err := e.encode(v.MapIndex(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) encodeStruct(v reflect.Value) error {
+	fields := cachedFields(v.Type())
+
+	values := make([]reflect.Value, 0, len(fields))
+	present := fields[:0:0]
+	for _, f := range fields {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || (f.omitEmpty && isEmpty(fv)) {
+			continue
+		}
+		present = append(present, f)
+		values = append(values, fv)
+	}
+
+	e.writeHeader(len(present), 0x80, 0xde, 0xdf)
+	for i, f := range present {
+		e.writeString(f.name)
+		if err := e.encode(values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldByIndex is false when the field is in a nil embedded struct pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, n := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(n)
+	}
+	return v, true
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map
+
+// cachedFields returns the encoded fields of the struct type t, the fields
+// of the embedded structs without a json name are promoted like
+// encoding/json does.
+func cachedFields(t reflect.Type) []field {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]field)
+	}
+	fields := typeFields(t, nil)
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+func typeFields(t reflect.Type, index []int) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if n := strings.Index(tag, ","); n >= 0 {
+			name, opts = tag[:n], tag[n+1:]
+		}
+
+		fieldIndex := append(append([]int(nil), index...), i)
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			fields = append(fields, typeFields(ft, fieldIndex)...)
+			continue
+		}
+		if sf.PkgPath != "" {
+			// Unexported.
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{
+			name:      name,
+			index:     fieldIndex,
+			omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+		})
+	}
+	return fields
+}
+
+func (e *encoder) writeHeader(n int, fix byte, code16, code32 byte) {
+	switch {
+	case n < 16:
+		e.buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		e.buf.WriteByte(code16)
+		binary.BigEndian.PutUint16(e.scratch[:2], uint16(n))
+		e.buf.Write(e.scratch[:2])
+	default:
+		e.buf.WriteByte(code32)
+		binary.BigEndian.PutUint32(e.scratch[:4], uint32(n))
+		e.buf.Write(e.scratch[:4])
+	}
+}
+
+func (e *encoder) writeString(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		e.buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		e.buf.WriteByte(0xd9)
+		e.buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		e.buf.WriteByte(0xda)
+		binary.BigEndian.PutUint16(e.scratch[:2], uint16(n))
+		e.buf.Write(e.scratch[:2])
+	default:
+		e.buf.WriteByte(0xdb)
+		binary.BigEndian.PutUint32(e.scratch[:4], uint32(n))
+		e.buf.Write(e.scratch[:4])
+	}
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) writeBinary(b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		e.buf.WriteByte(0xc4)
+		e.buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		e.buf.WriteByte(0xc5)
+		binary.BigEndian.PutUint16(e.scratch[:2], uint16(n))
+		e.buf.Write(e.scratch[:2])
+	default:
+		e.buf.WriteByte(0xc6)
+		binary.BigEndian.PutUint32(e.scratch[:4], uint32(n))
+		e.buf.Write(e.scratch[:4])
+	}
+	e.buf.Write(b)
+}
+
+func (e *encoder) writeFloat(f float64) {
+	e.buf.WriteByte(0xcb)
+	binary.BigEndian.PutUint64(e.scratch[:8], math.Float64bits(f))
+	e.buf.Write(e.scratch[:8])
+}
+
+// writeInt uses the smallest encoding of i.
+func (e *encoder) writeInt(i int64) {
+	if i >= 0 {
+		e.writeUint(uint64(i))
+		return
+	}
+	switch {
+	case i >= -32:
+		e.buf.WriteByte(byte(i))
+	case i >= math.MinInt8:
+		e.buf.WriteByte(0xd0)
+		e.buf.WriteByte(byte(i))
+	case i >= math.MinInt16:
+		e.buf.WriteByte(0xd1)
+		binary.BigEndian.PutUint16(e.scratch[:2], uint16(i))
+		e.buf.Write(e.scratch[:2])
+	case i >= math.MinInt32:
+		e.buf.WriteByte(0xd2)
+		binary.BigEndian.PutUint32(e.scratch[:4], uint32(i))
+		e.buf.Write(e.scratch[:4])
+	default:
+		e.buf.WriteByte(0xd3)
+		binary.BigEndian.PutUint64(e.scratch[:8], uint64(i))
+		e.buf.Write(e.scratch[:8])
+	}
+}
+
+func (e *encoder) writeUint(u uint64) {
+	switch {
+	case u <= 0x7f:
+		e.buf.WriteByte(byte(u))
+	case u <= math.MaxUint8:
+		e.buf.WriteByte(0xcc)
+		e.buf.WriteByte(byte(u))
+	case u <= math.MaxUint16:
+		e.buf.WriteByte(0xcd)
+		binary.BigEndian.PutUint16(e.scratch[:2], uint16(u))
+		e.buf.Write(e.scratch[:2])
+	case u <= math.MaxUint32:
+		e.buf.WriteByte(0xce)
+		binary.BigEndian.PutUint32(e.scratch[:4], uint32(u))
+		e.buf.Write(e.scratch[:4])
+	default:
+		e.buf.WriteByte(0xcf)
+		binary.BigEndian.PutUint64(e.scratch[:8], u)
+		e.buf.Write(e.scratch[:8])
+	}
+}
@@ -0,0 +1,119 @@
+package msgpack
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type jsonValue struct{}
+
+func (jsonValue) MarshalJSON() ([]byte, error) {
+	return []byte(`{"bins":[1,2.5]}`), nil
+}
+
+type embedded struct {
+	Host string `json:"host"`
+}
+
+type payload struct {
+	embedded
+	Name    string            `json:"name"`
+	Skipped string            `json:"-"`
+	Empty   []interface{}     `json:"empty,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	Value   float64
+	Custom  jsonValue `json:"custom"`
+	private int
+}
+
+func TestMarshalEncodings(t *testing.T) {
+	for _, c := range []struct {
+		in   interface{}
+		want []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{5, []byte{0x05}},
+		{-3, []byte{0xfd}},
+		{200, []byte{0xcc, 0xc8}},
+		{-200, []byte{0xd1, 0xff, 0x38}},
+		{int64(1) << 40, []byte{0xcf, 0, 0, 1, 0, 0, 0, 0, 0}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"ab", []byte{0xa2, 'a', 'b'}},
+		{[]byte{1}, []byte{0xc4, 1, 1}},
+		{[]int{1, 2}, []byte{0x92, 1, 2}},
+		{map[string]int{"b": 2, "a": 1}, []byte{0x82, 0xa1, 'a', 1, 0xa1, 'b', 2}},
+	} {
+		out, err := Marshal(c.in)
+		assert.NoError(t, err)
+		assert.Equal(t, c.want, out, "%#v", c.in)
+	}
+
+	out, err := Marshal(strings.Repeat("x", 40))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xd9, 40}, out[:2])
+}
+
+func TestMarshalStruct(t *testing.T) {
+	p := &payload{
+		embedded: embedded{Host: "web-1"},
+		Name:     "system.load.1",
+		Skipped:  "x",
+		Value:    0.5,
+		private:  1,
+	}
+	out, err := Marshal(p)
+	assert.NoError(t, err)
+
+	v, err := Decode(out)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"host":   "web-1",
+		"name":   "system.load.1",
+		"Value":  0.5,
+		"custom": map[string]interface{}{"bins": []interface{}{int64(1), 2.5}},
+	}, v)
+}
+
+// The payloads decoded from MessagePack are encoded to the same JSON as the
+// original ones.
+func TestDecodeLikeJSON(t *testing.T) {
+	in := map[string]interface{}{
+		"metrics": []interface{}{
+			[]interface{}{"system.load.1", int64(1500000000), 0.25, map[string]interface{}{"tags": []string{"env:prod"}}},
+		},
+		"os":    "linux",
+		"uuid":  nil,
+		"big":   uint64(math.MaxUint64),
+		"neg":   int64(-70000),
+		"float": float32(1.5),
+		"ok":    false,
+	}
+	out, err := Marshal(in)
+	assert.NoError(t, err)
+
+	v, err := Decode(out)
+	assert.NoError(t, err)
+
+	want, _ := json.Marshal(in)
+	got, err := json.Marshal(v)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(want), string(got))
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, in := range [][]byte{
+		{},
+		{0x92, 1},
+		{0xdb, 0xff, 0xff, 0xff, 0xff},
+		{0xc1},
+		{1, 2},
+	} {
+		_, err := Decode(in)
+		assert.Error(t, err, "%x", in)
+	}
+}
@@ -0,0 +1,192 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/proxy"
+)
+
+// DefaultHTTPTimeout is the default timeout of the requests sent by plugins.
+const DefaultHTTPTimeout = 10
+
+// HTTPConfig holds the options shared by the plugins talking to HTTP endpoints,
+// it's meant to be inlined into the instance config of those plugins, or into
+// the toml section of the outputs.
+type HTTPConfig struct {
+	Username             string            `yaml:"username" toml:"username"`
+	Password             string            `yaml:"password" toml:"password"`
+	Headers              map[string]string `yaml:"headers" toml:"headers"`
+	Timeout              int               `yaml:"timeout" toml:"timeout"`
+	DisableSSLValidation bool              `yaml:"disable_ssl_validation" toml:"disable_ssl_validation"`
+	CACerts              string            `yaml:"ca_certs" toml:"ca_certs"`
+	ClientCert           string            `yaml:"client_cert" toml:"client_cert"`
+	ClientKey            string            `yaml:"client_key" toml:"client_key"`
+	// SkipProxy sends the requests directly, ignoring the proxy of the agent.
+	SkipProxy bool `yaml:"skip_proxy" toml:"skip_proxy"`
+}
+
+// NewClient creates a http.Client according to the timeout and TLS options.
+func (c *HTTPConfig) NewClient() (*http.Client, error) {
+	tlsConfig, err := c.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHTTPTimeout
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+		// Plugins create a client for every check, so don't keep idle
+		// connections around.
+		DisableKeepAlives: true,
+	}
+	if !c.SkipProxy {
+		transport.Proxy = proxy.FromConfig
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(timeout) * time.Second,
+	}, nil
+}
+
+// TLSConfig loads the CA certificates and the client certificate.
+func (c *HTTPConfig) TLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.DisableSSLValidation,
+	}
+
+	if c.CACerts != "" {
+		pem, err := ioutil.ReadFile(c.CACerts)
+		if err != nil {
+			return nil, fmt.Errorf("could not read ca_certs %s: %s", c.CACerts, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificate found in %s", c.CACerts)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.ClientCert != "" {
+		key := c.ClientKey
+		if key == "" {
+			// The key may be bundled with the certificate.
+			key = c.ClientCert
+		}
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, key)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// NewRequest creates a request carrying the configured credentials and headers.
+func (c *HTTPConfig) NewRequest(method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	for k, v := range c.Headers {
+		if k == "Host" {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+
+	return req, nil
+}
+
+// Do sends req, a non 2xx status is returned as an error. The caller must
+// close the response body.
+func (c *HTTPConfig) Do(req *http.Request) (*http.Response, error) {
+	client, err := c.NewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%s returned HTTP status %d", req.URL, resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+// Get sends a GET request to url, the caller must close the response body.
+func (c *HTTPConfig) Get(url string) (*http.Response, error) {
+	req, err := c.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Do(req)
+}
+
+// GetJSON fetches url and decodes the JSON response into v.
+func (c *HTTPConfig) GetJSON(url string, v interface{}) error {
+	resp, err := c.Get(url)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("could not decode the response of %s: %s", url, err)
+	}
+	return nil
+}
+
+// PostJSON posts body encoded as JSON to url and decodes the JSON response
+// into v.
+func (c *HTTPConfig) PostJSON(url string, body interface{}, v interface{}) error {
+	content, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := c.NewRequest("POST", url, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("could not decode the response of %s: %s", url, err)
+	}
+	return nil
+}
@@ -1,9 +1,17 @@
 package plugin
 
 import (
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
 
+	"github.com/cloudinsight/cloudinsight-agent/common/kv"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
 	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/secrets"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -28,10 +36,58 @@ type InitConfig map[string]interface{}
 // Instance XXX
 type Instance map[string]interface{}
 
+// Unmarshal decodes the instance into v, which should be a pointer to a
+// struct using yaml tags to name its options.
+func (i Instance) Unmarshal(v interface{}) error {
+	content, err := yaml.Marshal(i)
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal(content, v)
+}
+
+// Tags returns the tags option of the instance.
+func (i Instance) Tags() []string {
+	list, _ := i["tags"].([]interface{})
+	var tags []string
+	for _, tag := range list {
+		if s, ok := tag.(string); ok {
+			tags = append(tags, s)
+		}
+	}
+	return tags
+}
+
+// LogOptions returns the log_level and log_file options of the instance,
+// which override the level and the file of the logging of the agent for the
+// checks of the instance.
+func (i Instance) LogOptions() (level, file string) {
+	level, _ = i["log_level"].(string)
+	file, _ = i["log_file"].(string)
+	return level, file
+}
+
+// expandTags expands the environment variables like $ENV or ${ENV} in the
+// tags option of the instance.
+func (i Instance) expandTags() {
+	list, ok := i["tags"].([]interface{})
+	if !ok {
+		return
+	}
+	for n, tag := range list {
+		if s, ok := tag.(string); ok {
+			list[n] = os.ExpandEnv(s)
+		}
+	}
+}
+
 // Config XXX
 type Config struct {
 	InitConfig InitConfig `yaml:"init_config"`
 	Instances  []Instance `yaml:"instances"`
+	// Filter selects the metrics and tags submitted by the check.
+	Filter metric.FilterConfig `yaml:"filter"`
 }
 
 // LoadConfig parses the YAML file into a Config.
@@ -41,11 +97,86 @@ func LoadConfig(filename string) (*Config, error) {
 		return nil, err
 	}
 
+	if content, err = util.ExpandEnv(content); err != nil {
+		return nil, err
+	}
+	if content, err = kv.Resolve(content); err != nil {
+		return nil, err
+	}
+
 	config := &Config{}
-	err = yaml.Unmarshal([]byte(string(content)), config)
+	err = yaml.Unmarshal(content, config)
 	if err != nil {
 		return nil, err
 	}
+	if err = secrets.Resolve(config); err != nil {
+		return nil, err
+	}
+
+	for _, instance := range config.Instances {
+		instance.expandTags()
+		if level, _ := instance.LogOptions(); level != "" {
+			if err = log.ValidateLevel(level); err != nil {
+				return nil, fmt.Errorf("invalid log_level: %s", err)
+			}
+		}
+	}
 
 	return config, nil
 }
+
+// AutoConfFile is the name of the autodiscovery templates of a <check>.d
+// directory, it's skipped by LoadConfigDir.
+const AutoConfFile = "auto_conf.yaml"
+
+// LoadConfigDir parses the YAML files of a conf.d/<check>.d directory in the
+// order of their names into a single Config, the instances of every file are
+// run. The init_config options and the filter may be split across the files
+// but not set twice.
+func LoadConfigDir(dir string) (*Config, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	config := &Config{}
+	loaded := 0
+	for _, file := range files {
+		if filepath.Base(file) == AutoConfFile {
+			continue
+		}
+		c, err := LoadConfig(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		if err = config.merge(c); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		loaded++
+	}
+	if loaded == 0 {
+		return nil, fmt.Errorf("no YAML file in %s", dir)
+	}
+	return config, nil
+}
+
+func (c *Config) merge(other *Config) error {
+	for key, value := range other.InitConfig {
+		if _, ok := c.InitConfig[key]; ok {
+			return fmt.Errorf("init_config option %s is already set", key)
+		}
+		if c.InitConfig == nil {
+			c.InitConfig = make(InitConfig)
+		}
+		c.InitConfig[key] = value
+	}
+	if !other.Filter.IsEmpty() {
+		if !c.Filter.IsEmpty() {
+			return fmt.Errorf("filter is already set")
+		}
+		c.Filter = other.Filter
+	}
+	c.Instances = append(c.Instances, other.Instances...)
+	return nil
+}
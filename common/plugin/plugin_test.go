@@ -1,9 +1,16 @@
 package plugin
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/secrets"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -36,6 +43,69 @@ func TestBadConfig(t *testing.T) {
 	}
 }
 
+func TestConfigEnv(t *testing.T) {
+	os.Setenv("CI_TEST_NGINX_HOST", "web-1")
+	defer os.Unsetenv("CI_TEST_NGINX_HOST")
+
+	conf, err := LoadConfig("testdata/nginx_env.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://web-1:80/nginx_status/", conf.Instances[0]["nginx_status_url"])
+
+	os.Unsetenv("CI_TEST_NGINX_HOST")
+	_, err = LoadConfig("testdata/nginx_env.yaml")
+	assert.EqualError(t, err, "environment variable CI_TEST_NGINX_HOST: not set")
+}
+
+func TestConfigSecrets(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the secret backend is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "plugin")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer secrets.Set(nil)
+
+	// The values are never parsed as YAML.
+	backend := filepath.Join(dir, "backend.sh")
+	require.NoError(t, ioutil.WriteFile(backend, []byte(`#!/bin/sh
+cat <<'EOF'
+{"comment": {"value": "#abc", "error": null}, "quoted": {"value": "a\"b\\d", "error": null},
+ "injected": {"value": "x\nport: 1", "error": null}}
+EOF
+`), 0700))
+	secrets.Set(&secrets.Backend{Command: backend})
+
+	path := filepath.Join(dir, "mysql.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`init_config:
+
+instances:
+  - password: SECRET[comment]
+    user: "SECRET[quoted]"
+    host: SECRET[injected]
+    port: 3306
+`), 0600))
+	conf, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, []Instance{{
+		"password": "#abc",
+		"user":     `a"b\d`,
+		"host":     "x\nport: 1",
+		"port":     3306,
+	}}, conf.Instances)
+}
+
+func TestLogOptions(t *testing.T) {
+	_, err := LoadConfig("testdata/nginx_log.yaml")
+	assert.EqualError(t, err, `invalid log_level: not a valid logrus Level: "verbose"`)
+
+	level, file := Instance{
+		"log_level": "debug",
+		"log_file":  "/var/log/cloudinsight-agent/nginx-web-1.log",
+	}.LogOptions()
+	assert.Equal(t, "debug", level)
+	assert.Equal(t, "/var/log/cloudinsight-agent/nginx-web-1.log", file)
+}
+
 func TestEmptyConfig(t *testing.T) {
 	_, err := LoadConfig("")
 	if err == nil {
@@ -43,3 +113,70 @@ func TestEmptyConfig(t *testing.T) {
 	}
 	assert.Contains(t, err.Error(), "no such file or directory")
 }
+
+func TestInstanceUnmarshal(t *testing.T) {
+	conf, err := LoadConfig("testdata/nginx.yaml")
+	if err != nil {
+		t.Fatalf("couldn't load configuration: %v", err)
+	}
+
+	var instance struct {
+		URL  string   `yaml:"nginx_status_url"`
+		Tags []string `yaml:"tags"`
+	}
+	err = conf.Instances[0].Unmarshal(&instance)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost/nginx_status/", instance.URL)
+	assert.Equal(t, []string{"foo:bar"}, instance.Tags)
+}
+
+func TestInstanceTags(t *testing.T) {
+	_ = os.Setenv("CI_TEST_ENV", "prod")
+	defer func() {
+		_ = os.Unsetenv("CI_TEST_ENV")
+	}()
+
+	f, err := ioutil.TempFile("", "plugin")
+	assert.NoError(t, err)
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	_, err = f.WriteString("instances:\n  - tags: [\"env:$CI_TEST_ENV\", \"team:payments\"]\n  - url: http://localhost\n")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	conf, err := LoadConfig(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"env:prod", "team:payments"}, conf.Instances[0].Tags())
+	assert.Nil(t, conf.Instances[1].Tags())
+}
+
+func TestLoadConfigDir(t *testing.T) {
+	conf, err := LoadConfigDir("testdata/nginx.d")
+	assert.NoError(t, err)
+	assert.Equal(t, &Config{
+		InitConfig: InitConfig{"check_interval": 60},
+		Instances: []Instance{
+			{"nginx_status_url": "http://web-1/nginx_status/"},
+			{"nginx_status_url": "http://web-2/nginx_status/"},
+			{"nginx_status_url": "http://web-3/nginx_status/"},
+		},
+		Filter: metric.FilterConfig{MetricExclude: []string{"nginx.net.writing"}},
+	}, conf)
+
+	dir, err := ioutil.TempDir("", "plugin")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = LoadConfigDir(dir)
+	assert.EqualError(t, err, "no YAML file in "+dir)
+
+	for name, content := range map[string]string{
+		"a.yaml": "init_config:\n  timeout: 5\n",
+		"b.yaml": "init_config:\n  timeout: 10\n",
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+	_, err = LoadConfigDir(dir)
+	assert.EqualError(t, err, filepath.Join(dir, "b.yaml")+": init_config option timeout is already set")
+}
@@ -0,0 +1,58 @@
+//go:build !windows
+// +build !windows
+
+package privileges
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// Lookup returns the ids of the user name and of the group, the primary
+// group of the user when group is empty.
+func Lookup(name, group string) (uid, gid int, err error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	gidStr := u.Gid
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return 0, 0, err
+		}
+		gidStr = g.Gid
+	}
+
+	if uid, err = strconv.Atoi(u.Uid); err != nil {
+		return 0, 0, fmt.Errorf("invalid uid %s of %s", u.Uid, name)
+	}
+	if gid, err = strconv.Atoi(gidStr); err != nil {
+		return 0, 0, fmt.Errorf("invalid gid %s", gidStr)
+	}
+	return uid, gid, nil
+}
+
+// Drop switches the process to the user uid and the group gid, for good.
+func Drop(uid, gid int) error {
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("the agent must be started as root to run as another user")
+	}
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("setgroups: %s", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid: %s", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid: %s", err)
+	}
+	// The real and saved ids are set too, root can't be regained.
+	if uid != 0 && syscall.Setuid(0) == nil {
+		return fmt.Errorf("the root privileges could be regained")
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package privileges
+
+import "fmt"
+
+var errUnsupported = fmt.Errorf("run_as_user is not supported on Windows")
+
+// Lookup returns the ids of the user name and of the group.
+func Lookup(name, group string) (uid, gid int, err error) {
+	return 0, 0, errUnsupported
+}
+
+// Drop switches the process to the user uid and the group gid.
+func Drop(uid, gid int) error {
+	return errUnsupported
+}
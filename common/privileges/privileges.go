@@ -0,0 +1,100 @@
+// Package privileges lets the agent start as root and run as an
+// unprivileged user. The listeners on the privileged ports are bound by Bind
+// before the privileges are dropped, Listen and ListenUDP then hand out
+// copies of them, so that they survive the reloads of the configuration.
+package privileges
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+)
+
+var (
+	mu sync.Mutex
+	// bound holds the files of the listeners bound by Bind, by network and
+	// address.
+	bound = make(map[string]*os.File)
+)
+
+// filer is implemented by *net.TCPListener and *net.UDPConn.
+type filer interface {
+	File() (*os.File, error)
+	Close() error
+}
+
+// Bind binds a listener of network, "tcp" or "udp", on addr. It's kept open
+// until the agent exits.
+func Bind(network, addr string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	key := network + "/" + addr
+	if _, ok := bound[key]; ok {
+		return nil
+	}
+
+	var l filer
+	switch network {
+	case "tcp":
+		tl, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		l = tl.(*net.TCPListener)
+	case "udp":
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return err
+		}
+		if l, err = net.ListenUDP("udp", udpAddr); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported network %s", network)
+	}
+
+	// The file is a duplicate, the listener itself isn't needed anymore.
+	f, err := l.File()
+	l.Close()
+	if err != nil {
+		return err
+	}
+	bound[key] = f
+	return nil
+}
+
+// file returns the file of the listener bound on network and addr, nil when
+// there's none.
+func file(network, addr string) *os.File {
+	mu.Lock()
+	defer mu.Unlock()
+	return bound[network+"/"+addr]
+}
+
+// Listen is like net.Listen, it returns a copy of the listener bound by Bind
+// on the same address if any.
+func Listen(network, addr string) (net.Listener, error) {
+	if f := file(network, addr); f != nil {
+		return net.FileListener(f)
+	}
+	return net.Listen(network, addr)
+}
+
+// ListenUDP is like net.ListenUDP, it returns a copy of the listener bound by
+// Bind on the same address if any.
+func ListenUDP(addr string) (*net.UDPConn, error) {
+	if f := file("udp", addr); f != nil {
+		conn, err := net.FilePacketConn(f)
+		if err != nil {
+			return nil, err
+		}
+		return conn.(*net.UDPConn), nil
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve address: %s", err)
+	}
+	return net.ListenUDP("udp", udpAddr)
+}
@@ -0,0 +1,64 @@
+package privileges
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListen(t *testing.T) {
+	require.NoError(t, Bind("tcp", "127.0.0.1:0"))
+	addr := "127.0.0.1:0"
+
+	// Every copy accepts on the bound socket, and closing one doesn't close
+	// it.
+	l, err := Listen("tcp", addr)
+	require.NoError(t, err)
+	bound := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	l, err = Listen("tcp", addr)
+	require.NoError(t, err)
+	defer l.Close()
+	assert.Equal(t, bound, l.Addr().String())
+
+	go func() {
+		if conn, err := net.Dial("tcp", bound); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	conn.Close()
+
+	assert.Error(t, Bind("unix", "/tmp/agent.sock"))
+}
+
+func TestListenUDP(t *testing.T) {
+	require.NoError(t, Bind("udp", "127.0.0.1:0"))
+
+	conn, err := ListenUDP("127.0.0.1:0")
+	require.NoError(t, err)
+	bound := conn.LocalAddr().String()
+	require.NoError(t, conn.Close())
+
+	conn, err = ListenUDP("127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, bound, conn.LocalAddr().String())
+
+	client, err := net.Dial("udp", bound)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("page.views:1|c"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 64)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "page.views:1|c", string(buf[:n]))
+}
@@ -0,0 +1,133 @@
+package prometheus
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Bucket is a cumulative bucket of a histogram.
+type Bucket struct {
+	UpperBound float64
+	Count      float64
+}
+
+// Histogram is a histogram series rebuilt from its _bucket, _sum and _count
+// samples.
+type Histogram struct {
+	Labels  map[string]string
+	Sum     float64
+	Count   float64
+	Buckets []Bucket
+}
+
+// Histograms groups the samples of a histogram family into series, by their
+// labels other than le.
+func (f *Family) Histograms() []*Histogram {
+	var histograms []*Histogram
+	byKey := make(map[string]*Histogram)
+
+	for _, s := range f.Samples {
+		labels := make(map[string]string)
+		for k, v := range s.Labels {
+			if k != "le" {
+				labels[k] = v
+			}
+		}
+		key := LabelsKey(labels)
+		h, ok := byKey[key]
+		if !ok {
+			h = &Histogram{Labels: labels}
+			byKey[key] = h
+			histograms = append(histograms, h)
+		}
+
+		switch s.Name {
+		case f.Name + "_sum":
+			h.Sum = s.Value
+		case f.Name + "_count":
+			h.Count = s.Value
+		case f.Name + "_bucket":
+			bound, err := parseValue(s.Labels["le"])
+			if err != nil {
+				continue
+			}
+			h.Buckets = append(h.Buckets, Bucket{UpperBound: bound, Count: s.Value})
+		}
+	}
+
+	for _, h := range histograms {
+		sort.Slice(h.Buckets, func(i, j int) bool {
+			return h.Buckets[i].UpperBound < h.Buckets[j].UpperBound
+		})
+	}
+	return histograms
+}
+
+// Delta returns the histogram of the observations made since prev.
+func (h *Histogram) Delta(prev *Histogram) *Histogram {
+	d := &Histogram{
+		Labels: h.Labels,
+		Sum:    h.Sum - prev.Sum,
+		Count:  h.Count - prev.Count,
+	}
+	for i, b := range h.Buckets {
+		count := b.Count
+		if i < len(prev.Buckets) && prev.Buckets[i].UpperBound == b.UpperBound {
+			count -= prev.Buckets[i].Count
+		}
+		d.Buckets = append(d.Buckets, Bucket{UpperBound: b.UpperBound, Count: count})
+	}
+	return d
+}
+
+// Quantile estimates the q-quantile (0 <= q <= 1) of the observations by
+// linear interpolation within the buckets, like histogram_quantile of PromQL.
+func (h *Histogram) Quantile(q float64) float64 {
+	if len(h.Buckets) == 0 {
+		return math.NaN()
+	}
+	total := h.Buckets[len(h.Buckets)-1].Count
+	if total <= 0 {
+		return math.NaN()
+	}
+
+	rank := q * total
+	var lowerBound, lowerCount float64
+	for i, b := range h.Buckets {
+		if b.Count >= rank {
+			if math.IsInf(b.UpperBound, 1) {
+				// Can't interpolate in the +Inf bucket, return the largest
+				// finite bound.
+				if i > 0 {
+					return h.Buckets[i-1].UpperBound
+				}
+				return math.NaN()
+			}
+			if b.Count == lowerCount {
+				return b.UpperBound
+			}
+			return lowerBound + (b.UpperBound-lowerBound)*(rank-lowerCount)/(b.Count-lowerCount)
+		}
+		lowerBound, lowerCount = b.UpperBound, b.Count
+	}
+	return lowerBound
+}
+
+// LabelsKey returns a canonical string of the labels, usable as a map key.
+func LabelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var key []byte
+	for _, name := range names {
+		key = append(key, name...)
+		key = append(key, '=')
+		key = strconv.AppendQuote(key, labels[name])
+		key = append(key, ',')
+	}
+	return string(key)
+}
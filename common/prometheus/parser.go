@@ -0,0 +1,201 @@
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Family is a group of samples sharing the same metric name and type.
+type Family struct {
+	Name    string
+	Type    string
+	Help    string
+	Samples []Sample
+}
+
+// Sample is a single sample of the text exposition format. For histograms
+// and summaries, Name carries the _bucket, _sum or _count suffix.
+type Sample struct {
+	Name      string
+	Labels    map[string]string
+	Value     float64
+	Timestamp int64
+}
+
+// Parse parses the Prometheus text exposition format, the families are
+// returned in the order they appear in the input.
+func Parse(r io.Reader) ([]*Family, error) {
+	var families []*Family
+	byName := make(map[string]*Family)
+
+	getFamily := func(name string) *Family {
+		if f, ok := byName[name]; ok {
+			return f
+		}
+		f := &Family{Name: name, Type: "untyped"}
+		byName[name] = f
+		families = append(families, f)
+		return f
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			fields := strings.SplitN(strings.TrimSpace(line[1:]), " ", 3)
+			if len(fields) < 3 {
+				// Comments and empty HELP lines.
+				continue
+			}
+			switch fields[0] {
+			case "TYPE":
+				getFamily(fields[1]).Type = strings.ToLower(strings.TrimSpace(fields[2]))
+			case "HELP":
+				getFamily(fields[1]).Help = fields[2]
+			}
+			continue
+		}
+
+		sample, err := parseSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNum, err)
+		}
+		f := getFamily(familyName(sample.Name, byName))
+		f.Samples = append(f.Samples, sample)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return families, nil
+}
+
+// familyName finds the family a sample belongs to, which is the sample name
+// without the suffixes of histograms and summaries.
+func familyName(name string, families map[string]*Family) string {
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		base := strings.TrimSuffix(name, suffix)
+		if f, ok := families[base]; ok && (f.Type == "histogram" || f.Type == "summary") {
+			return base
+		}
+	}
+	return name
+}
+
+// parseSample parses a line like:
+//
+//	http_requests_total{method="post",code="200"} 1027 1395066363000
+func parseSample(line string) (Sample, error) {
+	s := Sample{Labels: make(map[string]string)}
+
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return s, fmt.Errorf("invalid sample %q", line)
+	}
+	s.Name = line[:end]
+	rest := line[end:]
+
+	if rest[0] == '{' {
+		var err error
+		if rest, err = parseLabels(rest[1:], s.Labels); err != nil {
+			return s, err
+		}
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return s, fmt.Errorf("invalid sample %q", line)
+	}
+
+	value, err := parseValue(fields[0])
+	if err != nil {
+		return s, err
+	}
+	s.Value = value
+
+	if len(fields) == 2 {
+		if s.Timestamp, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+			return s, fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+	}
+	return s, nil
+}
+
+// parseLabels parses the labels until the closing brace and returns the rest
+// of the line.
+func parseLabels(in string, labels map[string]string) (string, error) {
+	for {
+		in = strings.TrimLeft(in, " \t,")
+		if in == "" {
+			return "", fmt.Errorf("unterminated label set")
+		}
+		if in[0] == '}' {
+			return in[1:], nil
+		}
+
+		eq := strings.IndexByte(in, '=')
+		if eq <= 0 {
+			return "", fmt.Errorf("invalid label in %q", in)
+		}
+		name := strings.TrimSpace(in[:eq])
+		in = strings.TrimLeft(in[eq+1:], " \t")
+		if in == "" || in[0] != '"' {
+			return "", fmt.Errorf("label value of %s is not quoted", name)
+		}
+
+		var value []byte
+		i := 1
+		for ; i < len(in); i++ {
+			c := in[i]
+			if c == '"' {
+				break
+			}
+			if c == '\\' && i+1 < len(in) {
+				i++
+				switch in[i] {
+				case 'n':
+					c = '\n'
+				default:
+					c = in[i]
+				}
+			}
+			value = append(value, c)
+		}
+		if i >= len(in) {
+			return "", fmt.Errorf("unterminated value of label %s", name)
+		}
+		labels[name] = string(value)
+		in = in[i+1:]
+	}
+}
+
+func parseValue(s string) (float64, error) {
+	switch s {
+	case "+Inf", "Inf":
+		return math.Inf(1), nil
+	case "-Inf":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return value, nil
+}
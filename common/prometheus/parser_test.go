@@ -0,0 +1,90 @@
+package prometheus
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const exposition = `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+# A comment
+metric_without_timestamp_and_labels 12.47
+escaped{path="C:\\dir\\",msg="say \"hi\"\n"} -Inf
+
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{le="0.1"} 50
+request_duration_seconds_bucket{le="0.5"} 90
+request_duration_seconds_bucket{le="+Inf"} 100
+request_duration_seconds_sum 30
+request_duration_seconds_count 100
+`
+
+func TestParse(t *testing.T) {
+	families, err := Parse(strings.NewReader(exposition))
+	require.NoError(t, err)
+	require.Len(t, families, 4)
+
+	f := families[0]
+	assert.Equal(t, "http_requests_total", f.Name)
+	assert.Equal(t, "counter", f.Type)
+	assert.Equal(t, "The total number of HTTP requests.", f.Help)
+	require.Len(t, f.Samples, 2)
+	assert.Equal(t, map[string]string{"method": "post", "code": "400"}, f.Samples[1].Labels)
+	assert.Equal(t, float64(3), f.Samples[1].Value)
+	assert.Equal(t, int64(1395066363000), f.Samples[1].Timestamp)
+
+	f = families[1]
+	assert.Equal(t, "untyped", f.Type)
+	assert.Equal(t, 12.47, f.Samples[0].Value)
+
+	f = families[2]
+	assert.Equal(t, `C:\dir\`, f.Samples[0].Labels["path"])
+	assert.Equal(t, "say \"hi\"\n", f.Samples[0].Labels["msg"])
+	assert.True(t, math.IsInf(f.Samples[0].Value, -1))
+
+	f = families[3]
+	assert.Equal(t, "histogram", f.Type)
+	assert.Len(t, f.Samples, 5)
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, in := range []string{
+		`metric{label="value" 1`,
+		`metric{label=value} 1`,
+		`metric abc`,
+		`metric 1 2 3`,
+	} {
+		_, err := Parse(strings.NewReader(in))
+		assert.Error(t, err, in)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	families, err := Parse(strings.NewReader(exposition))
+	require.NoError(t, err)
+
+	histograms := families[3].Histograms()
+	require.Len(t, histograms, 1)
+	h := histograms[0]
+	assert.Equal(t, float64(30), h.Sum)
+	assert.Equal(t, float64(100), h.Count)
+	require.Len(t, h.Buckets, 3)
+
+	assert.InDelta(t, 0.05, h.Quantile(0.25), 1e-9)
+	assert.InDelta(t, 0.3, h.Quantile(0.7), 1e-9)
+	// The +Inf bucket can't be interpolated.
+	assert.Equal(t, 0.5, h.Quantile(0.99))
+
+	prev := &Histogram{Sum: 10, Count: 40, Buckets: []Bucket{{0.1, 40}, {0.5, 40}, {math.Inf(1), 40}}}
+	d := h.Delta(prev)
+	assert.Equal(t, float64(20), d.Sum)
+	assert.Equal(t, float64(60), d.Count)
+	assert.Equal(t, []Bucket{{0.1, 10}, {0.5, 50}, {math.Inf(1), 60}}, d.Buckets)
+}
@@ -0,0 +1,80 @@
+// Package prometheus converts the metrics to the Prometheus data model.
+package prometheus
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/output"
+)
+
+// Label is a Prometheus label.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// MetricName turns name into a valid Prometheus metric name, the dots and
+// the other invalid characters are replaced by underscores:
+// "system.load.1" is "system_load_1".
+func MetricName(name string) string {
+	return sanitize(name, true)
+}
+
+// LabelName turns name into a valid Prometheus label name.
+func LabelName(name string) string {
+	return sanitize(name, false)
+}
+
+func sanitize(name string, colons bool) string {
+	b := []byte(name)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_':
+		case c == ':' && colons:
+		default:
+			b[i] = '_'
+		}
+	}
+	// The names can't start with a digit.
+	if len(b) == 0 || b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
+
+// Labels returns the tags of m as labels sorted by name. The names starting
+// with "__" are reserved by Prometheus, they're prefixed by "tag".
+func Labels(m *metric.Metric) []Label {
+	tags := output.TagMap(m)
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	seen := make(map[string]bool, len(tags))
+	labels := make([]Label, 0, len(tags))
+	for _, key := range keys {
+		name := LabelName(key)
+		if strings.HasPrefix(name, "__") {
+			name = "tag" + name
+		}
+		// Distinct tags may have the same sanitized name, keep the first.
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		labels = append(labels, Label{Name: name, Value: tags[key]})
+	}
+	SortLabels(labels)
+	return labels
+}
+
+// SortLabels sorts labels by name.
+func SortLabels(labels []Label) {
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+}
@@ -0,0 +1,28 @@
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricName(t *testing.T) {
+	assert.Equal(t, "system_load_1", MetricName("system.load.1"))
+	assert.Equal(t, "_1xx:rate", MetricName("1xx:rate"))
+	assert.Equal(t, "nginx_requests_per_s", MetricName("nginx.requests per/s"))
+	assert.Equal(t, "_", MetricName(""))
+	assert.Equal(t, "region_1_zone", LabelName("region-1:zone"))
+}
+
+func TestLabels(t *testing.T) {
+	m := metric.NewMetric("system.load.1", 1, []string{"env:prod", "a.b:1", "a_b:2", "__meta:x"})
+	m.DeviceName = "sda"
+
+	assert.Equal(t, []Label{
+		{Name: "a_b", Value: "1"},
+		{Name: "device", Value: "sda"},
+		{Name: "env", Value: "prod"},
+		{Name: "tag__meta", Value: "x"},
+	}, Labels(&m))
+}
@@ -0,0 +1,183 @@
+package prometheus
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+)
+
+// DefaultMaxAge is the time the series are exposed after their last update.
+const DefaultMaxAge = 5 * time.Minute
+
+// Default is the store the emitters write to when the exposition endpoint is
+// enabled, it's shared by the checks and Statsd.
+var Default = NewStore(DefaultMaxAge)
+
+type series struct {
+	name    string
+	labels  []Label
+	value   float64
+	updated time.Time
+}
+
+// Store keeps the latest value of every series, it's an output.Output.
+type Store struct {
+	mu     sync.Mutex
+	series map[string]*series
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+// NewStore creates a store forgetting the series not updated for maxAge.
+func NewStore(maxAge time.Duration) *Store {
+	return &Store{
+		series: make(map[string]*series),
+		maxAge: maxAge,
+		now:    time.Now,
+	}
+}
+
+// SetMaxAge changes the time the series are kept.
+func (s *Store) SetMaxAge(maxAge time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxAge = maxAge
+}
+
+// Write updates the series of the metrics, the ones without a numeric value
+// are skipped.
+func (s *Store) Write(metrics []metric.Metric) error {
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for n := range metrics {
+		m := &metrics[n]
+		value, err := m.FloatValue()
+		if err != nil {
+			continue
+		}
+
+		ser := &series{
+			name:    MetricName(m.Name),
+			labels:  Labels(m),
+			value:   value,
+			updated: now,
+		}
+		s.series[ser.key()] = ser
+	}
+	return nil
+}
+
+// Connect does nothing, the store is in memory.
+func (s *Store) Connect() error {
+	return nil
+}
+
+// Close does nothing, the series are kept for the next emitters.
+func (s *Store) Close() error {
+	return nil
+}
+
+// Len returns the number of series.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.series)
+}
+
+func (ser *series) key() string {
+	var b bytes.Buffer
+	b.WriteString(ser.name)
+	for _, l := range ser.labels {
+		b.WriteByte(0)
+		b.WriteString(l.Name)
+		b.WriteByte(0)
+		b.WriteString(l.Value)
+	}
+	return b.String()
+}
+
+// WriteText writes the series in the text exposition format of Prometheus,
+// as gauges grouped by name. The expired series are removed.
+func (s *Store) WriteText(w io.Writer) error {
+	s.mu.Lock()
+	expired := s.now().Add(-s.maxAge)
+	all := make([]*series, 0, len(s.series))
+	for key, ser := range s.series {
+		if ser.updated.Before(expired) {
+			delete(s.series, key)
+			continue
+		}
+		all = append(all, ser)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].name != all[j].name {
+			return all[i].name < all[j].name
+		}
+		return all[i].key() < all[j].key()
+	})
+
+	bw := bufio.NewWriter(w)
+	for i, ser := range all {
+		if i == 0 || ser.name != all[i-1].name {
+			WriteType(bw, ser.name, "gauge")
+		}
+		WriteSample(bw, ser.name, ser.labels, ser.value)
+	}
+	return bw.Flush()
+}
+
+// WriteType writes the TYPE line of a metric family.
+func WriteType(w *bufio.Writer, name, typ string) {
+	w.WriteString("# TYPE ")
+	w.WriteString(name)
+	w.WriteByte(' ')
+	w.WriteString(typ)
+	w.WriteByte('\n')
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteSample writes a sample line.
+func WriteSample(w *bufio.Writer, name string, labels []Label, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(l.Name)
+			w.WriteString(`="`)
+			w.WriteString(labelValueEscaper.Replace(l.Value))
+			w.WriteByte('"')
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
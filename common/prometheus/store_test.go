@@ -0,0 +1,51 @@
+package prometheus
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	s := NewStore(time.Minute)
+	s.now = func() time.Time { return now }
+
+	load := metric.NewMetric("system.load.1", 0.5, []string{"env:prod"})
+	load.Hostname = "host1"
+	require.NoError(t, s.Write([]metric.Metric{
+		load,
+		metric.NewMetric("system.load.1", 2, []string{"path:C:\\", `quote:"a"`}),
+		metric.NewMetric("nginx.net.requests", math.Inf(1)),
+		metric.NewMetric("system.load.5", "invalid"),
+	}))
+
+	// The latest value is kept.
+	now = now.Add(30 * time.Second)
+	load.Value = 0.75
+	require.NoError(t, s.Write([]metric.Metric{load}))
+	assert.Equal(t, 3, s.Len())
+
+	var b bytes.Buffer
+	require.NoError(t, s.WriteText(&b))
+	assert.Equal(t, `# TYPE nginx_net_requests gauge
+nginx_net_requests +Inf
+# TYPE system_load_1 gauge
+system_load_1{env="prod",host="host1"} 0.75
+system_load_1{path="C:\\",quote="\"a\""} 2
+`, b.String())
+
+	// The series not updated for a minute expire.
+	now = now.Add(45 * time.Second)
+	b.Reset()
+	require.NoError(t, s.WriteText(&b))
+	assert.Equal(t, `# TYPE system_load_1 gauge
+system_load_1{env="prod",host="host1"} 0.75
+`, b.String())
+	assert.Equal(t, 1, s.Len())
+}
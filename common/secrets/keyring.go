@@ -0,0 +1,134 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sync"
+)
+
+// keySize is the size of the AES-256 key of the key files.
+const keySize = 32
+
+var encrypted = regexp.MustCompile(`ENC\[([A-Za-z0-9+/=]+)\]`)
+
+// Keyring encrypts the ENC[...] values of the configuration files with the
+// key of a local file, so that the credentials of the files are encrypted
+// at rest and only the agent of the host can read them. The values are
+// encrypted with AES-256-GCM.
+type Keyring struct {
+	// Path is the key file, it holds the base64 encoded key and must only
+	// be accessible by its owner.
+	Path string
+
+	once sync.Once
+	aead cipher.AEAD
+	err  error
+}
+
+// GenerateKey writes a new random key to path, it fails if the file exists.
+func GenerateKey(path string) error {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(base64.StdEncoding.EncodeToString(key) + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// load reads the key file once.
+func (k *Keyring) load() (cipher.AEAD, error) {
+	k.once.Do(func() {
+		k.aead, k.err = loadKey(k.Path)
+	})
+	return k.aead, k.err
+}
+
+func loadKey(path string) (cipher.AEAD, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret key file: %s", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("invalid secret key file %s: it must only be accessible by its owner, its mode is %s", path, info.Mode().Perm())
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret key file: %s", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(content)))
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("invalid secret key file %s: it must hold a base64 encoded %d bytes key", path, keySize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt returns the ENC[...] value of plaintext.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	aead, err := k.load()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return "ENC[" + base64.StdEncoding.EncodeToString(sealed) + "]", nil
+}
+
+// decrypt returns the plaintext of the base64 payload of an ENC[...] value.
+func (k *Keyring) decrypt(payload string) (string, error) {
+	aead, err := k.load()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("invalid encrypted value ENC[%s]", payload)
+	}
+	n := aead.NonceSize()
+	plaintext, err := aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt ENC[%s], it was encrypted with another key than %s", payload, k.Path)
+	}
+	return string(plaintext), nil
+}
+
+var currentKeyring *Keyring
+
+// SetKeyring sets the keyring decrypting the ENC[...] values, nil when none
+// is configured.
+func SetKeyring(k *Keyring) {
+	mu.Lock()
+	defer mu.Unlock()
+	currentKeyring = k
+}
@@ -0,0 +1,60 @@
+//go:build !windows
+// +build !windows
+
+package secrets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyring(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer SetKeyring(nil)
+
+	path := filepath.Join(dir, "agent", "secret.key")
+	require.NoError(t, GenerateKey(path))
+	assert.Error(t, GenerateKey(path), "the key is never overwritten")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	keyring := &Keyring{Path: path}
+	value, err := keyring.Encrypt("p4ss")
+	require.NoError(t, err)
+	assert.Regexp(t, `^ENC\[[A-Za-z0-9+/=]+\]$`, value)
+	other, err := keyring.Encrypt("p4ss")
+	require.NoError(t, err)
+	assert.NotEqual(t, value, other)
+
+	password := value
+	assert.EqualError(t, Resolve(&password), value+" is used but no secret key file is configured")
+
+	// The plaintext is never parsed, nor resolved again.
+	tricky, err := keyring.Encrypt("#a\"b\\d\n- &c SECRET[x]")
+	require.NoError(t, err)
+	SetKeyring(&Keyring{Path: path})
+	options := map[string]interface{}{"password": value, "list": []interface{}{"u:" + tricky}}
+	require.NoError(t, Resolve(&options))
+	assert.Equal(t, map[string]interface{}{
+		"password": "p4ss",
+		"list":     []interface{}{"u:#a\"b\\d\n- &c SECRET[x]"},
+	}, options)
+
+	otherPath := filepath.Join(dir, "other.key")
+	require.NoError(t, GenerateKey(otherPath))
+	SetKeyring(&Keyring{Path: otherPath})
+	password = value
+	assert.EqualError(t, Resolve(&password), "unable to decrypt "+value+", it was encrypted with another key than "+otherPath)
+
+	require.NoError(t, os.Chmod(otherPath, 0644))
+	_, err = (&Keyring{Path: otherPath}).Encrypt("p4ss")
+	assert.EqualError(t, err, "invalid secret key file "+otherPath+": it must only be accessible by its owner, its mode is -rw-r--r--")
+}
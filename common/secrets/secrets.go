@@ -0,0 +1,204 @@
+// Package secrets resolves the SECRET[handle] placeholders of the
+// configuration files with an executable provided by the user, so that the
+// credentials aren't written in plain text. The executable may fetch them
+// from Vault, AWS Secrets Manager or any other store. The ENC[...] values,
+// encrypted with the key file of the agent, are decrypted too, see Keyring.
+//
+// The executable reads the handles on its standard input:
+//
+//	{"version": "1.0", "secrets": ["db_password", "api_token"]}
+//
+// and writes their values on its standard output:
+//
+//	{"db_password": {"value": "p4ss", "error": null}, "api_token": {"value": null, "error": "not found"}}
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// protocolVersion is the version of the payload given to the executable.
+const protocolVersion = "1.0"
+
+var placeholder = regexp.MustCompile(`SECRET\[([^\]\n]+)\]`)
+
+// Backend runs the executable resolving the secrets, the resolved values are
+// cached for the life of the Backend.
+type Backend struct {
+	Command string
+	Args    []string
+	// Timeout is the time the executable has to answer, 0 uses
+	// util.DefaultCommandTimeout.
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+type secretResult struct {
+	Value *string `json:"value"`
+	Error *string `json:"error"`
+}
+
+// Resolve returns the values of the handles, in a single run of the
+// executable for the handles which aren't cached.
+func (b *Backend) Resolve(handles []string) (map[string]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cache == nil {
+		b.cache = make(map[string]string)
+	}
+
+	var missing []string
+	for _, handle := range handles {
+		if _, ok := b.cache[handle]; !ok {
+			missing = append(missing, handle)
+		}
+	}
+	if len(missing) > 0 {
+		results, err := b.run(missing)
+		if err != nil {
+			return nil, err
+		}
+		for _, handle := range missing {
+			result, ok := results[handle]
+			switch {
+			case !ok:
+				return nil, fmt.Errorf("secret %s is missing from the output of %s", handle, b.Command)
+			case result.Error != nil:
+				return nil, fmt.Errorf("unable to resolve secret %s: %s", handle, *result.Error)
+			case result.Value == nil:
+				return nil, fmt.Errorf("secret %s has no value", handle)
+			}
+			b.cache[handle] = *result.Value
+		}
+	}
+
+	values := make(map[string]string, len(handles))
+	for _, handle := range handles {
+		values[handle] = b.cache[handle]
+	}
+	return values, nil
+}
+
+func (b *Backend) run(handles []string) (map[string]secretResult, error) {
+	if err := checkPermissions(b.Command); err != nil {
+		return nil, err
+	}
+
+	input, _ := json.Marshal(map[string]interface{}{
+		"version": protocolVersion,
+		"secrets": handles,
+	})
+	out, err := util.RunCommandWithInput(b.Timeout, input, b.Command, b.Args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve secrets: %s", err)
+	}
+
+	var results map[string]secretResult
+	if err = json.Unmarshal(out, &results); err != nil {
+		return nil, fmt.Errorf("invalid output of %s: %s", b.Command, err)
+	}
+	return results, nil
+}
+
+// checkPermissions refuses the executables other users may modify or read,
+// they could leak or tamper with the secrets.
+func checkPermissions(command string) error {
+	info, err := os.Stat(command)
+	if err != nil {
+		return fmt.Errorf("invalid secret backend command: %s", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("invalid secret backend command %s: it must only be accessible by its owner, its mode is %s", command, info.Mode().Perm())
+	}
+	return nil
+}
+
+var (
+	mu      sync.RWMutex
+	current *Backend
+)
+
+// Set sets the backend resolving the placeholders, nil when none is
+// configured.
+func Set(b *Backend) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = b
+}
+
+// value matches the ENC[...] values and the SECRET[handle] placeholders.
+var value = regexp.MustCompile(encrypted.String() + "|" + placeholder.String())
+
+// Resolve replaces the ENC[...] values of the string values of v, a pointer
+// to a decoded configuration, with their plaintext, decrypted by the keyring
+// set with SetKeyring, and the SECRET[handle] placeholders with the values
+// resolved by the backend set with Set. They're resolved once the files are
+// decoded so that the values are never parsed: whatever they hold, like
+// quotes, backslashes or a leading #, they can't break the file, change its
+// meaning or add options to it.
+func Resolve(v interface{}) error {
+	seen := make(map[string]bool)
+	var handles []string
+	found := false
+	err := util.ReplaceStrings(v, func(s string) (string, error) {
+		if value.MatchString(s) {
+			found = true
+		}
+		for _, m := range placeholder.FindAllStringSubmatch(s, -1) {
+			if handle := strings.TrimSpace(m[1]); !seen[handle] {
+				seen[handle] = true
+				handles = append(handles, handle)
+			}
+		}
+		return s, nil
+	})
+	if err != nil || !found {
+		return err
+	}
+
+	mu.RLock()
+	b, k := current, currentKeyring
+	mu.RUnlock()
+	var values map[string]string
+	if len(handles) > 0 {
+		if b == nil {
+			return fmt.Errorf("SECRET[%s] is used but no secret_backend_command is configured", handles[0])
+		}
+		if values, err = b.Resolve(handles); err != nil {
+			return err
+		}
+	}
+
+	// The values are replaced in a single pass, a plaintext or a secret
+	// holding a placeholder isn't resolved again.
+	return util.ReplaceStrings(v, func(s string) (string, error) {
+		var err error
+		s = value.ReplaceAllStringFunc(s, func(m string) string {
+			sub := value.FindStringSubmatch(m)
+			switch {
+			case err != nil:
+				return ""
+			case sub[2] != "":
+				return values[strings.TrimSpace(sub[2])]
+			case k == nil:
+				err = fmt.Errorf("%s is used but no secret key file is configured", m)
+				return ""
+			}
+			var plaintext string
+			plaintext, err = k.decrypt(sub[1])
+			return plaintext
+		})
+		return s, err
+	})
+}
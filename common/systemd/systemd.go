@@ -0,0 +1,126 @@
+// Package systemd implements the notifications of the services managed by
+// systemd, see sd_notify(3): the readiness of the Type=notify services and
+// the pings of the watchdog enabled by WatchdogSec, which restarts a hung
+// agent.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/watchdog"
+)
+
+// checkInterval is the time between two checks of the readiness.
+const checkInterval = time.Second
+
+// Notify sends state to the service manager, e.g. "READY=1". It returns
+// false when the agent isn't run by systemd, which sets NOTIFY_SOCKET.
+func Notify(state string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	// The abstract sockets start with a null byte.
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the interval of the watchdog of the service, 0
+// when it's disabled or set for another process.
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC %q", usec)
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
+
+// Run notifies systemd once the report of check is ready, and pings the
+// watchdog twice per interval as long as it's healthy, until shutdown is
+// closed. A stuck agent misses the pings and is restarted by systemd.
+func Run(shutdown chan struct{}, check func(now time.Time) watchdog.Report) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	interval, err := WatchdogInterval()
+	if err != nil {
+		log.Errorf("Failed to read the systemd watchdog interval: %s", err)
+	}
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	var lastPing time.Time
+	ready := false
+	healthy := true
+
+	for {
+		now := time.Now()
+		report := check(now)
+		if !ready && report.Ready {
+			ready = true
+			notify("READY=1\nSTATUS=Running")
+		}
+		if report.Healthy != healthy {
+			healthy = report.Healthy
+			if healthy {
+				notify("STATUS=Running")
+			} else {
+				log.Errorf("The agent is stuck: %s", stuck(report))
+				notify("STATUS=Stuck: " + stuck(report))
+			}
+		}
+		if interval > 0 && healthy && now.Sub(lastPing) >= interval/2 {
+			lastPing = now
+			notify("WATCHDOG=1")
+		}
+
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func notify(state string) {
+	if _, err := Notify(state); err != nil {
+		log.Debugf("Failed to notify systemd: %s", err)
+	}
+}
+
+// stuck lists the stuck components of report.
+func stuck(report watchdog.Report) string {
+	s := ""
+	for _, c := range report.Components {
+		if c.Status == watchdog.Stuck {
+			if s != "" {
+				s += ", "
+			}
+			s += c.Name
+		}
+	}
+	return s
+}
@@ -0,0 +1,82 @@
+//go:build !windows
+// +build !windows
+
+package systemd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/watchdog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	interval, err := WatchdogInterval()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), interval)
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	interval, err = WatchdogInterval()
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, interval)
+
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	interval, err = WatchdogInterval()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), interval)
+
+	os.Setenv("WATCHDOG_USEC", "soon")
+	_, err = WatchdogInterval()
+	assert.Error(t, err)
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemd")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", path)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	os.Setenv("WATCHDOG_USEC", "1000000")
+	defer os.Unsetenv("WATCHDOG_USEC")
+
+	ok, err := Notify("STATUS=Starting")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	shutdown := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		Run(shutdown, func(time.Time) watchdog.Report {
+			return watchdog.Report{Healthy: true, Ready: true}
+		})
+		close(done)
+	}()
+
+	buf := make([]byte, 1024)
+	var states []string
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for len(states) < 3 {
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		states = append(states, string(buf[:n]))
+	}
+	close(shutdown)
+	<-done
+	assert.Equal(t, []string{"STATUS=Starting", "READY=1\nSTATUS=Running", "WATCHDOG=1"}, states)
+}
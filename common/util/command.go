@@ -0,0 +1,84 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultCommandTimeout is the default timeout of the commands run by plugins.
+const DefaultCommandTimeout = 10 * time.Second
+
+// killWaitDelay bounds the wait for the output pipes to be closed once a
+// command is killed, a process which left the process group of the command
+// may keep them open.
+const killWaitDelay = time.Second
+
+// RunCommand runs the command and returns its standard output, it's killed
+// along with its children if it doesn't return within timeout. The standard
+// error is included in the returned error.
+func RunCommand(timeout time.Duration, name string, args ...string) ([]byte, error) {
+	return RunCommandWithInput(timeout, nil, name, args...)
+}
+
+// RunCommandWithInput is like RunCommand but writes input to the standard
+// input of the command, e.g. a script which holds credentials that must not
+// show up in the process list.
+func RunCommandWithInput(timeout time.Duration, input []byte, name string, args ...string) ([]byte, error) {
+	return runCommand(timeout, input, nil, name, args...)
+}
+
+// RunCommandWithEnv is like RunCommand but adds env, "KEY=value" pairs, to
+// the environment of the command, e.g. a password read by the command from
+// its environment, which unlike its arguments other users can't read.
+func RunCommandWithEnv(timeout time.Duration, env []string, name string, args ...string) ([]byte, error) {
+	return runCommand(timeout, nil, env, name, args...)
+}
+
+func runCommand(timeout time.Duration, input []byte, env []string, name string, args ...string) ([]byte, error) {
+	if timeout <= 0 {
+		timeout = DefaultCommandTimeout
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			msg := strings.TrimSpace(stderr.String())
+			if msg == "" {
+				return stdout.Bytes(), fmt.Errorf("%s failed: %s", name, err)
+			}
+			return stdout.Bytes(), fmt.Errorf("%s failed: %s: %s", name, err, msg)
+		}
+		return stdout.Bytes(), nil
+	case <-time.After(timeout):
+		_ = killProcessGroup(cmd)
+		select {
+		case <-done:
+		case <-time.After(killWaitDelay):
+		}
+		return nil, fmt.Errorf("%s timed out after %s", name, timeout)
+	}
+}
@@ -0,0 +1,55 @@
+//go:build !windows
+// +build !windows
+
+package util
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCommand(t *testing.T) {
+	out, err := RunCommand(0, "echo", "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\n", string(out))
+
+	_, err = RunCommand(0, "sh", "-c", "echo oops >&2; exit 3")
+	assert.EqualError(t, err, "sh failed: exit status 3: oops")
+
+	_, err = RunCommand(10*time.Millisecond, "sleep", "1")
+	assert.EqualError(t, err, "sleep timed out after 10ms")
+}
+
+func TestRunCommandTimeoutWithChild(t *testing.T) {
+	// The sleeping child inherits the standard output, killing the shell
+	// alone leaves the pipe open.
+	start := time.Now()
+	_, err := RunCommand(100*time.Millisecond, "sh", "-c", "sleep 30 & wait")
+	assert.EqualError(t, err, "sh timed out after 100ms")
+	assert.True(t, time.Since(start) < 5*time.Second, "the child must be killed too")
+
+	// Even when the child leaves the process group.
+	if _, err = exec.LookPath("setsid"); err != nil {
+		t.Skip("setsid is not installed")
+	}
+	start = time.Now()
+	_, err = RunCommand(100*time.Millisecond, "sh", "-c", "setsid sleep 10 & wait")
+	assert.EqualError(t, err, "sh timed out after 100ms")
+	assert.True(t, time.Since(start) < 5*time.Second, "the wait must be bounded")
+}
+
+func TestRunCommandWithInput(t *testing.T) {
+	out, err := RunCommandWithInput(0, []byte("hello\n"), "cat")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\n", string(out))
+}
+
+func TestRunCommandWithEnv(t *testing.T) {
+	out, err := RunCommandWithEnv(0, []string{"CLOUDINSIGHT_TEST=s3cr3t"}, "sh", "-c", `echo "$CLOUDINSIGHT_TEST $HOME"`)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t "+os.Getenv("HOME")+"\n", string(out))
+}
@@ -0,0 +1,10 @@
+package util
+
+import "os/exec"
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the command, its children are left running.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
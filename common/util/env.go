@@ -0,0 +1,149 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ExpandEnv replaces the ${VAR} placeholders of a configuration file with the
+// environment variables. ${VAR:-default} uses default when VAR is unset or
+// empty, ${VAR-default} only when it's unset, ${VAR:?message} is an error
+// when VAR is unset or empty, and $$ is a literal $. Like in a shell, an
+// unset variable without a default is empty. The bare $VAR isn't replaced,
+// so that the passwords holding a $ are kept, nor are the groups ${1}, ${2},
+// etc. of the transform rules. The comment lines are left alone, they may
+// document the placeholders or hold examples which aren't valid ones.
+func ExpandEnv(content []byte) ([]byte, error) {
+	if bytes.IndexByte(content, '$') < 0 {
+		return content, nil
+	}
+
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		if IsCommentLine(line) {
+			buf.Write(line)
+			continue
+		}
+		if err := expandLine(&buf, line); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// IsCommentLine returns true for the lines of a TOML or YAML file which only
+// hold a comment, the placeholders in them are left alone.
+func IsCommentLine(line []byte) bool {
+	line = bytes.TrimLeft(line, " \t")
+	return len(line) > 0 && line[0] == '#'
+}
+
+func expandLine(buf *bytes.Buffer, line []byte) error {
+	for len(line) > 0 {
+		i := bytes.IndexByte(line, '$')
+		if i < 0 || i == len(line)-1 {
+			buf.Write(line)
+			break
+		}
+		buf.Write(line[:i])
+		line = line[i:]
+
+		switch line[1] {
+		case '$':
+			buf.WriteByte('$')
+			line = line[2:]
+			continue
+		case '{':
+		default:
+			buf.WriteByte('$')
+			line = line[1:]
+			continue
+		}
+
+		end := bytes.IndexByte(line, '}')
+		if end < 0 {
+			return fmt.Errorf("unterminated placeholder %s", bytes.TrimRight(line, "\r\n"))
+		}
+		if isGroup(line[2:end]) {
+			buf.Write(line[:end+1])
+			line = line[end+1:]
+			continue
+		}
+		value, err := expandPlaceholder(string(line[2:end]))
+		if err != nil {
+			return err
+		}
+		buf.WriteString(value)
+		line = line[end+1:]
+	}
+	return nil
+}
+
+// expandPlaceholder resolves the content of a ${...} placeholder.
+func expandPlaceholder(s string) (string, error) {
+	name, op, arg := s, "", ""
+	if i := strings.IndexAny(s, ":-"); i >= 0 {
+		name, op = s[:i], s[i:]
+		switch {
+		case strings.HasPrefix(op, ":-"), strings.HasPrefix(op, ":?"):
+			op, arg = op[:2], op[2:]
+		case strings.HasPrefix(op, "-"):
+			op, arg = op[:1], op[1:]
+		default:
+			op = "invalid"
+		}
+	}
+	if !isEnvName(name) || op == "invalid" {
+		return "", fmt.Errorf("invalid placeholder ${%s}", s)
+	}
+
+	value, ok := os.LookupEnv(name)
+	switch op {
+	case ":-":
+		if value == "" {
+			return arg, nil
+		}
+	case "-":
+		if !ok {
+			return arg, nil
+		}
+	case ":?":
+		if value == "" {
+			if arg == "" {
+				arg = "not set"
+			}
+			return "", fmt.Errorf("environment variable %s: %s", name, arg)
+		}
+	}
+	return value, nil
+}
+
+// isGroup reports the ${1} references to the groups of a pattern.
+func isGroup(name []byte) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("CI_TEST_HOST", "db.local")
+	os.Setenv("CI_TEST_EMPTY", "")
+	os.Unsetenv("CI_TEST_UNSET")
+	defer os.Unsetenv("CI_TEST_HOST")
+	defer os.Unsetenv("CI_TEST_EMPTY")
+
+	for in, want := range map[string]string{
+		"host: ${CI_TEST_HOST}":                     "host: db.local",
+		"port: ${CI_TEST_UNSET:-5432}":              "port: 5432",
+		"port: ${CI_TEST_EMPTY:-5432}":              "port: 5432",
+		"port: ${CI_TEST_EMPTY-5432}":               "port: ",
+		"url: ${CI_TEST_UNSET:-http://a-b:80/}":     "url: http://a-b:80/",
+		"password: pa$word$$ $CI_TEST_HOST $":       "password: pa$word$ $CI_TEST_HOST $",
+		"${CI_TEST_HOST}:${CI_TEST_UNSET:-3306}\n#": "db.local:3306\n#",
+		"host: ${CI_TEST_UNSET}":                    "host: ",
+		"host: ${CI_TEST_HOST:?host is required}":   "host: db.local",
+	} {
+		out, err := ExpandEnv([]byte(in))
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, string(out), in)
+	}
+
+	for in, want := range map[string]string{
+		"host: ${CI_TEST_UNSET:?host is required}": "environment variable CI_TEST_UNSET: host is required",
+		"host: ${CI_TEST_EMPTY:?}":                 "environment variable CI_TEST_EMPTY: not set",
+		"host: ${CI_TEST_HOST":                     "unterminated placeholder ${CI_TEST_HOST",
+		"host: ${1HOST}":                           "invalid placeholder ${1HOST}",
+		"host: ${CI_TEST_HOST:+x}":                 "invalid placeholder ${CI_TEST_HOST:+x}",
+	} {
+		_, err := ExpandEnv([]byte(in))
+		assert.EqualError(t, err, want, in)
+	}
+}
+
+func TestExpandEnvComments(t *testing.T) {
+	os.Setenv("CI_TEST_HOST", "db.local")
+	os.Unsetenv("CI_TEST_UNSET")
+	defer os.Unsetenv("CI_TEST_HOST")
+
+	for in, want := range map[string]string{
+		"  # ${CI_TEST_UNSET:?}\nhost: ${CI_TEST_HOST}": "  # ${CI_TEST_UNSET:?}\nhost: db.local",
+		"# rename = \"mem.${1}_mb\"\n":                  "# rename = \"mem.${1}_mb\"\n",
+		"rename = \"mem.${1}_${CI_TEST_HOST}\"":         "rename = \"mem.${1}_db.local\"",
+		"host: ${CI_TEST_HOST} # ${CI_TEST_HOST}":       "host: db.local # db.local",
+	} {
+		out, err := ExpandEnv([]byte(in))
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, string(out), in)
+	}
+}
@@ -0,0 +1,70 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ReplaceStrings replaces every string value reachable from v, which must be
+// a pointer, with the one returned by replace: the strings of the exported
+// fields of the structs, of the slices, arrays and maps, and the ones held by
+// interfaces, like the values of a decoded YAML document. The keys of the
+// maps are left alone.
+func ReplaceStrings(v interface{}, replace func(string) (string, error)) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("ReplaceStrings needs a non-nil pointer, got %T", v)
+	}
+	return replaceStrings(rv.Elem(), replace)
+}
+
+// replaceStrings replaces the strings of v, which must be settable.
+func replaceStrings(v reflect.Value, replace func(string) (string, error)) error {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := replace(v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return replaceStrings(v.Elem(), replace)
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		// The value held by an interface can't be set, a copy is.
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		if err := replaceStrings(elem, replace); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Field(i); f.CanSet() {
+				if err := replaceStrings(f, replace); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := replaceStrings(v.Index(i), replace); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			if err := replaceStrings(elem, replace); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+	}
+	return nil
+}
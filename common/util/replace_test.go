@@ -0,0 +1,61 @@
+package util
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaceStrings(t *testing.T) {
+	type inner struct {
+		Name string
+		Tags []string
+	}
+	type config struct {
+		Name     string
+		Port     int
+		Inner    inner
+		Pointer  *inner
+		Options  map[string]interface{}
+		Sections map[string]inner
+		hidden   string
+	}
+
+	c := config{
+		Name:    "a",
+		Port:    80,
+		Inner:   inner{Name: "b", Tags: []string{"c", "d"}},
+		Pointer: &inner{Name: "e"},
+		Options: map[string]interface{}{
+			"f": "g",
+			"h": []interface{}{"i", 1, map[interface{}]interface{}{"j": "k"}},
+			"l": nil,
+		},
+		Sections: map[string]inner{"m": {Name: "n"}},
+		hidden:   "o",
+	}
+	assert.NoError(t, ReplaceStrings(&c, func(s string) (string, error) {
+		return strings.ToUpper(s), nil
+	}))
+	assert.Equal(t, config{
+		Name:    "A",
+		Port:    80,
+		Inner:   inner{Name: "B", Tags: []string{"C", "D"}},
+		Pointer: &inner{Name: "E"},
+		Options: map[string]interface{}{
+			"f": "G",
+			"h": []interface{}{"I", 1, map[interface{}]interface{}{"j": "K"}},
+			"l": nil,
+		},
+		Sections: map[string]inner{"m": {Name: "N"}},
+		hidden:   "o",
+	}, c)
+
+	err := ReplaceStrings(&c, func(s string) (string, error) {
+		return "", errors.New("failed")
+	})
+	assert.EqualError(t, err, "failed")
+	assert.Error(t, ReplaceStrings(c, nil))
+}
@@ -3,6 +3,7 @@ package util
 import (
 	"hash/fnv"
 	"math"
+	"strings"
 )
 
 // Cast rounds num to integer.
@@ -41,3 +42,21 @@ func Hash(s string) uint32 {
 	_, _ = h.Write([]byte(s))
 	return h.Sum32()
 }
+
+// GetValue looks up the dot-separated path in the nested maps decoded from JSON,
+// e.g. GetValue(stats, "jvm.mem.heap_used_in_bytes").
+func GetValue(data map[string]interface{}, path string) (interface{}, bool) {
+	keys := strings.Split(path, ".")
+	var current interface{} = data
+	for _, key := range keys {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
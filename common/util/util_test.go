@@ -85,3 +85,30 @@ func TestHash(t *testing.T) {
 		}
 	}
 }
+
+func TestGetValue(t *testing.T) {
+	data := map[string]interface{}{
+		"jvm": map[string]interface{}{
+			"mem": map[string]interface{}{
+				"heap_used_in_bytes": 1024.0,
+			},
+		},
+		"name": "node-1",
+	}
+
+	for _, c := range []struct {
+		in    string
+		want  interface{}
+		found bool
+	}{
+		{"jvm.mem.heap_used_in_bytes", 1024.0, true},
+		{"name", "node-1", true},
+		{"jvm.mem.missing", nil, false},
+		{"name.first", nil, false},
+	} {
+		out, found := GetValue(data, c.in)
+		if out != c.want || found != c.found {
+			t.Errorf("GetValue(%s) == (%v, %t), want (%v, %t)", c.in, out, found, c.want, c.found)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Package watchdog tells whether the loops of the agent, like the collector
+// or the Forwarder, are alive. Every loop beats its Heartbeat when it makes
+// progress, a loop whose last beat is older than its timeout is stuck.
+package watchdog
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MinTimeout is the smallest timeout of a component, the loops running every
+// few seconds may still be slowed down by a busy host.
+const MinTimeout = time.Minute
+
+// Timeout returns the timeout of a loop running every interval, it's stuck
+// once it missed 3 runs.
+func Timeout(interval time.Duration) time.Duration {
+	if timeout := 3 * interval; timeout > MinTimeout {
+		return timeout
+	}
+	return MinTimeout
+}
+
+// Heartbeat is the time of the last activity of a loop, it's safe for
+// concurrent use.
+type Heartbeat struct {
+	// last is the UnixNano time of the last beat.
+	last int64
+}
+
+// Beat records an activity now.
+func (h *Heartbeat) Beat() {
+	atomic.StoreInt64(&h.last, time.Now().UnixNano())
+}
+
+// Last returns the time of the last beat, the zero time until the first one.
+func (h *Heartbeat) Last() time.Time {
+	ns := atomic.LoadInt64(&h.last)
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// The states of a component.
+const (
+	// Starting is the state of a component which didn't beat yet.
+	Starting = "starting"
+	OK       = "ok"
+	// Stuck is the state of a component which didn't beat within its
+	// timeout, or didn't start within it.
+	Stuck = "stuck"
+)
+
+// ComponentStatus is the state of a component, reported by Check.
+type ComponentStatus struct {
+	Name         string     `json:"name"`
+	Status       string     `json:"status"`
+	LastActivity *time.Time `json:"last_activity,omitempty"`
+	// Timeout is the timeout of the component, in seconds.
+	Timeout float64 `json:"timeout"`
+}
+
+// Report is the state of the components of a Watchdog.
+type Report struct {
+	// Healthy is unset when a component is stuck.
+	Healthy bool `json:"healthy"`
+	// Ready is set once all the components started and none is stuck.
+	Ready      bool              `json:"ready"`
+	Components []ComponentStatus `json:"components"`
+}
+
+type component struct {
+	name       string
+	heartbeat  *Heartbeat
+	timeout    time.Duration
+	registered time.Time
+}
+
+// Watchdog checks the heartbeats of the components of the agent.
+type Watchdog struct {
+	mu         sync.Mutex
+	components []component
+}
+
+// New creates a new instance of Watchdog.
+func New() *Watchdog {
+	return &Watchdog{}
+}
+
+// Register adds a component beating h, which is stuck once it didn't beat
+// for timeout.
+func (w *Watchdog) Register(name string, h *Heartbeat, timeout time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.components = append(w.components, component{
+		name:       name,
+		heartbeat:  h,
+		timeout:    timeout,
+		registered: time.Now(),
+	})
+}
+
+// Check returns the state of the components at now, in the order of their
+// registration.
+func (w *Watchdog) Check(now time.Time) Report {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	report := Report{
+		Healthy:    true,
+		Ready:      true,
+		Components: make([]ComponentStatus, 0, len(w.components)),
+	}
+	for _, c := range w.components {
+		status := ComponentStatus{Name: c.name, Status: OK, Timeout: c.timeout.Seconds()}
+		last := c.heartbeat.Last()
+		switch {
+		case last.IsZero() && now.Sub(c.registered) <= c.timeout:
+			status.Status = Starting
+			report.Ready = false
+		case last.IsZero():
+			status.Status = Stuck
+		case now.Sub(last) > c.timeout:
+			status.Status = Stuck
+		}
+		if !last.IsZero() {
+			status.LastActivity = &last
+		}
+		if status.Status == Stuck {
+			report.Healthy = false
+			report.Ready = false
+		}
+		report.Components = append(report.Components, status)
+	}
+	return report
+}
@@ -0,0 +1,49 @@
+package watchdog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeout(t *testing.T) {
+	assert.Equal(t, MinTimeout, Timeout(10*time.Second))
+	assert.Equal(t, 15*time.Minute, Timeout(5*time.Minute))
+}
+
+func TestCheck(t *testing.T) {
+	w := New()
+	var collector, forwarder Heartbeat
+	w.Register("collector", &collector, time.Minute)
+	w.Register("forwarder", &forwarder, time.Minute)
+	now := time.Now()
+
+	report := w.Check(now)
+	assert.True(t, report.Healthy)
+	assert.False(t, report.Ready)
+	assert.Equal(t, Starting, report.Components[0].Status)
+	assert.Nil(t, report.Components[0].LastActivity)
+
+	collector.Beat()
+	forwarder.Beat()
+	report = w.Check(time.Now())
+	assert.True(t, report.Healthy)
+	assert.True(t, report.Ready)
+	assert.Equal(t, []string{OK, OK}, []string{report.Components[0].Status, report.Components[1].Status})
+
+	// The collector stopped beating.
+	forwarder.last = now.Add(2 * time.Minute).UnixNano()
+	report = w.Check(now.Add(2 * time.Minute))
+	assert.False(t, report.Healthy)
+	assert.False(t, report.Ready)
+	assert.Equal(t, Stuck, report.Components[0].Status)
+	assert.Equal(t, OK, report.Components[1].Status)
+	assert.Equal(t, float64(60), report.Components[0].Timeout)
+
+	// A component which never started is stuck after its timeout.
+	var statsd Heartbeat
+	w.Register("statsd", &statsd, time.Minute)
+	report = w.Check(time.Now().Add(2 * time.Minute))
+	assert.Equal(t, Stuck, report.Components[2].Status)
+}
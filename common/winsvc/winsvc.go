@@ -0,0 +1,65 @@
+// Package winsvc runs the agent as a Windows service: it installs and
+// removes the service with sc.exe, answers the stop and shutdown requests of
+// the service control manager and reports to the Application event log. It's
+// only supported on Windows, the functions fail on the other systems.
+package winsvc
+
+import "strings"
+
+// The Windows event log types.
+const (
+	Error       = 1
+	Warning     = 2
+	Information = 4
+)
+
+// Config is the service installed by Install.
+type Config struct {
+	Name        string
+	DisplayName string
+	Description string
+	// Executable is the binary of the service, started with Args.
+	Executable string
+	Args       []string
+}
+
+// commandLine returns the command line of the service, the arguments quoted
+// the way CommandLineToArgvW splits them.
+func commandLine(executable string, args []string) string {
+	quoted := make([]string, 0, len(args)+1)
+	for _, arg := range append([]string{executable}, args...) {
+		quoted = append(quoted, quoteArg(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"") {
+		return arg
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for _, c := range arg {
+		switch c {
+		case '\\':
+			slashes++
+		case '"':
+			// The backslashes before a quote are escaped, and the quote.
+			b.WriteString(strings.Repeat(`\`, slashes+1))
+			slashes = 0
+		default:
+			slashes = 0
+		}
+		b.WriteRune(c)
+	}
+	// As well as the ones before the closing quote.
+	b.WriteString(strings.Repeat(`\`, slashes))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// eventLogKey is the registry key of the sources of the Application event
+// log.
+const eventLogKey = `HKLM\SYSTEM\CurrentControlSet\Services\EventLog\Application\`
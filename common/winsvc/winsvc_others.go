@@ -0,0 +1,41 @@
+//go:build !windows
+// +build !windows
+
+package winsvc
+
+import "fmt"
+
+var errUnsupported = fmt.Errorf("Windows services are only supported on Windows")
+
+// Run runs the service name until run returns, see the Windows version.
+func Run(name string, run func(stop <-chan struct{}) error) error {
+	return errUnsupported
+}
+
+// Install creates the service of conf.
+func Install(conf Config) error {
+	return errUnsupported
+}
+
+// Remove stops and deletes the service name.
+func Remove(name string) error {
+	return errUnsupported
+}
+
+// EventLog reports to the Application event log.
+type EventLog struct{}
+
+// OpenEventLog opens the event log of source.
+func OpenEventLog(source string) (*EventLog, error) {
+	return nil, errUnsupported
+}
+
+// Report writes msg to the event log.
+func (l *EventLog) Report(eventType uint16, id uint32, msg string) error {
+	return errUnsupported
+}
+
+// Close closes the event log.
+func (l *EventLog) Close() error {
+	return nil
+}
@@ -0,0 +1,15 @@
+package winsvc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandLine(t *testing.T) {
+	assert.Equal(t,
+		`"C:\Program Files\Cloudinsight\cloudinsight-agent.exe" -config "C:\Program Files\Cloudinsight\agent.conf" service`,
+		commandLine(`C:\Program Files\Cloudinsight\cloudinsight-agent.exe`, []string{"-config", `C:\Program Files\Cloudinsight\agent.conf`, "service"}))
+	assert.Equal(t, `agent.exe "" "a\"b" "C:\my dir\\" C:\dir\`, commandLine("agent.exe", []string{"", `a"b`, `C:\my dir\`, `C:\dir\`}))
+	assert.Equal(t, `agent.exe "a\\\"b"`, commandLine("agent.exe", []string{`a\"b`}))
+}
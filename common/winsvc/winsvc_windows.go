@@ -0,0 +1,233 @@
+package winsvc
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+var (
+	advapi32                          = syscall.NewLazyDLL("advapi32.dll")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+	procRegisterEventSourceW          = advapi32.NewProc("RegisterEventSourceW")
+	procDeregisterEventSource         = advapi32.NewProc("DeregisterEventSource")
+	procReportEventW                  = advapi32.NewProc("ReportEventW")
+)
+
+const (
+	serviceWin32OwnProcess = 0x10
+
+	serviceStopped      = 1
+	serviceStartPending = 2
+	serviceStopPending  = 3
+	serviceRunning      = 4
+
+	serviceAcceptStop     = 0x1
+	serviceAcceptShutdown = 0x4
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+
+	errorCallNotImplemented    = 120
+	errorServiceSpecificError  = 1066
+	errorFailedServiceDispatch = 1063
+
+	// stopWaitHint is the time the agent may take to stop, it flushes the
+	// cached metrics.
+	stopWaitHint = 30 * time.Second
+)
+
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+type serviceStatus struct {
+	serviceType             uint32
+	currentState            uint32
+	controlsAccepted        uint32
+	win32ExitCode           uint32
+	serviceSpecificExitCode uint32
+	checkPoint              uint32
+	waitHint                uint32
+}
+
+// service is the service run by Run, the callbacks of the service control
+// manager can't carry a context.
+var service struct {
+	name   *uint16
+	run    func(stop <-chan struct{}) error
+	stop   chan struct{}
+	once   sync.Once
+	handle uintptr
+	err    error
+}
+
+// Run runs the service name until run returns. It's called with a channel
+// closed when the service control manager stops the service or the system
+// shuts down. It fails when the process wasn't started by the service
+// control manager.
+func Run(name string, run func(stop <-chan struct{}) error) error {
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	service.name = namePtr
+	service.run = run
+	service.stop = make(chan struct{})
+
+	table := []serviceTableEntry{
+		{name: namePtr, proc: syscall.NewCallback(serviceMain)},
+		{},
+	}
+	r, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0])))
+	if r == 0 {
+		if errno, ok := err.(syscall.Errno); ok && errno == errorFailedServiceDispatch {
+			return fmt.Errorf("not started by the service control manager, run the agent without the service subcommand")
+		}
+		return err
+	}
+	return service.err
+}
+
+func serviceMain(argc uint32, argv uintptr) uintptr {
+	h, _, err := procRegisterServiceCtrlHandlerExW.Call(
+		uintptr(unsafe.Pointer(service.name)), syscall.NewCallback(controlHandler), 0)
+	if h == 0 {
+		service.err = err
+		return 0
+	}
+	service.handle = h
+
+	setStatus(serviceStartPending, 0, 0)
+	setStatus(serviceRunning, serviceAcceptStop|serviceAcceptShutdown, 0)
+	service.err = service.run(service.stop)
+
+	exitCode := uint32(0)
+	if service.err != nil {
+		exitCode = errorServiceSpecificError
+	}
+	setStatus(serviceStopped, 0, exitCode)
+	return 0
+}
+
+func controlHandler(control, eventType uint32, eventData, context uintptr) uintptr {
+	switch control {
+	case serviceControlStop, serviceControlShutdown:
+		setStatus(serviceStopPending, 0, 0)
+		service.once.Do(func() { close(service.stop) })
+		return 0
+	case serviceControlInterrogate:
+		return 0
+	}
+	return errorCallNotImplemented
+}
+
+func setStatus(state, accepted, exitCode uint32) {
+	status := serviceStatus{
+		serviceType:      serviceWin32OwnProcess,
+		currentState:     state,
+		controlsAccepted: accepted,
+		win32ExitCode:    exitCode,
+	}
+	if exitCode == errorServiceSpecificError {
+		status.serviceSpecificExitCode = 1
+	}
+	if state == serviceStartPending || state == serviceStopPending {
+		status.waitHint = uint32(stopWaitHint / time.Millisecond)
+	}
+	_, _, _ = procSetServiceStatus.Call(service.handle, uintptr(unsafe.Pointer(&status)))
+}
+
+// Install creates the service of conf, started automatically at boot and
+// restarted when it fails, and registers it as a source of the Application
+// event log.
+func Install(conf Config) error {
+	if _, err := util.RunCommand(30*time.Second, "sc.exe", "create", conf.Name,
+		"binPath=", commandLine(conf.Executable, conf.Args),
+		"start=", "auto",
+		"DisplayName=", conf.DisplayName); err != nil {
+		return err
+	}
+	if conf.Description != "" {
+		if _, err := util.RunCommand(30*time.Second, "sc.exe", "description", conf.Name, conf.Description); err != nil {
+			return err
+		}
+	}
+	if _, err := util.RunCommand(30*time.Second, "sc.exe", "failure", conf.Name,
+		"reset=", "86400", "actions=", "restart/60000/restart/60000//"); err != nil {
+		return err
+	}
+
+	// EventCreate.exe holds the messages of the IDs 1 to 1000, the ones used
+	// by the EventLog.
+	key := eventLogKey + conf.Name
+	if _, err := util.RunCommand(30*time.Second, "reg.exe", "add", key,
+		"/v", "EventMessageFile", "/t", "REG_EXPAND_SZ", "/d", `%SystemRoot%\System32\EventCreate.exe`, "/f"); err != nil {
+		return err
+	}
+	_, err := util.RunCommand(30*time.Second, "reg.exe", "add", key,
+		"/v", "TypesSupported", "/t", "REG_DWORD", "/d", "7", "/f")
+	return err
+}
+
+// Remove stops and deletes the service name, and its event log source.
+func Remove(name string) error {
+	// It fails when the service isn't running.
+	_, _ = util.RunCommand(30*time.Second, "sc.exe", "stop", name)
+	if _, err := util.RunCommand(30*time.Second, "sc.exe", "delete", name); err != nil {
+		return err
+	}
+	_, _ = util.RunCommand(30*time.Second, "reg.exe", "delete", eventLogKey+name, "/f")
+	return nil
+}
+
+// EventLog reports to the Application event log.
+type EventLog struct {
+	handle uintptr
+}
+
+// OpenEventLog opens the event log of source, registered by Install.
+func OpenEventLog(source string) (*EventLog, error) {
+	sourcePtr, err := syscall.UTF16PtrFromString(source)
+	if err != nil {
+		return nil, err
+	}
+	h, _, err := procRegisterEventSourceW.Call(0, uintptr(unsafe.Pointer(sourcePtr)))
+	if h == 0 {
+		return nil, err
+	}
+	return &EventLog{handle: h}, nil
+}
+
+// Report writes msg to the event log with the event type and id, which must
+// be between 1 and 1000.
+func (l *EventLog) Report(eventType uint16, id uint32, msg string) error {
+	msgPtr, err := syscall.UTF16PtrFromString(msg)
+	if err != nil {
+		return err
+	}
+	strs := []*uint16{msgPtr}
+	r, _, err := procReportEventW.Call(l.handle, uintptr(eventType), 0, uintptr(id), 0,
+		1, 0, uintptr(unsafe.Pointer(&strs[0])), 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Close closes the event log.
+func (l *EventLog) Close() error {
+	r, _, err := procDeregisterEventSource.Call(l.handle)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+// Package connections periodically samples the TCP and UDP connections of
+// the host and posts their number between the addresses, by process, so that
+// Cloudinsight maps the dependencies between the services.
+package connections
+
+import (
+	"net"
+	"runtime"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/api"
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	psnet "github.com/shirou/gopsutil/net"
+	"github.com/shirou/gopsutil/process"
+)
+
+// MaxConnections bounds the number of aggregated connections of a payload,
+// the most numerous ones are kept.
+const MaxConnections = 1000
+
+// The directions of the connections, from the point of view of the host.
+const (
+	Incoming = "incoming"
+	Outgoing = "outgoing"
+)
+
+// Payload is the network connections payload.
+type Payload struct {
+	Hostname    string       `json:"hostname"`
+	Timestamp   int64        `json:"timestamp"`
+	Connections []Connection `json:"connections"`
+}
+
+// Connection counts the connections of a process between two addresses.
+// The ephemeral port of a side is left out: the local one of the outgoing
+// connections, and the remote one of the incoming connections.
+type Connection struct {
+	Protocol  string `json:"protocol"`
+	Direction string `json:"direction"`
+	Local     string `json:"local"`
+	Remote    string `json:"remote"`
+	// PID is 0 when the process owning the socket isn't visible to the
+	// agent, e.g. the ones of the other users when it doesn't run as root.
+	PID     int32  `json:"pid"`
+	Process string `json:"process,omitempty"`
+	Count   int    `json:"count"`
+}
+
+// Aggregate counts the established TCP connections and the connected UDP
+// sockets of conns. A connection is incoming when its local port is one the
+// host listens to.
+func Aggregate(conns []psnet.ConnectionStat) []Connection {
+	listening := make(map[string]bool)
+	for _, c := range conns {
+		if isListening(c) {
+			listening[protocol(c)+"/"+strconv.Itoa(int(c.Laddr.Port))] = true
+		}
+	}
+
+	counts := make(map[Connection]int)
+	for _, c := range conns {
+		if isListening(c) || c.Raddr.IP == "" || c.Raddr.Port == 0 {
+			continue
+		}
+		proto := protocol(c)
+		if proto == "tcp" && c.Status != "ESTABLISHED" {
+			continue
+		}
+
+		key := Connection{Protocol: proto, PID: c.Pid}
+		if listening[proto+"/"+strconv.Itoa(int(c.Laddr.Port))] {
+			key.Direction = Incoming
+			key.Local = hostPort(c.Laddr)
+			key.Remote = c.Raddr.IP
+		} else {
+			key.Direction = Outgoing
+			key.Local = c.Laddr.IP
+			key.Remote = hostPort(c.Raddr)
+		}
+		counts[key]++
+	}
+
+	aggregated := make([]Connection, 0, len(counts))
+	for key, count := range counts {
+		key.Count = count
+		aggregated = append(aggregated, key)
+	}
+	sort.Slice(aggregated, func(i, j int) bool {
+		a, b := aggregated[i], aggregated[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Local != b.Local {
+			return a.Local < b.Local
+		}
+		if a.Remote != b.Remote {
+			return a.Remote < b.Remote
+		}
+		return a.PID < b.PID
+	})
+	if len(aggregated) > MaxConnections {
+		aggregated = aggregated[:MaxConnections]
+	}
+	return aggregated
+}
+
+// isListening reports the listening TCP sockets and the UDP sockets without
+// a remote address.
+func isListening(c psnet.ConnectionStat) bool {
+	if protocol(c) == "tcp" {
+		return c.Status == "LISTEN"
+	}
+	return c.Raddr.Port == 0
+}
+
+func protocol(c psnet.ConnectionStat) string {
+	if c.Type == syscall.SOCK_DGRAM {
+		return "udp"
+	}
+	return "tcp"
+}
+
+func hostPort(a psnet.Addr) string {
+	return net.JoinHostPort(a.IP, strconv.Itoa(int(a.Port)))
+}
+
+// NewCollector creates a new instance of Collector.
+func NewCollector(conf *config.Config) *Collector {
+	a := api.NewAPI(conf.GetForwarderAddrWithScheme(), conf.GlobalConfig.LicenseKey, 10*time.Second)
+	a.CompressionLevel = conf.GlobalConfig.CompressionLevel
+	a.Format = conf.GlobalConfig.PayloadFormat
+	return &Collector{conf: conf, api: a}
+}
+
+// Collector posts the network connections to the Forwarder, with the
+// license key of the main pipeline.
+type Collector struct {
+	conf *config.Config
+	api  *api.API
+}
+
+// Post samples the connections and posts them.
+func (c *Collector) Post() error {
+	conns, err := psnet.Connections("inet")
+	if err != nil {
+		return err
+	}
+	aggregated := Aggregate(conns)
+	names := make(map[int32]string)
+	for i, conn := range aggregated {
+		if conn.PID == 0 {
+			continue
+		}
+		name, ok := names[conn.PID]
+		if !ok {
+			if p, err := process.NewProcess(conn.PID); err == nil {
+				name, _ = p.Name()
+			}
+			names[conn.PID] = name
+		}
+		aggregated[i].Process = name
+	}
+
+	return c.api.SubmitConnections(&Payload{
+		Hostname:    c.conf.GetHostname(),
+		Timestamp:   time.Now().Unix(),
+		Connections: aggregated,
+	})
+}
+
+// Run posts the connections every network_connections_interval until
+// shutdown is closed.
+func (c *Collector) Run(shutdown chan struct{}) {
+	if runtime.GOOS == "windows" {
+		log.Errorf("network_connections isn't supported on Windows")
+		return
+	}
+
+	ticker := time.NewTicker(c.conf.GetNetworkConnectionsInterval())
+	defer ticker.Stop()
+	for {
+		if err := c.Post(); err != nil {
+			log.Warnf("Failed to post the network connections: %s", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-shutdown:
+			return
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package connections
+
+import (
+	"syscall"
+	"testing"
+
+	psnet "github.com/shirou/gopsutil/net"
+	"github.com/stretchr/testify/assert"
+)
+
+func tcp(laddr psnet.Addr, raddr psnet.Addr, status string, pid int32) psnet.ConnectionStat {
+	return psnet.ConnectionStat{Type: syscall.SOCK_STREAM, Laddr: laddr, Raddr: raddr, Status: status, Pid: pid}
+}
+
+func TestAggregate(t *testing.T) {
+	web := psnet.Addr{IP: "10.0.0.1", Port: 80}
+	conns := []psnet.ConnectionStat{
+		tcp(psnet.Addr{IP: "0.0.0.0", Port: 80}, psnet.Addr{}, "LISTEN", 100),
+		// Two clients of the web server, one of them twice.
+		tcp(web, psnet.Addr{IP: "10.0.0.7", Port: 50001}, "ESTABLISHED", 100),
+		tcp(web, psnet.Addr{IP: "10.0.0.7", Port: 50002}, "ESTABLISHED", 100),
+		tcp(web, psnet.Addr{IP: "10.0.0.8", Port: 40001}, "ESTABLISHED", 100),
+		tcp(web, psnet.Addr{IP: "10.0.0.9", Port: 40002}, "TIME_WAIT", 0),
+		// The web server queries the database.
+		tcp(psnet.Addr{IP: "10.0.0.1", Port: 41000}, psnet.Addr{IP: "10.0.0.2", Port: 5432}, "ESTABLISHED", 100),
+		tcp(psnet.Addr{IP: "10.0.0.1", Port: 41001}, psnet.Addr{IP: "10.0.0.2", Port: 5432}, "ESTABLISHED", 100),
+		// A Statsd client, and the bound Statsd server.
+		{Type: syscall.SOCK_DGRAM, Laddr: psnet.Addr{IP: "127.0.0.1", Port: 39000}, Raddr: psnet.Addr{IP: "127.0.0.1", Port: 8251}, Pid: 100},
+		{Type: syscall.SOCK_DGRAM, Laddr: psnet.Addr{IP: "127.0.0.1", Port: 8251}, Pid: 200},
+	}
+
+	assert.Equal(t, []Connection{
+		{Protocol: "tcp", Direction: Outgoing, Local: "10.0.0.1", Remote: "10.0.0.2:5432", PID: 100, Count: 2},
+		{Protocol: "tcp", Direction: Incoming, Local: "10.0.0.1:80", Remote: "10.0.0.7", PID: 100, Count: 2},
+		{Protocol: "tcp", Direction: Incoming, Local: "10.0.0.1:80", Remote: "10.0.0.8", PID: 100, Count: 1},
+		{Protocol: "udp", Direction: Outgoing, Local: "127.0.0.1", Remote: "127.0.0.1:8251", PID: 100, Count: 1},
+	}, Aggregate(conns))
+}
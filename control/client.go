@@ -0,0 +1,116 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+)
+
+// Client queries the control API of a running agent.
+type Client struct {
+	client *http.Client
+	url    string
+	token  string
+}
+
+// NewClient creates a new instance of Client, it goes through the socket
+// when control_socket is set, the port with the token otherwise.
+func NewClient(conf *config.Config) (*Client, error) {
+	transport := &http.Transport{Proxy: nil}
+	c := &Client{client: &http.Client{Transport: transport, Timeout: RequestTimeout}}
+
+	switch {
+	case conf.GlobalConfig.ControlSocket != "":
+		path := conf.GlobalConfig.ControlSocket
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+		c.url = "http://agent"
+	case conf.GlobalConfig.ControlPort != 0:
+		content, err := ioutil.ReadFile(conf.GetControlTokenFile())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the control token: %s", err)
+		}
+		c.token = strings.TrimSpace(string(content))
+		c.url = "http://" + conf.GetControlAddr()
+	default:
+		return nil, fmt.Errorf("the control API is disabled, set control_socket or control_port")
+	}
+	return c, nil
+}
+
+// WithTimeout returns a copy of c whose requests time out after timeout, e.g.
+// for the profiles lasting longer than RequestTimeout.
+func (c *Client) WithTimeout(timeout time.Duration) *Client {
+	client := *c.client
+	client.Timeout = timeout
+	return &Client{client: &client, url: c.url, token: c.token}
+}
+
+// Do sends a request to the endpoint at path and decodes the response into
+// v, unless v is nil.
+func (c *Client) Do(method, path string, body io.Reader, v interface{}) error {
+	resp, err := c.send(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Download copies the response of the GET request to path to w, e.g. a
+// profile of /debug/pprof.
+func (c *Client) Download(path string, w io.Writer) error {
+	resp, err := c.send("GET", path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (c *Client) send(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach the agent, is it running? %s", err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Error == "" {
+			e.Error = resp.Status
+		}
+		return nil, fmt.Errorf("%s %s failed: %s", method, path, e.Error)
+	}
+	return resp, nil
+}
+
+// Get is a shortcut for Do with the GET method.
+func (c *Client) Get(path string, v interface{}) error {
+	return c.Do("GET", path, nil, v)
+}
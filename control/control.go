@@ -0,0 +1,337 @@
+// Package control serves the local control API of the agent, the foundation
+// of the subcommands and the tooling inspecting or driving a running agent.
+// It's served on a unix socket, which only the user running the agent may
+// use, and on a port of 127.0.0.1 to the clients sending the token of the
+// token file in an "Authorization: Bearer <token>" header. /health and
+// /ready don't need the token, for the probes.
+//
+// The endpoints answer in JSON:
+//
+//	GET /status       the version, hostname and uptime of the agent
+//	GET /checks       the status of the checks
+//	GET /queue        the state of the queue of the Forwarder
+//	POST /reload      reloads the configuration
+//	GET|PUT /log/level the log level, {"level": "debug"}
+//	GET /health       200 unless a loop of the agent is stuck, 503 otherwise
+//	GET /ready        200 once all the loops started and none is stuck
+//	GET /debug/pprof/ the runtime profiles, see net/http/pprof, served when
+//	                  enable_profiling is set
+//
+// The responses are written within RequestTimeout, plus the requested
+// duration for the CPU profiles and the traces.
+package control
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/agent"
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/privileges"
+	"github.com/cloudinsight/cloudinsight-agent/common/watchdog"
+	"github.com/cloudinsight/cloudinsight-agent/forwarder"
+)
+
+// RequestTimeout bounds the requests to the control API.
+const RequestTimeout = 10 * time.Second
+
+// Status is served by /status.
+type Status struct {
+	Version    string  `json:"version"`
+	Hostname   string  `json:"hostname"`
+	PID        int     `json:"pid"`
+	Uptime     float64 `json:"uptime"`
+	Goroutines int     `json:"goroutines"`
+	Checks     int     `json:"checks"`
+	LogLevel   string  `json:"log_level"`
+	// LastFlush is the last flush of the metrics of the checks, nil until
+	// the first one.
+	LastFlush *time.Time `json:"last_flush,omitempty"`
+}
+
+// LogLevel is served and accepted by /log/level.
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+// NewServer creates a new instance of Server, reload is called by /reload
+// and wd reports the health of the agent.
+func NewServer(conf *config.Config, ag *agent.Agent, fw *forwarder.Forwarder, wd *watchdog.Watchdog, reload func()) *Server {
+	return &Server{
+		conf:      conf,
+		agent:     ag,
+		forwarder: fw,
+		watchdog:  wd,
+		reload:    reload,
+		start:     time.Now(),
+	}
+}
+
+// Server is the HTTP server of the control API.
+type Server struct {
+	conf      *config.Config
+	agent     *agent.Agent
+	forwarder *forwarder.Forwarder
+	watchdog  *watchdog.Watchdog
+	reload    func()
+	start     time.Time
+}
+
+// publicPaths are served without the token.
+var publicPaths = map[string]bool{"/health": true, "/ready": true}
+
+// Handler returns the handler of the endpoints, without authentication.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.get(func() interface{} {
+		status := Status{
+			Version:    config.VERSION,
+			Hostname:   s.conf.GetHostname(),
+			PID:        os.Getpid(),
+			Uptime:     time.Since(s.start).Seconds(),
+			Goroutines: runtime.NumGoroutine(),
+			Checks:     len(s.conf.Plugins),
+			LogLevel:   log.GetLevel(),
+		}
+		if t := s.agent.LastFlush(); !t.IsZero() {
+			status.LastFlush = &t
+		}
+		return status
+	}))
+	mux.HandleFunc("/checks", s.get(func() interface{} {
+		return s.agent.Checks()
+	}))
+	mux.HandleFunc("/queue", s.get(func() interface{} {
+		return s.forwarder.Stats()
+	}))
+	mux.HandleFunc("/health", s.probe(func(r watchdog.Report) bool { return r.Healthy }))
+	mux.HandleFunc("/ready", s.probe(func(r watchdog.Report) bool { return r.Ready }))
+	mux.HandleFunc("/reload", s.handleReload)
+	mux.HandleFunc("/log/level", s.handleLogLevel)
+	if s.conf.GlobalConfig.EnableProfiling {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return mux
+}
+
+// get serves the value returned by f to the GET requests.
+func (s *Server) get(f func() interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeError(w, http.StatusMethodNotAllowed, "method %s is not allowed", r.Method)
+			return
+		}
+		writeJSON(w, http.StatusOK, f())
+	}
+}
+
+// probe serves the report of the watchdog, with a 503 status unless ok
+// accepts it.
+func (s *Server) probe(ok func(watchdog.Report) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			writeError(w, http.StatusMethodNotAllowed, "method %s is not allowed", r.Method)
+			return
+		}
+		report := s.watchdog.Check(time.Now())
+		code := http.StatusOK
+		if !ok(report) {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, report)
+	}
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "method %s is not allowed", r.Method)
+		return
+	}
+	log.Infof("Reload requested by the control API")
+	s.reload()
+	writeJSON(w, http.StatusAccepted, struct{}{})
+}
+
+// handleLogLevel changes the level until the next reload, which restores
+// the log_level option.
+func (s *Server) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		var req LogLevel
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<10)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request: %s", err)
+			return
+		}
+		if err := log.SetLevel(req.Level); err != nil {
+			writeError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+		log.Infof("Log level set to %s by the control API", req.Level)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method %s is not allowed", r.Method)
+		return
+	}
+	writeJSON(w, http.StatusOK, LogLevel{Level: log.GetLevel()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debugf("Failed to write the control API response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	writeJSON(w, code, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// authorize rejects the requests without the token, but the ones of
+// publicPaths.
+func authorize(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			h.ServeHTTP(w, r)
+			return
+		}
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// writeTimeout returns the time the response to r is given to be written.
+// The CPU profiles and the traces last the number of seconds of their
+// request, 30 by default like net/http/pprof.
+func writeTimeout(r *http.Request) time.Duration {
+	switch r.URL.Path {
+	case "/debug/pprof/profile", "/debug/pprof/trace":
+	default:
+		return RequestTimeout
+	}
+	seconds, err := strconv.ParseFloat(r.FormValue("seconds"), 64)
+	if err != nil || seconds <= 0 {
+		seconds = 30
+	}
+	return RequestTimeout + time.Duration(seconds*float64(time.Second))
+}
+
+// withWriteTimeout sets the write deadline of every response to its
+// writeTimeout, instead of a WriteTimeout of the server which would cut the
+// long profiles, and which net/http/pprof refuses to exceed.
+func withWriteTimeout(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Now().Add(writeTimeout(r))); err != nil {
+			log.Debugf("Failed to set the write deadline of the control API response: %s", err)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// LoadToken reads the token of path, it's created when the file doesn't
+// exist.
+func LoadToken(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err == nil {
+		token := strings.TrimSpace(string(content))
+		if token == "" {
+			return "", fmt.Errorf("empty control token file %s", path)
+		}
+		return token, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err = io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err = ioutil.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Run serves the control API until shutdown is closed.
+func (s *Server) Run(shutdown chan struct{}) error {
+	var servers []*http.Server
+	serve := func(l net.Listener, h http.Handler, name string) {
+		srv := &http.Server{
+			Handler:        withWriteTimeout(h),
+			ReadTimeout:    RequestTimeout,
+			MaxHeaderBytes: 1 << 20,
+		}
+		servers = append(servers, srv)
+		log.Infoln("Control API listening on:", name)
+		go func() {
+			if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+				log.Errorf("Control API stopped: %s", err)
+			}
+		}()
+	}
+	defer func() {
+		for _, srv := range servers {
+			_ = srv.Close()
+		}
+	}()
+
+	if path := s.conf.GlobalConfig.ControlSocket; path != "" {
+		// The socket of a previous run is left behind when it's killed.
+		_ = os.Remove(path)
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return err
+		}
+		if err = os.Chmod(path, 0600); err != nil {
+			l.Close()
+			return err
+		}
+		serve(l, s.Handler(), path)
+	}
+
+	if s.conf.GlobalConfig.ControlPort != 0 {
+		token, err := LoadToken(s.conf.GetControlTokenFile())
+		if err != nil {
+			return fmt.Errorf("failed to load the control token: %s", err)
+		}
+		addr := s.conf.GetControlAddr()
+		l, err := privileges.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		serve(l, authorize(token, s.Handler()), addr)
+	}
+
+	<-shutdown
+	log.Infof("Control API thread exit")
+	return nil
+}
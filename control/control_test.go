@@ -0,0 +1,160 @@
+package control
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/agent"
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/watchdog"
+	"github.com/cloudinsight/cloudinsight-agent/forwarder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func request(t *testing.T, h http.Handler, method, path, body, token string) (int, map[string]interface{}) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	var v interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &v))
+	m, _ := v.(map[string]interface{})
+	if list, ok := v.([]interface{}); ok {
+		m = map[string]interface{}{"list": list}
+	}
+	return w.Code, m
+}
+
+func TestHandler(t *testing.T) {
+	conf := &config.Config{
+		GlobalConfig: config.GlobalConfig{Hostname: "web-1"},
+		Plugins: []*plugin.RunningPlugin{
+			{Name: "nginx", Config: &plugin.Config{Instances: []plugin.Instance{{}, {}}}},
+		},
+	}
+	reloads := 0
+	s := NewServer(conf, agent.NewAgent(conf), forwarder.NewForwarder(conf), watchdog.New(), func() { reloads++ })
+	h := s.Handler()
+
+	code, status := request(t, h, "GET", "/status", "", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "web-1", status["hostname"])
+	assert.Equal(t, float64(1), status["checks"])
+
+	code, checks := request(t, h, "GET", "/checks", "", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"name": "nginx", "instances": float64(2), "runs": float64(0), "errors": float64(0), "last_duration": float64(0),
+		"last_metrics": float64(0), "last_events": float64(0), "last_service_checks": float64(0),
+	}}, checks["list"])
+
+	code, queue := request(t, h, "GET", "/queue", "", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(0), queue["queue_size"])
+	assert.Contains(t, queue["endpoints"], "metrics")
+
+	code, _ = request(t, h, "GET", "/reload", "", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+	code, _ = request(t, h, "POST", "/reload", "", "")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, 1, reloads)
+
+	defer log.SetLevel("info")
+	code, level := request(t, h, "PUT", "/log/level", `{"level": "debug"}`, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "debug", level["level"])
+	assert.Equal(t, "debug", log.GetLevel())
+	code, level = request(t, h, "PUT", "/log/level", `{"level": "verbose"}`, "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, `not a valid logrus Level: "verbose"`, level["error"])
+
+	h = authorize("s3cr3t", h)
+	code, _ = request(t, h, "GET", "/status", "", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = request(t, h, "GET", "/status", "", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = request(t, h, "GET", "/status", "", "s3cr3t")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestProbes(t *testing.T) {
+	conf := &config.Config{}
+	wd := watchdog.New()
+	var collector, fw watchdog.Heartbeat
+	wd.Register("collector", &collector, time.Minute)
+	wd.Register("forwarder", &fw, time.Minute)
+	h := authorize("s3cr3t", NewServer(conf, agent.NewAgent(conf), forwarder.NewForwarder(conf), wd, func() {}).Handler())
+
+	code, health := request(t, h, "GET", "/health", "", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, health["healthy"])
+	code, ready := request(t, h, "GET", "/ready", "", "")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, false, ready["ready"])
+
+	collector.Beat()
+	fw.Beat()
+	code, _ = request(t, h, "GET", "/ready", "", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = request(t, h, "POST", "/health", "", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+}
+
+func TestProfiling(t *testing.T) {
+	conf := &config.Config{}
+	s := NewServer(conf, agent.NewAgent(conf), forwarder.NewForwarder(conf), watchdog.New(), func() {})
+	req := httptest.NewRequest("GET", "/debug/pprof/heap", nil)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	conf.GlobalConfig.EnableProfiling = true
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected time.Duration
+	}{
+		{"/status", RequestTimeout},
+		{"/debug/pprof/heap", RequestTimeout},
+		{"/debug/pprof/profile?seconds=60", RequestTimeout + time.Minute},
+		{"/debug/pprof/trace?seconds=0.5", RequestTimeout + 500*time.Millisecond},
+		{"/debug/pprof/profile", RequestTimeout + 30*time.Second},
+		{"/debug/pprof/profile?seconds=-1", RequestTimeout + 30*time.Second},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, writeTimeout(httptest.NewRequest("GET", test.path, nil)), test.path)
+	}
+}
+
+func TestLoadToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "control")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "agent", "control.token")
+	token, err := LoadToken(path)
+	require.NoError(t, err)
+	assert.Len(t, token, 64)
+
+	again, err := LoadToken(path)
+	require.NoError(t, err)
+	assert.Equal(t, token, again)
+}
@@ -0,0 +1,95 @@
+package control
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/agent"
+	"github.com/cloudinsight/cloudinsight-agent/forwarder"
+)
+
+// WriteStatus writes a report of the state of the agent reached by c, for
+// the status subcommand.
+func WriteStatus(w io.Writer, c *Client, now time.Time) error {
+	var status Status
+	if err := c.Get("/status", &status); err != nil {
+		return err
+	}
+	var queue forwarder.Stats
+	if err := c.Get("/queue", &queue); err != nil {
+		return err
+	}
+	var checks []agent.CheckStatus
+	if err := c.Get("/checks", &checks); err != nil {
+		return err
+	}
+
+	header(w, "Cloudinsight Agent "+status.Version)
+	fmt.Fprintf(w, "  Hostname: %s\n", status.Hostname)
+	fmt.Fprintf(w, "  PID: %d\n", status.PID)
+	fmt.Fprintf(w, "  Uptime: %s\n", time.Duration(status.Uptime)*time.Second)
+	fmt.Fprintf(w, "  Log level: %s\n", status.LogLevel)
+	fmt.Fprintf(w, "  Last flush: %s\n", since(status.LastFlush, now))
+
+	header(w, "Forwarder")
+	mode := ""
+	if queue.Offline {
+		mode = " (offline, spooled)"
+	}
+	fmt.Fprintf(w, "  Queue: %d payloads, %d dropped%s\n", queue.QueueSize, queue.QueueDropped, mode)
+	names := make([]string, 0, len(queue.Endpoints))
+	for name := range queue.Endpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ep := queue.Endpoints[name]
+		fmt.Fprintf(w, "  %s: %d posted, %d retries, %d dropped\n", name, ep.Posted, ep.Retries, ep.Dropped)
+	}
+
+	header(w, "Checks")
+	if len(checks) == 0 {
+		fmt.Fprintln(w, "  No check is configured")
+	}
+	for _, check := range checks {
+		pipeline := ""
+		if check.Pipeline != "" {
+			pipeline = ", pipeline " + check.Pipeline
+		}
+		fmt.Fprintf(w, "  %s (%s%s)\n", check.Name, plural(check.Instances, "instance"), pipeline)
+		fmt.Fprintf(w, "    Runs: %d, errors: %d\n", check.Runs, check.Errors)
+		if check.LastRun == nil {
+			continue
+		}
+		fmt.Fprintf(w, "    Last run: %s, took %s\n", since(check.LastRun, now),
+			time.Duration(check.LastDuration*float64(time.Second)).Round(time.Millisecond))
+		fmt.Fprintf(w, "    Submitted: %d metrics, %d events, %d service checks\n",
+			check.LastMetrics, check.LastEvents, check.LastServiceChecks)
+		if check.LastError != "" {
+			fmt.Fprintf(w, "    Last error: %s\n", check.LastError)
+		}
+	}
+	return nil
+}
+
+func header(w io.Writer, title string) {
+	fmt.Fprintf(w, "\n%s\n%s\n", title, strings.Repeat("=", len(title)))
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// since formats t with the time elapsed until now.
+func since(t *time.Time, now time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return fmt.Sprintf("%s (%s ago)", t.Format(time.RFC3339), now.Sub(*t).Round(time.Second))
+}
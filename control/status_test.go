@@ -0,0 +1,75 @@
+package control
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "control")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	responses := map[string]string{
+		"/status": `{"version":"0.0.1","hostname":"web-1","pid":42,"uptime":3725.5,"log_level":"info","last_flush":"2026-10-15T10:00:00Z"}`,
+		"/queue":  `{"queue_size":3,"queue_dropped":1,"endpoints":{"service_checks":{"posted":4},"metrics":{"posted":10,"retries":2}}}`,
+		"/checks": `[{"name":"nginx","instances":2,"runs":5,"errors":1,"last_run":"2026-10-15T10:00:05Z","last_duration":0.1234,` +
+			`"last_error":"connection refused","last_metrics":12,"last_service_checks":2},{"name":"redis","instances":1}]`,
+	}
+	socket := filepath.Join(dir, "control.sock")
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(responses[r.URL.Path]))
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	c, err := NewClient(&config.Config{GlobalConfig: config.GlobalConfig{ControlSocket: socket}})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteStatus(&buf, c, time.Date(2026, 10, 15, 10, 0, 15, 0, time.UTC)))
+	assert.Equal(t, `
+Cloudinsight Agent 0.0.1
+========================
+  Hostname: web-1
+  PID: 42
+  Uptime: 1h2m5s
+  Log level: info
+  Last flush: 2026-10-15T10:00:00Z (15s ago)
+
+Forwarder
+=========
+  Queue: 3 payloads, 1 dropped
+  metrics: 10 posted, 2 retries, 0 dropped
+  service_checks: 4 posted, 0 retries, 0 dropped
+
+Checks
+======
+  nginx (2 instances)
+    Runs: 5, errors: 1
+    Last run: 2026-10-15T10:00:05Z (10s ago), took 123ms
+    Submitted: 12 metrics, 0 events, 2 service checks
+    Last error: connection refused
+  redis (1 instance)
+    Runs: 0, errors: 0
+`, buf.String())
+
+	_, err = NewClient(&config.Config{})
+	assert.EqualError(t, err, "the control API is disabled, set control_socket or control_port")
+
+	c, err = NewClient(&config.Config{GlobalConfig: config.GlobalConfig{ControlSocket: filepath.Join(dir, "missing.sock")}})
+	require.NoError(t, err)
+	assert.Error(t, WriteStatus(&buf, c, time.Now()))
+}
@@ -0,0 +1,112 @@
+// Package exporter serves the latest values of the metrics on /metrics, for
+// a Prometheus server to scrape the agent.
+package exporter
+
+import (
+	"bufio"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/privileges"
+	"github.com/cloudinsight/cloudinsight-agent/common/prometheus"
+)
+
+// contentType is the content type of the text exposition format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// NewExporter creates a new instance of Exporter, it serves the series of
+// prometheus.Default.
+func NewExporter(conf *config.Config) *Exporter {
+	e := &Exporter{
+		conf:  conf,
+		store: prometheus.Default,
+		start: time.Now(),
+	}
+
+	// Keep the series a few flushes, they may be missing from one.
+	maxAge := conf.GetCollectInterval()
+	if interval := conf.GetStatsdFlushInterval(); interval > maxAge {
+		maxAge = interval
+	}
+	if maxAge *= 3; maxAge < prometheus.DefaultMaxAge {
+		maxAge = prometheus.DefaultMaxAge
+	}
+	e.store.SetMaxAge(maxAge)
+	return e
+}
+
+// Exporter is the HTTP server of the Prometheus endpoint.
+type Exporter struct {
+	conf  *config.Config
+	store *prometheus.Store
+	start time.Time
+}
+
+// ServeHTTP writes the metrics of the agent itself, then the series.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+
+	bw := bufio.NewWriter(w)
+	e.writeSelfMetrics(bw)
+	if err := bw.Flush(); err != nil {
+		log.Debugf("Failed to write the Prometheus metrics: %s", err)
+		return
+	}
+	if err := e.store.WriteText(w); err != nil {
+		log.Debugf("Failed to write the Prometheus metrics: %s", err)
+	}
+}
+
+func (e *Exporter) writeSelfMetrics(w *bufio.Writer) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	for _, m := range []struct {
+		name   string
+		labels []prometheus.Label
+		value  float64
+	}{
+		{"cloudinsight_agent_info", []prometheus.Label{{Name: "version", Value: config.VERSION}}, 1},
+		{"cloudinsight_agent_uptime_seconds", nil, time.Since(e.start).Seconds()},
+		{"cloudinsight_agent_goroutines", nil, float64(runtime.NumGoroutine())},
+		{"cloudinsight_agent_memory_bytes", nil, float64(mem.Sys)},
+		{"cloudinsight_agent_exposed_series", nil, float64(e.store.Len())},
+	} {
+		prometheus.WriteType(w, m.name, "gauge")
+		prometheus.WriteSample(w, m.name, m.labels, m.value)
+	}
+}
+
+// Run serves /metrics until shutdown is closed.
+func (e *Exporter) Run(shutdown chan struct{}) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+
+	s := &http.Server{
+		Handler:        mux,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxHeaderBytes: 1 << 20,
+	}
+
+	addr := e.conf.GetPrometheusAddr()
+	l, err := privileges.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	log.Infoln("Prometheus endpoint listening on:", addr)
+
+	go func() {
+		if err := s.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Prometheus endpoint stopped: %s", err)
+		}
+	}()
+
+	<-shutdown
+	log.Infof("Prometheus endpoint thread exit")
+	return s.Close()
+}
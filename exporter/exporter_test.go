@@ -0,0 +1,38 @@
+package exporter
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeHTTP(t *testing.T) {
+	conf := &config.Config{}
+	e := NewExporter(conf)
+	e.store = prometheus.NewStore(time.Minute)
+
+	m := metric.NewMetric("system.load.1", 0.5, []string{"env:prod"})
+	require.NoError(t, e.store.Write([]metric.Metric{m}))
+
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, contentType, resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), "# TYPE cloudinsight_agent_info gauge\ncloudinsight_agent_info{version=\""+config.VERSION+"\"} 1\n")
+	assert.Contains(t, string(body), "cloudinsight_agent_exposed_series 1\n")
+	assert.Contains(t, string(body), "# TYPE system_load_1 gauge\nsystem_load_1{env=\"prod\"} 0.5\n")
+}
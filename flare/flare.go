@@ -0,0 +1,279 @@
+// Package flare bundles what the support needs to troubleshoot an agent into
+// a tarball: the configuration files with their credentials scrubbed, the
+// end of the log files, the status and the runtime profiles of the running
+// agent and a description of its environment. The tarball may be uploaded
+// to Cloudinsight.
+package flare
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+	"github.com/cloudinsight/cloudinsight-agent/control"
+)
+
+// scrubber removes the credentials from the files of the flare.
+var scrubber = util.NewScrubber(nil)
+
+// MaxLogSize is the size of the end of every log file added to the flare.
+const MaxLogSize = 10 << 20
+
+// MaxProfileDuration bounds the CPU profile.
+const MaxProfileDuration = 5 * time.Minute
+
+// Options are the options of Create.
+type Options struct {
+	// ConfigPath is the main configuration file of the agent.
+	ConfigPath string
+	// ProfileDuration is the duration of the CPU profile of the running
+	// agent, it isn't profiled when it's 0.
+	ProfileDuration time.Duration
+}
+
+// Create writes the flare of the agent configured by conf to a tarball in
+// dir and returns its path. The parts which can't be collected, like the
+// status when the agent isn't running, are listed in errors.txt.
+func Create(conf *config.Config, opts Options, dir string, now time.Time) (string, error) {
+	if opts.ProfileDuration > MaxProfileDuration {
+		return "", fmt.Errorf("the profile duration must be at most %s", MaxProfileDuration)
+	}
+
+	name := fmt.Sprintf("cloudinsight-agent-flare-%s-%s", sanitize(conf.GetHostname()), now.UTC().Format("20060102-150405"))
+	path := filepath.Join(dir, name+".tar.gz")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	b := newBuilder(f, name, now)
+	b.addConfigs(conf, opts.ConfigPath)
+	b.addLogs(conf)
+	b.addAgent(conf, opts.ProfileDuration, now)
+	b.add("environment.txt", environment(conf, opts.ConfigPath))
+	if len(b.errors) > 0 {
+		b.add("errors.txt", []byte(strings.Join(b.errors, "\n")+"\n"))
+	}
+
+	err = b.close()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// builder writes the files of the flare to the tarball, the errors of the
+// parts are collected instead of failing the flare.
+type builder struct {
+	gz     *gzip.Writer
+	tw     *tar.Writer
+	prefix string
+	now    time.Time
+	err    error
+	errors []string
+}
+
+func newBuilder(w io.Writer, prefix string, now time.Time) *builder {
+	gz := gzip.NewWriter(w)
+	return &builder{gz: gz, tw: tar.NewWriter(gz), prefix: prefix, now: now}
+}
+
+func (b *builder) add(name string, content []byte) {
+	if b.err != nil {
+		return
+	}
+	b.err = b.tw.WriteHeader(&tar.Header{
+		Name:    b.prefix + "/" + filepath.ToSlash(name),
+		Mode:    0600,
+		Size:    int64(len(content)),
+		ModTime: b.now,
+	})
+	if b.err == nil {
+		_, b.err = b.tw.Write(content)
+	}
+}
+
+func (b *builder) fail(format string, args ...interface{}) {
+	b.errors = append(b.errors, fmt.Sprintf(format, args...))
+}
+
+// addFile adds the end of file, scrubbed, as name.
+func (b *builder) addFile(name, file string, maxSize int64) {
+	f, err := os.Open(file)
+	if err != nil {
+		b.fail("%s: %s", name, err)
+		return
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err == nil && maxSize > 0 && info.Size() > maxSize {
+		_, _ = f.Seek(-maxSize, io.SeekEnd)
+	}
+	var buf bytes.Buffer
+	if _, err = io.Copy(&buf, f); err != nil {
+		b.fail("%s: %s", name, err)
+		return
+	}
+	b.add(name, scrubber.ScrubText(buf.Bytes()))
+}
+
+func (b *builder) close() error {
+	if err := b.tw.Close(); b.err == nil {
+		b.err = err
+	}
+	if err := b.gz.Close(); b.err == nil {
+		b.err = err
+	}
+	return b.err
+}
+
+// addConfigs adds the main configuration file and the files it includes,
+// and the configuration files of the checks.
+func (b *builder) addConfigs(conf *config.Config, confPath string) {
+	b.addFile("config/"+filepath.Base(confPath), confPath, 0)
+	for _, pattern := range conf.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(confPath), pattern)
+		}
+		files, _ := filepath.Glob(pattern)
+		for _, file := range files {
+			b.addFile("config/include/"+filepath.Base(file), file, 0)
+		}
+	}
+
+	if root, err := os.Getwd(); err == nil {
+		confd := filepath.Join(root, "collector/conf.d")
+		for _, pattern := range []string{"*.yaml", "*.yaml.default", "*.d/*.yaml"} {
+			files, _ := filepath.Glob(filepath.Join(confd, pattern))
+			for _, file := range files {
+				rel, _ := filepath.Rel(confd, file)
+				b.addFile("conf.d/"+rel, file, 0)
+			}
+		}
+	}
+	if dir := conf.GlobalConfig.RemoteConfigPath; dir != "" {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.yaml"))
+		for _, file := range files {
+			b.addFile("remote/"+filepath.Base(file), file, 0)
+		}
+	}
+}
+
+// addLogs adds the end of the log file of the agent and of the log files of
+// the checks.
+func (b *builder) addLogs(conf *config.Config) {
+	if file := conf.LoggingConfig.LogFile; file != "" {
+		b.addFile("logs/"+filepath.Base(file), file, MaxLogSize)
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range conf.Plugins {
+		for _, instance := range p.Config.Instances {
+			if _, file := instance.LogOptions(); file != "" && !seen[file] {
+				seen[file] = true
+				b.addFile("logs/checks/"+filepath.Base(file), file, MaxLogSize)
+			}
+		}
+	}
+}
+
+// addAgent adds the status and the profiles of the running agent, from its
+// control API. The profiles are only served when enable_profiling is set.
+func (b *builder) addAgent(conf *config.Config, profileDuration time.Duration, now time.Time) {
+	c, err := control.NewClient(conf)
+	if err != nil {
+		b.fail("status: %s", err)
+		return
+	}
+
+	var status bytes.Buffer
+	if err = control.WriteStatus(&status, c, now); err != nil {
+		b.fail("status: %s", err)
+		return
+	}
+	b.add("status.txt", status.Bytes())
+
+	if !conf.GlobalConfig.EnableProfiling {
+		b.fail("profiles: enable_profiling isn't set")
+		return
+	}
+	profiles := [][2]string{
+		{"profiles/heap.pprof", "/debug/pprof/heap"},
+		{"profiles/goroutines.txt", "/debug/pprof/goroutine?debug=2"},
+	}
+	for _, p := range profiles {
+		b.download(c, p[0], p[1])
+	}
+	if profileDuration > 0 {
+		// The profile lasts whole seconds, and the default 30 of pprof
+		// when it's 0.
+		seconds := int((profileDuration + time.Second - 1) / time.Second)
+		b.download(c.WithTimeout(control.RequestTimeout+time.Duration(seconds)*time.Second),
+			"profiles/cpu.pprof", fmt.Sprintf("/debug/pprof/profile?seconds=%d", seconds))
+	}
+}
+
+// download adds the response of the control API to the GET request to path
+// as name.
+func (b *builder) download(c *control.Client, name, path string) {
+	var buf bytes.Buffer
+	if err := c.Download(path, &buf); err != nil {
+		b.fail("%s: %s", name, err)
+		return
+	}
+	b.add(name, buf.Bytes())
+}
+
+// environment describes the host and the build of the agent.
+func environment(conf *config.Config, confPath string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Agent version: %s\n", config.VERSION)
+	fmt.Fprintf(&buf, "Go version: %s\n", runtime.Version())
+	fmt.Fprintf(&buf, "OS: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&buf, "CPUs: %d\n", runtime.NumCPU())
+	if executable, err := os.Executable(); err == nil {
+		fmt.Fprintf(&buf, "Executable: %s\n", executable)
+	}
+	fmt.Fprintf(&buf, "Config file: %s\n", confPath)
+	if r, err := conf.ResolveHostname(); err == nil {
+		fmt.Fprintf(&buf, "Hostname: %s (from %s)\n", r.Hostname, r.Source)
+	}
+
+	var env []string
+	for _, kv := range os.Environ() {
+		name := strings.ToUpper(strings.SplitN(kv, "=", 2)[0])
+		if strings.HasPrefix(name, "CLOUDINSIGHT") || strings.HasSuffix(name, "_PROXY") {
+			env = append(env, kv)
+		}
+	}
+	sort.Strings(env)
+	buf.WriteString("\nEnvironment:\n")
+	for _, kv := range env {
+		buf.WriteString("  " + kv + "\n")
+	}
+	return scrubber.ScrubText(buf.Bytes())
+}
+
+// sanitize keeps the hostname usable in a file name.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,106 @@
+package flare
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readFlare(t *testing.T, path string) map[string]string {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+
+	files := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name[strings.Index(hdr.Name, "/")+1:]] = string(content)
+	}
+	return files
+}
+
+func TestCreate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flare")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	confPath := filepath.Join(dir, "cloudinsight-agent.conf")
+	require.NoError(t, ioutil.WriteFile(confPath, []byte("[global]\nlicense_key = \"abc123\"\n"), 0600))
+	logPath := filepath.Join(dir, "agent.log")
+	require.NoError(t, ioutil.WriteFile(logPath, []byte("posted to /metrics?license_key=abc123\n"), 0600))
+
+	conf := &config.Config{
+		GlobalConfig:  config.GlobalConfig{Hostname: "web-1", LicenseKey: "abc123"},
+		LoggingConfig: config.LoggingConfig{LogFile: logPath},
+	}
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	path, err := Create(conf, Options{ConfigPath: confPath}, dir, now)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "cloudinsight-agent-flare-web-1-20170301-120000.tar.gz"), path)
+
+	files := readFlare(t, path)
+	assert.Equal(t, "[global]\nlicense_key = \"********\"\n", files["config/cloudinsight-agent.conf"])
+	assert.Equal(t, "posted to /metrics?license_key=********\n", files["logs/agent.log"])
+	assert.Contains(t, files["environment.txt"], "Agent version: "+config.VERSION)
+	assert.Contains(t, files["errors.txt"], "the control API is disabled")
+	assert.NotContains(t, files, "status.txt")
+
+	_, err = Create(conf, Options{ConfigPath: confPath}, dir, now)
+	assert.Error(t, err)
+	_, err = Create(conf, Options{ConfigPath: confPath, ProfileDuration: time.Hour}, dir, now)
+	assert.Error(t, err)
+}
+
+func TestUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flare")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "flare.tar.gz")
+	require.NoError(t, ioutil.WriteFile(path, []byte("flare"), 0600))
+
+	var got map[string]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/infrastructure/flare", r.URL.Path)
+		assert.Equal(t, "abc123", r.URL.Query().Get("license_key"))
+		f, _, err := r.FormFile("flare_file")
+		require.NoError(t, err)
+		content, _ := ioutil.ReadAll(f)
+		got = map[string]string{
+			"flare_file": string(content),
+			"case_id":    r.FormValue("case_id"),
+			"hostname":   r.FormValue("hostname"),
+		}
+	}))
+	defer ts.Close()
+
+	conf := &config.Config{
+		GlobalConfig: config.GlobalConfig{Hostname: "web-1", LicenseKey: "abc123", CiURL: ts.URL},
+	}
+	require.NoError(t, Upload(conf, path, "1234", ""))
+	assert.Equal(t, map[string]string{"flare_file": "flare", "case_id": "1234", "hostname": "web-1"}, got)
+
+	conf.GlobalConfig.CiURL = ts.URL + "/missing/"
+	ts.Config.Handler = http.NotFoundHandler()
+	assert.Error(t, Upload(conf, path, "", ""))
+}
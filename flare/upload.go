@@ -0,0 +1,82 @@
+package flare
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/proxy"
+)
+
+// uploadPath is the endpoint of Cloudinsight receiving the flares.
+const uploadPath = "/infrastructure/flare"
+
+// Upload posts the flare at path to the support of Cloudinsight, with the
+// case id and the email of the user when they're given.
+func Upload(conf *config.Config, path, caseID, email string) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, field := range [][2]string{
+		{"case_id", caseID},
+		{"email", email},
+		{"hostname", conf.GetHostname()},
+		{"agent_version", config.VERSION},
+	} {
+		if field[1] != "" {
+			_ = mw.WriteField(field[0], field[1])
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	part, err := mw.CreateFormFile("flare_file", filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(part, f); err != nil {
+		return err
+	}
+	if err = mw.Close(); err != nil {
+		return err
+	}
+
+	tlsConfig, err := conf.ForwarderTLSConfig()
+	if err != nil {
+		return err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy.FromConfig
+	transport.TLSClientConfig = tlsConfig
+	client := &http.Client{Transport: transport, Timeout: 60 * time.Second}
+
+	u := strings.TrimSuffix(conf.GlobalConfig.CiURL, "/") + uploadPath + "?" +
+		url.Values{"license_key": []string{conf.GlobalConfig.LicenseKey}}.Encode()
+	req, err := http.NewRequest("POST", u, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("User-Agent", fmt.Sprintf("Cloudinsight Agent/%s", config.VERSION))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to upload the flare: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("unable to upload the flare: received bad status code, %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -1,21 +1,130 @@
 package forwarder
 
 import (
-	"net"
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudinsight/cloudinsight-agent/common/api"
 	"github.com/cloudinsight/cloudinsight-agent/common/config"
 	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/msgpack"
+	"github.com/cloudinsight/cloudinsight-agent/common/privileges"
+	"github.com/cloudinsight/cloudinsight-agent/common/watchdog"
 )
 
+// retryCheckInterval is the time between two checks of the queue, the
+// payloads are only retried once the backoff delay of their endpoint elapsed.
+const retryCheckInterval = time.Second
+
+// msgTypes are the endpoints of the API the Forwarder posts to.
+var msgTypes = []string{"metrics", "service_checks", "metadata", "processes", "connections"}
+
 // NewForwarder creates a new instance of Forwarder.
 func NewForwarder(conf *config.Config) *Forwarder {
-	api := api.NewAPI(conf.GlobalConfig.CiURL, conf.GlobalConfig.LicenseKey, 10*time.Second)
-	return &Forwarder{
-		api:  api,
-		conf: conf,
+	f := newForwarder(conf)
+
+	if path := conf.GlobalConfig.SpoolPath; path != "" {
+		spool, err := newDiskQueue(path, int64(conf.GlobalConfig.SpoolMaxSize)<<20, 0, oldestFirst)
+		if err == nil {
+			log.Infof("Payloads are written to the spool %s", path)
+			f.queue = spool
+			f.offline = true
+			return f
+		}
+		log.Errorf("Failed to open the spool, payloads are posted: %s", err)
+	}
+
+	maxSize, maxAge := conf.GetForwarderQueueMaxSize(), conf.GetForwarderQueueMaxAge()
+	if path := conf.GlobalConfig.ForwarderQueuePath; path != "" {
+		queue, err := newDiskQueue(path, maxSize, maxAge, f.policy)
+		if err == nil {
+			f.queue = queue
+			return f
+		}
+		log.Errorf("Failed to open the Forwarder queue, payloads are queued in memory: %s", err)
+	}
+	f.queue = newMemoryQueue(maxSize, maxAge, f.policy)
+	return f
+}
+
+// newForwarder creates a Forwarder without its queue.
+func newForwarder(conf *config.Config) *Forwarder {
+	maxPayloadSize := conf.GlobalConfig.MaxPayloadSize
+	if maxPayloadSize <= 0 {
+		maxPayloadSize = api.DefaultMaxPayloadSize
+	}
+
+	tlsConfig, err := conf.ForwarderTLSConfig()
+	if err != nil {
+		log.Errorf("Failed to load the TLS options of the Forwarder: %s", err)
+	}
+	newAPI := func(licenseKey string) *api.API {
+		a := api.NewAPI(conf.GlobalConfig.CiURL, licenseKey, 10*time.Second)
+		if tlsConfig != nil {
+			a.SetTLSConfig(tlsConfig)
+		}
+		return a
+	}
+
+	f := &Forwarder{
+		api:          newAPI(conf.GlobalConfig.LicenseKey),
+		pipelineAPIs: make(map[string]*api.API, len(conf.Pipelines)),
+		pipelines:    make(map[string]string, len(conf.Pipelines)),
+		conf:         conf,
+		backoff: backoff{
+			base: conf.GetForwarderBackoffBase(),
+			max:  conf.GetForwarderBackoffMax(),
+		},
+		endpoints:      make(map[string]*endpoint, len(msgTypes)),
+		maxPayloadSize: maxPayloadSize,
+	}
+	for _, msgType := range msgTypes {
+		f.endpoints[msgType] = &endpoint{name: msgType}
+	}
+	for _, p := range conf.Pipelines {
+		f.pipelineAPIs[p.Name] = newAPI(p.LicenseKey)
+		f.pipelines[p.LicenseKey] = p.Name
+	}
+	// A pipeline sharing the key of the main one posts like it.
+	delete(f.pipelines, conf.GlobalConfig.LicenseKey)
+
+	if conf.GlobalConfig.ForwarderRetryPolicy == "newest_first" {
+		f.policy = newestFirst
+	}
+	return f
+}
+
+// Import posts the payloads spooled in dir in order, they're removed once
+// posted or rejected by Cloudinsight. It stops at the first payload which
+// may be posted later, the remaining ones are left for the next import.
+func Import(conf *config.Config, dir string) (int, error) {
+	spool, err := newDiskQueue(dir, 0, 0, oldestFirst)
+	if err != nil {
+		return 0, err
+	}
+	f := newForwarder(conf)
+	f.queue = spool
+	// Nothing to wait for, the import is retried by hand.
+	f.backoff = backoff{}
+
+	imported := 0
+	for {
+		t, ok := spool.Peek()
+		if !ok {
+			return imported, nil
+		}
+		if !f.post(t) {
+			return imported, fmt.Errorf("unable to post the %s payload, %d payloads remaining", t.msgType, spool.Len())
+		}
+		spool.Remove(t)
+		imported++
 	}
 }
 
@@ -23,29 +132,277 @@ func NewForwarder(conf *config.Config) *Forwarder {
 type Forwarder struct {
 	api  *api.API
 	conf *config.Config
+	// pipelineAPIs post the payloads of the [[pipeline]] sections with their
+	// license key, pipelines maps the license keys to their names.
+	pipelineAPIs map[string]*api.API
+	pipelines    map[string]string
+
+	backoff   backoff
+	endpoints map[string]*endpoint
+
+	// queue holds the payloads waiting to be retried.
+	queue  queue
+	policy retryPolicy
+	// offline is set when the queue is the spool, the payloads are never
+	// posted.
+	offline bool
+	// lastQueueDropped is the value of queue.Dropped at the previous report.
+	lastQueueDropped int64
+
+	maxPayloadSize int
+	// jsonOnly is set to 1 once the intake rejected a MessagePack payload.
+	jsonOnly int32
+
+	// heartbeat beats every retryCheckInterval, unless the retries are
+	// stuck.
+	heartbeat watchdog.Heartbeat
 }
 
 func (f *Forwarder) metricHandler(w http.ResponseWriter, r *http.Request) {
-	err := f.api.Post(f.api.GetURL("metrics"), r.Body)
+	f.forward("metrics", w, r)
+}
+
+func (f *Forwarder) serviceCheckHandler(w http.ResponseWriter, r *http.Request) {
+	f.forward("service_checks", w, r)
+}
+
+func (f *Forwarder) metadataHandler(w http.ResponseWriter, r *http.Request) {
+	f.forward("metadata", w, r)
+}
+
+func (f *Forwarder) processesHandler(w http.ResponseWriter, r *http.Request) {
+	f.forward("processes", w, r)
+}
+
+func (f *Forwarder) connectionsHandler(w http.ResponseWriter, r *http.Request) {
+	f.forward("connections", w, r)
+}
+
+// forward posts the body of r to the msgType endpoint with the license key of
+// the pipeline which sent it, it's queued when the
+// post fails or when the endpoint is backing off. When older payloads are
+// waiting, it's queued right away so that they're posted in order, unless
+// it has a high priority or the newest payloads go first. Every payload goes
+// to the spool when the Forwarder is offline. The payloads exceeding the maximum payload size are rejected with
+// 413 Request Entity Too Large, so that the emitters split them.
+func (f *Forwarder) forward(msgType string, w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(f.maxPayloadSize)+1))
 	if err != nil {
-		log.Errorf("Error occurred when posting Payload. %s", err)
+		log.Errorf("Error reading the %s payload. %s", msgType, err)
+		return
+	}
+	if len(body) > f.maxPayloadSize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType != msgpack.ContentType {
+		contentType = api.ContentTypeJSON
+	}
+	t := transaction{
+		msgType:      msgType,
+		body:         body,
+		contentType:  contentType,
+		highPriority: r.Header.Get(api.PriorityHeader) == api.HighPriority,
+		pipeline:     f.pipelines[r.URL.Query().Get("license_key")],
+	}
+	first := t.highPriority || f.policy == newestFirst
+	if f.offline || (f.queue.Len() > 0 && !first) || !f.endpoints[msgType].available(time.Now()) {
+		f.enqueue(t)
+		return
+	}
+	if !f.post(t) {
+		f.enqueue(t)
+	}
+}
+
+// post returns false when t should be retried. The payloads rejected with a
+// client error are dropped, retrying them would fail again.
+func (f *Forwarder) post(t transaction) bool {
+	ep, ok := f.endpoints[t.msgType]
+	if !ok {
+		log.Errorf("Dropping a payload for the unknown %s endpoint", t.msgType)
+		return true
+	}
+	if f.apiOf(t) == nil {
+		log.Errorf("Dropping a %s payload of the pipeline %s, it's no longer configured", t.msgType, t.pipeline)
+		return true
+	}
+
+	err := f.send(t)
+	switch {
+	case err == nil:
+		atomic.AddInt64(&ep.telemetry.posted, 1)
+		ep.success()
+		return true
+	case isPermanent(err):
+		log.Errorf("Dropping the %s payload rejected by Cloudinsight. %s", t.msgType, err)
+		atomic.AddInt64(&ep.telemetry.dropped, 1)
+		// The endpoint is reachable, only this payload is wrong.
+		ep.success()
+		return true
+	default:
+		delay := ep.failure(time.Now(), f.backoff)
+		log.Warnf("Error occurred when posting %s, retrying in %s. %s", t.msgType, delay, err)
+		return false
 	}
 }
 
+// send posts t in its format, the MessagePack payloads are converted to JSON
+// once the intake rejected one with 415 Unsupported Media Type, it's an
+// intake which doesn't know the format.
+func (f *Forwarder) send(t transaction) error {
+	if t.contentType == msgpack.ContentType && atomic.LoadInt32(&f.jsonOnly) == 1 {
+		return f.sendJSON(t)
+	}
+
+	a := f.apiOf(t)
+	err := a.PostContent(a.GetURL(t.msgType), t.contentType, bytes.NewReader(t.body))
+	statusErr, ok := err.(*api.StatusError)
+	if ok && statusErr.Code == http.StatusUnsupportedMediaType && t.contentType == msgpack.ContentType {
+		log.Warnf("Cloudinsight doesn't accept MessagePack payloads, posting them as JSON")
+		atomic.StoreInt32(&f.jsonOnly, 1)
+		return f.sendJSON(t)
+	}
+	return err
+}
+
+func (f *Forwarder) sendJSON(t transaction) error {
+	body, err := msgpackToJSON(t.body)
+	if err != nil {
+		return err
+	}
+	a := f.apiOf(t)
+	return a.PostContent(a.GetURL(t.msgType), api.ContentTypeJSON, bytes.NewReader(body))
+}
+
+// apiOf returns the API posting with the license key of the pipeline of t,
+// nil when the pipeline was removed since t was queued.
+func (f *Forwarder) apiOf(t transaction) *api.API {
+	if t.pipeline == "" {
+		return f.api
+	}
+	return f.pipelineAPIs[t.pipeline]
+}
+
+// msgpackToJSON converts a compressed MessagePack payload to a compressed
+// JSON payload.
+func msgpackToJSON(body []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, errUnreadablePayload
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errUnreadablePayload
+	}
+	v, err := msgpack.Decode(data)
+	if err != nil {
+		return nil, errUnreadablePayload
+	}
+	if data, err = json.Marshal(v); err != nil {
+		return nil, errUnreadablePayload
+	}
+
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	_, _ = w.Write(data)
+	_ = w.Close()
+	return buf.Bytes(), nil
+}
+
+func (f *Forwarder) enqueue(t transaction) {
+	if err := f.queue.Push(t); err != nil {
+		log.Errorf("Failed to queue the %s payload. %s", t.msgType, err)
+		atomic.AddInt64(&f.endpoints[t.msgType].telemetry.dropped, 1)
+	}
+}
+
+// flushQueue posts the queued payloads in the order of the retry policy, it
+// stops at the first payload whose endpoint is backing off or fails again.
+func (f *Forwarder) flushQueue() {
+	sent := 0
+	for {
+		t, ok := f.queue.Peek()
+		if !ok {
+			break
+		}
+		if ep, ok := f.endpoints[t.msgType]; ok {
+			if !ep.available(time.Now()) {
+				break
+			}
+			atomic.AddInt64(&ep.telemetry.retries, 1)
+		}
+		if !f.post(t) {
+			break
+		}
+		f.queue.Remove(t)
+		sent++
+	}
+	if sent > 0 {
+		log.Infof("Posted %d queued payloads, %d remaining", sent, f.queue.Len())
+	}
+}
+
+func (f *Forwarder) retry(shutdown chan struct{}) {
+	ticker := time.NewTicker(retryCheckInterval)
+	defer ticker.Stop()
+	reportTicker := time.NewTicker(f.conf.GetStatsdFlushInterval())
+	defer reportTicker.Stop()
+
+	f.heartbeat.Beat()
+	for {
+		select {
+		case <-ticker.C:
+			if !f.offline {
+				f.flushQueue()
+			}
+			f.heartbeat.Beat()
+		case <-reportTicker.C:
+			f.report()
+		case <-shutdown:
+			return
+		}
+	}
+}
+
+// Drain waits until the queued payloads are posted, or until timeout. The
+// payloads written to the spool are already safe.
+func (f *Forwarder) Drain(timeout time.Duration) error {
+	if f.offline {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	for f.queue.Len() > 0 {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d payloads are still queued after %s", f.queue.Len(), timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
 // Run runs a http server listening to 10010 as default.
 func (f *Forwarder) Run(shutdown chan struct{}) error {
-	http.HandleFunc("/infrastructure/metrics", f.metricHandler)
+	// A mux of its own, the handlers are registered again on every reload.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/infrastructure/metrics", f.metricHandler)
 
-	http.HandleFunc("/infrastructure/series", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/infrastructure/series", func(w http.ResponseWriter, r *http.Request) {
 		// TODO
 	})
 
-	http.HandleFunc("/infrastructure/service_checks", func(w http.ResponseWriter, r *http.Request) {
-		// TODO
-	})
+	mux.HandleFunc("/infrastructure/service_checks", f.serviceCheckHandler)
+
+	mux.HandleFunc("/infrastructure/metadata", f.metadataHandler)
+
+	mux.HandleFunc("/infrastructure/processes", f.processesHandler)
+
+	mux.HandleFunc("/infrastructure/connections", f.connectionsHandler)
 
 	s := &http.Server{
-		Handler:        nil,
+		Handler:        mux,
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
@@ -53,26 +410,22 @@ func (f *Forwarder) Run(shutdown chan struct{}) error {
 
 	addr := f.conf.GetForwarderAddr()
 
-	l, err := net.Listen("tcp", addr)
+	l, err := privileges.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
 
 	log.Infoln("Forwarder listening on:", addr)
 
+	go f.retry(shutdown)
+
 	go func() {
-		if err := s.Serve(l); err != nil {
+		if err := s.Serve(l); err != nil && err != http.ErrServerClosed {
 			log.Fatal(err)
 		}
 	}()
 
-	select {
-	case <-shutdown:
-		log.Infof("Forwarder server thread exit")
-		if err := l.Close(); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	<-shutdown
+	log.Infof("Forwarder server thread exit")
+	return s.Close()
 }
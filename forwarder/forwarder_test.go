@@ -1,13 +1,29 @@
 package forwarder
 
 import (
+	"bytes"
+	"compress/zlib"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/cloudinsight/cloudinsight-agent/common/api"
 	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/msgpack"
+	"github.com/stretchr/testify/assert"
 )
 
 var (
@@ -33,3 +49,211 @@ func TestMetricHandler(t *testing.T) {
 		t.Fatalf("Received non-200 response: %d\n", resp.StatusCode)
 	}
 }
+
+// writeClientCert writes a self-signed client certificate and its key to dir.
+func writeClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "cloudinsight-agent"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return cert, certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forwarder-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clientCert, certFile, keyFile := writeClientCert(t, dir)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	var received int
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", server.Certificate().Raw)
+
+	conf := config.DefaultConfig
+	conf.GlobalConfig.CiURL = server.URL
+	conf.GlobalConfig.ForwarderCACerts = caFile
+	conf.GlobalConfig.ForwarderMinTLSVersion = "1.2"
+
+	// The server requires a client certificate.
+	f := NewForwarder(&conf)
+	assert.Error(t, f.api.Post(f.api.GetURL("metrics"), strings.NewReader("{}")))
+
+	conf.GlobalConfig.ForwarderClientCert = certFile
+	conf.GlobalConfig.ForwarderClientKey = keyFile
+	f = NewForwarder(&conf)
+	assert.NoError(t, f.api.Post(f.api.GetURL("metrics"), strings.NewReader("{}")))
+	assert.Equal(t, 1, received)
+}
+
+func TestMsgpackFallback(t *testing.T) {
+	var received []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != api.ContentTypeJSON {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		zr, err := zlib.NewReader(r.Body)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(zr)
+		received = append(received, string(body))
+	}))
+	defer backend.Close()
+
+	f := NewForwarder(&config.DefaultConfig)
+	f.api = api.NewAPI(backend.URL, fakeLicenseKey, 10*time.Second)
+
+	post := func(v interface{}) {
+		data, err := msgpack.Marshal(v)
+		assert.NoError(t, err)
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		_, _ = zw.Write(data)
+		_ = zw.Close()
+
+		req := httptest.NewRequest("POST", "/infrastructure/metrics", &buf)
+		req.Header.Set("Content-Type", msgpack.ContentType)
+		f.metricHandler(httptest.NewRecorder(), req)
+	}
+
+	// Rejected once, then every payload is converted before posting.
+	post(map[string]interface{}{"os": "linux"})
+	post([]interface{}{1, "a"})
+	assert.Equal(t, []string{`{"os":"linux"}`, `[1,"a"]`}, received)
+	assert.Equal(t, 0, f.queue.Len())
+	assert.Equal(t, int32(1), f.jsonOnly)
+
+	_, err := msgpackToJSON([]byte("not zlib"))
+	assert.True(t, isPermanent(err))
+}
+
+func TestSpoolAndImport(t *testing.T) {
+	dir := tempQueueDir(t)
+	defer os.RemoveAll(dir)
+
+	up := false
+	var received []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, r.URL.Path+" "+string(body))
+	}))
+	defer backend.Close()
+
+	conf := config.DefaultConfig
+	conf.GlobalConfig.CiURL = backend.URL
+	conf.GlobalConfig.SpoolPath = dir
+	f := NewForwarder(&conf)
+	assert.True(t, f.offline)
+
+	f.metricHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/infrastructure/metrics", strings.NewReader("1")))
+	f.serviceCheckHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/infrastructure/service_checks", strings.NewReader("2")))
+	f.metricHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/infrastructure/metrics", strings.NewReader("3")))
+	assert.Equal(t, 3, f.queue.Len())
+
+	// Nothing is posted, the spool is left for the next import.
+	imported, err := Import(&conf, dir)
+	assert.Error(t, err)
+	assert.Equal(t, 0, imported)
+
+	up = true
+	imported, err = Import(&conf, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, imported)
+	assert.Equal(t, []string{
+		"/infrastructure/metrics 1",
+		"/infrastructure/service_checks 2",
+		"/infrastructure/metrics 3",
+	}, received)
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 0)
+}
+
+func TestPipelines(t *testing.T) {
+	dir := tempQueueDir(t)
+	defer os.RemoveAll(dir)
+
+	var received []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, r.URL.Query().Get("license_key")+" "+string(body))
+	}))
+	defer backend.Close()
+
+	conf := config.DefaultConfig
+	conf.GlobalConfig.CiURL = backend.URL
+	conf.GlobalConfig.LicenseKey = fakeLicenseKey
+	conf.GlobalConfig.SpoolPath = dir
+	conf.Pipelines = []config.PipelineConfig{{Name: "team", LicenseKey: "team-key"}}
+	f := NewForwarder(&conf)
+
+	// The pipelines are recognized by the license key of their Collector,
+	// the unknown keys post with the main one.
+	for _, req := range []string{"team-key 1", fakeLicenseKey + " 2", "unknown 3"} {
+		parts := strings.SplitN(req, " ", 2)
+		f.metricHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/infrastructure/metrics?license_key="+parts[0], strings.NewReader(parts[1])))
+	}
+	// Queued across a restart, the pipeline is kept.
+	imported, err := Import(&conf, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, imported)
+	assert.Equal(t, []string{"team-key 1", fakeLicenseKey + " 2", fakeLicenseKey + " 3"}, received)
+
+	// The payloads of a removed pipeline are dropped.
+	f.metricHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/infrastructure/metrics?license_key=team-key", strings.NewReader("4")))
+	conf.Pipelines = nil
+	received = nil
+	imported, err = Import(&conf, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, imported)
+	assert.Empty(t, received)
+}
@@ -0,0 +1,509 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/api"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+)
+
+const (
+	queueFileSuffix = ".payload"
+	queueHighSuffix = ".high"
+	queueTmpSuffix  = ".tmp"
+)
+
+// queueMagic starts every queued file, along with the version of the framing.
+// The files of version 1 have no content type, they hold JSON payloads.
+var (
+	queueMagic   = []byte("CIQ2")
+	queueMagicV1 = []byte("CIQ1")
+	// queueMagicV3 frames the pipeline of the payloads of the [[pipeline]]
+	// sections, the other ones are still framed in version 2 so that the
+	// spools may be imported by an older agent.
+	queueMagicV3 = []byte("CIQ3")
+)
+
+var errCorrupted = errors.New("corrupted payload")
+
+// transaction is a payload waiting to be posted to an endpoint of the API,
+// the msgType given to API.GetURL.
+type transaction struct {
+	msgType string
+	body    []byte
+	// contentType is the serialization of the compressed body.
+	contentType string
+	// highPriority is set on the payloads carrying the host metadata, they
+	// are posted before the others.
+	highPriority bool
+	// pipeline is the name of the [[pipeline]] whose license key the
+	// payload is posted with, empty for the main one.
+	pipeline string
+
+	// key identifies the transaction returned by Peek in its queue.
+	key string
+}
+
+// queue holds the transactions waiting to be retried.
+type queue interface {
+	Push(t transaction) error
+	// Peek returns the next transaction to post according to the retry
+	// policy, false when the queue is empty.
+	Peek() (transaction, bool)
+	// Remove drops a transaction returned by Peek, once it has been posted.
+	Remove(t transaction)
+	Len() int
+	// Dropped returns the number of transactions dropped because they
+	// exceeded the bounds of the queue, or were corrupted.
+	Dropped() int64
+}
+
+// retryPolicy selects the order the queued transactions are posted in, the
+// high priority ones always go first.
+type retryPolicy int
+
+const (
+	// oldestFirst posts the transactions in order.
+	oldestFirst retryPolicy = iota
+	// newestFirst posts the latest transactions first, so that the data
+	// becomes current again quickly after an outage.
+	newestFirst
+)
+
+// next returns the index of the transaction to post among n transactions
+// ordered from the oldest, highPriority tells whether the i-th one has a
+// high priority, none when highCount is 0.
+func (p retryPolicy) next(n, highCount int, highPriority func(i int) bool) int {
+	order := func(k int) int {
+		if p == newestFirst {
+			return n - 1 - k
+		}
+		return k
+	}
+	if highCount > 0 {
+		for k := 0; k < n; k++ {
+			if i := order(k); highPriority(i) {
+				return i
+			}
+		}
+	}
+	return order(0)
+}
+
+type queuedTransaction struct {
+	transaction
+	created time.Time
+}
+
+// memoryQueue is used when no queue directory is configured, it's bounded
+// like diskQueue but doesn't survive restarts.
+type memoryQueue struct {
+	mu           sync.Mutex
+	maxSize      int64
+	maxAge       time.Duration
+	policy       retryPolicy
+	transactions []queuedTransaction
+	size         int64
+	highCount    int
+	seq          uint64
+	dropped      int64
+}
+
+func newMemoryQueue(maxSize int64, maxAge time.Duration, policy retryPolicy) *memoryQueue {
+	return &memoryQueue{maxSize: maxSize, maxAge: maxAge, policy: policy}
+}
+
+// Len returns the number of queued transactions.
+func (q *memoryQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.transactions)
+}
+
+// Dropped returns the number of transactions dropped by the bounds.
+func (q *memoryQueue) Dropped() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// Push appends t to the queue.
+func (q *memoryQueue) Push(t transaction) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	t.key = strconv.FormatUint(q.seq, 10)
+	q.transactions = append(q.transactions, queuedTransaction{t, time.Now()})
+	q.size += int64(len(t.body))
+	if t.highPriority {
+		q.highCount++
+	}
+	q.evict()
+	return nil
+}
+
+// Peek returns the next transaction to post, the expired ones are dropped.
+func (q *memoryQueue) Peek() (transaction, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.evict()
+	if len(q.transactions) == 0 {
+		return transaction{}, false
+	}
+	i := q.policy.next(len(q.transactions), q.highCount, func(i int) bool {
+		return q.transactions[i].highPriority
+	})
+	return q.transactions[i].transaction, true
+}
+
+// Remove drops t, once it has been posted.
+func (q *memoryQueue) Remove(t transaction) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.transactions {
+		if q.transactions[i].key == t.key {
+			q.remove(i)
+			return
+		}
+	}
+}
+
+// evict drops the oldest transactions until the queue fits in maxSize and
+// maxAge. The caller holds the lock.
+func (q *memoryQueue) evict() {
+	dropped := 0
+	for len(q.transactions) > 0 {
+		oldest := q.transactions[0]
+		if (q.maxSize <= 0 || q.size <= q.maxSize) &&
+			(q.maxAge <= 0 || time.Since(oldest.created) <= q.maxAge) {
+			break
+		}
+		q.remove(0)
+		dropped++
+	}
+	q.dropped += int64(dropped)
+	if dropped > 0 {
+		log.Warnf("Dropped %d queued payloads exceeding the size or the age of the queue", dropped)
+	}
+}
+
+func (q *memoryQueue) remove(i int) {
+	t := q.transactions[i]
+	q.size -= int64(len(t.body))
+	if t.highPriority {
+		q.highCount--
+	}
+	if i == 0 {
+		q.transactions[0] = queuedTransaction{}
+		q.transactions = q.transactions[1:]
+		return
+	}
+	q.transactions = append(q.transactions[:i], q.transactions[i+1:]...)
+}
+
+type queueFile struct {
+	name         string
+	size         int64
+	created      time.Time
+	highPriority bool
+}
+
+// diskQueue keeps the transactions which couldn't be posted in a directory,
+// one file each, so that they survive restarts. The oldest transactions are
+// dropped when the queue exceeds maxSize bytes or when they're older than
+// maxAge.
+type diskQueue struct {
+	mu        sync.Mutex
+	dir       string
+	maxSize   int64
+	maxAge    time.Duration
+	policy    retryPolicy
+	files     []queueFile
+	size      int64
+	highCount int
+	seq       uint64
+	dropped   int64
+}
+
+// newDiskQueue creates the directory if needed and loads the transactions
+// queued by a previous run.
+func newDiskQueue(dir string, maxSize int64, maxAge time.Duration, policy retryPolicy) (*diskQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create the queue directory: %s", err)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the queue directory: %s", err)
+	}
+
+	q := &diskQueue{dir: dir, maxSize: maxSize, maxAge: maxAge, policy: policy}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, queueTmpSuffix) {
+			// Left behind by a crash while writing.
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		created, highPriority, ok := parseQueueFileName(name)
+		if !ok {
+			continue
+		}
+		q.files = append(q.files, queueFile{name: name, size: entry.Size(), created: created, highPriority: highPriority})
+		q.size += entry.Size()
+		if highPriority {
+			q.highCount++
+		}
+	}
+	// The names start with the creation time, so they sort in order.
+	sort.Slice(q.files, func(i, j int) bool {
+		return q.files[i].name < q.files[j].name
+	})
+	if len(q.files) > 0 {
+		log.Infof("Loaded %d queued payloads (%d bytes) from %s", len(q.files), q.size, dir)
+	}
+
+	q.mu.Lock()
+	q.evict()
+	q.mu.Unlock()
+	return q, nil
+}
+
+// parseQueueFileName parses the names of the queued files, the creation time
+// and a sequence number followed by ".high" for the high priority ones.
+func parseQueueFileName(name string) (time.Time, bool, bool) {
+	if !strings.HasSuffix(name, queueFileSuffix) {
+		return time.Time{}, false, false
+	}
+	name = strings.TrimSuffix(name, queueFileSuffix)
+	highPriority := strings.HasSuffix(name, queueHighSuffix)
+	name = strings.TrimSuffix(name, queueHighSuffix)
+
+	var nsec int64
+	var seq uint64
+	if _, err := fmt.Sscanf(name, "%d-%d", &nsec, &seq); err != nil {
+		return time.Time{}, false, false
+	}
+	return time.Unix(0, nsec), highPriority, true
+}
+
+// Len returns the number of queued transactions.
+func (q *diskQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.files)
+}
+
+// Dropped returns the number of transactions dropped by the bounds or
+// because they were corrupted.
+func (q *diskQueue) Dropped() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// Push writes t to a new file, through a temporary file renamed once
+// complete so that a crash never leaves a partial payload in the queue.
+func (q *diskQueue) Push(t transaction) error {
+	data := encodeTransaction(t)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	q.seq++
+	suffix := queueFileSuffix
+	if t.highPriority {
+		suffix = queueHighSuffix + queueFileSuffix
+	}
+	name := fmt.Sprintf("%020d-%010d%s", now.UnixNano(), q.seq, suffix)
+	path := filepath.Join(q.dir, name)
+	if err := ioutil.WriteFile(path+queueTmpSuffix, data, 0600); err != nil {
+		_ = os.Remove(path + queueTmpSuffix)
+		return fmt.Errorf("unable to write the queued payload: %s", err)
+	}
+	if err := os.Rename(path+queueTmpSuffix, path); err != nil {
+		_ = os.Remove(path + queueTmpSuffix)
+		return fmt.Errorf("unable to write the queued payload: %s", err)
+	}
+
+	q.files = append(q.files, queueFile{name: name, size: int64(len(data)), created: now, highPriority: t.highPriority})
+	q.size += int64(len(data))
+	if t.highPriority {
+		q.highCount++
+	}
+	q.evict()
+	return nil
+}
+
+// Peek returns the next transaction to post, the expired and the corrupted
+// ones are dropped on the way.
+func (q *diskQueue) Peek() (transaction, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.evict()
+	for len(q.files) > 0 {
+		i := q.policy.next(len(q.files), q.highCount, func(i int) bool {
+			return q.files[i].highPriority
+		})
+		file := q.files[i]
+		data, err := ioutil.ReadFile(filepath.Join(q.dir, file.name))
+		if err == nil {
+			var t transaction
+			if t, err = decodeTransaction(data); err == nil {
+				t.highPriority = file.highPriority
+				t.key = file.name
+				return t, true
+			}
+		}
+		log.Errorf("Dropping the queued payload %s: %s", file.name, err)
+		q.remove(i)
+		q.dropped++
+	}
+	return transaction{}, false
+}
+
+// Remove drops t, once it has been posted.
+func (q *diskQueue) Remove(t transaction) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.files {
+		if q.files[i].name == t.key {
+			q.remove(i)
+			return
+		}
+	}
+}
+
+// evict drops the oldest transactions until the queue fits in maxSize and
+// maxAge. The caller holds the lock.
+func (q *diskQueue) evict() {
+	dropped := 0
+	for len(q.files) > 0 {
+		oldest := q.files[0]
+		if (q.maxSize <= 0 || q.size <= q.maxSize) &&
+			(q.maxAge <= 0 || time.Since(oldest.created) <= q.maxAge) {
+			break
+		}
+		q.remove(0)
+		dropped++
+	}
+	q.dropped += int64(dropped)
+	if dropped > 0 {
+		log.Warnf("Dropped %d queued payloads exceeding the size or the age of the queue", dropped)
+	}
+}
+
+func (q *diskQueue) remove(i int) {
+	file := q.files[i]
+	if err := os.Remove(filepath.Join(q.dir, file.name)); err != nil && !os.IsNotExist(err) {
+		log.Errorf("Unable to remove the queued payload %s: %s", file.name, err)
+	}
+	q.files = append(q.files[:i], q.files[i+1:]...)
+	q.size -= file.size
+	if file.highPriority {
+		q.highCount--
+	}
+}
+
+// encodeTransaction frames t as:
+// magic (4 bytes) | CRC32 of the rest (4) | msgType length (2) | msgType |
+// contentType length (2) | contentType | body length (4) | body
+// The version 3 adds pipeline length (2) | pipeline after the contentType.
+func encodeTransaction(t transaction) []byte {
+	magic := queueMagic
+	var payload bytes.Buffer
+	_ = binary.Write(&payload, binary.BigEndian, uint16(len(t.msgType)))
+	payload.WriteString(t.msgType)
+	_ = binary.Write(&payload, binary.BigEndian, uint16(len(t.contentType)))
+	payload.WriteString(t.contentType)
+	if t.pipeline != "" {
+		magic = queueMagicV3
+		_ = binary.Write(&payload, binary.BigEndian, uint16(len(t.pipeline)))
+		payload.WriteString(t.pipeline)
+	}
+	_ = binary.Write(&payload, binary.BigEndian, uint32(len(t.body)))
+	payload.Write(t.body)
+
+	var buf bytes.Buffer
+	buf.Write(magic)
+	_ = binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(payload.Bytes()))
+	buf.Write(payload.Bytes())
+	return buf.Bytes()
+}
+
+// decodeTransaction also reads the files of version 1, queued before the
+// content type was framed.
+func decodeTransaction(data []byte) (transaction, error) {
+	var t transaction
+	header := len(queueMagic) + 4
+	if len(data) < header+2 {
+		return t, errCorrupted
+	}
+	v1 := bytes.Equal(data[:len(queueMagicV1)], queueMagicV1)
+	v3 := bytes.Equal(data[:len(queueMagicV3)], queueMagicV3)
+	if !v1 && !v3 && !bytes.Equal(data[:len(queueMagic)], queueMagic) {
+		return t, errCorrupted
+	}
+	payload := data[header:]
+	if binary.BigEndian.Uint32(data[len(queueMagic):header]) != crc32.ChecksumIEEE(payload) {
+		return t, errCorrupted
+	}
+
+	var ok bool
+	if t.msgType, payload, ok = readString(payload); !ok {
+		return t, errCorrupted
+	}
+	t.contentType = api.ContentTypeJSON
+	if !v1 {
+		if t.contentType, payload, ok = readString(payload); !ok {
+			return t, errCorrupted
+		}
+	}
+	if v3 {
+		if t.pipeline, payload, ok = readString(payload); !ok {
+			return t, errCorrupted
+		}
+	}
+
+	if len(payload) < 4 {
+		return t, errCorrupted
+	}
+	bodyLen := int(binary.BigEndian.Uint32(payload))
+	payload = payload[4:]
+	if len(payload) != bodyLen {
+		return t, errCorrupted
+	}
+	t.body = payload
+	return t, nil
+}
+
+// readString reads a string prefixed with its length on 2 bytes.
+func readString(b []byte) (string, []byte, bool) {
+	if len(b) < 2 {
+		return "", nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if len(b) < n {
+		return "", nil, false
+	}
+	return string(b[:n]), b[n:], true
+}
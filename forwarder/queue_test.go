@@ -0,0 +1,317 @@
+package forwarder
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/api"
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/msgpack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tempQueueDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "forwarder-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestDiskQueue(t *testing.T) {
+	dir := tempQueueDir(t)
+	defer os.RemoveAll(dir)
+
+	q, err := newDiskQueue(dir, 1<<20, time.Hour, oldestFirst)
+	assert.NoError(t, err)
+	assert.NoError(t, q.Push(transaction{msgType: "metrics", body: []byte("first")}))
+	assert.NoError(t, q.Push(transaction{msgType: "service_checks", body: []byte("second")}))
+	assert.Equal(t, 2, q.Len())
+
+	// The payloads survive a restart, in order.
+	q, err = newDiskQueue(dir, 1<<20, time.Hour, oldestFirst)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, q.Len())
+
+	tr, ok := q.Peek()
+	assert.True(t, ok)
+	assert.Equal(t, "metrics", tr.msgType)
+	assert.Equal(t, "first", string(tr.body))
+	q.Remove(tr)
+
+	tr, ok = q.Peek()
+	assert.True(t, ok)
+	assert.Equal(t, "service_checks", tr.msgType)
+	assert.Equal(t, "second", string(tr.body))
+	q.Remove(tr)
+
+	_, ok = q.Peek()
+	assert.False(t, ok)
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 0)
+}
+
+func TestDiskQueueCorruption(t *testing.T) {
+	dir := tempQueueDir(t)
+	defer os.RemoveAll(dir)
+
+	q, err := newDiskQueue(dir, 1<<20, time.Hour, oldestFirst)
+	assert.NoError(t, err)
+	assert.NoError(t, q.Push(transaction{msgType: "metrics", body: []byte("corrupted")}))
+	assert.NoError(t, q.Push(transaction{msgType: "metrics", body: []byte("valid")}))
+
+	// Flip a byte of the body of the first payload, and leave a partial write.
+	first := filepath.Join(dir, q.files[0].name)
+	data, err := ioutil.ReadFile(first)
+	assert.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.NoError(t, ioutil.WriteFile(first, data, 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "partial"+queueTmpSuffix), []byte("CIQ1"), 0600))
+
+	q, err = newDiskQueue(dir, 1<<20, time.Hour, oldestFirst)
+	assert.NoError(t, err)
+	tr, ok := q.Peek()
+	assert.True(t, ok)
+	assert.Equal(t, "valid", string(tr.body))
+	assert.Equal(t, 1, q.Len())
+
+	_, err = os.Stat(filepath.Join(dir, "partial"+queueTmpSuffix))
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = decodeTransaction([]byte("CIQ1"))
+	assert.Equal(t, errCorrupted, err)
+}
+
+func TestTransactionFraming(t *testing.T) {
+	tr, err := decodeTransaction(encodeTransaction(transaction{
+		msgType:     "metrics",
+		contentType: msgpack.ContentType,
+		body:        []byte("payload"),
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, "metrics", tr.msgType)
+	assert.Equal(t, msgpack.ContentType, tr.contentType)
+	assert.Equal(t, "payload", string(tr.body))
+	assert.Equal(t, "", tr.pipeline)
+
+	data := encodeTransaction(transaction{msgType: "metrics", pipeline: "team", body: []byte("payload")})
+	assert.Equal(t, queueMagicV3, data[:4])
+	tr, err = decodeTransaction(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "team", tr.pipeline)
+	assert.Equal(t, "payload", string(tr.body))
+
+	// Queued by an agent without the content type in the framing.
+	payload := []byte{0, 7, 'm', 'e', 't', 'r', 'i', 'c', 's', 0, 0, 0, 2, '{', '}'}
+	data = append([]byte("CIQ1"), 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[4:], crc32.ChecksumIEEE(payload))
+	tr, err = decodeTransaction(append(data, payload...))
+	assert.NoError(t, err)
+	assert.Equal(t, "metrics", tr.msgType)
+	assert.Equal(t, api.ContentTypeJSON, tr.contentType)
+	assert.Equal(t, "{}", string(tr.body))
+}
+
+func TestDiskQueueBounds(t *testing.T) {
+	dir := tempQueueDir(t)
+	defer os.RemoveAll(dir)
+
+	body := []byte(strings.Repeat("x", 100))
+	size := int64(len(encodeTransaction(transaction{msgType: "metrics", body: body})))
+
+	q, err := newDiskQueue(dir, 2*size, time.Hour, oldestFirst)
+	assert.NoError(t, err)
+	for _, b := range []string{"a", "b", "c"} {
+		assert.NoError(t, q.Push(transaction{msgType: "metrics", body: append([]byte(b), body[1:]...)}))
+	}
+	assert.Equal(t, 2, q.Len())
+	tr, _ := q.Peek()
+	assert.Equal(t, byte('b'), tr.body[0])
+
+	// Expired payloads are dropped.
+	q.maxAge = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	_, ok := q.Peek()
+	assert.False(t, ok)
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestForwarderQueue(t *testing.T) {
+	dir := tempQueueDir(t)
+	defer os.RemoveAll(dir)
+
+	up := false
+	var received []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, string(body))
+	}))
+	defer backend.Close()
+
+	conf := config.DefaultConfig
+	conf.GlobalConfig.ForwarderQueuePath = dir
+	f := NewForwarder(&conf)
+	f.api = api.NewAPI(backend.URL, fakeLicenseKey, 10*time.Second)
+	// Retry right away.
+	f.backoff = backoff{}
+
+	post := func(body string) {
+		req := httptest.NewRequest("POST", "/infrastructure/metrics", strings.NewReader(body))
+		f.metricHandler(httptest.NewRecorder(), req)
+	}
+
+	post("1")
+	post("2")
+	assert.Equal(t, 2, f.queue.Len())
+
+	// Once the backend is back, new payloads wait for the queued ones.
+	up = true
+	post("3")
+	assert.Empty(t, received)
+	f.flushQueue()
+	assert.Equal(t, []string{"1", "2", "3"}, received)
+	assert.Equal(t, 0, f.queue.Len())
+}
+
+func TestMemoryQueue(t *testing.T) {
+	q := newMemoryQueue(10, time.Hour, oldestFirst)
+	assert.NoError(t, q.Push(transaction{msgType: "metrics", body: []byte("12345")}))
+	assert.NoError(t, q.Push(transaction{msgType: "metrics", body: []byte("67890")}))
+	assert.NoError(t, q.Push(transaction{msgType: "metrics", body: []byte("abc")}))
+	assert.Equal(t, 2, q.Len())
+	assert.Equal(t, int64(1), q.Dropped())
+
+	tr, ok := q.Peek()
+	assert.True(t, ok)
+	assert.Equal(t, "67890", string(tr.body))
+	q.Remove(tr)
+	tr, _ = q.Peek()
+	q.Remove(tr)
+	_, ok = q.Peek()
+	assert.False(t, ok)
+}
+
+// drain posts the queued transactions and returns their bodies in order.
+func drain(q queue) []string {
+	var bodies []string
+	for {
+		tr, ok := q.Peek()
+		if !ok {
+			return bodies
+		}
+		bodies = append(bodies, string(tr.body))
+		q.Remove(tr)
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	dir := tempQueueDir(t)
+	defer os.RemoveAll(dir)
+
+	push := func(q queue) {
+		for _, tr := range []transaction{
+			{msgType: "metrics", body: []byte("1")},
+			{msgType: "metrics", body: []byte("2"), highPriority: true},
+			{msgType: "metrics", body: []byte("3")},
+			{msgType: "metrics", body: []byte("4"), highPriority: true},
+			{msgType: "metrics", body: []byte("5")},
+		} {
+			assert.NoError(t, q.Push(tr))
+		}
+	}
+
+	q := newMemoryQueue(0, time.Hour, oldestFirst)
+	push(q)
+	assert.Equal(t, []string{"2", "4", "1", "3", "5"}, drain(q))
+
+	q = newMemoryQueue(0, time.Hour, newestFirst)
+	push(q)
+	assert.Equal(t, []string{"4", "2", "5", "3", "1"}, drain(q))
+
+	// The priority survives a restart.
+	dq, err := newDiskQueue(dir, 1<<20, time.Hour, newestFirst)
+	assert.NoError(t, err)
+	push(dq)
+	dq, err = newDiskQueue(dir, 1<<20, time.Hour, newestFirst)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, dq.highCount)
+	assert.Equal(t, []string{"4", "2", "5", "3", "1"}, drain(dq))
+	assert.Equal(t, 0, dq.highCount)
+
+	// A transaction pushed between Peek and Remove stays queued.
+	q = newMemoryQueue(0, time.Hour, newestFirst)
+	assert.NoError(t, q.Push(transaction{msgType: "metrics", body: []byte("1")}))
+	tr, _ := q.Peek()
+	assert.NoError(t, q.Push(transaction{msgType: "metrics", body: []byte("2")}))
+	q.Remove(tr)
+	assert.Equal(t, []string{"2"}, drain(q))
+}
+
+func TestForwarderRetryPolicy(t *testing.T) {
+	up := false
+	var received []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, string(body))
+	}))
+	defer backend.Close()
+
+	conf := config.DefaultConfig
+	conf.GlobalConfig.ForwarderRetryPolicy = "newest_first"
+	f := NewForwarder(&conf)
+	f.api = api.NewAPI(backend.URL, fakeLicenseKey, 10*time.Second)
+	f.backoff = backoff{}
+
+	post := func(body string, highPriority bool) {
+		req := httptest.NewRequest("POST", "/infrastructure/metrics", strings.NewReader(body))
+		if highPriority {
+			req.Header.Set(api.PriorityHeader, api.HighPriority)
+		}
+		f.metricHandler(httptest.NewRecorder(), req)
+	}
+
+	post("1", false)
+	post("metadata", true)
+	post("2", false)
+	assert.Equal(t, 3, f.queue.Len())
+
+	// Once the backend is back, new payloads don't wait for the queued ones.
+	up = true
+	post("3", false)
+	assert.Equal(t, []string{"3"}, received)
+	f.flushQueue()
+	assert.Equal(t, []string{"3", "metadata", "2", "1"}, received)
+}
+
+func TestDrain(t *testing.T) {
+	conf := config.DefaultConfig
+	f := NewForwarder(&conf)
+	assert.NoError(t, f.Drain(time.Second))
+
+	require.NoError(t, f.queue.Push(transaction{msgType: "metrics", body: []byte("1")}))
+	assert.EqualError(t, f.Drain(200*time.Millisecond), "1 payloads are still queued after 200ms")
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		tr, _ := f.queue.Peek()
+		f.queue.Remove(tr)
+	}()
+	assert.NoError(t, f.Drain(5*time.Second))
+}
@@ -0,0 +1,90 @@
+package forwarder
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/api"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+)
+
+// backoff computes how long an endpoint is left alone after consecutive
+// failures: base doubled at every failure up to max, with a random jitter so
+// that the agents cut off together don't retry together.
+type backoff struct {
+	base time.Duration
+	max  time.Duration
+}
+
+// delay returns a random duration between half and all of
+// min(base * 2^(failures-1), max).
+func (b backoff) delay(failures int) time.Duration {
+	d := b.max
+	if failures < 32 {
+		if exp := b.base << uint(failures-1); exp > 0 && exp < b.max {
+			d = exp
+		}
+	}
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// endpoint is the circuit breaker of an endpoint of the API. The circuit
+// opens at the first failure, the payloads are queued without trying to post
+// them until the backoff delay elapses, and it closes at the first success.
+type endpoint struct {
+	mu        sync.Mutex
+	name      string
+	failures  int
+	openUntil time.Time
+
+	telemetry telemetry
+}
+
+// available returns true when the circuit is closed, or when the backoff
+// delay elapsed and a payload may be tried.
+func (e *endpoint) available(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return !now.Before(e.openUntil)
+}
+
+func (e *endpoint) success() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.failures > 0 {
+		log.Infof("The %s endpoint is reachable again after %d failures", e.name, e.failures)
+	}
+	e.failures = 0
+	e.openUntil = time.Time{}
+}
+
+func (e *endpoint) failure(now time.Time, b backoff) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.failures++
+	delay := b.delay(e.failures)
+	e.openUntil = now.Add(delay)
+	return delay
+}
+
+// errUnreadablePayload is returned when a MessagePack payload can't be
+// converted to JSON.
+var errUnreadablePayload = errors.New("unreadable MessagePack payload")
+
+// isPermanent returns true for the errors that retrying won't fix, the
+// payloads failing with them are dropped.
+func isPermanent(err error) bool {
+	if err == api.ErrPayloadTooLarge || err == errUnreadablePayload {
+		return true
+	}
+	statusErr, ok := err.(*api.StatusError)
+	return ok && statusErr.Permanent()
+}
@@ -0,0 +1,99 @@
+package forwarder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/api"
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	b := backoff{base: 2 * time.Second, max: time.Minute}
+	for failures, max := range map[int]time.Duration{
+		1:  2 * time.Second,
+		2:  4 * time.Second,
+		3:  8 * time.Second,
+		5:  32 * time.Second,
+		6:  time.Minute,
+		64: time.Minute,
+	} {
+		for i := 0; i < 10; i++ {
+			d := b.delay(failures)
+			assert.True(t, d >= max/2 && d <= max, "%d failures: %s not in [%s, %s]", failures, d, max/2, max)
+		}
+	}
+	assert.Equal(t, time.Duration(0), backoff{}.delay(1))
+}
+
+func TestEndpoint(t *testing.T) {
+	b := backoff{base: time.Second, max: time.Second}
+	ep := &endpoint{name: "metrics"}
+	now := time.Now()
+	assert.True(t, ep.available(now))
+
+	delay := ep.failure(now, b)
+	assert.False(t, ep.available(now))
+	assert.True(t, ep.available(now.Add(delay)))
+
+	ep.success()
+	assert.True(t, ep.available(now))
+	assert.Equal(t, 0, ep.failures)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer backend.Close()
+
+	conf := config.DefaultConfig
+	f := NewForwarder(&conf)
+	f.api = api.NewAPI(backend.URL, fakeLicenseKey, 10*time.Second)
+	ep := f.endpoints["metrics"]
+
+	post := func() {
+		req := httptest.NewRequest("POST", "/infrastructure/metrics", strings.NewReader("payload"))
+		f.metricHandler(httptest.NewRecorder(), req)
+	}
+
+	// Server errors are retried, the circuit opens until the backoff delay.
+	post()
+	assert.Equal(t, 1, f.queue.Len())
+	assert.False(t, ep.available(time.Now()))
+	f.flushQueue()
+	assert.Equal(t, 1, f.queue.Len())
+	assert.Equal(t, int64(0), ep.telemetry.retries)
+
+	// Client errors are dropped.
+	f.backoff = backoff{}
+	ep.success()
+	status = http.StatusBadRequest
+	f.flushQueue()
+	assert.Equal(t, 0, f.queue.Len())
+	assert.Equal(t, int64(1), ep.telemetry.retries)
+	assert.Equal(t, int64(1), ep.telemetry.dropped)
+
+	status = http.StatusOK
+	post()
+	assert.Equal(t, 0, f.queue.Len())
+	assert.Equal(t, int64(1), ep.telemetry.posted)
+}
+
+func TestPayloadTooLarge(t *testing.T) {
+	conf := config.DefaultConfig
+	conf.GlobalConfig.MaxPayloadSize = 4
+	f := NewForwarder(&conf)
+
+	w := httptest.NewRecorder()
+	f.metricHandler(w, httptest.NewRequest("POST", "/infrastructure/metrics", strings.NewReader("too large")))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, 0, f.queue.Len())
+
+	assert.True(t, isPermanent(api.ErrPayloadTooLarge))
+}
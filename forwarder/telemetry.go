@@ -0,0 +1,117 @@
+package forwarder
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/watchdog"
+)
+
+// telemetry counts what happens to the payloads of an endpoint. The
+// counters are updated concurrently by the handlers and the retry loop.
+type telemetry struct {
+	posted  int64
+	retries int64
+	dropped int64
+
+	// last holds the counters at the previous report, it's only used by the
+	// report goroutine.
+	last [3]int64
+}
+
+func (t *telemetry) counters() [3]int64 {
+	return [3]int64{
+		atomic.LoadInt64(&t.posted),
+		atomic.LoadInt64(&t.retries),
+		atomic.LoadInt64(&t.dropped),
+	}
+}
+
+var telemetryNames = [3]string{
+	"cloudinsight.forwarder.payloads",
+	"cloudinsight.forwarder.retries",
+	"cloudinsight.forwarder.payloads_dropped",
+}
+
+// packets returns the statsd lines reporting the counts since the previous
+// call, tagged by endpoint.
+func (t *telemetry) packets(endpoint string) []string {
+	current := t.counters()
+	packets := make([]string, 0, len(telemetryNames))
+	for i, name := range telemetryNames {
+		packets = append(packets, fmt.Sprintf("%s:%d|c|#endpoint:%s", name, current[i]-t.last[i], endpoint))
+	}
+	t.last = current
+	return packets
+}
+
+// report sends the telemetry of the Forwarder to Statsd, the Forwarder doesn't
+// aggregate metrics itself.
+func (f *Forwarder) report() {
+	packets := make([]string, 0, 2*len(telemetryNames)+2)
+	for _, ep := range f.endpoints {
+		packets = append(packets, ep.telemetry.packets(ep.name)...)
+	}
+
+	dropped := f.queue.Dropped()
+	packets = append(packets,
+		fmt.Sprintf("cloudinsight.forwarder.queue_dropped:%d|c", dropped-f.lastQueueDropped),
+		fmt.Sprintf("cloudinsight.forwarder.queue_size:%d|g", f.queue.Len()),
+	)
+	f.lastQueueDropped = dropped
+
+	conn, err := net.Dial("udp", f.conf.GetStatsdAddr())
+	if err != nil {
+		log.Debugf("Unable to report the Forwarder telemetry: %s", err)
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	for _, packet := range packets {
+		if _, err := conn.Write([]byte(packet)); err != nil {
+			log.Debugf("Unable to report the Forwarder telemetry: %s", err)
+			return
+		}
+	}
+}
+
+// Stats is the state of the queue and the counters of the endpoints of the
+// Forwarder, reported by the control API.
+type Stats struct {
+	QueueSize    int   `json:"queue_size"`
+	QueueDropped int64 `json:"queue_dropped"`
+	// Offline is set when the payloads are written to the spool.
+	Offline   bool                     `json:"offline"`
+	Endpoints map[string]EndpointStats `json:"endpoints"`
+}
+
+// EndpointStats counts the payloads of an endpoint since the start.
+type EndpointStats struct {
+	Posted  int64 `json:"posted"`
+	Retries int64 `json:"retries"`
+	Dropped int64 `json:"dropped"`
+}
+
+// Stats returns the state of the Forwarder.
+func (f *Forwarder) Stats() Stats {
+	stats := Stats{
+		QueueSize:    f.queue.Len(),
+		QueueDropped: f.queue.Dropped(),
+		Offline:      f.offline,
+		Endpoints:    make(map[string]EndpointStats, len(f.endpoints)),
+	}
+	for _, ep := range f.endpoints {
+		c := ep.telemetry.counters()
+		stats.Endpoints[ep.name] = EndpointStats{Posted: c[0], Retries: c[1], Dropped: c[2]}
+	}
+	return stats
+}
+
+// Heartbeat returns the heartbeat of the retry loop of the Forwarder, which
+// only starts once it listens.
+func (f *Forwarder) Heartbeat() *watchdog.Heartbeat {
+	return &f.heartbeat
+}
@@ -0,0 +1,27 @@
+package forwarder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTelemetryPackets(t *testing.T) {
+	var tm telemetry
+	tm.posted = 3
+	tm.retries = 2
+	tm.dropped = 1
+	assert.Equal(t, []string{
+		"cloudinsight.forwarder.payloads:3|c|#endpoint:metrics",
+		"cloudinsight.forwarder.retries:2|c|#endpoint:metrics",
+		"cloudinsight.forwarder.payloads_dropped:1|c|#endpoint:metrics",
+	}, tm.packets("metrics"))
+
+	// Only the counts since the previous call are reported.
+	tm.posted = 5
+	assert.Equal(t, []string{
+		"cloudinsight.forwarder.payloads:2|c|#endpoint:metrics",
+		"cloudinsight.forwarder.retries:0|c|#endpoint:metrics",
+		"cloudinsight.forwarder.payloads_dropped:0|c|#endpoint:metrics",
+	}, tm.packets("metrics"))
+}
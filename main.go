@@ -3,65 +3,407 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/cloudinsight/cloudinsight-agent/agent"
 	"github.com/cloudinsight/cloudinsight-agent/collector"
 	_ "github.com/cloudinsight/cloudinsight-agent/collector/plugins"
 	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/limits"
 	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/secrets"
+	"github.com/cloudinsight/cloudinsight-agent/common/systemd"
+	"github.com/cloudinsight/cloudinsight-agent/common/watchdog"
+	"github.com/cloudinsight/cloudinsight-agent/connections"
+	"github.com/cloudinsight/cloudinsight-agent/control"
+	"github.com/cloudinsight/cloudinsight-agent/exporter"
+	"github.com/cloudinsight/cloudinsight-agent/flare"
 	"github.com/cloudinsight/cloudinsight-agent/forwarder"
+	"github.com/cloudinsight/cloudinsight-agent/metadata"
+	_ "github.com/cloudinsight/cloudinsight-agent/output/plugins"
+	"github.com/cloudinsight/cloudinsight-agent/processes"
+	"github.com/cloudinsight/cloudinsight-agent/remoteconfig"
 	"github.com/cloudinsight/cloudinsight-agent/statsd"
+	"github.com/cloudinsight/cloudinsight-agent/upgrade"
 )
 
 var fConfig = flag.String("config", "", "configuration file to load")
+var fOnce = flag.Bool("once", false, "run the checks once, flush and exit, e.g. from cron")
 
-func startAgent(shutdown chan struct{}, conf *config.Config) {
-	ag := agent.NewAgent(conf)
+// drainTimeout bounds the time the once mode waits for the queued payloads
+// to be posted.
+const drainTimeout = 30 * time.Second
+
+func startAgent(shutdown chan struct{}, ag *agent.Agent) {
 	err := ag.Run(shutdown)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func startForwarder(shutdown chan struct{}, conf *config.Config) {
-	f := forwarder.NewForwarder(conf)
+func startForwarder(shutdown chan struct{}, f *forwarder.Forwarder) {
 	err := f.Run(shutdown)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func startStatsd(shutdown chan struct{}, conf *config.Config) {
-	s := statsd.NewStatsd(conf)
+func startStatsd(shutdown chan struct{}, s *statsd.Statsd) {
+	err := s.Run(shutdown)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func startExporter(shutdown chan struct{}, conf *config.Config) {
+	e := exporter.NewExporter(conf)
+	err := e.Run(shutdown)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func startMetadata(shutdown chan struct{}, conf *config.Config) {
+	c := metadata.NewCollector(conf)
+	c.Run(shutdown)
+}
+
+func startProcesses(shutdown chan struct{}, conf *config.Config) {
+	c := processes.NewCollector(conf)
+	c.Run(shutdown)
+}
+
+func startConnections(shutdown chan struct{}, conf *config.Config) {
+	c := connections.NewCollector(conf)
+	c.Run(shutdown)
+}
+
+func startControl(shutdown chan struct{}, s *control.Server) {
 	err := s.Run(shutdown)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
+func startRemoteConfig(shutdown chan struct{}, conf *config.Config, reload func()) {
+	p := remoteconfig.NewPoller(conf)
+	p.Run(shutdown, reload)
+}
+
+// runOnce runs the checks once, flushes their metrics through the Forwarder
+// and waits for it to post them. It returns the exit status, 1 when a check
+// or the flush failed, or when payloads are left in the queue.
+func runOnce() int {
+	conf, err := config.NewConfig(*fConfig)
+	if err != nil {
+		log.Fatalf("failed to load config: %s", err)
+	}
+	if err = conf.InitializeLogging(); err != nil {
+		log.Fatal(err)
+	}
+	if err = conf.InitializeProxy(); err != nil {
+		log.Fatal(err)
+	}
+	limits.Apply(conf.Limits())
+
+	shutdown := make(chan struct{})
+	fw := forwarder.NewForwarder(conf)
+	go func() {
+		if err := fw.Run(shutdown); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	defer close(shutdown)
+	// The retry loop starts once the Forwarder listens.
+	for fw.Heartbeat().Last().IsZero() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	status := 0
+	if err = agent.NewAgent(conf).RunOnce(conf.GetCollectInterval()); err != nil {
+		log.Errorf("The run failed:\n%s", err)
+		status = 1
+	}
+	if err = fw.Drain(drainTimeout); err != nil {
+		log.Error(err)
+		status = 1
+	}
+	return status
+}
+
+// runImport uploads the payloads spooled in dir, see the spool_path option.
+func runImport(dir string) {
+	conf, err := config.NewConfig(*fConfig)
+	if err != nil {
+		log.Fatalf("failed to load config: %s", err)
+	}
+	if err = conf.InitializeProxy(); err != nil {
+		log.Fatal(err)
+	}
+
+	imported, err := forwarder.Import(conf, dir)
+	log.Infof("Imported %d payloads from %s", imported, dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runHostname prints the hostname of the agent and the option it comes from.
+func runHostname() {
+	conf, err := config.NewConfig(*fConfig)
+	if err != nil {
+		log.Fatalf("failed to load config: %s", err)
+	}
+
+	r, err := conf.ResolveHostname()
+	for _, source := range config.HostnameSources {
+		if reason, ok := r.Skipped[source]; ok {
+			fmt.Printf("Skipped %s: %s\n", source, reason)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Hostname: %s\nSource: %s\n", r.Hostname, r.Source)
+}
+
+// runStatus prints the state of the running agent, from its control API.
+func runStatus() {
+	conf, err := config.NewConfig(*fConfig)
+	if err != nil {
+		log.Fatalf("failed to load config: %s", err)
+	}
+
+	c, err := control.NewClient(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = control.WriteStatus(os.Stdout, c, time.Now()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runFlare creates the flare of the agent, and uploads it when asked to.
+func runFlare(args []string) {
+	fs := flag.NewFlagSet("flare", flag.ExitOnError)
+	upload := fs.Bool("upload", false, "upload the flare to the Cloudinsight support")
+	caseID := fs.String("case", "", "the support case the flare is for")
+	email := fs.String("email", "", "the email of the user sending the flare")
+	profile := fs.Duration("profile", 5*time.Second, "the duration of the CPU profile of the running agent, 0 to skip it")
+	_ = fs.Parse(args)
+
+	confPath, err := config.Path(*fConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	conf, err := config.NewConfig(confPath)
+	if err != nil {
+		log.Fatalf("failed to load config: %s", err)
+	}
+	if err = conf.InitializeProxy(); err != nil {
+		log.Fatal(err)
+	}
+
+	path, err := flare.Create(conf, flare.Options{ConfigPath: confPath, ProfileDuration: *profile}, os.TempDir(), time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Flare written to %s\n", path)
+
+	if *upload {
+		if err = flare.Upload(conf, path, *caseID, *email); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Flare uploaded")
+	}
+}
+
+// runUpgrade installs the latest release of the agent and restarts its
+// service.
+func runUpgrade(args []string) {
+	fs := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	check := fs.Bool("check", false, "only print the latest release")
+	force := fs.Bool("force", false, "install the latest release even if it isn't newer than the running version")
+	restart := fs.Bool("restart", true, "restart the service of the agent once upgraded")
+	service := fs.String("service", serviceName, "the name of the service of the agent")
+	_ = fs.Parse(args)
+
+	conf, err := config.NewConfig(*fConfig)
+	if err != nil {
+		log.Fatalf("failed to load config: %s", err)
+	}
+	if err = conf.InitializeProxy(); err != nil {
+		log.Fatal(err)
+	}
+	u, err := upgrade.New(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	u.Force = *force
+
+	r, err := u.Latest()
+	if err != nil {
+		log.Fatalf("failed to check the latest release: %s", err)
+	}
+	if upgrade.CompareVersions(r.Version, config.VERSION) > 0 {
+		fmt.Printf("Release %s is available, running %s\n", r.Version, config.VERSION)
+	} else {
+		fmt.Printf("The agent is up to date, running %s, the latest release is %s\n", config.VERSION, r.Version)
+		if !*force {
+			return
+		}
+	}
+	if *check {
+		return
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if executable, err = filepath.EvalSymlinks(executable); err != nil {
+		log.Fatal(err)
+	}
+	if err = u.Install(r, executable); err != nil {
+		log.Fatalf("failed to upgrade: %s", err)
+	}
+	fmt.Printf("Installed release %s to %s\n", r.Version, executable)
+
+	if !*restart {
+		return
+	}
+	restarted, err := upgrade.Restart(*service)
+	if err != nil {
+		log.Fatalf("failed to restart the %s service: %s", *service, err)
+	}
+	if restarted {
+		fmt.Printf("Restarted the %s service\n", *service)
+	} else {
+		fmt.Println("Restart the agent to run the new release")
+	}
+}
+
+// runSecretEncrypt prints the ENC[...] value of the secret read on the
+// standard input, the key file is created if it doesn't exist.
+func runSecretEncrypt() {
+	conf, err := config.NewConfig(*fConfig)
+	if err != nil {
+		log.Fatalf("failed to load config: %s", err)
+	}
+
+	keyFile := conf.GetSecretKeyFile()
+	if _, err = os.Stat(keyFile); os.IsNotExist(err) {
+		if err = secrets.GenerateKey(keyFile); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "Created the key file %s\n", keyFile)
+	}
+
+	secret, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keyring := &secrets.Keyring{Path: keyFile}
+	value, err := keyring.Encrypt(strings.TrimRight(string(secret), "\r\n"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(value)
+}
+
 func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %[1]s [options]\n       %[1]s [options] import <dir>\n       %[1]s [options] hostname\n       %[1]s [options] status\n       %[1]s [options] flare [-upload] [-case id] [-email address] [-profile duration]\n       %[1]s [options] secret encrypt < secret\n       %[1]s [options] install-service|remove-service\n       %[1]s [options] upgrade [-check] [-force] [-restart=false] [-service name]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	switch flag.Arg(0) {
+	case "":
+	case "import":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		runImport(flag.Arg(1))
+		return
+	case "hostname":
+		runHostname()
+		return
+	case "status":
+		runStatus()
+		return
+	case "flare":
+		runFlare(flag.Args()[1:])
+		return
+	case "upgrade":
+		runUpgrade(flag.Args()[1:])
+		return
+	case "install-service":
+		runInstallService()
+		return
+	case "remove-service":
+		runRemoveService()
+		return
+	case "service":
+		runService()
+		return
+	case "secret":
+		if flag.NArg() != 2 || flag.Arg(1) != "encrypt" {
+			flag.Usage()
+			os.Exit(2)
+		}
+		runSecretEncrypt()
+		return
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dropPrivileges()
+	if *fOnce {
+		os.Exit(runOnce())
+	}
+	run(nil)
+}
+
+// notifySystemd sends state to systemd when it runs the agent.
+func notifySystemd(state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		log.Debugf("Failed to notify systemd: %s", err)
+	}
+}
+
+// run runs the agent until it's interrupted or stop is closed, it's
+// restarted with the new configuration on every reload.
+func run(stop <-chan struct{}) {
 	reload := make(chan bool, 1)
 	reload <- true
 	for <-reload {
 		reload <- false
-		flag.Parse()
 
 		shutdown := make(chan struct{})
-		signals := make(chan os.Signal)
-		signal.Notify(signals, os.Interrupt, syscall.SIGHUP)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 		go func() {
 			select {
+			case <-stop:
+				notifySystemd("STOPPING=1")
+				close(shutdown)
 			case sig := <-signals:
-				if sig == os.Interrupt {
+				if sig == os.Interrupt || sig == syscall.SIGTERM {
+					notifySystemd("STOPPING=1")
 					close(shutdown)
 				}
 				if sig == syscall.SIGHUP {
 					log.Infof("Reloading config...")
+					notifySystemd("RELOADING=1")
 					<-reload
 					reload <- true
 					close(shutdown)
@@ -79,6 +421,10 @@ func main() {
 			log.Fatal(err)
 		}
 
+		if err = conf.InitializeProxy(); err != nil {
+			log.Fatal(err)
+		}
+
 		fmt.Println("Available Plugins:")
 		for k := range collector.Plugins {
 			fmt.Printf("  %s\n", k)
@@ -86,25 +432,118 @@ func main() {
 
 		log.Infof("Loaded plugins: %s", strings.Join(conf.PluginNames(), " "))
 
+		// Reloads like a SIGHUP does.
+		reloadConfig := func() {
+			select {
+			case signals <- syscall.SIGHUP:
+			default:
+			}
+		}
+
+		limits.Apply(conf.Limits())
+
+		ag := agent.NewAgent(conf)
+		fw := forwarder.NewForwarder(conf)
+		sd := statsd.NewStatsd(conf)
+
+		wd := watchdog.New()
+		wd.Register("collector", ag.Heartbeat(), watchdog.Timeout(conf.GetCollectInterval()))
+		for name, h := range ag.PipelineHeartbeats() {
+			wd.Register("collector/"+name, h, watchdog.Timeout(conf.GetCollectInterval()))
+		}
+		wd.Register("statsd", sd.Heartbeat(), watchdog.Timeout(conf.GetStatsdFlushInterval()))
+		wd.Register("forwarder", fw.Heartbeat(), watchdog.MinTimeout)
+
 		var wg sync.WaitGroup
-		wg.Add(3)
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+
+			systemd.Run(shutdown, wd.Check)
+		}()
+
 		go func() {
 			defer wg.Done()
 
-			startAgent(shutdown, conf)
+			startAgent(shutdown, ag)
 		}()
 
 		go func() {
 			defer wg.Done()
 
-			startForwarder(shutdown, conf)
+			startForwarder(shutdown, fw)
 		}()
 
 		go func() {
 			defer wg.Done()
 
-			startStatsd(shutdown, conf)
+			startStatsd(shutdown, sd)
 		}()
+
+		if limit := conf.GlobalConfig.MemoryLimit; limit > 0 {
+			monitor := limits.NewMonitor(uint64(limit) << 20)
+			sd.Shedding = monitor.Exceeded
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				monitor.Run(shutdown)
+			}()
+		}
+
+		if conf.GlobalConfig.RemoteConfigURL != "" {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				startRemoteConfig(shutdown, conf, reloadConfig)
+			}()
+		}
+
+		if conf.GlobalConfig.HostMetadata && conf.GlobalConfig.CloudinsightOutput {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				startMetadata(shutdown, conf)
+			}()
+		}
+
+		if conf.GlobalConfig.ProcessInventory && conf.GlobalConfig.CloudinsightOutput {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				startProcesses(shutdown, conf)
+			}()
+		}
+
+		if conf.GlobalConfig.NetworkConnections && conf.GlobalConfig.CloudinsightOutput {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				startConnections(shutdown, conf)
+			}()
+		}
+
+		if conf.GlobalConfig.ControlSocket != "" || conf.GlobalConfig.ControlPort != 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				startControl(shutdown, control.NewServer(conf, ag, fw, wd, reloadConfig))
+			}()
+		}
+
+		if conf.GlobalConfig.PrometheusPort != 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				startExporter(shutdown, conf)
+			}()
+		}
 		wg.Wait()
 	}
 }
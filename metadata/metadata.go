@@ -0,0 +1,211 @@
+// Package metadata periodically posts the facts of the host, its operating
+// system, CPUs, memory and addresses, the version of the agent, the cloud
+// instance and the tags, in a payload of their own for the host map of
+// Cloudinsight.
+package metadata
+
+import (
+	"net"
+	"runtime"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/api"
+	"github.com/cloudinsight/cloudinsight-agent/common/cloud"
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/host"
+	"github.com/shirou/gopsutil/mem"
+)
+
+// Payload is the host metadata payload.
+type Payload struct {
+	Hostname     string `json:"hostname"`
+	Timestamp    int64  `json:"timestamp"`
+	AgentVersion string `json:"agent_version"`
+	OS           OS     `json:"os"`
+	CPU          CPU    `json:"cpu"`
+	Memory       Memory `json:"memory"`
+	// IPAddresses are the addresses of the interfaces which are up, but
+	// the loopback and the link-local ones.
+	IPAddresses []string `json:"ip_addresses"`
+	// Cloud is nil unless cloud_metadata is set and the host is a cloud
+	// instance.
+	Cloud *cloud.Metadata `json:"cloud,omitempty"`
+	// Tags are the tags of the pipeline the payload is posted for.
+	Tags []string `json:"tags"`
+}
+
+// OS describes the operating system of the host.
+type OS struct {
+	Name            string `json:"name"`
+	Arch            string `json:"arch"`
+	Platform        string `json:"platform"`
+	PlatformFamily  string `json:"platform_family"`
+	PlatformVersion string `json:"platform_version"`
+	KernelVersion   string `json:"kernel_version"`
+	// BootTime is the boot time of the host, in seconds since the epoch.
+	BootTime uint64 `json:"boot_time"`
+}
+
+// CPU describes the processors of the host.
+type CPU struct {
+	Model        string  `json:"model"`
+	Cores        int     `json:"cores"`
+	LogicalCores int     `json:"logical_cores"`
+	MHz          float64 `json:"mhz"`
+}
+
+// Memory is the memory of the host, in bytes.
+type Memory struct {
+	Total     uint64 `json:"total"`
+	SwapTotal uint64 `json:"swap_total"`
+}
+
+// Collect gathers the facts of the host, the ones which can't be read are
+// left empty.
+func Collect(conf *config.Config) *Payload {
+	p := &Payload{
+		Hostname:     conf.GetHostname(),
+		Timestamp:    time.Now().Unix(),
+		AgentVersion: config.VERSION,
+		OS:           OS{Name: runtime.GOOS, Arch: runtime.GOARCH},
+		CPU:          CPU{LogicalCores: runtime.NumCPU()},
+		IPAddresses:  ipAddresses(),
+		Tags:         conf.GlobalConfig.Tags,
+	}
+
+	if info, err := host.Info(); err == nil {
+		p.OS.Platform = info.Platform
+		p.OS.PlatformFamily = info.PlatformFamily
+		p.OS.PlatformVersion = info.PlatformVersion
+		p.OS.KernelVersion = info.KernelVersion
+		p.OS.BootTime = info.BootTime
+	} else {
+		log.Debugf("Failed to read the host information: %s", err)
+	}
+
+	if infos, err := cpu.Info(); err == nil && len(infos) > 0 {
+		p.CPU.Model = infos[0].ModelName
+		p.CPU.MHz = infos[0].Mhz
+		p.CPU.Cores = physicalCores(infos)
+	} else if err != nil {
+		log.Debugf("Failed to read the CPU information: %s", err)
+	}
+
+	if vm, err := mem.VirtualMemory(); err == nil {
+		p.Memory.Total = vm.Total
+	} else {
+		log.Debugf("Failed to read the memory information: %s", err)
+	}
+	if swap, err := mem.SwapMemory(); err == nil {
+		p.Memory.SwapTotal = swap.Total
+	}
+
+	if conf.GlobalConfig.CloudMetadata {
+		p.Cloud = cloud.Detect(cloud.DefaultTimeout)
+	}
+	return p
+}
+
+// physicalCores counts the distinct cores of infos, which has an entry per
+// logical CPU on Linux, or sums the cores of the processors elsewhere.
+func physicalCores(infos []cpu.InfoStat) int {
+	cores := make(map[string]bool)
+	sum := 0
+	for _, info := range infos {
+		if info.CoreID != "" {
+			cores[info.PhysicalID+"/"+info.CoreID] = true
+		}
+		sum += int(info.Cores)
+	}
+	if len(cores) > 0 {
+		return len(cores)
+	}
+	return sum
+}
+
+func ipAddresses() []string {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		log.Debugf("Failed to list the network interfaces: %s", err)
+		return nil
+	}
+
+	ips := []string{}
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			ips = append(ips, ipNet.IP.String())
+		}
+	}
+	return ips
+}
+
+// NewCollector creates a new instance of Collector, it posts the payload of
+// every pipeline of conf with its license key and tags.
+func NewCollector(conf *config.Config) *Collector {
+	c := &Collector{conf: conf}
+	for _, pc := range conf.SplitPipelines() {
+		a := api.NewAPI(conf.GetForwarderAddrWithScheme(), pc.GlobalConfig.LicenseKey, 10*time.Second)
+		a.CompressionLevel = conf.GlobalConfig.CompressionLevel
+		a.Format = conf.GlobalConfig.PayloadFormat
+		c.targets = append(c.targets, target{api: a, tags: pc.GlobalConfig.Tags})
+	}
+	return c
+}
+
+// Collector posts the host metadata payload to the Forwarder.
+type Collector struct {
+	conf    *config.Config
+	targets []target
+}
+
+type target struct {
+	api  *api.API
+	tags []string
+}
+
+// Post collects the host metadata and posts it for every pipeline.
+func (c *Collector) Post() error {
+	p := Collect(c.conf)
+	var firstErr error
+	for _, t := range c.targets {
+		payload := *p
+		payload.Tags = t.tags
+		if err := t.api.SubmitHostMetadata(&payload); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Run posts the host metadata every host_metadata_interval until shutdown is
+// closed.
+func (c *Collector) Run(shutdown chan struct{}) {
+	ticker := time.NewTicker(c.conf.GetHostMetadataInterval())
+	defer ticker.Stop()
+	for {
+		if err := c.Post(); err != nil {
+			log.Warnf("Failed to post the host metadata: %s", err)
+		} else {
+			log.Debug("Posted the host metadata")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-shutdown:
+			return
+		}
+	}
+}
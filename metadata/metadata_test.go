@@ -0,0 +1,82 @@
+package metadata
+
+import (
+	"compress/zlib"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollect(t *testing.T) {
+	conf := &config.Config{GlobalConfig: config.DefaultGlobalConfig}
+	conf.GlobalConfig.Hostname = "web-1"
+	conf.GlobalConfig.Tags = config.TagList{"role:web"}
+	conf.GlobalConfig.CloudMetadata = false
+
+	p := Collect(conf)
+	assert.Equal(t, "web-1", p.Hostname)
+	assert.Equal(t, config.VERSION, p.AgentVersion)
+	assert.NotEmpty(t, p.OS.Name)
+	assert.True(t, p.CPU.LogicalCores > 0)
+	assert.Equal(t, []string{"role:web"}, p.Tags)
+	assert.Nil(t, p.Cloud)
+	for _, ip := range p.IPAddresses {
+		assert.False(t, net.ParseIP(ip).IsLoopback(), ip)
+	}
+}
+
+func TestPhysicalCores(t *testing.T) {
+	// Linux, 2 hyperthreads per core.
+	assert.Equal(t, 2, physicalCores([]cpu.InfoStat{
+		{PhysicalID: "0", CoreID: "0", Cores: 1},
+		{PhysicalID: "0", CoreID: "1", Cores: 1},
+		{PhysicalID: "0", CoreID: "0", Cores: 1},
+		{PhysicalID: "0", CoreID: "1", Cores: 1},
+	}))
+	// Windows, a processor per socket.
+	assert.Equal(t, 8, physicalCores([]cpu.InfoStat{{Cores: 4}, {Cores: 4}}))
+}
+
+func TestPost(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received = make(map[string]Payload)
+	)
+	forwarder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/infrastructure/metadata", r.URL.Path)
+		zr, err := zlib.NewReader(r.Body)
+		require.NoError(t, err)
+		var p Payload
+		require.NoError(t, json.NewDecoder(zr).Decode(&p))
+		mu.Lock()
+		received[r.URL.Query().Get("license_key")] = p
+		mu.Unlock()
+	}))
+	defer forwarder.Close()
+
+	host, port, err := net.SplitHostPort(forwarder.Listener.Addr().String())
+	require.NoError(t, err)
+	conf := &config.Config{GlobalConfig: config.DefaultGlobalConfig}
+	conf.GlobalConfig.BindHost = host
+	conf.GlobalConfig.ListenPort, _ = strconv.Atoi(port)
+	conf.GlobalConfig.Hostname = "web-1"
+	conf.GlobalConfig.LicenseKey = "main"
+	conf.GlobalConfig.Tags = config.TagList{"role:web"}
+	conf.GlobalConfig.CloudMetadata = false
+	conf.Pipelines = []config.PipelineConfig{{Name: "team", LicenseKey: "team-key", Tags: config.TagList{"team:db"}}}
+
+	require.NoError(t, NewCollector(conf).Post())
+	require.Len(t, received, 2)
+	assert.Equal(t, []string{"role:web"}, received["main"].Tags)
+	assert.Equal(t, []string{"team:db"}, received["team-key"].Tags)
+	assert.Equal(t, "web-1", received["team-key"].Hostname)
+}
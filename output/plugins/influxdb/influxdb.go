@@ -0,0 +1,137 @@
+// Package influxdb writes the metrics to InfluxDB, in the line protocol.
+package influxdb
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/output"
+)
+
+// Config is the [[output.influxdb]] section of the agent configuration.
+type Config struct {
+	URL             string `toml:"url"`
+	Database        string `toml:"database"`
+	RetentionPolicy string `toml:"retention_policy"`
+	plugin.HTTPConfig
+}
+
+// NewInfluxDB creates the output.
+func NewInfluxDB(decode func(v interface{}) error) (output.Output, error) {
+	i := &InfluxDB{}
+	if err := decode(&i.conf); err != nil {
+		return nil, err
+	}
+
+	if i.conf.URL == "" {
+		return nil, fmt.Errorf("influxdb: url must be specified")
+	}
+	if i.conf.Database == "" {
+		return nil, fmt.Errorf("influxdb: database must be specified")
+	}
+	u, err := url.Parse(strings.TrimSuffix(i.conf.URL, "/") + "/write")
+	if err != nil {
+		return nil, fmt.Errorf("influxdb: invalid url %s: %s", i.conf.URL, err)
+	}
+	params := url.Values{}
+	params.Set("db", i.conf.Database)
+	if i.conf.RetentionPolicy != "" {
+		params.Set("rp", i.conf.RetentionPolicy)
+	}
+	// The timestamps of the metrics are in seconds.
+	params.Set("precision", "s")
+	u.RawQuery = params.Encode()
+	i.writeURL = u.String()
+
+	if _, err = i.conf.TLSConfig(); err != nil {
+		return nil, fmt.Errorf("influxdb: %s", err)
+	}
+	return i, nil
+}
+
+// InfluxDB posts the metrics to the /write endpoint, the metric name is the
+// measurement, the value is the "value" field.
+type InfluxDB struct {
+	conf     Config
+	writeURL string
+}
+
+// Write posts the metrics in a single request.
+func (i *InfluxDB) Write(metrics []metric.Metric) error {
+	var body bytes.Buffer
+	for n := range metrics {
+		writeLine(&body, &metrics[n])
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+
+	req, err := i.conf.NewRequest("POST", i.writeURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := i.conf.Do(req)
+	if err != nil {
+		return fmt.Errorf("influxdb: %s", err)
+	}
+	return resp.Body.Close()
+}
+
+// Connect does nothing, every Write sends its own request.
+func (i *InfluxDB) Connect() error {
+	return nil
+}
+
+// Close does nothing, the connections aren't kept.
+func (i *InfluxDB) Close() error {
+	return nil
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", " ")
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", " ")
+)
+
+// writeLine writes m in the line protocol, the metrics without a numeric
+// value are skipped.
+func writeLine(w *bytes.Buffer, m *metric.Metric) {
+	value, err := m.FloatValue()
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+
+	w.WriteString(measurementEscaper.Replace(m.Name))
+
+	tags := output.TagMap(m)
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	// InfluxDB performs better with the tags sorted by key.
+	sort.Strings(keys)
+	for _, key := range keys {
+		w.WriteByte(',')
+		w.WriteString(tagEscaper.Replace(key))
+		w.WriteByte('=')
+		w.WriteString(tagEscaper.Replace(tags[key]))
+	}
+
+	w.WriteString(" value=")
+	w.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	w.WriteByte(' ')
+	w.WriteString(strconv.FormatInt(m.Timestamp, 10))
+	w.WriteByte('\n')
+}
+
+func init() {
+	output.Add("influxdb", NewInfluxDB)
+}
@@ -0,0 +1,100 @@
+package influxdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decoder(conf string) func(v interface{}) error {
+	return func(v interface{}) error {
+		_, err := toml.Decode(conf, v)
+		return err
+	}
+}
+
+func TestWriteLine(t *testing.T) {
+	m := metric.NewMetric("nginx.net.requests per_s", 12.5, []string{"role:web,front", "prod", "a=b:c d"})
+	m.Hostname = "host1"
+	m.DeviceName = "eth0"
+	m.Timestamp = 1500000000
+
+	var w bytes.Buffer
+	writeLine(&w, &m)
+	assert.Equal(t, `nginx.net.requests\ per_s,a\=b=c\ d,device=eth0,host=host1,prod=true,role=web\,front value=12.5 1500000000`+"\n", w.String())
+
+	w.Reset()
+	m = metric.NewMetric("system.load.1", math.NaN())
+	writeLine(&w, &m)
+	m = metric.NewMetric("system.load.1", "high")
+	writeLine(&w, &m)
+	assert.Empty(t, w.String())
+}
+
+func TestWrite(t *testing.T) {
+	var query, body, user string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/write", r.URL.Path)
+		query = r.URL.RawQuery
+		user, _, _ = r.BasicAuth()
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	o, err := NewInfluxDB(decoder(`
+url = "` + ts.URL + `/"
+database = "metrics"
+retention_policy = "week"
+username = "agent"
+password = "secret"
+`))
+	require.NoError(t, err)
+	defer o.Close()
+
+	m1 := metric.NewMetric("system.load.1", 1)
+	m1.Timestamp = 1500000000
+	m2 := metric.NewMetric("system.load.5", int64(2), []string{"env:prod"})
+	m2.Timestamp = 1500000000
+	require.NoError(t, o.Write([]metric.Metric{m1, m2}))
+
+	assert.Equal(t, "db=metrics&precision=s&rp=week", query)
+	assert.Equal(t, "agent", user)
+	assert.Equal(t, "system.load.1 value=1 1500000000\nsystem.load.5,env=prod value=2 1500000000\n", body)
+}
+
+func TestWriteError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"database not found"}`, http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	o, err := NewInfluxDB(decoder(`
+url = "` + ts.URL + `"
+database = "metrics"
+`))
+	require.NoError(t, err)
+	assert.Error(t, o.Write([]metric.Metric{metric.NewMetric("system.load.1", 1)}))
+}
+
+func TestConfigErrors(t *testing.T) {
+	for _, conf := range []string{
+		`database = "metrics"`,
+		`url = "http://localhost:8086"`,
+		`url = "http://localhost:8086"
+database = "metrics"
+ca_certs = "missing.pem"`,
+	} {
+		_, err := NewInfluxDB(decoder(conf))
+		assert.Error(t, err, conf)
+	}
+}
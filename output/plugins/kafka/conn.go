@@ -0,0 +1,125 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// maxResponseSize bounds the responses read from the brokers, the producer
+// only reads small metadata and produce responses.
+const maxResponseSize = 16 * 1024 * 1024
+
+// conn is a connection to a broker, the requests are sent one at a time.
+type conn struct {
+	net.Conn
+
+	clientID      string
+	correlationID int32
+	timeout       time.Duration
+}
+
+// dial connects to addr, over TLS when tlsConfig is set, and authenticates
+// with SASL PLAIN when username is set.
+func dial(addr string, conf *Config, tlsConfig *tls.Config) (*conn, error) {
+	timeout := conf.timeout()
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var nc net.Conn
+	var err error
+	if tlsConfig != nil {
+		nc, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		nc, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conn{Conn: nc, clientID: conf.clientID(), timeout: timeout}
+	if conf.SASLUsername != "" {
+		if err = c.authenticate(conf.SASLUsername, conf.SASLPassword); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// request sends a request and returns the body of the response, nil when
+// none is expected.
+func (c *conn) request(apiKey, version int16, body []byte, expectResponse bool) ([]byte, error) {
+	c.correlationID++
+
+	var e encoder
+	e.int32(0) // size, set below
+	e.int16(apiKey)
+	e.int16(version)
+	e.int32(c.correlationID)
+	e.string(c.clientID)
+	e.Write(body)
+	frame := e.Bytes()
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+
+	if err := c.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := c.Write(frame); err != nil {
+		return nil, err
+	}
+	if !expectResponse {
+		return nil, nil
+	}
+
+	var header [8]byte
+	if _, err := io.ReadFull(c, header[:]); err != nil {
+		return nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(header[:4]))
+	if size < 4 || size > maxResponseSize {
+		return nil, fmt.Errorf("invalid response size %d", size)
+	}
+	if id := int32(binary.BigEndian.Uint32(header[4:])); id != c.correlationID {
+		return nil, fmt.Errorf("unexpected correlation id %d, expected %d", id, c.correlationID)
+	}
+
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(c, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// authenticate runs the SASL PLAIN exchange.
+func (c *conn) authenticate(username, password string) error {
+	var handshake encoder
+	handshake.string("PLAIN")
+	resp, err := c.request(apiSaslHandshake, saslHandshakeVersion, handshake.Bytes(), true)
+	if err != nil {
+		return fmt.Errorf("SASL handshake failed: %s", err)
+	}
+	d := &decoder{b: resp}
+	if code := kafkaError(d.int16()); code != 0 {
+		return fmt.Errorf("SASL PLAIN isn't enabled on the broker: %s", code)
+	}
+
+	var auth encoder
+	auth.bytes([]byte("\x00" + username + "\x00" + password))
+	resp, err = c.request(apiSaslAuthenticate, saslAuthenticateVersion, auth.Bytes(), true)
+	if err != nil {
+		return fmt.Errorf("SASL authentication failed: %s", err)
+	}
+	d = &decoder{b: resp}
+	code := kafkaError(d.int16())
+	message := d.string()
+	if d.err != nil {
+		return fmt.Errorf("SASL authentication failed: %s", d.err)
+	}
+	if code != 0 {
+		return fmt.Errorf("SASL authentication failed: %s %s", code, message)
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+// Package kafka publishes the metrics to a Kafka topic, as JSON batches.
+package kafka
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/output"
+)
+
+const (
+	defaultClientID = "cloudinsight-agent"
+	defaultTimeout  = 10
+)
+
+// Config is the [[output.kafka]] section of the agent configuration.
+type Config struct {
+	Brokers  []string `toml:"brokers"`
+	Topic    string   `toml:"topic"`
+	ClientID string   `toml:"client_id"`
+	// Partitioner is "hash" to send the metrics with the same name to the
+	// same partition, "round_robin" or "random" to send every batch to a
+	// partition in turn or at random.
+	Partitioner string `toml:"partitioner"`
+	// RequiredAcks is the number of acknowledgements the leader waits for,
+	// -1 for all the in-sync replicas, 0 for none. It defaults to 1.
+	RequiredAcks *int `toml:"required_acks"`
+	// Timeout is the number of seconds to wait for the brokers.
+	Timeout int `toml:"timeout"`
+
+	TLS                   bool   `toml:"tls"`
+	TLSCACerts            string `toml:"tls_ca_certs"`
+	TLSClientCert         string `toml:"tls_client_cert"`
+	TLSClientKey          string `toml:"tls_client_key"`
+	TLSInsecureSkipVerify bool   `toml:"tls_insecure_skip_verify"`
+
+	SASLUsername string `toml:"sasl_username"`
+	SASLPassword string `toml:"sasl_password"`
+}
+
+func (c *Config) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return defaultTimeout * time.Second
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
+func (c *Config) clientID() string {
+	if c.ClientID == "" {
+		return defaultClientID
+	}
+	return c.ClientID
+}
+
+func (c *Config) acks() int16 {
+	if c.RequiredAcks == nil {
+		return 1
+	}
+	return int16(*c.RequiredAcks)
+}
+
+// NewKafka creates the output, it connects to the brokers in Connect.
+func NewKafka(decode func(v interface{}) error) (output.Output, error) {
+	k := &Kafka{
+		conns: make(map[int32]*conn),
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if err := decode(&k.conf); err != nil {
+		return nil, err
+	}
+
+	if len(k.conf.Brokers) == 0 {
+		return nil, fmt.Errorf("kafka: brokers must be specified")
+	}
+	if k.conf.Topic == "" {
+		return nil, fmt.Errorf("kafka: topic must be specified")
+	}
+	switch k.conf.Partitioner {
+	case "":
+		k.conf.Partitioner = "hash"
+	case "hash", "round_robin", "random":
+	default:
+		return nil, fmt.Errorf("kafka: unknown partitioner %s", k.conf.Partitioner)
+	}
+	if acks := k.conf.acks(); acks < -1 {
+		return nil, fmt.Errorf("kafka: invalid required_acks %d", acks)
+	}
+
+	if k.conf.TLS {
+		httpConfig := plugin.HTTPConfig{
+			CACerts:              k.conf.TLSCACerts,
+			ClientCert:           k.conf.TLSClientCert,
+			ClientKey:            k.conf.TLSClientKey,
+			DisableSSLValidation: k.conf.TLSInsecureSkipVerify,
+		}
+		tlsConfig, err := httpConfig.TLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("kafka: %s", err)
+		}
+		k.tlsConfig = tlsConfig
+	}
+	return k, nil
+}
+
+// Kafka publishes every batch as JSON messages, one per partition.
+type Kafka struct {
+	conf      Config
+	tlsConfig *tls.Config
+
+	// metadata is fetched again after the errors, it's nil until then.
+	metadata *metadata
+	conns    map[int32]*conn
+	next     int
+	rand     *rand.Rand
+}
+
+type jsonMetric struct {
+	Name       string   `json:"metric"`
+	Value      float64  `json:"value"`
+	Timestamp  int64    `json:"timestamp"`
+	Tags       []string `json:"tags,omitempty"`
+	Host       string   `json:"host,omitempty"`
+	DeviceName string   `json:"device_name,omitempty"`
+	Type       string   `json:"type,omitempty"`
+}
+
+// Connect fetches the partitions of the topic.
+func (k *Kafka) Connect() error {
+	return k.refreshMetadata()
+}
+
+// Write sends the metrics to the leaders of their partitions.
+func (k *Kafka) Write(metrics []metric.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	if err := k.refreshMetadata(); err != nil {
+		return err
+	}
+
+	// The batches by leader then by partition.
+	batches := make(map[int32]map[int32][]byte)
+	now := time.Now()
+	for p, metrics := range k.partition(metrics) {
+		value, err := encodeMetrics(metrics)
+		if err != nil {
+			return err
+		}
+		if batches[p.leader] == nil {
+			batches[p.leader] = make(map[int32][]byte)
+		}
+		batches[p.leader][p.id] = encodeRecordBatch([][]byte{value}, now)
+	}
+
+	acks := k.conf.acks()
+	for leader, partitions := range batches {
+		c, err := k.conn(leader)
+		if err != nil {
+			k.metadata = nil
+			return err
+		}
+
+		req := encodeProduceRequest(k.conf.Topic, acks, k.conf.timeout(), partitions)
+		resp, err := c.request(apiProduce, produceVersion, req, acks != 0)
+		if err != nil {
+			k.closeConn(leader)
+			k.metadata = nil
+			return fmt.Errorf("kafka: unable to produce to broker %d: %s", leader, err)
+		}
+		if acks == 0 {
+			continue
+		}
+		if err = decodeProduceResponse(resp); err != nil {
+			if perr, ok := err.(partitionError); ok && perr.retriable() {
+				k.metadata = nil
+			}
+			return fmt.Errorf("kafka: unable to produce to topic %s: %s", k.conf.Topic, err)
+		}
+	}
+	return nil
+}
+
+// partition groups the metrics by partition.
+func (k *Kafka) partition(metrics []metric.Metric) map[partition][]metric.Metric {
+	partitions := k.metadata.partitions
+	groups := make(map[partition][]metric.Metric)
+
+	switch k.conf.Partitioner {
+	case "round_robin":
+		k.next = (k.next + 1) % len(partitions)
+		groups[partitions[k.next]] = metrics
+	case "random":
+		groups[partitions[k.rand.Intn(len(partitions))]] = metrics
+	default:
+		for _, m := range metrics {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(m.Name))
+			p := partitions[h.Sum32()%uint32(len(partitions))]
+			groups[p] = append(groups[p], m)
+		}
+	}
+	return groups
+}
+
+func encodeMetrics(metrics []metric.Metric) ([]byte, error) {
+	batch := make([]jsonMetric, 0, len(metrics))
+	for _, m := range metrics {
+		value, err := m.FloatValue()
+		if err != nil {
+			continue
+		}
+		batch = append(batch, jsonMetric{
+			Name:       m.Name,
+			Value:      value,
+			Timestamp:  m.Timestamp,
+			Tags:       m.Tags,
+			Host:       m.Hostname,
+			DeviceName: m.DeviceName,
+			Type:       m.Type,
+		})
+	}
+	return json.Marshal(batch)
+}
+
+// refreshMetadata fetches the partitions of the topic and their leaders from
+// the first broker answering.
+func (k *Kafka) refreshMetadata() error {
+	if k.metadata != nil {
+		return nil
+	}
+
+	var lastErr error
+	for _, addr := range k.conf.Brokers {
+		c, err := dial(addr, &k.conf, k.tlsConfig)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp, err := c.request(apiMetadata, metadataVersion, encodeMetadataRequest(k.conf.Topic), true)
+		_ = c.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		md, err := decodeMetadataResponse(resp, k.conf.Topic)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		k.metadata = md
+		// The leaders may have moved, connect to them again.
+		for id := range k.conns {
+			k.closeConn(id)
+		}
+		return nil
+	}
+	return fmt.Errorf("kafka: unable to fetch the metadata of topic %s: %s", k.conf.Topic, lastErr)
+}
+
+func (k *Kafka) conn(id int32) (*conn, error) {
+	if c, ok := k.conns[id]; ok {
+		return c, nil
+	}
+
+	b, ok := k.metadata.brokers[id]
+	if !ok {
+		return nil, fmt.Errorf("kafka: unknown broker %d", id)
+	}
+	c, err := dial(b.addr, &k.conf, k.tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("kafka: unable to connect to broker %d at %s: %s", id, b.addr, err)
+	}
+	k.conns[id] = c
+	return c, nil
+}
+
+func (k *Kafka) closeConn(id int32) {
+	if c, ok := k.conns[id]; ok {
+		if err := c.Close(); err != nil {
+			log.Debugf("kafka: error closing the connection to broker %d: %s", id, err)
+		}
+		delete(k.conns, id)
+	}
+}
+
+// Close closes the connections to the brokers.
+func (k *Kafka) Close() error {
+	for id := range k.conns {
+		k.closeConn(id)
+	}
+	return nil
+}
+
+func init() {
+	output.Add("kafka", NewKafka)
+}
@@ -0,0 +1,364 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/output"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBroker is a single broker leading the partitions of a topic, it
+// records the messages produced to them.
+type fakeBroker struct {
+	t          *testing.T
+	listener   net.Listener
+	topic      string
+	partitions int32
+	// password enables SASL PLAIN for the user "agent", it's set before the
+	// broker serves.
+	password string
+
+	mu       sync.Mutex
+	messages map[int32][][]byte
+	acks     []int16
+}
+
+func newFakeBroker(t *testing.T, topic string, partitions int32, password string) *fakeBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	b := &fakeBroker{
+		t:          t,
+		listener:   l,
+		topic:      topic,
+		partitions: partitions,
+		password:   password,
+		messages:   make(map[int32][][]byte),
+	}
+	go b.serve()
+	return b
+}
+
+func (b *fakeBroker) addr() string {
+	return b.listener.Addr().String()
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		c, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(c)
+	}
+}
+
+func (b *fakeBroker) handle(c net.Conn) {
+	defer c.Close()
+	authenticated := b.password == ""
+
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(c, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(c, req); err != nil {
+			return
+		}
+		d := &decoder{b: req}
+		apiKey, _, correlationID := d.int16(), d.int16(), d.int32()
+		d.string() // client id
+
+		var resp encoder
+		resp.int32(correlationID)
+		switch {
+		case apiKey == apiSaslHandshake:
+			resp.int16(0)
+			resp.int32(1)
+			resp.string("PLAIN")
+		case apiKey == apiSaslAuthenticate:
+			if string(d.bytes()) == "\x00agent\x00"+b.password {
+				authenticated = true
+				resp.int16(0)
+				resp.nullString()
+			} else {
+				resp.int16(58)
+				resp.string("invalid credentials")
+			}
+			resp.bytes(nil)
+		case !authenticated:
+			return
+		case apiKey == apiMetadata:
+			b.encodeMetadata(&resp)
+		case apiKey == apiProduce:
+			if !b.produce(d, &resp) {
+				continue
+			}
+		default:
+			b.t.Errorf("unexpected request %d", apiKey)
+			return
+		}
+
+		var frame encoder
+		frame.bytes(resp.Bytes())
+		if _, err := c.Write(frame.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) encodeMetadata(resp *encoder) {
+	host, port, _ := net.SplitHostPort(b.addr())
+	portNum, _ := strconv.Atoi(port)
+
+	resp.int32(1)
+	resp.int32(1)
+	resp.string(host)
+	resp.int32(int32(portNum))
+	resp.nullString()
+	resp.int32(1) // controller
+
+	resp.int32(1)
+	resp.int16(0)
+	resp.string(b.topic)
+	resp.int8(0)
+	resp.int32(b.partitions)
+	for i := int32(0); i < b.partitions; i++ {
+		resp.int16(0)
+		resp.int32(i)
+		resp.int32(1)
+		resp.int32(1)
+		resp.int32(1)
+		resp.int32(1)
+		resp.int32(1)
+	}
+}
+
+// produce records the messages and returns false when no response is
+// expected.
+func (b *fakeBroker) produce(d *decoder, resp *encoder) bool {
+	d.string() // transactional id
+	acks := d.int16()
+	d.int32()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.acks = append(b.acks, acks)
+
+	var ids []int32
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		assert.Equal(b.t, b.topic, d.string())
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			id := d.int32()
+			ids = append(ids, id)
+			b.messages[id] = append(b.messages[id], decodeRecordBatch(b.t, d.bytes())...)
+		}
+	}
+	require.NoError(b.t, d.err)
+
+	if acks == 0 {
+		return false
+	}
+	resp.int32(1)
+	resp.string(b.topic)
+	resp.int32(int32(len(ids)))
+	for _, id := range ids {
+		resp.int32(id)
+		resp.int16(0)
+		resp.int64(0)
+		resp.int64(-1)
+	}
+	resp.int32(0) // throttle time
+	return true
+}
+
+func decodeRecordBatch(t *testing.T, batch []byte) [][]byte {
+	d := &decoder{b: batch}
+	d.int64()
+	assert.Equal(t, int(d.int32()), len(d.b))
+	d.int32()
+	assert.Equal(t, int8(2), d.int8())
+	crc := uint32(d.int32())
+	assert.Equal(t, crc32.Checksum(d.b, crc32c), crc, "invalid CRC")
+
+	d.int16()
+	d.int32()
+	d.int64()
+	d.int64()
+	d.int64()
+	d.int16()
+	d.int32()
+	var values [][]byte
+	for i, n := 0, int(d.int32()); i < n; i++ {
+		record := &decoder{b: d.next(int(d.varint()))}
+		record.int8()
+		record.varint()
+		assert.Equal(t, int64(i), record.varint())
+		assert.Nil(t, record.varBytes())
+		values = append(values, record.varBytes())
+		assert.Equal(t, int64(0), record.varint())
+		require.NoError(t, record.err)
+	}
+	require.NoError(t, d.err)
+	return values
+}
+
+// requiredAcks returns the acks of the produce requests received.
+func (b *fakeBroker) requiredAcks() []int16 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]int16(nil), b.acks...)
+}
+
+func (b *fakeBroker) received() map[int32][]jsonMetric {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	received := make(map[int32][]jsonMetric)
+	for id, values := range b.messages {
+		for _, value := range values {
+			var metrics []jsonMetric
+			require.NoError(b.t, json.Unmarshal(value, &metrics))
+			received[id] = append(received[id], metrics...)
+		}
+	}
+	return received
+}
+
+func newOutput(t *testing.T, conf string) *Kafka {
+	var section struct {
+		Output map[string][]toml.Primitive `toml:"output"`
+	}
+	_, err := toml.Decode(conf, &section)
+	require.NoError(t, err)
+
+	o, err := output.Outputs["kafka"](func(v interface{}) error {
+		return toml.PrimitiveDecode(section.Output["kafka"][0], v)
+	})
+	require.NoError(t, err)
+	return o.(*Kafka)
+}
+
+func testMetrics() []metric.Metric {
+	var metrics []metric.Metric
+	for _, name := range []string{"system.cpu.idle", "system.load.1", "system.mem.free", "system.cpu.idle"} {
+		m := metric.NewMetric(name, 1.5, []string{"env:prod"})
+		m.Hostname = "host1"
+		m.Timestamp = 1500000000
+		m.Type = "gauge"
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func TestHashPartitioner(t *testing.T) {
+	b := newFakeBroker(t, "metrics", 3, "")
+	defer b.listener.Close()
+
+	k := newOutput(t, `
+[[output.kafka]]
+brokers = ["`+b.addr()+`"]
+topic = "metrics"
+`)
+	defer k.Close()
+
+	require.NoError(t, k.Write(testMetrics()))
+	require.NoError(t, k.Write(testMetrics()))
+
+	received := b.received()
+	total := 0
+	partitions := make(map[string]int32)
+	for id, metrics := range received {
+		for _, m := range metrics {
+			total++
+			// The metrics with the same name go to the same partition.
+			if p, ok := partitions[m.Name]; ok {
+				assert.Equal(t, p, id)
+			}
+			partitions[m.Name] = id
+			assert.Equal(t, jsonMetric{
+				Name:      m.Name,
+				Value:     1.5,
+				Timestamp: 1500000000,
+				Tags:      []string{"env:prod"},
+				Host:      "host1",
+				Type:      "gauge",
+			}, m)
+		}
+	}
+	assert.Equal(t, 8, total)
+	assert.Equal(t, []int16{1, 1}, b.requiredAcks()[:2])
+}
+
+func TestRoundRobinPartitioner(t *testing.T) {
+	b := newFakeBroker(t, "metrics", 2, "")
+	defer b.listener.Close()
+
+	k := newOutput(t, `
+[[output.kafka]]
+brokers = ["127.0.0.1:1", "`+b.addr()+`"]
+topic = "metrics"
+partitioner = "round_robin"
+required_acks = -1
+`)
+	defer k.Close()
+
+	require.NoError(t, k.Write(testMetrics()))
+	require.NoError(t, k.Write(testMetrics()))
+
+	received := b.received()
+	assert.Len(t, received[0], 4)
+	assert.Len(t, received[1], 4)
+	assert.Equal(t, []int16{-1, -1}, b.requiredAcks())
+}
+
+func TestSASL(t *testing.T) {
+	b := newFakeBroker(t, "metrics", 1, "secret")
+	defer b.listener.Close()
+
+	k := newOutput(t, `
+[[output.kafka]]
+brokers = ["`+b.addr()+`"]
+topic = "metrics"
+sasl_username = "agent"
+sasl_password = "wrong"
+`)
+	err := k.Write(testMetrics())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid credentials")
+
+	k.conf.SASLPassword = "secret"
+	require.NoError(t, k.Write(testMetrics()))
+	assert.Len(t, b.received()[0], 4)
+	k.Close()
+}
+
+func TestConfigErrors(t *testing.T) {
+	for _, conf := range []string{
+		`topic = "metrics"`,
+		`brokers = ["localhost:9092"]`,
+		`brokers = ["localhost:9092"]
+topic = "metrics"
+partitioner = "sticky"`,
+		`brokers = ["localhost:9092"]
+topic = "metrics"
+required_acks = -2`,
+	} {
+		_, err := NewKafka(func(v interface{}) error {
+			_, err := toml.Decode(conf, v)
+			return err
+		})
+		assert.Error(t, err, conf)
+	}
+}
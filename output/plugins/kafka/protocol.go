@@ -0,0 +1,370 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"time"
+)
+
+// The requests of the Kafka protocol used by the producer, they're supported
+// by the brokers since Kafka 1.0.
+const (
+	apiProduce          int16 = 0
+	apiMetadata         int16 = 3
+	apiSaslHandshake    int16 = 17
+	apiSaslAuthenticate int16 = 36
+
+	produceVersion          int16 = 3
+	metadataVersion         int16 = 1
+	saslHandshakeVersion    int16 = 1
+	saslAuthenticateVersion int16 = 0
+)
+
+// kafkaError is an error code returned by a broker.
+type kafkaError int16
+
+// The error codes after which the metadata is refreshed.
+const (
+	errUnknownTopicOrPartition kafkaError = 3
+	errLeaderNotAvailable      kafkaError = 5
+	errNotLeaderForPartition   kafkaError = 6
+)
+
+func (e kafkaError) Error() string {
+	return fmt.Sprintf("kafka error code %d", int16(e))
+}
+
+// retriable returns true when the metadata is stale.
+func (e kafkaError) retriable() bool {
+	return e == errUnknownTopicOrPartition || e == errLeaderNotAvailable || e == errNotLeaderForPartition
+}
+
+var errMalformed = errors.New("malformed kafka response")
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// encoder writes the big-endian primitives of the Kafka protocol.
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) int8(v int8) {
+	e.WriteByte(byte(v))
+}
+
+func (e *encoder) int16(v int16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) int32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) int64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.WriteString(s)
+}
+
+func (e *encoder) nullString() {
+	e.int16(-1)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.Write(b)
+}
+
+// varint writes a zigzag encoded variable length integer, as used by the
+// records.
+func (e *encoder) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	e.Write(b[:n])
+}
+
+func (e *encoder) varBytes(b []byte) {
+	if b == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(b)))
+	e.Write(b)
+}
+
+// decoder reads the primitives of the Kafka protocol, the first error is
+// kept and the following reads return zero values.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errMalformed
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return int8(b[0])
+}
+
+func (d *decoder) int16() int16 {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func (d *decoder) int32() int32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) int64() int64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = errMalformed
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) varBytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+// arrayLen reads the length of an array, a null array is empty.
+func (d *decoder) arrayLen() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	// Every element takes at least a byte, don't allocate for garbage.
+	if int(n) > len(d.b) {
+		d.err = errMalformed
+		return 0
+	}
+	return int(n)
+}
+
+// encodeRecordBatch encodes the values into a record batch (magic 2) of
+// uncompressed messages without key.
+func encodeRecordBatch(values [][]byte, now time.Time) []byte {
+	timestamp := now.UnixNano() / int64(time.Millisecond)
+
+	var records encoder
+	for i, value := range values {
+		var record encoder
+		record.int8(0)   // attributes
+		record.varint(0) // timestamp delta
+		record.varint(int64(i))
+		record.varBytes(nil)
+		record.varBytes(value)
+		record.varint(0) // headers
+
+		records.varint(int64(record.Len()))
+		records.Write(record.Bytes())
+	}
+
+	// The part of the batch covered by the CRC.
+	var body encoder
+	body.int16(0) // attributes: no compression, create time
+	body.int32(int32(len(values) - 1))
+	body.int64(timestamp)
+	body.int64(timestamp)
+	body.int64(-1) // producer id
+	body.int16(-1) // producer epoch
+	body.int32(-1) // base sequence
+	body.int32(int32(len(values)))
+	body.Write(records.Bytes())
+
+	var batch encoder
+	batch.int64(0) // base offset
+	// The length of the rest: leader epoch, magic, crc and body.
+	batch.int32(int32(4 + 1 + 4 + body.Len()))
+	batch.int32(-1) // partition leader epoch
+	batch.int8(2)   // magic
+	batch.int32(int32(crc32.Checksum(body.Bytes(), crc32c)))
+	batch.Write(body.Bytes())
+	return batch.Bytes()
+}
+
+type broker struct {
+	id   int32
+	addr string
+}
+
+type partition struct {
+	id     int32
+	leader int32
+}
+
+type metadata struct {
+	brokers    map[int32]broker
+	partitions []partition
+}
+
+func encodeMetadataRequest(topic string) []byte {
+	var e encoder
+	e.int32(1)
+	e.string(topic)
+	return e.Bytes()
+}
+
+func decodeMetadataResponse(b []byte, topic string) (*metadata, error) {
+	d := &decoder{b: b}
+	md := &metadata{brokers: make(map[int32]broker)}
+
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		md.brokers[id] = broker{id: id, addr: fmt.Sprintf("%s:%d", host, port)}
+	}
+	d.int32() // controller id
+
+	var topicErr kafkaError
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		errCode := kafkaError(d.int16())
+		name := d.string()
+		d.int8() // is internal
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			d.int16() // partition error, the leader may still be known
+			p := partition{id: d.int32(), leader: d.int32()}
+			for k, r := 0, d.arrayLen(); k < r; k++ {
+				d.int32() // replicas
+			}
+			for k, r := 0, d.arrayLen(); k < r; k++ {
+				d.int32() // in-sync replicas
+			}
+			if name == topic && p.leader >= 0 {
+				md.partitions = append(md.partitions, p)
+			}
+		}
+		if name == topic {
+			topicErr = errCode
+		}
+	}
+	// Sorted so that the hash partitioner is stable.
+	sort.Slice(md.partitions, func(i, j int) bool {
+		return md.partitions[i].id < md.partitions[j].id
+	})
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	if topicErr != 0 {
+		return nil, fmt.Errorf("metadata of topic %s: %s", topic, topicErr)
+	}
+	if len(md.partitions) == 0 {
+		return nil, fmt.Errorf("no partition of topic %s has a leader", topic)
+	}
+	return md, nil
+}
+
+func encodeProduceRequest(topic string, acks int16, timeout time.Duration, batches map[int32][]byte) []byte {
+	var e encoder
+	e.nullString() // transactional id
+	e.int16(acks)
+	e.int32(int32(timeout / time.Millisecond))
+	e.int32(1)
+	e.string(topic)
+	e.int32(int32(len(batches)))
+	for id, batch := range batches {
+		e.int32(id)
+		e.bytes(batch)
+	}
+	return e.Bytes()
+}
+
+// decodeProduceResponse returns the first error of the partitions.
+func decodeProduceResponse(b []byte) error {
+	d := &decoder{b: b}
+	var err error
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		d.string()
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			id := d.int32()
+			if code := kafkaError(d.int16()); code != 0 && err == nil {
+				err = partitionError{partition: id, kafkaError: code}
+			}
+			d.int64() // base offset
+			d.int64() // log append time
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	return err
+}
+
+type partitionError struct {
+	kafkaError
+	partition int32
+}
+
+func (e partitionError) Error() string {
+	return fmt.Sprintf("partition %d: %s", e.partition, e.kafkaError)
+}
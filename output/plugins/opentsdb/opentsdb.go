@@ -0,0 +1,156 @@
+// Package opentsdb writes the metrics to OpenTSDB, through its /api/put HTTP
+// endpoint.
+package opentsdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/output"
+)
+
+// defaultBatchSize keeps most requests under the 4096 bytes accepted by the
+// TSDs, unless tsd.http.request.enable_chunked is set.
+const defaultBatchSize = 20
+
+// Config is the [[output.opentsdb]] section of the agent configuration.
+type Config struct {
+	URL string `toml:"url"`
+	// BatchSize is the maximum number of data points per request.
+	BatchSize int `toml:"batch_size"`
+	plugin.HTTPConfig
+}
+
+// NewOpenTSDB creates the output.
+func NewOpenTSDB(decode func(v interface{}) error) (output.Output, error) {
+	o := &OpenTSDB{}
+	if err := decode(&o.conf); err != nil {
+		return nil, err
+	}
+
+	if o.conf.URL == "" {
+		return nil, fmt.Errorf("opentsdb: url must be specified")
+	}
+	if o.conf.BatchSize <= 0 {
+		o.conf.BatchSize = defaultBatchSize
+	}
+	o.putURL = strings.TrimSuffix(o.conf.URL, "/") + "/api/put"
+
+	if _, err := o.conf.TLSConfig(); err != nil {
+		return nil, fmt.Errorf("opentsdb: %s", err)
+	}
+	return o, nil
+}
+
+// OpenTSDB posts the metrics as JSON data points.
+type OpenTSDB struct {
+	conf   Config
+	putURL string
+}
+
+type dataPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp int64             `json:"timestamp"`
+	Value     float64           `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// Write posts the metrics by batches of BatchSize, it stops at the first
+// failure.
+func (o *OpenTSDB) Write(metrics []metric.Metric) error {
+	points := make([]dataPoint, 0, len(metrics))
+	for n := range metrics {
+		if p, ok := newDataPoint(&metrics[n]); ok {
+			points = append(points, p)
+		}
+	}
+
+	for len(points) > 0 {
+		n := o.conf.BatchSize
+		if n > len(points) {
+			n = len(points)
+		}
+		if err := o.put(points[:n]); err != nil {
+			return err
+		}
+		points = points[n:]
+	}
+	return nil
+}
+
+func (o *OpenTSDB) put(points []dataPoint) error {
+	body, err := json.Marshal(points)
+	if err != nil {
+		return err
+	}
+
+	req, err := o.conf.NewRequest("POST", o.putURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.conf.Do(req)
+	if err != nil {
+		return fmt.Errorf("opentsdb: %s", err)
+	}
+	return resp.Body.Close()
+}
+
+// Connect does nothing, every Write sends its own requests.
+func (o *OpenTSDB) Connect() error {
+	return nil
+}
+
+// Close does nothing, the connections aren't kept.
+func (o *OpenTSDB) Close() error {
+	return nil
+}
+
+// newDataPoint converts m, OpenTSDB requires a numeric value and at least
+// one tag.
+func newDataPoint(m *metric.Metric) (dataPoint, bool) {
+	value, err := m.FloatValue()
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return dataPoint{}, false
+	}
+
+	tags := make(map[string]string)
+	for key, value := range output.TagMap(m) {
+		tags[sanitize(key)] = sanitize(value)
+	}
+	if len(tags) == 0 {
+		return dataPoint{}, false
+	}
+
+	return dataPoint{
+		Metric:    sanitize(m.Name),
+		Timestamp: m.Timestamp,
+		Value:     value,
+		Tags:      tags,
+	}, true
+}
+
+// sanitize replaces the characters OpenTSDB rejects in the metric names and
+// the tags by underscores.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r):
+			return r
+		case r == '-', r == '_', r == '.', r == '/':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func init() {
+	output.Add("opentsdb", NewOpenTSDB)
+}
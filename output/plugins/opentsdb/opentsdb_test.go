@@ -0,0 +1,94 @@
+package opentsdb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decoder(conf string) func(v interface{}) error {
+	return func(v interface{}) error {
+		_, err := toml.Decode(conf, v)
+		return err
+	}
+}
+
+func TestNewDataPoint(t *testing.T) {
+	m := metric.NewMetric("nginx.net.requests per_s", 12.5, []string{"role:web,front", "prod"})
+	m.Hostname = "host1"
+	m.Timestamp = 1500000000
+
+	p, ok := newDataPoint(&m)
+	require.True(t, ok)
+	assert.Equal(t, dataPoint{
+		Metric:    "nginx.net.requests_per_s",
+		Timestamp: 1500000000,
+		Value:     12.5,
+		Tags:      map[string]string{"host": "host1", "role": "web_front", "prod": "true"},
+	}, p)
+
+	// OpenTSDB requires a tag.
+	m = metric.NewMetric("system.load.1", 1)
+	_, ok = newDataPoint(&m)
+	assert.False(t, ok)
+}
+
+func TestWrite(t *testing.T) {
+	var batches [][]dataPoint
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/put", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var points []dataPoint
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&points))
+		batches = append(batches, points)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	o, err := NewOpenTSDB(decoder(`
+url = "` + ts.URL + `"
+batch_size = 2
+`))
+	require.NoError(t, err)
+	defer o.Close()
+
+	var metrics []metric.Metric
+	for _, name := range []string{"system.load.1", "system.load.5", "system.load.15"} {
+		m := metric.NewMetric(name, 1, []string{"env:prod"})
+		m.Timestamp = 1500000000
+		metrics = append(metrics, m)
+	}
+	require.NoError(t, o.Write(metrics))
+
+	require.Len(t, batches, 2)
+	assert.Len(t, batches[0], 2)
+	assert.Equal(t, []dataPoint{{
+		Metric:    "system.load.15",
+		Timestamp: 1500000000,
+		Value:     1,
+		Tags:      map[string]string{"env": "prod"},
+	}}, batches[1])
+}
+
+func TestWriteError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	o, err := NewOpenTSDB(decoder(`url = "` + ts.URL + `"`))
+	require.NoError(t, err)
+	m := metric.NewMetric("system.load.1", 1, []string{"env:prod"})
+	assert.Error(t, o.Write([]metric.Metric{m}))
+}
+
+func TestConfigErrors(t *testing.T) {
+	_, err := NewOpenTSDB(decoder(`batch_size = 10`))
+	assert.Error(t, err)
+}
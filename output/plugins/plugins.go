@@ -0,0 +1,9 @@
+package plugins
+
+import (
+	// registry all outputs
+	_ "github.com/cloudinsight/cloudinsight-agent/output/plugins/influxdb"
+	_ "github.com/cloudinsight/cloudinsight-agent/output/plugins/kafka"
+	_ "github.com/cloudinsight/cloudinsight-agent/output/plugins/opentsdb"
+	_ "github.com/cloudinsight/cloudinsight-agent/output/plugins/remotewrite"
+)
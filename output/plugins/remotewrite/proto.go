@@ -0,0 +1,88 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/prometheus"
+)
+
+// The messages of the remote write protocol, prompb/remote.proto and
+// prompb/types.proto of Prometheus:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+type sample struct {
+	value float64
+	// timestamp is in milliseconds.
+	timestamp int64
+}
+
+type timeSeries struct {
+	labels  []prometheus.Label
+	samples []sample
+}
+
+// protoBuffer appends the protobuf encoding of the fields.
+type protoBuffer []byte
+
+func (b protoBuffer) key(field, wire int) protoBuffer {
+	return b.varint(uint64(field<<3 | wire))
+}
+
+func (b protoBuffer) varint(v uint64) protoBuffer {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func (b protoBuffer) bytes(field int, v []byte) protoBuffer {
+	b = b.key(field, wireBytes).varint(uint64(len(v)))
+	return append(b, v...)
+}
+
+func (b protoBuffer) string(field int, v string) protoBuffer {
+	b = b.key(field, wireBytes).varint(uint64(len(v)))
+	return append(b, v...)
+}
+
+func (b protoBuffer) double(field int, v float64) protoBuffer {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	return append(b.key(field, wireFixed64), buf[:]...)
+}
+
+func (b protoBuffer) int64(field int, v int64) protoBuffer {
+	return b.key(field, wireVarint).varint(uint64(v))
+}
+
+// encodeWriteRequest returns the protobuf encoding of a WriteRequest.
+func encodeWriteRequest(series []timeSeries) []byte {
+	var req, ts, msg protoBuffer
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = msg[:0].string(1, l.Name).string(2, l.Value)
+			ts = ts.bytes(1, msg)
+		}
+		for _, smp := range s.samples {
+			msg = msg[:0].double(1, smp.value)
+			// The zero values are omitted by the proto3 encoders.
+			if smp.timestamp != 0 {
+				msg = msg.int64(2, smp.timestamp)
+			}
+			ts = ts.bytes(2, msg)
+		}
+		req = req.bytes(1, ts)
+	}
+	return req
+}
@@ -0,0 +1,125 @@
+// Package remotewrite pushes the metrics with the remote write protocol of
+// Prometheus, to Prometheus itself or to the compatible backends like Thanos,
+// Mimir, Cortex or VictoriaMetrics.
+package remotewrite
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/prometheus"
+	"github.com/cloudinsight/cloudinsight-agent/output"
+)
+
+const defaultBatchSize = 500
+
+// Config is the [[output.prometheus_remote_write]] section of the agent
+// configuration. The bearer tokens and the tenant headers, like the
+// X-Scope-OrgID of Mimir, are set with the headers.
+type Config struct {
+	URL string `toml:"url"`
+	// BatchSize is the maximum number of series per request.
+	BatchSize int `toml:"batch_size"`
+	plugin.HTTPConfig
+}
+
+// NewRemoteWrite creates the output.
+func NewRemoteWrite(decode func(v interface{}) error) (output.Output, error) {
+	r := &RemoteWrite{}
+	if err := decode(&r.conf); err != nil {
+		return nil, err
+	}
+
+	if r.conf.URL == "" {
+		return nil, fmt.Errorf("prometheus_remote_write: url must be specified")
+	}
+	if r.conf.BatchSize <= 0 {
+		r.conf.BatchSize = defaultBatchSize
+	}
+	if _, err := r.conf.TLSConfig(); err != nil {
+		return nil, fmt.Errorf("prometheus_remote_write: %s", err)
+	}
+	return r, nil
+}
+
+// RemoteWrite sends every metric as a series of one sample, the metric name
+// is the __name__ label and the tags are the other labels.
+type RemoteWrite struct {
+	conf Config
+}
+
+// Write sends the metrics by batches of BatchSize, it stops at the first
+// failure.
+func (r *RemoteWrite) Write(metrics []metric.Metric) error {
+	series := make([]timeSeries, 0, len(metrics))
+	for n := range metrics {
+		if ts, ok := newTimeSeries(&metrics[n]); ok {
+			series = append(series, ts)
+		}
+	}
+
+	for len(series) > 0 {
+		n := r.conf.BatchSize
+		if n > len(series) {
+			n = len(series)
+		}
+		if err := r.send(series[:n]); err != nil {
+			return err
+		}
+		series = series[n:]
+	}
+	return nil
+}
+
+func (r *RemoteWrite) send(series []timeSeries) error {
+	body := snappyEncode(encodeWriteRequest(series))
+	req, err := r.conf.NewRequest("POST", r.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("User-Agent", "cloudinsight-agent/"+config.VERSION)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := r.conf.Do(req)
+	if err != nil {
+		return fmt.Errorf("prometheus_remote_write: %s", err)
+	}
+	return resp.Body.Close()
+}
+
+// Connect does nothing, every Write sends its own requests.
+func (r *RemoteWrite) Connect() error {
+	return nil
+}
+
+// Close does nothing, the connections aren't kept.
+func (r *RemoteWrite) Close() error {
+	return nil
+}
+
+func newTimeSeries(m *metric.Metric) (timeSeries, bool) {
+	value, err := m.FloatValue()
+	if err != nil || math.IsNaN(value) {
+		return timeSeries{}, false
+	}
+
+	labels := append(prometheus.Labels(m), prometheus.Label{
+		Name:  "__name__",
+		Value: prometheus.MetricName(m.Name),
+	})
+	prometheus.SortLabels(labels)
+	return timeSeries{
+		labels:  labels,
+		samples: []sample{{value: value, timestamp: m.Timestamp * 1000}},
+	}, true
+}
+
+func init() {
+	output.Add("prometheus_remote_write", NewRemoteWrite)
+}
@@ -0,0 +1,164 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// protoReader reads the fields of a protobuf message.
+type protoReader struct {
+	t *testing.T
+	b []byte
+}
+
+func (r *protoReader) varint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	require.True(r.t, n > 0)
+	r.b = r.b[n:]
+	return v
+}
+
+// next returns the next field number and its value, a []byte for the
+// length-delimited fields, a uint64 otherwise.
+func (r *protoReader) next() (int, interface{}) {
+	key := r.varint()
+	switch key & 0x07 {
+	case wireVarint:
+		return int(key >> 3), r.varint()
+	case wireFixed64:
+		v := binary.LittleEndian.Uint64(r.b)
+		r.b = r.b[8:]
+		return int(key >> 3), v
+	case wireBytes:
+		n := int(r.varint())
+		v := r.b[:n]
+		r.b = r.b[n:]
+		return int(key >> 3), v
+	}
+	r.t.Fatalf("unexpected wire type %d", key&0x07)
+	return 0, nil
+}
+
+func decodeWriteRequest(t *testing.T, b []byte) []timeSeries {
+	var series []timeSeries
+	req := &protoReader{t: t, b: b}
+	for len(req.b) > 0 {
+		field, v := req.next()
+		require.Equal(t, 1, field)
+
+		var s timeSeries
+		ts := &protoReader{t: t, b: v.([]byte)}
+		for len(ts.b) > 0 {
+			field, v := ts.next()
+			msg := &protoReader{t: t, b: v.([]byte)}
+			switch field {
+			case 1:
+				var l prometheus.Label
+				for len(msg.b) > 0 {
+					field, v := msg.next()
+					if field == 1 {
+						l.Name = string(v.([]byte))
+					} else {
+						l.Value = string(v.([]byte))
+					}
+				}
+				s.labels = append(s.labels, l)
+			case 2:
+				var smp sample
+				for len(msg.b) > 0 {
+					field, v := msg.next()
+					if field == 1 {
+						smp.value = math.Float64frombits(v.(uint64))
+					} else {
+						smp.timestamp = int64(v.(uint64))
+					}
+				}
+				s.samples = append(s.samples, smp)
+			}
+		}
+		series = append(series, s)
+	}
+	return series
+}
+
+func TestWrite(t *testing.T) {
+	var requests [][]timeSeries
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "0.1.0", r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		assert.Equal(t, "tenant1", r.Header.Get("X-Scope-OrgID"))
+
+		body, _ := ioutil.ReadAll(r.Body)
+		decoded, err := snappyDecode(body)
+		require.NoError(t, err)
+		requests = append(requests, decodeWriteRequest(t, decoded))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	var section struct {
+		Output map[string][]toml.Primitive `toml:"output"`
+	}
+	_, err := toml.Decode(`
+[[output.prometheus_remote_write]]
+url = "`+ts.URL+`/api/v1/push"
+batch_size = 2
+[output.prometheus_remote_write.headers]
+X-Scope-OrgID = "tenant1"
+`, &section)
+	require.NoError(t, err)
+	o, err := NewRemoteWrite(func(v interface{}) error {
+		return toml.PrimitiveDecode(section.Output["prometheus_remote_write"][0], v)
+	})
+	require.NoError(t, err)
+	defer o.Close()
+
+	m1 := metric.NewMetric("system.load.1", 0.5, []string{"env:prod", "Role:web", "__name__:evil"})
+	m1.Hostname = "host1"
+	m1.Timestamp = 1500000000
+	m2 := metric.NewMetric("system.load.5", 1)
+	m3 := metric.NewMetric("system.load.15", "invalid")
+	m4 := metric.NewMetric("system.load.15", 2)
+	require.NoError(t, o.Write([]metric.Metric{m1, m2, m3, m4}))
+
+	require.Len(t, requests, 2)
+	assert.Equal(t, []timeSeries{{
+		labels: []prometheus.Label{
+			{Name: "Role", Value: "web"},
+			{Name: "__name__", Value: "system_load_1"},
+			{Name: "env", Value: "prod"},
+			{Name: "host", Value: "host1"},
+			{Name: "tag__name__", Value: "evil"},
+		},
+		samples: []sample{{value: 0.5, timestamp: 1500000000000}},
+	}, {
+		labels:  []prometheus.Label{{Name: "__name__", Value: "system_load_5"}},
+		samples: []sample{{value: 1}},
+	}}, requests[0])
+	assert.Len(t, requests[1], 1)
+}
+
+func TestWriteError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	o, err := NewRemoteWrite(func(v interface{}) error {
+		_, err := toml.Decode(`url = "`+ts.URL+`"`, v)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Error(t, o.Write([]metric.Metric{metric.NewMetric("system.load.1", 1)}))
+}
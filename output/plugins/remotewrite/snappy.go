@@ -0,0 +1,108 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+)
+
+// The remote write requests are compressed with the block format of Snappy,
+// https://github.com/google/snappy/blob/master/format_description.txt.
+// snappyEncode is a simple greedy compressor producing that format.
+
+const (
+	snappyTagLiteral = 0x00
+	snappyTagCopy1   = 0x01
+	snappyTagCopy2   = 0x02
+
+	// snappyBlockSize bounds the copy offsets to 16 bits.
+	snappyBlockSize = 1 << 16
+	snappyTableBits = 14
+	// snappyMinMatch is the shortest match worth a copy.
+	snappyMinMatch = 4
+)
+
+// snappyEncode returns the Snappy block encoding of src.
+func snappyEncode(src []byte) []byte {
+	dst := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(src)+len(src)/6+16)
+	n := binary.PutUvarint(dst, uint64(len(src)))
+	dst = dst[:n]
+
+	for len(src) > 0 {
+		block := src
+		if len(block) > snappyBlockSize {
+			block = block[:snappyBlockSize]
+		}
+		dst = snappyEncodeBlock(dst, block)
+		src = src[len(block):]
+	}
+	return dst
+}
+
+func snappyHash(u uint32) uint32 {
+	return (u * 0x1e35a7bd) >> (32 - snappyTableBits)
+}
+
+func snappyEncodeBlock(dst, src []byte) []byte {
+	// The positions in src plus one, 0 is no position.
+	var table [1 << snappyTableBits]int32
+
+	literal, s := 0, 0
+	for s+snappyMinMatch <= len(src) {
+		u := binary.LittleEndian.Uint32(src[s:])
+		h := snappyHash(u)
+		candidate := int(table[h]) - 1
+		table[h] = int32(s + 1)
+
+		if candidate < 0 || binary.LittleEndian.Uint32(src[candidate:]) != u {
+			s++
+			continue
+		}
+
+		dst = snappyEmitLiteral(dst, src[literal:s])
+		start := s
+		s += snappyMinMatch
+		for i := candidate + snappyMinMatch; s < len(src) && src[s] == src[i]; i++ {
+			s++
+		}
+		dst = snappyEmitCopy(dst, start-candidate, s-start)
+		literal = s
+	}
+	return snappyEmitLiteral(dst, src[literal:])
+}
+
+func snappyEmitLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	n := uint32(len(lit) - 1)
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|snappyTagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|snappyTagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|snappyTagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, lit...)
+}
+
+// snappyEmitCopy emits the copies of length bytes at offset, length is at
+// least snappyMinMatch.
+func snappyEmitCopy(dst []byte, offset, length int) []byte {
+	for length >= 68 {
+		dst = append(dst, 63<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		// Leave at least 4 bytes for the last copy.
+		dst = append(dst, 59<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		return append(dst, byte(length-1)<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+	}
+	return append(dst, byte(offset>>8)<<5|byte(length-4)<<2|snappyTagCopy1, byte(offset))
+}
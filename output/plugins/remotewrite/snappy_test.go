@@ -0,0 +1,103 @@
+package remotewrite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errCorrupt = errors.New("corrupt snappy block")
+
+// snappyDecode decodes the Snappy block format, to check the encoder.
+func snappyDecode(src []byte) ([]byte, error) {
+	n, l := binary.Uvarint(src)
+	if l <= 0 {
+		return nil, errCorrupt
+	}
+	src = src[l:]
+	dst := make([]byte, 0, n)
+
+	for len(src) > 0 {
+		tag := src[0]
+		var length, offset int
+		switch tag & 0x03 {
+		case snappyTagLiteral:
+			length = int(tag >> 2)
+			src = src[1:]
+			if length >= 60 {
+				extra := length - 59
+				if len(src) < extra {
+					return nil, errCorrupt
+				}
+				length = 0
+				for i := extra - 1; i >= 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				src = src[extra:]
+			}
+			length++
+			if len(src) < length {
+				return nil, errCorrupt
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case snappyTagCopy1:
+			if len(src) < 2 {
+				return nil, errCorrupt
+			}
+			length = 4 + int(tag>>2&0x07)
+			offset = int(tag>>5)<<8 | int(src[1])
+			src = src[2:]
+		case snappyTagCopy2:
+			if len(src) < 3 {
+				return nil, errCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		default:
+			return nil, errCorrupt
+		}
+		if offset <= 0 || offset > len(dst) {
+			return nil, errCorrupt
+		}
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if uint64(len(dst)) != n {
+		return nil, errCorrupt
+	}
+	return dst, nil
+}
+
+func TestSnappy(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 100000)
+	r.Read(random)
+	repeated := bytes.Repeat([]byte("system_load_1{host=\"web1\"} "), 10000)
+	mixed := append(append([]byte{}, repeated[:70000]...), random[:3000]...)
+	mixed = append(mixed, repeated[:500]...)
+
+	for name, src := range map[string][]byte{
+		"empty":    {},
+		"short":    []byte("abc"),
+		"random":   random,
+		"repeated": repeated,
+		"mixed":    mixed,
+		"runs":     bytes.Repeat([]byte{'a'}, 200),
+	} {
+		encoded := snappyEncode(src)
+		decoded, err := snappyDecode(encoded)
+		require.NoError(t, err, name)
+		assert.Equal(t, src, decoded, name)
+	}
+
+	assert.True(t, len(snappyEncode(repeated)) < len(repeated)/10)
+}
@@ -0,0 +1,35 @@
+// Package output holds the registry of the outputs, which write the metrics
+// flushed by the emitters to other backends than Cloudinsight.
+//
+// An output is a package of output/plugins registering a Creator in its init
+// function and imported by output/plugins/plugins.go. Its options are
+// decoded from the [[output.<name>]] sections of the agent configuration,
+// along with the metric_batch_size and metric_buffer_limit options of the
+// RunningOutput wrapping it.
+package output
+
+import "github.com/cloudinsight/cloudinsight-agent/common/metric"
+
+// Output writes batches of metrics to a backend. An output is used by a
+// single emitter, its methods aren't called concurrently.
+type Output interface {
+	// Connect is called before the first Write, and again before the next
+	// one until it succeeds.
+	Connect() error
+	// Write writes a batch, the whole batch is written again when Write
+	// fails.
+	Write(metrics []metric.Metric) error
+	Close() error
+}
+
+// Creator creates an output, decode decodes its options from its section of
+// the agent configuration.
+type Creator func(decode func(v interface{}) error) (Output, error)
+
+// Outputs holds the registered outputs by name.
+var Outputs = map[string]Creator{}
+
+// Add registers an output.
+func Add(name string, creator Creator) {
+	Outputs[name] = creator
+}
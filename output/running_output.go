@@ -0,0 +1,104 @@
+package output
+
+import (
+	"fmt"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+)
+
+const (
+	// DefaultMetricBatchSize is the default maximum number of metrics given
+	// to a Write of an output.
+	DefaultMetricBatchSize = 1000
+	// DefaultMetricBufferLimit is the default number of metrics an output
+	// keeps while its backend is unavailable.
+	DefaultMetricBufferLimit = 10000
+)
+
+// RunningConfig holds the options every output section accepts.
+type RunningConfig struct {
+	MetricBatchSize   int `toml:"metric_batch_size"`
+	MetricBufferLimit int `toml:"metric_buffer_limit"`
+}
+
+// RunningOutput buffers the metrics of an output, they're written by batches
+// and kept for the next write when it fails. It connects the output before
+// the first write.
+type RunningOutput struct {
+	Name   string
+	Output Output
+
+	batchSize   int
+	bufferLimit int
+	connected   bool
+	buffer      []metric.Metric
+	drops       int
+}
+
+// NewRunningOutput wraps the output o created from the section name.
+func NewRunningOutput(name string, o Output, conf RunningConfig) *RunningOutput {
+	ro := &RunningOutput{
+		Name:        name,
+		Output:      o,
+		batchSize:   conf.MetricBatchSize,
+		bufferLimit: conf.MetricBufferLimit,
+	}
+	if ro.batchSize <= 0 {
+		ro.batchSize = DefaultMetricBatchSize
+	}
+	if ro.bufferLimit <= 0 {
+		ro.bufferLimit = DefaultMetricBufferLimit
+	}
+	if ro.bufferLimit < ro.batchSize {
+		ro.bufferLimit = ro.batchSize
+	}
+	return ro
+}
+
+// Write adds the metrics to the buffer, then writes the buffer. The oldest
+// metrics are dropped when the buffer is full.
+func (ro *RunningOutput) Write(metrics []metric.Metric) error {
+	ro.buffer = append(ro.buffer, metrics...)
+	if excess := len(ro.buffer) - ro.bufferLimit; excess > 0 {
+		ro.drops += excess
+		ro.buffer = append([]metric.Metric(nil), ro.buffer[excess:]...)
+	}
+	if len(ro.buffer) == 0 {
+		return nil
+	}
+
+	if !ro.connected {
+		if err := ro.Output.Connect(); err != nil {
+			return fmt.Errorf("unable to connect to output %s: %s", ro.Name, err)
+		}
+		ro.connected = true
+	}
+
+	for len(ro.buffer) > 0 {
+		n := ro.batchSize
+		if n > len(ro.buffer) {
+			n = len(ro.buffer)
+		}
+		if err := ro.Output.Write(ro.buffer[:n]); err != nil {
+			return fmt.Errorf("unable to write to output %s: %s", ro.Name, err)
+		}
+		ro.buffer = ro.buffer[n:]
+	}
+	ro.buffer = nil
+	return nil
+}
+
+// Len returns the number of metrics waiting in the buffer.
+func (ro *RunningOutput) Len() int {
+	return len(ro.buffer)
+}
+
+// Drops returns the number of metrics dropped because the buffer was full.
+func (ro *RunningOutput) Drops() int {
+	return ro.drops
+}
+
+// Close closes the output, the buffered metrics are lost.
+func (ro *RunningOutput) Close() error {
+	return ro.Output.Close()
+}
@@ -0,0 +1,75 @@
+package output
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockOutput struct {
+	connectErr error
+	writeErr   error
+	connects   int
+	batches    [][]metric.Metric
+}
+
+func (o *mockOutput) Connect() error {
+	o.connects++
+	return o.connectErr
+}
+
+func (o *mockOutput) Write(metrics []metric.Metric) error {
+	if o.writeErr != nil {
+		return o.writeErr
+	}
+	o.batches = append(o.batches, append([]metric.Metric(nil), metrics...))
+	return nil
+}
+
+func (o *mockOutput) Close() error {
+	return nil
+}
+
+func testMetrics(names ...string) []metric.Metric {
+	var metrics []metric.Metric
+	for _, name := range names {
+		metrics = append(metrics, metric.NewMetric(name, 1))
+	}
+	return metrics
+}
+
+func TestRunningOutput(t *testing.T) {
+	o := &mockOutput{connectErr: errors.New("connection refused")}
+	ro := NewRunningOutput("mock", o, RunningConfig{MetricBatchSize: 2, MetricBufferLimit: 4})
+
+	// The metrics are kept until the output connects.
+	err := ro.Write(testMetrics("m1", "m2", "m3"))
+	assert.EqualError(t, err, "unable to connect to output mock: connection refused")
+	assert.Equal(t, 3, ro.Len())
+
+	// Then until it writes them, the oldest are dropped.
+	o.connectErr = nil
+	o.writeErr = errors.New("timeout")
+	assert.Error(t, ro.Write(testMetrics("m4", "m5")))
+	assert.Equal(t, 4, ro.Len())
+	assert.Equal(t, 1, ro.Drops())
+
+	o.writeErr = nil
+	require.NoError(t, ro.Write(nil))
+	assert.Equal(t, 0, ro.Len())
+	assert.Equal(t, 2, o.connects)
+	assert.Equal(t, [][]metric.Metric{testMetrics("m2", "m3"), testMetrics("m4", "m5")}, o.batches)
+
+	require.NoError(t, ro.Write(testMetrics("m6")))
+	assert.Equal(t, 2, o.connects)
+	assert.Len(t, o.batches, 3)
+}
+
+func TestRunningOutputDefaults(t *testing.T) {
+	ro := NewRunningOutput("mock", &mockOutput{}, RunningConfig{MetricBufferLimit: 10})
+	assert.Equal(t, DefaultMetricBatchSize, ro.batchSize)
+	assert.Equal(t, DefaultMetricBatchSize, ro.bufferLimit)
+}
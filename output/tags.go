@@ -0,0 +1,35 @@
+package output
+
+import (
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+)
+
+// TagMap returns the tags of m as key-value pairs, for the backends without
+// the notion of a tag list. A tag without value, like "prod", is set to
+// "true", the first value of a key is kept. The host and device of the metric
+// are the "host" and "device" tags.
+func TagMap(m *metric.Metric) map[string]string {
+	tags := make(map[string]string, len(m.Tags)+2)
+	if m.Hostname != "" {
+		tags["host"] = m.Hostname
+	}
+	if m.DeviceName != "" {
+		tags["device"] = m.DeviceName
+	}
+	for _, tag := range m.Tags {
+		kv := strings.SplitN(tag, ":", 2)
+		key, value := kv[0], "true"
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+		if key == "" || value == "" {
+			continue
+		}
+		if _, ok := tags[key]; !ok {
+			tags[key] = value
+		}
+	}
+	return tags
+}
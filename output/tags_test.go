@@ -0,0 +1,22 @@
+package output
+
+import (
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagMap(t *testing.T) {
+	m := metric.NewMetric("system.net.bytes_rcvd", 1, []string{"env:prod", "env:dev", "canary", "url:http://host", ":empty", "host:other"})
+	m.Hostname = "host1"
+	m.DeviceName = "eth0"
+
+	assert.Equal(t, map[string]string{
+		"host":   "host1",
+		"device": "eth0",
+		"env":    "prod",
+		"canary": "true",
+		"url":    "http://host",
+	}, TagMap(&m))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/privileges"
+	"github.com/cloudinsight/cloudinsight-agent/control"
+)
+
+// privilegedPort is the first port any user may listen to.
+const privilegedPort = 1024
+
+// dropPrivileges switches the agent to run_as_user when it's set: it binds
+// the privileged ports and hands the files the agent writes over to the user
+// first. A reload doesn't change the user.
+func dropPrivileges() {
+	conf, err := config.NewConfig(*fConfig)
+	if err != nil {
+		log.Fatalf("failed to load config: %s", err)
+	}
+	global := conf.GlobalConfig
+	if global.RunAsUser == "" {
+		return
+	}
+
+	uid, gid, err := privileges.Lookup(global.RunAsUser, global.RunAsGroup)
+	if err != nil {
+		log.Fatalf("invalid run_as_user or run_as_group: %s", err)
+	}
+
+	for _, l := range []struct {
+		network string
+		addr    string
+		port    int
+	}{
+		{"udp", conf.GetStatsdAddr(), global.StatsdPort},
+		{"tcp", conf.GetStatsdTCPAddr(), global.StatsdTCPPort},
+		{"tcp", conf.GetForwarderAddr(), global.ListenPort},
+		{"tcp", conf.GetPrometheusAddr(), global.PrometheusPort},
+		{"tcp", conf.GetControlAddr(), global.ControlPort},
+	} {
+		if l.port > 0 && l.port < privilegedPort {
+			if err = privileges.Bind(l.network, l.addr); err != nil {
+				log.Fatalf("failed to bind %s %s: %s", l.network, l.addr, err)
+			}
+		}
+	}
+
+	// The control token is created by the agent, where the user may not
+	// write.
+	if global.ControlPort != 0 {
+		if _, err = control.LoadToken(conf.GetControlTokenFile()); err != nil {
+			log.Fatalf("failed to load the control token: %s", err)
+		}
+	}
+
+	var files, dirs []string
+	files = append(files, conf.LoggingConfig.LogFile)
+	for _, p := range conf.Plugins {
+		for _, instance := range p.Config.Instances {
+			_, file := instance.LogOptions()
+			files = append(files, file)
+		}
+	}
+	dirs = append(dirs, global.ForwarderQueuePath, global.SpoolPath, global.RemoteConfigPath)
+
+	chown := func(path string) {
+		if err := os.Chown(path, uid, gid); err != nil {
+			log.Fatalf("failed to give %s to %s: %s", path, global.RunAsUser, err)
+		}
+	}
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		f.Close()
+		chown(file)
+	}
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			log.Fatal(err)
+		}
+		_ = filepath.Walk(dir, func(path string, _ os.FileInfo, err error) error {
+			if err == nil {
+				chown(path)
+			}
+			return nil
+		})
+	}
+	for _, file := range []string{conf.GetControlTokenFile(), conf.GetSecretKeyFile()} {
+		if _, err = os.Stat(file); err == nil {
+			chown(file)
+		}
+	}
+
+	if err = privileges.Drop(uid, gid); err != nil {
+		log.Fatalf("failed to run as %s: %s", global.RunAsUser, err)
+	}
+	log.Infof("Running as %s", global.RunAsUser)
+}
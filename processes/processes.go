@@ -0,0 +1,200 @@
+// Package processes periodically posts a snapshot of the processes using the
+// most CPU and memory, so that Cloudinsight shows what was running during an
+// incident. The secrets passed on their command lines are scrubbed.
+package processes
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/api"
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/shirou/gopsutil/process"
+)
+
+// Payload is the process inventory payload.
+type Payload struct {
+	Hostname  string `json:"hostname"`
+	Timestamp int64  `json:"timestamp"`
+	// Total is the number of processes running on the host.
+	Total     int       `json:"total"`
+	Processes []Process `json:"processes"`
+}
+
+// Process is the usage of a process since the previous snapshot.
+type Process struct {
+	PID  int32  `json:"pid"`
+	User string `json:"user"`
+	Name string `json:"name"`
+	// Command is the scrubbed command line.
+	Command       []string `json:"command"`
+	CPUPercent    float64  `json:"cpu_percent"`
+	MemoryPercent float64  `json:"memory_percent"`
+	// RSS is the resident memory in bytes.
+	RSS uint64 `json:"rss"`
+}
+
+// Top returns the n processes using the most CPU and the n ones using the
+// most memory, by decreasing CPU usage.
+func Top(procs []Process, n int) []Process {
+	sorted := make([]Process, len(procs))
+	copy(sorted, procs)
+
+	selected := make(map[int32]Process)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].RSS > sorted[j].RSS })
+	for i := 0; i < n && i < len(sorted); i++ {
+		selected[sorted[i].PID] = sorted[i]
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].CPUPercent > sorted[j].CPUPercent })
+	for i := 0; i < n && i < len(sorted); i++ {
+		selected[sorted[i].PID] = sorted[i]
+	}
+
+	top := make([]Process, 0, len(selected))
+	for _, p := range selected {
+		top = append(top, p)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].CPUPercent != top[j].CPUPercent {
+			return top[i].CPUPercent > top[j].CPUPercent
+		}
+		return top[i].RSS > top[j].RSS
+	})
+	return top
+}
+
+// NewSampler creates a new instance of Sampler.
+func NewSampler() *Sampler {
+	return &Sampler{procs: make(map[int32]*process.Process)}
+}
+
+// Sampler measures the usage of the processes, the CPU usage of a process is
+// the one since the previous sample, 0 at its first one.
+type Sampler struct {
+	procs map[int32]*process.Process
+}
+
+// Sample returns the usage of the running processes, without their user,
+// name and command line.
+func (s *Sampler) Sample() []Process {
+	pids, err := process.Pids()
+	if err != nil {
+		log.Warnf("Failed to list the processes: %s", err)
+		return nil
+	}
+
+	var total uint64
+	if vm, err := mem.VirtualMemory(); err == nil {
+		total = vm.Total
+	}
+
+	running := make(map[int32]bool, len(pids))
+	procs := make([]Process, 0, len(pids))
+	for _, pid := range pids {
+		running[pid] = true
+		p, ok := s.procs[pid]
+		if !ok {
+			if p, err = process.NewProcess(pid); err != nil {
+				continue
+			}
+			s.procs[pid] = p
+		}
+
+		cpuPercent, err := p.Percent(0)
+		if err != nil {
+			// Gone since it was listed.
+			continue
+		}
+		memInfo, err := p.MemoryInfo()
+		if err != nil {
+			continue
+		}
+		proc := Process{PID: pid, CPUPercent: util.Round(cpuPercent, 2), RSS: memInfo.RSS}
+		if total > 0 {
+			proc.MemoryPercent = util.Round(100*float64(memInfo.RSS)/float64(total), 2)
+		}
+		procs = append(procs, proc)
+	}
+	for pid := range s.procs {
+		if !running[pid] {
+			delete(s.procs, pid)
+		}
+	}
+	return procs
+}
+
+// Snapshot samples the processes and returns the top n ones, with their
+// user, name and command line scrubbed by scrubber.
+func (s *Sampler) Snapshot(n int, scrubber *util.Scrubber) ([]Process, int) {
+	procs := s.Sample()
+	top := Top(procs, n)
+	for i := range top {
+		p, ok := s.procs[top[i].PID]
+		if !ok {
+			continue
+		}
+		top[i].User, _ = p.Username()
+		top[i].Name, _ = p.Name()
+		if args, err := p.CmdlineSlice(); err == nil {
+			top[i].Command = scrubber.ScrubArgs(args)
+		}
+	}
+	return top, len(procs)
+}
+
+// NewCollector creates a new instance of Collector.
+func NewCollector(conf *config.Config) *Collector {
+	a := api.NewAPI(conf.GetForwarderAddrWithScheme(), conf.GlobalConfig.LicenseKey, 10*time.Second)
+	a.CompressionLevel = conf.GlobalConfig.CompressionLevel
+	a.Format = conf.GlobalConfig.PayloadFormat
+	return &Collector{
+		conf:     conf,
+		api:      a,
+		sampler:  NewSampler(),
+		scrubber: util.NewScrubber(conf.GlobalConfig.ProcessScrubWords),
+	}
+}
+
+// Collector posts the process inventory to the Forwarder, with the license
+// key of the main pipeline.
+type Collector struct {
+	conf     *config.Config
+	api      *api.API
+	sampler  *Sampler
+	scrubber *util.Scrubber
+}
+
+// Post takes a snapshot of the processes and posts it.
+func (c *Collector) Post() error {
+	procs, total := c.sampler.Snapshot(c.conf.GetProcessInventoryTop(), c.scrubber)
+	return c.api.SubmitProcesses(&Payload{
+		Hostname:  c.conf.GetHostname(),
+		Timestamp: time.Now().Unix(),
+		Total:     total,
+		Processes: procs,
+	})
+}
+
+// Run posts a snapshot every process_inventory_interval until shutdown is
+// closed, the first one is taken an interval after the start so that the
+// CPU usage is measured.
+func (c *Collector) Run(shutdown chan struct{}) {
+	c.sampler.Sample()
+
+	ticker := time.NewTicker(c.conf.GetProcessInventoryInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-shutdown:
+			return
+		}
+
+		if err := c.Post(); err != nil {
+			log.Warnf("Failed to post the process inventory: %s", err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package processes
+
+import (
+	"os"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTop(t *testing.T) {
+	procs := []Process{
+		{PID: 1, CPUPercent: 0.1, RSS: 10},
+		{PID: 2, CPUPercent: 50, RSS: 20},
+		{PID: 3, CPUPercent: 0, RSS: 900},
+		{PID: 4, CPUPercent: 20, RSS: 30},
+		{PID: 5, CPUPercent: 0, RSS: 40},
+	}
+
+	var pids []int32
+	for _, p := range Top(procs, 1) {
+		pids = append(pids, p.PID)
+	}
+	assert.Equal(t, []int32{2, 3}, pids)
+
+	pids = nil
+	for _, p := range Top(procs, 2) {
+		pids = append(pids, p.PID)
+	}
+	assert.Equal(t, []int32{2, 4, 3, 5}, pids)
+	assert.Len(t, Top(procs, 3), 5)
+}
+
+func TestSnapshot(t *testing.T) {
+	s := NewSampler()
+	procs, total := s.Snapshot(1<<20, util.NewScrubber(nil))
+	assert.True(t, total > 0)
+	assert.Len(t, procs, total)
+
+	found := false
+	for _, p := range procs {
+		if p.PID == int32(os.Getpid()) {
+			found = true
+			assert.NotEmpty(t, p.Name)
+			assert.NotEmpty(t, p.Command)
+		}
+	}
+	assert.True(t, found)
+}
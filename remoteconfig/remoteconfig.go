@@ -0,0 +1,187 @@
+// Package remoteconfig polls a central HTTP endpoint for the configurations
+// of the checks. They're stored in the remote_config_path directory, loaded
+// with the ones of conf.d, and the agent reloads when they change.
+package remoteconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/plugin"
+	"github.com/cloudinsight/cloudinsight-agent/common/proxy"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// VersionHeader carries the version of the configuration the agent is
+// running in every poll, so that the endpoint knows which agents are up to
+// date.
+const VersionHeader = "X-Cloudinsight-Config-Version"
+
+// versionFile holds the version of the stored configurations.
+const versionFile = "version"
+
+var checkName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Document is served by the endpoint, with the status 304 Not Modified when
+// the version in VersionHeader is current.
+type Document struct {
+	Version string `json:"version"`
+	// Checks are the YAML configurations of the checks, by check name.
+	Checks map[string]string `json:"checks"`
+}
+
+// NewPoller creates a new instance of Poller, it starts from the version
+// stored by the previous run.
+func NewPoller(conf *config.Config) *Poller {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy.FromConfig
+	p := &Poller{
+		conf: conf,
+		dir:  conf.GlobalConfig.RemoteConfigPath,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   10 * time.Second,
+		},
+	}
+	if version, err := ioutil.ReadFile(filepath.Join(p.dir, versionFile)); err == nil {
+		p.version = strings.TrimSpace(string(version))
+	}
+	return p
+}
+
+// Poller fetches the configurations of the checks.
+type Poller struct {
+	conf    *config.Config
+	dir     string
+	client  *http.Client
+	version string
+}
+
+// Version returns the version of the stored configurations, the one the
+// agent is running.
+func (p *Poller) Version() string {
+	return p.version
+}
+
+// Poll fetches the configurations, it returns true when a new version has
+// been stored. A version with an invalid configuration isn't stored at all.
+func (p *Poller) Poll() (bool, error) {
+	req, err := http.NewRequest("GET", p.conf.GlobalConfig.RemoteConfigURL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("User-Agent", "cloudinsight-agent/"+config.VERSION)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Cloudinsight-Hostname", p.conf.GetHostname())
+	if p.version != "" {
+		req.Header.Set(VersionHeader, p.version)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return false, nil
+	case resp.StatusCode != http.StatusOK:
+		return false, fmt.Errorf("received bad status code, %d", resp.StatusCode)
+	}
+
+	var doc Document
+	if err = json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return false, fmt.Errorf("invalid remote configuration: %s", err)
+	}
+	if doc.Version == "" {
+		return false, fmt.Errorf("invalid remote configuration: no version")
+	}
+	if doc.Version == p.version {
+		return false, nil
+	}
+	if err = p.store(&doc); err != nil {
+		return false, err
+	}
+	p.version = doc.Version
+	return true, nil
+}
+
+// store replaces the stored configurations with the ones of doc, the version
+// is written last.
+func (p *Poller) store(doc *Document) error {
+	for name, content := range doc.Checks {
+		if !checkName.MatchString(name) {
+			return fmt.Errorf("invalid check name %q in version %s", name, doc.Version)
+		}
+		var c plugin.Config
+		if err := yaml.Unmarshal([]byte(content), &c); err != nil {
+			return fmt.Errorf("invalid configuration of %s in version %s: %s", name, doc.Version, err)
+		}
+	}
+
+	if err := os.MkdirAll(p.dir, 0700); err != nil {
+		return err
+	}
+	for name, content := range doc.Checks {
+		if err := writeFile(filepath.Join(p.dir, name+".yaml"), []byte(content)); err != nil {
+			return err
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(p.dir, "*.yaml"))
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".yaml")
+		if _, ok := doc.Checks[name]; !ok {
+			if err := os.Remove(file); err != nil {
+				return err
+			}
+		}
+	}
+	return writeFile(filepath.Join(p.dir, versionFile), []byte(doc.Version+"\n"))
+}
+
+// writeFile writes through a temporary file, so that a configuration is
+// never loaded half-written.
+func writeFile(path string, data []byte) error {
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		_ = os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Run polls the endpoint until shutdown is closed, reload is called when a
+// new version has been stored.
+func (p *Poller) Run(shutdown chan struct{}, reload func()) {
+	log.Infof("Polling %s for the check configurations, running version %q", p.conf.GlobalConfig.RemoteConfigURL, p.version)
+
+	ticker := time.NewTicker(p.conf.GetRemoteConfigInterval())
+	defer ticker.Stop()
+	for {
+		changed, err := p.Poll()
+		if err != nil {
+			log.Warnf("Failed to poll the remote configuration: %s", err)
+		} else if changed {
+			log.Infof("Reloading for the remote configuration version %q", p.version)
+			reload()
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-shutdown:
+			return
+		}
+	}
+}
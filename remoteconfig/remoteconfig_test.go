@@ -0,0 +1,86 @@
+package remoteconfig
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func listDir(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestPoll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remoteconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	doc := Document{
+		Version: "1",
+		Checks: map[string]string{
+			"nginx": "instances:\n  - nginx_status_url: http://localhost/nginx_status/\n",
+			"redis": "instances:\n  - host: localhost\n",
+		},
+	}
+	var versions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		versions = append(versions, r.Header.Get(VersionHeader))
+		if r.Header.Get(VersionHeader) == doc.Version {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(doc)
+	}))
+	defer server.Close()
+
+	conf := config.DefaultConfig
+	conf.GlobalConfig.RemoteConfigURL = server.URL
+	conf.GlobalConfig.RemoteConfigPath = filepath.Join(dir, "remote")
+
+	p := NewPoller(&conf)
+	changed, err := p.Poll()
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []string{"nginx.yaml", "redis.yaml", "version"}, listDir(t, conf.GlobalConfig.RemoteConfigPath))
+
+	changed, err = p.Poll()
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// The version survives a reload, the removed checks are deleted.
+	doc = Document{Version: "2", Checks: map[string]string{"nginx": "instances: []\n"}}
+	p = NewPoller(&conf)
+	assert.Equal(t, "1", p.Version())
+	changed, err = p.Poll()
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "2", p.Version())
+	assert.Equal(t, []string{"nginx.yaml", "version"}, listDir(t, conf.GlobalConfig.RemoteConfigPath))
+	assert.Equal(t, []string{"", "1", "1"}, versions)
+
+	// An invalid version isn't stored.
+	doc = Document{Version: "3", Checks: map[string]string{"nginx": "instances: [", "../etc": ""}}
+	changed, err = p.Poll()
+	assert.Error(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, "2", p.Version())
+	content, _ := ioutil.ReadFile(filepath.Join(conf.GlobalConfig.RemoteConfigPath, "nginx.yaml"))
+	assert.Equal(t, "instances: []\n", string(content))
+}
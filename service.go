@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/winsvc"
+)
+
+// serviceName is the name of the Windows service and of its event log
+// source.
+const serviceName = "cloudinsight-agent"
+
+// The IDs of the events reported to the event log.
+const (
+	eventStarted = 1
+	eventStopped = 2
+	eventError   = 3
+)
+
+// runInstallService installs the agent as a Windows service, started with
+// the current configuration file.
+func runInstallService() {
+	confPath, err := config.Path(*fConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if confPath, err = filepath.Abs(confPath); err != nil {
+		log.Fatal(err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = winsvc.Install(winsvc.Config{
+		Name:        serviceName,
+		DisplayName: "Cloudinsight Agent",
+		Description: "Collects the metrics of the host and its services for Cloudinsight.",
+		Executable:  executable,
+		Args:        []string{"-config", confPath, "service"},
+	})
+	if err != nil {
+		log.Fatalf("failed to install the service: %s", err)
+	}
+	fmt.Printf("Installed the %s service, start it with: sc.exe start %s\n", serviceName, serviceName)
+}
+
+// runRemoveService stops and removes the Windows service.
+func runRemoveService() {
+	if err := winsvc.Remove(serviceName); err != nil {
+		log.Fatalf("failed to remove the service: %s", err)
+	}
+	fmt.Printf("Removed the %s service\n", serviceName)
+}
+
+// runService runs the agent under the Windows service control manager, the
+// errors are reported to the event log too.
+func runService() {
+	// The services start in the system directory, the checks are
+	// configured relative to the executable.
+	if executable, err := os.Executable(); err == nil {
+		if err = os.Chdir(filepath.Dir(executable)); err != nil {
+			log.Errorf("Failed to change to the directory of the agent: %s", err)
+		}
+	}
+
+	events, err := winsvc.OpenEventLog(serviceName)
+	if err != nil {
+		log.Errorf("Failed to open the event log: %s", err)
+	} else {
+		defer events.Close()
+		log.AddErrorHook(func(msg string) {
+			_ = events.Report(winsvc.Error, eventError, msg)
+		})
+	}
+	report := func(id uint32, msg string) {
+		if events != nil {
+			_ = events.Report(winsvc.Information, id, msg)
+		}
+	}
+
+	err = winsvc.Run(serviceName, func(stop <-chan struct{}) error {
+		report(eventStarted, fmt.Sprintf("Cloudinsight Agent %s started", config.VERSION))
+		run(stop)
+		report(eventStopped, "Cloudinsight Agent stopped")
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -5,26 +5,53 @@ import (
 	"github.com/cloudinsight/cloudinsight-agent/common/metric"
 )
 
-const interval = 10
-
-// NewAggregator XXX
+// NewAggregator creates the aggregator of one of the shards, the context limit
+// is spread across them. The metrics are transformed before being sharded.
 func NewAggregator(
 	metrics chan metric.Metric,
+	events chan metric.Event,
+	serviceChecks chan metric.ServiceCheck,
 	conf *config.Config,
+	filter *metric.Filter,
+	shards int,
 ) metric.Aggregator {
-	return metric.NewAggregator(metrics, interval, conf.GetHostname(), formatter, nil, nil, 0)
+	interval := conf.GetStatsdFlushInterval().Seconds()
+	contextLimit := conf.GlobalConfig.ContextLimit
+	if contextLimit > 0 && shards > 1 {
+		contextLimit = (contextLimit + shards - 1) / shards
+	}
+	agg := metric.NewAggregator(
+		metrics,
+		events,
+		serviceChecks,
+		interval,
+		conf.GetHostname(),
+		newFormatter(interval),
+		conf.GlobalConfig.HistogramAggregates,
+		conf.GlobalConfig.HistogramPercentiles,
+		conf.HistogramPrefixes(),
+		contextLimit,
+		filter,
+		nil,
+		int64(conf.GlobalConfig.LateSampleWindow),
+		0,
+	)
+	return metric.WithTags(agg, conf.GlobalConfig.Tags...)
 }
 
-// Format metrics coming from the Aggregator. Will look like:
+// newFormatter formats the metrics coming from the Aggregator flushed every
+// interval seconds. Will look like:
 // {"metric": "a.b.c", "points": [[1474867457, 2]], "tags": ["tag1", "tag2"], "host": "xxx", "device_name": "xxx", "type": "gauge", "interval": 10}
-func formatter(m metric.Metric) interface{} {
-	return map[string]interface{}{
-		"metric":      m.Name,
-		"points":      [1]interface{}{[2]interface{}{m.Timestamp, m.Value}},
-		"tags":        m.Tags,
-		"host":        m.Hostname,
-		"device_name": m.DeviceName,
-		"type":        m.Type,
-		"interval":    interval,
+func newFormatter(interval float64) metric.Formatter {
+	return func(m metric.Metric) interface{} {
+		return map[string]interface{}{
+			"metric":      m.Name,
+			"points":      [1]interface{}{[2]interface{}{m.Timestamp, m.Value}},
+			"tags":        m.Tags,
+			"host":        m.Hostname,
+			"device_name": m.DeviceName,
+			"type":        m.Type,
+			"interval":    interval,
+		}
 	}
 }
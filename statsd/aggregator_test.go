@@ -1,18 +1,32 @@
 package statsd
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 
 	"github.com/cloudinsight/cloudinsight-agent/common/metric"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFormatter(t *testing.T) {
 	m := metric.NewMetric("test.formatter", 99, []string{"test"})
-	actual := fmt.Sprintf("%v", formatter(m))
+	actual := fmt.Sprintf("%v", newFormatter(10)(m))
 	assert.Contains(t, actual, "metric:test.formatter")
 	assert.Contains(t, actual, "points:[[0 99]]")
 	assert.Contains(t, actual, "tags:[test]")
 	assert.Contains(t, actual, "interval:10")
 }
+
+func TestFormatterDistribution(t *testing.T) {
+	sketch := metric.NewSketch(metric.DefaultSketchRelativeAccuracy, metric.DefaultSketchMaxBins)
+	sketch.Add(1, 1)
+	m := metric.NewMetric("test.distribution", sketch, []string{"test"})
+	m.Type = "distribution"
+
+	data, err := json.Marshal(newFormatter(10)(m))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"points":[[0,{"alpha":0.01,"cnt":1,`)
+	assert.Contains(t, string(data), `"type":"distribution"`)
+}
@@ -0,0 +1,108 @@
+package statsd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+)
+
+const (
+	matchTypeGlob  = "glob"
+	matchTypeRegex = "regex"
+)
+
+type mappingTag struct {
+	key   string
+	value string
+}
+
+type mappingRule struct {
+	re   *regexp.Regexp
+	name string
+	tags []mappingTag
+}
+
+// mapper rewrites the names of the statsd metrics according to the
+// statsd_mapping rules, the first matching rule wins.
+type mapper struct {
+	rules []mappingRule
+}
+
+// newMapper compiles the mappings, the invalid ones are logged and skipped.
+func newMapper(mappings []config.StatsdMapping) *mapper {
+	m := &mapper{}
+	for _, mapping := range mappings {
+		rule, err := newMappingRule(mapping)
+		if err != nil {
+			log.Errorf("Failed to load statsd mapping: %s", err)
+			continue
+		}
+		m.rules = append(m.rules, rule)
+	}
+	return m
+}
+
+func newMappingRule(mapping config.StatsdMapping) (mappingRule, error) {
+	if mapping.Match == "" || mapping.Name == "" {
+		return mappingRule{}, fmt.Errorf("statsd mapping requires both match and name")
+	}
+
+	var pattern string
+	switch mapping.MatchType {
+	case "", matchTypeGlob:
+		pattern = globToRegex(mapping.Match)
+	case matchTypeRegex:
+		pattern = mapping.Match
+	default:
+		return mappingRule{}, fmt.Errorf("unknown match_type %q of statsd mapping %s", mapping.MatchType, mapping.Match)
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return mappingRule{}, fmt.Errorf("invalid statsd mapping %s: %s", mapping.Match, err)
+	}
+
+	rule := mappingRule{re: re, name: mapping.Name}
+	for key, value := range mapping.Tags {
+		rule.tags = append(rule.tags, mappingTag{key: key, value: value})
+	}
+	sort.Slice(rule.tags, func(i, j int) bool {
+		return rule.tags[i].key < rule.tags[j].key
+	})
+	return rule, nil
+}
+
+// globToRegex converts a glob like "api.*.count" to an anchored regex where
+// each * captures a single dot separated part of the name.
+func globToRegex(glob string) string {
+	parts := strings.Split(glob, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return "^" + strings.Join(parts, "([^.]+)") + "$"
+}
+
+// Map renames the metric and adds the tags of the first matching rule, it
+// returns false if no rule matches.
+func (mp *mapper) Map(m *metric.Metric) bool {
+	for _, rule := range mp.rules {
+		match := rule.re.FindStringSubmatchIndex(m.Name)
+		if match == nil {
+			continue
+		}
+
+		name := m.Name
+		m.Name = string(rule.re.ExpandString(nil, rule.name, name, match))
+		for _, tag := range rule.tags {
+			value := string(rule.re.ExpandString(nil, tag.value, name, match))
+			m.Tags = append(m.Tags, tag.key+":"+value)
+		}
+		return true
+	}
+	return false
+}
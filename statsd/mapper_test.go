@@ -0,0 +1,43 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapper(t *testing.T) {
+	mp := newMapper([]config.StatsdMapping{
+		{
+			Match: "api.*.*.count",
+			Name:  "api.requests",
+			Tags:  map[string]string{"endpoint": "$1", "region": "$2"},
+		},
+		{
+			Match:     `^jobs\.(\w+)\.(duration|wait)$`,
+			MatchType: "regex",
+			Name:      "jobs.$2",
+			Tags:      map[string]string{"job": "$1"},
+		},
+		{Match: "invalid.*", MatchType: "unknown", Name: "invalid"},
+		{Match: "(", MatchType: "regex", Name: "invalid"},
+	})
+	assert.Len(t, mp.rules, 2)
+
+	m := metric.NewMetric("api.login.us-east.count", 1, []string{"env:prod"})
+	assert.True(t, mp.Map(&m))
+	assert.Equal(t, "api.requests", m.Name)
+	assert.Equal(t, []string{"env:prod", "endpoint:login", "region:us-east"}, m.Tags)
+
+	m = metric.NewMetric("jobs.backup.wait", 1, nil)
+	assert.True(t, mp.Map(&m))
+	assert.Equal(t, "jobs.wait", m.Name)
+	assert.Equal(t, []string{"job:backup"}, m.Tags)
+
+	// A glob wildcard doesn't match across dots.
+	m = metric.NewMetric("api.v1.login.us-east.count", 1, nil)
+	assert.False(t, mp.Map(&m))
+	assert.Equal(t, "api.v1.login.us-east.count", m.Name)
+}
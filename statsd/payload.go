@@ -2,5 +2,6 @@ package statsd
 
 // Payload XXX
 type Payload struct {
-	Series []interface{} `json:"series"`
+	Series []interface{}          `json:"series,omitempty"`
+	Events map[string]interface{} `json:"events,omitempty"`
 }
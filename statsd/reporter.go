@@ -7,6 +7,7 @@ import (
 	"github.com/cloudinsight/cloudinsight-agent/common/config"
 	"github.com/cloudinsight/cloudinsight-agent/common/emitter"
 	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
 )
 
 // Reporter XXX
@@ -21,6 +22,9 @@ type Reporter struct {
 func NewReporter(conf *config.Config) *Reporter {
 	emitter := emitter.NewEmitter("Statsd")
 	api := api.NewAPI(conf.GetForwarderAddrWithScheme(), conf.GlobalConfig.LicenseKey, 5*time.Second)
+	api.CompressionLevel = conf.GlobalConfig.CompressionLevel
+	api.MaxPayloadSize = conf.GlobalConfig.MaxPayloadSize
+	api.Format = conf.GlobalConfig.PayloadFormat
 
 	r := &Reporter{
 		Emitter: emitter,
@@ -28,6 +32,14 @@ func NewReporter(conf *config.Config) *Reporter {
 		conf:    conf,
 	}
 	r.Emitter.Parent = r
+	r.Emitter.FlushJitter = conf.GetFlushJitter()
+
+	outputs, err := conf.NewOutputs()
+	if err != nil {
+		log.Errorf("Failed to create the outputs: %s", err)
+	}
+	r.Emitter.Outputs = outputs
+	r.Emitter.DisablePost = !conf.GlobalConfig.CloudinsightOutput
 
 	return r
 }
@@ -46,3 +58,24 @@ func (r *Reporter) Post(metrics []interface{}) error {
 	}
 	return err
 }
+
+// PostEvents sends the events to Forwarder API.
+func (r *Reporter) PostEvents(events []metric.Event) error {
+	payload := Payload{}
+	payload.Events = metric.FormatEvents(events)
+
+	err := r.api.SubmitMetrics(&payload)
+	if err == nil {
+		log.Debugf("Post %d events", len(events))
+	}
+	return err
+}
+
+// PostServiceChecks sends the service checks to Forwarder API.
+func (r *Reporter) PostServiceChecks(serviceChecks []metric.ServiceCheck) error {
+	err := r.api.SubmitServiceChecks(serviceChecks)
+	if err == nil {
+		log.Debugf("Post %d service checks", len(serviceChecks))
+	}
+	return err
+}
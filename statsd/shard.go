@@ -0,0 +1,44 @@
+package statsd
+
+import (
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+)
+
+// shardOf returns the aggregator shard of the metrics named name. All the
+// contexts of a metric are aggregated by the same shard, so that the tags
+// stripped by the filter can't split a context across shards.
+func shardOf(name string, shards int) int {
+	if shards <= 1 {
+		return 0
+	}
+
+	// FNV-1a
+	h := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		h ^= uint32(name[i])
+		h *= 16777619
+	}
+	return int(h % uint32(shards))
+}
+
+// split distributes the metrics of result across shards, the events and the
+// service checks go to the first one. The shards without anything to
+// aggregate are left empty.
+func split(result metric.ParsedPacket, shards int) []metric.ParsedPacket {
+	if shards <= 1 {
+		return []metric.ParsedPacket{result}
+	}
+
+	split := make([]metric.ParsedPacket, shards)
+	split[0].Events = result.Events
+	split[0].ServiceChecks = result.ServiceChecks
+	for _, m := range result.Metrics {
+		i := shardOf(m.Name, shards)
+		split[i].Metrics = append(split[i].Metrics, m)
+	}
+	return split
+}
+
+func isEmpty(p metric.ParsedPacket) bool {
+	return len(p.Metrics) == 0 && len(p.Events) == 0 && len(p.ServiceChecks) == 0
+}
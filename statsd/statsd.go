@@ -1,13 +1,19 @@
 package statsd
 
 import (
+	"fmt"
 	"net"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/emitter"
 	"github.com/cloudinsight/cloudinsight-agent/common/log"
 	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/cloudinsight/cloudinsight-agent/common/privileges"
+	"github.com/cloudinsight/cloudinsight-agent/common/watchdog"
 )
 
 const (
@@ -25,33 +31,86 @@ const (
 func NewStatsd(conf *config.Config) *Statsd {
 	reporter := NewReporter(conf)
 	return &Statsd{
-		conf:     conf,
-		reporter: reporter,
-		in:       make(chan []byte, AllowedPendingMessages),
+		conf:        conf,
+		reporter:    reporter,
+		mapper:      newMapper(conf.StatsdMappings),
+		transformer: conf.Transformer(),
+		in:          make(chan packet, AllowedPendingMessages),
 	}
 }
 
+// packet is a datagram received by one of the listeners, with the tags
+// identifying its sender.
+type packet struct {
+	data []byte
+	tags []string
+}
+
 // Statsd XXX
 type Statsd struct {
 	conf     *config.Config
 	reporter *Reporter
+	mapper   *mapper
+	// transformer is applied when decoding rather than by the aggregators,
+	// since renaming a metric changes its shard.
+	transformer *metric.Transformer
 
 	// Channel for all incoming statsd packets
-	in chan []byte
+	in chan packet
+
+	telemetry telemetry
+
+	// listening is set to 1 once the UDP listener is bound, the heartbeat
+	// beats every flush from then on, unless the parsing is stuck.
+	listening int32
+	heartbeat watchdog.Heartbeat
 
-	// drops tracks the number of dropped metrics.
-	drops int
+	// Shedding drops the packets received while it returns true, e.g. when
+	// the agent uses too much memory. The TCP connections aren't read
+	// meanwhile instead, which slows down their senders.
+	Shedding func() bool
+}
+
+// Heartbeat returns the heartbeat of Statsd, for the watchdog.
+func (s *Statsd) Heartbeat() *watchdog.Heartbeat {
+	return &s.heartbeat
 }
 
 // Run XXX
 func (s *Statsd) Run(shutdown chan struct{}) error {
 	var wg sync.WaitGroup
-	interval := 10 * time.Second
+	interval := s.conf.GetStatsdFlushInterval()
 
 	// channel shared between all Plugin threads for collecting metrics
 	metricC := make(chan metric.Metric, 10000)
+	eventC := make(chan metric.Event, emitter.DefaultEventBufferLimit)
+	serviceCheckC := make(chan metric.ServiceCheck, emitter.DefaultServiceCheckBufferLimit)
 
-	wg.Add(3)
+	// Packets are decoded concurrently, but an aggregator isn't safe for
+	// concurrent use, so the metrics are sharded by name across aggregators
+	// which are each owned by a parser goroutine.
+	shards := s.conf.GlobalConfig.StatsdAggregatorShards
+	if shards <= 0 {
+		shards = 1
+	}
+	parsed := make([]chan metric.ParsedPacket, shards)
+	for i := range parsed {
+		parsed[i] = make(chan metric.ParsedPacket, AllowedPendingMessages/shards)
+	}
+	workers := s.conf.GlobalConfig.StatsdWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	var decoders sync.WaitGroup
+	decoders.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer decoders.Done()
+			s.decode(shutdown, parsed)
+		}()
+	}
+
+	wg.Add(2 + shards)
 	go func() {
 		defer wg.Done()
 		if err := s.listen(shutdown); err != nil {
@@ -61,98 +120,203 @@ func (s *Statsd) Run(shutdown chan struct{}) error {
 
 	go func() {
 		defer wg.Done()
-		if err := s.reporter.Run(shutdown, metricC, interval); err != nil {
+		if err := s.reporter.Run(shutdown, metricC, eventC, serviceCheckC, interval); err != nil {
 			log.Infof("Reporter routine failed, exiting: %s", err.Error())
 			close(shutdown)
 		}
 	}()
 
-	go func() {
-		defer wg.Done()
-		if err := s.parser(shutdown, metricC, interval); err != nil {
-			log.Info(err)
-		}
-	}()
+	for i := range parsed {
+		go func(shard int) {
+			defer wg.Done()
+			if err := s.parser(shutdown, shard, shards, parsed[shard], metricC, eventC, serviceCheckC, interval); err != nil {
+				log.Info(err)
+			}
+		}(i)
+	}
+
+	if s.conf.GlobalConfig.StatsdTCPPort > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.listenTCP(shutdown); err != nil {
+				log.Info(err)
+			}
+		}()
+	}
+
+	if path := s.conf.GlobalConfig.StatsdSocket; path != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.listenUnix(shutdown, path); err != nil {
+				log.Info(err)
+			}
+		}()
+	}
 
 	wg.Wait()
+	decoders.Wait()
 	return nil
 }
 
 func (s *Statsd) listen(shutdown chan struct{}) error {
-	addr, err := net.ResolveUDPAddr("udp", s.conf.GetStatsdAddr())
+	addr := s.conf.GetStatsdAddr()
+	conn, err := privileges.ListenUDP(addr)
 	if err != nil {
-		log.Fatalln("Can't resolve address:", err)
+		return fmt.Errorf("error listening: %s", err)
 	}
 
-	conn, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		log.Fatalln("Error listening:", err)
+	if err := setReadBuffer(conn, s.conf.GlobalConfig.StatsdReadBuffer); err != nil {
+		s.closeConn(conn)
+		return err
 	}
-	defer s.closeConn(conn)
 
 	log.Infoln("Statsd listening on:", addr)
+	atomic.StoreInt32(&s.listening, 1)
 
+	// Closing the connection unblocks the pending read, so that we don't wait
+	// for the next packet to notice the shutdown.
+	go func() {
+		<-shutdown
+		log.Infof("Statsd server thread exit")
+		s.closeConn(conn)
+	}()
+
+	buf := make([]byte, UDPMaxPacketSize)
 	for {
-		select {
-		case <-shutdown:
-			log.Infof("Statsd server thread exit")
-			if err := conn.Close(); err != nil {
-				return err
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-shutdown:
+				return nil
+			default:
 			}
-			return nil
-		default:
-			s.handleClient(conn)
+			log.Infoln("failed to read UDP msg because of ", err.Error())
+			continue
 		}
+		s.handlePacket(buf[:n])
+	}
+}
+
+func setReadBuffer(conn interface {
+	SetReadBuffer(bytes int) error
+}, size int) error {
+	if size <= 0 {
+		return nil
+	}
+	if err := conn.SetReadBuffer(size); err != nil {
+		return fmt.Errorf("can't set the read buffer to %d bytes: %s", size, err)
 	}
+	return nil
 }
 
-func (s *Statsd) closeConn(conn *net.UDPConn) {
+func (s *Statsd) closeConn(conn net.Conn) {
 	if err := conn.Close(); err != nil {
 		log.Info(err)
 	}
 }
 
-func (s *Statsd) handleClient(conn *net.UDPConn) {
-	buf := make([]byte, UDPMaxPacketSize)
-	n, _, err := conn.ReadFromUDP(buf)
-	if err != nil {
-		log.Infoln("failed to read UDP msg because of ", err.Error())
+func (s *Statsd) handlePacket(data []byte, tags ...string) {
+	s.telemetry.received(len(data))
+	if s.Shedding != nil && s.Shedding() {
+		atomic.AddInt64(&s.telemetry.drops, 1)
 		return
 	}
 
-	bufCopy := make([]byte, n)
-	copy(bufCopy, buf[:n])
+	bufCopy := make([]byte, len(data))
+	copy(bufCopy, data)
 
 	select {
-	case s.in <- bufCopy:
+	case s.in <- packet{data: bufCopy, tags: tags}:
 	default:
-		s.drops++
-		if s.drops == 1 || s.drops%AllowedPendingMessages == 0 {
+		drops := atomic.AddInt64(&s.telemetry.drops, 1)
+		if drops == 1 || drops%AllowedPendingMessages == 0 {
 			log.Infof("ERROR: statsd message queue full. "+
-				"We have dropped %d messages so far. ", s.drops)
+				"We have dropped %d messages so far. ", drops)
+		}
+	}
+}
+
+// decode monitors the s.in channel, if there is a packet ready, it parses the
+// packet into metrics, events and service checks and passes them to the parser.
+func (s *Statsd) decode(shutdown chan struct{}, parsed []chan metric.ParsedPacket) {
+	for {
+		select {
+		case <-shutdown:
+			return
+		case p := <-s.in:
+			log.Debugf("Received packet: %s", string(p.data))
+			result := metric.ParsePackets(string(p.data), p.tags...)
+			if result.Invalid > 0 {
+				atomic.AddInt64(&s.telemetry.parseErrors, int64(result.Invalid))
+			}
+			atomic.AddInt64(&s.telemetry.metrics, int64(len(result.Metrics)))
+			if len(result.Metrics) == 0 && len(result.Events) == 0 && len(result.ServiceChecks) == 0 {
+				continue
+			}
+			for i := range result.Metrics {
+				m := &result.Metrics[i]
+				s.mapper.Map(m)
+				m.Type = s.transformer.Apply(m.Type, m)
+			}
+			for i, shard := range split(result, len(parsed)) {
+				if isEmpty(shard) {
+					continue
+				}
+				select {
+				case parsed[i] <- shard:
+				case <-shutdown:
+					return
+				}
+			}
 		}
 	}
 }
 
-// parser monitors the s.in channel, if there is a packet ready, it parses the
-// packet into statsd strings and then calls parseStatsdLine, which parses a
-// single statsd metric.
-func (s *Statsd) parser(shutdown chan struct{}, metricC chan metric.Metric, interval time.Duration) error {
+// parser adds the decoded metrics, events and service checks to the aggregator of the shard
+// and flushes it every interval. The first shard also reports the telemetry.
+func (s *Statsd) parser(
+	shutdown chan struct{},
+	shard int,
+	shards int,
+	parsed chan metric.ParsedPacket,
+	metricC chan metric.Metric,
+	eventC chan metric.Event,
+	serviceCheckC chan metric.ServiceCheck,
+	interval time.Duration,
+) error {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	agg := NewAggregator(metricC, s.conf)
+	filter, err := s.conf.NewFilter()
+	if err != nil {
+		return err
+	}
+	agg := NewAggregator(metricC, eventC, serviceCheckC, s.conf, filter, shards)
 
-	var packet []byte
 	for {
 		select {
 		case <-shutdown:
 			return nil
 		case <-ticker.C:
+			if shard == 0 {
+				s.telemetry.submit(agg, len(s.in))
+			}
 			agg.Flush()
-		case packet = <-s.in:
-			log.Debugf("Received packet: %s", string(packet))
-			agg.SubmitPackets(string(packet))
+			if shard == 0 && atomic.LoadInt32(&s.listening) == 1 {
+				s.heartbeat.Beat()
+			}
+		case result := <-parsed:
+			for _, m := range result.Metrics {
+				agg.Add(m.Type, m)
+			}
+			for _, e := range result.Events {
+				agg.AddEvent(e)
+			}
+			for _, sc := range result.ServiceChecks {
+				agg.AddServiceCheck(sc)
+			}
 		}
 	}
 }
@@ -0,0 +1,88 @@
+package statsd
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	s := &Statsd{mapper: &mapper{}, in: make(chan packet, 2)}
+	parsed := make(chan metric.ParsedPacket, 2)
+	shutdown := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.decode(shutdown, []chan metric.ParsedPacket{parsed})
+		close(done)
+	}()
+
+	s.handlePacket([]byte("invalid"))
+	s.handlePacket([]byte("my.counter:1|c\nmy.gauge:2|g"), "container_id:abc")
+
+	result := <-parsed
+	assert.Len(t, result.Metrics, 2)
+	assert.Equal(t, "my.counter", result.Metrics[0].Name)
+	assert.Equal(t, []string{"container_id:abc"}, result.Metrics[1].Tags)
+
+	close(shutdown)
+	<-done
+}
+
+func TestDecodeShards(t *testing.T) {
+	s := &Statsd{mapper: &mapper{}, in: make(chan packet, 1)}
+	parsed := []chan metric.ParsedPacket{
+		make(chan metric.ParsedPacket, 1),
+		make(chan metric.ParsedPacket, 1),
+		make(chan metric.ParsedPacket, 1),
+	}
+	shutdown := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.decode(shutdown, parsed)
+		close(done)
+	}()
+
+	names := []string{"a.counter", "b.counter", "c.counter", "d.counter", "e.counter"}
+	packet := "_sc|my.check|0"
+	for _, name := range names {
+		packet += "\n" + name + ":1|c|#id:1\n" + name + ":1|c|#id:2"
+	}
+	s.handlePacket([]byte(packet))
+
+	received := 0
+	for i := 0; i < len(parsed); i++ {
+		var result metric.ParsedPacket
+		select {
+		case result = <-parsed[i]:
+		case <-time.After(100 * time.Millisecond):
+			continue
+		}
+		if i == 0 {
+			assert.Len(t, result.ServiceChecks, 1)
+		}
+		for _, m := range result.Metrics {
+			assert.Equal(t, i, shardOf(m.Name, len(parsed)))
+		}
+		received += len(result.Metrics)
+	}
+	assert.Equal(t, 2*len(names), received)
+
+	close(shutdown)
+	<-done
+}
+
+func TestShardOf(t *testing.T) {
+	assert.Equal(t, 0, shardOf("my.metric", 1))
+	assert.Equal(t, shardOf("my.metric", 8), shardOf("my.metric", 8))
+
+	seen := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		shard := shardOf(fmt.Sprintf("my.metric.%d", i), 4)
+		assert.True(t, shard >= 0 && shard < 4)
+		seen[shard] = true
+	}
+	assert.Len(t, seen, 4)
+}
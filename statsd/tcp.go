@@ -0,0 +1,166 @@
+package statsd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"github.com/cloudinsight/cloudinsight-agent/common/privileges"
+)
+
+const (
+	// tcpIdleTimeout closes the connections which haven't sent anything for
+	// a while.
+	tcpIdleTimeout = 5 * time.Minute
+
+	// tcpMaxBatchSize is the maximum size of the lines read from a connection
+	// that are queued as a single packet.
+	tcpMaxBatchSize = UDPMaxPacketSize
+
+	// tcpSheddingPollInterval is how often a connection paused while
+	// shedding checks whether it may resume.
+	tcpSheddingPollInterval = 100 * time.Millisecond
+)
+
+func (s *Statsd) listenTCP(shutdown chan struct{}) error {
+	addr := s.conf.GetStatsdTCPAddr()
+	l, err := privileges.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening: %s", err)
+	}
+
+	global := s.conf.GlobalConfig
+	if global.StatsdTLSCert != "" || global.StatsdTLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(global.StatsdTLSCert, global.StatsdTLSKey)
+		if err != nil {
+			_ = l.Close()
+			return fmt.Errorf("can't load the statsd TLS certificate: %s", err)
+		}
+		l = tls.NewListener(l, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		})
+		log.Infoln("Statsd listening on (TLS):", addr)
+	} else {
+		log.Infoln("Statsd listening on (TCP):", addr)
+	}
+
+	go func() {
+		<-shutdown
+		if err := l.Close(); err != nil {
+			log.Info(err)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-shutdown:
+				return nil
+			default:
+			}
+			log.Infoln("failed to accept TCP connection because of ", err.Error())
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handleConn(shutdown, conn)
+		}()
+	}
+}
+
+// handleConn reads the newline separated lines sent on the connection. The
+// complete lines already buffered are queued together, and unlike UDP nothing is
+// dropped when the queue is full or while shedding: we stop reading, which
+// slows down the sender.
+func (s *Statsd) handleConn(shutdown chan struct{}, conn net.Conn) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-shutdown:
+		case <-done:
+		}
+		if err := conn.Close(); err != nil {
+			log.Debug(err)
+		}
+	}()
+
+	r := bufio.NewReaderSize(conn, tcpMaxBatchSize)
+	var batch []byte
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			log.Infof("Dropping a statsd line longer than %d bytes from %s", tcpMaxBatchSize, conn.RemoteAddr())
+			if _, err = r.ReadBytes('\n'); err != nil {
+				return
+			}
+			continue
+		}
+
+		if len(batch)+len(line) > tcpMaxBatchSize {
+			if !s.enqueue(shutdown, batch) {
+				return
+			}
+			batch = nil
+		}
+		batch = append(batch, line...)
+
+		if err != nil || !hasLine(r) {
+			if !s.enqueue(shutdown, batch) {
+				return
+			}
+			batch = nil
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				log.Debugf("Closing statsd connection from %s: %s", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+// hasLine tells whether a complete line is already buffered, otherwise the
+// next read may block and the batch should be queued first.
+func hasLine(r *bufio.Reader) bool {
+	buffered, _ := r.Peek(r.Buffered())
+	return bytes.IndexByte(buffered, '\n') >= 0
+}
+
+// enqueue queues the packet, waiting for room in the queue and for the
+// shedding to stop. It returns false on shutdown.
+func (s *Statsd) enqueue(shutdown chan struct{}, data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+	s.telemetry.received(len(data))
+
+	for s.Shedding != nil && s.Shedding() {
+		select {
+		case <-time.After(tcpSheddingPollInterval):
+		case <-shutdown:
+			return false
+		}
+	}
+
+	select {
+	case s.in <- packet{data: data}:
+		return true
+	case <-shutdown:
+		return false
+	}
+}
@@ -0,0 +1,107 @@
+package statsd
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleConn(t *testing.T) {
+	s := &Statsd{in: make(chan packet, 1)}
+	shutdown := make(chan struct{})
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.handleConn(shutdown, server)
+		close(done)
+	}()
+
+	_, err := client.Write([]byte("my.counter:1|c\nmy.gauge:"))
+	require.NoError(t, err)
+	_, err = client.Write([]byte("2|g\n"))
+	require.NoError(t, err)
+
+	p := <-s.in
+	assert.Equal(t, "my.counter:1|c\n", string(p.data))
+	p = <-s.in
+	assert.Equal(t, "my.gauge:2|g\n", string(p.data))
+
+	// The last line doesn't need a trailing newline.
+	_, err = client.Write([]byte("my.set:a|s"))
+	require.NoError(t, err)
+	require.NoError(t, client.Close())
+	<-done
+
+	p = <-s.in
+	assert.Equal(t, "my.set:a|s", string(p.data))
+}
+
+func TestHandleConnBackpressure(t *testing.T) {
+	s := &Statsd{in: make(chan packet, 1)}
+	shutdown := make(chan struct{})
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.handleConn(shutdown, server)
+		close(done)
+	}()
+
+	_, err := client.Write([]byte("first:1|c\n"))
+	require.NoError(t, err)
+	_, err = client.Write([]byte("second:1|c\n"))
+	require.NoError(t, err)
+
+	// The queue is full, the second packet waits instead of being dropped.
+	select {
+	case <-done:
+		t.Fatal("connection closed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, int64(0), s.telemetry.drops)
+	assert.Equal(t, "first:1|c\n", string((<-s.in).data))
+	assert.Equal(t, "second:1|c\n", string((<-s.in).data))
+
+	close(shutdown)
+	<-done
+}
+
+func TestHandleConnShedding(t *testing.T) {
+	var shedding int32 = 1
+	s := &Statsd{
+		in:       make(chan packet, 1),
+		Shedding: func() bool { return atomic.LoadInt32(&shedding) == 1 },
+	}
+	shutdown := make(chan struct{})
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.handleConn(shutdown, server)
+		close(done)
+	}()
+
+	_, err := client.Write([]byte("first:1|c\n"))
+	require.NoError(t, err)
+
+	// The connection is paused while shedding, instead of dropping.
+	select {
+	case <-s.in:
+		t.Fatal("packet queued while shedding")
+	case <-time.After(2 * tcpSheddingPollInterval):
+	}
+	assert.Equal(t, int64(0), s.telemetry.drops)
+
+	atomic.StoreInt32(&shedding, 0)
+	select {
+	case p := <-s.in:
+		assert.Equal(t, "first:1|c\n", string(p.data))
+	case <-time.After(time.Second):
+		t.Fatal("no packet received")
+	}
+
+	close(shutdown)
+	<-done
+}
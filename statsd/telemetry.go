@@ -0,0 +1,57 @@
+package statsd
+
+import (
+	"sync/atomic"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+)
+
+// telemetry counts what happens to the packets received by the listeners,
+// so that users can tell whether their metrics reach the agent. The counters
+// are updated concurrently by the listeners and the decoders.
+type telemetry struct {
+	packets     int64
+	bytes       int64
+	drops       int64
+	parseErrors int64
+	metrics     int64
+
+	// last holds the counters at the previous submit, it's only used by the
+	// parser goroutine.
+	last [5]int64
+}
+
+func (t *telemetry) counters() [5]int64 {
+	return [5]int64{
+		atomic.LoadInt64(&t.packets),
+		atomic.LoadInt64(&t.bytes),
+		atomic.LoadInt64(&t.drops),
+		atomic.LoadInt64(&t.parseErrors),
+		atomic.LoadInt64(&t.metrics),
+	}
+}
+
+func (t *telemetry) received(bytes int) {
+	atomic.AddInt64(&t.packets, 1)
+	atomic.AddInt64(&t.bytes, int64(bytes))
+}
+
+var telemetryNames = [5]string{
+	"cloudinsight.statsd.packets",
+	"cloudinsight.statsd.bytes",
+	"cloudinsight.statsd.packets_dropped",
+	"cloudinsight.statsd.parse_errors",
+	"cloudinsight.statsd.metrics",
+}
+
+// submit adds the counts since the previous submit and the number of packets
+// waiting to be decoded to the aggregator.
+func (t *telemetry) submit(agg metric.Aggregator, queueSize int) {
+	current := t.counters()
+	for i, name := range telemetryNames {
+		agg.Add("count", metric.NewMetric(name, current[i]-t.last[i]))
+	}
+	t.last = current
+
+	agg.Add("gauge", metric.NewMetric("cloudinsight.statsd.queue_size", queueSize))
+}
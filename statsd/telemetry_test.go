@@ -0,0 +1,74 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTelemetry(t *testing.T) {
+	s := &Statsd{mapper: &mapper{}, in: make(chan packet, 2)}
+	parsed := make(chan metric.ParsedPacket, 2)
+	shutdown := make(chan struct{})
+	done := make(chan struct{})
+
+	s.handlePacket([]byte("my.counter:1|c\ninvalid"))
+	s.handlePacket([]byte("my.gauge:1|g"))
+	// The queue is full.
+	s.handlePacket([]byte("my.gauge:2|g"))
+
+	agg := &metric.MockAggregator{}
+	s.telemetry.submit(agg, len(s.in))
+	expected := map[string]float64{
+		"cloudinsight.statsd.packets":         3,
+		"cloudinsight.statsd.bytes":           46,
+		"cloudinsight.statsd.packets_dropped": 1,
+		"cloudinsight.statsd.parse_errors":    0,
+		"cloudinsight.statsd.metrics":         0,
+		"cloudinsight.statsd.queue_size":      2,
+	}
+	for name, value := range expected {
+		actual, ok := agg.Value(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, value, actual, name)
+	}
+
+	go func() {
+		s.decode(shutdown, []chan metric.ParsedPacket{parsed})
+		close(done)
+	}()
+	<-parsed
+	<-parsed
+	close(shutdown)
+	<-done
+
+	agg = &metric.MockAggregator{}
+	s.telemetry.submit(agg, len(s.in))
+	expected = map[string]float64{
+		"cloudinsight.statsd.packets":         0,
+		"cloudinsight.statsd.bytes":           0,
+		"cloudinsight.statsd.packets_dropped": 0,
+		"cloudinsight.statsd.parse_errors":    1,
+		"cloudinsight.statsd.metrics":         2,
+		"cloudinsight.statsd.queue_size":      0,
+	}
+	for name, value := range expected {
+		actual, ok := agg.Value(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, value, actual, name)
+	}
+}
+
+func TestShedding(t *testing.T) {
+	shedding := true
+	s := &Statsd{mapper: &mapper{}, in: make(chan packet, 2), Shedding: func() bool { return shedding }}
+
+	s.handlePacket([]byte("my.gauge:1|g"))
+	assert.Len(t, s.in, 0)
+	assert.Equal(t, int64(1), s.telemetry.drops)
+
+	shedding = false
+	s.handlePacket([]byte("my.gauge:2|g"))
+	assert.Len(t, s.in, 1)
+}
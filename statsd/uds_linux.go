@@ -0,0 +1,203 @@
+package statsd
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/log"
+	"golang.org/x/sys/unix"
+)
+
+// originCacheExpiry is how long the tags resolved for a sender pid are kept,
+// pids get reused so they can't be cached forever.
+const originCacheExpiry = time.Minute
+
+// originCacheSize bounds the number of pids cached, in case many short
+// lived processes send metrics within originCacheExpiry.
+const originCacheSize = 10000
+
+var containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// cgroupPath is replaced in tests.
+var cgroupPath = func(pid int32) string {
+	return fmt.Sprintf("/proc/%d/cgroup", pid)
+}
+
+func (s *Statsd) listenUnix(shutdown chan struct{}, path string) error {
+	if fi, err := os.Stat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s already exists and is not a socket", path)
+		}
+		// Left over from a previous run.
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("error listening: %s", err)
+	}
+	if err := setReadBuffer(conn, s.conf.GlobalConfig.StatsdReadBuffer); err != nil {
+		s.closeConn(conn)
+		return err
+	}
+	// Any local process should be able to submit metrics.
+	if err := os.Chmod(path, 0722); err != nil {
+		s.closeConn(conn)
+		return err
+	}
+
+	origin := s.conf.GlobalConfig.StatsdOriginDetection
+	if origin {
+		if err := enablePassCred(conn); err != nil {
+			s.closeConn(conn)
+			return fmt.Errorf("can't enable SO_PASSCRED on %s: %s", path, err)
+		}
+	}
+
+	log.Infoln("Statsd listening on:", path)
+
+	go func() {
+		<-shutdown
+		s.closeConn(conn)
+	}()
+	defer func() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Info(err)
+		}
+	}()
+
+	resolver := newOriginResolver()
+	buf := make([]byte, UDPMaxPacketSize)
+	oob := make([]byte, unix.CmsgSpace(unix.SizeofUcred))
+	for {
+		n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			select {
+			case <-shutdown:
+				return nil
+			default:
+			}
+			log.Infoln("failed to read unix socket msg because of ", err.Error())
+			continue
+		}
+
+		var tags []string
+		if origin {
+			tags = resolver.tags(oob[:oobn])
+		}
+		s.handlePacket(buf[:n], tags...)
+	}
+}
+
+func enablePassCred(conn *net.UnixConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_PASSCRED, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+type originEntry struct {
+	tags    []string
+	expires time.Time
+}
+
+// originResolver maps the credentials attached to a datagram to the tags of
+// the sender. The expired entries are purged every originCacheExpiry.
+type originResolver struct {
+	sync.Mutex
+	cache     map[int32]originEntry
+	nextPurge time.Time
+}
+
+func newOriginResolver() *originResolver {
+	return &originResolver{
+		cache: make(map[int32]originEntry),
+	}
+}
+
+func (r *originResolver) tags(oob []byte) []string {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		log.Debugf("Failed to parse the socket control message: %s", err)
+		return nil
+	}
+
+	for i := range msgs {
+		cred, err := unix.ParseUnixCredentials(&msgs[i])
+		if err != nil {
+			continue
+		}
+		return r.lookup(cred.Pid, time.Now())
+	}
+	return nil
+}
+
+func (r *originResolver) lookup(pid int32, now time.Time) []string {
+	r.Lock()
+	defer r.Unlock()
+
+	if entry, ok := r.cache[pid]; ok && now.Before(entry.expires) {
+		return entry.tags
+	}
+
+	var tags []string
+	if id := containerID(pid); id != "" {
+		tags = []string{"container_id:" + id}
+	}
+	if !now.Before(r.nextPurge) || len(r.cache) >= originCacheSize {
+		r.purge(now)
+	}
+	r.cache[pid] = originEntry{tags: tags, expires: now.Add(originCacheExpiry)}
+	return tags
+}
+
+// purge removes the expired entries, or all of them when the cache is still
+// full, since the pids are then resolved again at worst.
+func (r *originResolver) purge(now time.Time) {
+	for pid, entry := range r.cache {
+		if !now.Before(entry.expires) {
+			delete(r.cache, pid)
+		}
+	}
+	if len(r.cache) >= originCacheSize {
+		r.cache = make(map[int32]originEntry)
+	}
+	r.nextPurge = now.Add(originCacheExpiry)
+}
+
+// containerID extracts the container ID from the cgroup of a process, it
+// returns an empty string if the process doesn't run in a container.
+func containerID(pid int32) string {
+	f, err := os.Open(cgroupPath(pid))
+	if err != nil {
+		log.Debugf("Failed to read the cgroup of pid %d: %s", pid, err)
+		return ""
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if id := containerIDPattern.FindString(scanner.Text()); id != "" {
+			return id
+		}
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+package statsd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statsd")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "statsd.sock")
+
+	s := &Statsd{
+		conf: &config.Config{},
+		in:   make(chan packet, 1),
+	}
+	s.conf.GlobalConfig.StatsdOriginDetection = true
+
+	shutdown := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- s.listenUnix(shutdown, path)
+	}()
+
+	var conn net.Conn
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("unixgram", path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("my.counter:1|c"))
+	require.NoError(t, err)
+
+	select {
+	case p := <-s.in:
+		assert.Equal(t, "my.counter:1|c", string(p.data))
+	case <-time.After(time.Second):
+		t.Fatal("no packet received")
+	}
+
+	close(shutdown)
+	assert.NoError(t, <-done)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestOriginResolver(t *testing.T) {
+	cgroupPath = func(pid int32) string {
+		if pid == 1 {
+			return "testdata/cgroup"
+		}
+		return "testdata/missing"
+	}
+
+	now := time.Now()
+	r := newOriginResolver()
+	assert.Equal(t,
+		[]string{"container_id:3726184226f5d3147c25fdeab5b60097e378e8a720503a5e19ecfdf29f869860"},
+		r.lookup(1, now))
+	assert.Nil(t, r.lookup(2, now))
+	assert.Len(t, r.cache, 2)
+
+	// The expired entries are purged when another pid is resolved.
+	now = now.Add(originCacheExpiry / 2)
+	r.lookup(2, now)
+	now = now.Add(originCacheExpiry)
+	r.lookup(3, now)
+	assert.Len(t, r.cache, 1)
+	_, ok := r.cache[3]
+	assert.True(t, ok)
+
+	// The cache is bounded.
+	for pid := int32(0); pid < 2*originCacheSize; pid++ {
+		r.lookup(pid, now)
+	}
+	assert.True(t, len(r.cache) <= originCacheSize)
+}
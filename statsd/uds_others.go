@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package statsd
+
+import "fmt"
+
+func (s *Statsd) listenUnix(shutdown chan struct{}, path string) error {
+	return fmt.Errorf("statsd_socket is only supported on Linux")
+}
@@ -0,0 +1,315 @@
+// Package upgrade replaces the binary of the agent with the latest release,
+// for the fleets without configuration management. The releases are
+// described by the upgrade_url endpoint and signed with the ed25519 key
+// whose public half is upgrade_public_key, an unsigned or tampered binary is
+// never installed, nor is a release older than the running one unless the
+// upgrade is forced.
+package upgrade
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/cloudinsight/cloudinsight-agent/common/proxy"
+	"github.com/cloudinsight/cloudinsight-agent/common/util"
+)
+
+// MaxBinarySize bounds the size of the downloaded binaries.
+const MaxBinarySize = 256 << 20
+
+// Release is the latest release served by the endpoint for the system and
+// the architecture of the agent.
+type Release struct {
+	Version string `json:"version"`
+	// URL is the URL of the binary, relative to the endpoint.
+	URL string `json:"url"`
+	// SHA256 is the hex digest of the binary, Signature the base64 ed25519
+	// signature of its SignedMessage.
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature"`
+}
+
+// SignedMessage returns the message the signature of a release is computed
+// over. It binds the version, the system and the architecture of the release
+// to the digest of its binary, so that a signed binary can't be served as
+// another version, e.g. to downgrade the agent to a release with known flaws,
+// or for another system.
+func SignedMessage(version, goos, goarch string, digest []byte) []byte {
+	return []byte(fmt.Sprintf("cloudinsight-agent %s %s/%s sha256:%x", version, goos, goarch, digest))
+}
+
+// Upgrader downloads and installs the releases.
+type Upgrader struct {
+	// Force installs the releases which aren't newer than the running
+	// version.
+	Force bool
+
+	endpoint  string
+	publicKey ed25519.PublicKey
+	client    *http.Client
+}
+
+// New creates a new instance of Upgrader from the upgrade options of conf.
+func New(conf *config.Config) (*Upgrader, error) {
+	if conf.GlobalConfig.UpgradeURL == "" {
+		return nil, fmt.Errorf("upgrade_url must be specified to upgrade the agent")
+	}
+	key, err := base64.StdEncoding.DecodeString(conf.GlobalConfig.UpgradePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("upgrade_public_key must be a base64 ed25519 public key, the releases can't be verified otherwise")
+	}
+
+	tlsConfig, err := conf.ForwarderTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy.FromConfig
+	transport.TLSClientConfig = tlsConfig
+
+	return &Upgrader{
+		endpoint:  conf.GlobalConfig.UpgradeURL,
+		publicKey: ed25519.PublicKey(key),
+		client:    &http.Client{Transport: transport, Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// Latest returns the latest release for the system of the agent.
+func (u *Upgrader) Latest() (*Release, error) {
+	query := url.Values{
+		"os":      []string{runtime.GOOS},
+		"arch":    []string{runtime.GOARCH},
+		"current": []string{config.VERSION},
+	}
+	sep := "?"
+	if strings.Contains(u.endpoint, "?") {
+		sep = "&"
+	}
+	resp, err := u.get(u.endpoint + sep + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var r Release
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&r); err != nil {
+		return nil, fmt.Errorf("invalid release: %s", err)
+	}
+	if r.Version == "" || r.URL == "" {
+		return nil, fmt.Errorf("invalid release: missing version or url")
+	}
+	return &r, nil
+}
+
+func (u *Upgrader) get(rawurl string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("Cloudinsight Agent/%s", config.VERSION))
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: received bad status code, %d", rawurl, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// Install downloads the binary of r next to executable, verifies it and
+// swaps it with executable. The previous binary is kept as executable.old.
+func (u *Upgrader) Install(r *Release, executable string) error {
+	if !u.Force && CompareVersions(r.Version, config.VERSION) <= 0 {
+		return fmt.Errorf("the release %s isn't newer than the running version %s, force the upgrade to install it",
+			r.Version, config.VERSION)
+	}
+
+	digest, err := hex.DecodeString(r.SHA256)
+	if err != nil || len(digest) != sha256.Size {
+		return fmt.Errorf("invalid release: bad sha256 %q", r.SHA256)
+	}
+	signature, err := base64.StdEncoding.DecodeString(r.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid release: bad signature: %s", err)
+	}
+	if !ed25519.Verify(u.publicKey, SignedMessage(r.Version, runtime.GOOS, runtime.GOARCH, digest), signature) {
+		return fmt.Errorf("the signature of the release %s is invalid", r.Version)
+	}
+
+	base, err := url.Parse(u.endpoint)
+	if err != nil {
+		return err
+	}
+	ref, err := url.Parse(r.URL)
+	if err != nil {
+		return fmt.Errorf("invalid release: bad url: %s", err)
+	}
+	resp, err := u.get(base.ResolveReference(ref).String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// In the same directory, so that the rename is atomic.
+	tmp, err := ioutil.TempFile(filepath.Dir(executable), ".cloudinsight-agent-upgrade-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(resp.Body, MaxBinarySize+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download the release %s: %s", r.Version, err)
+	}
+	if n > MaxBinarySize {
+		return fmt.Errorf("the binary of the release %s is larger than %d MB", r.Version, MaxBinarySize>>20)
+	}
+	if !bytes.Equal(h.Sum(nil), digest) {
+		return fmt.Errorf("the binary of the release %s doesn't match its sha256", r.Version)
+	}
+
+	if err = os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	return swap(tmp.Name(), executable)
+}
+
+// CompareVersions compares the versions a and b like semantic versions, it
+// returns -1 when a is older than b, 1 when it's newer and 0 when they're
+// equal. A leading v and the build metadata are ignored, and a pre-release,
+// like 1.2.0-rc.1, is older than its release.
+func CompareVersions(a, b string) int {
+	a, aPre := splitVersion(a)
+	b, bPre := splitVersion(b)
+	if c := compareFields(strings.Split(a, "."), strings.Split(b, ".")); c != 0 {
+		return c
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	}
+	return compareFields(strings.Split(aPre, "."), strings.Split(bPre, "."))
+}
+
+// splitVersion returns the version without its leading v and its build
+// metadata, and its pre-release.
+func splitVersion(v string) (version, pre string) {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.Index(v, "+"); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.Index(v, "-"); i >= 0 {
+		return v[:i], v[i+1:]
+	}
+	return v, ""
+}
+
+// compareFields compares the dot separated fields of two versions, the
+// numeric fields numerically and the others lexically. A missing field is
+// 0.
+func compareFields(a, b []string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		x, y := "0", "0"
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		xn, xErr := strconv.ParseUint(x, 10, 64)
+		yn, yErr := strconv.ParseUint(y, 10, 64)
+		switch {
+		case xErr == nil && yErr == nil:
+			if xn != yn {
+				if xn < yn {
+					return -1
+				}
+				return 1
+			}
+		case xErr == nil:
+			// The numeric fields are older than the alphanumeric ones.
+			return -1
+		case yErr == nil:
+			return 1
+		case x != y:
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// swap replaces executable with path, the running executable can't be
+// replaced on Windows but it may be renamed.
+func swap(path, executable string) error {
+	backup := executable + ".old"
+	_ = os.Remove(backup)
+	if runtime.GOOS == "windows" {
+		if err := os.Rename(executable, backup); err != nil {
+			return err
+		}
+		if err := os.Rename(path, executable); err != nil {
+			_ = os.Rename(backup, executable)
+			return err
+		}
+		return nil
+	}
+
+	if err := os.Link(executable, backup); err != nil {
+		return fmt.Errorf("failed to back up %s: %s", executable, err)
+	}
+	return os.Rename(path, executable)
+}
+
+// Restart restarts the service of the agent with its service manager, it
+// returns false when the agent isn't managed by systemd or the Windows
+// service manager.
+func Restart(service string) (bool, error) {
+	switch {
+	case runtime.GOOS == "windows":
+		// It fails when the service isn't running.
+		_, _ = util.RunCommand(time.Minute, "net.exe", "stop", service)
+		_, err := util.RunCommand(time.Minute, "net.exe", "start", service)
+		return true, err
+	case isSystemd():
+		_, err := util.RunCommand(time.Minute, "systemctl", "restart", service)
+		return true, err
+	}
+	return false, nil
+}
+
+// isSystemd tells whether the host is booted with systemd, see
+// sd_booted(3).
+func isSystemd() bool {
+	info, err := os.Stat("/run/systemd/system")
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,183 @@
+package upgrade
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/cloudinsight/cloudinsight-agent/common/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpgrade(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	binary := []byte("#!/bin/sh\necho 0.0.2\n")
+	digest := sha256.Sum256(binary)
+	release := Release{
+		Version:   "0.0.2",
+		URL:       "binaries/cloudinsight-agent",
+		SHA256:    hex.EncodeToString(digest[:]),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(priv, SignedMessage("0.0.2", runtime.GOOS, runtime.GOARCH, digest[:]))),
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/releases/latest":
+			assert.Equal(t, runtime.GOOS, r.URL.Query().Get("os"))
+			assert.Equal(t, config.VERSION, r.URL.Query().Get("current"))
+			_ = json.NewEncoder(w).Encode(release)
+		case "/releases/binaries/cloudinsight-agent":
+			_, _ = w.Write(binary)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	conf := &config.Config{GlobalConfig: config.GlobalConfig{
+		UpgradeURL:       ts.URL + "/releases/latest",
+		UpgradePublicKey: base64.StdEncoding.EncodeToString(pub),
+	}}
+	u, err := New(conf)
+	require.NoError(t, err)
+
+	r, err := u.Latest()
+	require.NoError(t, err)
+	assert.Equal(t, release, *r)
+
+	dir, err := ioutil.TempDir("", "upgrade")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	executable := filepath.Join(dir, "cloudinsight-agent")
+	require.NoError(t, ioutil.WriteFile(executable, []byte("old"), 0755))
+
+	// A binary signed with another key is rejected.
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	forged := *r
+	forged.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(other, SignedMessage("0.0.2", runtime.GOOS, runtime.GOARCH, digest[:])))
+	assert.EqualError(t, u.Install(&forged, executable), "the signature of the release 0.0.2 is invalid")
+
+	// A signed binary can't be served as another version or for another
+	// system.
+	forged = *r
+	forged.Version = "0.0.3"
+	assert.EqualError(t, u.Install(&forged, executable), "the signature of the release 0.0.3 is invalid")
+	forged = *r
+	forged.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, SignedMessage("0.0.2", "plan9", runtime.GOARCH, digest[:])))
+	assert.EqualError(t, u.Install(&forged, executable), "the signature of the release 0.0.2 is invalid")
+	forged.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, digest[:]))
+	assert.EqualError(t, u.Install(&forged, executable), "the signature of the release 0.0.2 is invalid")
+
+	// As well as a binary which doesn't match the signed digest.
+	binary = []byte("tampered")
+	assert.EqualError(t, u.Install(r, executable), "the binary of the release 0.0.2 doesn't match its sha256")
+	content, err := ioutil.ReadFile(executable)
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(content))
+
+	binary = []byte("#!/bin/sh\necho 0.0.2\n")
+	require.NoError(t, u.Install(r, executable))
+	content, err = ioutil.ReadFile(executable)
+	require.NoError(t, err)
+	assert.Equal(t, binary, content)
+	content, err = ioutil.ReadFile(executable + ".old")
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(content))
+
+	files, err := filepath.Glob(filepath.Join(dir, ".cloudinsight-agent-upgrade-*"))
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestUpgradeNotNewer(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	binary := []byte("#!/bin/sh\necho 0.0.0\n")
+	digest := sha256.Sum256(binary)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(binary)
+	}))
+	defer ts.Close()
+
+	conf := &config.Config{GlobalConfig: config.GlobalConfig{
+		UpgradeURL:       ts.URL + "/releases/latest",
+		UpgradePublicKey: base64.StdEncoding.EncodeToString(pub),
+	}}
+	u, err := New(conf)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "upgrade")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	executable := filepath.Join(dir, "cloudinsight-agent")
+	require.NoError(t, ioutil.WriteFile(executable, []byte("old"), 0755))
+
+	for _, version := range []string{"0.0.0", config.VERSION} {
+		r := &Release{
+			Version:   version,
+			URL:       ts.URL + "/binaries/cloudinsight-agent",
+			SHA256:    hex.EncodeToString(digest[:]),
+			Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(priv, SignedMessage(version, runtime.GOOS, runtime.GOARCH, digest[:]))),
+		}
+		u.Force = false
+		assert.EqualError(t, u.Install(r, executable),
+			"the release "+version+" isn't newer than the running version "+config.VERSION+", force the upgrade to install it")
+		content, err := ioutil.ReadFile(executable)
+		require.NoError(t, err)
+		assert.Equal(t, "old", string(content))
+
+		u.Force = true
+		require.NoError(t, u.Install(r, executable))
+		content, err = ioutil.ReadFile(executable)
+		require.NoError(t, err)
+		assert.Equal(t, binary, content)
+		require.NoError(t, ioutil.WriteFile(executable, []byte("old"), 0755))
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"0.0.1", "0.0.1", 0},
+		{"v0.0.1", "0.0.1", 0},
+		{"0.0.2", "0.0.1", 1},
+		{"0.0.1", "0.0.2", -1},
+		{"0.10.0", "0.9.0", 1},
+		{"1.0", "1.0.0", 0},
+		{"1.0.1", "1.0", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc.1", 1},
+		{"1.0.0-rc.2", "1.0.0-rc.10", -1},
+		{"1.0.0-alpha", "1.0.0-beta", -1},
+		{"1.0.0-rc.1", "1.0.0-rc.1", 0},
+		{"1.0.0-1", "1.0.0-alpha", -1},
+		{"1.0.0+build.2", "1.0.0+build.1", 0},
+		{"0.9.9", "1.0.0-rc.1", -1},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, CompareVersions(test.a, test.b), "%s <=> %s", test.a, test.b)
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(&config.Config{})
+	assert.Error(t, err)
+	_, err = New(&config.Config{GlobalConfig: config.GlobalConfig{UpgradeURL: "https://example.com/latest", UpgradePublicKey: "c2hvcnQ="}})
+	assert.Error(t, err)
+}